@@ -0,0 +1,694 @@
+/*
+Copyright © 2025 hey-granth
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/abuse"
+	"github.com/hey-granth/filegoblin/internal/auth"
+	"github.com/hey-granth/filegoblin/internal/client"
+	"github.com/hey-granth/filegoblin/internal/config"
+	"github.com/hey-granth/filegoblin/internal/logx"
+	"github.com/hey-granth/filegoblin/internal/metadata"
+	"github.com/hey-granth/filegoblin/internal/signing"
+	"github.com/hey-granth/filegoblin/internal/webhook"
+	"github.com/spf13/cobra"
+)
+
+var (
+	adminOffline    bool
+	adminQuota      string
+	adminKeyLabel   string
+	adminReportAll  bool
+	adminReportNote string
+	adminLogsLevel  string
+	adminLogsLimit  int
+)
+
+// adminBackend is what the admin subcommands need. *client.Client implements it against a
+// running server; offlineAdmin implements it against the data directory for break-glass use.
+type adminBackend interface {
+	Users(ctx context.Context) ([]client.UserInfo, error)
+	AddUser(ctx context.Context, name string, quota int64) (client.UserInfo, error)
+	RemoveUser(ctx context.Context, name string) error
+	SetQuota(ctx context.Context, name string, quota int64) (client.UserInfo, error)
+	Keys(ctx context.Context, user string) ([]auth.Key, error)
+	CreateKey(ctx context.Context, user, label string) (auth.NewKey, error)
+	RevokeKey(ctx context.Context, id string) error
+	SigningKeys(ctx context.Context) ([]signing.Key, error)
+	RotateSigningKey(ctx context.Context) (signing.Key, error)
+	Reports(ctx context.Context, all bool) ([]abuse.Report, error)
+	Takedown(ctx context.Context, id, note string) (abuse.Report, error)
+	DismissReport(ctx context.Context, id, note string) (abuse.Report, error)
+	Stats(ctx context.Context) (client.Stats, error)
+	WebhookDeliveries(ctx context.Context) ([]webhook.Delivery, error)
+	TestWebhooks(ctx context.Context, url string) ([]webhook.Delivery, error)
+	Logs(ctx context.Context, level string, limit int) ([]logx.Line, error)
+}
+
+// offlineAdmin edits the registry file directly. The server only notices on its next reload
+// (SIGHUP or POST /admin/reload), so this is meant for when the server is down.
+type offlineAdmin struct {
+	cfg     *config.Config
+	users   *auth.Registry
+	index   *metadata.Index
+	links   *signing.Keyring
+	reports *abuse.Store
+}
+
+func (o *offlineAdmin) info(u auth.User) client.UserInfo {
+	var used int64
+	for _, f := range o.index.List() {
+		if f.Owner == u.Name {
+			used += f.StoredSize()
+		}
+	}
+	return client.UserInfo{User: u, Used: used}
+}
+
+func (o *offlineAdmin) Users(context.Context) ([]client.UserInfo, error) {
+	var out []client.UserInfo
+	for _, u := range o.users.Users() {
+		out = append(out, o.info(u))
+	}
+	return out, nil
+}
+
+func (o *offlineAdmin) AddUser(_ context.Context, name string, quota int64) (client.UserInfo, error) {
+	u, err := o.users.AddUser(name, quota)
+	return o.info(u), err
+}
+
+func (o *offlineAdmin) RemoveUser(_ context.Context, name string) error {
+	return o.users.RemoveUser(name)
+}
+
+func (o *offlineAdmin) SetQuota(_ context.Context, name string, quota int64) (client.UserInfo, error) {
+	if err := o.users.SetQuota(name, quota); err != nil {
+		return client.UserInfo{}, err
+	}
+	u, err := o.users.User(name)
+	return o.info(u), err
+}
+
+func (o *offlineAdmin) Keys(_ context.Context, user string) ([]auth.Key, error) {
+	return o.users.Keys(user)
+}
+
+func (o *offlineAdmin) CreateKey(_ context.Context, user, label string) (auth.NewKey, error) {
+	return o.users.CreateKey(user, label)
+}
+
+func (o *offlineAdmin) RevokeKey(_ context.Context, id string) error {
+	return o.users.RevokeKey(id)
+}
+
+func (o *offlineAdmin) SigningKeys(context.Context) ([]signing.Key, error) {
+	return o.links.Keys(), nil
+}
+
+func (o *offlineAdmin) RotateSigningKey(context.Context) (signing.Key, error) {
+	return o.links.Rotate(time.Now())
+}
+
+func (o *offlineAdmin) Reports(_ context.Context, all bool) ([]abuse.Report, error) {
+	if all {
+		return o.reports.List(""), nil
+	}
+	return o.reports.List(abuse.StatusOpen), nil
+}
+
+func (o *offlineAdmin) Takedown(_ context.Context, id, note string) (abuse.Report, error) {
+	return o.reports.Takedown(o.index, id, note)
+}
+
+func (o *offlineAdmin) DismissReport(_ context.Context, id, note string) (abuse.Report, error) {
+	return o.reports.Resolve(id, abuse.StatusDismissed, note)
+}
+
+// Stats covers what the data directory knows. Transfers, errors and sweeps only exist in a
+// running server, so those stay empty.
+func (o *offlineAdmin) Stats(context.Context) (client.Stats, error) {
+	files := o.index.List()
+	st := client.Stats{
+		Files:        len(files),
+		Users:        len(o.users.Users()),
+		OpenReports:  len(o.reports.List(abuse.StatusOpen)),
+		TopConsumers: metadata.TopOwners(files, 10),
+		Transfers:    []client.Transfer{},
+		RecentErrors: []logx.Entry{},
+	}
+	for _, f := range files {
+		st.Bytes += f.StoredSize()
+	}
+	return st, nil
+}
+
+// WebhookDeliveries has nothing to show: only a running server keeps the delivery log.
+func (o *offlineAdmin) WebhookDeliveries(context.Context) ([]webhook.Delivery, error) {
+	return nil, errors.New("the webhook delivery log is kept by the running server; leave out --offline")
+}
+
+// Logs has nothing to show either: recent messages are kept in the server's memory.
+func (o *offlineAdmin) Logs(context.Context, string, int) ([]logx.Line, error) {
+	return nil, errors.New("recent log messages are kept by the running server; leave out --offline")
+}
+
+// TestWebhooks sends the test event from here, with the webhooks in the config file.
+func (o *offlineAdmin) TestWebhooks(_ context.Context, url string) ([]webhook.Delivery, error) {
+	d := webhook.New(nil)
+	defer d.Close()
+	wc := o.cfg.Webhooks
+	ev := webhook.NewEvent("test", map[string]string{"message": "test event from filegoblin"})
+	var out []webhook.Delivery
+	for _, h := range wc.Hooks {
+		if url == "" || h.URL == url {
+			out = append(out, d.Deliver(webhook.Target{URL: h.URL, Secret: h.Secret}, wc.Timeout, ev))
+		}
+	}
+	if len(out) == 0 {
+		return nil, errors.New("no such webhook configured")
+	}
+	return out, nil
+}
+
+// adminTarget picks the online or offline backend based on --offline.
+func adminTarget() (adminBackend, error) {
+	if !adminOffline {
+		return newClient()
+	}
+	cfg, err := config.Load(cfgFile)
+	if err != nil {
+		return nil, err
+	}
+	// the metadata database's password may be a reference
+	if _, _, err := resolveSecrets(cfg); err != nil {
+		return nil, err
+	}
+	index, err := cfg.Metadata.Open(cfg.DataDir)
+	if err != nil {
+		return nil, err
+	}
+	users, err := cfg.Metadata.OpenUsers(cfg.DataDir, index)
+	if err != nil {
+		return nil, err
+	}
+	links, err := signing.Open(filepath.Join(cfg.DataDir, "signing.json"))
+	if err != nil {
+		return nil, err
+	}
+	reports, err := abuse.Open(filepath.Join(cfg.DataDir, "reports.json"))
+	if err != nil {
+		return nil, err
+	}
+	return &offlineAdmin{cfg: cfg, users: users, index: index, links: links, reports: reports}, nil
+}
+
+// adminRun adapts an admin action to cobra's RunE, resolving the backend first.
+func adminRun(fn func(cmd *cobra.Command, args []string, b adminBackend) error) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		b, err := adminTarget()
+		if err != nil {
+			return err
+		}
+		return fn(cmd, args, b)
+	}
+}
+
+// parseQuota accepts sizes like "10GB"; "0" or "unlimited" removes the quota.
+func parseQuota(s string) (int64, error) {
+	if s == "" || s == "unlimited" {
+		return 0, nil
+	}
+	n, err := config.ParseByteSize(s)
+	return int64(n), err
+}
+
+func quotaString(n int64) string {
+	if n == 0 {
+		return "unlimited"
+	}
+	return config.ByteSize(n).String()
+}
+
+var adminCmd = &cobra.Command{
+	Use:   "admin",
+	Short: "Manage users, API keys and quotas",
+	Long: `admin talks to a running server's admin API using an admin key (auth.admin_keys).
+
+With --offline it edits the data directory from --config directly instead, for bootstrapping
+a fresh install or when the server is down. A running server picks offline edits up on its
+next reload.`,
+	Example: `  filegoblin admin --server https://files.example.com user add alice --quota 10GB
+  filegoblin admin key create alice --label laptop
+  filegoblin admin --offline --config /etc/filegoblin/config.yaml user add root`,
+}
+
+var adminUserCmd = &cobra.Command{Use: "user", Short: "Add, remove and list users"}
+
+var adminUserAddCmd = &cobra.Command{
+	Use:   "add <name>",
+	Short: "Create a user",
+	Args:  cobra.ExactArgs(1),
+	RunE: adminRun(func(cmd *cobra.Command, args []string, b adminBackend) error {
+		quota, err := parseQuota(adminQuota)
+		if err != nil {
+			return err
+		}
+		u, err := b.AddUser(cmd.Context(), args[0], quota)
+		if err != nil {
+			return err
+		}
+		return printResult(cmd, u, func(w io.Writer) error {
+			_, err := fmt.Fprintf(w, "created user %s (quota %s)\n", u.Name, quotaString(u.Quota))
+			return err
+		})
+	}),
+}
+
+var adminUserRmCmd = &cobra.Command{
+	Use:     "rm <name>",
+	Aliases: []string{"remove"},
+	Short:   "Delete a user and revoke all of their keys",
+	Args:    cobra.ExactArgs(1),
+	RunE: adminRun(func(cmd *cobra.Command, args []string, b adminBackend) error {
+		if err := b.RemoveUser(cmd.Context(), args[0]); err != nil {
+			return err
+		}
+		return printResult(cmd, map[string]string{"removed": args[0]}, func(w io.Writer) error {
+			_, err := fmt.Fprintf(w, "removed user %s\n", args[0])
+			return err
+		})
+	}),
+}
+
+var adminUserListCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "List users with their usage and quota",
+	Args:    cobra.NoArgs,
+	RunE: adminRun(func(cmd *cobra.Command, args []string, b adminBackend) error {
+		users, err := b.Users(cmd.Context())
+		if err != nil {
+			return err
+		}
+		if users == nil {
+			users = []client.UserInfo{} // "[]" rather than "null" for scripts
+		}
+		return printResult(cmd, users, func(w io.Writer) error {
+			tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "NAME\tUSED\tQUOTA\tCREATED")
+			for _, u := range users {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", u.Name, config.ByteSize(u.Used), quotaString(u.Quota), u.CreatedAt.Format("2006-01-02"))
+			}
+			return tw.Flush()
+		})
+	}),
+}
+
+var adminKeyCmd = &cobra.Command{Use: "key", Short: "Create, revoke and list API keys"}
+
+var adminKeyCreateCmd = &cobra.Command{
+	Use:   "create <user>",
+	Short: "Issue a new API key for a user",
+	Args:  cobra.ExactArgs(1),
+	RunE: adminRun(func(cmd *cobra.Command, args []string, b adminBackend) error {
+		k, err := b.CreateKey(cmd.Context(), args[0], adminKeyLabel)
+		if err != nil {
+			return err
+		}
+		return printResult(cmd, k, func(w io.Writer) error {
+			fmt.Fprintf(w, "key %s created for %s\n", k.ID, k.User)
+			fmt.Fprintf(w, "secret: %s\n", k.Secret)
+			_, err := fmt.Fprintln(w, "store it now, it can't be shown again")
+			return err
+		})
+	}),
+}
+
+var adminKeyRevokeCmd = &cobra.Command{
+	Use:   "revoke <key-id>",
+	Short: "Revoke an API key",
+	Args:  cobra.ExactArgs(1),
+	RunE: adminRun(func(cmd *cobra.Command, args []string, b adminBackend) error {
+		if err := b.RevokeKey(cmd.Context(), args[0]); err != nil {
+			return err
+		}
+		return printResult(cmd, map[string]string{"revoked": args[0]}, func(w io.Writer) error {
+			_, err := fmt.Fprintf(w, "revoked key %s\n", args[0])
+			return err
+		})
+	}),
+}
+
+var adminKeyListCmd = &cobra.Command{
+	Use:     "list <user>",
+	Aliases: []string{"ls"},
+	Short:   "List a user's API keys",
+	Args:    cobra.ExactArgs(1),
+	RunE: adminRun(func(cmd *cobra.Command, args []string, b adminBackend) error {
+		keys, err := b.Keys(cmd.Context(), args[0])
+		if err != nil {
+			return err
+		}
+		if keys == nil {
+			keys = []auth.Key{}
+		}
+		return printResult(cmd, keys, func(w io.Writer) error {
+			tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "ID\tLABEL\tCREATED\tSTATUS")
+			for _, k := range keys {
+				status := "active"
+				if k.Revoked() {
+					status = "revoked " + k.RevokedAt.Format("2006-01-02")
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", k.ID, k.Label, k.CreatedAt.Format("2006-01-02"), status)
+			}
+			return tw.Flush()
+		})
+	}),
+}
+
+var adminQuotaCmd = &cobra.Command{Use: "quota", Short: "Manage storage quotas"}
+
+var adminQuotaSetCmd = &cobra.Command{
+	Use:     "set <user> <size>",
+	Short:   `Set a user's storage quota, e.g. "10GB" or "unlimited"`,
+	Example: `  filegoblin admin quota set alice 50GiB`,
+	Args:    cobra.ExactArgs(2),
+	RunE: adminRun(func(cmd *cobra.Command, args []string, b adminBackend) error {
+		quota, err := parseQuota(args[1])
+		if err != nil {
+			return err
+		}
+		u, err := b.SetQuota(cmd.Context(), args[0], quota)
+		if err != nil {
+			return err
+		}
+		return printResult(cmd, u, func(w io.Writer) error {
+			_, err := fmt.Fprintf(w, "quota for %s is now %s (using %s)\n", u.Name, quotaString(u.Quota), config.ByteSize(u.Used))
+			return err
+		})
+	}),
+}
+
+var adminSigningCmd = &cobra.Command{
+	Use:   "signing-key",
+	Short: "List and rotate the keys that sign share links",
+	Long: `Signed share links (links.require_signature) name the key that signed them. The server
+rotates keys every links.rotate_every; links signed with a retired key keep working for
+links.grace.`,
+}
+
+var adminSigningListCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "List link signing keys",
+	Args:    cobra.NoArgs,
+	RunE: adminRun(func(cmd *cobra.Command, args []string, b adminBackend) error {
+		keys, err := b.SigningKeys(cmd.Context())
+		if err != nil {
+			return err
+		}
+		return printResult(cmd, keys, func(w io.Writer) error {
+			tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "ID\tCREATED\tSTATUS")
+			for _, k := range keys {
+				status := "signing"
+				if k.RetiredAt != nil {
+					status = "retired " + k.RetiredAt.Format("2006-01-02 15:04")
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\n", k.ID, k.CreatedAt.Format("2006-01-02 15:04"), status)
+			}
+			return tw.Flush()
+		})
+	}),
+}
+
+var adminSigningRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Start signing links with a new key now",
+	Args:  cobra.NoArgs,
+	RunE: adminRun(func(cmd *cobra.Command, args []string, b adminBackend) error {
+		k, err := b.RotateSigningKey(cmd.Context())
+		if err != nil {
+			return err
+		}
+		return printResult(cmd, k, func(w io.Writer) error {
+			_, err := fmt.Fprintf(w, "now signing links with key %s\n", k.ID)
+			return err
+		})
+	}),
+}
+
+var adminStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show storage usage, top consumers, transfers and recent errors",
+	Long: `stats prints the same overview as the admin dashboard in the web UI (/admin/). With
+--offline only the stored data is known: there are no transfers, errors or sweeps to show.`,
+	Args: cobra.NoArgs,
+	RunE: adminRun(func(cmd *cobra.Command, args []string, b adminBackend) error {
+		st, err := b.Stats(cmd.Context())
+		if err != nil {
+			return err
+		}
+		return printResult(cmd, st, func(w io.Writer) error {
+			fmt.Fprintf(w, "%d files, %s stored, %d users, %d open abuse reports\n", st.Files, config.ByteSize(st.Bytes), st.Users, st.OpenReports)
+			if lc := st.Lifecycle; lc.LastSweep != nil {
+				fmt.Fprintf(w, "last sweep %s removed %d files (%d since start)\n", lc.LastSweep.Format("2006-01-02 15:04"), lc.LastExpired, lc.TotalExpired)
+			}
+			tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "\nOWNER\tFILES\tSTORED")
+			for _, c := range st.TopConsumers {
+				owner := c.Owner
+				if owner == "" {
+					owner = "(anonymous)"
+				}
+				fmt.Fprintf(tw, "%s\t%d\t%s\n", owner, c.Files, config.ByteSize(c.Bytes))
+			}
+			if len(st.Transfers) > 0 {
+				fmt.Fprintln(tw, "\nTRANSFER\tFILE\tFROM\tBYTES")
+				for _, t := range st.Transfers {
+					fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", t.Kind, t.File, t.Remote, config.ByteSize(t.Bytes))
+				}
+			}
+			if err := tw.Flush(); err != nil {
+				return err
+			}
+			if len(st.RecentErrors) > 0 {
+				fmt.Fprintln(w, "\nrecent errors:")
+				for _, e := range st.RecentErrors {
+					fmt.Fprintf(w, "  %s  %s\n", e.Time.Local().Format("2006-01-02 15:04:05"), e.Message)
+				}
+			}
+			return nil
+		})
+	}),
+}
+
+var adminReportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Review abuse reports and take files down",
+	Long: `Anyone with a link can report a file (filegoblin report). Taking a file down stops it
+being served, closes every open report against it, and leaves a notice for its uploader
+(filegoblin notices). The file and its blob are kept for review; the uploader can no longer
+delete it and lifecycle rules leave it alone.`,
+}
+
+var adminReportListCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "List open abuse reports",
+	Args:    cobra.NoArgs,
+	RunE: adminRun(func(cmd *cobra.Command, args []string, b adminBackend) error {
+		reports, err := b.Reports(cmd.Context(), adminReportAll)
+		if err != nil {
+			return err
+		}
+		return printResult(cmd, reports, func(w io.Writer) error {
+			tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "ID	FILE	REASON	FILED	STATUS	DETAILS")
+			for _, r := range reports {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", r.ID, r.FileID, r.Reason, r.CreatedAt.Format("2006-01-02 15:04"), r.Status, r.Details)
+			}
+			return tw.Flush()
+		})
+	}),
+}
+
+var adminReportTakedownCmd = &cobra.Command{
+	Use:   "takedown <report-id>",
+	Short: "Take the reported file down and notify its uploader",
+	Args:  cobra.ExactArgs(1),
+	RunE: adminRun(func(cmd *cobra.Command, args []string, b adminBackend) error {
+		r, err := b.Takedown(cmd.Context(), args[0], adminReportNote)
+		if err != nil && r.ID == "" {
+			return err
+		}
+		if err != nil {
+			fmt.Fprintf(cmd.ErrOrStderr(), "warning: could not notify the uploader: %v\n", err)
+		}
+		return printResult(cmd, r, func(w io.Writer) error {
+			_, err := fmt.Fprintf(w, "took down %s (report %s)\n", r.FileID, r.ID)
+			return err
+		})
+	}),
+}
+
+var adminReportDismissCmd = &cobra.Command{
+	Use:   "dismiss <report-id>",
+	Short: "Close a report without acting on it",
+	Args:  cobra.ExactArgs(1),
+	RunE: adminRun(func(cmd *cobra.Command, args []string, b adminBackend) error {
+		r, err := b.DismissReport(cmd.Context(), args[0], adminReportNote)
+		if err != nil {
+			return err
+		}
+		return printResult(cmd, r, func(w io.Writer) error {
+			_, err := fmt.Fprintf(w, "dismissed report %s\n", r.ID)
+			return err
+		})
+	}),
+}
+
+var adminWebhookCmd = &cobra.Command{
+	Use:   "webhook",
+	Short: "Test webhooks and see how deliveries went",
+	Long: `Webhooks (webhooks.hooks in the config) are told about uploads, downloads, deletions,
+expiries and refused over-quota uploads. Each event is a JSON POST signed with the hook's
+secret; failed deliveries are retried with exponential backoff.`,
+}
+
+var adminWebhookTestCmd = &cobra.Command{
+	Use:   "test [url]",
+	Short: "Send a test event to every webhook, or the one at url",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: adminRun(func(cmd *cobra.Command, args []string, b adminBackend) error {
+		url := ""
+		if len(args) == 1 {
+			url = args[0]
+		}
+		tried, err := b.TestWebhooks(cmd.Context(), url)
+		if err != nil {
+			return err
+		}
+		return printResult(cmd, tried, func(w io.Writer) error {
+			return printDeliveries(w, tried)
+		})
+	}),
+}
+
+var adminWebhookDeliveriesCmd = &cobra.Command{
+	Use:   "deliveries",
+	Short: "List the latest delivery attempts, newest first",
+	Args:  cobra.NoArgs,
+	RunE: adminRun(func(cmd *cobra.Command, args []string, b adminBackend) error {
+		log, err := b.WebhookDeliveries(cmd.Context())
+		if err != nil {
+			return err
+		}
+		return printResult(cmd, log, func(w io.Writer) error {
+			return printDeliveries(w, log)
+		})
+	}),
+}
+
+var adminLogsCmd = &cobra.Command{
+	Use:   "logs",
+	Short: "Show the server's latest log messages",
+	Long: `logs prints the messages the server keeps in memory (log.recent, the last 1000 by
+default), oldest first, redacted as they are in the log file. It needs no access to the log
+file or the machine, only an admin key.`,
+	Example: `  filegoblin admin logs --level warn
+  filegoblin admin logs -n 50 --json`,
+	Args: cobra.NoArgs,
+	RunE: adminRun(func(cmd *cobra.Command, args []string, b adminBackend) error {
+		lines, err := b.Logs(cmd.Context(), adminLogsLevel, adminLogsLimit)
+		if err != nil {
+			return err
+		}
+		return printResult(cmd, lines, func(w io.Writer) error {
+			for _, l := range lines {
+				keys := make([]string, 0, len(l.Fields))
+				for k := range l.Fields {
+					keys = append(keys, k)
+				}
+				sort.Strings(keys)
+				fmt.Fprintf(w, "%s [%s] %s", l.Time.Local().Format("2006-01-02 15:04:05"), strings.ToUpper(l.Level), l.Message)
+				for _, k := range keys {
+					fmt.Fprintf(w, " %s=%v", k, l.Fields[k])
+				}
+				if _, err := fmt.Fprintln(w); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}),
+}
+
+func printDeliveries(w io.Writer, log []webhook.Delivery) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tEVENT\tURL\tATTEMPT\tRESULT")
+	for _, d := range log {
+		result := "ok"
+		if !d.OK() {
+			result = d.Error
+			if d.RetryAt != nil {
+				result += ", retrying " + d.RetryAt.Local().Format("15:04:05")
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s %s\t%s\t%d\t%s\n", d.Time.Local().Format("2006-01-02 15:04:05"), d.Type, d.Event, d.URL, d.Attempt, result)
+	}
+	return tw.Flush()
+}
+
+func init() {
+	rootCmd.AddCommand(adminCmd)
+	addClientFlags(adminCmd)
+	adminCmd.PersistentFlags().BoolVar(&adminOffline, "offline", false, "edit the data directory from --config instead of calling the server")
+
+	adminCmd.AddCommand(adminUserCmd, adminKeyCmd, adminQuotaCmd, adminSigningCmd, adminReportCmd, adminStatsCmd, adminWebhookCmd, adminLogsCmd)
+	adminUserCmd.AddCommand(adminUserAddCmd, adminUserRmCmd, adminUserListCmd)
+	adminKeyCmd.AddCommand(adminKeyCreateCmd, adminKeyRevokeCmd, adminKeyListCmd)
+	adminQuotaCmd.AddCommand(adminQuotaSetCmd)
+	adminSigningCmd.AddCommand(adminSigningListCmd, adminSigningRotateCmd)
+	adminReportCmd.AddCommand(adminReportListCmd, adminReportTakedownCmd, adminReportDismissCmd)
+	adminWebhookCmd.AddCommand(adminWebhookTestCmd, adminWebhookDeliveriesCmd)
+
+	adminUserAddCmd.Flags().StringVar(&adminQuota, "quota", "", `storage quota such as "10GB" (default unlimited)`)
+	adminKeyCreateCmd.Flags().StringVar(&adminKeyLabel, "label", "", "note to remember what the key is for")
+	adminReportListCmd.Flags().BoolVar(&adminReportAll, "all", false, "include resolved reports")
+	adminReportTakedownCmd.Flags().StringVar(&adminReportNote, "note", "", "note for the record (shown to the uploader)")
+	adminReportDismissCmd.Flags().StringVar(&adminReportNote, "note", "", "note for the record")
+	adminLogsCmd.Flags().StringVar(&adminLogsLevel, "level", "", `leave out messages below this level, such as "warn"`)
+	adminLogsCmd.Flags().IntVarP(&adminLogsLimit, "lines", "n", 0, "show only the last n messages (default all kept)")
+}
@@ -0,0 +1,99 @@
+/*
+Copyright © 2025 hey-granth
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/hey-granth/filegoblin/internal/audit"
+	"github.com/hey-granth/filegoblin/internal/config"
+	"github.com/spf13/cobra"
+)
+
+var auditHead string
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Inspect the server's audit log",
+}
+
+var auditVerifyCmd = &cobra.Command{
+	Use:   "verify [file]",
+	Short: "Check that the audit log hasn't been edited",
+	Long: `verify walks the hash chain of the audit log (audit.log in the configured data
+directory, or the given file) and reports the first record that was changed, inserted or
+removed. It exits non-zero when the chain is broken.
+
+A chain can't tell that records were cut off its end. To catch that, keep the head hash
+verify prints somewhere the server can't write to, and pass it with --head next time: it
+must still be in the log.`,
+	Example: `  filegoblin audit verify
+  filegoblin audit verify --head 3f9a... /mnt/evidence/audit.log`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var path string
+		if len(args) == 1 {
+			path = args[0]
+		} else {
+			cfg, err := config.Load(cfgFile)
+			if err != nil {
+				return err
+			}
+			path = filepath.Join(cfg.DataDir, "audit.log")
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		res, err := audit.Verify(f, auditHead)
+		if err != nil {
+			return err
+		}
+		if err := printResult(cmd, res, func(w io.Writer) error {
+			if !res.OK {
+				if res.Line > 0 {
+					fmt.Fprintf(w, "line %d: ", res.Line)
+				}
+				fmt.Fprintf(w, "%s\n", res.Problem)
+			}
+			_, err := fmt.Fprintf(w, "%d good records, head %s\n", res.Records, res.Head)
+			return err
+		}); err != nil {
+			return err
+		}
+		if !res.OK {
+			return errors.New("audit log chain is broken")
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(auditCmd)
+	auditCmd.AddCommand(auditVerifyCmd)
+	auditVerifyCmd.Flags().StringVar(&auditHead, "head", "", "head hash from an earlier verify, to detect records removed from the end")
+}
@@ -0,0 +1,171 @@
+/*
+Copyright © 2025 hey-granth
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/backup"
+	"github.com/hey-granth/filegoblin/internal/config"
+	"github.com/spf13/cobra"
+)
+
+var (
+	backupTarget string
+	backupKeep   int
+)
+
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Write, list and restore incremental backups of the data directory",
+	Long: `Each backup run adds a generation to the target directory holding the blobs stored and
+the file records changed since the run before, plus copies of auth.json, signing.json and
+reports.json. The oldest generation is folded into the next once there are more than
+backup.keep of them. With backup.every set the server runs backups itself; these commands
+run them by hand and restore them. The target defaults to backup.target.`,
+}
+
+var backupRunCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Write a new backup generation",
+	Long: `run writes a generation with what changed since the last one, then folds away old
+generations until backup.keep (or --keep) are left. It reads the data directory without
+changing it, so it can run next to the server.`,
+	Example: `  filegoblin backup run --target /mnt/offsite/filegoblin`,
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, store, index, err := openDataDir()
+		if err != nil {
+			return err
+		}
+		target, err := backupTargetFor(cfg)
+		if err != nil {
+			return err
+		}
+		keep := cfg.Backup.Keep
+		if cmd.Flags().Changed("keep") {
+			keep = backupKeep
+		}
+		if keep < 1 {
+			return errors.New("--keep: must be at least 1")
+		}
+		info, err := backup.Run(cmd.Context(), store, index, cfg.DataDir, target, keep, time.Now())
+		if err != nil {
+			return err
+		}
+		return printResult(cmd, info, func(w io.Writer) error {
+			_, err := fmt.Fprintf(w, "wrote %s: %d records changed, %d deleted, %d new blobs (%s)\n",
+				info.Name, info.Records, info.Deleted, info.Blobs, config.ByteSize(info.Bytes))
+			return err
+		})
+	},
+}
+
+var backupListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the backup generations, oldest first",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.Load(cfgFile)
+		if err != nil {
+			return err
+		}
+		target, err := backupTargetFor(cfg)
+		if err != nil {
+			return err
+		}
+		gens, err := backup.List(target)
+		if err != nil {
+			return err
+		}
+		return printResult(cmd, gens, func(w io.Writer) error {
+			if len(gens) == 0 {
+				_, err := fmt.Fprintf(w, "no backups in %s\n", target)
+				return err
+			}
+			tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "GENERATION\tKIND\tRECORDS\tDELETED\tBLOBS\tSIZE")
+			for _, g := range gens {
+				kind := "changes"
+				if g.Full {
+					kind = "full"
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%s\n", g.Name, kind, g.Records, g.Deleted, g.Blobs, config.ByteSize(g.Bytes))
+			}
+			return tw.Flush()
+		})
+	},
+}
+
+var backupRestoreCmd = &cobra.Command{
+	Use:   "restore [generation]",
+	Short: "Restore the data directory from a backup",
+	Long: `restore fills the configured data directory with its contents as of the given
+generation, or the latest one. The data directory must not have any files yet: restore
+into a fresh one, with the server stopped, and move it into place afterwards.`,
+	Example: `  filegoblin backup restore --target /mnt/offsite/filegoblin 20240501T030000Z`,
+	Args:    cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, store, index, err := openDataDir()
+		if err != nil {
+			return err
+		}
+		target, err := backupTargetFor(cfg)
+		if err != nil {
+			return err
+		}
+		var name string
+		if len(args) == 1 {
+			name = args[0]
+		}
+		info, err := backup.Restore(cmd.Context(), target, name, store, index, cfg.DataDir)
+		if err != nil {
+			return err
+		}
+		return printResult(cmd, info, func(w io.Writer) error {
+			_, err := fmt.Fprintf(w, "restored %s into %s\n", info.Name, cfg.DataDir)
+			return err
+		})
+	},
+}
+
+// backupTargetFor returns --target, or backup.target when it isn't given.
+func backupTargetFor(cfg *config.Config) (string, error) {
+	if backupTarget != "" {
+		return backupTarget, nil
+	}
+	if cfg.Backup.Target == "" {
+		return "", errors.New("no backup target: set backup.target or pass --target")
+	}
+	return cfg.Backup.Target, nil
+}
+
+func init() {
+	rootCmd.AddCommand(backupCmd)
+	backupCmd.AddCommand(backupRunCmd, backupListCmd, backupRestoreCmd)
+	backupCmd.PersistentFlags().StringVar(&backupTarget, "target", "", "directory holding the backups, instead of backup.target")
+	backupRunCmd.Flags().IntVar(&backupKeep, "keep", 0, "generations to keep, instead of backup.keep")
+}
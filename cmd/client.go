@@ -0,0 +1,55 @@
+/*
+Copyright © 2025 hey-granth
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"errors"
+	"os"
+
+	"github.com/hey-granth/filegoblin/internal/client"
+	"github.com/spf13/cobra"
+)
+
+var (
+	clientServer string
+	clientToken  string
+)
+
+// addClientFlags gives a command the --server/--token pair every command that talks to a
+// running server needs. Both fall back to environment variables so scripts don't have to
+// put keys on the command line, where they'd end up in shell history and `ps`.
+func addClientFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringVar(&clientServer, "server", os.Getenv("FILEGOBLIN_SERVER"), "server URL (env FILEGOBLIN_SERVER)")
+	cmd.PersistentFlags().StringVar(&clientToken, "token", "", "API or admin key (env FILEGOBLIN_TOKEN)")
+}
+
+// newClient builds an API client from the --server/--token flags.
+func newClient() (*client.Client, error) {
+	if clientServer == "" {
+		return nil, errors.New("no server given: use --server or set FILEGOBLIN_SERVER")
+	}
+	token := clientToken
+	if token == "" {
+		token = os.Getenv("FILEGOBLIN_TOKEN")
+	}
+	return client.New(clientServer, token), nil
+}
@@ -0,0 +1,202 @@
+/*
+Copyright © 2025 hey-granth
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/hey-granth/filegoblin/internal/config"
+	"github.com/hey-granth/filegoblin/internal/secrets"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	configRedact bool
+	configKMS    string
+)
+
+// configCmd groups the helpers for checking a config file before it reaches a server.
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Check and inspect the server configuration",
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check the config file for unknown keys and invalid values",
+	Long: `validate loads the file given by --config exactly like "serve" would, including
+FILEGOBLIN_* environment overrides and secret references (vault:, env:, file:), and reports
+every problem it finds. It exits non-zero when the configuration is not usable, so it fits
+nicely in a deploy pipeline.`,
+	Example: `  filegoblin config validate --config /etc/filegoblin/config.yaml`,
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.Load(cfgFile)
+		if err != nil {
+			return err
+		}
+		if _, _, err := resolveSecrets(cfg); err != nil {
+			return err
+		}
+		if err := cfg.Validate(); err != nil {
+			return invalidConfigError{err}
+		}
+		return printResult(cmd, map[string]bool{"valid": true}, func(w io.Writer) error {
+			_, err := fmt.Fprintln(w, "configuration OK")
+			return err
+		})
+	},
+}
+
+var configPrintCmd = &cobra.Command{
+	Use:   "print",
+	Short: "Print the effective configuration (defaults + file + environment)",
+	Example: `  filegoblin config print --config /etc/filegoblin/config.yaml
+  filegoblin config print --redact-secrets=false`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.Load(cfgFile)
+		if err != nil {
+			return err
+		}
+		if configRedact {
+			cfg = cfg.Redact()
+		}
+		// go through YAML for the JSON form too, so both use the same keys and the same
+		// human-friendly sizes and durations
+		data, err := yaml.Marshal(cfg)
+		if err != nil {
+			return err
+		}
+		var generic map[string]interface{}
+		if err := yaml.Unmarshal(data, &generic); err != nil {
+			return err
+		}
+		return printResult(cmd, generic, func(w io.Writer) error {
+			enc := yaml.NewEncoder(w)
+			enc.SetIndent(2)
+			defer enc.Close()
+			return enc.Encode(cfg)
+		})
+	},
+}
+
+var configKeygenCmd = &cobra.Command{
+	Use:   "keygen",
+	Short: "Generate a key for encrypted config values",
+	Long: `keygen prints a new random key for "enc:" config values. Keep it out of the config
+file: put it in $FILEGOBLIN_CONFIG_KEY, or in Vault and point config_key at it.
+
+The same kind of key encrypts blobs at rest as storage.encryption_key, which can point at
+Vault, a file or the environment like any secret setting.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key := secrets.NewConfigKey()
+		return printResult(cmd, map[string]string{"key": key}, func(w io.Writer) error {
+			_, err := fmt.Fprintln(w, key)
+			return err
+		})
+	},
+}
+
+var configEncryptCmd = &cobra.Command{
+	Use:   "encrypt [value]",
+	Short: "Encrypt a secret for use in the config file",
+	Long: `encrypt turns a secret into an "enc:..." value that can go in any secret field of the
+config file (API keys, the CAPTCHA secret and so on), so the file can be committed without
+giving the secrets away. They're decrypted at startup with the config key: config_key from
+--config, or $FILEGOBLIN_CONFIG_KEY.
+
+With --kms, the value is sealed by a key management service instead, which opens it again at
+startup: --kms names the scheme and the service's key, and kms in --config, or the usual
+environment variables, say how to reach it.
+
+The value is read from standard input when not given, which keeps it out of shell history.`,
+	Example: `  export FILEGOBLIN_CONFIG_KEY=$(filegoblin config keygen)
+  filegoblin config encrypt < admin-key.txt
+  filegoblin config keygen | filegoblin config encrypt --kms awskms:alias/filegoblin
+  filegoblin config keygen | filegoblin config encrypt --kms transit:filegoblin`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.Load(cfgFile)
+		if err != nil {
+			return err
+		}
+		r, err := secrets.NewResolver(cfg.Vault)
+		if err != nil {
+			return err
+		}
+		var seal func(string) (string, error)
+		if configKMS != "" {
+			if err := r.UseKMS(cmd.Context(), cfg); err != nil {
+				return err
+			}
+			scheme, key, _ := strings.Cut(configKMS, ":")
+			kms, ok := r.Providers[scheme].(secrets.KMS)
+			if !ok || key == "" {
+				return fmt.Errorf("--kms: %q must be awskms:<key>, gcpkms:<key> or, with a vault, transit:<key>", configKMS)
+			}
+			seal = func(v string) (string, error) { return kms.Encrypt(cmd.Context(), key, v) }
+		} else {
+			sealer, err := r.Sealer(cmd.Context(), cfg)
+			if err != nil {
+				return err
+			}
+			if sealer == nil {
+				return fmt.Errorf("no config key: set config_key in --config or $%s", secrets.EnvConfigKey)
+			}
+			seal = func(v string) (string, error) { return sealer.Seal(v), nil }
+		}
+		var value string
+		if len(args) == 1 {
+			value = args[0]
+		} else {
+			data, err := io.ReadAll(cmd.InOrStdin())
+			if err != nil {
+				return err
+			}
+			value = strings.TrimRight(string(data), "\r\n")
+		}
+		if value == "" {
+			return errors.New("nothing to encrypt")
+		}
+		sealed, err := seal(value)
+		if err != nil {
+			return err
+		}
+		return printResult(cmd, map[string]string{"value": sealed}, func(w io.Writer) error {
+			_, err := fmt.Fprintln(w, sealed)
+			return err
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configValidateCmd, configPrintCmd, configKeygenCmd, configEncryptCmd)
+	configPrintCmd.Flags().BoolVar(&configRedact, "redact-secrets", true, "mask keys and other secrets in the output")
+	configEncryptCmd.Flags().StringVar(&configKMS, "kms", "", `seal with a key management service's key, like "awskms:alias/filegoblin"`)
+}
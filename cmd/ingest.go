@@ -0,0 +1,119 @@
+/*
+Copyright © 2025 hey-granth
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/hey-granth/filegoblin/internal/config"
+	"github.com/hey-granth/filegoblin/internal/ingest"
+	"github.com/hey-granth/filegoblin/internal/server"
+	"github.com/spf13/cobra"
+)
+
+var (
+	ingestOwner   string
+	ingestVerbose bool
+)
+
+var ingestCmd = &cobra.Command{
+	Use:   "ingest <dir>",
+	Short: "Import an existing directory tree into the data directory",
+	Long: `ingest walks dir and imports every file in it, for moving files off a shared drive. Each
+one gets a record like an upload's, keeping its path in the tree and its modification time.
+Files are hashed before they are stored: content that is already there, from an earlier
+upload or another file in the tree, is not stored again, and the duplicate's record shares it.
+With storage.dedup on, new content is stored under its SHA-256 like an upload's, so files
+uploaded later share it too.
+
+An interrupted run can simply be started again: files imported before, with the same path,
+size and modification time, are not read a second time. A file that changed since is imported
+again as a new file.
+
+Like fsck, ingest works on the data directory directly: run it with the server stopped.
+Imported files skip the upload checks (virus scan, DLP, type checks and plugins), so only
+import trees you trust. Problems with single files are printed as they happen; the command
+exits non-zero if any file failed.`,
+	Example: `  filegoblin ingest /srv/shared --owner alice`,
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir := args[0]
+		if fi, err := os.Stat(dir); err != nil {
+			return err
+		} else if !fi.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+		cfg, store, index, err := openDataDir()
+		if err != nil {
+			return err
+		}
+		if ingestOwner != "" {
+			users, err := cfg.Metadata.OpenUsers(cfg.DataDir, index)
+			if err != nil {
+				return err
+			}
+			if _, err := users.User(ingestOwner); err != nil {
+				return fmt.Errorf("--owner: %w", err)
+			}
+		}
+		stderr := cmd.ErrOrStderr()
+		res, err := ingest.Run(cmd.Context(), store, index, dir, ingest.Options{
+			Owner:       ingestOwner,
+			Exclude:     cfg.DataDir,
+			Dedup:       cfg.Storage.Dedup,
+			ContentType: server.DetectContentType,
+			Progress: func(it ingest.Item) {
+				switch {
+				case it.Status == ingest.Failed:
+					fmt.Fprintf(stderr, "%s: %s\n", it.Path, it.Error)
+				case ingestVerbose && it.Of != "":
+					fmt.Fprintf(stderr, "%-9s  %s  %s, sharing %s\n", it.Status, it.Path, it.ID, it.Of)
+				case ingestVerbose:
+					fmt.Fprintf(stderr, "%-9s  %s  %s\n", it.Status, it.Path, it.ID)
+				}
+			},
+		})
+		if err != nil {
+			return err
+		}
+		if err := printResult(cmd, res, func(w io.Writer) error {
+			_, err := fmt.Fprintf(w, "imported %d files (%s), %d duplicates, %d done before, %d ignored, %d failed\n",
+				res.Imported, config.ByteSize(res.Bytes), res.Duplicates, res.Done, res.Ignored, res.Failed)
+			return err
+		}); err != nil {
+			return err
+		}
+		if res.Failed > 0 {
+			return errors.New("some files could not be imported")
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(ingestCmd)
+	ingestCmd.Flags().StringVar(&ingestOwner, "owner", "", "user to record as the files' owner, counting them against their quota")
+	ingestCmd.Flags().BoolVarP(&ingestVerbose, "verbose", "v", false, "print every file as it is handled, on stderr")
+}
@@ -0,0 +1,291 @@
+/*
+Copyright © 2025 hey-granth
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/config"
+	"github.com/hey-granth/filegoblin/internal/fsck"
+	"github.com/hey-granth/filegoblin/internal/metadata"
+	"github.com/hey-granth/filegoblin/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var (
+	fsckChecksums bool
+	gcMinAge      time.Duration
+	gcDryRun      bool
+	gcExpired     bool
+	rekeyDryRun   bool
+	rekeyLimit    int
+	scrubRate     string
+)
+
+var fsckCmd = &cobra.Command{
+	Use:   "fsck",
+	Short: "Cross-check stored files against the metadata index",
+	Long: `fsck reads the data directory named in the config and reports records whose blob is
+missing, blobs whose size or checksum doesn't match their record, and orphaned blobs that no
+record points to. It changes nothing; use gc to reclaim orphans.
+
+Run it with the server stopped or idle, since uploads in progress show up as orphans. The
+command exits non-zero when it finds problems.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		_, store, index, err := openDataDir()
+		if err != nil {
+			return err
+		}
+		rep, err := fsck.Check(cmd.Context(), store, index, fsckChecksums)
+		if err != nil {
+			return err
+		}
+		if err := printResult(cmd, rep, func(w io.Writer) error {
+			tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+			for _, p := range rep.Problems {
+				fmt.Fprintf(tw, "%s\t%s\t%s\n", p.Kind, p.ID, p.Detail)
+			}
+			if err := tw.Flush(); err != nil {
+				return err
+			}
+			_, err := fmt.Fprintf(w, "%d records, %d blobs, %d problems\n", rep.Records, rep.Blobs, len(rep.Problems))
+			return err
+		}); err != nil {
+			return err
+		}
+		if !rep.OK() {
+			return errors.New("fsck found problems")
+		}
+		return nil
+	},
+}
+
+var gcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Reclaim orphaned blobs and leftover temporary files",
+	Long: `gc deletes the records of files past their expiry time or lifecycle.max_age, as the
+server's lifecycle sweep does but without sending webhooks, then blobs that no metadata record
+points to. It removes partial uploads left behind by a crash, multipart uploads to S3 that
+were never finished among them, and vacuums the metadata index. Only blobs and uploads older
+than --min-age are touched, so an upload that is still in flight is never mistaken for
+garbage. Each thing removed is listed as it goes; --dry-run lists what would be.
+
+Records whose blob has gone missing are reported by fsck but never removed automatically.
+The server can collect orphans on its own too: see lifecycle.gc_interval.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, store, index, err := openDataDir()
+		if err != nil {
+			return err
+		}
+		res, err := fsck.Collect(cmd.Context(), store, index, fsck.GCOptions{MinAge: gcMinAge, DryRun: gcDryRun,
+			Expire: gcExpired, MaxAge: cfg.Lifecycle.MaxAge, Progress: func(kind, id string) {
+				if !jsonOutput() {
+					fmt.Fprintf(cmd.OutOrStdout(), "%s %s\n", kind, id)
+				}
+			}})
+		if err != nil {
+			return err
+		}
+		return printResult(cmd, res, func(w io.Writer) error {
+			verb := "removed"
+			if res.DryRun {
+				verb = "would remove"
+			}
+			_, err := fmt.Fprintf(w, "%s %d expired records, %d orphaned blobs (%s), %d temporary files, %d stale index files\n",
+				verb, res.Expired, res.Orphans, config.ByteSize(res.OrphanBytes), res.TempFiles, res.Vacuumed)
+			return err
+		})
+	},
+}
+
+var rekeyCmd = &cobra.Command{
+	Use:   "rekey",
+	Short: "Encrypt blobs at rest with the current storage encryption key",
+	Long: `rekey seals every blob that's encrypted with one of storage.old_encryption_keys, or
+stored before encryption at rest was turned on, with storage.encryption_key, and reports how
+many it did and how many already were. Once none are left, the old keys can go.
+
+A sealed blob keeps its own data key: rekey only wraps that key again and copies the
+encrypted content across as it is. Blobs stored in the clear, or sealed in the older "FGS1"
+format, are encrypted again whole. Blobs are written back one at a time, so rekey can run
+while the server does, and can be stopped and run again: it picks up where it left off. --limit does a batch at a
+time; --dry-run only counts.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		_, store, _, err := openDataDir()
+		if err != nil {
+			return err
+		}
+		verb := "rekeyed"
+		if rekeyDryRun {
+			verb = "to rekey"
+		}
+		res, err := storage.RekeyAll(cmd.Context(), store, rekeyDryRun, rekeyLimit, func(key string, err error) {
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "%s: %v\n", key, err)
+			} else if !jsonOutput() {
+				fmt.Fprintf(cmd.OutOrStdout(), "%s %s\n", verb, key)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		if err := printResult(cmd, res, func(w io.Writer) error {
+			_, err := fmt.Fprintf(w, "%d blobs %s, %d left for later, %d current already, %d failed\n",
+				res.Rekeyed, verb, res.Pending, res.Current, res.Failed)
+			return err
+		}); err != nil {
+			return err
+		}
+		if res.Failed > 0 {
+			return errors.New("some blobs could not be rekeyed")
+		}
+		return nil
+	},
+}
+
+var rebuildCmd = &cobra.Command{
+	Use:   "rebuild",
+	Short: "Rewrite missing and damaged shards of erasure-coded blobs",
+	Long: `rebuild reads the shards of every blob the erasure backend keeps, and writes those that
+are missing, damaged or left over from an older write again from the rest: after a disk was
+replaced, say, or was down while blobs were stored. Until it has run, blobs are read around
+what's missing, but can stand to lose fewer disks.
+
+It reports how many blobs it checked and rebuilt, and how many have too few shards left to
+recover at all. It can run while the server does.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		_, store, _, err := openDataDir()
+		if err != nil {
+			return err
+		}
+		ec, ok := storage.Find[*storage.Erasure](store)
+		if !ok {
+			return errors.New("storage.backend isn't erasure")
+		}
+		res, err := ec.Rebuild(cmd.Context(), func(key string, err error) {
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "%s: %v\n", key, err)
+			} else if !jsonOutput() {
+				fmt.Fprintf(cmd.OutOrStdout(), "rebuilt %s\n", key)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		if err := printResult(cmd, res, func(w io.Writer) error {
+			_, err := fmt.Fprintf(w, "%d blobs checked, %d rebuilt, %d lost\n", res.Checked, res.Rebuilt, res.Lost)
+			return err
+		}); err != nil {
+			return err
+		}
+		if res.Lost > 0 {
+			return errors.New("some blobs could not be recovered")
+		}
+		return nil
+	},
+}
+
+var scrubCmd = &cobra.Command{
+	Use:   "scrub",
+	Short: "Read back every blob and repair corrupt ones from replicas",
+	Long: `scrub reads every stored blob that has a recorded checksum and hashes it, to find
+corruption before a download does. With storage replication, each replica's copy is read, and
+bad copies are replaced with one that matches. Each problem is listed as it's found.
+
+Unlike fsck, scrub can run while the server does, and --rate keeps it from taking all of the
+disk or network. The server can scrub on its own too: see integrity.scrub_interval. The
+command exits non-zero when it finds a problem it couldn't repair.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		rate, err := config.ParseByteSize(scrubRate)
+		if err != nil {
+			return fmt.Errorf("--rate: %w", err)
+		}
+		_, store, index, err := openDataDir()
+		if err != nil {
+			return err
+		}
+		res, err := fsck.Scrub(cmd.Context(), store, index, fsck.ScrubOptions{Rate: int64(rate),
+			Progress: func(p fsck.Problem, repaired bool) {
+				if repaired {
+					fmt.Fprintf(cmd.ErrOrStderr(), "%s: repaired: %s\n", p.ID, p.Detail)
+				} else {
+					fmt.Fprintf(cmd.ErrOrStderr(), "%s: %s: %s\n", p.ID, p.Kind, p.Detail)
+				}
+			}})
+		if err != nil {
+			return err
+		}
+		if err := printResult(cmd, res, func(w io.Writer) error {
+			_, err := fmt.Fprintf(w, "%d blobs checked (%s), %d corrupt, %d repaired, %d missing\n",
+				res.Checked, config.ByteSize(res.Bytes), res.Corrupt, res.Repaired, res.Missing)
+			return err
+		}); err != nil {
+			return err
+		}
+		if res.Corrupt > res.Repaired || res.Missing > 0 {
+			return errors.New("scrub found problems")
+		}
+		return nil
+	},
+}
+
+// openDataDir opens the blob store and metadata index of the configured data directory, and
+// returns the configuration naming it.
+func openDataDir() (*config.Config, storage.Backend, *metadata.Index, error) {
+	cfg, err := config.Load(cfgFile)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	// the storage credentials and encryption key may be references
+	if _, _, err := resolveSecrets(cfg); err != nil {
+		return nil, nil, nil, err
+	}
+	store, err := cfg.Storage.Open(cfg.DataDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	index, err := cfg.Metadata.Open(cfg.DataDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return cfg, store, index, nil
+}
+
+func init() {
+	rootCmd.AddCommand(fsckCmd, gcCmd, rekeyCmd, rebuildCmd, scrubCmd)
+	fsckCmd.Flags().BoolVar(&fsckChecksums, "checksums", true, "hash every blob and compare with its recorded SHA-256 (slow on large stores)")
+	gcCmd.Flags().DurationVar(&gcMinAge, "min-age", time.Hour, "leave orphans and temporary files younger than this alone")
+	gcCmd.Flags().BoolVar(&gcDryRun, "dry-run", false, "report what would be removed without removing it")
+	gcCmd.Flags().BoolVar(&gcExpired, "expired", true, "remove expired files' records, and their blobs")
+	rekeyCmd.Flags().BoolVar(&rekeyDryRun, "dry-run", false, "count the blobs to rekey without rekeying them")
+	rekeyCmd.Flags().IntVar(&rekeyLimit, "limit", 0, "rekey at most this many blobs, 0 for all")
+	scrubCmd.Flags().StringVar(&scrubRate, "rate", "0", "read at most this much a second, like 50MB; 0 for no limit")
+}
@@ -0,0 +1,209 @@
+/*
+Copyright © 2025 hey-granth
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/hey-granth/filegoblin/internal/config"
+	"github.com/hey-granth/filegoblin/internal/metadata"
+	"github.com/spf13/cobra"
+)
+
+var migrateTo int
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Show and change the schema version of the metadata database",
+	Long: `The sqlite and postgres metadata backends keep their records in tables whose layout,
+the schema, changes with releases. Each change is a numbered migration, and the schema's
+version is the number of the last one applied. Servers migrate the schema up when they
+start, unless metadata.migrate is "manual"; these commands do it by hand, and take it back
+down before a downgrade. Migrations wait for one another, so two can't run at once.`,
+}
+
+// migrationResult is what up and down report: the versions the schema went from and to, and
+// the migrations applied or undone on the way.
+type migrationResult struct {
+	From       int                  `json:"from"`
+	To         int                  `json:"to"`
+	Migrations []metadata.Migration `json:"migrations"`
+}
+
+var migrateStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "List the migrations and which are applied",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		st, err := openMigrator()
+		if err != nil {
+			return err
+		}
+		defer st.(metadata.Store).Close()
+		v, err := st.Version()
+		if err != nil {
+			return err
+		}
+		type status struct {
+			metadata.Migration
+			Applied bool `json:"applied"`
+		}
+		res := struct {
+			Version    int      `json:"version"`
+			Latest     int      `json:"latest"`
+			Migrations []status `json:"migrations"`
+		}{Version: v, Latest: metadata.Latest(st), Migrations: []status{}}
+		for _, m := range st.Migrations() {
+			res.Migrations = append(res.Migrations, status{m, m.Version <= v})
+		}
+		return printResult(cmd, res, func(w io.Writer) error {
+			tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+			for _, m := range res.Migrations {
+				applied := "no"
+				if m.Applied {
+					applied = "yes"
+				}
+				fmt.Fprintf(tw, "%d\t%s\t%s\n", m.Version, m.Name, applied)
+			}
+			if err := tw.Flush(); err != nil {
+				return err
+			}
+			if v > res.Latest {
+				_, err = fmt.Fprintf(w, "the schema is at version %d, newer than this filegoblin knows\n", v)
+				return err
+			}
+			_, err = fmt.Fprintf(w, "the schema is at version %d of %d\n", v, res.Latest)
+			return err
+		})
+	},
+}
+
+var migrateUpCmd = &cobra.Command{
+	Use:   "up",
+	Short: "Apply the migrations not applied yet",
+	Long: `up applies every migration the schema doesn't have yet, or those up to --to. Servers
+still running an older release may not work with the new schema, so stop or upgrade them
+first.`,
+	Example: `  filegoblin migrate up --config /etc/filegoblin/config.yaml`,
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runMigrate(cmd, func(st metadata.Migrator, v int) (int, error) {
+			if !cmd.Flags().Changed("to") {
+				return metadata.Latest(st), nil
+			}
+			if migrateTo < v {
+				return 0, fmt.Errorf("--to: the schema is at version %d already; see migrate down", v)
+			}
+			return migrateTo, nil
+		})
+	},
+}
+
+var migrateDownCmd = &cobra.Command{
+	Use:   "down",
+	Short: "Undo the migrations after a version, to run an older release",
+	Long: `down undoes migrations, newest first, until the schema is at version --to, the latest
+an older release knows. Whatever the undone migrations added is lost with them, records
+included when going down to 0. Stop the servers first.`,
+	Example: `  filegoblin migrate down --to 1`,
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runMigrate(cmd, func(st metadata.Migrator, v int) (int, error) {
+			if !cmd.Flags().Changed("to") {
+				return 0, errors.New("--to: say which version to go down to")
+			}
+			if migrateTo > v {
+				return 0, fmt.Errorf("--to: the schema is at version %d already; see migrate up", v)
+			}
+			return migrateTo, nil
+		})
+	},
+}
+
+// runMigrate takes the schema to the version target picks, given the one it's at.
+func runMigrate(cmd *cobra.Command, target func(st metadata.Migrator, v int) (int, error)) error {
+	st, err := openMigrator()
+	if err != nil {
+		return err
+	}
+	defer st.(metadata.Store).Close()
+	v, err := st.Version()
+	if err != nil {
+		return err
+	}
+	to, err := target(st, v)
+	if err != nil {
+		return err
+	}
+	res := migrationResult{From: v, To: to, Migrations: []metadata.Migration{}}
+	err = metadata.Migrate(st, to, func(m metadata.Migration, up bool) {
+		res.Migrations = append(res.Migrations, m)
+		if !jsonOutput() {
+			verb := "applied"
+			if !up {
+				verb = "undid"
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "%s %d %s\n", verb, m.Version, m.Name)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return printResult(cmd, res, func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "the schema is at version %d\n", to)
+		return err
+	})
+}
+
+// openMigrator opens the configured metadata store, which must have a schema, without
+// migrating it.
+func openMigrator() (metadata.Migrator, error) {
+	cfg, err := config.Load(cfgFile)
+	if err != nil {
+		return nil, err
+	}
+	// the database password may be a reference
+	if _, _, err := resolveSecrets(cfg); err != nil {
+		return nil, err
+	}
+	st, err := cfg.Metadata.OpenStore(cfg.DataDir)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := st.(metadata.Migrator)
+	if !ok {
+		st.Close()
+		return nil, errors.New("the files metadata backend has no schema to migrate")
+	}
+	return m, nil
+}
+
+func init() {
+	rootCmd.AddCommand(migrateCmd)
+	migrateCmd.AddCommand(migrateStatusCmd, migrateUpCmd, migrateDownCmd)
+	migrateUpCmd.Flags().IntVar(&migrateTo, "to", 0, "stop at this version, instead of the latest")
+	migrateDownCmd.Flags().IntVar(&migrateTo, "to", 0, "the version to go down to")
+}
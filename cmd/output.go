@@ -0,0 +1,107 @@
+/*
+Copyright © 2025 hey-granth
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/hey-granth/filegoblin/internal/client"
+	"github.com/spf13/cobra"
+)
+
+// outputFormat is the global --output flag: "text" for people, "json" for scripts.
+var outputFormat string
+
+func jsonOutput() bool { return outputFormat == "json" }
+
+// checkOutputFormat rejects anything but the two supported formats before a command runs.
+func checkOutputFormat(cmd *cobra.Command, args []string) error {
+	if outputFormat != "text" && outputFormat != "json" {
+		return fmt.Errorf("--output must be \"text\" or \"json\", not %q", outputFormat)
+	}
+	return nil
+}
+
+// printResult writes a command's result: v encoded as JSON with --output json, otherwise
+// whatever text renders. Every command goes through here so the JSON mode can't be forgotten.
+func printResult(cmd *cobra.Command, v interface{}, text func(w io.Writer) error) error {
+	out := cmd.OutOrStdout()
+	if jsonOutput() {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	return text(out)
+}
+
+// errorBody is the JSON shape of a failed command, written to stderr:
+//
+//	{"error": {"code": "api_error", "message": "...", "status": 403}}
+//
+// code is one of "api_error" (the server refused; status holds the HTTP status),
+// "invalid_config" (problems lists each issue) or "error" for everything else.
+type errorBody struct {
+	Error errorDetail `json:"error"`
+}
+
+type errorDetail struct {
+	Code     string   `json:"code"`
+	Message  string   `json:"message"`
+	Status   int      `json:"status,omitempty"`
+	Problems []string `json:"problems,omitempty"`
+}
+
+// invalidConfigError marks validation failures so they get their own error code.
+type invalidConfigError struct{ err error }
+
+func (e invalidConfigError) Error() string { return "invalid configuration:\n" + e.err.Error() }
+func (e invalidConfigError) Unwrap() error { return e.err }
+
+// printError reports err on w in the selected output format.
+func printError(w io.Writer, err error) {
+	if !jsonOutput() {
+		fmt.Fprintln(w, "Error:", err)
+		return
+	}
+	d := errorDetail{Code: "error", Message: err.Error()}
+	var apiErr *client.APIError
+	var cfgErr invalidConfigError
+	switch {
+	case errors.As(err, &apiErr):
+		d.Code, d.Status, d.Message = "api_error", apiErr.Status, apiErr.Message
+	case errors.As(err, &cfgErr):
+		d.Code, d.Message = "invalid_config", "invalid configuration"
+		if joined, ok := cfgErr.err.(interface{ Unwrap() []error }); ok {
+			for _, e := range joined.Unwrap() {
+				d.Problems = append(d.Problems, e.Error())
+			}
+		} else {
+			d.Problems = []string{cfgErr.err.Error()}
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(errorBody{Error: d})
+}
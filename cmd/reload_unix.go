@@ -0,0 +1,65 @@
+//go:build !windows
+
+/*
+Copyright © 2025 hey-granth
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/hey-granth/filegoblin/internal/logx"
+	"github.com/hey-granth/filegoblin/internal/server"
+	"github.com/hey-granth/filegoblin/internal/systemd"
+)
+
+// watchReload reloads the server configuration every time the process gets SIGHUP,
+// which is what `systemctl reload` and `kill -HUP` send. SIGUSR1 turns debug logging on,
+// and off again the next time.
+func watchReload(ctx context.Context, srv *server.Server, log *logx.Logger) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+	defer signal.Stop(usr1)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-usr1:
+			lv := srv.ToggleDebug()
+			log.Warn("SIGUSR1 received, log level is now %s", strings.ToLower(lv.String()))
+		case <-hup:
+			log.Info("SIGHUP received, reloading configuration")
+			_, _ = systemd.Notify("RELOADING=1")
+			if err := srv.Reload(); err != nil {
+				log.Error("reload failed, keeping the previous configuration: %v", err)
+			}
+			_, _ = systemd.Notify("READY=1")
+		}
+	}
+}
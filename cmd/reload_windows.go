@@ -0,0 +1,61 @@
+//go:build windows
+
+/*
+Copyright © 2025 hey-granth
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"context"
+
+	"github.com/hey-granth/filegoblin/internal/logx"
+	"github.com/hey-granth/filegoblin/internal/server"
+)
+
+// serviceReload is poked when the service control manager sends the service a parameter
+// change, Windows' closest thing to SIGHUP.
+var serviceReload = make(chan struct{}, 1)
+
+// requestReload asks a running watchReload to reload; it doesn't wait, and folds requests
+// made while one is pending into it.
+func requestReload() {
+	select {
+	case serviceReload <- struct{}{}:
+	default:
+	}
+}
+
+// watchReload reloads the server configuration on `sc control filegoblin paramchange` when
+// running as a service. Windows has no SIGHUP; POST /admin/reload works everywhere.
+func watchReload(ctx context.Context, srv *server.Server, log *logx.Logger) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-serviceReload:
+			log.Info("parameter change received, reloading configuration")
+			if err := srv.Reload(); err != nil {
+				log.Error("reload failed, keeping the previous configuration: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,116 @@
+/*
+Copyright © 2025 hey-granth
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/hey-granth/filegoblin/internal/abuse"
+	"github.com/spf13/cobra"
+)
+
+var (
+	reportReason  string
+	reportDetails string
+	reportContact string
+)
+
+var reportCmd = &cobra.Command{
+	Use:   "report <id|url>",
+	Short: "Report a shared file to the server's admins",
+	Long: `report files an abuse report against a shared file. Admins review open reports with
+"filegoblin admin report list" and can take the file down, which stops it being served and
+notifies whoever uploaded it.
+
+No API key is needed: anyone holding a working link can report the file behind it.`,
+	Example: `  filegoblin report --reason malware https://files.example.com/f/t_xGli9kxcGe
+  filegoblin report --reason copyright --details "my photo, used without permission" t_xGli9kxcGe`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ref, err := fileRef(args[0])
+		if err != nil {
+			return err
+		}
+		c, err := newClient()
+		if err != nil {
+			return err
+		}
+		if err := c.SolveChallenge(cmd.Context()); err != nil {
+			return err
+		}
+		id, err := c.Report(cmd.Context(), ref.ID, ref.Query, reportReason, reportDetails, reportContact)
+		if err != nil {
+			return err
+		}
+		res := reportResult{ID: id, FileID: ref.ID}
+		return printResult(cmd, res, func(w io.Writer) error {
+			_, err := fmt.Fprintf(w, "reported %s (report %s)\n", ref.ID, id)
+			return err
+		})
+	},
+}
+
+// reportResult is the --output json shape of `report`.
+type reportResult struct {
+	ID     string `json:"id"`
+	FileID string `json:"file_id"`
+}
+
+var noticesCmd = &cobra.Command{
+	Use:   "notices",
+	Short: "List takedown notices for your files",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := newClient()
+		if err != nil {
+			return err
+		}
+		notices, err := c.Notices(cmd.Context())
+		if err != nil {
+			return err
+		}
+		return printResult(cmd, notices, func(w io.Writer) error {
+			if len(notices) == 0 {
+				_, err := fmt.Fprintln(w, "no takedown notices")
+				return err
+			}
+			tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "DATE\tFILE\tNAME\tREASON\tNOTE")
+			for _, n := range notices {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", n.CreatedAt.Format("2006-01-02 15:04"), n.FileID, n.FileName, n.Reason, n.Note)
+			}
+			return tw.Flush()
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(reportCmd, noticesCmd)
+	addClientFlags(reportCmd)
+	addClientFlags(noticesCmd)
+	reportCmd.Flags().StringVar(&reportReason, "reason", "", "why the file is being reported: "+strings.Join(abuse.Reasons, ", "))
+	reportCmd.Flags().StringVar(&reportDetails, "details", "", "anything that helps the admins decide")
+	reportCmd.Flags().StringVar(&reportContact, "contact", "", "how the admins can reach you, if you want them to")
+}
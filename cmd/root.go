@@ -23,21 +23,36 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"os"
 
+	"github.com/hey-granth/filegoblin/internal/config"
 	"github.com/spf13/cobra"
 )
 
+var cfgFile string
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "filegoblin",
 	Short: "A simple toolkit for converting office documents to PDF and merging PDFs.",
 	Long:  `filegoblin helps non-technical users handle everyday document chores without hassle. It converts DOCX, PPTX and other office files into clean PDFs, merges multiple PDFs into one, and wraps all of it in a predictable, beginner-friendly command-line workflow. Perfect for quick tasks, automated scripts, or anyone tired of wrestling with clunky online converters.`,
+	// errors are already explicit enough; dumping the full usage after them just buries the message
+	SilenceUsage: true,
+	// Execute prints errors itself so they can come out as JSON with --output json
+	SilenceErrors: true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		findConfig()
+		return checkOutputFormat(cmd, args)
+	},
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	// Run: func(cmd *cobra.Command, args []string) { },
-	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("filegoblin CLI ready")
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return printResult(cmd, map[string]string{"status": "ready"}, func(w io.Writer) error {
+			_, err := fmt.Fprintln(w, "filegoblin CLI ready")
+			return err
+		})
 	},
 }
 
@@ -46,16 +61,29 @@ var rootCmd = &cobra.Command{
 func Execute() {
 	err := rootCmd.Execute()
 	if err != nil {
+		printError(rootCmd.ErrOrStderr(), err)
 		os.Exit(1)
 	}
 }
 
+// findConfig falls back to the platform's config file when --config isn't given and that
+// file exists. A file this user can't stat counts as missing, so client commands keep
+// working on a server whose config is hidden from them.
+func findConfig() {
+	if cfgFile == "" {
+		if _, err := os.Stat(config.DefaultFile()); err == nil {
+			cfgFile = config.DefaultFile()
+		}
+	}
+}
+
 func init() {
 	// Here you will define your flags and configuration settings.
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
 
-	// rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.filegoblin.yaml)")
+	rootCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", "text", `output format: "text" or "json" (see docs/cli-json.md)`)
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (YAML); "+config.DefaultFile()+" when it exists, otherwise built-in defaults")
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
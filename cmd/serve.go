@@ -0,0 +1,486 @@
+/*
+Copyright © 2025 hey-granth
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/abuse"
+	"github.com/hey-granth/filegoblin/internal/audit"
+	"github.com/hey-granth/filegoblin/internal/config"
+	"github.com/hey-granth/filegoblin/internal/journal"
+	"github.com/hey-granth/filegoblin/internal/logx"
+	"github.com/hey-granth/filegoblin/internal/secrets"
+	"github.com/hey-granth/filegoblin/internal/server"
+	"github.com/hey-granth/filegoblin/internal/share"
+	"github.com/hey-granth/filegoblin/internal/signing"
+	"github.com/hey-granth/filegoblin/internal/storage"
+	"github.com/hey-granth/filegoblin/internal/systemd"
+	"github.com/spf13/cobra"
+)
+
+var (
+	serveListen string
+	serveOnce   string
+	serveTTL    time.Duration
+
+	serveEphemeral bool
+	// ephemeralDir is the data directory of an --ephemeral server, removed when it exits.
+	ephemeralDir string
+)
+
+// serveCmd runs filegoblin as a server, either as the long-running daemon or in one-shot share mode.
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run the filegoblin server, or share a directory for a while with --once",
+	Long: `Without flags, serve runs the long-running filegoblin daemon configured by --config.
+
+With --once <dir>, it shares that directory read-only on a random port, protected by a
+random token, and exits by itself after --ttl (one hour by default). Handy for handing
+someone on the same network a folder without setting anything up.
+
+With --ephemeral, the daemon keeps uploads in memory and the rest of its data in a temporary
+directory it removes on exit, so a demo or test instance needs no setup and forgets
+everything when it stops.`,
+	Example: `  filegoblin serve --config /etc/filegoblin.yaml
+  filegoblin serve --ephemeral --listen :8080
+  filegoblin serve --once ./photos
+  filegoblin serve --once ./build --ttl 15m`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		log := logx.New(os.Stderr)
+		if serveOnce != "" {
+			return runOnce(ctx, cmd, log)
+		}
+		return runDaemon(ctx, log)
+	},
+}
+
+// loadServeConfig reads the config file and applies command-line overrides on top. It's
+// used both at startup and on every reload, so the overrides survive a reload.
+func loadServeConfig() (*config.Config, error) {
+	cfg, err := config.Load(cfgFile)
+	if err != nil {
+		return nil, err
+	}
+	if serveListen != "" {
+		cfg.Listen = serveListen
+	}
+	if serveEphemeral {
+		cfg.Storage.Backend, cfg.DataDir = "memory", ephemeralDir
+	}
+	r, leases, err := resolveSecrets(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, invalidConfigError{err}
+	}
+	keepSecretsAlive(r, leases)
+	return cfg, nil
+}
+
+// resolveSecrets replaces secret references in cfg with their values.
+func resolveSecrets(cfg *config.Config) (*secrets.Resolver, []secrets.Lease, error) {
+	r, err := secrets.NewResolver(cfg.Vault)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	leases, err := r.Resolve(ctx, cfg)
+	return r, leases, err
+}
+
+var (
+	renewMu sync.Mutex
+	// stopRenew ends the lease renewal for the previously loaded configuration.
+	stopRenew = func() {}
+	// staleSecrets is told when a lease behind the configuration runs out; the daemon
+	// reloads, which resolves the secrets again.
+	staleSecrets = func(error) {}
+)
+
+// keepSecretsAlive renews the Vault token and the leases of a freshly loaded configuration,
+// and stops renewing those of the one before.
+func keepSecretsAlive(r *secrets.Resolver, leases []secrets.Lease) {
+	renewMu.Lock()
+	defer renewMu.Unlock()
+	stopRenew()
+	stopRenew = func() {}
+	if r.Vault == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopRenew = cancel
+	go r.Vault.KeepAlive(ctx, leases, func(err error) {
+		renewMu.Lock()
+		stale := staleSecrets
+		renewMu.Unlock()
+		stale(err)
+	})
+}
+
+func runDaemon(ctx context.Context, log *logx.Logger) error {
+	if serveEphemeral {
+		dir, err := os.MkdirTemp("", "filegoblin-ephemeral-")
+		if err != nil {
+			return err
+		}
+		ephemeralDir = dir
+		defer os.RemoveAll(dir)
+	}
+	cfg, err := loadServeConfig()
+	if err != nil {
+		return err
+	}
+	log, closeLog, err := daemonLogger(cfg.Log, log)
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+	if serveEphemeral {
+		defer log.AtExit("ephemeral data", func() error { return os.RemoveAll(ephemeralDir) })()
+		log.Info("ephemeral mode: uploads are kept in memory and everything is gone on exit")
+	}
+	store, err := cfg.Storage.Open(cfg.DataDir)
+	if err != nil {
+		return err
+	}
+	index, err := cfg.Metadata.Open(cfg.DataDir)
+	if err != nil {
+		return err
+	}
+	defer index.Close()
+	users, err := cfg.Metadata.OpenUsers(cfg.DataDir, index)
+	if err != nil {
+		return err
+	}
+	links, err := signing.Open(filepath.Join(cfg.DataDir, "signing.json"))
+	if err != nil {
+		return err
+	}
+	reports, err := abuse.Open(filepath.Join(cfg.DataDir, "reports.json"))
+	if err != nil {
+		return err
+	}
+	trail, err := audit.Open(filepath.Join(cfg.DataDir, "audit.log"))
+	if err != nil {
+		return err
+	}
+	defer trail.Close()
+	// if something calls Fatal, don't leave the audit trail unflushed or half-written blobs in tmp/
+	defer log.AtExit("audit trail", trail.Close)()
+	if disk, ok := store.(*storage.Disk); ok {
+		defer log.AtExit("partial uploads", func() error {
+			_, err := disk.CleanTemp(time.Now())
+			return err
+		})()
+	}
+	var uploads *journal.Journal
+	if cfg.Storage.Backend != "memory" {
+		// each node has its own, as only it knows which of its uploads were cut short
+		name := "uploads.journal"
+		if cfg.Cluster.Enabled() {
+			name = "uploads-" + cfg.Cluster.NodeID + ".journal"
+		}
+		if uploads, err = journal.Open(filepath.Join(cfg.DataDir, name)); err != nil {
+			return err
+		}
+		defer uploads.Close()
+	}
+	if len(cfg.Auth.Keys) == 0 && !users.HasUsers() {
+		log.Info("no API keys configured: anyone who can reach %s may upload and delete files", cfg.Listen)
+	}
+	ln, err := daemonListener(cfg.Listen, log)
+	if err != nil {
+		return err
+	}
+
+	// tell systemd (if it's watching) that we're up, keep its watchdog fed, and say goodbye on the way out
+	if _, err := systemd.Notify("READY=1\nSTATUS=serving on " + ln.Addr().String()); err != nil {
+		log.Error("sd_notify: %v", err)
+	}
+	go func() {
+		if err := systemd.Watchdog(ctx); err != nil {
+			log.Error("systemd watchdog: %v", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		_, _ = systemd.Notify("STOPPING=1")
+	}()
+	srv := server.New(cfg, store, index, users, links, reports, trail, log)
+	srv.SetReloader(loadServeConfig)
+	if uploads != nil {
+		srv.SetJournal(uploads)
+		res, err := srv.RecoverUploads(ctx)
+		if err != nil {
+			log.Error("recover interrupted uploads, %d left for the next start: %v", res.Open, err)
+		}
+		if res.Committed+res.Discarded+res.Lost > 0 {
+			log.Info("recovered interrupted uploads: %d were committed, %d discarded, %d lost their blob", res.Committed, res.Discarded, res.Lost)
+		}
+	}
+	if cfg.Log.AccessFile != "" {
+		f, err := logx.OpenFile(cfg.Log.AccessFile, logRotation(cfg.Log))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		srv.SetAccessLog(f)
+	}
+	renewMu.Lock()
+	staleSecrets = func(err error) {
+		log.Info("%v; reloading to fetch fresh secrets", err)
+		if err := srv.Reload(); err != nil {
+			log.Error("reload failed, keeping the previous configuration: %v", err)
+		}
+	}
+	renewMu.Unlock()
+	go watchReload(ctx, srv, log)
+	return srv.Serve(ctx, ln)
+}
+
+// daemonLogger returns log, or a logger writing where and in the format lc asks for instead:
+// to the file or the journal, and to syslog, Graylog, Logstash, an OpenTelemetry collector
+// and the Windows Event Log; queued if lc.Buffer is set. The function it returns writes out the queue and the
+// summaries of repeats held back, and closes them.
+func daemonLogger(lc config.Log, log *logx.Logger) (*logx.Logger, func(), error) {
+	var closers []io.Closer
+	closeLog := func() {
+		for _, c := range closers {
+			c.Close()
+		}
+	}
+	main := log.Writer()
+	switch {
+	case lc.File != "":
+		f, err := logx.OpenFile(lc.File, logRotation(lc))
+		if err != nil {
+			return nil, nil, err
+		}
+		main, closers = f, append(closers, f)
+	case lc.Journal:
+		j, err := logx.OpenJournal("filegoblin")
+		if err != nil {
+			return nil, nil, err
+		}
+		main, closers = j, append(closers, j)
+	}
+	l := log
+	if lc.File != "" || lc.Journal || lc.Format != "auto" || lc.Syslog != "" || lc.GELF != "" || lc.Logstash != "" || lc.OTLP != "" || lc.EventLog != "" || lc.Split != "" {
+		pretty := func(w io.Writer) bool {
+			return lc.Format == "pretty" || lc.Format == "auto" && logx.IsTerminal(w)
+		}
+		sinks := []logx.Sink{{Writer: main, Level: logx.LevelDebug, JSON: lc.Format == "json", Pretty: pretty(main)}}
+		if lc.Split != "" {
+			lv, _ := logx.ParseLevel(lc.Split) // checked by Validate
+			// Only as well, so what goes to the logger's Writer, like access log lines, stays
+			// off standard error
+			sinks[0].Level, sinks[0].Only = lv, func(l logx.Level) bool { return l >= lv }
+			sinks = append(sinks, logx.Sink{Writer: os.Stdout, Level: logx.LevelDebug, JSON: lc.Format == "json", Pretty: pretty(os.Stdout), Only: logx.Below(lv)})
+		}
+		if lc.Stderr != "" {
+			lv, _ := logx.ParseLevel(lc.Stderr) // checked by Validate
+			sinks = append(sinks, logx.Sink{Writer: log.Writer(), Level: lv, Pretty: lc.Format != "text" && logx.IsTerminal(log.Writer())})
+		}
+		if lc.Syslog != "" {
+			network, addr, _ := lc.SyslogAddr()
+			sl, err := logx.DialSyslog(network, addr, "filegoblin")
+			if err != nil {
+				closeLog()
+				return nil, nil, err
+			}
+			sinks, closers = append(sinks, logx.Sink{Writer: sl, Level: logx.LevelDebug}), append(closers, sl)
+		}
+		if lc.GELF != "" {
+			network, addr, _ := lc.GELFAddr()
+			g, err := logx.DialGELF(network, addr)
+			if err != nil {
+				closeLog()
+				return nil, nil, err
+			}
+			sinks, closers = append(sinks, logx.Sink{Writer: g, Level: logx.LevelDebug}), append(closers, g)
+		}
+		if lc.Logstash != "" {
+			addr, _ := lc.LogstashAddr()
+			ls, err := logx.DialLogstash(addr)
+			if err != nil {
+				closeLog()
+				return nil, nil, err
+			}
+			sinks, closers = append(sinks, logx.Sink{Writer: ls, Level: logx.LevelDebug}), append(closers, ls)
+		}
+		if lc.OTLP != "" {
+			o := logx.NewOTLP(lc.OTLP, "filegoblin", lc.OTLPHeaders)
+			sinks, closers = append(sinks, logx.Sink{Writer: o, Level: logx.LevelDebug}), append(closers, o)
+		}
+		if lc.EventLog != "" {
+			e, err := logx.OpenEventLog(lc.EventLog)
+			if err != nil {
+				closeLog()
+				return nil, nil, err
+			}
+			sinks, closers = append(sinks, logx.Sink{Writer: e, Level: logx.LevelDebug}), append(closers, e)
+		}
+		l = logx.NewMulti(sinks...)
+	}
+	if lc.Buffer > 0 {
+		overflow := logx.Block
+		if lc.Overflow == "drop" {
+			overflow = logx.Drop
+		}
+		l.StartAsync(lc.Buffer, overflow)
+	}
+	closers = append([]io.Closer{l}, closers...) // write out the queue and repeats before closing the sinks
+	return l, closeLog, nil
+}
+
+func logRotation(lc config.Log) logx.Rotation {
+	return logx.Rotation{MaxSize: int64(lc.MaxSize), MaxAge: lc.MaxAge, MaxBackups: lc.MaxBackups, Compress: lc.Compress,
+		Buffer: int(lc.FileBuffer), FlushEvery: lc.FlushEvery}
+}
+
+// daemonListener prefers a socket handed over by systemd socket activation (the one named
+// "http" via FileDescriptorName=, or the only one) and binds addr itself otherwise.
+func daemonListener(addr string, log *logx.Logger) (net.Listener, error) {
+	lns, err := systemd.Listeners()
+	if err != nil {
+		return nil, err
+	}
+	if ln, ok := lns["http"]; ok {
+		log.Info("using socket-activated listener %s", ln.Addr())
+		return ln, nil
+	}
+	if len(lns) == 1 {
+		for name, ln := range lns {
+			log.Info("using socket-activated listener %s (%s)", ln.Addr(), name)
+			return ln, nil
+		}
+	}
+	if len(lns) > 1 {
+		return nil, fmt.Errorf("got %d sockets from systemd but none named \"http\"", len(lns))
+	}
+	return net.Listen("tcp", addr)
+}
+
+// onceInfo is what `serve --once` prints when the share opens.
+type onceInfo struct {
+	Dir       string    `json:"dir"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Token     string    `json:"token"`
+	URLs      []string  `json:"urls"`
+}
+
+func runOnce(ctx context.Context, cmd *cobra.Command, log *logx.Logger) error {
+	fi, err := os.Stat(serveOnce)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", serveOnce)
+	}
+	token := share.NewToken()
+	sh, err := share.New(serveOnce, token, log)
+	if err != nil {
+		return err
+	}
+	defer sh.Close()
+
+	listen := serveListen
+	if listen == "" {
+		listen = ":0" // let the kernel pick a free port
+	}
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		return err
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	info := onceInfo{Dir: serveOnce, ExpiresAt: time.Now().Add(serveTTL), Token: token}
+	for _, host := range lanAddrs() {
+		info.URLs = append(info.URLs, fmt.Sprintf("http://%s/?t=%s", net.JoinHostPort(host, fmt.Sprint(port)), token))
+	}
+	err = printResult(cmd, info, func(w io.Writer) error {
+		fmt.Fprintf(w, "Sharing %s until %s. Open one of:\n", info.Dir, info.ExpiresAt.Format(time.Kitchen))
+		for _, u := range info.URLs {
+			fmt.Fprintf(w, "  %s\n", u)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, serveTTL)
+	defer cancel()
+	srv := &http.Server{Handler: sh, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		log.Info("share closed")
+		_ = srv.Close() // one-shot mode: no need to be gentle with half-finished downloads
+	}()
+	if err := srv.Serve(ln); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// lanAddrs lists the non-loopback IPv4 addresses of this machine, which is what people on the
+// same network need to type. Falls back to localhost when there are none.
+func lanAddrs() []string {
+	var out []string
+	addrs, _ := net.InterfaceAddrs()
+	for _, a := range addrs {
+		ipn, ok := a.(*net.IPNet)
+		if !ok || ipn.IP.IsLoopback() || ipn.IP.To4() == nil {
+			continue
+		}
+		out = append(out, ipn.IP.String())
+	}
+	if len(out) == 0 {
+		out = append(out, "localhost")
+	}
+	return out
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().StringVar(&serveListen, "listen", "", "address to listen on (overrides the config; random port with --once)")
+	serveCmd.Flags().StringVar(&serveOnce, "once", "", "share this directory read-only with a random token, then exit")
+	serveCmd.Flags().DurationVar(&serveTTL, "ttl", time.Hour, "how long --once keeps the share open")
+	serveCmd.Flags().BoolVar(&serveEphemeral, "ephemeral", false, "keep files in memory and all other data in a temporary directory, and lose it all on exit")
+	serveCmd.MarkFlagsMutuallyExclusive("once", "ephemeral")
+}
@@ -0,0 +1,151 @@
+//go:build windows
+
+/*
+Copyright © 2025 hey-granth
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/hey-granth/filegoblin/internal/config"
+	"github.com/hey-granth/filegoblin/internal/logx"
+	"github.com/hey-granth/filegoblin/internal/winsvc"
+	"github.com/spf13/cobra"
+)
+
+var serviceName string
+
+var serviceCmd = &cobra.Command{
+	Use:   "service",
+	Short: "Run filegoblin as a Windows service",
+}
+
+var serviceInstallCmd = &cobra.Command{
+	Use:   "install",
+	Short: "Install filegoblin as a service that starts at boot",
+	Long: `install registers a service that runs "filegoblin service run" with the config file
+given by --config (by default ` + config.DefaultFile() + `), starts at boot and is
+restarted when it fails. It runs as its own virtual account, NT SERVICE\<name>, which is
+given modify rights on ` + config.StateDir() + `, its working directory; the default
+data_dir, "data", ends up there. It also registers an Event Log source named after the
+service, for log.event_log.
+
+Run it from an elevated prompt, then start the service with "sc start filegoblin" or
+Start-Service.`,
+	Example: `  filegoblin service install
+  filegoblin service install --config D:\filegoblin\config.yaml --name filegoblin-d`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := cfgFile
+		if path == "" {
+			path = config.DefaultFile()
+		}
+		path, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		dir := config.StateDir()
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+		desc := "Self-hosted file sharing; configured by " + path
+		if err := winsvc.Install(serviceName, "filegoblin", desc, []string{"service", "run", "--name", serviceName, "--config", path}); err != nil {
+			return err
+		}
+		// (OI)(CI)M: modify rights, inherited by everything created below dir
+		grant := exec.Command("icacls", dir, "/grant", `NT SERVICE\`+serviceName+":(OI)(CI)M")
+		if out, err := grant.CombinedOutput(); err != nil {
+			return fmt.Errorf("service installed, but granting it access to %s failed: %v\n%s", dir, err, out)
+		}
+		if err := logx.InstallEventSource(serviceName); err != nil {
+			return fmt.Errorf("service installed, but %w", err)
+		}
+		_, err = fmt.Fprintf(cmd.OutOrStdout(), "installed service %s; start it with: sc start %s\n", serviceName, serviceName)
+		return err
+	},
+}
+
+var serviceUninstallCmd = &cobra.Command{
+	Use:   "uninstall",
+	Short: "Stop and remove the filegoblin service",
+	Long: `uninstall stops the service if it is running and removes it, and its Event Log
+source. Its state directory, ` + config.StateDir() + `, and the data in it are left alone.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := winsvc.Uninstall(serviceName); err != nil {
+			return err
+		}
+		if err := logx.RemoveEventSource(serviceName); err != nil {
+			return err
+		}
+		_, err := fmt.Fprintf(cmd.OutOrStdout(), "removed service %s\n", serviceName)
+		return err
+	},
+}
+
+var serviceRunCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Run as the service (started by the service control manager, not by hand)",
+	Long: `run is what the installed service executes. It works in ` + config.StateDir() + `,
+logs to filegoblin.log there unless log.file says otherwise, stops when the service is stopped, and reloads its
+configuration on "sc control <name> paramchange". Use "filegoblin serve" to run the server
+in a console.`,
+	Args:   cobra.NoArgs,
+	Hidden: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir := config.StateDir()
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+		if err := os.Chdir(dir); err != nil {
+			return err
+		}
+		f, err := logx.OpenFile("filegoblin.log", logRotation(config.Default().Log))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		log := logx.New(f)
+		err = winsvc.Run(serviceName, func(ctx context.Context) error {
+			err := runDaemon(ctx, log)
+			if err != nil {
+				log.Error("%v", err)
+			}
+			return err
+		}, requestReload)
+		if err == winsvc.ErrNotService {
+			return fmt.Errorf("%w; to run the server in a console use filegoblin serve", err)
+		}
+		return err
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serviceCmd)
+	serviceCmd.AddCommand(serviceInstallCmd, serviceUninstallCmd, serviceRunCmd)
+	serviceCmd.PersistentFlags().StringVar(&serviceName, "name", "filegoblin", "service name, to run several instances side by side")
+}
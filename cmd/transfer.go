@@ -0,0 +1,426 @@
+/*
+Copyright © 2025 hey-granth
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/hey-granth/filegoblin/internal/client"
+	"github.com/hey-granth/filegoblin/internal/config"
+	"github.com/hey-granth/filegoblin/internal/e2e"
+	"github.com/spf13/cobra"
+)
+
+var (
+	putName     string
+	putE2E      bool
+	putPrintKey bool
+	putPassword string
+	putExpires  string
+	putMaxDL    int
+	putStrip    bool
+	putDesc     string
+	putTags     []string
+	putMeta     map[string]string
+	getTo       string
+	getKey      string
+	getPassword string
+	fetchName   string
+)
+
+var putCmd = &cobra.Command{
+	Use:   "put <file | ->",
+	Short: "Upload a file, or stdin with \"-\"",
+	Long: `put uploads a file to the server and prints its share link.
+
+With "-" it streams standard input straight to the server without a temporary file, so
+it can sit at the end of a pipeline. Use --name to give the upload a file name.
+
+With --e2e the file and its name are encrypted locally before they leave the machine. The
+key is appended to the share link after "#", a part of the URL browsers never send to the
+server, or printed on its own line with --print-key. Opened in a browser, the link leads to
+a page that downloads and decrypts the file locally.
+
+With --password, downloading the file takes that password too (browsers ask for it). Wrong
+guesses are rate limited by the server, but pick a password that isn't easy to guess.
+
+With --max-downloads, the server deletes the file once it has been downloaded that many
+times; players fetching a video in ranges count once.
+
+With --strip, the server removes EXIF (including the GPS position) and other metadata from
+JPEG, PNG and WebP images before storing them, keeping only their orientation. Some servers
+always do this, some never.`,
+	Example: `  filegoblin put report.pdf
+  pg_dump mydb | gzip | filegoblin put - --name mydb.sql.gz
+  filegoblin put --e2e passport.jpg
+  filegoblin put --password "$(cat pw.txt)" contract.pdf
+  filegoblin put --expires 7d build.zip
+  filegoblin put --max-downloads 1 secret.txt
+  filegoblin put --strip holiday.jpg`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := newClient()
+		if err != nil {
+			return err
+		}
+		if err := c.SolveChallenge(cmd.Context()); err != nil {
+			return err
+		}
+		c.FilePassword, c.Expires, c.MaxDownloads, c.StripMetadata = putPassword, putExpires, putMaxDL, putStrip
+		c.Description, c.Tags, c.Meta = putDesc, putTags, putMeta
+		var (
+			r    io.Reader
+			size int64 = -1
+			name       = putName
+		)
+		if args[0] == "-" {
+			r = cmd.InOrStdin()
+			if name == "" {
+				name = "stdin"
+			}
+		} else {
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			fi, err := f.Stat()
+			if err != nil {
+				return err
+			}
+			if fi.IsDir() {
+				return fmt.Errorf("%s is a directory", args[0])
+			}
+			r, size = f, fi.Size()
+			if name == "" {
+				name = filepath.Base(args[0])
+			}
+		}
+		if !putE2E {
+			file, err := c.Upload(cmd.Context(), name, r, size)
+			if err != nil {
+				return err
+			}
+			return printResult(cmd, putResult{File: file}, func(w io.Writer) error {
+				if putStrip && strings.HasPrefix(file.ContentType, "image/") && !file.Stripped {
+					fmt.Fprintln(cmd.ErrOrStderr(), "note: the server kept this image's metadata; it doesn't strip it, or not from this format")
+				}
+				if file.SlowStart {
+					fmt.Fprintln(cmd.ErrOrStderr(), "note: this MP4 has its index at the end, so browsers fetch the whole end before playing; remux with ffmpeg -movflags +faststart to stream it sooner")
+				}
+				_, err := fmt.Fprintf(w, "%s (%s)\n", file.URL, config.ByteSize(file.Size))
+				return err
+			})
+		}
+
+		key := e2e.NewKey()
+		encName, err := e2e.EncryptName(name, key)
+		if err != nil {
+			return err
+		}
+		if size >= 0 {
+			size = e2e.EncryptedSize(size)
+		}
+		// encrypt on the fly through a pipe, so even huge files never touch the disk in plaintext copies
+		pr, pw := io.Pipe()
+		go func() {
+			ew, err := e2e.NewWriter(pw, key)
+			if err == nil {
+				_, err = io.Copy(ew, r)
+			}
+			if err == nil {
+				err = ew.Close()
+			}
+			pw.CloseWithError(err)
+		}()
+		file, err := c.UploadEncrypted(cmd.Context(), encName, pr, size)
+		pr.Close()
+		if err != nil {
+			return err
+		}
+		res := putResult{File: file, Key: e2e.EncodeKey(key)}
+		res.Name = name // only we know the real name
+		if !putPrintKey {
+			res.URL += "#k=" + res.Key
+		}
+		return printResult(cmd, res, func(w io.Writer) error {
+			fmt.Fprintf(w, "%s (%s, end-to-end encrypted)\n", res.URL, config.ByteSize(file.Size))
+			if putPrintKey {
+				fmt.Fprintf(w, "key: %s\n", res.Key)
+			}
+			return nil
+		})
+	},
+}
+
+var getCmd = &cobra.Command{
+	Use:   "get <id | url>",
+	Short: "Download a file to stdout or to a file",
+	Long: `get downloads a file by its ID or share link and streams it to standard output, so it
+can feed straight into another command. End-to-end encrypted files are decrypted on the fly
+when the link carries its key (#k=...) or the key is given with --key. When stdout is a terminal, or with --to, the file is
+saved to disk instead (under its original name unless --to says otherwise).
+
+When the data itself goes to stdout, the summary (and the --output json result) is written to
+stderr so it never mixes with the file contents.`,
+	Example: `  filegoblin get a1B2c3D4e5F6 | tar xz
+  filegoblin get https://files.example.com/f/a1B2c3D4e5F6 --to backup.tar.gz`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ref, err := fileRef(args[0])
+		if err != nil {
+			return err
+		}
+		if getKey != "" {
+			ref.Key = getKey
+		}
+		c, err := newClient()
+		if err != nil {
+			return err
+		}
+		c.FilePassword = getPassword
+		d, err := c.Download(cmd.Context(), ref.ID, ref.Query)
+		if err != nil {
+			return err
+		}
+		defer d.Body.Close()
+
+		// count and hash what comes off the wire, so truncation and corruption can be checked
+		// against Content-Length and X-Checksum-SHA256 even when decryption changes the bytes
+		// we write
+		sum := sha256.New()
+		wire := &countingReader{r: io.TeeReader(d.Body, sum)}
+		var body io.Reader = wire
+		if ref.Key != "" {
+			k, err := e2e.DecodeKey(ref.Key)
+			if err != nil {
+				return err
+			}
+			if d.Name, err = e2e.DecryptName(d.Name, k); err != nil {
+				return err
+			}
+			if body, err = e2e.NewReader(wire, k); err != nil {
+				return err
+			}
+		}
+
+		dest := getTo
+		if dest == "" && !isTerminal(os.Stdout) {
+			dest = "-"
+		}
+		if dest == "" {
+			dest = safeLocalName(d.Name, ref.ID)
+		}
+
+		res := getResult{ID: ref.ID, Name: d.Name, ContentType: d.ContentType, Path: dest}
+		if dest == "-" {
+			res.Bytes, err = io.Copy(cmd.OutOrStdout(), body)
+			if err != nil {
+				return err
+			}
+			cmd.SetOut(cmd.ErrOrStderr()) // keep the summary out of the data stream
+		} else {
+			res.Bytes, err = saveFile(dest, body)
+			if err != nil {
+				return err
+			}
+		}
+		if d.Size >= 0 && wire.n != d.Size {
+			return fmt.Errorf("download truncated: got %d of %d bytes", wire.n, d.Size)
+		}
+		if d.SHA256 != "" {
+			if got := hex.EncodeToString(sum.Sum(nil)); got != d.SHA256 {
+				if dest != "-" {
+					_ = os.Remove(dest)
+				}
+				return fmt.Errorf("download corrupted: sha256 is %s, server says %s", got, d.SHA256)
+			}
+			res.SHA256 = d.SHA256
+		}
+		return printResult(cmd, res, func(w io.Writer) error {
+			where := res.Path
+			if where == "-" {
+				where = "stdout"
+			}
+			_, err := fmt.Fprintf(w, "%s: %s written to %s\n", res.Name, config.ByteSize(res.Bytes), where)
+			return err
+		})
+	},
+}
+
+var fetchCmd = &cobra.Command{
+	Use:   "fetch <url>",
+	Short: "Have the server download a URL and store it",
+	Long: `fetch uploads a file by URL: the server downloads it directly, so the data never passes
+through this machine. The server must have fetch.enabled set, and it refuses addresses on
+internal networks.`,
+	Example: `  filegoblin fetch https://example.com/dataset.csv
+  filegoblin fetch https://example.com/download?id=7 --name report.pdf`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := newClient()
+		if err != nil {
+			return err
+		}
+		if err := c.SolveChallenge(cmd.Context()); err != nil {
+			return err
+		}
+		file, err := c.Fetch(cmd.Context(), args[0], fetchName)
+		if err != nil {
+			return err
+		}
+		return printResult(cmd, putResult{File: file}, func(w io.Writer) error {
+			_, err := fmt.Fprintf(w, "%s (%s)\n", file.URL, config.ByteSize(file.Size))
+			return err
+		})
+	},
+}
+
+// putResult is the --output json shape of `put`. Key is only set for --e2e uploads.
+type putResult struct {
+	client.File
+	Key string `json:"key,omitempty"`
+}
+
+// getResult is the --output json shape of `get`.
+type getResult struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Bytes       int64  `json:"bytes"`
+	Path        string `json:"path"`             // "-" for stdout
+	SHA256      string `json:"sha256,omitempty"` // checksum of the stored file, verified on receipt
+}
+
+// shareRef is what a file argument on the command line resolves to.
+type shareRef struct {
+	ID    string
+	Key   string // e2e key from the link's "#k=" fragment
+	Query string // signature of a signed link ("exp=...&kid=...&sig=...")
+}
+
+// fileRef accepts a bare ID or a share link like https://host/f/<id>, or https://host/e/<id>#k=<key>
+// for encrypted files. For links, the
+// server URL is taken from the link unless --server was given, and the e2e key (if any) from
+// the fragment.
+func fileRef(ref string) (shareRef, error) {
+	if !strings.Contains(ref, "://") {
+		return shareRef{ID: ref}, nil
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return shareRef{}, err
+	}
+	dir, id := path.Split(u.Path)
+	if (!strings.HasSuffix(dir, "/f/") && !strings.HasSuffix(dir, "/e/")) || id == "" {
+		return shareRef{}, fmt.Errorf("%s does not look like a filegoblin share link", ref)
+	}
+	if clientServer == "" {
+		clientServer = u.Scheme + "://" + u.Host + dir[:len(dir)-len("/f/")]
+	}
+	sr := shareRef{ID: id, Query: u.RawQuery}
+	if frag, err := url.ParseQuery(u.Fragment); err == nil {
+		sr.Key = frag.Get("k")
+	}
+	return sr, nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// saveFile writes r to path via a temp file, so an interrupted download never leaves a
+// half-written file under the final name.
+func saveFile(path string, r io.Reader) (int64, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".filegoblin-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	n, err := io.Copy(tmp, r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return n, err
+	}
+	return n, os.Rename(tmp.Name(), path)
+}
+
+// safeLocalName picks a file name in the current directory for a download, refusing to
+// overwrite anything or to be steered elsewhere by a hostile server-supplied name.
+func safeLocalName(name, id string) string {
+	name = filepath.Base(filepath.Clean("/" + name))
+	if name == "/" || name == "." || name == "" {
+		name = id
+	}
+	candidate := name
+	ext := filepath.Ext(name)
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(candidate); errors.Is(err, os.ErrNotExist) {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), i, ext)
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+func init() {
+	rootCmd.AddCommand(putCmd, getCmd, fetchCmd)
+	addClientFlags(putCmd)
+	addClientFlags(getCmd)
+	addClientFlags(fetchCmd)
+	putCmd.Flags().StringVar(&putName, "name", "", "file name to store (default: the local name, or \"stdin\")")
+	putCmd.Flags().BoolVar(&putE2E, "e2e", false, "encrypt locally before upload; the server never sees the content or name")
+	putCmd.Flags().BoolVar(&putPrintKey, "print-key", false, "with --e2e, print the key separately instead of putting it in the link")
+	putCmd.Flags().StringVar(&putExpires, "expires", "", `delete the file after this long, e.g. "24h" or "7d"`)
+	putCmd.Flags().IntVar(&putMaxDL, "max-downloads", 0, "delete the file once it has been downloaded this many times")
+	putCmd.Flags().StringVar(&putPassword, "password", "", "require this password to download the file")
+	putCmd.Flags().StringVar(&putDesc, "description", "", "say what the file is, for people and searches")
+	putCmd.Flags().StringSliceVar(&putTags, "tag", nil, "tag the file; repeat or separate with commas for several")
+	putCmd.Flags().StringToStringVar(&putMeta, "meta", nil, "give the file metadata of your own, like project=apollo; repeat for several")
+	putCmd.Flags().BoolVar(&putStrip, "strip", false, "have the server remove EXIF (location, camera) and other metadata from images")
+	getCmd.Flags().StringVar(&getTo, "to", "", "write to this path instead (\"-\" for stdout)")
+	getCmd.Flags().StringVar(&getKey, "key", "", "key for an end-to-end encrypted file, if the link doesn't carry it")
+	getCmd.Flags().StringVar(&getPassword, "password", "", "password of a password-protected file")
+	fetchCmd.Flags().StringVar(&fetchName, "name", "", "file name to store (default: from the server's response or the URL)")
+}
@@ -0,0 +1,141 @@
+/*
+Copyright © 2025 hey-granth
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var verifyLocal string
+
+// errChecksumMismatch is returned after the result has been printed, so scripts get both the
+// details and a non-zero exit status.
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify <id|url>",
+	Short: "Check a stored file against its checksum",
+	Long: `verify checks a file's integrity against the SHA-256 the server recorded when it was
+uploaded.
+
+Without flags the server rereads the stored blob and hashes it again, which catches bit rot
+and tampering on the storage side. With --local the given local file is hashed instead and
+compared to the stored checksum, which confirms that a transfer arrived intact.
+
+Files uploaded with --e2e are checksummed as ciphertext, so --local only matches the
+encrypted form of such files.
+
+The command exits non-zero when the checksums differ.`,
+	Example: `  filegoblin verify t_xGli9kxcGe
+  filegoblin verify --local backup.tar.gz https://files.example.com/f/t_xGli9kxcGe`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ref, err := fileRef(args[0])
+		if err != nil {
+			return err
+		}
+		id := ref.ID
+		c, err := newClient()
+		if err != nil {
+			return err
+		}
+
+		var res verifyResult
+		if verifyLocal == "" {
+			v, err := c.Verify(cmd.Context(), id)
+			if err != nil {
+				return err
+			}
+			res = verifyResult{ID: v.ID, Mode: "server", Expected: v.Expected, Actual: v.Actual, Bytes: v.Bytes, OK: v.OK}
+		} else {
+			f, err := c.Stat(cmd.Context(), id)
+			if err != nil {
+				return err
+			}
+			if f.SHA256 == "" {
+				return fmt.Errorf("%s: no checksum was recorded for this file", id)
+			}
+			sum, n, err := hashFile(verifyLocal)
+			if err != nil {
+				return err
+			}
+			res = verifyResult{ID: f.ID, Mode: "local", Path: verifyLocal, Expected: f.SHA256, Actual: sum, Bytes: n}
+			res.OK = sum == f.SHA256 && n == f.Size
+		}
+
+		if err := printResult(cmd, res, func(w io.Writer) error {
+			status := "OK"
+			if !res.OK {
+				status = "MISMATCH"
+			}
+			_, err := fmt.Fprintf(w, "%s: %s (sha256 %s)\n", res.ID, status, res.Actual)
+			if !res.OK {
+				_, err = fmt.Fprintf(w, "  expected sha256 %s\n", res.Expected)
+			}
+			return err
+		}); err != nil {
+			return err
+		}
+		if !res.OK {
+			return errChecksumMismatch
+		}
+		return nil
+	},
+}
+
+// verifyResult is the --output json shape of `verify`.
+type verifyResult struct {
+	ID       string `json:"id"`
+	Mode     string `json:"mode"` // "server" or "local"
+	Path     string `json:"path,omitempty"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+	Bytes    int64  `json:"bytes"`
+	OK       bool   `json:"ok"`
+}
+
+func hashFile(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+	addClientFlags(verifyCmd)
+	verifyCmd.Flags().StringVar(&verifyLocal, "local", "", "compare this local file with the stored checksum instead")
+}
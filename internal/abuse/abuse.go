@@ -0,0 +1,270 @@
+// Package abuse keeps the queue of reports filed against shared files, and the notices sent
+// to uploaders when one of their files is taken down.
+package abuse
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/metadata"
+)
+
+var (
+	ErrUnknownReport = errors.New("abuse: no such report")
+	ErrInvalidReason = errors.New("abuse: reason must be one of copyright, malware, phishing, illegal, spam, other")
+	ErrTooManyOpen   = errors.New("abuse: this file already has plenty of open reports")
+	ErrResolved      = errors.New("abuse: report is already resolved")
+	ErrFileGone      = errors.New("abuse: the reported file no longer exists; dismiss the report instead")
+)
+
+// Reasons a file can be reported for.
+var Reasons = []string{"copyright", "malware", "phishing", "illegal", "spam", "other"}
+
+// Report statuses.
+const (
+	StatusOpen      = "open"
+	StatusTakenDown = "taken_down"
+	StatusDismissed = "dismissed"
+)
+
+// maxOpenPerFile caps the queue for a single file, so one angry (or automated) reporter
+// can't flood it. Past this point extra reports add nothing for the reviewer anyway.
+const maxOpenPerFile = 20
+
+// Report is one complaint about a file.
+type Report struct {
+	ID         string     `json:"id"`
+	FileID     string     `json:"file_id"`
+	Reason     string     `json:"reason"`
+	Details    string     `json:"details,omitempty"`
+	Contact    string     `json:"contact,omitempty"` // how to reach the reporter, if they said
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+	Note       string     `json:"note,omitempty"` // the reviewer's note when resolving
+}
+
+// Notice tells an uploader that one of their files was taken down.
+type Notice struct {
+	Owner     string    `json:"owner"`
+	FileID    string    `json:"file_id"`
+	FileName  string    `json:"file_name"`
+	Reason    string    `json:"reason"`
+	Note      string    `json:"note,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Store holds reports and notices in memory and persists them to a JSON file.
+type Store struct {
+	path    string
+	mu      sync.RWMutex
+	reports map[string]*Report
+	notices []*Notice
+}
+
+type storeFile struct {
+	Reports []*Report `json:"reports"`
+	Notices []*Notice `json:"notices"`
+}
+
+// Open loads the store at path; a missing file is an empty store.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload re-reads the file, picking up changes made offline.
+func (s *Store) Reload() error {
+	var sf storeFile
+	data, err := os.ReadFile(s.path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return err
+	default:
+		if err := json.Unmarshal(data, &sf); err != nil {
+			return fmt.Errorf("parse %s: %w", s.path, err)
+		}
+	}
+	reports := make(map[string]*Report, len(sf.Reports))
+	for _, r := range sf.Reports {
+		reports[r.ID] = r
+	}
+	s.mu.Lock()
+	s.reports, s.notices = reports, sf.Notices
+	s.mu.Unlock()
+	return nil
+}
+
+// save writes the store atomically. Callers hold s.mu.
+func (s *Store) save() error {
+	sf := storeFile{Reports: []*Report{}, Notices: s.notices}
+	for _, r := range s.reports {
+		sf.Reports = append(sf.Reports, r)
+	}
+	sort.Slice(sf.Reports, func(i, j int) bool { return sf.Reports[i].CreatedAt.Before(sf.Reports[j].CreatedAt) })
+	if sf.Notices == nil {
+		sf.Notices = []*Notice{}
+	}
+	data, err := json.MarshalIndent(sf, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil { // reporters' contact details
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Add files a new open report against fileID.
+func (s *Store) Add(fileID, reason, details, contact string) (Report, error) {
+	if !slices.Contains(Reasons, reason) {
+		return Report{}, ErrInvalidReason
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	open := 0
+	for _, r := range s.reports {
+		if r.FileID == fileID && r.Status == StatusOpen {
+			open++
+		}
+	}
+	if open >= maxOpenPerFile {
+		return Report{}, ErrTooManyOpen
+	}
+	b := make([]byte, 6)
+	_, _ = rand.Read(b)
+	r := &Report{
+		ID:        hex.EncodeToString(b),
+		FileID:    fileID,
+		Reason:    reason,
+		Details:   details,
+		Contact:   contact,
+		Status:    StatusOpen,
+		CreatedAt: time.Now().UTC(),
+	}
+	s.reports[r.ID] = r
+	if err := s.save(); err != nil {
+		delete(s.reports, r.ID)
+		return Report{}, err
+	}
+	return *r, nil
+}
+
+// List returns reports oldest first, only those with the given status unless it's empty.
+func (s *Store) List(status string) []Report {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := []Report{}
+	for _, r := range s.reports {
+		if status == "" || r.Status == status {
+			out = append(out, *r)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out
+}
+
+// Get looks up one report.
+func (s *Store) Get(id string) (Report, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r, ok := s.reports[id]
+	if !ok {
+		return Report{}, ErrUnknownReport
+	}
+	return *r, nil
+}
+
+// Resolve closes a report with the given status. A takedown closes every other open report
+// on the same file too, since they've all been dealt with.
+func (s *Store) Resolve(id, status, note string) (Report, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.reports[id]
+	if !ok {
+		return Report{}, ErrUnknownReport
+	}
+	if r.Status != StatusOpen {
+		return Report{}, ErrResolved
+	}
+	now := time.Now().UTC()
+	for _, o := range s.reports {
+		if o == r || (status == StatusTakenDown && o.FileID == r.FileID && o.Status == StatusOpen) {
+			o.Status, o.ResolvedAt, o.Note = status, &now, note
+		}
+	}
+	if err := s.save(); err != nil {
+		return Report{}, err
+	}
+	return *r, nil
+}
+
+// Notify records a notice for an uploader.
+func (s *Store) Notify(n Notice) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.notices = append(s.notices, &n)
+	return s.save()
+}
+
+// Notices returns the notices for owner, newest first.
+func (s *Store) Notices(owner string) []Notice {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := []Notice{}
+	for i := len(s.notices) - 1; i >= 0; i-- {
+		if s.notices[i].Owner == owner {
+			out = append(out, *s.notices[i])
+		}
+	}
+	return out
+}
+
+// Takedown acts on report id: it marks the reported file as taken down in ix, which stops it
+// being served while keeping the record and blob for review, resolves the file's open
+// reports, and leaves a notice for the uploader.
+func (s *Store) Takedown(ix *metadata.Index, id, note string) (Report, error) {
+	r, err := s.Get(id)
+	if err != nil {
+		return Report{}, err
+	}
+	if r.Status != StatusOpen {
+		return Report{}, ErrResolved
+	}
+	f, err := ix.Get(r.FileID)
+	if errors.Is(err, metadata.ErrNotFound) {
+		return Report{}, ErrFileGone
+	}
+	if err != nil {
+		return Report{}, err
+	}
+	now := time.Now().UTC()
+	if f.TakenDown == nil {
+		f.TakenDown = &metadata.Takedown{At: now, Reason: r.Reason, Report: r.ID, Note: note}
+		if err := ix.Put(f); err != nil {
+			return Report{}, err
+		}
+	}
+	if r, err = s.Resolve(id, StatusTakenDown, note); err != nil {
+		return Report{}, err
+	}
+	if f.Owner != "" {
+		// the file is down either way, so a failure here is returned alongside the report
+		err = s.Notify(Notice{Owner: f.Owner, FileID: f.ID, FileName: f.Name, Reason: r.Reason, Note: note, CreatedAt: now})
+	}
+	return r, err
+}
@@ -0,0 +1,62 @@
+package abuse
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/metadata"
+)
+
+// TestTakedown files two reports against a file and takes it down through the first: both
+// close, the record is marked and kept, and the uploader gets a notice that survives a reload.
+func TestTakedown(t *testing.T) {
+	dir := t.TempDir()
+	ix, err := metadata.Open(filepath.Join(dir, "meta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ix.Put(&metadata.File{ID: "abc", Name: "song.mp3", Owner: "ann", CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := Open(filepath.Join(dir, "reports.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Add("abc", "rude", "", ""); err != ErrInvalidReason {
+		t.Fatalf("unknown reason: got %v", err)
+	}
+	first, err := s.Add("abc", "copyright", "my song", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Add("abc", "other", "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Takedown(ix, first.ID, "DMCA notice on file"); err != nil {
+		t.Fatal(err)
+	}
+	if open := s.List(StatusOpen); len(open) != 0 {
+		t.Fatalf("reports still open after takedown: %+v", open)
+	}
+	f, err := ix.Get("abc")
+	if err != nil || f.TakenDown == nil || f.TakenDown.Report != first.ID {
+		t.Fatalf("file after takedown: %+v, %v", f, err)
+	}
+	if _, err := s.Takedown(ix, first.ID, ""); err != ErrResolved {
+		t.Fatalf("second takedown: got %v", err)
+	}
+
+	if err := s.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	n := s.Notices("ann")
+	if len(n) != 1 || n[0].FileID != "abc" || !strings.Contains(n[0].Note, "DMCA") {
+		t.Fatalf("notices: %+v", n)
+	}
+	if len(s.Notices("bob")) != 0 {
+		t.Fatal("someone else got ann's notice")
+	}
+}
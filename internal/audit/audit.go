@@ -0,0 +1,180 @@
+// Package audit keeps a tamper-evident trail of security-relevant events. Records are
+// appended as JSON lines, and each one carries the hash of the record before it, so editing,
+// inserting or deleting a record anywhere but at the very end breaks the chain for every
+// record that follows.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Genesis is the "previous hash" of the first record.
+var Genesis = strings.Repeat("0", 64)
+
+// Record is one audited event.
+type Record struct {
+	Seq    int64     `json:"seq"` // 1 for the first record, then counting up without gaps
+	Time   time.Time `json:"time"`
+	Event  string    `json:"event"` // e.g. "password_lockout", "admin_takedown"
+	Detail string    `json:"detail"`
+
+	// Who did it and to what, where known. Left out of the JSON when empty, so records
+	// written before they existed still hash the same.
+	Actor  string `json:"actor,omitempty"`  // user name or key fingerprint; empty when anonymous
+	Key    string `json:"key,omitempty"`    // ID of the API key the request carried
+	Remote string `json:"remote,omitempty"` // the client's IP address
+	File   string `json:"file,omitempty"`   // ID of the file acted on
+
+	Prev string `json:"prev"` // Hash of the record before, or Genesis
+	Hash string `json:"hash"` // hex SHA-256 of the record's JSON with Hash left empty
+}
+
+// digest computes what r.Hash should be.
+func (r Record) digest() string {
+	r.Hash = ""
+	data, _ := json.Marshal(r) // a struct of strings, ints and a time can't fail to marshal
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Log appends records to a file. It is safe for concurrent use.
+type Log struct {
+	mu   sync.Mutex
+	f    *os.File
+	seq  int64
+	head string
+}
+
+// Open opens the log at path for appending, creating it if needed, and picks the chain up
+// where the file leaves off. It doesn't check the chain; that is Verify's job.
+func Open(path string) (*Log, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	l := &Log{f: f, head: Genesis}
+	torn := false
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		var rec Record
+		if json.Unmarshal(sc.Bytes(), &rec) == nil && rec.Hash != "" {
+			l.seq, l.head = rec.Seq, rec.Hash
+		}
+	}
+	if err := sc.Err(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	// a crash mid-write can leave a last line without its newline; start on a fresh line so
+	// the next record stays readable (Verify will still point at the torn one)
+	if fi, err := f.Stat(); err == nil && fi.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, fi.Size()-1); err == nil && last[0] != '\n' {
+			torn = true
+		}
+	}
+	if torn {
+		if _, err := f.Write([]byte("\n")); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// Append records an event and syncs it to disk before returning.
+func (l *Log) Append(event, detail string) (Record, error) {
+	return l.Add(Record{Event: event, Detail: detail})
+}
+
+// Add records rec, with its sequence number, time and hashes filled in, and syncs it to
+// disk before returning.
+func (l *Log) Add(rec Record) (Record, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	rec.Seq, rec.Time, rec.Prev = l.seq+1, time.Now().UTC(), l.head
+	rec.Hash = rec.digest()
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return Record{}, err
+	}
+	if _, err := l.f.Write(append(data, '\n')); err != nil {
+		return Record{}, err
+	}
+	if err := l.f.Sync(); err != nil {
+		return Record{}, err
+	}
+	l.seq, l.head = rec.Seq, rec.Hash
+	return rec, nil
+}
+
+// Close closes the file.
+func (l *Log) Close() error {
+	return l.f.Close()
+}
+
+// Result is the outcome of Verify.
+type Result struct {
+	Records int64  `json:"records"`
+	Head    string `json:"head"` // hash of the last good record; note it down to detect truncation later
+	OK      bool   `json:"ok"`
+	Line    int    `json:"line,omitempty"`    // first line that breaks the chain
+	Problem string `json:"problem,omitempty"` // what is wrong with it
+}
+
+// Verify reads a log and checks every record's hash and its link to the one before. It stops
+// at the first break. A chain can't show that records were cut off its end, so an expected
+// head hash, noted from an earlier run, may be given: it must then appear in the log.
+func Verify(r io.Reader, expectHead string) (Result, error) {
+	res := Result{Head: Genesis}
+	seenHead := expectHead == "" || expectHead == Genesis
+	fail := func(line int, format string, args ...interface{}) (Result, error) {
+		res.Line, res.Problem = line, fmt.Sprintf(format, args...)
+		return res, nil
+	}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for line := 1; sc.Scan(); line++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var rec Record
+		dec := json.NewDecoder(bytes.NewReader(sc.Bytes()))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&rec); err != nil {
+			return fail(line, "not a record: %v", err)
+		}
+		if rec.Seq != res.Records+1 {
+			return fail(line, "sequence number %d, want %d", rec.Seq, res.Records+1)
+		}
+		if rec.Prev != res.Head {
+			return fail(line, "previous hash %.12s… doesn't match record %d", rec.Prev, res.Records)
+		}
+		if rec.digest() != rec.Hash {
+			return fail(line, "record %d was modified: its hash doesn't match its content", rec.Seq)
+		}
+		res.Records, res.Head = rec.Seq, rec.Hash
+		if rec.Hash == expectHead {
+			seenHead = true
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return res, err
+	}
+	if !seenHead {
+		return fail(0, "expected head %.12s… is not in the log: records were removed from its end", expectHead)
+	}
+	res.OK = true
+	return res, nil
+}
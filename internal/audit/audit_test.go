@@ -0,0 +1,70 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ev := range []string{"one", "two"} {
+		if _, err := l.Append(ev, "detail of "+ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.Close()
+
+	// reopening picks the chain up where it left off
+	l, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last, err := l.Add(Record{Event: "three", Detail: "detail of three", Actor: "alice", Remote: "192.0.2.1", File: "f1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	if last.Seq != 3 {
+		t.Fatalf("seq after reopen = %d", last.Seq)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify := func(log, head string) Result {
+		t.Helper()
+		res, err := Verify(strings.NewReader(log), head)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	if res := verify(string(data), last.Hash); !res.OK || res.Records != 3 || res.Head != last.Hash {
+		t.Fatalf("intact log: %+v", res)
+	}
+
+	lines := strings.SplitAfter(string(data), "\n")
+	for name, c := range map[string]struct {
+		log      string
+		head     string
+		wantLine int
+	}{
+		"edited":    {strings.Replace(string(data), "detail of two", "detail of 2", 1), "", 2},
+		"actor":     {strings.Replace(string(data), `"actor":"alice"`, `"actor":"bob"`, 1), "", 3},
+		"removed":   {lines[0] + lines[2], "", 2},
+		"reordered": {lines[1] + lines[0] + lines[2], "", 1},
+		"truncated": {lines[0] + lines[1], last.Hash, 0},
+	} {
+		res := verify(c.log, c.head)
+		if res.OK || res.Line != c.wantLine || res.Problem == "" {
+			t.Errorf("%s: %+v, want a break at line %d", name, res, c.wantLine)
+		}
+	}
+}
@@ -0,0 +1,359 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUserExists  = errors.New("auth: user already exists")
+	ErrUnknownUser = errors.New("auth: no such user")
+	ErrUnknownKey  = errors.New("auth: no such key")
+	ErrInvalidName = errors.New("auth: user names may only use a-z, 0-9, '.', '_' and '-' (max 64)")
+)
+
+var validUserName = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,63}$`)
+
+// keySecretPrefix makes leaked keys easy to spot in logs and secret scanners.
+const keySecretPrefix = "fgk_"
+
+// User is an account that can own API keys and files.
+type User struct {
+	Name      string    `json:"name"`
+	Quota     int64     `json:"quota"` // bytes the user may store in total; 0 means unlimited
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Key is an API key belonging to a user. Only a hash of the secret is ever stored.
+type Key struct {
+	ID        string     `json:"id"`
+	User      string     `json:"user"`
+	Label     string     `json:"label,omitempty"`
+	Hash      string     `json:"hash,omitempty"` // sha256 of the secret; cleared in everything the registry hands out
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Revoked reports whether the key has been revoked.
+func (k Key) Revoked() bool { return k.RevokedAt != nil }
+
+// NewKey is returned once, at creation time: the only moment the secret is known.
+type NewKey struct {
+	Key
+	Secret string `json:"secret"`
+}
+
+// Registry holds users and their keys in memory and persists them to a Backend: a single
+// JSON file, or the metadata database. It's small (one entry per person or integration), so
+// rewriting all of it is fine.
+type Registry struct {
+	backend Backend
+	mu      sync.RWMutex
+	users   map[string]*User
+	keys    map[string]*Key // by key ID
+	byHash  map[string]*Key
+}
+
+// Backend is where a Registry keeps its users and keys.
+type Backend interface {
+	// LoadAccounts returns every user and key; none at all for a new backend.
+	LoadAccounts() ([]*User, []*Key, error)
+	// SaveAccounts replaces them all, durably and all at once.
+	SaveAccounts(users []*User, keys []*Key) error
+}
+
+// File is a Backend keeping users and keys in a JSON file, like data_dir/auth.json.
+type File string
+
+type registryFile struct {
+	Users []*User `json:"users"`
+	Keys  []*Key  `json:"keys"`
+}
+
+// LoadAccounts reads the file; a missing one has no accounts.
+func (path File) LoadAccounts() ([]*User, []*Key, error) {
+	var rf registryFile
+	data, err := os.ReadFile(string(path))
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, nil, err
+	default:
+		if err := json.Unmarshal(data, &rf); err != nil {
+			return nil, nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+	}
+	return rf.Users, rf.Keys, nil
+}
+
+// SaveAccounts writes the file atomically.
+func (path File) SaveAccounts(users []*User, keys []*Key) error {
+	data, err := json.MarshalIndent(registryFile{Users: users, Keys: keys}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := string(path) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil { // holds key hashes, keep it private
+		return err
+	}
+	return os.Rename(tmp, string(path))
+}
+
+// Open loads the registry in the JSON file at path; a missing file is an empty registry.
+func Open(path string) (*Registry, error) {
+	return New(File(path))
+}
+
+// New loads the registry kept in b.
+func New(b Backend) (*Registry, error) {
+	r := &Registry{backend: b}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload re-reads the backend, picking up changes made by `filegoblin admin --offline`, or
+// by other servers sharing it.
+func (r *Registry) Reload() error {
+	us, ks, err := r.backend.LoadAccounts()
+	if err != nil {
+		return err
+	}
+	users := make(map[string]*User, len(us))
+	for _, u := range us {
+		users[u.Name] = u
+	}
+	keys := make(map[string]*Key, len(ks))
+	byHash := make(map[string]*Key, len(ks))
+	for _, k := range ks {
+		keys[k.ID] = k
+		byHash[k.Hash] = k
+	}
+	r.mu.Lock()
+	r.users, r.keys, r.byHash = users, keys, byHash
+	r.mu.Unlock()
+	return nil
+}
+
+// save writes the registry to its backend, users by name and keys oldest first. Callers
+// hold r.mu.
+func (r *Registry) save() error {
+	users, keys := []*User{}, []*Key{}
+	for _, u := range r.users {
+		users = append(users, u)
+	}
+	for _, k := range r.keys {
+		keys = append(keys, k)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Name < users[j].Name })
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return r.backend.SaveAccounts(users, keys)
+}
+
+// AddUser creates a user with the given quota in bytes (0 = unlimited).
+func (r *Registry) AddUser(name string, quota int64) (User, error) {
+	if !validUserName.MatchString(name) {
+		return User{}, ErrInvalidName
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.users[name]; ok {
+		return User{}, ErrUserExists
+	}
+	u := &User{Name: name, Quota: quota, CreatedAt: time.Now().UTC()}
+	r.users[name] = u
+	if err := r.save(); err != nil {
+		delete(r.users, name)
+		return User{}, err
+	}
+	return *u, nil
+}
+
+// RemoveUser deletes a user and revokes all of their keys. Their files are left alone.
+func (r *Registry) RemoveUser(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.users[name]; !ok {
+		return ErrUnknownUser
+	}
+	delete(r.users, name)
+	now := time.Now().UTC()
+	for _, k := range r.keys {
+		if k.User == name && !k.Revoked() {
+			k.RevokedAt = &now
+		}
+	}
+	return r.save()
+}
+
+// Users lists all users by name.
+func (r *Registry) Users() []User {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]User, 0, len(r.users))
+	for _, u := range r.users {
+		out = append(out, *u)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// HasUsers reports whether any user exists, i.e. whether registry keys are in use at all.
+func (r *Registry) HasUsers() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.users) > 0
+}
+
+// User looks up a single user.
+func (r *Registry) User(name string) (User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	u, ok := r.users[name]
+	if !ok {
+		return User{}, ErrUnknownUser
+	}
+	return *u, nil
+}
+
+// SetQuota changes a user's storage quota in bytes (0 = unlimited).
+func (r *Registry) SetQuota(name string, quota int64) error {
+	if quota < 0 {
+		return errors.New("auth: quota must not be negative")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.users[name]
+	if !ok {
+		return ErrUnknownUser
+	}
+	old := u.Quota
+	u.Quota = quota
+	if err := r.save(); err != nil {
+		u.Quota = old
+		return err
+	}
+	return nil
+}
+
+// CreateKey issues a new API key for user. The secret is returned here and never again.
+func (r *Registry) CreateKey(user, label string) (NewKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.users[user]; !ok {
+		return NewKey{}, ErrUnknownUser
+	}
+	secret := keySecretPrefix + randomString(24)
+	k := &Key{
+		ID:        randomString(6),
+		User:      user,
+		Label:     label,
+		Hash:      hashSecret(secret),
+		CreatedAt: time.Now().UTC(),
+	}
+	r.keys[k.ID] = k
+	r.byHash[k.Hash] = k
+	if err := r.save(); err != nil {
+		delete(r.keys, k.ID)
+		delete(r.byHash, k.Hash)
+		return NewKey{}, err
+	}
+	out := *k
+	out.Hash = ""
+	return NewKey{Key: out, Secret: secret}, nil
+}
+
+// RevokeKey disables a key. Revoked keys are kept so the audit trail still makes sense.
+func (r *Registry) RevokeKey(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	k, ok := r.keys[id]
+	if !ok {
+		return ErrUnknownKey
+	}
+	if k.Revoked() {
+		return nil
+	}
+	now := time.Now().UTC()
+	k.RevokedAt = &now
+	if err := r.save(); err != nil {
+		k.RevokedAt = nil
+		return err
+	}
+	return nil
+}
+
+// Keys lists a user's keys (including revoked ones), without their hashes.
+func (r *Registry) Keys(user string) ([]Key, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if _, ok := r.users[user]; !ok {
+		return nil, ErrUnknownUser
+	}
+	out := []Key{}
+	for _, k := range r.keys {
+		if k.User == user {
+			cp := *k
+			cp.Hash = ""
+			out = append(out, cp)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out, nil
+}
+
+// Authenticate maps a presented secret to its user. Revoked keys and keys of deleted users fail.
+func (r *Registry) Authenticate(secret string) (User, bool) {
+	if secret == "" {
+		return User{}, false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	// looking up by hash means we never compare secrets directly, so timing tells an attacker nothing
+	k, ok := r.byHash[hashSecret(secret)]
+	if !ok || k.Revoked() {
+		return User{}, false
+	}
+	u, ok := r.users[k.User]
+	if !ok {
+		return User{}, false
+	}
+	return *u, true
+}
+
+// KeyID returns the ID of the live key whose secret this is, to record which key did what.
+func (r *Registry) KeyID(secret string) (string, bool) {
+	if secret == "" {
+		return "", false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	k, ok := r.byHash[hashSecret(secret)]
+	if !ok || k.Revoked() {
+		return "", false
+	}
+	return k.ID, true
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
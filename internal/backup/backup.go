@@ -0,0 +1,442 @@
+// Package backup writes incremental backups of a data directory to another directory, such
+// as a mount of off-site storage, and restores them.
+//
+// Each run adds a generation: a directory holding the blobs stored since the run before, the
+// file records that were added or changed since then, the IDs of those deleted, and copies of
+// the small state files (users and keys, link signing keys, abuse reports). The oldest
+// generation is full and later ones build on it, so restoring one replays the chain up to it.
+// When there are more generations than are to be kept, the oldest is folded into the next,
+// which becomes the new full one. Users and keys kept in the metadata database rather than
+// auth.json are written to the generation as an auth.json all the same, and restored into it.
+//
+// A generation is written under a temporary name and renamed into place when complete, so an
+// interrupted run leaves nothing behind that a restore could trip over.
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/auth"
+	"github.com/hey-granth/filegoblin/internal/cluster"
+	"github.com/hey-granth/filegoblin/internal/metadata"
+	"github.com/hey-granth/filegoblin/internal/storage"
+)
+
+// StateFiles are the files of a data directory that are copied whole into every generation.
+var StateFiles = []string{"auth.json", "signing.json", "reports.json"}
+
+var (
+	// ErrBusy is returned by Run while another run is writing to the same target.
+	ErrBusy = errors.New("backup: another backup is running")
+	// ErrNotEmpty is returned by Restore into a data directory that already has files.
+	ErrNotEmpty = errors.New("backup: the data directory already has files; restore into an empty one")
+)
+
+const (
+	manifestFile = "manifest.json"
+	nameFormat   = "20060102T150405Z"
+	lockStale    = 6 * time.Hour // a run's lock older than this was left by one that died
+)
+
+// manifest describes a generation. It is written last, into the generation's directory.
+type manifest struct {
+	Name    string           `json:"name"`
+	Created time.Time        `json:"created"`
+	Base    string           `json:"base,omitempty"`    // the generation this one builds on; empty for a full one
+	Records []*metadata.File `json:"records"`           // new or changed since Base; all of them in a full generation
+	Deleted []string         `json:"deleted,omitempty"` // records gone since Base
+	Blobs   int              `json:"blobs"`             // blobs stored in this generation
+	Bytes   int64            `json:"bytes"`
+}
+
+// Info sums up a generation.
+type Info struct {
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+	Full    bool      `json:"full"`
+	Records int       `json:"records"` // records written: new or changed ones, or all of them in a full generation
+	Deleted int       `json:"deleted"`
+	Blobs   int       `json:"blobs"`
+	Bytes   int64     `json:"bytes"`
+}
+
+func (m *manifest) info() Info {
+	return Info{Name: m.Name, Created: m.Created, Full: m.Base == "", Records: len(m.Records), Deleted: len(m.Deleted), Blobs: m.Blobs, Bytes: m.Bytes}
+}
+
+// Run writes a new generation of the data directory dataDir (whose blobs and records are
+// store and index) to target, then folds old generations away until keep are left.
+func Run(ctx context.Context, store storage.Backend, index *metadata.Index, dataDir, target string, keep int, now time.Time) (Info, error) {
+	unlock, err := cluster.Lock(target, "backup", lockStale, 0)
+	if errors.Is(err, cluster.ErrBusy) {
+		return Info{}, ErrBusy
+	}
+	if err != nil {
+		return Info{}, err
+	}
+	defer unlock()
+	if err := removeTemp(target); err != nil {
+		return Info{}, err
+	}
+	chain, err := readChain(target)
+	if err != nil {
+		return Info{}, err
+	}
+	state := replay(chain)
+	stored, err := blobs(target, chain)
+	if err != nil {
+		return Info{}, err
+	}
+
+	m := &manifest{Name: now.UTC().Format(nameFormat), Created: now.UTC(), Records: []*metadata.File{}}
+	if len(chain) > 0 {
+		m.Base = chain[len(chain)-1].Name
+		if m.Name <= m.Base {
+			return Info{}, fmt.Errorf("backup: generation %s is not newer than %s", m.Name, m.Base)
+		}
+	}
+	tmp := filepath.Join(target, ".tmp-"+m.Name)
+	if err := os.MkdirAll(filepath.Join(tmp, "blobs"), 0o750); err != nil {
+		return Info{}, err
+	}
+	seen := map[string]bool{}
+files:
+	for _, f := range index.List() {
+		// blobs are kept by their storage key, so a deduplicated one is copied once
+		for _, key := range f.BlobKeys() {
+			if _, ok := stored[key]; ok {
+				continue
+			}
+			n, err := copyBlob(ctx, store, key, filepath.Join(tmp, "blobs", key))
+			if errors.Is(err, storage.ErrNotFound) {
+				continue files // deleted or replaced since the listing
+			}
+			if err != nil {
+				return Info{}, fmt.Errorf("backup: copy %s: %w", f.ID, err)
+			}
+			stored[key] = m.Name
+			m.Blobs++
+			m.Bytes += n
+		}
+		seen[f.ID] = true
+		if old, ok := state[f.ID]; !ok || !sameRecord(old, f) {
+			m.Records = append(m.Records, f)
+		}
+	}
+	for id := range state {
+		if !seen[id] {
+			m.Deleted = append(m.Deleted, id)
+		}
+	}
+	sort.Strings(m.Deleted)
+	if err := copyState(dataDir, filepath.Join(tmp, "state")); err != nil {
+		return Info{}, err
+	}
+	// a metadata database keeps the users and keys instead of auth.json
+	if db, ok := index.Store().(auth.Backend); ok {
+		users, keys, err := db.LoadAccounts()
+		if err == nil {
+			err = auth.File(filepath.Join(tmp, "state", "auth.json")).SaveAccounts(users, keys)
+		}
+		if err != nil {
+			return Info{}, fmt.Errorf("backup: users and keys: %w", err)
+		}
+	}
+	if err := writeManifest(tmp, m); err != nil {
+		return Info{}, err
+	}
+	if err := os.Rename(tmp, filepath.Join(target, m.Name)); err != nil {
+		return Info{}, err
+	}
+	return m.info(), prune(target, keep)
+}
+
+// List returns the generations in target, oldest first.
+func List(target string) ([]Info, error) {
+	chain, err := readChain(target)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Info, len(chain))
+	for i, m := range chain {
+		out[i] = m.info()
+	}
+	return out, nil
+}
+
+// Restore fills the empty data directory dataDir (whose blobs and records are store and
+// index) from generation name in target, or from the latest one when name is empty. It
+// returns the generation restored.
+func Restore(ctx context.Context, target, name string, store storage.Backend, index *metadata.Index, dataDir string) (Info, error) {
+	if len(index.List()) > 0 {
+		return Info{}, ErrNotEmpty
+	}
+	chain, err := readChain(target)
+	if err != nil {
+		return Info{}, err
+	}
+	if len(chain) == 0 {
+		return Info{}, fmt.Errorf("backup: no generations in %s", target)
+	}
+	n := len(chain)
+	if name != "" {
+		n = slices.IndexFunc(chain, func(m *manifest) bool { return m.Name == name }) + 1
+		if n == 0 {
+			return Info{}, fmt.Errorf("backup: no generation %q in %s", name, target)
+		}
+	}
+	chain = chain[:n]
+	state := replay(chain)
+	stored, err := blobs(target, chain)
+	if err != nil {
+		return Info{}, err
+	}
+	restored := map[string]bool{}
+	for id, f := range state {
+		for _, key := range f.BlobKeys() {
+			gen, ok := stored[key]
+			if !ok {
+				return Info{}, fmt.Errorf("backup: the blob of %s is missing from the backup", id)
+			}
+			if restored[key] {
+				continue
+			}
+			src, err := os.Open(filepath.Join(target, gen, "blobs", key))
+			if err != nil {
+				return Info{}, err
+			}
+			_, err = store.Put(ctx, key, src)
+			src.Close()
+			if err != nil {
+				return Info{}, err
+			}
+			restored[key] = true
+		}
+		if err := index.Put(f); err != nil {
+			return Info{}, err
+		}
+	}
+	last := chain[len(chain)-1]
+	if err := copyState(filepath.Join(target, last.Name, "state"), dataDir); err != nil {
+		return Info{}, err
+	}
+	if db, ok := index.Store().(auth.Backend); ok {
+		users, keys, err := auth.File(filepath.Join(dataDir, "auth.json")).LoadAccounts()
+		if err == nil {
+			err = db.SaveAccounts(users, keys)
+		}
+		if err != nil {
+			return Info{}, fmt.Errorf("backup: users and keys: %w", err)
+		}
+	}
+	return last.info(), nil
+}
+
+// readChain reads the manifests of every generation in target, oldest first, and checks
+// that each builds on the one before.
+func readChain(target string) ([]*manifest, error) {
+	entries, err := os.ReadDir(target)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var chain []*manifest
+	for _, e := range entries {
+		if !e.IsDir() || strings.HasPrefix(e.Name(), ".") || strings.HasSuffix(e.Name(), ".lock") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(target, e.Name(), manifestFile))
+		if err != nil {
+			return nil, fmt.Errorf("backup: generation %s: %w", e.Name(), err)
+		}
+		var m manifest
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, fmt.Errorf("backup: generation %s: %w", e.Name(), err)
+		}
+		chain = append(chain, &m)
+	}
+	sort.Slice(chain, func(i, j int) bool { return chain[i].Name < chain[j].Name })
+	for i, m := range chain {
+		if m.Base != "" && (i == 0 || chain[i-1].Name != m.Base) {
+			return nil, fmt.Errorf("backup: generation %s builds on %s, which is missing", m.Name, m.Base)
+		}
+	}
+	return chain, nil
+}
+
+// replay works out the records as of the last generation of chain.
+func replay(chain []*manifest) map[string]*metadata.File {
+	state := map[string]*metadata.File{}
+	for _, m := range chain {
+		if m.Base == "" {
+			clear(state)
+		}
+		for _, f := range m.Records {
+			state[f.ID] = f
+		}
+		for _, id := range m.Deleted {
+			delete(state, id)
+		}
+	}
+	return state
+}
+
+// blobs maps every blob stored in the generations of chain to the generation holding it.
+// It looks at the directories rather than the manifests, which a fold that was interrupted
+// may have left behind the blobs it moved.
+func blobs(target string, chain []*manifest) (map[string]string, error) {
+	out := map[string]string{}
+	for _, m := range chain {
+		entries, err := os.ReadDir(filepath.Join(target, m.Name, "blobs"))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		for _, e := range entries {
+			out[e.Name()] = m.Name
+		}
+	}
+	return out, nil
+}
+
+// prune folds the oldest generation into the next until keep are left.
+func prune(target string, keep int) error {
+	for {
+		chain, err := readChain(target)
+		if err != nil || len(chain) <= max(keep, 1) {
+			return err
+		}
+		if err := fold(target, chain[0], chain[1]); err != nil {
+			return err
+		}
+	}
+}
+
+// fold turns next into a full generation by moving into it the blobs of first that it still
+// needs and writing it a complete list of records, then removes first. Each step leaves a
+// chain that still restores if the next one doesn't happen.
+func fold(target string, first, next *manifest) error {
+	if next.Base != "" {
+		state := replay([]*manifest{first, next})
+		dir := filepath.Join(target, next.Name, "blobs")
+		if err := os.MkdirAll(dir, 0o750); err != nil {
+			return err
+		}
+		for _, f := range state {
+			for _, key := range f.BlobKeys() {
+				err := os.Rename(filepath.Join(target, first.Name, "blobs", key), filepath.Join(dir, key))
+				if err != nil && !errors.Is(err, fs.ErrNotExist) {
+					return err
+				}
+			}
+		}
+		full := *next
+		full.Base, full.Deleted, full.Records = "", nil, make([]*metadata.File, 0, len(state))
+		for _, f := range state {
+			full.Records = append(full.Records, f)
+		}
+		sort.Slice(full.Records, func(i, j int) bool { return full.Records[i].ID < full.Records[j].ID })
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		full.Blobs, full.Bytes = len(entries), 0
+		for _, e := range entries {
+			if fi, err := e.Info(); err == nil {
+				full.Bytes += fi.Size()
+			}
+		}
+		if err := writeManifest(filepath.Join(target, next.Name), &full); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(filepath.Join(target, first.Name))
+}
+
+func writeManifest(dir string, m *manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, manifestFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0o640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, manifestFile))
+}
+
+// removeTemp removes generations that a run didn't get to finish.
+func removeTemp(target string) error {
+	paths, err := filepath.Glob(filepath.Join(target, ".tmp-*"))
+	if err != nil {
+		return err
+	}
+	for _, p := range paths {
+		if err := os.RemoveAll(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyBlob(ctx context.Context, store storage.Backend, id, dst string) (int64, error) {
+	rc, err := store.Get(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	return copyFile(rc, dst, 0o640)
+}
+
+// copyState copies the state files found in from into to.
+func copyState(from, to string) error {
+	if err := os.MkdirAll(to, 0o750); err != nil {
+		return err
+	}
+	for _, name := range StateFiles {
+		src, err := os.Open(filepath.Join(from, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		_, err = copyFile(src, filepath.Join(to, name), 0o600) // auth.json holds key hashes
+		src.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(r io.Reader, dst string, perm os.FileMode) (int64, error) {
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(out, r)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+func sameRecord(a, b *metadata.File) bool {
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	return bytes.Equal(ja, jb)
+}
@@ -0,0 +1,237 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/auth"
+	"github.com/hey-granth/filegoblin/internal/metadata"
+	"github.com/hey-granth/filegoblin/internal/storage"
+)
+
+type dataDir struct {
+	dir   string
+	store storage.Backend
+	index *metadata.Index
+}
+
+func newDataDir(t *testing.T) *dataDir {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := storage.NewDisk(filepath.Join(dir, "blobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := metadata.Open(filepath.Join(dir, "meta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &dataDir{dir, store, index}
+}
+
+// newSQLiteDataDir is a data directory keeping its records, users and keys in SQLite.
+func newSQLiteDataDir(t *testing.T) *dataDir {
+	t.Helper()
+	d := newDataDir(t)
+	st, err := metadata.OpenSQLite(metadata.SQLiteOptions{Path: filepath.Join(d.dir, "meta.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := metadata.Migrate(st.(metadata.Migrator), metadata.Latest(st.(metadata.Migrator)), nil); err != nil {
+		t.Fatal(err)
+	}
+	if d.index, err = metadata.New(st); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.index.Close() })
+	return d
+}
+
+func (d *dataDir) add(t *testing.T, id, content string) {
+	t.Helper()
+	n, err := d.store.Put(context.Background(), id, strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.index.Put(&metadata.File{ID: id, Name: id + ".txt", Size: n, CreatedAt: time.Now().UTC()}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (d *dataDir) remove(t *testing.T, id string) {
+	t.Helper()
+	if err := d.store.Delete(context.Background(), id); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.index.Delete(id); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestRun backs up a data directory four times with changes in between, keeping three
+// generations, and restores both the latest and an older one.
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	src := newDataDir(t)
+	target := t.TempDir()
+	now := time.Date(2024, 5, 1, 3, 0, 0, 0, time.UTC)
+	run := func() Info {
+		t.Helper()
+		now = now.Add(24 * time.Hour)
+		info, err := Run(ctx, src.store, src.index, src.dir, target, 3, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info
+	}
+
+	src.add(t, "a", "alpha")
+	src.add(t, "b", "bravo")
+	if err := os.WriteFile(filepath.Join(src.dir, "auth.json"), []byte(`{"users":{}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if info := run(); !info.Full || info.Records != 2 || info.Blobs != 2 || info.Bytes != 10 {
+		t.Fatalf("first run: %+v", info)
+	}
+	if info := run(); info.Full || info.Records != 0 || info.Blobs != 0 {
+		t.Fatalf("run without changes: %+v", info)
+	}
+	src.add(t, "c", "charlie")
+	src.remove(t, "b")
+	third := run()
+	if third.Records != 1 || third.Deleted != 1 || third.Blobs != 1 {
+		t.Fatalf("run after changes: %+v", third)
+	}
+	f, _ := src.index.Get("a")
+	f.Name = "renamed.txt"
+	if err := src.index.Put(f); err != nil {
+		t.Fatal(err)
+	}
+	if info := run(); info.Records != 1 || info.Blobs != 0 {
+		t.Fatalf("run after a rename: %+v", info)
+	}
+
+	gens, err := List(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gens) != 3 || !gens[0].Full || gens[0].Records != 2 || gens[0].Blobs != 2 || gens[1].Full {
+		t.Fatalf("after pruning to 3: %+v", gens)
+	}
+
+	restore := func(name string) *dataDir {
+		t.Helper()
+		dst := newDataDir(t)
+		if _, err := Restore(ctx, target, name, dst.store, dst.index, dst.dir); err != nil {
+			t.Fatal(err)
+		}
+		return dst
+	}
+	dst := restore("")
+	if f, err := dst.index.Get("a"); err != nil || f.Name != "renamed.txt" {
+		t.Fatalf("restored a: %+v, %v", f, err)
+	}
+	if _, err := dst.index.Get("b"); err == nil {
+		t.Fatal("restored a deleted record")
+	}
+	rc, err := dst.store.Get(ctx, "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(data) != "charlie" {
+		t.Fatalf("restored c holds %q", data)
+	}
+	if data, err := os.ReadFile(filepath.Join(dst.dir, "auth.json")); err != nil || string(data) != `{"users":{}}` {
+		t.Fatalf("restored auth.json: %q, %v", data, err)
+	}
+	if f, _ := restore(third.Name).index.Get("a"); f == nil || f.Name != "a.txt" {
+		t.Fatalf("a as of %s: %+v", third.Name, f)
+	}
+
+	if _, err := Restore(ctx, target, "", dst.store, dst.index, dst.dir); !errors.Is(err, ErrNotEmpty) {
+		t.Fatalf("restore over files: %v", err)
+	}
+}
+
+// TestInterrupted leaves a half-written generation and a half-done fold behind and checks
+// that neither is restored from and the next run cleans up after them.
+func TestInterrupted(t *testing.T) {
+	ctx := context.Background()
+	src := newDataDir(t)
+	target := t.TempDir()
+	now := time.Date(2024, 5, 1, 3, 0, 0, 0, time.UTC)
+	src.add(t, "a", "alpha")
+	first, err := Run(ctx, src.store, src.index, src.dir, target, 5, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src.add(t, "b", "bravo")
+	second, err := Run(ctx, src.store, src.index, src.dir, target, 5, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a fold that moved the blob of a but stopped before writing the new manifest
+	if err := os.Rename(filepath.Join(target, first.Name, "blobs", "a"), filepath.Join(target, second.Name, "blobs", "a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(target, ".tmp-20240501T050000Z", "blobs"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	dst := newDataDir(t)
+	if info, err := Restore(ctx, target, "", dst.store, dst.index, dst.dir); err != nil || info.Name != second.Name {
+		t.Fatalf("restore: %+v, %v", info, err)
+	}
+	if n := len(dst.index.List()); n != 2 {
+		t.Fatalf("restored %d records, want 2", n)
+	}
+
+	if _, err := Run(ctx, src.store, src.index, src.dir, target, 1, now.Add(2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(target)
+	if len(entries) != 1 {
+		t.Fatalf("left in the target: %v", entries)
+	}
+}
+
+// TestAccounts backs up the users and keys a metadata database keeps, and restores them
+// into another one.
+func TestAccounts(t *testing.T) {
+	ctx := context.Background()
+	src, dst := newSQLiteDataDir(t), newSQLiteDataDir(t)
+	target := t.TempDir()
+	users, err := auth.New(src.index.Store().(auth.Backend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.AddUser("ann", 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	key, err := users.CreateKey("ann", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src.add(t, "a", "alpha")
+	if _, err := Run(ctx, src.store, src.index, src.dir, target, 3, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Restore(ctx, target, "", dst.store, dst.index, dst.dir); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := auth.New(dst.index.Store().(auth.Backend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u, ok := restored.Authenticate(key.Secret); !ok || u.Name != "ann" || u.Quota != 1<<20 {
+		t.Fatalf("restored registry: %+v, %v", u, ok)
+	}
+}
@@ -0,0 +1,268 @@
+// Package bus publishes events to a NATS server, for pipelines that would rather consume a
+// stream than receive webhooks. It speaks the NATS client protocol itself; publishing needs
+// only a small part of it. Delivery is best effort, as NATS core's is: messages published
+// while the server can't be reached wait in a bounded queue and are sent after reconnecting,
+// and are dropped if the queue fills up or the publisher is closed first.
+package bus
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errTooBig is returned for a message the server won't take whatever we do.
+var errTooBig = errors.New("message is over the server's size limit")
+
+const (
+	queueSize   = 1000
+	dialTimeout = 10 * time.Second
+	maxBackoff  = 30 * time.Second
+)
+
+type message struct {
+	subject string
+	data    []byte
+}
+
+// Publisher sends messages to one NATS server from a single background connection, made
+// again whenever it breaks. It is safe for concurrent use.
+type Publisher struct {
+	url   *url.URL
+	logf  func(format string, args ...any) // told about connection failures
+	queue chan message
+	quit  chan struct{}
+	done  chan struct{}
+	once  sync.Once
+}
+
+// New checks rawURL ("nats://host:port", or "tls://" to require TLS; "user:password@" or
+// "token@" in it are used to log in) and starts publishing to it. Nothing is dialled until
+// the first message.
+func New(rawURL string, logf func(format string, args ...any)) (*Publisher, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("bus: %w", err)
+	}
+	if (u.Scheme != "nats" && u.Scheme != "tls") || u.Hostname() == "" {
+		return nil, fmt.Errorf("bus: %q is not a nats:// or tls:// URL", u.Redacted())
+	}
+	p := &Publisher{url: u, logf: logf, queue: make(chan message, queueSize), quit: make(chan struct{}), done: make(chan struct{})}
+	go p.run()
+	return p, nil
+}
+
+// Publish queues data for subject. It returns false, dropping the message, when the queue is
+// full: the server has been unreachable for a while.
+func (p *Publisher) Publish(subject string, data []byte) bool {
+	select {
+	case <-p.quit:
+		return false
+	default:
+	}
+	select {
+	case p.queue <- message{subject, data}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close sends what is queued if the connection is up, then closes it.
+func (p *Publisher) Close() {
+	p.once.Do(func() { close(p.quit) })
+	<-p.done
+}
+
+func (p *Publisher) run() {
+	defer close(p.done)
+	var c *conn
+	defer func() {
+		if c != nil {
+			c.close()
+		}
+	}()
+	wait := time.Second
+	for {
+		var m message
+		select {
+		case m = <-p.queue:
+		case <-p.quit:
+			// one try for what is left, without reconnecting
+			for c != nil && len(p.queue) > 0 {
+				if m := <-p.queue; c.publish(m) != nil {
+					return
+				}
+			}
+			return
+		}
+		for {
+			if c == nil {
+				var err error
+				if c, err = dial(p.url); err != nil {
+					p.logf("bus: connect to %s: %v", p.url.Redacted(), err)
+					select {
+					case <-time.After(wait):
+					case <-p.quit:
+						return
+					}
+					wait = min(2*wait, maxBackoff)
+					continue
+				}
+				wait = time.Second
+			}
+			if err := c.publish(m); errors.Is(err, errTooBig) {
+				p.logf("bus: dropped a %d byte message on %s: %v", len(m.data), m.subject, err)
+			} else if err != nil {
+				p.logf("bus: publish to %s: %v", p.url.Redacted(), err)
+				c.close()
+				c = nil
+				continue
+			}
+			break
+		}
+	}
+}
+
+// info is the part of the server's INFO message we use.
+type info struct {
+	TLSRequired bool `json:"tls_required"`
+	MaxPayload  int  `json:"max_payload"`
+}
+
+// conn is one connection to the server. A goroutine reads what the server sends, answering
+// its PINGs so it doesn't drop us as dead.
+type conn struct {
+	nc         net.Conn
+	mu         sync.Mutex // serializes writes
+	w          *bufio.Writer
+	maxPayload int
+	broken     chan struct{} // closed when the reader stops
+	err        error         // why it stopped
+}
+
+func dial(u *url.URL) (*conn, error) {
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	nc, err := net.DialTimeout("tcp", host, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	_ = nc.SetDeadline(time.Now().Add(dialTimeout))
+	r := bufio.NewReader(nc)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	payload, ok := strings.CutPrefix(strings.TrimSpace(line), "INFO ")
+	var in info
+	if !ok || json.Unmarshal([]byte(payload), &in) != nil {
+		nc.Close()
+		return nil, errors.New("not a NATS server")
+	}
+	if u.Scheme == "tls" || in.TLSRequired {
+		tc := tls.Client(nc, &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12})
+		if err := tc.Handshake(); err != nil {
+			nc.Close()
+			return nil, err
+		}
+		nc = tc
+		r = bufio.NewReader(nc)
+	}
+
+	opts := map[string]any{"verbose": false, "pedantic": false, "name": "filegoblin", "lang": "go", "version": "1", "protocol": 1}
+	if u.User != nil {
+		if pass, ok := u.User.Password(); ok {
+			opts["user"], opts["pass"] = u.User.Username(), pass
+		} else {
+			opts["auth_token"] = u.User.Username()
+		}
+	}
+	connect, _ := json.Marshal(opts)
+	// the PING's PONG tells us the server accepted the CONNECT; it answers -ERR otherwise
+	if _, err := fmt.Fprintf(nc, "CONNECT %s\r\nPING\r\n", connect); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			nc.Close()
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "-ERR") {
+			nc.Close()
+			return nil, errors.New(strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "-ERR")), "'"))
+		}
+		if line == "PONG" {
+			break
+		}
+	}
+	_ = nc.SetDeadline(time.Time{})
+
+	c := &conn{nc: nc, w: bufio.NewWriter(nc), maxPayload: in.MaxPayload, broken: make(chan struct{})}
+	go c.read(r)
+	return c, nil
+}
+
+func (c *conn) read(r *bufio.Reader) {
+	defer close(c.broken)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			c.err = err
+			return
+		}
+		switch line = strings.TrimSpace(line); {
+		case line == "PING":
+			c.mu.Lock()
+			_, err = c.w.WriteString("PONG\r\n")
+			if err == nil {
+				err = c.w.Flush()
+			}
+			c.mu.Unlock()
+			if err != nil {
+				c.err = err
+				return
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			// most errors end the connection; for the others starting over is simplest
+			c.err = errors.New(strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "-ERR")), "'"))
+			return
+		}
+	}
+}
+
+func (c *conn) publish(m message) error {
+	select {
+	case <-c.broken:
+		return c.err
+	default:
+	}
+	if c.maxPayload > 0 && len(m.data) > c.maxPayload {
+		return errTooBig
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_ = c.nc.SetWriteDeadline(time.Now().Add(dialTimeout))
+	fmt.Fprintf(c.w, "PUB %s %d\r\n", m.subject, len(m.data))
+	c.w.Write(m.data)
+	c.w.WriteString("\r\n")
+	return c.w.Flush()
+}
+
+func (c *conn) close() {
+	c.nc.Close()
+	<-c.broken
+}
@@ -0,0 +1,123 @@
+package bus
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeNATS accepts connections like a NATS server would, checks the login and hands every
+// message published to it to msgs as "<subject> <data>". Each connection is closed after
+// perConn messages, to make the publisher reconnect.
+func fakeNATS(t *testing.T, perConn int) (addr string, msgs chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	msgs = make(chan string, 10)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveFake(c, perConn, msgs)
+		}
+	}()
+	return ln.Addr().String(), msgs
+}
+
+func serveFake(c net.Conn, perConn int, msgs chan<- string) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	fmt.Fprintf(c, "INFO {\"server_id\":\"fake\",\"max_payload\":64}\r\n")
+	line, _ := r.ReadString('\n')
+	var opts struct{ User, Pass string }
+	_ = json.Unmarshal([]byte(strings.TrimPrefix(line, "CONNECT ")), &opts)
+	if opts.User != "fg" || opts.Pass != "s3cret" {
+		fmt.Fprintf(c, "-ERR 'Authorization Violation'\r\n")
+		return
+	}
+	if line, _ := r.ReadString('\n'); line != "PING\r\n" {
+		return
+	}
+	// and a PING of our own, which has to be answered (in any order with what follows)
+	fmt.Fprintf(c, "PONG\r\nPING\r\n")
+	for n := 0; n < perConn; {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		f := strings.Fields(line)
+		switch {
+		case len(f) == 1 && f[0] == "PONG":
+		case len(f) == 3 && f[0] == "PUB":
+			size, _ := strconv.Atoi(f[2])
+			data := make([]byte, size+2)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return
+			}
+			msgs <- f[1] + " " + string(data[:size])
+			n++
+		default:
+			return
+		}
+	}
+}
+
+// TestPublish publishes through a reconnect and checks that messages the server would refuse
+// are dropped rather than retried for ever.
+func TestPublish(t *testing.T) {
+	addr, msgs := fakeNATS(t, 1)
+	p, err := New("nats://fg:s3cret@"+addr, t.Logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	receive := func(want string) {
+		t.Helper()
+		select {
+		case got := <-msgs:
+			if got != want {
+				t.Fatalf("got %q, want %q", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+	p.Publish("filegoblin.upload", []byte(strings.Repeat("x", 100))) // over max_payload
+	p.Publish("filegoblin.upload", []byte(`{"n":1}`))
+	receive(`filegoblin.upload {"n":1}`)
+	time.Sleep(100 * time.Millisecond) // for the publisher to see the server hang up
+	p.Publish("filegoblin.delete", []byte(`{"n":2}`))
+	receive(`filegoblin.delete {"n":2}`)
+
+	if _, err := New("http://"+addr, t.Logf); err == nil {
+		t.Fatal("accepted a URL that isn't nats:// or tls://")
+	}
+}
+
+// TestBadLogin keeps the message queued while the server refuses the login.
+func TestBadLogin(t *testing.T) {
+	addr, msgs := fakeNATS(t, 1)
+	p, err := New("nats://fg:wrong@"+addr, t.Logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Publish("filegoblin.upload", []byte("{}"))
+	select {
+	case got := <-msgs:
+		t.Fatalf("published with a refused login: %q", got)
+	case <-time.After(300 * time.Millisecond):
+	}
+	p.Close()
+}
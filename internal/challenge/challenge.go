@@ -0,0 +1,224 @@
+// Package challenge checks that a request comes from a person, or at least from someone
+// willing to spend some CPU: a CAPTCHA token verified with hCaptcha or Cloudflare Turnstile,
+// or a hashcash-style proof of work.
+package challenge
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/bits"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrMissing  = errors.New("challenge: no response given")
+	ErrRejected = errors.New("challenge: response rejected")
+	ErrExpired  = errors.New("challenge: puzzle expired")
+	ErrReplayed = errors.New("challenge: puzzle already used")
+)
+
+// VerifyURLs are the providers' token verification endpoints.
+var VerifyURLs = map[string]string{
+	"hcaptcha":  "https://api.hcaptcha.com/siteverify",
+	"turnstile": "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+}
+
+// Captcha verifies widget tokens with the provider. Both providers speak the same protocol:
+// a form POST of secret, response and remoteip, answered with {"success": bool}.
+type Captcha struct {
+	URL     string // verification endpoint, normally from VerifyURLs
+	Secret  string
+	SiteKey string
+	Client  *http.Client
+}
+
+// Verify asks the provider whether token is a fresh, valid solution. Errors other than
+// ErrMissing and ErrRejected mean the provider couldn't be asked.
+func (c *Captcha) Verify(ctx context.Context, token, remoteIP string) error {
+	if token == "" {
+		return ErrMissing
+	}
+	form := url.Values{"secret": {c.Secret}, "response": {token}}
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+	if c.SiteKey != "" {
+		form.Set("sitekey", c.SiteKey) // hCaptcha checks it matches; Turnstile ignores it
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("challenge: verify: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("challenge: verify: provider returned %s", resp.Status)
+	}
+	var out struct {
+		Success bool `json:"success"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return fmt.Errorf("challenge: verify: %w", err)
+	}
+	if !out.Success {
+		return ErrRejected
+	}
+	return nil
+}
+
+// PoW hands out proof-of-work puzzles and checks their solutions. Puzzles are signed rather
+// than stored, so issuing one costs nothing; only solved ones are remembered, until they
+// expire, so each can be spent once. Without a secret the signing key is random and lives in
+// memory: a restart simply invalidates the puzzles in flight. Servers given the same secret
+// accept each other's puzzles; a Ledger they share, set with Share, keeps a solution from
+// being spent once with each of them.
+//
+// A puzzle is "<payload>.<mac>", where the payload carries its expiry and difficulty. The
+// solution is a nonce such that SHA-256("<puzzle>:<nonce>") starts with difficulty zero bits,
+// and the response sent back is "<puzzle>:<nonce>".
+type PoW struct {
+	key    []byte
+	mu     sync.Mutex
+	used   map[string]time.Time // spent puzzles and when they expire
+	ledger Ledger               // where the other servers see them too; nil for none
+}
+
+// Ledger records spent puzzles where every server checking them sees them, like the database
+// the servers of a cluster share.
+type Ledger interface {
+	// Spend records key as spent until exp, and reports whether it wasn't already.
+	Spend(key string, exp time.Time) (bool, error)
+}
+
+// NewPoW returns a PoW signing with a key derived from secret, or with a fresh random key
+// when secret is empty.
+func NewPoW(secret string) *PoW {
+	key := make([]byte, 32)
+	if secret == "" {
+		_, _ = rand.Read(key)
+	} else {
+		sum := sha256.Sum256([]byte("filegoblin pow:" + secret))
+		key = sum[:]
+	}
+	return &PoW{key: key, used: map[string]time.Time{}}
+}
+
+// Share makes p record the puzzles spent with it in l too, and refuse those spent there by
+// other servers. Call it before p checks any.
+func (p *PoW) Share(l Ledger) { p.ledger = l }
+
+func (p *PoW) mac(payload string) string {
+	m := hmac.New(sha256.New, p.key)
+	m.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil)[:16])
+}
+
+// Issue returns a new puzzle of the given difficulty that can be solved until now+ttl.
+func (p *PoW) Issue(difficulty int, ttl time.Duration, now time.Time) string {
+	raw := make([]byte, 8+1+8)
+	binary.BigEndian.PutUint64(raw, uint64(now.Add(ttl).Unix()))
+	raw[8] = byte(difficulty)
+	_, _ = rand.Read(raw[9:])
+	payload := base64.RawURLEncoding.EncodeToString(raw)
+	return payload + "." + p.mac(payload)
+}
+
+// Verify checks a "<puzzle>:<nonce>" response against the difficulty required now, which
+// may have been raised since the puzzle was issued.
+func (p *PoW) Verify(response string, difficulty int, now time.Time) error {
+	if response == "" {
+		return ErrMissing
+	}
+	puzzle, nonce, ok := strings.Cut(response, ":")
+	payload, mac, ok2 := strings.Cut(puzzle, ".")
+	if !ok || !ok2 || !hmac.Equal([]byte(mac), []byte(p.mac(payload))) {
+		return ErrRejected
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil || len(raw) != 17 {
+		return ErrRejected
+	}
+	exp := time.Unix(int64(binary.BigEndian.Uint64(raw)), 0)
+	if now.After(exp) {
+		return ErrExpired
+	}
+	if int(raw[8]) < difficulty || leadingZeros(puzzle, nonce) < int(raw[8]) {
+		return ErrRejected
+	}
+
+	p.mu.Lock()
+	for k, e := range p.used {
+		if now.After(e) {
+			delete(p.used, k)
+		}
+	}
+	_, seen := p.used[puzzle]
+	if !seen {
+		p.used[puzzle] = exp
+	}
+	p.mu.Unlock()
+	if seen {
+		return ErrReplayed
+	}
+	if p.ledger == nil {
+		return nil
+	}
+	fresh, err := p.ledger.Spend(puzzle, exp)
+	if err != nil {
+		p.mu.Lock()
+		delete(p.used, puzzle) // not spent after all: it may be tried again
+		p.mu.Unlock()
+		return fmt.Errorf("challenge: record spent puzzle: %w", err)
+	}
+	if !fresh {
+		return ErrReplayed
+	}
+	return nil
+}
+
+// Solve finds a nonce for puzzle and returns the complete response. Each extra bit of
+// difficulty doubles the expected work; 18 bits takes a fraction of a second natively.
+func Solve(ctx context.Context, puzzle string, difficulty int) (string, error) {
+	for n := uint64(0); ; n++ {
+		nonce := strconv.FormatUint(n, 10)
+		if leadingZeros(puzzle, nonce) >= difficulty {
+			return puzzle + ":" + nonce, nil
+		}
+		if n%(1<<16) == 0 && ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+	}
+}
+
+// leadingZeros counts the zero bits at the start of SHA-256("<puzzle>:<nonce>").
+func leadingZeros(puzzle, nonce string) int {
+	sum := sha256.Sum256([]byte(puzzle + ":" + nonce))
+	n := 0
+	for _, b := range sum {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
@@ -0,0 +1,110 @@
+package challenge
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestPoW solves a puzzle and checks that the answer is accepted exactly once, and that
+// tampered, under-difficulty and expired answers are refused.
+func TestPoW(t *testing.T) {
+	p := NewPoW("")
+	now := time.Now()
+	puzzle := p.Issue(8, time.Minute, now)
+	resp, err := Solve(context.Background(), puzzle, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Verify(resp, 12, now); err != ErrRejected {
+		t.Fatalf("puzzle easier than required: got %v", err)
+	}
+	if err := p.Verify(resp, 8, now.Add(2*time.Minute)); err != ErrExpired {
+		t.Fatalf("expired puzzle: got %v", err)
+	}
+	if err := p.Verify("x"+resp, 8, now); err != ErrRejected {
+		t.Fatalf("tampered puzzle: got %v", err)
+	}
+	if err := p.Verify(resp, 8, now); err != nil {
+		t.Fatalf("valid answer: %v", err)
+	}
+	if err := p.Verify(resp, 8, now); err != ErrReplayed {
+		t.Fatalf("replayed answer: got %v", err)
+	}
+	if err := NewPoW("").Verify(resp, 8, now); err != ErrRejected {
+		t.Fatalf("puzzle from another key: got %v", err)
+	}
+
+	// servers sharing a secret accept each other's puzzles
+	puzzle = NewPoW("shared").Issue(8, time.Minute, now)
+	if resp, err = Solve(context.Background(), puzzle, 8); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewPoW("other").Verify(resp, 8, now); err != ErrRejected {
+		t.Fatalf("puzzle from another secret: got %v", err)
+	}
+	if err := NewPoW("shared").Verify(resp, 8, now); err != nil {
+		t.Fatalf("puzzle from a server with the same secret: %v", err)
+	}
+}
+
+// ledger is a Ledger held in memory, as a database shared by servers would hold it.
+type ledger map[string]time.Time
+
+func (l ledger) Spend(key string, exp time.Time) (bool, error) {
+	if _, ok := l[key]; ok {
+		return false, nil
+	}
+	l[key] = exp
+	return true, nil
+}
+
+// TestPoWShared checks that servers sharing a ledger accept a solution only once between
+// them.
+func TestPoWShared(t *testing.T) {
+	now := time.Now()
+	spent := ledger{}
+	a, b := NewPoW("shared"), NewPoW("shared")
+	a.Share(spent)
+	b.Share(spent)
+	resp, err := Solve(context.Background(), a.Issue(8, time.Minute, now), 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Verify(resp, 8, now); err != nil {
+		t.Fatalf("valid answer: %v", err)
+	}
+	if err := b.Verify(resp, 8, now); err != ErrReplayed {
+		t.Fatalf("answer replayed against another server: got %v", err)
+	}
+}
+
+// TestCaptcha verifies tokens against a fake provider endpoint.
+func TestCaptcha(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		ok := r.PostForm.Get("secret") == "s3cret" && r.PostForm.Get("response") == "good"
+		_ = json.NewEncoder(w).Encode(map[string]bool{"success": ok})
+	}))
+	defer srv.Close()
+	c := &Captcha{URL: srv.URL, Secret: "s3cret"}
+	ctx := context.Background()
+
+	if err := c.Verify(ctx, "good", "192.0.2.1"); err != nil {
+		t.Fatalf("good token: %v", err)
+	}
+	if err := c.Verify(ctx, "bad", ""); err != ErrRejected {
+		t.Fatalf("bad token: got %v", err)
+	}
+	if err := c.Verify(ctx, "", ""); err != ErrMissing {
+		t.Fatalf("no token: got %v", err)
+	}
+	srv.Close()
+	if err := c.Verify(ctx, "good", ""); err == nil || err == ErrRejected {
+		t.Fatalf("provider down: got %v", err)
+	}
+}
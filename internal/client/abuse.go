@@ -0,0 +1,54 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/hey-granth/filegoblin/internal/abuse"
+)
+
+// Report files an abuse report against file id. query carries the share link's signature, if
+// it has one, since the server only takes reports from people who can see the file.
+func (c *Client) Report(ctx context.Context, id, query, reason, details, contact string) (string, error) {
+	path := "/api/files/" + escape(id) + "/report"
+	if query != "" {
+		path += "?" + query
+	}
+	var out struct {
+		ID string `json:"id"`
+	}
+	err := c.do(ctx, http.MethodPost, path, map[string]string{"reason": reason, "details": details, "contact": contact}, &out)
+	return out.ID, err
+}
+
+// Notices lists the takedown notices for the caller's files.
+func (c *Client) Notices(ctx context.Context) ([]abuse.Notice, error) {
+	var out []abuse.Notice
+	err := c.do(ctx, http.MethodGet, "/api/notices", nil, &out)
+	return out, err
+}
+
+// Reports lists the open abuse reports, or every report when all is set.
+func (c *Client) Reports(ctx context.Context, all bool) ([]abuse.Report, error) {
+	path := "/admin/reports"
+	if all {
+		path += "?all=1"
+	}
+	var out []abuse.Report
+	err := c.do(ctx, http.MethodGet, path, nil, &out)
+	return out, err
+}
+
+// Takedown takes the file behind report id down and notifies its uploader.
+func (c *Client) Takedown(ctx context.Context, id, note string) (abuse.Report, error) {
+	var out abuse.Report
+	err := c.do(ctx, http.MethodPost, "/admin/reports/"+escape(id)+"/takedown", map[string]string{"note": note}, &out)
+	return out, err
+}
+
+// DismissReport closes report id without acting on it.
+func (c *Client) DismissReport(ctx context.Context, id, note string) (abuse.Report, error) {
+	var out abuse.Report
+	err := c.do(ctx, http.MethodPost, "/admin/reports/"+escape(id)+"/dismiss", map[string]string{"note": note}, &out)
+	return out, err
+}
@@ -0,0 +1,152 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/auth"
+	"github.com/hey-granth/filegoblin/internal/logx"
+	"github.com/hey-granth/filegoblin/internal/metadata"
+	"github.com/hey-granth/filegoblin/internal/signing"
+	"github.com/hey-granth/filegoblin/internal/webhook"
+)
+
+// UserInfo is a user as reported by the admin API, including current usage.
+type UserInfo struct {
+	auth.User
+	Used int64 `json:"used"`
+}
+
+// Users lists every user with their usage.
+func (c *Client) Users(ctx context.Context) ([]UserInfo, error) {
+	var out []UserInfo
+	err := c.do(ctx, http.MethodGet, "/admin/users", nil, &out)
+	return out, err
+}
+
+// AddUser creates a user with a quota in bytes (0 = unlimited).
+func (c *Client) AddUser(ctx context.Context, name string, quota int64) (UserInfo, error) {
+	var out UserInfo
+	err := c.do(ctx, http.MethodPost, "/admin/users", map[string]interface{}{"name": name, "quota": quota}, &out)
+	return out, err
+}
+
+// RemoveUser deletes a user and revokes their keys.
+func (c *Client) RemoveUser(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, "/admin/users/"+escape(name), nil, nil)
+}
+
+// SetQuota changes a user's quota in bytes (0 = unlimited).
+func (c *Client) SetQuota(ctx context.Context, name string, quota int64) (UserInfo, error) {
+	var out UserInfo
+	err := c.do(ctx, http.MethodPut, "/admin/users/"+escape(name)+"/quota", map[string]int64{"quota": quota}, &out)
+	return out, err
+}
+
+// Keys lists a user's API keys.
+func (c *Client) Keys(ctx context.Context, user string) ([]auth.Key, error) {
+	var out []auth.Key
+	err := c.do(ctx, http.MethodGet, "/admin/users/"+escape(user)+"/keys", nil, &out)
+	return out, err
+}
+
+// CreateKey issues a key for user. The returned secret is shown exactly once.
+func (c *Client) CreateKey(ctx context.Context, user, label string) (auth.NewKey, error) {
+	var out auth.NewKey
+	err := c.do(ctx, http.MethodPost, "/admin/users/"+escape(user)+"/keys", map[string]string{"label": label}, &out)
+	return out, err
+}
+
+// RevokeKey disables a key by ID.
+func (c *Client) RevokeKey(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/admin/keys/"+escape(id), nil, nil)
+}
+
+// SigningKeys lists the link signing keys, without their secrets.
+func (c *Client) SigningKeys(ctx context.Context) ([]signing.Key, error) {
+	var out []signing.Key
+	err := c.do(ctx, http.MethodGet, "/admin/signing-keys", nil, &out)
+	return out, err
+}
+
+// RotateSigningKey makes the server sign new links with a fresh key.
+func (c *Client) RotateSigningKey(ctx context.Context) (signing.Key, error) {
+	var out signing.Key
+	err := c.do(ctx, http.MethodPost, "/admin/signing-keys/rotate", nil, &out)
+	return out, err
+}
+
+// Stats is the admin dashboard's overview of a server.
+type Stats struct {
+	Files        int                   `json:"files"`
+	Bytes        int64                 `json:"bytes"`
+	Users        int                   `json:"users"`
+	OpenReports  int                   `json:"open_reports"`
+	TopConsumers []metadata.OwnerUsage `json:"top_consumers"`
+	Transfers    []Transfer            `json:"transfers"`
+	RecentErrors []logx.Entry          `json:"recent_errors"` // newest first
+	Lifecycle    LifecycleStats        `json:"lifecycle"`
+}
+
+// Transfer is an upload or download in progress.
+type Transfer struct {
+	ID        int64     `json:"id"`
+	Kind      string    `json:"kind"` // "upload" or "download"
+	File      string    `json:"file"` // the file ID of a download, the name of an upload
+	Owner     string    `json:"owner,omitempty"`
+	Remote    string    `json:"remote"`
+	StartedAt time.Time `json:"started_at"`
+	Size      int64     `json:"size"` // -1 when not known up front
+	Bytes     int64     `json:"bytes"`
+}
+
+// LifecycleStats sums up the lifecycle sweeps since the server started.
+type LifecycleStats struct {
+	LastSweep    *time.Time `json:"last_sweep,omitempty"`
+	LastExpired  int        `json:"last_expired"`
+	TotalExpired int        `json:"total_expired"`
+}
+
+// Stats fetches the dashboard overview.
+func (c *Client) Stats(ctx context.Context) (Stats, error) {
+	var out Stats
+	err := c.do(ctx, http.MethodGet, "/admin/stats", nil, &out)
+	return out, err
+}
+
+// WebhookDeliveries lists the server's latest webhook delivery attempts, newest first.
+func (c *Client) WebhookDeliveries(ctx context.Context) ([]webhook.Delivery, error) {
+	var out []webhook.Delivery
+	err := c.do(ctx, http.MethodGet, "/admin/webhooks/deliveries", nil, &out)
+	return out, err
+}
+
+// Logs returns the server's latest log messages at level and above, oldest first: all it
+// keeps when limit is 0, or else the last limit of them.
+func (c *Client) Logs(ctx context.Context, level string, limit int) ([]logx.Line, error) {
+	q := url.Values{}
+	if level != "" {
+		q.Set("level", level)
+	}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	path := "/admin/logs"
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+	var out []logx.Line
+	err := c.do(ctx, http.MethodGet, path, nil, &out)
+	return out, err
+}
+
+// TestWebhooks has the server send a test event to its webhooks, or only to the one at url
+// when it isn't empty, and reports how each delivery went.
+func (c *Client) TestWebhooks(ctx context.Context, url string) ([]webhook.Delivery, error) {
+	var out []webhook.Delivery
+	err := c.do(ctx, http.MethodPost, "/admin/webhooks/test", map[string]string{"url": url}, &out)
+	return out, err
+}
@@ -0,0 +1,46 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/hey-granth/filegoblin/internal/challenge"
+)
+
+// Challenge is what the server wants solved before a request without an API key.
+type Challenge struct {
+	Provider   string `json:"provider"` // "", "hcaptcha", "turnstile" or "pow"
+	SiteKey    string `json:"site_key,omitempty"`
+	Puzzle     string `json:"puzzle,omitempty"`
+	Difficulty int    `json:"difficulty,omitempty"`
+}
+
+// Challenge fetches a fresh challenge from the server.
+func (c *Client) Challenge(ctx context.Context) (Challenge, error) {
+	var out Challenge
+	err := c.do(ctx, http.MethodGet, "/api/challenge", nil, &out)
+	return out, err
+}
+
+// SolveChallenge prepares the client for one request without a token: it solves the
+// server's proof-of-work puzzle, if it sets one, and stores the answer in ChallengeResponse.
+// Puzzles are single-use, so call it again before every such request. CAPTCHAs need a
+// person and a browser, so those are an error.
+func (c *Client) SolveChallenge(ctx context.Context) error {
+	if c.Token != "" {
+		return nil
+	}
+	ch, err := c.Challenge(ctx)
+	if err != nil {
+		return err
+	}
+	switch ch.Provider {
+	case "":
+		return nil
+	case "pow":
+		c.ChallengeResponse, err = challenge.Solve(ctx, ch.Puzzle, ch.Difficulty)
+		return err
+	}
+	return fmt.Errorf("the server asks for a %s CAPTCHA without an API key: use --token, or the web UI", ch.Provider)
+}
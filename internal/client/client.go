@@ -0,0 +1,139 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client talks to a filegoblin server's HTTP API on behalf of the CLI.
+type Client struct {
+	BaseURL string // e.g. "https://files.example.com"
+	Token   string // API key or admin key, sent as a bearer token
+	HTTP    *http.Client
+
+	// ChallengeResponse is sent with requests made without a token, on servers that ask for
+	// a CAPTCHA or proof of work (see Challenge and SolveChallenge).
+	ChallengeResponse string
+
+	// FilePassword is sent with every request when set: uploads protect the new file with
+	// it, and stat and download use it to open a protected file.
+	FilePassword string
+
+	// Expires is sent with uploads: how long the server keeps the new file, like "24h" or
+	// "7d". Empty leaves it to the server's lifecycle settings.
+	Expires string
+
+	// MaxDownloads is sent with uploads: how many downloads the new file may have before the
+	// server deletes it. 0 sets no limit.
+	MaxDownloads int
+
+	// Description and Tags are sent with uploads, for people and searches to find the new
+	// file by.
+	Description string
+	Tags        []string
+
+	// Meta is sent with uploads: entries of the new file's own metadata, which downloads of
+	// it carry in X-Goblin-Meta-* headers.
+	Meta map[string]string
+
+	// StripMetadata asks the server to remove EXIF and other metadata from uploaded images,
+	// where its policy leaves that to the uploader.
+	StripMetadata bool
+}
+
+// New returns a client for the server at baseURL.
+func New(baseURL, token string) *Client {
+	return &Client{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		Token:   token,
+		// no overall timeout: a multi-gigabyte transfer may legitimately take hours. We only
+		// bound how long the server may sit on a request before it starts answering.
+		HTTP: &http.Client{Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			ResponseHeaderTimeout: 60 * time.Second,
+		}},
+	}
+}
+
+// APIError is a non-2xx answer from the server, carrying its {"error": "..."} message.
+type APIError struct {
+	Status  int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("server returned %d: %s", e.Status, e.Message)
+}
+
+// do sends a JSON request and decodes a JSON answer into out (if non-nil).
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.send(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return decodeJSON(resp.Body, out)
+}
+
+func decodeJSON(r io.Reader, out interface{}) error {
+	if err := json.NewDecoder(r).Decode(out); err != nil {
+		return fmt.Errorf("decode server response: %w", err)
+	}
+	return nil
+}
+
+// send adds auth, performs the request and turns error statuses into *APIError.
+// On success the caller owns resp.Body.
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	} else if c.ChallengeResponse != "" {
+		req.Header.Set("X-Challenge-Response", c.ChallengeResponse)
+	}
+	if c.FilePassword != "" {
+		req.Header.Set("X-File-Password", c.FilePassword)
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		var e struct {
+			Error string `json:"error"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if json.Unmarshal(data, &e) != nil || e.Error == "" {
+			e.Error = strings.TrimSpace(string(data))
+		}
+		return nil, &APIError{Status: resp.StatusCode, Message: e.Error}
+	}
+	return resp, nil
+}
+
+func escape(s string) string { return url.PathEscape(s) }
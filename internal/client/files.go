@@ -0,0 +1,168 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/metadata"
+)
+
+// File is a stored file as the API reports it.
+type File struct {
+	ID           string             `json:"id"`
+	Name         string             `json:"name"`
+	Size         int64              `json:"size"`
+	ContentType  string             `json:"content_type"`
+	SHA256       string             `json:"sha256,omitempty"`
+	Encrypted    bool               `json:"encrypted,omitempty"`
+	Owner        string             `json:"owner,omitempty"`
+	CreatedAt    time.Time          `json:"created_at"`
+	URL          string             `json:"url"`
+	LinkExpires  *time.Time         `json:"link_expires_at,omitempty"` // when the signed URL stops working
+	TakenDown    *metadata.Takedown `json:"takedown,omitempty"`
+	Protected    bool               `json:"password_protected,omitempty"` // downloads need the file's password
+	DLP          []string           `json:"dlp,omitempty"`                // sensitive content the server's DLP rules tagged
+	ExpiresAt    *time.Time         `json:"expires_at,omitempty"`         // when the server deletes the file
+	MaxDownloads int                `json:"max_downloads,omitempty"`      // the server deletes the file after this many downloads
+	Downloads    int                `json:"downloads,omitempty"`          // so far, when there's a limit
+	Thumbnail    string             `json:"thumbnail_url,omitempty"`      // a small preview, for images
+	Poster       string             `json:"poster_url,omitempty"`         // a still frame, for videos
+	Clip         string             `json:"clip_url,omitempty"`           // a short preview clip, for videos
+	Share        string             `json:"share_url,omitempty"`          // a landing page for people, when the server has its web UI on
+	Preview      string             `json:"preview_url,omitempty"`        // a page showing the file in the browser, for text, CSV and PDF
+	SlowStart    bool               `json:"slow_start,omitempty"`         // an MP4 that browsers can only play once they have its end
+	Stripped     bool               `json:"metadata_stripped,omitempty"`  // the server removed the image's EXIF and other metadata
+	Path         string             `json:"path,omitempty"`               // where an imported file was in the tree it came from
+	ModTime      *time.Time         `json:"mod_time,omitempty"`           // when an imported file was last modified before the import
+	Description  string             `json:"description,omitempty"`
+	Tags         []string           `json:"tags,omitempty"`
+	Meta         map[string]string  `json:"meta,omitempty"`
+}
+
+// Upload streams r to the server as a file called name. size is sent as Content-Length when
+// known; pass -1 for streams of unknown length (like stdin), which go out chunked.
+func (c *Client) Upload(ctx context.Context, name string, r io.Reader, size int64) (File, error) {
+	return c.upload(ctx, url.Values{"name": {name}}, r, size)
+}
+
+// UploadEncrypted is Upload for content and a name already encrypted with package e2e. The
+// server stores such files as opaque blobs and links them to its in-browser decryption page.
+func (c *Client) UploadEncrypted(ctx context.Context, name string, r io.Reader, size int64) (File, error) {
+	return c.upload(ctx, url.Values{"name": {name}, "e2e": {"1"}}, r, size)
+}
+
+func (c *Client) upload(ctx context.Context, query url.Values, r io.Reader, size int64) (File, error) {
+	if c.Expires != "" {
+		query.Set("expires", c.Expires)
+	}
+	if c.MaxDownloads > 0 {
+		query.Set("max_downloads", strconv.Itoa(c.MaxDownloads))
+	}
+	if c.Description != "" {
+		query.Set("description", c.Description)
+	}
+	if len(c.Tags) > 0 {
+		query.Set("tags", strings.Join(c.Tags, ","))
+	}
+	if len(c.Meta) > 0 {
+		meta, err := json.Marshal(c.Meta)
+		if err != nil {
+			return File{}, err
+		}
+		query.Set("meta", string(meta))
+	}
+	if c.StripMetadata {
+		query.Set("strip", "1")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/api/files?"+query.Encode(), r)
+	if err != nil {
+		return File{}, err
+	}
+	req.ContentLength = size
+	if size < 0 {
+		req.ContentLength = -1
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := c.send(req)
+	if err != nil {
+		return File{}, err
+	}
+	defer resp.Body.Close()
+	var f File
+	err = decodeJSON(resp.Body, &f)
+	return f, err
+}
+
+// Download is an open download: read Body, then close it.
+type Download struct {
+	Body        io.ReadCloser
+	Name        string // from Content-Disposition
+	Size        int64  // from Content-Length, -1 when the server didn't say
+	ContentType string
+	SHA256      string // from X-Checksum-SHA256; the checksum of the bytes sent, empty if unknown
+}
+
+// Download starts fetching file id. query carries the signature of a signed link, if there
+// is one. The body is streamed, not buffered.
+func (c *Client) Download(ctx context.Context, id, query string) (*Download, error) {
+	u := c.BaseURL + "/f/" + escape(id)
+	if query != "" {
+		u += "?" + query
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.send(req)
+	if err != nil {
+		return nil, err
+	}
+	d := &Download{
+		Body:        resp.Body,
+		Size:        resp.ContentLength,
+		ContentType: resp.Header.Get("Content-Type"),
+		SHA256:      resp.Header.Get("X-Checksum-SHA256"),
+	}
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		d.Name = params["filename"]
+	}
+	return d, nil
+}
+
+// Stat fetches a file's record without downloading it.
+func (c *Client) Stat(ctx context.Context, id string) (File, error) {
+	var f File
+	err := c.do(ctx, http.MethodGet, "/api/files/"+escape(id), nil, &f)
+	return f, err
+}
+
+// Verification is the server's answer to a verify request.
+type Verification struct {
+	ID       string `json:"id"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+	Bytes    int64  `json:"bytes"`
+	OK       bool   `json:"ok"`
+}
+
+// Verify asks the server to reread file id and check it against its stored checksum.
+func (c *Client) Verify(ctx context.Context, id string) (Verification, error) {
+	var v Verification
+	err := c.do(ctx, http.MethodPost, "/api/files/"+escape(id)+"/verify", nil, &v)
+	return v, err
+}
+
+// Fetch asks the server to download url and store it as a file. An empty name lets the
+// server pick one from the response.
+func (c *Client) Fetch(ctx context.Context, url, name string) (File, error) {
+	var f File
+	err := c.do(ctx, http.MethodPost, "/api/fetch", map[string]string{"url": url, "name": name}, &f)
+	return f, err
+}
@@ -0,0 +1,147 @@
+// Package cluster lets several servers share one data directory, on NFS or another shared
+// filesystem. Requests can go to any of them; the background jobs (the lifecycle sweep and
+// signing key rotation) must only run in one place, so the servers elect a leader with a lease
+// kept in the directory. The leader renews it well before it runs out; when the leader stops
+// or dies, another server takes the lease over once it has expired.
+//
+// Expiry is judged by each server's own clock, so the servers' clocks must agree to within a
+// small part of the lease's length.
+package cluster
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrBusy is returned when a lock stays held by another server for too long.
+var ErrBusy = errors.New("cluster: locked by another server")
+
+// State is what the lease file holds.
+type State struct {
+	Node    string    `json:"node"`
+	Expires time.Time `json:"expires"`
+}
+
+// Held reports whether the lease belongs to someone at now.
+func (s State) Held(now time.Time) bool { return s.Node != "" && now.Before(s.Expires) }
+
+// Lease is one server's handle on the shared lease.
+type Lease struct {
+	dir  string
+	node string
+	ttl  time.Duration
+}
+
+// New returns node's handle on the lease kept in dir. A lease taken or renewed lasts ttl.
+func New(dir, node string, ttl time.Duration) *Lease {
+	return &Lease{dir: dir, node: node, ttl: ttl}
+}
+
+func (l *Lease) path() string { return filepath.Join(l.dir, "lease.json") }
+
+// Acquire takes the lease for this node if it is free or has expired, renews it if the node
+// already holds it, and reports whether the node holds it now.
+func (l *Lease) Acquire(now time.Time) (bool, error) {
+	ok := false
+	err := l.locked(func() error {
+		cur, err := l.read()
+		if err != nil {
+			return err
+		}
+		if cur.Node != l.node && cur.Held(now) {
+			return nil
+		}
+		ok = true
+		return l.write(State{Node: l.node, Expires: now.Add(l.ttl)})
+	})
+	return ok, err
+}
+
+// Release gives the lease up if this node holds it, so another server can take over at once
+// instead of waiting for it to expire.
+func (l *Lease) Release() error {
+	return l.locked(func() error {
+		cur, err := l.read()
+		if err != nil || cur.Node != l.node {
+			return err
+		}
+		return l.write(State{})
+	})
+}
+
+// Holder returns who holds the lease, as last written.
+func (l *Lease) Holder() (State, error) { return l.read() }
+
+func (l *Lease) read() (State, error) {
+	var s State
+	data, err := os.ReadFile(l.path())
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return s, fmt.Errorf("parse %s: %w", l.path(), err)
+	}
+	return s, nil
+}
+
+func (l *Lease) write(s State) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp := l.path() + "." + l.node + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.path())
+}
+
+// locked runs fn while holding the lease file's lock.
+func (l *Lease) locked(fn func() error) error {
+	unlock, err := Lock(l.dir, "lease", l.ttl, time.Second)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return fn()
+}
+
+// Lock takes the lock called name in dir, for a change that several servers might make at
+// the same time, and returns the function that releases it. It waits up to wait while another
+// server holds the lock, then returns ErrBusy. A lock older than stale was left behind by a
+// server that died holding it and is broken.
+//
+// The lock is a directory, because creating one is atomic on every filesystem worth sharing,
+// NFS included, where file locks often aren't.
+func Lock(dir, name string, stale, wait time.Duration) (func(), error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	lock := filepath.Join(dir, name+".lock")
+	deadline := time.Now().Add(wait)
+	for {
+		err := os.Mkdir(lock, 0o750)
+		if err == nil {
+			return func() { _ = os.Remove(lock) }, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+		if fi, err := os.Stat(lock); err == nil && time.Since(fi.ModTime()) > stale {
+			_ = os.Remove(lock)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, ErrBusy
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,92 @@
+package cluster
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestLease passes the lease between two servers: only one holds it at a time, the holder
+// renews it, and the other takes over once it expires or is released.
+func TestLease(t *testing.T) {
+	dir := t.TempDir()
+	a := New(dir, "a", time.Minute)
+	b := New(dir, "b", time.Minute)
+	now := time.Now()
+
+	if ok, err := a.Acquire(now); err != nil || !ok {
+		t.Fatalf("a on a free lease: %v, %v", ok, err)
+	}
+	if ok, err := b.Acquire(now.Add(time.Second)); err != nil || ok {
+		t.Fatalf("b while a holds it: %v, %v", ok, err)
+	}
+	if ok, err := a.Acquire(now.Add(50 * time.Second)); err != nil || !ok {
+		t.Fatalf("a renewing: %v, %v", ok, err)
+	}
+	if ok, _ := b.Acquire(now.Add(90 * time.Second)); ok {
+		t.Fatal("b took a renewed lease before it expired")
+	}
+	if ok, err := b.Acquire(now.Add(2 * time.Minute)); err != nil || !ok {
+		t.Fatalf("b after a's lease expired: %v, %v", ok, err)
+	}
+	if s, err := a.Holder(); err != nil || s.Node != "b" {
+		t.Fatalf("holder: %+v, %v", s, err)
+	}
+
+	if err := a.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := a.Holder(); s.Node != "b" {
+		t.Fatal("a released a lease it didn't hold")
+	}
+	if err := b.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := a.Acquire(now.Add(2*time.Minute + time.Second)); err != nil || !ok {
+		t.Fatalf("a after b released: %v, %v", ok, err)
+	}
+}
+
+// TestStaleLock breaks a lock left behind by a server that died holding it.
+func TestStaleLock(t *testing.T) {
+	dir := t.TempDir()
+	l := New(dir, "a", time.Second)
+	lock := filepath.Join(dir, "lease.lock")
+	if err := os.Mkdir(lock, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Minute)
+	if err := os.Chtimes(lock, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := l.Acquire(time.Now()); err != nil || !ok {
+		t.Fatalf("acquire past a stale lock: %v, %v", ok, err)
+	}
+	if _, err := os.Stat(lock); !os.IsNotExist(err) {
+		t.Fatalf("lock left behind: %v", err)
+	}
+}
+
+// TestLock keeps a second taker out until the first releases the lock.
+func TestLock(t *testing.T) {
+	dir := t.TempDir()
+	unlock, err := Lock(dir, "file-abc", time.Minute, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Lock(dir, "file-abc", time.Minute, 100*time.Millisecond); err != ErrBusy {
+		t.Fatalf("second lock while held: got %v, want ErrBusy", err)
+	}
+	other, err := Lock(dir, "file-abd", time.Minute, 0)
+	if err != nil {
+		t.Fatalf("lock with another name: %v", err)
+	}
+	other()
+	unlock()
+	again, err := Lock(dir, "file-abc", time.Minute, 0)
+	if err != nil {
+		t.Fatalf("lock after release: %v", err)
+	}
+	again()
+}
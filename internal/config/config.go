@@ -0,0 +1,1271 @@
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/auth"
+	"github.com/hey-granth/filegoblin/internal/logx"
+	"github.com/hey-granth/filegoblin/internal/metadata"
+	"github.com/hey-granth/filegoblin/internal/storage"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is everything the long-running server needs to know. It is loaded from a YAML file
+// and every field has a sensible default, so an empty (or missing) file still gives a working server.
+type Config struct {
+	Listen     string     `yaml:"listen"`     // address the HTTP server binds to, e.g. ":8080"
+	DataDir    string     `yaml:"data_dir"`   // where blobs and metadata live on disk
+	PublicURL  string     `yaml:"public_url"` // base URL used when building share links; derived from the request when empty
+	Storage    Storage    `yaml:"storage"`
+	Metadata   Metadata   `yaml:"metadata"`
+	Limits     Limits     `yaml:"limits"`
+	Auth       Auth       `yaml:"auth"`
+	TLS        TLS        `yaml:"tls"`
+	Lifecycle  Lifecycle  `yaml:"lifecycle"`
+	Versions   Versions   `yaml:"versions"`
+	UI         UI         `yaml:"ui"`
+	Scan       Scan       `yaml:"scan"`
+	Links      Links      `yaml:"links"`
+	Headers    Headers    `yaml:"headers"`
+	Fetch      Fetch      `yaml:"fetch"`
+	Integrity  Integrity  `yaml:"integrity"`
+	Challenge  Challenge  `yaml:"challenge"`
+	Types      Types      `yaml:"types"`
+	Passwords  Passwords  `yaml:"passwords"`
+	DLP        DLP        `yaml:"dlp"`
+	Images     Images     `yaml:"images"`
+	Thumbnails Thumbnails `yaml:"thumbnails"`
+	Media      Media      `yaml:"media"`
+	Preview    Preview    `yaml:"preview"`
+	Branding   Branding   `yaml:"branding"`
+	Webhooks   Webhooks   `yaml:"webhooks"`
+	Bus        Bus        `yaml:"bus"`
+	Plugins    []Plugin   `yaml:"plugins"`
+	Cluster    Cluster    `yaml:"cluster"`
+	Backup     Backup     `yaml:"backup"`
+	Log        Log        `yaml:"log"`
+	Audit      Audit      `yaml:"audit"`
+	Metrics    Metrics    `yaml:"metrics"`
+	Vault      Vault      `yaml:"vault"`
+	KMS        KMS        `yaml:"kms"`
+	ConfigKey  string     `yaml:"config_key" secret:"true"` // decrypts "enc:" values; usually a reference like "vault:kv/data/fg#config_key"; $FILEGOBLIN_CONFIG_KEY when empty
+}
+
+// Storage says where the blobs are kept: "disk", in data_dir/blobs, "s3", in a bucket
+// on AWS or an S3-compatible service like MinIO, "azure", in an Azure Storage container,
+// "sftp", on another host reached over SSH, or "ipfs", on an IPFS node (see IPFS), so the
+// server keeps only its metadata on local disk. "erasure" spreads them across several local disks so some can fail (see Erasure).
+// "memory" keeps them in RAM and loses them on exit, which "serve --ephemeral" picks.
+// It changes on restart.
+//
+// With dedup on, uploads are stored under the SHA-256 of their content, so identical files
+// take space once however many times they're uploaded, and an upload with an
+// X-Checksum-SHA256 header matching a file its owner already has is done without its body.
+//
+// With chunk_over set, blobs at least that large are split into content-defined chunks
+// averaging chunk_size (1MiB unless set, a power of two), each stored once however many
+// blobs have it, so a re-upload of a large file with small changes only adds the chunks
+// around them. Up to chunk_over of each upload is held in memory until it's clear which it
+// is, and chunked blobs can't be downloaded directly.
+//
+// With encryption_key set, a base64-encoded 32-byte key like "filegoblin config keygen"
+// prints, blobs are encrypted before they're stored, each under a key of its own that's
+// stored with it, sealed by this one; blobs stored before stay readable as they are. Losing
+// the key loses every blob, so it's best kept in Vault or a file backed up on its own, or
+// sealed by a KMS (see KMS). To rotate it, move the old key to old_encryption_keys: blobs
+// sealed with it stay readable, and "filegoblin rekey" seals them with the new one.
+//
+// With compression set to "gzip", blobs of types that compress well, text, JSON, XML and
+// the like, are stored gzipped, and sent that way to clients that accept it. zstd isn't
+// offered: the standard library has no encoder for it.
+//
+// With replication set, every blob is stored on other backends as well, and read from
+// another when one fails (see Replication).
+//
+// With cold set, blobs move to a cheaper backend as they go cold (see ColdStorage). They
+// move as they're stored, encrypted and compressed, and chunks move one by one.
+//
+// With direct_downloads set, downloads from a backend that can sign URLs (azure) redirect
+// to one valid that long, and the blob is fetched from the service instead of through the
+// server; with ipfs, they redirect to its gateway, for good. Encrypted files are still served by the server, whose decryption page fetches them.
+type Storage struct {
+	Backend           string        `yaml:"backend"`
+	S3                S3            `yaml:"s3"`
+	Azure             Azure         `yaml:"azure"`
+	SFTP              SFTP          `yaml:"sftp"`
+	IPFS              IPFS          `yaml:"ipfs"`
+	Erasure           Erasure       `yaml:"erasure"`
+	DirectDownloads   time.Duration `yaml:"direct_downloads"`
+	Dedup             bool          `yaml:"dedup"`
+	ChunkOver         ByteSize      `yaml:"chunk_over"` // 0 stores every blob whole
+	ChunkSize         ByteSize      `yaml:"chunk_size"`
+	EncryptionKey     string        `yaml:"encryption_key" secret:"true"`
+	OldEncryptionKeys []string      `yaml:"old_encryption_keys" secret:"true"`
+	Compression       string        `yaml:"compression"`
+	Replication       Replication   `yaml:"replication"`
+	Cold              ColdStorage   `yaml:"cold"`
+}
+
+// Store is a backend besides storage's own, set up the same way: backend is disk, s3, azure,
+// sftp or ipfs, and the disk backend keeps blobs in dir.
+type Store struct {
+	Backend string `yaml:"backend"`
+	Dir     string `yaml:"dir"`
+	S3      S3     `yaml:"s3"`
+	Azure   Azure  `yaml:"azure"`
+	SFTP    SFTP   `yaml:"sftp"`
+	IPFS    IPFS   `yaml:"ipfs"`
+}
+
+func (s Store) open(dataDir string) (storage.Backend, error) {
+	if s.Backend == "disk" {
+		return storage.NewDisk(s.Dir)
+	}
+	return Storage{Backend: s.Backend, S3: s.S3, Azure: s.Azure, SFTP: s.SFTP, IPFS: s.IPFS}.open(dataDir)
+}
+
+// Replication stores every blob on each of replicas as well as storage's own backend, all
+// at once, and reads from storage's own backend, or the next replica when it fails. An
+// upload succeeds once min_copies of them (1 unless set) have it. A backend that was down
+// for a while is caught up on what it missed every repair_interval (5 minutes unless set);
+// until then it isn't read from for those blobs.
+type Replication struct {
+	Replicas       []Store       `yaml:"replicas"`
+	MinCopies      int           `yaml:"min_copies"`
+	RepairInterval time.Duration `yaml:"repair_interval"`
+}
+
+// ColdStorage is a second, cheaper backend blobs move to once they've gone cold, by the
+// rules after (stored that long ago), idle (not read for that long) and over (at least that
+// large). A blob moves when every rule that's set holds, checked every interval (an hour
+// unless set) by the server, or the cluster's leader. With idle set, reading a cold blob
+// moves it back; without, it's read from the cold backend. An empty backend keeps every
+// blob where it's stored; the disk backend keeps cold ones in dir, data_dir/cold unless set.
+type ColdStorage struct {
+	Store    `yaml:",inline"`
+	After    time.Duration `yaml:"after"`
+	Idle     time.Duration `yaml:"idle"`
+	Over     ByteSize      `yaml:"over"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+// S3 is where the s3 backend keeps blobs. Endpoint is empty for AWS, or the URL of another
+// service, like "http://minio:9000", which mostly wants path_style too. SSE asks for
+// encryption at rest: "AES256", or "aws:kms" with the key kms_key_id, or the default one.
+// Blobs larger than part_size (16MiB unless set, at least 5MiB) go up in parts of that size.
+type S3 struct {
+	Endpoint  string   `yaml:"endpoint"`
+	Region    string   `yaml:"region"`
+	Bucket    string   `yaml:"bucket"`
+	Prefix    string   `yaml:"prefix"` // like "blobs/", to share the bucket
+	AccessKey string   `yaml:"access_key"`
+	SecretKey string   `yaml:"secret_key" secret:"true"`
+	PathStyle bool     `yaml:"path_style"`
+	SSE       string   `yaml:"sse"`
+	KMSKeyID  string   `yaml:"kms_key_id"`
+	PartSize  ByteSize `yaml:"part_size"`
+}
+
+// Azure is where the azure backend keeps blobs. Key is the storage account's access key;
+// Endpoint is empty for Azure itself, or another URL like Azurite's. Tier is the access tier
+// new blobs go in, Hot, Cool, Cold or Archive, the account's default when empty. Blobs
+// larger than block_size (8MiB unless set) go up in blocks of that size.
+type Azure struct {
+	Account   string   `yaml:"account"`
+	Key       string   `yaml:"key" secret:"true"`
+	Container string   `yaml:"container"`
+	Prefix    string   `yaml:"prefix"`
+	Endpoint  string   `yaml:"endpoint"`
+	Tier      string   `yaml:"tier"`
+	BlockSize ByteSize `yaml:"block_size"`
+}
+
+// SFTP is where the sftp backend keeps blobs: in dir on host, "nas.lan" or "backup@nas.lan",
+// logging in with the system's ssh client and identity_file, or whatever ssh_config says.
+// The host has to be in known_hosts already. Up to conns connections are kept open, 4
+// unless set, and a request whose connection dropped is tried retries more times on a new one.
+type SFTP struct {
+	Host         string `yaml:"host"`
+	Port         int    `yaml:"port"`
+	IdentityFile string `yaml:"identity_file"`
+	Dir          string `yaml:"dir"`
+	SSHCommand   string `yaml:"ssh_command"` // "ssh" when empty
+	Conns        int    `yaml:"conns"`
+	Retries      int    `yaml:"retries"`
+}
+
+// IPFS is the node the ipfs backend keeps blobs on: its RPC API, http://127.0.0.1:5001
+// unless set, and the MFS directory dir, /filegoblin unless set, which keeps them from the
+// node's garbage collection. Each file records its CID, and downloads carry it in an
+// X-Ipfs-Path header, so browsers with an IPFS extension can fetch them from the network;
+// with gateway set, direct_downloads sends them there. With pin_service set, the endpoint of
+// a service speaking the IPFS Pinning Service API, every blob is pinned there too, with the
+// bearer token pin_token, so it stays on the network when the node is down.
+type IPFS struct {
+	API        string `yaml:"api"`
+	Dir        string `yaml:"dir"`
+	Gateway    string `yaml:"gateway"`
+	PinService string `yaml:"pin_service"`
+	PinToken   string `yaml:"pin_token" secret:"true"`
+}
+
+// Erasure is how the erasure backend stripes blobs across dirs, each best on a disk of its
+// own: each blob is cut into shards, parity of them redundant, and any parity dirs can be
+// lost without losing a blob. After a disk is replaced, or was down while blobs were stored,
+// "filegoblin rebuild" writes its shards again. shard_size (64KiB unless set) is how much
+// of a blob goes into each shard at a time.
+type Erasure struct {
+	Dirs      []string `yaml:"dirs"`
+	Parity    int      `yaml:"parity"`
+	ShardSize ByteSize `yaml:"shard_size"`
+}
+
+// Open opens the configured backend; dataDir is where the disk backend keeps blobs.
+func (s Storage) Open(dataDir string) (storage.Backend, error) {
+	b, err := s.open(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	if r := s.Replication; len(r.Replicas) > 0 {
+		backends := []storage.Backend{b}
+		for i, replica := range r.Replicas {
+			rb, err := replica.open(dataDir)
+			if err != nil {
+				return nil, fmt.Errorf("storage.replication.replicas[%d]: %w", i, err)
+			}
+			backends = append(backends, rb)
+		}
+		b, err = storage.NewReplicated(backends, storage.ReplicaOptions{MinCopies: r.MinCopies,
+			State: filepath.Join(dataDir, "replicas.json")})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c := s.Cold; c.Backend != "" {
+		if c.Backend == "disk" && c.Dir == "" {
+			c.Dir = filepath.Join(dataDir, "cold")
+		}
+		cold, err := c.open(dataDir)
+		if err != nil {
+			return nil, fmt.Errorf("storage.cold: %w", err)
+		}
+		// the tiers move what's stored, so a blob needn't be decrypted to move
+		b, err = storage.NewTiered(b, cold, storage.TierPolicy{Age: c.After, Idle: c.Idle, MinSize: int64(c.Over),
+			State: filepath.Join(dataDir, "tiers.json")})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if s.EncryptionKey != "" {
+		var keys [][]byte
+		for i, k := range append([]string{s.EncryptionKey}, s.OldEncryptionKeys...) {
+			key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(k))
+			if err != nil || len(key) != 32 {
+				name := "storage.encryption_key"
+				if i > 0 {
+					name = fmt.Sprintf("storage.old_encryption_keys[%d]", i-1)
+				}
+				return nil, fmt.Errorf("%s: must be 32 bytes, base64-encoded (see `filegoblin config keygen`)", name)
+			}
+			keys = append(keys, key)
+		}
+		if b, err = storage.NewSealed(b, keys[0], keys[1:]...); err != nil {
+			return nil, err
+		}
+	}
+	if s.Compression == "gzip" {
+		b = storage.NewCompressed(b) // before encryption: ciphertext doesn't compress
+	}
+	if s.ChunkOver == 0 {
+		return b, nil
+	}
+	// chunks are compressed and sealed one by one, so they still dedupe
+	return storage.NewChunked(b, storage.ChunkOptions{Over: int64(s.ChunkOver), Avg: int(s.ChunkSize)})
+}
+
+func (s Storage) open(dataDir string) (storage.Backend, error) {
+	switch s.Backend {
+	case "s3":
+		return storage.NewS3(storage.S3Options{Endpoint: s.S3.Endpoint, Region: s.S3.Region, Bucket: s.S3.Bucket,
+			Prefix: s.S3.Prefix, AccessKey: s.S3.AccessKey, SecretKey: s.S3.SecretKey, PathStyle: s.S3.PathStyle,
+			SSE: s.S3.SSE, SSEKMSKeyID: s.S3.KMSKeyID, PartSize: int64(s.S3.PartSize)})
+	case "azure":
+		return storage.NewAzure(storage.AzureOptions{Account: s.Azure.Account, Key: s.Azure.Key,
+			Container: s.Azure.Container, Prefix: s.Azure.Prefix, Endpoint: s.Azure.Endpoint, Tier: s.Azure.Tier,
+			BlockSize: int64(s.Azure.BlockSize)})
+	case "ipfs":
+		return storage.NewIPFS(storage.IPFSOptions{API: s.IPFS.API, Dir: s.IPFS.Dir, Gateway: s.IPFS.Gateway,
+			PinService: s.IPFS.PinService, PinToken: s.IPFS.PinToken})
+	case "memory":
+		return storage.NewMemory(), nil
+	case "erasure":
+		return storage.NewErasure(storage.ErasureOptions{Dirs: s.Erasure.Dirs, Parity: s.Erasure.Parity,
+			ShardSize: int(s.Erasure.ShardSize)})
+	case "sftp":
+		return storage.NewSFTP(storage.SFTPOptions{Host: s.SFTP.Host, Port: s.SFTP.Port, IdentityFile: s.SFTP.IdentityFile,
+			Dir: s.SFTP.Dir, SSHCommand: s.SFTP.SSHCommand, Conns: s.SFTP.Conns, Retries: s.SFTP.Retries})
+	}
+	return storage.NewDisk(filepath.Join(dataDir, "blobs"))
+}
+
+// Metadata says where the file records are kept: "sqlite", a SQLite database, data_dir/meta.db
+// unless path is set, "postgres", a PostgreSQL database (see Postgres), or "files", a JSON
+// file each in data_dir/meta. Left empty, it's sqlite, unless the data directory has records
+// in meta from before that was the default, and no meta.db. Either way every record is also
+// held in memory. Searches use the databases' full-text indexes, or go through the records in
+// memory with files. The databases keep the users, their quotas and their keys as well,
+// which files leaves in data_dir/auth.json; they take those in auth.json when they have none.
+// Servers in a cluster share the directory or the database; a SQLite one takes a filesystem
+// whose locks work across hosts. Records aren't copied when it changes.
+//
+// A database's schema changes with releases. Migrate "auto" brings it up to date when the
+// server starts; with "manual", the server refuses to start until "filegoblin migrate up"
+// has, which suits clusters that upgrade one server at a time.
+type Metadata struct {
+	Backend  string   `yaml:"backend"`
+	Path     string   `yaml:"path"` // the sqlite database
+	Postgres Postgres `yaml:"postgres"`
+	Migrate  string   `yaml:"migrate"` // "auto" or "manual"
+}
+
+// Postgres is the database the postgres metadata backend keeps file records in, in a table
+// it creates, filegoblin_files. With it, servers in a cluster take their locks and elect
+// their leader with the database's advisory locks rather than with files in data_dir, which
+// they still share for everything else; a server that dies gives its locks up at once.
+//
+// Over a connection without TLS, the password is only sent for SCRAM-SHA-256 logins unless
+// the URL's require_auth, as libpq has it, allows others: require_auth=md5, say.
+type Postgres struct {
+	URL      string `yaml:"url"`                    // like "postgres://filegoblin@db.lan/filegoblin?sslmode=verify-full"
+	Password string `yaml:"password" secret:"true"` // instead of one in the URL
+	MaxConns int    `yaml:"max_conns"`              // connections for queries, and as many for locks; 10 unless set
+}
+
+// BackendIn is the backend the metadata of dataDir is kept in: the one set, or the default
+// for that directory.
+func (m Metadata) BackendIn(dataDir string) string {
+	if m.Backend != "" {
+		return m.Backend
+	}
+	if m.Path == "" {
+		_, dbErr := os.Stat(filepath.Join(dataDir, "meta.db"))
+		if fi, err := os.Stat(filepath.Join(dataDir, "meta")); err == nil && fi.IsDir() && errors.Is(dbErr, fs.ErrNotExist) {
+			return "files"
+		}
+	}
+	return "sqlite"
+}
+
+// OpenStore opens the metadata store the configuration names, as it is.
+func (m Metadata) OpenStore(dataDir string) (metadata.Store, error) {
+	switch m.BackendIn(dataDir) {
+	case "files":
+		return metadata.OpenDir(filepath.Join(dataDir, "meta"))
+	case "postgres":
+		return metadata.OpenPostgres(metadata.PostgresOptions{URL: m.Postgres.URL, Password: m.Postgres.Password, MaxConns: m.Postgres.MaxConns})
+	}
+	path := m.Path
+	if path == "" {
+		path = filepath.Join(dataDir, "meta.db")
+	}
+	return metadata.OpenSQLite(metadata.SQLiteOptions{Path: path})
+}
+
+// Open opens the metadata store the configuration names, migrates its schema unless migrate
+// is "manual", and loads its records.
+func (m Metadata) Open(dataDir string) (*metadata.Index, error) {
+	st, err := m.OpenStore(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	var ix *metadata.Index
+	if mg, ok := st.(metadata.Migrator); ok && m.Migrate != "manual" {
+		err = metadata.Migrate(mg, metadata.Latest(mg), nil)
+	}
+	if err == nil {
+		ix, err = metadata.New(st)
+	} else {
+		st.Close()
+	}
+	if errors.Is(err, metadata.ErrSchemaVersion) {
+		err = fmt.Errorf("%w (see filegoblin migrate)", err)
+	}
+	return ix, err
+}
+
+// OpenUsers opens the registry of users and their keys: in the metadata database ix keeps its
+// records in, if it has one, or in data_dir/auth.json. A database without any yet takes those
+// in auth.json, once.
+func (m Metadata) OpenUsers(dataDir string, ix *metadata.Index) (*auth.Registry, error) {
+	file := auth.File(filepath.Join(dataDir, "auth.json"))
+	db, ok := ix.Store().(auth.Backend)
+	if !ok {
+		return auth.New(file)
+	}
+	users, keys, err := db.LoadAccounts()
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 && len(keys) == 0 {
+		if users, keys, err = file.LoadAccounts(); err != nil {
+			return nil, err
+		}
+		if len(users) > 0 || len(keys) > 0 {
+			if err := db.SaveAccounts(users, keys); err != nil {
+				return nil, fmt.Errorf("copy %s into the metadata database: %w", file, err)
+			}
+		}
+	}
+	return auth.New(db)
+}
+
+// Limits caps what a single client can do.
+type Limits struct {
+	MaxUploadSize ByteSize `yaml:"max_upload_size"` // 0 means unlimited
+}
+
+// Auth controls who may upload and manage files. Downloads are always public for anyone holding the link.
+type Auth struct {
+	Keys             []string `yaml:"keys" secret:"true"`       // static API keys accepted as "Authorization: Bearer <key>"
+	AnonymousUploads bool     `yaml:"anonymous_uploads"`        // allow uploads without a key even when keys are configured
+	AdminKeys        []string `yaml:"admin_keys" secret:"true"` // keys for the /admin API; the admin API is disabled when empty
+}
+
+// TLS enables HTTPS when both files are set. The files are re-read on reload, so renewed
+// certificates can be picked up without a restart.
+type TLS struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+// Enabled reports whether the server should speak HTTPS.
+func (t TLS) Enabled() bool { return t.CertFile != "" && t.KeyFile != "" }
+
+// Lifecycle rules decide when files are cleaned up automatically.
+//
+// With trash_for set, deleting a file through the API moves it to its owner's trash, where it
+// can be restored until the sweep purges it, and still counts towards their quota. Expiry
+// and max_age still delete files outright.
+type Lifecycle struct {
+	MaxAge        time.Duration `yaml:"max_age"`        // delete files older than this; 0 keeps them forever
+	SweepInterval time.Duration `yaml:"sweep_interval"` // how often the cleanup runs
+	GCInterval    time.Duration `yaml:"gc_interval"`    // how often orphaned blobs and leftover uploads are collected; 0 leaves it to "filegoblin gc"
+	GCMinAge      time.Duration `yaml:"gc_min_age"`     // what's younger is left alone, as it may be an upload in flight; an hour unless set
+	TrashFor      time.Duration `yaml:"trash_for"`      // deleted files stay in the trash, restorable, this long; 0 deletes them right away
+}
+
+// Versions keeps the contents uploads replace. An upload with ?path= that one of its owner's
+// files already has becomes that file's new content, under the same ID and links; the old
+// content is kept as a version that can be downloaded or restored, up to keep of them per
+// file, and for max_age after it was replaced if that's set. Versions count towards quota.
+type Versions struct {
+	Keep   int           `yaml:"keep"`    // earlier versions kept per file; 0 keeps none, so uploads just replace the content
+	MaxAge time.Duration `yaml:"max_age"` // the lifecycle sweep prunes versions replaced longer ago; 0 keeps them
+}
+
+// UI controls the built-in web interface.
+type UI struct {
+	Enabled   bool   `yaml:"enabled"`
+	AssetsDir string `yaml:"assets_dir"` // serve UI files from this directory instead of the embedded copy, for customization
+}
+
+// Scan sends every upload through a virus scanner before it is published. Set either Clamd or
+// Command; scanning is off when neither is.
+type Scan struct {
+	Clamd    string        `yaml:"clamd"`     // clamd socket: "unix:/run/clamav/clamd.ctl" or "tcp:127.0.0.1:3310"
+	Command  []string      `yaml:"command"`   // scanner reading the file on stdin; exit 1 means infected, like clamscan
+	Action   string        `yaml:"action"`    // what to do with detections: "reject" deletes them, "quarantine" keeps them unreachable
+	Timeout  time.Duration `yaml:"timeout"`   // per-file limit on scanning
+	FailOpen bool          `yaml:"fail_open"` // publish uploads anyway when the scanner fails, instead of refusing them
+}
+
+// Enabled reports whether uploads are scanned.
+func (s Scan) Enabled() bool { return s.Clamd != "" || len(s.Command) > 0 }
+
+// Links controls share links. With RequireSignature, download links carry an expiry and an
+// HMAC signature, and anything unsigned or expired is refused. The signing keys live in the
+// data directory and rotate on a schedule; retired keys keep verifying for Grace, so set it
+// at least as long as TTL or rotation will cut links short.
+type Links struct {
+	RequireSignature bool          `yaml:"require_signature"`
+	TTL              time.Duration `yaml:"ttl"`          // how long a signed link stays valid
+	RotateEvery      time.Duration `yaml:"rotate_every"` // start signing with a new key this often; 0 never rotates automatically
+	Grace            time.Duration `yaml:"grace"`        // how long links signed by a retired key keep working
+}
+
+// Headers are the security headers added to every response; an empty value leaves a header
+// out. Downloads of uploaded files always get a sandboxing CSP of their own, whatever is set
+// here, since their content is whatever users put in.
+type Headers struct {
+	ContentSecurityPolicy string                       `yaml:"content_security_policy"`
+	FrameOptions          string                       `yaml:"frame_options"`
+	ReferrerPolicy        string                       `yaml:"referrer_policy"`
+	HSTSMaxAge            time.Duration                `yaml:"hsts_max_age"` // sent on HTTPS (or with an https public_url); 0 disables HSTS
+	Routes                map[string]map[string]string `yaml:"routes"`       // per path prefix: headers to add or replace; "" removes one
+}
+
+// Fetch lets users upload by URL, with the server downloading the file itself. Addresses in
+// private, loopback, link-local and other internal ranges are refused so the server can't be
+// used to probe its own network; AllowNetworks exempts specific ranges. Fetched files count
+// against the same size limits and quotas as uploads, and MaxSize caps them even where
+// uploads are unlimited.
+type Fetch struct {
+	Enabled       bool          `yaml:"enabled"`
+	Timeout       time.Duration `yaml:"timeout"`        // for the whole download
+	MaxSize       ByteSize      `yaml:"max_size"`       // the largest file fetched; the smaller of it and max_upload_size applies
+	MaxRedirects  int           `yaml:"max_redirects"`  // redirects to follow; every hop is checked again
+	AllowNetworks []string      `yaml:"allow_networks"` // CIDRs that may be fetched from despite being internal
+}
+
+// Integrity controls checksum verification on download. Checksum headers are always sent;
+// verifying before serving costs a full extra read of every file, so it can be limited to
+// the owners whose files need that assurance.
+//
+// Uploads are hashed with SHA-256 as they're stored, and with the checksums listed in
+// checksums as well, "md5" and "crc32c", for clients that check those, like S3's. An upload
+// that comes with one, in X-Checksum-SHA256, -MD5 or -CRC32C, Content-MD5,
+// X-Amz-Checksum-Sha256 or -Crc32c, or Repr-Digest, is refused unless it matches.
+//
+// With scrub_interval set, the leader reads back every stored blob that often, so corruption
+// is found before a download runs into it. Each one found is logged and sent as a "corrupt"
+// webhook event; with storage replication, every replica's copy is read, and bad ones are
+// replaced with a good one.
+type Integrity struct {
+	Checksums        []string      `yaml:"checksums"`
+	VerifyOnDownload bool          `yaml:"verify_on_download"` // hash each blob before serving it and refuse it on a mismatch; range requests past the start aren't checked
+	VerifyOwners     []string      `yaml:"verify_owners"`      // only verify files of these owners (user names or "key:..."); empty means all
+	ScrubInterval    time.Duration `yaml:"scrub_interval"`     // 0 leaves scrubbing to "filegoblin scrub"
+	ScrubRate        ByteSize      `yaml:"scrub_rate"`         // bytes a second a scrub reads at most; 0 is no limit
+}
+
+// Challenge makes requests without an API key (anonymous uploads, URL fetches and abuse
+// reports) prove they come from a person: a CAPTCHA solved in the web UI, or a proof-of-work
+// puzzle that the CLI and web UI solve on their own.
+type Challenge struct {
+	Provider   string        `yaml:"provider"`             // "hcaptcha", "turnstile" or "pow"; empty turns challenges off; pow in a cluster needs the postgres metadata backend
+	SiteKey    string        `yaml:"site_key"`             // public CAPTCHA site key, given to the widget
+	Secret     string        `yaml:"secret" secret:"true"` // CAPTCHA secret used to verify tokens with the provider; for pow, signs puzzles so servers sharing it accept each other's
+	Difficulty int           `yaml:"difficulty"`           // proof of work: leading zero bits required; each one doubles the work
+	TTL        time.Duration `yaml:"ttl"`                  // proof of work: how long a puzzle stays solvable
+}
+
+// Types controls how uploads' content types are checked and how files may be shown in the
+// browser.
+type Types struct {
+	VerifyMagic  bool     `yaml:"verify_magic"`  // reject uploads whose first bytes don't match their extension, like HTML named photo.png
+	VerifyOwners []string `yaml:"verify_owners"` // only check uploads of these owners (user names or "key:..."); empty means all
+	InlineAllow  []string `yaml:"inline_allow"`  // extra content types ?inline=1 may show in the browser, e.g. "image/svg+xml"; still sandboxed
+}
+
+// Passwords guards password-protected files against guessing. Wrong passwords are counted
+// per file and per client IP; once either runs out of free attempts it is locked out, for
+// twice as long with every further failure.
+type Passwords struct {
+	FreeAttempts int           `yaml:"free_attempts"` // wrong passwords allowed before the first lockout
+	Lockout      time.Duration `yaml:"lockout"`       // length of the first lockout
+	MaxLockout   time.Duration `yaml:"max_lockout"`   // lockouts never grow past this; counts are forgotten after this long without guesses
+}
+
+// DLP checks text uploads (plain text, source, JSON, XML and the like) for sensitive content.
+// Each rule is either a builtin ("credit_card", "private_key", "aws_access_key") or a regular
+// expression of your own, such as one matching internal host names.
+type DLP struct {
+	Rules    []DLPRule `yaml:"rules"`
+	MaxBytes ByteSize  `yaml:"max_bytes"` // how much of each file is checked
+}
+
+// DLPRule is one pattern and what to do when an upload contains it.
+type DLPRule struct {
+	Name    string `yaml:"name"`    // shown in logs and on tagged files; defaults to the builtin's name
+	Builtin string `yaml:"builtin"` // a ready-made rule; card numbers are Luhn-checked to keep false positives down
+	Pattern string `yaml:"pattern"` // or a Go regular expression, e.g. '\b[a-z0-9-]+\.corp\.example\.com\b'
+	Action  string `yaml:"action"`  // "warn" logs the match, "tag" also marks the file, "block" refuses the upload
+}
+
+// Images controls changes made to image uploads before they are stored.
+type Images struct {
+	// StripMetadata removes EXIF (GPS position, camera, time taken), XMP, IPTC and comments
+	// from JPEG, PNG and WebP uploads, keeping only the orientation so they still show
+	// upright: "never", "on_request" (uploads with ?strip=1) or "always". Images too broken
+	// to parse are refused rather than stored with their metadata.
+	StripMetadata string `yaml:"strip_metadata"`
+}
+
+// Thumbnails are small previews of image uploads, served at /f/{id}/thumb?w=256. The
+// configured sizes are made right after upload and cached under data_dir/cache/thumbs; other
+// widths are rounded up to the next configured size. JPEG and PNG are made in-process; WebP
+// and AVIF need an encoder command that reads a PNG on stdin and writes the image on stdout,
+// usually a small script around cwebp or avifenc. Browsers that don't accept the configured format get JPEG.
+type Thumbnails struct {
+	Enabled   bool     `yaml:"enabled"`
+	Sizes     []int    `yaml:"sizes"`      // widths in pixels
+	Format    string   `yaml:"format"`     // "jpeg", "png", "webp" or "avif"
+	Encoder   []string `yaml:"encoder"`    // for webp and avif
+	Quality   int      `yaml:"quality"`    // JPEG quality, 1-100
+	MaxPixels int64    `yaml:"max_pixels"` // images with more pixels than this get no thumbnail
+}
+
+// Media makes previews of video uploads with ffmpeg: a poster frame at /f/{id}/poster and a
+// short clip from the start at /f/{id}/clip. Streaming the videos themselves works without
+// it. Like thumbnails, previews are made right after upload and cached under data_dir/cache.
+type Media struct {
+	FFmpeg     string        `yaml:"ffmpeg"`      // path to ffmpeg; previews are off when empty
+	Width      int           `yaml:"width"`       // previews are scaled down to this width
+	PosterAt   time.Duration `yaml:"poster_at"`   // how far into the video the poster frame is taken
+	ClipLength time.Duration `yaml:"clip_length"` // length of the preview clip; 0 makes none
+	Timeout    time.Duration `yaml:"timeout"`     // per-file limit on ffmpeg
+}
+
+// Preview controls /f/{id}/preview: text and code shown with line numbers and syntax
+// highlighting, CSV as a table, PDFs in the browser's own viewer. Longer files are cut short.
+type Preview struct {
+	Enabled  bool     `yaml:"enabled"`
+	MaxBytes ByteSize `yaml:"max_bytes"` // how much of a text or CSV file is shown
+	MaxRows  int      `yaml:"max_rows"`  // CSV rows shown, header included
+}
+
+// Webhooks are HTTP endpoints told about file events as they happen, with a JSON POST per
+// event signed in X-Filegoblin-Signature. Deliveries the endpoint doesn't answer, or answers
+// with a 5xx, 408 or 429, are retried with exponential backoff; retries still pending when the
+// server stops are dropped.
+type Webhooks struct {
+	Hooks       []Webhook     `yaml:"hooks"`
+	MaxAttempts int           `yaml:"max_attempts"` // per event and endpoint, the first included
+	Backoff     time.Duration `yaml:"backoff"`      // wait before the first retry; doubles for each one after
+	Timeout     time.Duration `yaml:"timeout"`      // per attempt
+}
+
+// Webhook is one endpoint.
+type Webhook struct {
+	URL    string   `yaml:"url"`
+	Secret string   `yaml:"secret" secret:"true"` // key for the HMAC-SHA256 signature; deliveries are unsigned without one
+	Events []string `yaml:"events"`               // any of upload, download, delete, restore, purge, expire, quota, corrupt; all of them when empty
+}
+
+// WebhookEvents are the events webhooks can subscribe to.
+var WebhookEvents = []string{"upload", "download", "delete", "restore", "purge", "expire", "quota", "corrupt"}
+
+// Bus publishes the webhook events to a NATS server as well, on the subject
+// "<subject>.<type>" with the same JSON as a webhook's body, for pipelines that consume a
+// stream. Publishing is best effort: events wait in a short queue while the server can't be
+// reached, and are dropped when it fills.
+type Bus struct {
+	NATS    string   `yaml:"nats" secret:"true"` // "nats://host:4222", or tls:// to require TLS; "user:password@" or "token@" log in; empty turns publishing off
+	Subject string   `yaml:"subject"`            // prefix of the subjects published to
+	Events  []string `yaml:"events"`             // the webhook events to publish; all of them when empty
+}
+
+// Plugin runs an operator's own code at one point of a file's life: before an upload is
+// published, after it is, or before a download. A plugin is a command or an HTTP endpoint;
+// either way it is given the file and request as JSON and answers with JSON, as described in
+// docs/plugins.md. Plugins at the same point run in the order listed.
+type Plugin struct {
+	Point    string        `yaml:"point"`                // "pre_upload", "post_upload" or "pre_download"
+	Command  []string      `yaml:"command"`              // run with the request on stdin; set this or url
+	URL      string        `yaml:"url"`                  // POSTed the request
+	Secret   string        `yaml:"secret" secret:"true"` // signs requests to url as webhooks are signed
+	Env      []string      `yaml:"env"`                  // "NAME=value" for the command, which otherwise only gets PATH
+	Timeout  time.Duration `yaml:"timeout"`              // per run; 0 means 10s
+	FailOpen bool          `yaml:"fail_open"`            // go ahead when the plugin fails, instead of refusing
+}
+
+// PluginPoints are where plugins can run.
+var PluginPoints = []string{"pre_upload", "post_upload", "pre_download"}
+
+// Branding changes how the web pages look, for white-labelled deployments. Tenants override
+// it for requests to their own domain, which their share links then use too. Every page is a
+// template that ui.assets_dir (or a tenant's assets_dir) can replace, so changes beyond
+// these settings need no rebuild either.
+type Branding struct {
+	Brand   `yaml:",inline"`
+	Tenants []Tenant `yaml:"tenants"`
+}
+
+// Brand is one look, and the language it speaks by default. Colours are CSS colours like "#0a7"
+// or "rebeccapurple".
+type Brand struct {
+	Name       string `yaml:"name"`       // shown in titles and headers instead of "filegoblin"
+	Logo       string `yaml:"logo"`       // header image: a file in the assets directory, like "logo.svg", or a data: URL
+	Accent     string `yaml:"accent"`     // links and buttons
+	Background string `yaml:"background"` // page background
+	Text       string `yaml:"text"`       // body text
+	Footer     string `yaml:"footer"`     // plain text at the bottom of every page
+	Locale     string `yaml:"locale"`     // language for browsers that ask for none filegoblin speaks, like "de"; English when empty
+}
+
+// Tenant is a branded domain on a shared instance. Empty brand settings fall back to the
+// instance's own.
+type Tenant struct {
+	Domain    string `yaml:"domain"` // host name, like "files.acme.example"
+	Brand     `yaml:",inline"`
+	AssetsDir string `yaml:"assets_dir"` // templates and assets for this tenant, over ui.assets_dir
+}
+
+// Cluster lets several servers share one data_dir, on NFS or another shared filesystem, behind
+// a load balancer. Each needs its own node_id. One of them at a time, the leader, runs the
+// lifecycle sweep and rotates signing keys; the others pick up its changes, and each other's,
+// every lease_ttl/3. Leave node_id empty for a single server.
+type Cluster struct {
+	NodeID   string        `yaml:"node_id"`   // this server's name, unique in the cluster, like its host name
+	LeaseTTL time.Duration `yaml:"lease_ttl"` // how long the leader's lease lasts; another server takes over this long after it dies
+}
+
+// Enabled reports whether this server is one of several sharing the data directory.
+func (c Cluster) Enabled() bool { return c.NodeID != "" }
+
+// Backup writes incremental backups of the data directory to target every so often: each run
+// adds a generation holding only what changed since the one before, and the oldest are folded
+// together so that keep remain. Point target at off-site storage, like an NFS or rclone mount.
+// "filegoblin backup" runs, lists and restores them by hand.
+type Backup struct {
+	Target string        `yaml:"target"` // directory the generations are written to
+	Every  time.Duration `yaml:"every"`  // time between runs, like 24h; 0 runs none in the server
+	Keep   int           `yaml:"keep"`   // generations kept
+}
+
+// Log controls the server's own log. The level takes effect on reload too, so debug output
+// can be turned on for a while without a restart.
+type Log struct {
+	Level string `yaml:"level"` // "debug", "info", "warn" or "error"; lower levels are dropped
+	// Levels sets the level of parts of the server apart from the rest, by the component
+	// they log as, like {"upload": "debug"} to follow uploads in detail. A level applies to
+	// the components named below it too: "storage" to "storage.s3". It changes on reload.
+	Levels map[string]string `yaml:"levels"`
+	// Format is "text", "json" for one JSON object per line, "pretty" for colored lines with
+	// the time since the start, or "auto": pretty on a terminal, text anywhere else, files
+	// included. It changes on restart.
+	Format string `yaml:"format"`
+
+	// TimeFormat is how text and JSON lines write the time: "rfc3339" (the default),
+	// "rfc3339nano", "epoch_ms" for milliseconds since 1970, or a Go layout such as
+	// "2006-01-02 15:04:05.000". TimeZone is "local", "utc" or a zone name such as
+	// "Europe/Berlin"; empty keeps local time in text and UTC in JSON. Both change on reload.
+	TimeFormat string `yaml:"time_format"`
+	TimeZone   string `yaml:"time_zone"`
+
+	// File is where the log goes instead of standard error. It is rotated once it reaches
+	// max_size: moved aside with the time in its name and a new one started. These settings
+	// change on restart.
+	File       string        `yaml:"file"`
+	MaxSize    ByteSize      `yaml:"max_size"`    // 0 never rotates
+	MaxAge     time.Duration `yaml:"max_age"`     // rotated files older than this are deleted; 0 keeps them
+	MaxBackups int           `yaml:"max_backups"` // rotated files kept; 0 keeps them all
+	Compress   bool          `yaml:"compress"`    // gzip rotated files
+
+	// FileBuffer, when above 0, holds up to that much of the log file and access_file in
+	// memory and writes it out every FlushEvery (a second if 0), for busy access logs. Sync
+	// writes out the buffer and syncs the log to disk after each message at this level and
+	// above, like "error", so failures are on disk even if the machine goes down next; empty
+	// syncs none. FileBuffer and FlushEvery change on restart, Sync on reload.
+	FileBuffer ByteSize      `yaml:"file_buffer"`
+	FlushEvery time.Duration `yaml:"flush_every"`
+	Sync       string        `yaml:"sync"`
+
+	// Journal sends the log to the systemd journal instead of standard error, with the level
+	// as each entry's priority and the fields as journal fields; Linux only. Syslog also
+	// sends it to a syslog server, in RFC 5424 messages: "udp://host:514", "tcp://host:601",
+	// or "unix:///dev/log" for the local daemon. These change on restart.
+	Journal bool   `yaml:"journal"`
+	Syslog  string `yaml:"syslog"`
+
+	// EventLog also writes the log to the Windows Event Log, as this event source, like
+	// "filegoblin": errors as Error events, warnings as Warning, the rest as Information.
+	// "filegoblin service install" registers a source named after the service. Windows
+	// only; it changes on restart.
+	EventLog string `yaml:"event_log"`
+
+	// GELF also ships the log to Graylog, "udp://graylog:12201" or "tcp://graylog:12201",
+	// and Logstash to Logstash's tcp input with the json_lines codec, "tcp://logstash:5000".
+	// While the collector is down up to 10000 messages wait in memory for it to come back.
+	// These change on restart.
+	GELF     string `yaml:"gelf"`
+	Logstash string `yaml:"logstash"`
+
+	// OTLP also exports the log to an OpenTelemetry collector's OTLP/HTTP endpoint, like
+	// "http://collector:4318/v1/logs", with OTLPHeaders on each request, such as an API key
+	// for a hosted backend. Requests that came with a traceparent header have its trace and
+	// span on their lines. These change on restart.
+	OTLP        string            `yaml:"otlp"`
+	OTLPHeaders map[string]string `yaml:"otlp_headers" secret:"true"`
+
+	// Dedup writes a message that comes again within this long only once, then how often it
+	// came once the time is up, so a failing backend doesn't flood the log with the same
+	// error. 0 writes every message. It changes on reload.
+	Dedup time.Duration `yaml:"dedup"`
+
+	// Caller adds the file, line and function each message was logged from, as the caller
+	// and func fields. It changes on reload.
+	Caller bool `yaml:"caller"`
+
+	// ErrorStacks adds a stack trace, as the stack field, to errors that aren't expected in
+	// the normal course of things, like a failed write to storage rather than a client that
+	// hung up. It changes on reload.
+	ErrorStacks bool `yaml:"error_stacks"`
+
+	// Recent is how many of the latest messages the server keeps in memory, for admins to
+	// read through GET /admin/logs or "filegoblin admin logs" without access to the log
+	// file. 0 keeps none. It changes on reload.
+	Recent int `yaml:"recent"`
+
+	// Buffer, when above 0, queues up to that many messages for a goroutine to write, so a
+	// slow disk or syslog server doesn't hold up requests. Overflow says what happens when
+	// the queue is full: "block" waits for room, "drop" drops messages and counts them.
+	// These change on restart.
+	Buffer   int    `yaml:"buffer"`
+	Overflow string `yaml:"overflow"`
+
+	// Stderr also sends messages at this level and above to standard error when the log
+	// goes to a file or the journal, like "error" to see failures on the console too: in
+	// text, or pretty on a terminal unless format is text. Empty sends none there.
+	Stderr string `yaml:"stderr"`
+
+	// Split sends messages below this level to standard output and the rest to standard
+	// error, like "warn" for platforms that flag whatever comes on standard error as an
+	// error. Empty sends all of them to standard error. It doesn't go with file or journal,
+	// and changes on restart.
+	Split string `yaml:"split"`
+
+	// Access logs every request: "log" as a message with the request's details as fields,
+	// "combined" as a line in Apache's Combined Log Format, written to access_file (rotated
+	// like file) or else wherever the log goes. Empty logs none. Access changes on reload.
+	Access     string `yaml:"access"`
+	AccessFile string `yaml:"access_file"`
+
+	// API keys, passwords and link signatures are masked before a line is written: values
+	// of fields named like password or token, bearer credentials, sig= and token= in query
+	// strings and passwords in URLs. RedactFields names more fields to mask, and Redact adds
+	// regular expressions; one with a group masks only what the group matched. Both change
+	// on reload.
+	RedactFields []string `yaml:"redact_fields"`
+	Redact       []string `yaml:"redact"`
+
+	// Exclude drops the messages any of its filters picks out, like the lines access: log
+	// writes for health checks, and Include, when set, those none of its filters does. They
+	// change on reload.
+	Include []LogFilter `yaml:"include"`
+	Exclude []LogFilter `yaml:"exclude"`
+}
+
+// LogFilter picks out log messages by a regular expression their text has to match and
+// ones for the values of their fields, all of which they must have:
+//
+//	exclude:
+//	  - message: ^request$
+//	    fields: {path: ^/(healthz|readyz)$, remote_ip: ^10\.}
+//
+// It only picks out messages up to UpTo, "info" unless set, so errors still get through.
+type LogFilter struct {
+	Message string            `yaml:"message"`
+	Fields  map[string]string `yaml:"fields"`
+	UpTo    string            `yaml:"up_to"`
+}
+
+// SyslogAddr splits Syslog into the network and address to dial; "unix" is a datagram
+// socket, as /dev/log is.
+func (l Log) SyslogAddr() (network, addr string, err error) {
+	u, err := url.Parse(l.Syslog)
+	if err != nil {
+		return "", "", err
+	}
+	switch u.Scheme {
+	case "udp", "tcp":
+		if u.Host == "" {
+			return "", "", fmt.Errorf("%q has no host", l.Syslog)
+		}
+		if u.Port() == "" {
+			return "", "", fmt.Errorf("%q has no port", l.Syslog)
+		}
+		return u.Scheme, u.Host, nil
+	case "unix":
+		if u.Path == "" {
+			return "", "", fmt.Errorf("%q has no socket path", l.Syslog)
+		}
+		return "unixgram", u.Path, nil
+	}
+	return "", "", fmt.Errorf("%q must start with udp://, tcp:// or unix://", l.Syslog)
+}
+
+// GELFAddr splits GELF into the network and address to send to.
+func (l Log) GELFAddr() (network, addr string, err error) {
+	return hostAddr(l.GELF, "udp", "tcp")
+}
+
+// LogstashAddr returns the address in Logstash.
+func (l Log) LogstashAddr() (string, error) {
+	_, addr, err := hostAddr(l.Logstash, "tcp")
+	return addr, err
+}
+
+// hostAddr splits a URL like "udp://host:port" into its scheme, which must be one of schemes,
+// and host:port.
+func hostAddr(raw string, schemes ...string) (scheme, addr string, err error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", "", err
+	}
+	if !slices.Contains(schemes, u.Scheme) {
+		return "", "", fmt.Errorf("%q must start with %s://", raw, strings.Join(schemes, ":// or "))
+	}
+	if u.Hostname() == "" || u.Port() == "" {
+		return "", "", fmt.Errorf("%q needs a host and a port", raw)
+	}
+	return u.Scheme, u.Host, nil
+}
+
+// TimeLayout returns the layout and zone TimeFormat and TimeZone name, for
+// logx.SetTimeFormat: "" and nil for what they leave to the format.
+func (l Log) TimeLayout() (layout string, loc *time.Location, err error) {
+	switch strings.ToLower(l.TimeFormat) {
+	case "", "rfc3339":
+		// the formats' own
+	case "rfc3339nano":
+		layout = time.RFC3339Nano
+	case logx.EpochMillis:
+		layout = logx.EpochMillis
+	default:
+		if time.Unix(0, 0).Format(l.TimeFormat) == l.TimeFormat {
+			return "", nil, fmt.Errorf("%q has no part of a time in it, like 2006-01-02 15:04:05", l.TimeFormat)
+		}
+		layout = l.TimeFormat
+	}
+	switch strings.ToLower(l.TimeZone) {
+	case "":
+	case "local":
+		loc = time.Local
+	case "utc":
+		loc = time.UTC
+	default:
+		if loc, err = time.LoadLocation(l.TimeZone); err != nil {
+			return "", nil, err
+		}
+	}
+	return layout, loc, nil
+}
+
+// Filters compiles Include and Exclude for logx.
+func (l Log) Filters() (include, exclude []logx.Filter, err error) {
+	if include, err = compileFilters("include", l.Include); err != nil {
+		return nil, nil, err
+	}
+	if exclude, err = compileFilters("exclude", l.Exclude); err != nil {
+		return nil, nil, err
+	}
+	return include, exclude, nil
+}
+
+func compileFilters(name string, lfs []LogFilter) ([]logx.Filter, error) {
+	var out []logx.Filter
+	for i, lf := range lfs {
+		f := logx.Filter{UpTo: logx.LevelInfo}
+		var err error
+		if lf.Message != "" {
+			if f.Message, err = regexp.Compile(lf.Message); err != nil {
+				return nil, fmt.Errorf("%s[%d].message: %v", name, i, err)
+			}
+		}
+		for key, expr := range lf.Fields {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("%s[%d].fields.%s: %v", name, i, key, err)
+			}
+			if f.Fields == nil {
+				f.Fields = map[string]*regexp.Regexp{}
+			}
+			f.Fields[key] = re
+		}
+		if lf.UpTo != "" {
+			if f.UpTo, err = logx.ParseLevel(lf.UpTo); err != nil {
+				return nil, fmt.Errorf("%s[%d].up_to: %v", name, i, err)
+			}
+		}
+		if f.Message == nil && len(f.Fields) == 0 {
+			return nil, fmt.Errorf("%s[%d]: needs a message or fields to match", name, i)
+		}
+		out = append(out, f)
+	}
+	return out, nil
+}
+
+// Audit controls the audit log, data_dir/audit.log: a hash-chained record of who did what to
+// which file, from where and with which key, kept apart from the operational log. Uploads,
+// deletions and admin actions are always recorded. Downloads are unless Downloads is off, as
+// each one costs a synced write on a busy public server.
+type Audit struct {
+	Downloads bool `yaml:"downloads"`
+}
+
+// Metrics serves counters for Prometheus at /metrics, such as log messages by level and
+// component, to alert on a rising error rate. Token, when set, must come as "Authorization:
+// Bearer <token>", which Prometheus sends with its authorization setting. Both change on
+// reload.
+type Metrics struct {
+	Enabled bool   `yaml:"enabled"`
+	Token   string `yaml:"token" secret:"true"`
+}
+
+// Vault is where "vault:" secret references are looked up. Any secret field may hold a
+// reference instead of the value itself: "vault:<path>#<field>", "env:<VARIABLE>" or
+// "file:<path>". References are resolved at startup and again on every reload.
+type Vault struct {
+	Address   string `yaml:"address"`             // e.g. "https://vault.internal:8200"; $VAULT_ADDR when empty
+	Token     string `yaml:"token" secret:"true"` // $VAULT_TOKEN when empty
+	TokenFile string `yaml:"token_file"`          // read the token from this file instead, e.g. a Vault Agent sink
+	Namespace string `yaml:"namespace"`           // Vault Enterprise namespace
+}
+
+// KMS is how secret references sealed by a key management service are opened. Like "enc:"
+// values, any secret field may hold one, but the key never leaves the service, which logs
+// and authorizes every use; it's the usual home for storage.encryption_key's own key:
+//
+//   - "awskms:<ciphertext>", base64, opened with AWS KMS in aws.region, with aws.access_key
+//     and aws.secret_key, or $AWS_REGION, $AWS_ACCESS_KEY_ID, $AWS_SECRET_ACCESS_KEY and
+//     $AWS_SESSION_TOKEN when empty
+//   - "gcpkms:<key name>:<ciphertext>", opened with Google Cloud KMS as the service account
+//     in gcp.credentials_file, or $GOOGLE_APPLICATION_CREDENTIALS, or else the one of the VM
+//   - "transit:<key>:<ciphertext>", opened with the transit key of the Vault in vault; "key"
+//     is "transit/<name>" or another mount, or a name in the "transit" mount
+//
+// "filegoblin config encrypt --kms" seals a value this way.
+type KMS struct {
+	AWS AWSKMS `yaml:"aws"`
+	GCP GCPKMS `yaml:"gcp"`
+}
+
+type AWSKMS struct {
+	Region    string `yaml:"region"`
+	Endpoint  string `yaml:"endpoint"` // "https://kms.<region>.amazonaws.com" when empty
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key" secret:"true"`
+}
+
+type GCPKMS struct {
+	CredentialsFile string `yaml:"credentials_file"`
+	Endpoint        string `yaml:"endpoint"` // "https://cloudkms.googleapis.com" when empty
+}
+
+// Default returns the configuration used when no file is given.
+func Default() *Config {
+	return &Config{
+		Listen:  ":8080",
+		DataDir: "data",
+		Storage: Storage{Backend: "disk", SFTP: SFTP{Retries: 2}, Cold: ColdStorage{Store: Store{SFTP: SFTP{Retries: 2}}}},
+		Metadata: Metadata{
+			Migrate: "auto",
+		},
+		Limits: Limits{
+			MaxUploadSize: 1 << 30, // 1 GiB
+		},
+		Lifecycle: Lifecycle{
+			SweepInterval: 10 * time.Minute,
+		},
+		Versions: Versions{
+			Keep: 10,
+		},
+		UI: UI{
+			Enabled: true,
+		},
+		Scan: Scan{
+			Action:  "reject",
+			Timeout: 2 * time.Minute,
+		},
+		Links: Links{
+			TTL:         24 * time.Hour,
+			RotateEvery: 30 * 24 * time.Hour,
+			Grace:       7 * 24 * time.Hour,
+		},
+		Headers: Headers{
+			ContentSecurityPolicy: "default-src 'self'; img-src 'self' data: blob:; object-src 'none'; base-uri 'none'; form-action 'self'; frame-ancestors 'none'",
+			FrameOptions:          "DENY",
+			ReferrerPolicy:        "same-origin",
+			HSTSMaxAge:            180 * 24 * time.Hour,
+		},
+		Fetch: Fetch{
+			Timeout:      10 * time.Minute,
+			MaxSize:      1 << 30, // 1 GiB
+			MaxRedirects: 5,
+		},
+		Challenge: Challenge{
+			Difficulty: 18,
+			TTL:        5 * time.Minute,
+		},
+		Passwords: Passwords{
+			FreeAttempts: 5,
+			Lockout:      30 * time.Second,
+			MaxLockout:   time.Hour,
+		},
+		DLP: DLP{
+			MaxBytes: 10 << 20, // 10 MiB
+		},
+		Images: Images{
+			StripMetadata: "on_request",
+		},
+		Thumbnails: Thumbnails{
+			Enabled:   true,
+			Sizes:     []int{128, 256, 512},
+			Format:    "jpeg",
+			Quality:   80,
+			MaxPixels: 40_000_000, // about 160 MB decoded
+		},
+		Media: Media{
+			Width:      640,
+			PosterAt:   time.Second,
+			ClipLength: 10 * time.Second,
+			Timeout:    2 * time.Minute,
+		},
+		Preview: Preview{
+			Enabled:  true,
+			MaxBytes: 1 << 20, // 1 MiB
+			MaxRows:  1000,
+		},
+		Webhooks: Webhooks{
+			MaxAttempts: 6, // the last one about five minutes after the first
+			Backoff:     10 * time.Second,
+			Timeout:     10 * time.Second,
+		},
+		Bus: Bus{
+			Subject: "filegoblin",
+		},
+		Cluster: Cluster{
+			LeaseTTL: 30 * time.Second,
+		},
+		Backup: Backup{
+			Keep: 7,
+		},
+		Log: Log{
+			Level:      "info",
+			Format:     "auto",
+			MaxSize:    100 << 20, // 100 MiB
+			MaxBackups: 10,
+			Overflow:   "block",
+			Recent:     1000,
+		},
+		Audit: Audit{Downloads: true},
+	}
+}
+
+// Load builds the effective configuration: defaults, then the YAML file at path (if any), then
+// FILEGOBLIN_* environment overrides. Unknown keys in the file are an error, so a typo like
+// "max_uplaod_size" fails loudly instead of silently leaving the default in place.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("read config: %w", err)
+		}
+		defer f.Close()
+		dec := yaml.NewDecoder(f)
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) { // EOF just means an empty file
+			return nil, fmt.Errorf("parse config %s: %w", path, err)
+		}
+	}
+	applyEnv(cfg)
+	return cfg, nil
+}
+
+// envOverrides maps environment variables to the settings they replace. Handy in containers,
+// where mounting a whole config file just to change the port is a chore.
+var envOverrides = map[string]func(*Config, string){
+	"FILEGOBLIN_LISTEN":     func(c *Config, v string) { c.Listen = v },
+	"FILEGOBLIN_DATA_DIR":   func(c *Config, v string) { c.DataDir = v },
+	"FILEGOBLIN_PUBLIC_URL": func(c *Config, v string) { c.PublicURL = v },
+	"FILEGOBLIN_LOG_LEVEL":  func(c *Config, v string) { c.Log.Level = v },
+	"FILEGOBLIN_LOG_FORMAT": func(c *Config, v string) { c.Log.Format = v },
+	"FILEGOBLIN_LOG_STDERR": func(c *Config, v string) { c.Log.Stderr = v },
+	"FILEGOBLIN_LOG_SYSLOG": func(c *Config, v string) { c.Log.Syslog = v },
+	"FILEGOBLIN_LOG_GELF":   func(c *Config, v string) { c.Log.GELF = v },
+	"FILEGOBLIN_LOG_SPLIT":  func(c *Config, v string) { c.Log.Split = v },
+	"FILEGOBLIN_LOG_OTLP":   func(c *Config, v string) { c.Log.OTLP = v },
+}
+
+func applyEnv(cfg *Config) {
+	for name, set := range envOverrides {
+		if v, ok := os.LookupEnv(name); ok {
+			set(cfg, v)
+		}
+	}
+}
+
+// ByteSize is a number of bytes that can be written in config files either as a plain
+// integer or with a unit suffix like "512MB" or "2GiB", which is a lot friendlier for humans.
+type ByteSize int64
+
+var byteUnits = []struct {
+	suffix string
+	mult   int64
+}{
+	// longest suffixes first so "MiB" is not mistaken for "B"
+	{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30}, {"TIB", 1 << 40},
+	{"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000}, {"TB", 1000 * 1000 * 1000 * 1000},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"T", 1 << 40},
+	{"B", 1},
+}
+
+// ParseByteSize turns strings like "10MB", "1.5GiB" or "4096" into a ByteSize.
+func ParseByteSize(s string) (ByteSize, error) {
+	str := strings.ToUpper(strings.TrimSpace(s))
+	if str == "" {
+		return 0, errors.New("empty size")
+	}
+	mult := int64(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(str, u.suffix) {
+			mult = u.mult
+			str = strings.TrimSpace(strings.TrimSuffix(str, u.suffix))
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(str, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return ByteSize(n * float64(mult)), nil
+}
+
+// UnmarshalYAML lets yaml.v3 decode both "512MB" and 536870912.
+func (b *ByteSize) UnmarshalYAML(node *yaml.Node) error {
+	v, err := ParseByteSize(node.Value)
+	if err != nil {
+		return err
+	}
+	*b = v
+	return nil
+}
+
+// String prints the size using the largest unit that divides it evenly, binary units first.
+func (b ByteSize) String() string {
+	n := int64(b)
+	for _, u := range []struct {
+		suffix string
+		mult   int64
+	}{
+		{"TiB", 1 << 40}, {"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10},
+		{"TB", 1e12}, {"GB", 1e9}, {"MB", 1e6}, {"KB", 1e3},
+	} {
+		if n != 0 && n%u.mult == 0 {
+			return fmt.Sprintf("%d%s", n/u.mult, u.suffix)
+		}
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// MarshalYAML writes the size back in its human form, or as a plain number when no unit fits.
+func (b ByteSize) MarshalYAML() (interface{}, error) {
+	if s := b.String(); s != strconv.FormatInt(int64(b), 10) {
+		return s, nil
+	}
+	return int64(b), nil
+}
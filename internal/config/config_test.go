@@ -0,0 +1,151 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/auth"
+)
+
+func writeConfig(t *testing.T, body string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(p, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// TestLoadRejectsUnknownKeys makes sure typos in the file are reported instead of ignored.
+func TestLoadRejectsUnknownKeys(t *testing.T) {
+	_, err := Load(writeConfig(t, "limits:\n  max_uplaod_size: 10MB\n"))
+	if err == nil || !strings.Contains(err.Error(), "max_uplaod_size") {
+		t.Fatalf("expected an error naming the bad key, got %v", err)
+	}
+}
+
+// TestLoadMergesFileAndEnv checks the defaults < file < environment order.
+func TestLoadMergesFileAndEnv(t *testing.T) {
+	t.Setenv("FILEGOBLIN_DATA_DIR", "/srv/goblin")
+	cfg, err := Load(writeConfig(t, "listen: \":9000\"\ndata_dir: /tmp/x\nlimits:\n  max_upload_size: 10MiB\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Listen != ":9000" || cfg.DataDir != "/srv/goblin" || cfg.Limits.MaxUploadSize != 10<<20 {
+		t.Fatalf("unexpected merge result: %+v", cfg)
+	}
+	if cfg.Lifecycle.SweepInterval != Default().Lifecycle.SweepInterval {
+		t.Fatal("default sweep interval lost")
+	}
+}
+
+// TestValidateReportsAllProblems checks that every problem is reported, not just the first.
+func TestValidateReportsAllProblems(t *testing.T) {
+	cfg := Default()
+	cfg.Listen = "nonsense"
+	cfg.Auth.Keys = []string{"short"}
+	cfg.TLS.CertFile = "cert.pem"
+	cfg.Scan.Clamd = "localhost:3310"
+	cfg.Branding.Locale = "klingon"
+	cfg.Log.Level = "chatty"
+	cfg.Integrity.Checksums = []string{"sha1"}
+	cfg.Integrity.ScrubInterval = -time.Hour
+	cfg.Metadata.Backend = "mysql"
+	cfg.Metadata.Migrate = "sometimes"
+	cfg.Storage = Storage{Backend: "s3", S3: S3{Bucket: "files", Region: "eu-central-1", SSE: "rot13"}, DirectDownloads: time.Hour, ChunkSize: 1000,
+		Cold:        ColdStorage{Store: Store{Backend: "sftp", SFTP: SFTP{Host: "nas", Dir: "cold"}}},
+		Replication: Replication{Replicas: []Store{{Backend: "disk"}, {Backend: "ipfs", IPFS: IPFS{Dir: "filegoblin"}}}}}
+	cfg.Log.TimeFormat = "yesterday"
+	cfg.Log.Levels = map[string]string{"storage.": "debug"}
+	cfg.Log.Exclude = []LogFilter{{Fields: map[string]string{"path": "(healthz"}}}
+	cfg.Fetch.MaxSize = 0
+	cfg.Challenge.Provider = "pow"
+	cfg.Cluster.NodeID = "node-1"
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected validation errors")
+	}
+	for _, want := range []string{"listen", "auth.keys[0]", "tls", "scan.clamd", "branding.locale", "log.level", "log.time_format", "log.levels", "log.exclude[0].fields.path", "storage.s3.sse", "secret_key", "storage.direct_downloads", "storage.chunk_size", "storage.cold.sftp.dir", "storage.cold: set after", "storage.replication.replicas[0].dir", "storage.replication.replicas[1].ipfs.dir", "integrity.checksums[0]", "integrity.scrub_interval", "metadata.backend", "metadata.migrate", "fetch.max_size", "challenge.provider"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("missing %q in %v", want, err)
+		}
+	}
+	if err := Default().Validate(); err != nil {
+		t.Fatalf("defaults should be valid: %v", err)
+	}
+}
+
+// TestMetadataBackend checks that records are kept in SQLite unless set otherwise, or the
+// data directory has records from before that was the default.
+func TestMetadataBackend(t *testing.T) {
+	dir := t.TempDir()
+	var m Metadata
+	if got := m.BackendIn(dir); got != "sqlite" {
+		t.Fatalf("new data directory: %q, want sqlite", got)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "meta"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.BackendIn(dir); got != "files" {
+		t.Fatalf("data directory with records in meta: %q, want files", got)
+	}
+	if got := (Metadata{Backend: "postgres"}).BackendIn(dir); got != "postgres" {
+		t.Fatalf("postgres set: %q", got)
+	}
+	st, err := (Metadata{Backend: "sqlite"}).OpenStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Close()
+	if got := m.BackendIn(dir); got != "sqlite" {
+		t.Fatalf("data directory with meta.db: %q, want sqlite", got)
+	}
+}
+
+// TestOpenUsers checks that a metadata database takes the users and keys in auth.json when it
+// has none of its own.
+func TestOpenUsers(t *testing.T) {
+	dir := t.TempDir()
+	file, err := auth.Open(filepath.Join(dir, "auth.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.AddUser("ann", 0); err != nil {
+		t.Fatal(err)
+	}
+	ix, err := Default().Metadata.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ix.Close()
+	users, err := Default().Metadata.OpenUsers(dir, ix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.AddUser("bob", 0); err != nil {
+		t.Fatal(err)
+	}
+	again, err := Default().Metadata.OpenUsers(dir, ix)
+	if err != nil || len(again.Users()) != 2 {
+		t.Fatalf("reopened: %v, %v", again.Users(), err)
+	}
+	if file.Reload(); len(file.Users()) != 1 {
+		t.Fatalf("auth.json changed: %v", file.Users())
+	}
+}
+
+// TestRedact makes sure secrets are masked in the copy only.
+func TestRedact(t *testing.T) {
+	cfg := Default()
+	cfg.Auth.Keys = []string{"0123456789abcdef"}
+	red := cfg.Redact()
+	if red.Auth.Keys[0] != Redacted {
+		t.Fatalf("key not redacted: %v", red.Auth.Keys)
+	}
+	if cfg.Auth.Keys[0] != "0123456789abcdef" {
+		t.Fatal("Redact modified the original config")
+	}
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// StateDir is where filegoblin keeps its state when installed as a system service, so that
+// the default data_dir, "data", ends up in the usual place for the platform:
+//
+//   - Linux and other Unix systems: /var/lib/filegoblin (the systemd unit's StateDirectory)
+//   - macOS: /Library/Application Support/filegoblin
+//   - Windows: %ProgramData%\filegoblin
+//
+// The service runs with it as its working directory; run by hand, filegoblin uses the
+// working directory it is started in.
+func StateDir() string {
+	switch runtime.GOOS {
+	case "windows":
+		base := os.Getenv("ProgramData")
+		if base == "" {
+			base = `C:\ProgramData`
+		}
+		return filepath.Join(base, "filegoblin")
+	case "darwin":
+		return "/Library/Application Support/filegoblin"
+	default:
+		return "/var/lib/filegoblin"
+	}
+}
+
+// DefaultFile is the config file used when none is given on the command line, if it exists:
+// /etc/filegoblin/config.yaml on Unix systems, and config.yaml in StateDir on macOS and
+// Windows, which have no /etc convention for services.
+func DefaultFile() string {
+	switch runtime.GOOS {
+	case "windows", "darwin":
+		return filepath.Join(StateDir(), "config.yaml")
+	default:
+		return "/etc/filegoblin/config.yaml"
+	}
+}
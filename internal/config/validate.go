@@ -0,0 +1,763 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/i18n"
+	"github.com/hey-granth/filegoblin/internal/logx"
+)
+
+// Validate checks the values that YAML decoding alone can't: addresses that parse, files that
+// exist, settings that only make sense together. It reports every problem at once, so fixing a
+// config file doesn't turn into a run-fix-run loop.
+func (c *Config) Validate() error {
+	var errs []error
+	bad := func(format string, args ...interface{}) { errs = append(errs, fmt.Errorf(format, args...)) }
+
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		bad("listen: %q is not a host:port address", c.Listen)
+	}
+	if c.DataDir == "" {
+		bad("data_dir: must not be empty")
+	}
+	if c.PublicURL != "" {
+		u, err := url.Parse(c.PublicURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			bad("public_url: %q must be an absolute http(s) URL", c.PublicURL)
+		}
+	}
+	switch c.Storage.Backend {
+	case "memory":
+	case "erasure":
+		ec := c.Storage.Erasure
+		if ec.Parity < 1 || len(ec.Dirs) <= ec.Parity {
+			bad("storage.erasure: needs parity of at least 1, and more dirs than that")
+		} else if len(ec.Dirs) > 256 {
+			bad("storage.erasure.dirs: at most 256")
+		}
+		seen := map[string]bool{}
+		for i, dir := range ec.Dirs {
+			if dir == "" || seen[filepath.Clean(dir)] {
+				bad("storage.erasure.dirs[%d]: %q must be a directory of its own", i, dir)
+			}
+			seen[filepath.Clean(dir)] = true
+		}
+		if ec.ShardSize < 0 || ec.ShardSize > 16<<20 {
+			bad("storage.erasure.shard_size: must be at most 16MiB")
+		}
+	default:
+		validateBackend(bad, "storage", c.Storage.Backend, c.Storage.S3, c.Storage.Azure, c.Storage.SFTP, c.Storage.IPFS)
+	}
+	if r := c.Storage.Replication; len(r.Replicas) > 0 {
+		for i, replica := range r.Replicas {
+			name := fmt.Sprintf("storage.replication.replicas[%d]", i)
+			switch {
+			case replica.Backend == "memory" || replica.Backend == "":
+				bad("%s.backend: %q must be disk, s3, azure, sftp or ipfs", name, replica.Backend)
+			case replica.Backend == "disk" && replica.Dir == "":
+				bad("%s.dir: a disk replica needs a directory of its own", name)
+			default:
+				validateBackend(bad, name, replica.Backend, replica.S3, replica.Azure, replica.SFTP, replica.IPFS)
+			}
+		}
+		if r.MinCopies < 0 || r.MinCopies > len(r.Replicas)+1 {
+			bad("storage.replication.min_copies: %d must be at most %d, one for each backend", r.MinCopies, len(r.Replicas)+1)
+		}
+		if r.RepairInterval < 0 {
+			bad("storage.replication.repair_interval: must not be negative")
+		}
+		if c.Storage.Backend == "memory" {
+			bad("storage.replication: the memory backend can't be replicated")
+		}
+	} else if r.MinCopies > 1 {
+		bad("storage.replication.min_copies: there are no replicas to keep copies on")
+	}
+	if cold := c.Storage.Cold; cold.Backend != "" {
+		if cold.Backend == "memory" {
+			bad("storage.cold.backend: memory can't be a cold tier")
+		} else {
+			validateBackend(bad, "storage.cold", cold.Backend, cold.S3, cold.Azure, cold.SFTP, cold.IPFS)
+		}
+		if cold.After < 0 || cold.Idle < 0 || cold.Over < 0 || cold.Interval < 0 {
+			bad("storage.cold: after, idle, over and interval must not be negative")
+		} else if cold.After == 0 && cold.Idle == 0 && cold.Over == 0 {
+			bad("storage.cold: set after, idle or over, or no blob ever moves")
+		}
+		if c.Storage.Backend == "memory" {
+			bad("storage.cold: the memory backend keeps nothing worth moving")
+		}
+	}
+	if c.Storage.DirectDownloads < 0 {
+		bad("storage.direct_downloads: must not be negative")
+	} else if c.Storage.DirectDownloads > 0 && c.Storage.Backend != "azure" && c.Storage.Backend != "ipfs" {
+		bad("storage.direct_downloads: only the azure and ipfs backends can send downloads elsewhere")
+	} else if c.Storage.DirectDownloads > 0 && c.Storage.Backend == "ipfs" && c.Storage.IPFS.Gateway == "" {
+		bad("storage.direct_downloads: set storage.ipfs.gateway to send downloads to")
+	} else if c.Storage.DirectDownloads > 0 && c.Storage.ChunkOver > 0 {
+		bad("storage.direct_downloads: chunked blobs can't be downloaded directly; unset chunk_over")
+	} else if c.Storage.DirectDownloads > 0 && c.Storage.EncryptionKey != "" {
+		bad("storage.direct_downloads: blobs encrypted at rest can't be downloaded directly")
+	} else if c.Storage.DirectDownloads > 0 && c.Storage.Compression != "" {
+		bad("storage.direct_downloads: compressed blobs can't be downloaded directly")
+	} else if c.Storage.DirectDownloads > 0 && c.Storage.Cold.Backend != "" {
+		bad("storage.direct_downloads: can't sign URLs for blobs in the cold tier")
+	} else if c.Storage.DirectDownloads > 0 && len(c.Storage.Replication.Replicas) > 0 {
+		bad("storage.direct_downloads: can't sign URLs for replicated blobs")
+	}
+	if len(c.Storage.OldEncryptionKeys) > 0 && c.Storage.EncryptionKey == "" {
+		bad("storage.old_encryption_keys: set encryption_key to the key replacing them")
+	}
+	switch c.Storage.Compression {
+	case "", "gzip":
+	case "zstd":
+		bad("storage.compression: zstd isn't supported, use gzip")
+	default:
+		bad("storage.compression: %q must be gzip or empty", c.Storage.Compression)
+	}
+	if c.Storage.ChunkOver < 0 {
+		bad("storage.chunk_over: must not be negative")
+	}
+	if cs := c.Storage.ChunkSize; cs != 0 && (cs < 64<<10 || cs > 16<<20 || cs&(cs-1) != 0) {
+		bad("storage.chunk_size: %d must be a power of two from 64KiB to 16MiB", cs)
+	}
+	if c.Limits.MaxUploadSize < 0 {
+		bad("limits.max_upload_size: must not be negative")
+	}
+	for i, k := range c.Auth.Keys {
+		if len(k) < 16 {
+			bad("auth.keys[%d]: keys must be at least 16 characters", i)
+		}
+	}
+	for i, k := range c.Auth.AdminKeys {
+		if len(k) < 16 {
+			bad("auth.admin_keys[%d]: keys must be at least 16 characters", i)
+		}
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		bad("tls: cert_file and key_file must be set together")
+	}
+	for _, f := range []struct{ name, path string }{{"tls.cert_file", c.TLS.CertFile}, {"tls.key_file", c.TLS.KeyFile}} {
+		if f.path == "" {
+			continue
+		}
+		if _, err := os.Stat(f.path); err != nil {
+			bad("%s: %v", f.name, err)
+		}
+	}
+	if c.UI.AssetsDir != "" {
+		if fi, err := os.Stat(c.UI.AssetsDir); err != nil || !fi.IsDir() {
+			bad("ui.assets_dir: %q is not a directory", c.UI.AssetsDir)
+		}
+	}
+	if c.Lifecycle.MaxAge < 0 {
+		bad("lifecycle.max_age: must not be negative")
+	}
+	if c.Lifecycle.SweepInterval <= 0 {
+		bad("lifecycle.sweep_interval: must be positive")
+	}
+	switch m := c.Metadata; m.Backend {
+	case "", "files", "sqlite":
+	case "postgres":
+		if u, err := url.Parse(m.Postgres.URL); err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") || u.Hostname() == "" {
+			bad("metadata.postgres.url: must be a URL like postgres://user@host/database")
+		}
+		if m.Postgres.MaxConns < 0 {
+			bad("metadata.postgres.max_conns: must not be negative")
+		}
+	default:
+		bad("metadata.backend: %q must be files, sqlite or postgres", m.Backend)
+	}
+	if b := c.Metadata.Backend; b != "" && b != "sqlite" && c.Metadata.Path != "" {
+		bad("metadata.path: is for the sqlite backend")
+	}
+	if m := c.Metadata.Migrate; m != "" && m != "auto" && m != "manual" {
+		bad("metadata.migrate: %q must be auto or manual", m)
+	}
+	if c.Lifecycle.GCInterval < 0 || c.Lifecycle.GCMinAge < 0 {
+		bad("lifecycle: gc_interval and gc_min_age must not be negative")
+	}
+	if c.Lifecycle.TrashFor < 0 {
+		bad("lifecycle.trash_for: must not be negative")
+	}
+	if c.Versions.Keep < 0 || c.Versions.MaxAge < 0 {
+		bad("versions: keep and max_age must not be negative")
+	}
+	if c.Scan.Clamd != "" && len(c.Scan.Command) > 0 {
+		bad("scan: set either clamd or command, not both")
+	}
+	if c.Scan.Clamd != "" {
+		if network, addr, _ := strings.Cut(c.Scan.Clamd, ":"); addr == "" || (network != "unix" && network != "tcp") {
+			bad("scan.clamd: %q must look like unix:/path or tcp:host:port", c.Scan.Clamd)
+		}
+	}
+	if c.Scan.Action != "reject" && c.Scan.Action != "quarantine" {
+		bad("scan.action: %q must be reject or quarantine", c.Scan.Action)
+	}
+	if c.Scan.Timeout <= 0 {
+		bad("scan.timeout: must be positive")
+	}
+	if c.Links.TTL <= 0 {
+		bad("links.ttl: must be positive")
+	}
+	if c.Links.RotateEvery < 0 {
+		bad("links.rotate_every: must not be negative")
+	}
+	if c.Links.Grace < c.Links.TTL {
+		bad("links.grace: must be at least links.ttl (%s), or rotation would cut signed links short", c.Links.TTL)
+	}
+	if c.Headers.HSTSMaxAge < 0 {
+		bad("headers.hsts_max_age: must not be negative")
+	}
+	for prefix := range c.Headers.Routes {
+		if !strings.HasPrefix(prefix, "/") {
+			bad("headers.routes: %q must be a path starting with /", prefix)
+		}
+	}
+	if c.Fetch.Timeout <= 0 {
+		bad("fetch.timeout: must be positive")
+	}
+	if c.Fetch.MaxSize <= 0 {
+		bad("fetch.max_size: must be positive; a fetch can't be unlimited")
+	}
+	if c.Fetch.MaxRedirects < 0 {
+		bad("fetch.max_redirects: must not be negative")
+	}
+	for i, n := range c.Fetch.AllowNetworks {
+		if _, err := netip.ParsePrefix(n); err != nil {
+			bad("fetch.allow_networks[%d]: %q is not a CIDR like 10.1.0.0/16", i, n)
+		}
+	}
+	for i, name := range c.Integrity.Checksums {
+		if name != "md5" && name != "crc32c" {
+			bad("integrity.checksums[%d]: %q must be md5 or crc32c; SHA-256 is always computed", i, name)
+		}
+	}
+	if c.Integrity.ScrubInterval < 0 {
+		bad("integrity.scrub_interval: must not be negative")
+	}
+	if c.Integrity.ScrubRate < 0 {
+		bad("integrity.scrub_rate: must not be negative")
+	}
+	switch c.Challenge.Provider {
+	case "", "pow":
+	case "hcaptcha", "turnstile":
+		if c.Challenge.SiteKey == "" || c.Challenge.Secret == "" {
+			bad("challenge: %s needs both site_key and secret", c.Challenge.Provider)
+		}
+	default:
+		bad("challenge.provider: %q must be hcaptcha, turnstile or pow", c.Challenge.Provider)
+	}
+	if c.Challenge.Provider == "pow" && c.Cluster.Enabled() && c.Metadata.Backend != "postgres" {
+		bad("challenge.provider: pow in a cluster needs metadata.backend: postgres, where the servers record the solutions spent; without it each would take a solution once")
+	}
+	if c.Challenge.Difficulty < 1 || c.Challenge.Difficulty > 32 {
+		bad("challenge.difficulty: must be between 1 and 32 bits")
+	}
+	if c.Challenge.TTL <= 0 {
+		bad("challenge.ttl: must be positive")
+	}
+	for i, t := range c.Types.InlineAllow {
+		if mt, _, err := mime.ParseMediaType(t); err != nil || !strings.Contains(mt, "/") {
+			bad("types.inline_allow[%d]: %q is not a content type like image/svg+xml", i, t)
+		}
+	}
+	if c.Passwords.FreeAttempts < 1 {
+		bad("passwords.free_attempts: must be at least 1")
+	}
+	if c.Passwords.Lockout <= 0 {
+		bad("passwords.lockout: must be positive")
+	}
+	if c.Passwords.MaxLockout < c.Passwords.Lockout {
+		bad("passwords.max_lockout: must be at least passwords.lockout (%s)", c.Passwords.Lockout)
+	}
+	for i, r := range c.DLP.Rules {
+		switch {
+		case (r.Builtin == "") == (r.Pattern == ""):
+			bad("dlp.rules[%d]: set either builtin or pattern", i)
+		case r.Builtin != "" && r.Builtin != "credit_card" && r.Builtin != "private_key" && r.Builtin != "aws_access_key":
+			bad("dlp.rules[%d].builtin: %q must be credit_card, private_key or aws_access_key", i, r.Builtin)
+		case r.Pattern != "" && r.Name == "":
+			bad("dlp.rules[%d].name: rules with a pattern need a name", i)
+		}
+		if r.Pattern != "" {
+			if _, err := regexp.Compile(r.Pattern); err != nil {
+				bad("dlp.rules[%d].pattern: %v", i, err)
+			}
+		}
+		if r.Action != "warn" && r.Action != "tag" && r.Action != "block" {
+			bad("dlp.rules[%d].action: %q must be warn, tag or block", i, r.Action)
+		}
+	}
+	if c.DLP.MaxBytes <= 0 {
+		bad("dlp.max_bytes: must be positive")
+	}
+	switch c.Images.StripMetadata {
+	case "never", "on_request", "always":
+	default:
+		bad("images.strip_metadata: %q must be never, on_request or always", c.Images.StripMetadata)
+	}
+	if c.Thumbnails.Enabled && len(c.Thumbnails.Sizes) == 0 {
+		bad("thumbnails.sizes: needs at least one width")
+	}
+	for i, w := range c.Thumbnails.Sizes {
+		if w < 16 || w > 4096 {
+			bad("thumbnails.sizes[%d]: %d must be between 16 and 4096 pixels", i, w)
+		}
+	}
+	switch c.Thumbnails.Format {
+	case "jpeg", "png":
+	case "webp", "avif":
+		if len(c.Thumbnails.Encoder) == 0 {
+			bad("thumbnails.format: %s needs thumbnails.encoder", c.Thumbnails.Format)
+		}
+	default:
+		bad("thumbnails.format: %q must be jpeg, png, webp or avif", c.Thumbnails.Format)
+	}
+	if c.Thumbnails.Quality < 1 || c.Thumbnails.Quality > 100 {
+		bad("thumbnails.quality: must be between 1 and 100")
+	}
+	if c.Thumbnails.MaxPixels <= 0 {
+		bad("thumbnails.max_pixels: must be positive")
+	}
+	if c.Media.Width < 16 || c.Media.Width > 4096 {
+		bad("media.width: %d must be between 16 and 4096 pixels", c.Media.Width)
+	}
+	if c.Media.PosterAt < 0 {
+		bad("media.poster_at: must not be negative")
+	}
+	if c.Media.ClipLength < 0 {
+		bad("media.clip_length: must not be negative")
+	}
+	if c.Media.Timeout <= 0 {
+		bad("media.timeout: must be positive")
+	}
+	if c.Preview.MaxBytes <= 0 {
+		bad("preview.max_bytes: must be positive")
+	}
+	if c.Preview.MaxRows < 2 {
+		bad("preview.max_rows: must be at least 2, the header and one row")
+	}
+	checkBrand := func(name string, b Brand) {
+		for _, f := range []struct{ key, v string }{{"accent", b.Accent}, {"background", b.Background}, {"text", b.Text}} {
+			if f.v != "" && !cssColor.MatchString(f.v) {
+				bad("%s.%s: %q is not a CSS colour like #0a7 or teal", name, f.key, f.v)
+			}
+		}
+		if b.Logo != "" && !strings.HasPrefix(b.Logo, "data:image/") && (strings.ContainsAny(b.Logo, `/\`) || strings.HasPrefix(b.Logo, ".")) {
+			bad("%s.logo: %q must be a file name in the assets directory or a data:image/ URL", name, b.Logo)
+		}
+		if b.Locale != "" && i18n.Get(b.Locale) == nil {
+			bad("%s.locale: %q is not one of %s", name, b.Locale, strings.Join(i18n.Supported(), ", "))
+		}
+	}
+	checkBrand("branding", c.Branding.Brand)
+	domains := map[string]bool{}
+	for i, t := range c.Branding.Tenants {
+		name := fmt.Sprintf("branding.tenants[%d]", i)
+		if t.Domain == "" || strings.ContainsAny(t.Domain, "/:@ ") {
+			bad("%s.domain: %q must be a host name like files.example.com", name, t.Domain)
+		}
+		if domains[strings.ToLower(t.Domain)] {
+			bad("%s.domain: %q is used by another tenant", name, t.Domain)
+		}
+		domains[strings.ToLower(t.Domain)] = true
+		checkBrand(name, t.Brand)
+		if t.AssetsDir != "" {
+			if fi, err := os.Stat(t.AssetsDir); err != nil || !fi.IsDir() {
+				bad("%s.assets_dir: %q is not a directory", name, t.AssetsDir)
+			}
+		}
+	}
+	for i, h := range c.Webhooks.Hooks {
+		name := fmt.Sprintf("webhooks.hooks[%d]", i)
+		if u, err := url.Parse(h.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			bad("%s.url: %q must be an http(s) URL", name, h.URL)
+		}
+		for _, e := range h.Events {
+			if !slices.Contains(WebhookEvents, e) {
+				bad("%s.events: %q must be one of %s", name, e, strings.Join(WebhookEvents, ", "))
+			}
+		}
+	}
+	if c.Webhooks.MaxAttempts < 1 {
+		bad("webhooks.max_attempts: must be at least 1")
+	}
+	if c.Webhooks.Backoff <= 0 {
+		bad("webhooks.backoff: must be positive")
+	}
+	if c.Webhooks.Timeout <= 0 {
+		bad("webhooks.timeout: must be positive")
+	}
+	if c.Bus.NATS != "" {
+		// the URL can hold a password: leave it out of the message
+		if u, err := url.Parse(c.Bus.NATS); err != nil || (u.Scheme != "nats" && u.Scheme != "tls") || u.Hostname() == "" {
+			bad("bus.nats: must be a nats:// or tls:// URL")
+		}
+	}
+	if c.Bus.Subject == "" || strings.ContainsAny(c.Bus.Subject, " \t*>") {
+		bad("bus.subject: %q must be a subject without spaces or wildcards", c.Bus.Subject)
+	}
+	for _, e := range c.Bus.Events {
+		if !slices.Contains(WebhookEvents, e) {
+			bad("bus.events: %q must be one of %s", e, strings.Join(WebhookEvents, ", "))
+		}
+	}
+	for i, p := range c.Plugins {
+		name := fmt.Sprintf("plugins[%d]", i)
+		if !slices.Contains(PluginPoints, p.Point) {
+			bad("%s.point: %q must be one of %s", name, p.Point, strings.Join(PluginPoints, ", "))
+		}
+		switch {
+		case len(p.Command) > 0 && p.URL != "":
+			bad("%s: set command or url, not both", name)
+		case len(p.Command) == 0 && p.URL == "":
+			bad("%s: needs a command or a url", name)
+		}
+		if p.URL != "" {
+			if u, err := url.Parse(p.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				bad("%s.url: %q must be an http(s) URL", name, p.URL)
+			}
+		}
+		for _, e := range p.Env {
+			if k, _, ok := strings.Cut(e, "="); !ok || k == "" {
+				bad("%s.env: %q must look like NAME=value", name, e)
+			}
+		}
+		if p.Timeout < 0 {
+			bad("%s.timeout: must not be negative", name)
+		}
+	}
+	if c.Cluster.LeaseTTL < 3*time.Second {
+		bad("cluster.lease_ttl: must be at least 3s")
+	}
+	if strings.ContainsAny(c.Cluster.NodeID, `/\ `) {
+		bad("cluster.node_id: %q must not contain slashes or spaces", c.Cluster.NodeID)
+	}
+	if c.Backup.Keep < 1 {
+		bad("backup.keep: must be at least 1")
+	}
+	if c.Backup.Every < 0 {
+		bad("backup.every: must not be negative")
+	}
+	if c.Backup.Every > 0 && c.Backup.Target == "" {
+		bad("backup.every: needs a target")
+	}
+	if _, err := logx.ParseLevel(c.Log.Level); err != nil {
+		bad("log.level: %v", err)
+	}
+	for name, level := range c.Log.Levels {
+		if name == "" || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".") || strings.Contains(name, "..") {
+			bad("log.levels: %q is not a component name like upload or storage.s3", name)
+		} else if _, err := logx.ParseLevel(level); err != nil {
+			bad("log.levels: %s: %v", name, err)
+		}
+	}
+	switch c.Log.Format {
+	case "auto", "text", "json", "pretty":
+	default:
+		bad("log.format: %q must be auto, text, json or pretty", c.Log.Format)
+	}
+	if _, _, err := c.Log.TimeLayout(); err != nil {
+		bad("log.time_format or time_zone: %v", err)
+	}
+	if _, _, err := c.Log.Filters(); err != nil {
+		bad("log.%v", err)
+	}
+	if c.Log.Access != "" && c.Log.Access != "log" && c.Log.Access != "combined" {
+		bad("log.access: %q must be log, combined or empty", c.Log.Access)
+	}
+	if c.Log.Stderr != "" {
+		if _, err := logx.ParseLevel(c.Log.Stderr); err != nil {
+			bad("log.stderr: %v", err)
+		} else if c.Log.File == "" && !c.Log.Journal {
+			bad("log.stderr: only applies with a log file or the journal; without them everything goes to standard error")
+		}
+	}
+	if c.Log.Split != "" {
+		if _, err := logx.ParseLevel(c.Log.Split); err != nil {
+			bad("log.split: %v", err)
+		} else if c.Log.File != "" || c.Log.Journal {
+			bad("log.split: only applies when the log goes to standard error, not with a log file or the journal")
+		}
+	}
+	if c.Log.Journal && runtime.GOOS != "linux" {
+		bad("log.journal: the systemd journal is only on Linux")
+	}
+	if c.Log.EventLog != "" && runtime.GOOS != "windows" {
+		bad("log.event_log: the Windows Event Log is only on Windows")
+	}
+	if c.Log.Syslog != "" {
+		if _, _, err := c.Log.SyslogAddr(); err != nil {
+			bad("log.syslog: %v", err)
+		}
+	}
+	if c.Log.GELF != "" {
+		if _, _, err := c.Log.GELFAddr(); err != nil {
+			bad("log.gelf: %v", err)
+		}
+	}
+	if c.Log.OTLP != "" {
+		if u, err := url.Parse(c.Log.OTLP); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			bad("log.otlp: %q must be an http:// or https:// URL", c.Log.OTLP)
+		}
+	}
+	if c.Log.Logstash != "" {
+		if _, err := c.Log.LogstashAddr(); err != nil {
+			bad("log.logstash: %v", err)
+		}
+	}
+	if _, err := logx.NewRedactor(c.Log.RedactFields, c.Log.Redact); err != nil {
+		bad("log.redact: %v", err)
+	}
+	if c.Log.Buffer < 0 {
+		bad("log.buffer: must not be negative")
+	}
+	if c.Log.Dedup < 0 {
+		bad("log.dedup: must not be negative")
+	}
+	if c.Log.Recent < 0 {
+		bad("log.recent: must not be negative")
+	}
+	if c.Log.Overflow != "block" && c.Log.Overflow != "drop" {
+		bad("log.overflow: %q must be block or drop", c.Log.Overflow)
+	}
+	if c.Log.MaxSize < 0 || c.Log.MaxAge < 0 || c.Log.MaxBackups < 0 {
+		bad("log: max_size, max_age and max_backups must not be negative")
+	}
+	if c.Log.FileBuffer < 0 || c.Log.FlushEvery < 0 {
+		bad("log: file_buffer and flush_every must not be negative")
+	}
+	if c.Log.Sync != "" {
+		if _, err := logx.ParseLevel(c.Log.Sync); err != nil {
+			bad("log.sync: %v", err)
+		}
+	}
+	if c.Vault.Token != "" && c.Vault.TokenFile != "" {
+		bad("vault: set token or token_file, not both")
+	}
+	return errors.Join(errs...)
+}
+
+// cssColor accepts the colour syntaxes that can't break out of a CSS declaration.
+var cssColor = regexp.MustCompile(`^(#[0-9a-fA-F]{3,8}|[a-zA-Z]+|(rgb|rgba|hsl|hsla)\([0-9.,%/ ]+\))$`)
+
+// Secrets calls fn for every string held by a field tagged `secret:"true"`, with the setting's
+// name as written in the config file ("auth.keys[1]"). fn may change the value, which is how
+// secret references get replaced by what they point to.
+func (c *Config) Secrets(fn func(name string, v *string) error) error {
+	return eachSecret(reflect.ValueOf(c).Elem(), "", false, fn)
+}
+
+func eachSecret(v reflect.Value, name string, secret bool, fn func(string, *string) error) error {
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			key, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+			if name != "" {
+				key = name + "." + key
+			}
+			if err := eachSecret(v.Field(i), key, secret || f.Tag.Get("secret") == "true", fn); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if err := eachSecret(v.Index(i), fmt.Sprintf("%s[%d]", name, i), secret, fn); err != nil {
+				return err
+			}
+		}
+	case reflect.String:
+		if secret {
+			return fn(name, v.Addr().Interface().(*string))
+		}
+	}
+	return nil
+}
+
+// Redacted is the marker that replaces secret values in Redact's output.
+const Redacted = "REDACTED"
+
+// Redact returns a deep copy of c with every field tagged `secret:"true"` masked, which is
+// what `config print --redact-secrets` shows. Tagging a new field is all it takes to keep it
+// out of printed configs.
+func (c *Config) Redact() *Config {
+	cp := deepCopy(reflect.ValueOf(c).Elem())
+	redact(cp)
+	out := cp.Interface().(Config)
+	return &out
+}
+
+// deepCopy copies a value so that slices in the copy don't share storage with the original.
+func deepCopy(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Struct:
+		out := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.NumField(); i++ {
+			if out.Field(i).CanSet() {
+				out.Field(i).Set(deepCopy(v.Field(i)))
+			}
+		}
+		return out
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			out.Index(i).Set(deepCopy(v.Index(i)))
+		}
+		return out
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			out.SetMapIndex(iter.Key(), deepCopy(iter.Value()))
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+func redact(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Field(i)
+			if !f.CanSet() {
+				continue
+			}
+			if t.Field(i).Tag.Get("secret") == "true" {
+				mask(f)
+				continue
+			}
+			redact(f)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			redact(v.Index(i))
+		}
+	}
+}
+
+// mask blanks out a secret field, leaving empty values empty so it's still visible whether
+// a secret is set at all.
+func mask(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.String:
+		if v.String() != "" {
+			v.SetString(Redacted)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			mask(v.Index(i))
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			if iter.Value().Kind() == reflect.String {
+				v.SetMapIndex(iter.Key(), reflect.ValueOf(Redacted).Convert(iter.Value().Type()))
+			}
+		}
+	}
+}
+
+// validateBackend checks the settings of the backend storage or storage.cold, which name
+// says, is set to.
+func validateBackend(bad func(string, ...interface{}), name, backend string, s3 S3, az Azure, sf SFTP, ip IPFS) {
+	switch backend {
+	case "disk":
+	case "s3":
+		if s3.Bucket == "" || s3.Region == "" {
+			bad(name + ".s3: bucket and region are required")
+		}
+		if s3.Endpoint != "" {
+			if u, err := url.Parse(s3.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				bad(name+".s3.endpoint: %q must be an http(s) URL", s3.Endpoint)
+			}
+		}
+		if s3.AccessKey == "" || s3.SecretKey == "" {
+			bad(name + ".s3: access_key and secret_key are required")
+		}
+		if s3.SSE != "" && s3.SSE != "AES256" && s3.SSE != "aws:kms" {
+			bad(name+".s3.sse: %q must be AES256, aws:kms or empty", s3.SSE)
+		}
+		if s3.KMSKeyID != "" && s3.SSE != "aws:kms" {
+			bad(name + ".s3.kms_key_id: only applies with sse: aws:kms")
+		}
+		if s3.PartSize != 0 && s3.PartSize < 5<<20 {
+			bad(name + ".s3.part_size: must be at least 5MiB, the smallest part S3 takes")
+		}
+	case "azure":
+		if az.Account == "" || az.Container == "" || az.Key == "" {
+			bad(name + ".azure: account, container and key are required")
+		}
+		if az.Endpoint != "" {
+			if u, err := url.Parse(az.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				bad(name+".azure.endpoint: %q must be an http(s) URL", az.Endpoint)
+			}
+		}
+		switch az.Tier {
+		case "", "Hot", "Cool", "Cold", "Archive":
+		default:
+			bad(name+".azure.tier: %q must be Hot, Cool, Cold, Archive or empty", az.Tier)
+		}
+		if az.BlockSize < 0 || az.BlockSize > 4000<<20 {
+			bad(name + ".azure.block_size: must be at most 4000MiB, the largest block Azure takes")
+		}
+	case "sftp":
+		if sf.Host == "" || sf.Dir == "" {
+			bad(name + ".sftp: host and dir are required")
+		} else if !path.IsAbs(sf.Dir) {
+			bad(name+".sftp.dir: %q must be an absolute path on the host", sf.Dir)
+		}
+		if sf.Port < 0 || sf.Port > 65535 {
+			bad(name+".sftp.port: %d is not a port", sf.Port)
+		}
+		if sf.Conns < 0 || sf.Retries < 0 {
+			bad(name + ".sftp: conns and retries must not be negative")
+		}
+	case "ipfs":
+		for _, f := range []struct{ field, value string }{{"api", ip.API}, {"gateway", ip.Gateway}, {"pin_service", ip.PinService}} {
+			if f.value == "" {
+				continue
+			}
+			if u, err := url.Parse(f.value); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				bad(name+".ipfs.%s: %q must be an http(s) URL", f.field, f.value)
+			}
+		}
+		if ip.Dir != "" && !path.IsAbs(ip.Dir) {
+			bad(name+".ipfs.dir: %q must be an absolute MFS path, like /filegoblin", ip.Dir)
+		}
+		if ip.PinService != "" && ip.PinToken == "" {
+			bad(name + ".ipfs.pin_token: the pinning service needs a token")
+		}
+	case "erasure":
+		bad(name + ".backend: erasure only works as storage's own backend")
+	default:
+		bad(name+".backend: %q must be disk, memory, erasure, s3, azure, sftp or ipfs", backend)
+	}
+}
@@ -0,0 +1,129 @@
+// Package dlp looks for sensitive content, like card numbers and private keys, in text
+// uploads, so deployments with compliance rules can keep it from being shared by accident.
+package dlp
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+
+	"github.com/hey-granth/filegoblin/internal/config"
+)
+
+// Actions a rule can take when it matches.
+const (
+	ActionWarn  = "warn"  // log it
+	ActionTag   = "tag"   // log it and mark the file
+	ActionBlock = "block" // refuse the upload
+)
+
+// builtin is a ready-made rule. valid, when set, weeds out matches that only look right.
+type builtin struct {
+	pattern string
+	valid   func([]byte) bool
+}
+
+var builtins = map[string]builtin{
+	"credit_card":    {`\b(?:\d[ -]?){12,18}\d\b`, luhn},
+	"private_key":    {`-----BEGIN (?:[A-Z0-9]+ )*PRIVATE KEY-----`, nil},
+	"aws_access_key": {`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`, nil},
+}
+
+// Rule is a compiled config.DLPRule.
+type Rule struct {
+	Name   string
+	Action string
+	re     *regexp.Regexp
+	valid  func([]byte) bool
+}
+
+// Match is a rule that matched, and how often.
+type Match struct {
+	Rule   string `json:"rule"`
+	Action string `json:"action"`
+	Count  int    `json:"count"`
+}
+
+// Scanner checks content against a set of rules.
+type Scanner struct {
+	Rules []Rule
+	Limit int64 // bytes read from each file; the rest isn't checked
+}
+
+// New compiles the rules in cfg. It returns nil when there are none.
+func New(cfg config.DLP) (*Scanner, error) {
+	if len(cfg.Rules) == 0 {
+		return nil, nil
+	}
+	s := &Scanner{Limit: int64(cfg.MaxBytes)}
+	for _, rc := range cfg.Rules {
+		r := Rule{Name: rc.Name, Action: rc.Action}
+		pattern := rc.Pattern
+		if rc.Builtin != "" {
+			b, ok := builtins[rc.Builtin]
+			if !ok {
+				return nil, fmt.Errorf("dlp: no builtin rule %q", rc.Builtin)
+			}
+			pattern, r.valid = b.pattern, b.valid
+			if r.Name == "" {
+				r.Name = rc.Builtin
+			}
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("dlp: rule %s: %w", r.Name, err)
+		}
+		r.re = re
+		s.Rules = append(s.Rules, r)
+	}
+	return s, nil
+}
+
+// Scan reads up to Limit bytes of r and reports the rules that matched, in rule order.
+func (s *Scanner) Scan(r io.Reader) ([]Match, error) {
+	if s.Limit > 0 {
+		r = io.LimitReader(r, s.Limit)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var out []Match
+	for _, rule := range s.Rules {
+		n := 0
+		for _, m := range rule.re.FindAll(data, -1) {
+			if rule.valid == nil || rule.valid(m) {
+				n++
+			}
+		}
+		if n > 0 {
+			out = append(out, Match{Rule: rule.Name, Action: rule.Action, Count: n})
+		}
+	}
+	return out, nil
+}
+
+// luhn reports whether the digits in s, ignoring separators, make a plausible card number:
+// 13 to 19 digits with a valid Luhn check digit.
+func luhn(s []byte) bool {
+	var digits []int
+	for _, c := range s {
+		if c >= '0' && c <= '9' {
+			digits = append(digits, int(c-'0'))
+		}
+	}
+	if len(digits) < 13 || len(digits) > 19 {
+		return false
+	}
+	sum := 0
+	for i := range digits {
+		d := digits[len(digits)-1-i]
+		if i%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
@@ -0,0 +1,267 @@
+// Package e2e implements filegoblin's end-to-end encryption format. Files are encrypted on the
+// client before upload, so the server only ever stores ciphertext; the key travels in the URL
+// fragment (which browsers never send to the server) or out of band.
+//
+// The format is deliberately simple enough to reimplement with WebCrypto in the browser:
+//
+//	header:  "FGE1" | chunk size (uint32 BE) | nonce prefix (7 random bytes)
+//	chunks:  AES-256-GCM(chunk), nonce = prefix | counter (uint32 BE) | last (1 byte, 0 or 1)
+//
+// Every chunk is authenticated with the header as additional data, and the "last" flag stops
+// an attacker from truncating the stream at a chunk boundary. The content and file name keys
+// are derived from the 32-byte master key with HKDF-SHA256.
+package e2e
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	magic      = "FGE1"
+	headerSize = 4 + 4 + 7
+	tagSize    = 16
+	// ChunkSize is how much plaintext goes into each sealed chunk.
+	ChunkSize = 64 << 10
+	// KeySize is the length of a master key.
+	KeySize = 32
+	// NamePrefix marks an encrypted file name, so clients can tell it from a plain one.
+	NamePrefix = "fge1."
+)
+
+// ErrCorrupt means the ciphertext was modified, truncated, or the key is wrong.
+var ErrCorrupt = errors.New("e2e: decryption failed (wrong key or corrupted data)")
+
+// NewKey returns a fresh random master key.
+func NewKey() []byte {
+	k := make([]byte, KeySize)
+	_, _ = rand.Read(k)
+	return k
+}
+
+// EncodeKey turns a key into the URL-safe text used in links ("#k=...").
+func EncodeKey(k []byte) string { return base64.RawURLEncoding.EncodeToString(k) }
+
+// DecodeKey parses a key produced by EncodeKey.
+func DecodeKey(s string) ([]byte, error) {
+	k, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(k) != KeySize {
+		return nil, errors.New("e2e: invalid key")
+	}
+	return k, nil
+}
+
+func deriveAEAD(master []byte, purpose string) (cipher.AEAD, error) {
+	if len(master) != KeySize {
+		return nil, errors.New("e2e: invalid key")
+	}
+	sub, err := hkdf.Key(sha256.New, master, nil, "filegoblin e2e "+purpose, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(sub)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// IsEncrypted reports whether data starts like a stream written by NewWriter. Servers use it
+// to sanity-check uploads that claim to be encrypted; it proves nothing about the content.
+func IsEncrypted(head []byte) bool { return len(head) >= headerSize && string(head[:4]) == magic }
+
+// EncryptedSize is the ciphertext length for n bytes of plaintext, so uploads of encrypted
+// files can still send an exact Content-Length.
+func EncryptedSize(n int64) int64 {
+	chunks := (n + ChunkSize - 1) / ChunkSize
+	if chunks == 0 {
+		chunks = 1 // an empty file still gets one (empty, final) chunk
+	}
+	return headerSize + n + chunks*tagSize
+}
+
+func chunkNonce(prefix []byte, counter uint32, last bool) []byte {
+	n := make([]byte, 12)
+	copy(n, prefix)
+	binary.BigEndian.PutUint32(n[7:11], counter)
+	if last {
+		n[11] = 1
+	}
+	return n
+}
+
+// writer buffers one chunk of plaintext. A full chunk is only sealed once more data shows
+// up, because until then we can't know whether it is the last one.
+type writer struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	header  []byte
+	buf     []byte
+	counter uint32
+	closed  bool
+}
+
+// NewWriter returns a WriteCloser that encrypts everything written to it into w. Close must
+// be called to write the final chunk; it does not close w.
+func NewWriter(w io.Writer, key []byte) (io.WriteCloser, error) {
+	aead, err := deriveAEAD(key, "content")
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, headerSize)
+	copy(header, magic)
+	binary.BigEndian.PutUint32(header[4:8], ChunkSize)
+	_, _ = rand.Read(header[8:])
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &writer{w: w, aead: aead, header: header, buf: make([]byte, 0, ChunkSize)}, nil
+}
+
+func (e *writer) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, errors.New("e2e: write after close")
+	}
+	n := 0
+	for len(p) > 0 {
+		if len(e.buf) == ChunkSize {
+			if err := e.seal(false); err != nil {
+				return n, err
+			}
+		}
+		k := copy(e.buf[len(e.buf):ChunkSize], p)
+		e.buf = e.buf[:len(e.buf)+k]
+		p = p[k:]
+		n += k
+	}
+	return n, nil
+}
+
+func (e *writer) seal(last bool) error {
+	if e.counter == ^uint32(0) {
+		return errors.New("e2e: file too large")
+	}
+	out := e.aead.Seal(nil, chunkNonce(e.header[8:], e.counter, last), e.buf, e.header)
+	e.counter++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(out)
+	return err
+}
+
+func (e *writer) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	return e.seal(true)
+}
+
+type reader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	header  []byte
+	chunk   int
+	counter uint32
+	plain   []byte
+	done    bool
+}
+
+// NewReader decrypts a stream produced by NewWriter. Any tampering, truncation or wrong key
+// surfaces as ErrCorrupt from Read.
+func NewReader(r io.Reader, key []byte) (io.Reader, error) {
+	aead, err := deriveAEAD(key, "content")
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, ErrCorrupt
+	}
+	if string(header[:4]) != magic {
+		return nil, errors.New("e2e: not an encrypted filegoblin file")
+	}
+	chunk := int(binary.BigEndian.Uint32(header[4:8]))
+	if chunk <= 0 || chunk > 16<<20 {
+		return nil, ErrCorrupt
+	}
+	return &reader{r: bufio.NewReaderSize(r, chunk+tagSize+1), aead: aead, header: header, chunk: chunk}, nil
+}
+
+func (d *reader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *reader) next() error {
+	buf := make([]byte, d.chunk+tagSize)
+	n, err := io.ReadFull(d.r, buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return ErrCorrupt // not even a tag's worth of data where a chunk should be
+	}
+	// a short read means this was the last chunk; a full one is last only if nothing follows
+	last := err == io.ErrUnexpectedEOF
+	if !last {
+		if _, perr := d.r.Peek(1); perr == io.EOF {
+			last = true
+		}
+	}
+	plain, oerr := d.aead.Open(buf[:0], chunkNonce(d.header[8:], d.counter, last), buf[:n], d.header)
+	if oerr != nil {
+		return ErrCorrupt
+	}
+	d.counter++
+	d.plain = plain
+	d.done = last
+	return nil
+}
+
+// EncryptName seals a file name so the server can store it without learning it.
+func EncryptName(name string, key []byte) (string, error) {
+	aead, err := deriveAEAD(key, "name")
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, _ = rand.Read(nonce)
+	sealed := aead.Seal(nonce, nonce, []byte(name), nil)
+	return NamePrefix + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptName reverses EncryptName. Names without the prefix are returned unchanged.
+func DecryptName(enc string, key []byte) (string, error) {
+	if !strings.HasPrefix(enc, NamePrefix) {
+		return enc, nil
+	}
+	aead, err := deriveAEAD(key, "name")
+	if err != nil {
+		return "", err
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(enc, NamePrefix))
+	if err != nil || len(raw) < aead.NonceSize() {
+		return "", fmt.Errorf("e2e: malformed encrypted name")
+	}
+	plain, err := aead.Open(nil, raw[:aead.NonceSize()], raw[aead.NonceSize():], nil)
+	if err != nil {
+		return "", ErrCorrupt
+	}
+	return string(plain), nil
+}
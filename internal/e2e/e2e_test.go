@@ -0,0 +1,84 @@
+package e2e
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+)
+
+// TestRoundTrip encrypts and decrypts payloads around the chunk boundaries and checks the
+// predicted ciphertext size.
+func TestRoundTrip(t *testing.T) {
+	key := NewKey()
+	for _, n := range []int{0, 1, ChunkSize - 1, ChunkSize, ChunkSize + 1, 3 * ChunkSize} {
+		plain := make([]byte, n)
+		_, _ = rand.Read(plain)
+
+		var ct bytes.Buffer
+		w, err := NewWriter(&ct, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(plain); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if int64(ct.Len()) != EncryptedSize(int64(n)) {
+			t.Fatalf("n=%d: ciphertext is %d bytes, EncryptedSize says %d", n, ct.Len(), EncryptedSize(int64(n)))
+		}
+
+		r, err := NewReader(bytes.NewReader(ct.Bytes()), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("n=%d: %v", n, err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Fatalf("n=%d: plaintext mismatch", n)
+		}
+	}
+}
+
+// TestTruncationDetected cuts the stream at a chunk boundary, which must not decrypt cleanly.
+func TestTruncationDetected(t *testing.T) {
+	key := NewKey()
+	var ct bytes.Buffer
+	w, _ := NewWriter(&ct, key)
+	_, _ = w.Write(make([]byte, 2*ChunkSize+10))
+	_ = w.Close()
+
+	cut := ct.Bytes()[:headerSize+ChunkSize+tagSize]
+	r, err := NewReader(bytes.NewReader(cut), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(r); err != ErrCorrupt {
+		t.Fatalf("truncated stream: got %v, want ErrCorrupt", err)
+	}
+
+	r, _ = NewReader(bytes.NewReader(ct.Bytes()), NewKey())
+	if _, err := io.ReadAll(r); err != ErrCorrupt {
+		t.Fatalf("wrong key: got %v, want ErrCorrupt", err)
+	}
+}
+
+// TestNames checks file name encryption.
+func TestNames(t *testing.T) {
+	key := NewKey()
+	enc, err := EncryptName("tax return 2025.pdf", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecryptName(enc, key)
+	if err != nil || got != "tax return 2025.pdf" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+	if _, err := DecryptName(enc, NewKey()); err == nil {
+		t.Fatal("decrypted a name with the wrong key")
+	}
+}
@@ -0,0 +1,144 @@
+// Package fetch downloads URLs on behalf of users without letting them reach anything the
+// server itself can: private networks, loopback, link-local (and with it every cloud
+// metadata service), and the like.
+//
+// The address check runs in the dialer, against the IP actually being connected to. That
+// covers redirects and DNS rebinding alike, since a hostname that resolved to a public
+// address a moment ago is checked again when it resolves to 127.0.0.1 now.
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// ErrBlocked is returned for URLs pointing at addresses users may not reach.
+var ErrBlocked = errors.New("fetch: destination address is not allowed")
+
+// blocked lists ranges that are never fetched unless explicitly allowed. Loopback, private,
+// link-local and multicast addresses are caught by netip's predicates; these are the rest.
+var blocked = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // "this network"
+	netip.MustParsePrefix("100.64.0.0/10"),  // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),   // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),  // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),    // reserved, including broadcast
+	netip.MustParsePrefix("64:ff9b::/96"),   // NAT64, which can wrap any IPv4 address
+	netip.MustParsePrefix("64:ff9b:1::/48"), // local-use NAT64
+	netip.MustParsePrefix("2002::/16"),      // 6to4, same problem
+	netip.MustParsePrefix("fec0::/10"),      // deprecated site-local
+}
+
+// Options configure a Client.
+type Options struct {
+	Timeout      time.Duration  // for the whole fetch, body included
+	MaxRedirects int            // redirects to follow
+	Allow        []netip.Prefix // ranges exempt from blocking, e.g. an internal mirror
+}
+
+// Allowed reports whether ip may be fetched from.
+func (o Options) Allowed(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	for _, p := range o.Allow {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, p := range blocked {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// NewClient returns an http.Client that only talks http(s) to allowed addresses.
+func NewClient(o Options) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			ap, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return fmt.Errorf("%w: %s", ErrBlocked, address)
+			}
+			if !o.Allowed(ap.Addr()) {
+				return fmt.Errorf("%w: %s", ErrBlocked, ap.Addr())
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: o.Timeout,
+		Transport: &http.Transport{
+			// no proxy: the proxy would do the connecting, and our address check with it
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: 30 * time.Second,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       30 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > o.MaxRedirects {
+				return fmt.Errorf("fetch: stopped after %d redirects", o.MaxRedirects)
+			}
+			return o.CheckURL(req.URL)
+		},
+	}
+}
+
+// CheckURL rejects URLs the client would refuse anyway, so callers can fail early with a
+// clear message: anything but http(s), credentials in the URL, or a literal blocked address.
+// Hostnames are left to the dialer, which checks whatever they resolve to.
+func (o Options) CheckURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("fetch: only http and https URLs can be fetched, not %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return errors.New("fetch: URL has no host")
+	}
+	if u.User != nil {
+		return errors.New("fetch: URLs with credentials are not allowed")
+	}
+	if ip, err := netip.ParseAddr(u.Hostname()); err == nil && !o.Allowed(ip) {
+		return fmt.Errorf("%w: %s", ErrBlocked, ip)
+	}
+	return nil
+}
+
+// Get starts fetching rawURL with c, a client made from o. The caller closes the body and is
+// responsible for capping how much of it is read.
+func Get(ctx context.Context, c *http.Client, o Options, rawURL string) (*http.Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("fetch: %w", err)
+	}
+	if err := o.CheckURL(u); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "filegoblin-fetch")
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("fetch: %s answered %s", u.Host, resp.Status)
+	}
+	return resp, nil
+}
@@ -0,0 +1,102 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestAllowed(t *testing.T) {
+	var o Options
+	for addr, want := range map[string]bool{
+		"93.184.216.34":      true,
+		"2606:2800:220:1::1": true,
+		"127.0.0.1":          false,
+		"10.1.2.3":           false,
+		"172.16.0.1":         false,
+		"192.168.1.1":        false,
+		"169.254.169.254":    false, // cloud metadata
+		"100.64.0.1":         false,
+		"0.0.0.0":            false,
+		"::1":                false,
+		"fd00:ec2::254":      false, // AWS metadata over IPv6
+		"fe80::1":            false,
+		"::ffff:127.0.0.1":   false, // IPv4-mapped loopback
+		"64:ff9b::a9fe:a9fe": false, // NAT64 of 169.254.169.254
+		"2002:7f00:1::":      false, // 6to4 of 127.0.0.1
+		"255.255.255.255":    false,
+		"224.0.0.1":          false,
+		"2001:db8::1":        true, // documentation range, harmless
+	} {
+		if got := o.Allowed(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("Allowed(%s) = %v, want %v", addr, got, want)
+		}
+	}
+	o.Allow = []netip.Prefix{netip.MustParsePrefix("10.1.0.0/16")}
+	if !o.Allowed(netip.MustParseAddr("10.1.2.3")) {
+		t.Error("allow_networks not honoured")
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	var o Options
+	for raw, want := range map[string]error{
+		"https://example.com/f":       nil,
+		"http://93.184.216.34:8080/f": nil,
+		"http://127.0.0.1/":           ErrBlocked,
+		"http://169.254.169.254/meta": ErrBlocked,
+		"http://[::1]:8080/":          ErrBlocked,
+		"http://[::ffff:10.0.0.1]/":   ErrBlocked,
+		"http://localhost/":           nil, // left to the dialer
+		"http://10.1.2.3/":            ErrBlocked,
+	} {
+		u, err := url.Parse(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := o.CheckURL(u); !errors.Is(err, want) {
+			t.Errorf("%s: got %v, want %v", raw, err, want)
+		}
+	}
+	o.Allow = []netip.Prefix{netip.MustParsePrefix("10.1.0.0/16")}
+	if err := o.CheckURL(&url.URL{Scheme: "http", Host: "10.1.2.3"}); err != nil {
+		t.Errorf("allowed literal: %v", err)
+	}
+}
+
+// TestBlocksLoopback fetches from a local test server, which must be refused unless loopback
+// is explicitly allowed, and tries a few URLs that must never be fetched at all.
+func TestBlocksLoopback(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("internal secrets"))
+	}))
+	defer target.Close()
+
+	o := Options{MaxRedirects: 3}
+	c := NewClient(o)
+	if _, err := Get(context.Background(), c, o, target.URL); !errors.Is(err, ErrBlocked) {
+		t.Fatalf("loopback fetch: got %v, want ErrBlocked", err)
+	}
+	// by name, so it's the dialer rather than CheckURL that has to refuse it
+	byName := strings.Replace(target.URL, "127.0.0.1", "localhost", 1)
+	if _, err := Get(context.Background(), c, o, byName); !errors.Is(err, ErrBlocked) {
+		t.Fatalf("loopback fetch by name: got %v, want ErrBlocked", err)
+	}
+	for _, u := range []string{"file:///etc/passwd", "gopher://x/", "http://user:pw@example.com/"} {
+		if _, err := Get(context.Background(), c, o, u); err == nil {
+			t.Errorf("%s: fetched", u)
+		}
+	}
+
+	o.Allow = []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}
+	resp, err := Get(context.Background(), NewClient(o), o, target.URL)
+	if err != nil {
+		t.Fatalf("allowed fetch: %v", err)
+	}
+	resp.Body.Close()
+}
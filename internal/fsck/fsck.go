@@ -0,0 +1,246 @@
+// Package fsck cross-checks the metadata index against the storage backend and cleans up
+// what a crash or a manual intervention can leave behind. It is meant to run offline, or
+// while the server is quiesced: a blob that is still being uploaded looks just like an
+// orphan, which is why Collect only touches things older than a grace period.
+package fsck
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/metadata"
+	"github.com/hey-granth/filegoblin/internal/storage"
+)
+
+// Problem kinds reported by Check.
+const (
+	Missing = "missing" // a record whose blob is gone
+	Corrupt = "corrupt" // a blob whose size or checksum doesn't match its record
+	Orphan  = "orphan"  // a blob that no record points to
+)
+
+// Problem is one inconsistency found by Check.
+type Problem struct {
+	Kind   string `json:"kind"`
+	ID     string `json:"id"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Report is the outcome of a Check.
+type Report struct {
+	Records  int       `json:"records"`
+	Blobs    int       `json:"blobs"`
+	Problems []Problem `json:"problems"`
+}
+
+// OK reports whether Check found nothing wrong.
+func (r *Report) OK() bool { return len(r.Problems) == 0 }
+
+// Check compares every record with its blob and every blob with the index. With checksums
+// set, each blob is read in full and hashed; otherwise only sizes are compared.
+func Check(ctx context.Context, store storage.Backend, index *metadata.Index, checksums bool) (*Report, error) {
+	rep := &Report{Problems: []Problem{}}
+	sums := map[string]string{} // of deduplicated blobs already read, which records share
+	for _, f := range index.List() {
+		rep.Records++
+		info, err := store.Stat(ctx, f.BlobKey())
+		if errors.Is(err, storage.ErrNotFound) {
+			rep.Problems = append(rep.Problems, Problem{Kind: Missing, ID: f.ID, Detail: f.Name})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if info.Size != f.Size {
+			rep.Problems = append(rep.Problems, Problem{Kind: Corrupt, ID: f.ID,
+				Detail: fmt.Sprintf("size is %d bytes, record says %d", info.Size, f.Size)})
+			continue
+		}
+		if checksums && f.SHA256 != "" {
+			sum, ok := sums[f.BlobKey()]
+			if !ok {
+				if sum, err = hashBlob(ctx, store, f.BlobKey(), nil); err != nil {
+					return nil, err
+				}
+				sums[f.BlobKey()] = sum
+			}
+			if sum != f.SHA256 {
+				rep.Problems = append(rep.Problems, Problem{Kind: Corrupt, ID: f.ID,
+					Detail: fmt.Sprintf("sha256 is %s, record says %s", sum, f.SHA256)})
+			}
+		}
+	}
+	err := store.List(ctx, func(info storage.Info) error {
+		rep.Blobs++
+		if !index.HasBlob(info.Key) {
+			rep.Problems = append(rep.Problems, Problem{Kind: Orphan, ID: info.Key,
+				Detail: fmt.Sprintf("%d bytes, written %s", info.Size, info.ModTime.UTC().Format(time.RFC3339))})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rep, nil
+}
+
+// hashBlob reads the blob id in full and returns its SHA-256, as fast as p lets it if p
+// isn't nil.
+func hashBlob(ctx context.Context, store storage.Backend, id string, p *pacer) (string, error) {
+	rc, err := store.Get(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	var r io.Reader = rc
+	if p != nil {
+		r = &pacedReader{ctx, rc, p}
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", fmt.Errorf("read %s: %w", id, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// GCResult is what Collect removed (or would remove, on a dry run).
+type GCResult struct {
+	Expired     int   `json:"expired"`
+	Orphans     int   `json:"orphans"`
+	OrphanBytes int64 `json:"orphan_bytes"`
+	TempFiles   int   `json:"temp_files"`
+	Vacuumed    int   `json:"vacuumed"`
+	DryRun      bool  `json:"dry_run"`
+}
+
+// What Collect tells GCOptions.Progress it removed.
+const (
+	GCExpired = "expired" // a record past its expiry time, its downloads or lifecycle.max_age, by ID
+	GCOrphan  = "orphan"  // a blob no record points to, by key
+)
+
+// GCOptions tune Collect.
+type GCOptions struct {
+	MinAge time.Duration // blobs and temporary files younger than this are left alone
+	DryRun bool
+	// Expire removes the records of files past their own expiry time, or older than MaxAge
+	// if it's set, as the server's lifecycle sweep does, so their blobs go with the orphans.
+	// Taken-down files are kept for an admin to deal with, and those in the trash until it's
+	// emptied.
+	Expire bool
+	MaxAge time.Duration
+	// Lock, when set, is held while an orphan is checked once more and deleted, so a server
+	// running Collect can keep it from racing an upload that shares the blob.
+	Lock sync.Locker
+	// Progress, when set, is told of everything removed, or that would be on a dry run.
+	Progress func(kind, id string)
+}
+
+// tempCleaner is implemented by backends that stage uploads somewhere before publishing them,
+// and by those whose multipart uploads can be abandoned half done.
+type tempCleaner interface {
+	CleanTemp(cutoff time.Time) (int, error)
+}
+
+// Collect deletes orphaned blobs and abandoned temporary files older than opt.MinAge, with
+// opt.Expire the records of expired files first, and vacuums the index. Records with missing
+// blobs are left alone: deciding to forget a file is for a human, not for a garbage collector.
+func Collect(ctx context.Context, store storage.Backend, index *metadata.Index, opt GCOptions) (GCResult, error) {
+	res := GCResult{DryRun: opt.DryRun}
+	now := time.Now()
+	cutoff := now.Add(-opt.MinAge)
+	progress := func(kind, id string) {
+		if opt.Progress != nil {
+			opt.Progress(kind, id)
+		}
+	}
+	// a dry run deletes no records, so it tracks which blobs would lose all of theirs
+	expired, kept := map[string]bool{}, map[string]bool{}
+	if opt.Expire {
+		for _, f := range index.List() {
+			old := opt.MaxAge > 0 && now.Sub(f.CreatedAt) >= opt.MaxAge
+			if !(old || f.Expired(now)) || f.TakenDown != nil || f.Trashed != nil {
+				for _, key := range f.BlobKeys() {
+					kept[key] = true
+				}
+				continue
+			}
+			if opt.DryRun {
+				for _, key := range f.BlobKeys() {
+					expired[key] = true
+				}
+			} else if err := index.Delete(f.ID); err != nil {
+				return res, err
+			}
+			res.Expired++
+			progress(GCExpired, f.ID)
+		}
+	}
+	orphaned := func(key string) bool {
+		return !index.HasBlob(key) || expired[key] && !kept[key]
+	}
+
+	var orphans []storage.Info
+	err := store.List(ctx, func(info storage.Info) error {
+		if !info.ModTime.After(cutoff) && orphaned(info.Key) {
+			orphans = append(orphans, info)
+		}
+		return nil
+	})
+	if err != nil {
+		return res, err
+	}
+	// delete after the walk rather than during it, so backends don't have to cope with
+	// their listing changing underneath them
+	for _, info := range orphans {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		if !opt.DryRun {
+			if gone, err := collectOrphan(ctx, store, index, info.Key, cutoff, opt.Lock); err != nil {
+				return res, err
+			} else if !gone {
+				continue
+			}
+		}
+		res.Orphans++
+		res.OrphanBytes += info.Size
+		progress(GCOrphan, info.Key)
+	}
+	if opt.DryRun {
+		return res, nil
+	}
+	if tc, ok := store.(tempCleaner); ok {
+		if res.TempFiles, err = tc.CleanTemp(cutoff); err != nil {
+			return res, err
+		}
+	}
+	res.Vacuumed, err = index.Vacuum()
+	return res, err
+}
+
+// collectOrphan deletes the blob under key if it's still an orphan, and reports whether it did.
+func collectOrphan(ctx context.Context, store storage.Backend, index *metadata.Index, key string, cutoff time.Time, lock sync.Locker) (bool, error) {
+	if lock != nil {
+		lock.Lock()
+		defer lock.Unlock()
+		// it may have been shared, or stored again, since the walk
+		if index.HasBlob(key) {
+			return false, nil
+		}
+		if info, err := store.Stat(ctx, key); err != nil || info.ModTime.After(cutoff) {
+			return false, nil
+		}
+	}
+	err := store.Delete(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,169 @@
+package fsck
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/metadata"
+	"github.com/hey-granth/filegoblin/internal/storage"
+)
+
+func sum(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+// TestCheckAndCollect sets up one healthy file and one of each problem, then checks that
+// fsck reports exactly those and gc only removes the orphan.
+func TestCheckAndCollect(t *testing.T) {
+	ctx := context.Background()
+	store, err := storage.NewDisk(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := metadata.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	put := func(id, content string, record bool) {
+		t.Helper()
+		if _, err := store.Put(ctx, id, strings.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+		if record {
+			if err := index.Put(&metadata.File{ID: id, Size: int64(len(content)), SHA256: sum(content)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	put("good", "fine", true)
+	put("flipped", "abcd", true)
+	put("flipped", "abce", false) // same size, different bytes
+	put("orphan", "nobody's", false)
+	if err := index.Put(&metadata.File{ID: "gone", Size: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	rep, err := Check(ctx, store, index, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, p := range rep.Problems {
+		got[p.ID] = p.Kind
+	}
+	want := map[string]string{"flipped": Corrupt, "orphan": Orphan, "gone": Missing}
+	if len(got) != len(want) {
+		t.Fatalf("problems: got %v, want %v", got, want)
+	}
+	for id, kind := range want {
+		if got[id] != kind {
+			t.Fatalf("%s: got %q, want %q", id, got[id], kind)
+		}
+	}
+
+	// the orphan is brand new, so a grace period protects it
+	res, err := Collect(ctx, store, index, GCOptions{MinAge: time.Hour})
+	if err != nil || res.Orphans != 0 {
+		t.Fatalf("gc within grace period: %+v, %v", res, err)
+	}
+	var removed []string
+	res, err = Collect(ctx, store, index, GCOptions{Progress: func(kind, id string) { removed = append(removed, kind+" "+id) }})
+	if err != nil || res.Orphans != 1 || res.OrphanBytes != 8 || strings.Join(removed, ",") != "orphan orphan" {
+		t.Fatalf("gc: %+v, %v", res, err)
+	}
+	if _, err := store.Stat(ctx, "orphan"); err != storage.ErrNotFound {
+		t.Fatalf("orphan still stored: %v", err)
+	}
+	if _, err := store.Stat(ctx, "good"); err != nil {
+		t.Fatalf("gc removed a live blob: %v", err)
+	}
+}
+
+// TestCollectExpired checks that gc removes expired records, and their blobs unless a live
+// record shares them, and that a dry run counts the same without removing anything.
+func TestCollectExpired(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemory()
+	index, err := metadata.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Minute)
+	for _, f := range []*metadata.File{
+		{ID: "expired", ExpiresAt: &past},
+		{ID: "shared-expired", Blob: "shared", ExpiresAt: &past},
+		{ID: "shared-live", Blob: "shared"},
+		{ID: "taken-down", ExpiresAt: &past, TakenDown: &metadata.Takedown{}},
+	} {
+		store.Put(ctx, f.BlobKey(), strings.NewReader("content"))
+		if err := index.Put(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, dryRun := range []bool{true, false} {
+		res, err := Collect(ctx, store, index, GCOptions{DryRun: dryRun, Expire: true})
+		if err != nil || res.Expired != 2 || res.Orphans != 1 {
+			t.Fatalf("dry run %v: %+v, %v", dryRun, res, err)
+		}
+	}
+	if _, err := index.Get("expired"); err == nil {
+		t.Fatal("expired record kept")
+	}
+	for _, key := range []string{"shared", "taken-down"} {
+		if _, err := store.Stat(ctx, key); err != nil {
+			t.Fatalf("%s: %v", key, err)
+		}
+	}
+}
+
+// TestScrub rots one replica's copy of a blob and both copies of another, and checks that a
+// scrub repairs the first from the good replica and only reports the second.
+func TestScrub(t *testing.T) {
+	ctx := context.Background()
+	a, b := storage.NewMemory(), storage.NewMemory()
+	store, err := storage.NewReplicated([]storage.Backend{a, b}, storage.ReplicaOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := metadata.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"good", "rotted", "lost"} {
+		store.Put(ctx, id, strings.NewReader(id+" content"))
+		if err := index.Put(&metadata.File{ID: id, Size: int64(len(id) + 8), SHA256: sum(id + " content")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b.Put(ctx, "rotted", strings.NewReader("rotted c0ntent"))
+	a.Put(ctx, "lost", strings.NewReader("lost c0ntent"))
+	b.Put(ctx, "lost", strings.NewReader("lost c0ntent"))
+
+	var corrupt []string
+	res, err := Scrub(ctx, store, index, ScrubOptions{Progress: func(p Problem, repaired bool) {
+		if p.Kind != Corrupt || repaired != (p.ID == "rotted") {
+			t.Errorf("%+v, repaired %v", p, repaired)
+		}
+		corrupt = append(corrupt, p.ID)
+	}})
+	if err != nil || res.Checked != 3 || res.Corrupt != 2 || res.Repaired != 1 || len(corrupt) != 2 {
+		t.Fatalf("%+v, %v", res, err)
+	}
+	rc, err := b.Get(ctx, "rotted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if got, _ := io.ReadAll(rc); string(got) != "rotted content" {
+		t.Fatalf("replica not repaired: %q", got)
+	}
+	if res, err := Scrub(ctx, store, index, ScrubOptions{}); err != nil || res.Corrupt != 1 {
+		t.Fatalf("second pass: %+v, %v", res, err)
+	}
+}
@@ -0,0 +1,183 @@
+package fsck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/metadata"
+	"github.com/hey-granth/filegoblin/internal/storage"
+)
+
+// ScrubResult is what a Scrub pass found.
+type ScrubResult struct {
+	Checked  int   `json:"checked"` // blobs read back and hashed
+	Bytes    int64 `json:"bytes"`   // read, counting each replica's copy
+	Corrupt  int   `json:"corrupt"`
+	Repaired int   `json:"repaired"` // of the corrupt ones, fixed from a good replica
+	Missing  int   `json:"missing"`
+}
+
+// ScrubOptions tune Scrub.
+type ScrubOptions struct {
+	// Rate is how many bytes a second the pass reads at most, so it doesn't compete with
+	// downloads; 0 doesn't limit it.
+	Rate int64
+	// Progress, when set, is told of each problem found, and whether it was repaired.
+	Progress func(p Problem, repaired bool)
+}
+
+// Scrub reads back every blob that has a recorded checksum and hashes it, to find the ones
+// storage has let rot before anyone downloads them. With a replicated backend, each replica's
+// copy is read, and the bad ones are replaced with a good one. Unlike Check it's safe to run
+// while the server is up: its reads don't count as downloads for storage tiering, and a file
+// deleted while it was read isn't reported.
+func Scrub(ctx context.Context, store storage.Backend, index *metadata.Index, opt ScrubOptions) (ScrubResult, error) {
+	var res ScrubResult
+	ctx = storage.WithBackgroundRead(ctx)
+	p := &pacer{rate: opt.Rate, start: time.Now()}
+	report := func(prob Problem, repaired bool) {
+		if opt.Progress != nil {
+			opt.Progress(prob, repaired)
+		}
+	}
+	seen := map[string]bool{} // deduplicated blobs are read once
+	for _, f := range index.List() {
+		key := f.BlobKey()
+		if f.SHA256 == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		info, err := store.Stat(ctx, key)
+		if errors.Is(err, storage.ErrNotFound) {
+			if index.HasBlob(key) {
+				res.Missing++
+				report(Problem{Kind: Missing, ID: f.ID, Detail: f.Name}, false)
+			}
+			continue
+		} else if err != nil {
+			return res, err
+		}
+		res.Checked++
+		prob, repaired, err := scrubBlob(ctx, store, key, f.SHA256, info.Size, p, &res)
+		if err != nil {
+			return res, err
+		}
+		if prob == "" || !index.HasBlob(key) { // deleted while it was read
+			continue
+		}
+		res.Corrupt++
+		if repaired {
+			res.Repaired++
+		}
+		report(Problem{Kind: Corrupt, ID: f.ID, Detail: prob}, repaired)
+	}
+	return res, nil
+}
+
+// scrubBlob hashes the blob under key, each replica's copy of it if store replicates, and
+// describes what doesn't match want, after copying a good replica over the bad ones. The
+// error is only for the pass being cancelled.
+func scrubBlob(ctx context.Context, store storage.Backend, key, want string, size int64, p *pacer, res *ScrubResult) (string, bool, error) {
+	reads := []*storage.ReplicaRead{nil}
+	rep, replicated := storage.Find[*storage.Replicated](store)
+	if replicated {
+		reads = make([]*storage.ReplicaRead, rep.Replicas())
+		for i := range reads {
+			reads[i] = &storage.ReplicaRead{Replica: i}
+		}
+	}
+	var probs []string
+	good, bad := -1, []int(nil)
+	for i, rr := range reads {
+		rctx := ctx
+		if rr != nil {
+			rctx = storage.WithReplicaRead(ctx, rr)
+		}
+		sum, err := hashBlob(rctx, store, key, p)
+		if ctx.Err() != nil {
+			return "", false, ctx.Err()
+		}
+		if rr != nil && rr.Skipped() {
+			continue // down or behind, which Repair deals with
+		}
+		res.Bytes += size
+		prob := ""
+		switch {
+		case err != nil:
+			prob = fmt.Sprintf("can't be read back: %v", err)
+		case sum != want:
+			prob = fmt.Sprintf("sha256 is %s, record says %s", sum, want)
+		case good < 0:
+			good = i
+			continue
+		default:
+			continue
+		}
+		if rr != nil {
+			prob = fmt.Sprintf("replica %d: %s", i, prob)
+		}
+		probs, bad = append(probs, prob), append(bad, i)
+	}
+	if len(probs) == 0 {
+		return "", false, nil
+	}
+	prob := strings.Join(probs, "; ")
+	if !replicated {
+		return prob, false, nil
+	}
+	if good < 0 {
+		return prob + "; no replica has a good copy", false, nil
+	}
+	if err := rep.Heal(ctx, reads[good].Keys(), good, bad); err != nil {
+		return fmt.Sprintf("%s; not repaired: %v", prob, err), false, nil
+	}
+	return prob, true, nil
+}
+
+// pacer holds the reads of a pass to rate bytes a second, counted from start.
+type pacer struct {
+	rate  int64
+	start time.Time
+	n     int64
+}
+
+// wait notes n more bytes read, and sleeps until the pass is back down to the rate.
+func (p *pacer) wait(ctx context.Context, n int) error {
+	if p.rate <= 0 {
+		return nil
+	}
+	p.n += int64(n)
+	d := time.Duration(float64(p.n)/float64(p.rate)*float64(time.Second)) - time.Since(p.start)
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type pacedReader struct {
+	ctx context.Context
+	r   io.Reader
+	p   *pacer
+}
+
+func (r *pacedReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	if werr := r.p.wait(r.ctx, n); werr != nil {
+		return n, werr
+	}
+	return n, err
+}
@@ -0,0 +1,188 @@
+// Package highlight colours source code for the preview page. It is a deliberately small
+// lexer, not a parser: it knows each language's comments, strings, numbers and keywords,
+// which is most of what makes code readable, and falls back to plain text for the rest.
+package highlight
+
+import (
+	"html"
+	"html/template"
+	"path"
+	"strings"
+)
+
+// Token classes, used as CSS class names in the output.
+const (
+	Keyword = "kw"
+	String  = "str"
+	Comment = "com"
+	Number  = "num"
+)
+
+// lang describes what the lexer needs to know about a language.
+type lang struct {
+	line     []string // line comment markers
+	block    [2]string
+	quotes   string // characters that open a string, closed by the same character
+	keywords map[string]bool
+}
+
+func words(s string) map[string]bool {
+	m := map[string]bool{}
+	for _, w := range strings.Fields(s) {
+		m[w] = true
+	}
+	return m
+}
+
+var (
+	cLike = lang{line: []string{"//"}, block: [2]string{"/*", "*/"}, quotes: `"'`}
+	langs = map[string]lang{
+		"go":   {line: cLike.line, block: cLike.block, quotes: "\"'`", keywords: words(`break case chan const continue default defer else fallthrough for func go goto if import interface map package range return select struct switch type var nil true false iota`)},
+		"js":   {line: cLike.line, block: cLike.block, quotes: "\"'`", keywords: words(`async await break case catch class const continue debugger default delete do else export extends finally for function if import in instanceof let new of return super switch this throw try typeof var void while yield null undefined true false`)},
+		"c":    {line: cLike.line, block: cLike.block, quotes: cLike.quotes, keywords: words(`auto break case char class const continue default delete do double else enum extern float for goto if inline int long namespace new private protected public register return short signed sizeof static struct switch template this typedef union unsigned virtual void volatile while true false nullptr NULL`)},
+		"java": {line: cLike.line, block: cLike.block, quotes: cLike.quotes, keywords: words(`abstract boolean break byte case catch char class continue default do double else enum extends final finally float for if implements import instanceof int interface long new package private protected public return short static super switch this throw throws try void while null true false var val fun object when`)},
+		"rust": {line: cLike.line, block: cLike.block, quotes: `"`, keywords: words(`as async await break const continue crate dyn else enum extern false fn for if impl in let loop match mod move mut pub ref return self Self static struct super trait true type unsafe use where while`)},
+		"py":   {line: []string{"#"}, quotes: `"'`, keywords: words(`and as assert async await break class continue def del elif else except finally for from global if import in is lambda nonlocal not or pass raise return try while with yield None True False`)},
+		"rb":   {line: []string{"#"}, quotes: `"'`, keywords: words(`begin break case class def do else elsif end ensure false for if in module next nil not or redo rescue retry return self super then true undef unless until when while yield`)},
+		"sh":   {line: []string{"#"}, quotes: `"'`, keywords: words(`if then else elif fi for while until do done case esac function in return local export`)},
+		"yaml": {line: []string{"#"}, quotes: `"'`, keywords: words(`true false null yes no`)},
+		"sql":  {line: []string{"--"}, block: cLike.block, quotes: `'"`, keywords: words(`select from where and or not insert into values update set delete create table drop alter index join left right inner outer on group by order having limit as null is in like distinct union primary key references SELECT FROM WHERE AND OR NOT INSERT INTO VALUES UPDATE SET DELETE CREATE TABLE DROP ALTER INDEX JOIN LEFT RIGHT INNER OUTER ON GROUP BY ORDER HAVING LIMIT AS NULL IS IN LIKE DISTINCT UNION PRIMARY KEY REFERENCES`)},
+		"css":  {block: cLike.block, quotes: `"'`},
+		"json": {quotes: `"`, keywords: words(`true false null`)},
+	}
+	extensions = map[string]string{
+		".go": "go", ".js": "js", ".mjs": "js", ".ts": "js", ".tsx": "js", ".jsx": "js",
+		".c": "c", ".h": "c", ".cc": "c", ".cpp": "c", ".hpp": "c", ".cs": "c",
+		".java": "java", ".kt": "java", ".scala": "java", ".rs": "rust",
+		".py": "py", ".rb": "rb", ".sh": "sh", ".bash": "sh", ".zsh": "sh",
+		".yaml": "yaml", ".yml": "yaml", ".toml": "yaml", ".sql": "sql", ".css": "css", ".json": "json",
+	}
+)
+
+// Language returns the language the lexer uses for a file name, or "" for plain text.
+func Language(name string) string {
+	return extensions[strings.ToLower(path.Ext(name))]
+}
+
+// Lines returns src as escaped HTML, one entry per line, with tokens of language lang
+// wrapped in <span class="..."> using the classes above. A span never crosses a line, so each
+// line stands on its own. An unknown language gives plain escaped lines.
+func Lines(lang, src string) []template.HTML {
+	l, ok := langs[lang]
+	var out []template.HTML
+	var cur strings.Builder
+	emit := func(class, text string) {
+		// split at newlines, closing and reopening the span around each break
+		for i, part := range strings.Split(text, "\n") {
+			if i > 0 {
+				out = append(out, template.HTML(cur.String()))
+				cur.Reset()
+			}
+			if part == "" {
+				continue
+			}
+			if class == "" {
+				cur.WriteString(html.EscapeString(part))
+			} else {
+				cur.WriteString(`<span class="` + class + `">` + html.EscapeString(part) + `</span>`)
+			}
+		}
+	}
+	if !ok {
+		emit("", src)
+	} else {
+		lex(l, src, emit)
+	}
+	if cur.Len() > 0 || len(out) == 0 {
+		out = append(out, template.HTML(cur.String()))
+	}
+	return out
+}
+
+func lex(l lang, src string, emit func(class, text string)) {
+	plain := 0 // start of the pending run of plain text
+	flush := func(i int) {
+		if i > plain {
+			emit("", src[plain:i])
+		}
+	}
+	for i := 0; i < len(src); {
+		rest := src[i:]
+		c := src[i]
+		end, class := 0, ""
+		switch {
+		case hasAny(rest, l.line):
+			end, class = lineEnd(rest), Comment
+		case l.block[0] != "" && strings.HasPrefix(rest, l.block[0]):
+			end, class = len(rest), Comment
+			if j := strings.Index(rest[len(l.block[0]):], l.block[1]); j >= 0 {
+				end = len(l.block[0]) + j + len(l.block[1])
+			}
+		case strings.IndexByte(l.quotes, c) >= 0:
+			end, class = quoted(rest), String
+		case isDigit(c) && (i == 0 || !isWord(src[i-1])):
+			end, class = wordEnd(rest), Number
+		case isWord(c) && (i == 0 || !isWord(src[i-1])):
+			if n := wordEnd(rest); l.keywords[rest[:n]] {
+				end, class = n, Keyword
+			}
+		}
+		if class == "" {
+			i++
+			continue
+		}
+		flush(i)
+		emit(class, rest[:end])
+		i += end
+		plain = i
+	}
+	flush(len(src))
+}
+
+func hasAny(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func lineEnd(s string) int {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return i
+	}
+	return len(s)
+}
+
+// quoted returns the length of the string literal at the start of s, including its quotes.
+// Backslash escapes are honoured; a string left open ends with its line, except for
+// backquotes, which may span lines.
+func quoted(s string) int {
+	q := s[0]
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && q != '`':
+			i++
+		case s[i] == q:
+			return i + 1
+		case s[i] == '\n' && q != '`':
+			return i
+		}
+	}
+	return len(s)
+}
+
+func wordEnd(s string) int {
+	i := 0
+	for i < len(s) && (isWord(s[i]) || s[i] == '.' && i > 0 && isDigit(s[0])) {
+		i++
+	}
+	return max(i, 1)
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func isWord(c byte) bool {
+	return c == '_' || isDigit(c) || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
@@ -0,0 +1,33 @@
+package highlight
+
+import (
+	"html/template"
+	"testing"
+)
+
+func TestLines(t *testing.T) {
+	src := "func main() { // <start>\n\ts := `a\nb` /* x */\n\treturn 42\n}"
+	want := []template.HTML{
+		`<span class="kw">func</span> main() { <span class="com">// &lt;start&gt;</span>`,
+		"\ts := <span class=\"str\">`a</span>",
+		"<span class=\"str\">b`</span> <span class=\"com\">/* x */</span>",
+		"\t<span class=\"kw\">return</span> <span class=\"num\">42</span>",
+		"}",
+	}
+	got := Lines(Language("main.go"), src)
+	if len(got) != len(want) {
+		t.Fatalf("got %d lines, want %d: %q", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("line %d:\n got %s\nwant %s", i+1, got[i], want[i])
+		}
+	}
+
+	if got := Lines(Language("notes.txt"), "a <b>\n\nfor"); len(got) != 3 || got[0] != "a &lt;b&gt;" || got[1] != "" || got[2] != "for" {
+		t.Errorf("plain text: %q", got)
+	}
+	if got := Lines("py", `x = "it's" # done`); got[0] != `x = <span class="str">&#34;it&#39;s&#34;</span> <span class="com"># done</span>` {
+		t.Errorf("python: %s", got[0])
+	}
+}
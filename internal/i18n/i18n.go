@@ -0,0 +1,131 @@
+// Package i18n translates what people see: the web pages, the messages their scripts show,
+// and the API's error messages. Messages are looked up by their English text, gettext style,
+// so English needs no catalog and a message a catalog lacks falls back to readable English.
+//
+// Catalogs are the JSON files in locales/, one object per language mapping English to the
+// translation. Formats keep their verbs ("%s") and the scripts' placeholders ("{0}") in the
+// same order, which the tests check.
+package i18n
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Default is the language messages are written in.
+const Default = "en"
+
+//go:embed locales/*.json
+var files embed.FS
+
+// Locale is one language's catalog.
+type Locale struct {
+	Tag  string // like "de"
+	msgs map[string]string
+}
+
+var locales = load()
+
+func load() map[string]*Locale {
+	out := map[string]*Locale{Default: {Tag: Default, msgs: map[string]string{}}}
+	entries, _ := files.ReadDir("locales")
+	for _, e := range entries {
+		data, _ := files.ReadFile("locales/" + e.Name())
+		l := &Locale{Tag: strings.TrimSuffix(e.Name(), path.Ext(e.Name()))}
+		if err := json.Unmarshal(data, &l.msgs); err != nil {
+			panic(fmt.Sprintf("i18n: %s: %v", e.Name(), err)) // compiled in, so caught by the tests
+		}
+		out[l.Tag] = l
+	}
+	return out
+}
+
+// Supported lists the languages there are catalogs for, and English.
+func Supported() []string {
+	out := make([]string, 0, len(locales))
+	for tag := range locales {
+		out = append(out, tag)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Get returns the locale for tag, or nil if there is no catalog for it.
+func Get(tag string) *Locale { return locales[strings.ToLower(tag)] }
+
+// T translates msg.
+func (l *Locale) T(msg string) string {
+	if t, ok := l.msgs[msg]; ok && t != "" {
+		return t
+	}
+	return msg
+}
+
+// Tf translates format and formats it with args, like fmt.Sprintf.
+func (l *Locale) Tf(format string, args ...any) string {
+	return fmt.Sprintf(l.T(format), args...)
+}
+
+// Messages returns the whole catalog, for pages to hand to their scripts.
+func (l *Locale) Messages() map[string]string { return l.msgs }
+
+// Negotiate picks the language to answer in from an Accept-Language header: the one the
+// client likes best that there is a catalog for, matching "de-AT" to "de" when there is no
+// "de-at". It falls back to fallback when the client wants none of them, and to English when
+// fallback is empty or unsupported.
+func Negotiate(accept, fallback string) *Locale {
+	type choice struct {
+		tag string
+		q   float64
+	}
+	var choices []choice
+	for _, part := range strings.Split(accept, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if tag = strings.TrimSpace(tag); tag != "" && tag != "*" && q > 0 {
+			choices = append(choices, choice{tag, q})
+		}
+	}
+	sort.SliceStable(choices, func(i, j int) bool { return choices[i].q > choices[j].q })
+	for _, c := range choices {
+		if l := Get(c.tag); l != nil {
+			return l
+		}
+		if base, _, ok := strings.Cut(c.tag, "-"); ok {
+			if l := Get(base); l != nil {
+				return l
+			}
+		}
+	}
+	if l := Get(fallback); l != nil {
+		return l
+	}
+	return locales[Default]
+}
+
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx carrying l.
+func NewContext(ctx context.Context, l *Locale) context.Context {
+	return context.WithValue(ctx, ctxKey{}, l)
+}
+
+// FromContext returns the locale carried by ctx, or English.
+func FromContext(ctx context.Context) *Locale {
+	if l, ok := ctx.Value(ctxKey{}).(*Locale); ok {
+		return l
+	}
+	return locales[Default]
+}
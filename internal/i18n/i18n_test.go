@@ -0,0 +1,57 @@
+package i18n
+
+import (
+	"context"
+	"regexp"
+	"slices"
+	"testing"
+)
+
+// placeholders matches fmt verbs and the scripts' "{0}" placeholders.
+var placeholders = regexp.MustCompile(`%[a-z%]|\{\d\}`)
+
+// TestCatalogs checks that every translation keeps the placeholders of its English, in order.
+func TestCatalogs(t *testing.T) {
+	if len(Supported()) < 2 {
+		t.Fatalf("no catalogs loaded: %v", Supported())
+	}
+	for _, tag := range Supported() {
+		for msg, tr := range Get(tag).Messages() {
+			want, got := placeholders.FindAllString(msg, -1), placeholders.FindAllString(tr, -1)
+			if !slices.Equal(want, got) {
+				t.Errorf("%s: %q has placeholders %v, its translation %q has %v", tag, msg, want, tr, got)
+			}
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	for _, tc := range []struct {
+		accept, fallback, want string
+	}{
+		{"de-AT,de;q=0.9,en;q=0.8", "", "de"},
+		{"en-GB,en;q=0.9", "de", "en"},
+		{"pt-BR;q=0.9, fr;q=0.5", "", "fr"},
+		{"fr;q=0.5, es", "", "es"},
+		{"pt-BR", "de", "de"},
+		{"", "fr", "fr"},
+		{"*", "xx", "en"},
+		{"de;q=0", "", "en"},
+		{"de;q=nonsense", "", "en"},
+	} {
+		if got := Negotiate(tc.accept, tc.fallback).Tag; got != tc.want {
+			t.Errorf("Negotiate(%q, %q) = %s, want %s", tc.accept, tc.fallback, got, tc.want)
+		}
+	}
+
+	de := Get("de")
+	if got := de.Tf("upload rejected: %s detected", "EICAR"); got != "Upload abgelehnt: EICAR gefunden" {
+		t.Errorf("Tf: %q", got)
+	}
+	if got := de.T("a message nobody translated"); got != "a message nobody translated" {
+		t.Errorf("untranslated: %q", got)
+	}
+	if FromContext(NewContext(context.Background(), de)) != de || FromContext(context.Background()).Tag != Default {
+		t.Error("context round trip")
+	}
+}
@@ -0,0 +1,244 @@
+// Package ingest imports an existing directory tree into the data directory, for moving years
+// of files off a shared drive. Each file becomes a record like an upload's, remembering where
+// it was in the tree and when it was last modified. Content that is already stored is not
+// stored twice: the file is hashed first, and a duplicate's record shares the stored blob.
+// With Options.Dedup, new content is filed by its SHA-256 as uploads are under storage.dedup,
+// so later uploads of the same content share it too. A run that was interrupted picks up where it left off: files imported by an earlier run are
+// recognised by their path, size and modification time and not read again.
+//
+// Like fsck, it works on the data directory directly, so it is meant to run while the server
+// is stopped. Imported files don't go through the upload checks: no virus scan, DLP rules,
+// type checks or plugins.
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/metadata"
+	"github.com/hey-granth/filegoblin/internal/storage"
+)
+
+// What happened to a file.
+const (
+	Imported  = "imported"
+	Duplicate = "duplicate" // its content was already stored: its record shares it, Item.Of's if a file's
+	Done      = "done"      // imported by an earlier run
+	Ignored   = "ignored"   // not a regular file: a symlink, device or socket
+	Failed    = "failed"
+)
+
+// Options adjust a Run.
+type Options struct {
+	Owner       string                                // recorded as the files' owner; empty for none
+	Exclude     string                                // a directory in the tree to leave out, like the data directory itself
+	Dedup       bool                                  // storage.dedup: store content under its SHA-256, shared with uploads
+	ContentType func(name string, head []byte) string // picks a file's content type; sniffing only when nil
+	Progress    func(Item)                            // told about every file as it is handled
+}
+
+// Item is the outcome for one file.
+type Item struct {
+	Path   string `json:"path"` // relative to the imported directory, with forward slashes
+	Status string `json:"status"`
+	ID     string `json:"id,omitempty"` // its record
+	Of     string `json:"of,omitempty"` // for a Duplicate, the record whose content it shares
+	Error  string `json:"error,omitempty"`
+}
+
+// Result adds up a Run.
+type Result struct {
+	Imported   int   `json:"imported"`
+	Duplicates int   `json:"duplicates"`
+	Done       int   `json:"done"`
+	Ignored    int   `json:"ignored"`
+	Failed     int   `json:"failed"`
+	Bytes      int64 `json:"bytes"` // stored by this run
+}
+
+// Run imports every file under dir. Problems with single files are reported as Failed items
+// and the walk goes on; Run itself only fails when ctx is cancelled.
+func Run(ctx context.Context, store storage.Backend, index *metadata.Index, dir string, opt Options) (Result, error) {
+	detect := opt.ContentType
+	if detect == nil {
+		detect = func(_ string, head []byte) string { return http.DetectContentType(head) }
+	}
+	byHash := map[string]*metadata.File{}
+	byPath := map[string]*metadata.File{}
+	for _, f := range index.List() {
+		if f.SHA256 != "" && !f.Encrypted {
+			byHash[f.SHA256] = f
+		}
+		if f.Path != "" {
+			byPath[f.Path] = f
+		}
+	}
+
+	var exclude fs.FileInfo
+	if opt.Exclude != "" {
+		exclude, _ = os.Stat(opt.Exclude)
+	}
+
+	var res Result
+	report := func(it Item) {
+		switch it.Status {
+		case Imported:
+			res.Imported++
+		case Duplicate:
+			res.Duplicates++
+		case Done:
+			res.Done++
+		case Ignored:
+			res.Ignored++
+		case Failed:
+			res.Failed++
+		}
+		if opt.Progress != nil {
+			opt.Progress(it)
+		}
+	}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		rel = filepath.ToSlash(rel)
+		if err != nil {
+			report(Item{Path: rel, Status: Failed, Error: err.Error()})
+			return nil // an unreadable directory is left out, the rest goes on
+		}
+		if d.IsDir() {
+			if fi, err := d.Info(); err == nil && exclude != nil && os.SameFile(fi, exclude) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			report(Item{Path: rel, Status: Ignored})
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			report(Item{Path: rel, Status: Failed, Error: err.Error()})
+			return nil
+		}
+		if f, ok := byPath[rel]; ok && f.Size == info.Size() && f.ModTime != nil && f.ModTime.Equal(info.ModTime()) {
+			report(Item{Path: rel, Status: Done, ID: f.ID})
+			return nil
+		}
+
+		f, head, err := hashFile(p, rel, info)
+		if err != nil {
+			report(Item{Path: rel, Status: Failed, Error: err.Error()})
+			return nil
+		}
+		f.Owner, f.ContentType = opt.Owner, detect(f.Name, head)
+		same := byHash[f.SHA256]
+		if same != nil {
+			f.Blob = same.BlobKey() // shared, not stored again
+		} else if err := copyIn(ctx, store, p, f); err != nil {
+			report(Item{Path: rel, Status: Failed, Error: err.Error()})
+			return nil
+		}
+		stored := same != nil
+		if opt.Dedup && same == nil {
+			// as the server's publish does, though only an old version can have this content
+			stored = index.Refs(f.SHA256) > 0
+			if err := storage.Dedup(ctx, store, f.ID, f.SHA256, stored); err != nil && !stored {
+				_ = store.Delete(ctx, f.ID)
+				report(Item{Path: rel, Status: Failed, Error: err.Error()})
+				return nil
+			}
+			f.Blob = f.SHA256
+		}
+		if err := index.Put(f); err != nil {
+			if !stored {
+				_ = store.Delete(ctx, f.BlobKey())
+			}
+			report(Item{Path: rel, Status: Failed, Error: err.Error()})
+			return nil
+		}
+		byPath[rel] = f
+		if stored {
+			it := Item{Path: rel, Status: Duplicate, ID: f.ID}
+			if same != nil {
+				it.Of = same.ID
+			}
+			report(it)
+			return nil
+		}
+		byHash[f.SHA256] = f
+		res.Bytes += f.Size
+		report(Item{Path: rel, Status: Imported, ID: f.ID})
+		return nil
+	})
+	return res, err
+}
+
+// hashFile reads the file at p and returns its record, not yet in the index, with its size
+// and SHA-256 filled in, and the start of its content for picking its type.
+func hashFile(p, rel string, info fs.FileInfo) (*metadata.File, []byte, error) {
+	src, err := os.Open(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer src.Close()
+	br := bufio.NewReader(src)
+	head, _ := br.Peek(512)
+	head = append([]byte(nil), head...)
+	h := sha256.New()
+	n, err := io.Copy(h, br)
+	if err != nil {
+		return nil, nil, err
+	}
+	mod := info.ModTime().UTC()
+	f := &metadata.File{
+		ID:        newID(),
+		Name:      path.Base(rel),
+		Path:      rel,
+		Size:      n,
+		SHA256:    hex.EncodeToString(h.Sum(nil)),
+		CreatedAt: time.Now().UTC(),
+		ModTime:   &mod,
+	}
+	return f, head, nil
+}
+
+// copyIn copies the file at p into the store under f's ID, checking on the way that it is
+// still what hashFile read.
+func copyIn(ctx context.Context, store storage.Backend, p string, f *metadata.File) error {
+	src, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	h := sha256.New()
+	n, err := store.Put(ctx, f.ID, io.TeeReader(src, h))
+	if err == nil && (n != f.Size || hex.EncodeToString(h.Sum(nil)) != f.SHA256) {
+		err = errors.New("changed while it was imported")
+	}
+	if err != nil {
+		_ = store.Delete(ctx, f.ID)
+		return err
+	}
+	return nil
+}
+
+// newID returns an ID like the server gives uploads.
+func newID() string {
+	b := make([]byte, 9)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,181 @@
+package ingest
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/metadata"
+	"github.com/hey-granth/filegoblin/internal/storage"
+)
+
+// TestRun imports a small tree twice: the first run stores each distinct content once, giving
+// every file a record with its path and modification time, the second finds everything
+// already done.
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	data := t.TempDir()
+	store, err := storage.NewDisk(filepath.Join(data, "blobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := metadata.Open(filepath.Join(data, "meta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	mtime := time.Date(2019, 3, 4, 5, 6, 7, 0, time.UTC)
+	write := func(name, content string) {
+		t.Helper()
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("reports/2019/q1.txt", "first quarter")
+	write("reports/2019/q1 copy.txt", "first quarter")
+	write("notes.md", "# notes")
+	_ = os.Symlink("notes.md", filepath.Join(dir, "link.md")) // not everywhere allowed; ignored either way
+
+	items := map[string]Item{}
+	res, err := Run(ctx, store, index, dir, Options{Owner: "alice", Progress: func(it Item) { items[it.Path] = it }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Imported != 2 || res.Duplicates != 1 || res.Failed != 0 || res.Bytes != int64(len("first quarter")+len("# notes")) {
+		t.Fatalf("first run: %+v", res)
+	}
+	q1 := items["reports/2019/q1 copy.txt"] // walked first, so the other is the duplicate
+	dup := items["reports/2019/q1.txt"]
+	if q1.Status != Imported || dup.Status != Duplicate || dup.Of != q1.ID || dup.ID == q1.ID {
+		t.Fatalf("duplicates: %+v", items)
+	}
+	f, err := index.Get(q1.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Name != "q1 copy.txt" || f.Path != "reports/2019/q1 copy.txt" || f.Owner != "alice" || f.ModTime == nil || !f.ModTime.Equal(mtime) {
+		t.Fatalf("record: %+v", f)
+	}
+	if info, err := store.Stat(ctx, q1.ID); err != nil || info.Size != f.Size {
+		t.Fatalf("blob: %+v, %v", info, err)
+	}
+	// the duplicate has a record of its own, sharing the blob rather than storing it again
+	g, err := index.Get(dup.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Path != "reports/2019/q1.txt" || g.Blob != q1.ID || g.Size != f.Size || g.ModTime == nil || !g.ModTime.Equal(mtime) {
+		t.Fatalf("duplicate's record: %+v", g)
+	}
+	if _, err := store.Stat(ctx, dup.ID); err == nil {
+		t.Fatal("the duplicate was stored a second time")
+	}
+
+	res, err = Run(ctx, store, index, dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Imported != 0 || res.Done != 3 || res.Duplicates != 0 || res.Bytes != 0 {
+		t.Fatalf("second run: %+v", res)
+	}
+	if n := len(index.List()); n != 3 {
+		t.Fatalf("%d records after two runs, want 3", n)
+	}
+
+	// a file changed since is imported again
+	write("notes.md", "# more notes")
+	if res, _ = Run(ctx, store, index, dir, Options{}); res.Imported != 1 {
+		t.Fatalf("run after a change: %+v", res)
+	}
+}
+
+// TestRunDedup imports with storage.dedup on: content is stored under its SHA-256, and
+// content an upload already stored that way is shared with it.
+func TestRunDedup(t *testing.T) {
+	ctx := context.Background()
+	data := t.TempDir()
+	store, err := storage.NewDisk(filepath.Join(data, "blobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := metadata.Open(filepath.Join(data, "meta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := func(s string) string {
+		h := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(h[:])
+	}
+	up := &metadata.File{ID: "upload", Name: "up.txt", Size: 8, SHA256: sum("uploaded"), Blob: sum("uploaded"), CreatedAt: time.Now()}
+	if _, err := store.Put(ctx, up.Blob, strings.NewReader("uploaded")); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Put(up); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	for name, content := range map[string]string{"a.txt": "uploaded", "b.txt": "new"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	items := map[string]Item{}
+	res, err := Run(ctx, store, index, dir, Options{Dedup: true, Progress: func(it Item) { items[it.Path] = it }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Imported != 1 || res.Duplicates != 1 || res.Bytes != 3 {
+		t.Fatalf("got %+v", res)
+	}
+	if a, _ := index.Get(items["a.txt"].ID); a == nil || a.Blob != up.Blob || items["a.txt"].Of != up.ID {
+		t.Fatalf("a.txt: %+v, %+v", items["a.txt"], a)
+	}
+	b, _ := index.Get(items["b.txt"].ID)
+	if b == nil || b.Blob != sum("new") {
+		t.Fatalf("b.txt: %+v", b)
+	}
+	if _, err := store.Stat(ctx, b.Blob); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Stat(ctx, b.ID); err == nil {
+		t.Fatal("b.txt left behind under its ID")
+	}
+}
+
+// TestExclude leaves the data directory out when it sits inside the imported tree.
+func TestExclude(t *testing.T) {
+	dir := t.TempDir()
+	data := filepath.Join(dir, "filegoblin-data")
+	store, err := storage.NewDisk(filepath.Join(data, "blobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := metadata.Open(filepath.Join(data, "meta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	res, err := Run(context.Background(), store, index, dir, Options{Exclude: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Imported != 1 || res.Failed != 0 {
+		t.Fatalf("got %+v, want only a.txt imported", res)
+	}
+}
@@ -0,0 +1,180 @@
+// Package journal is a write-ahead journal of uploads: each is recorded, and synced to disk,
+// before any of its blob is written, and marked done once its metadata is committed or it
+// has been cleaned up. After a crash, the uploads still open in it are the ones that may
+// have left a partial blob, or a record whose blob never made it to disk, behind.
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// compactAt is how large the file may grow before it's rewritten with only the open entries.
+const compactAt = 1 << 20
+
+// Entry is an upload that was begun.
+type Entry struct {
+	ID      string    `json:"id"`
+	Started time.Time `json:"started"`
+}
+
+type line struct {
+	Op string `json:"op"` // "begin" or "end"
+	Entry
+}
+
+// Journal appends entries to a file. It is safe for concurrent use.
+type Journal struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+	size int64
+	open map[string]time.Time
+}
+
+// Open opens the journal at path for appending, creating it if needed, and reads which
+// uploads it has open. A last line torn by a crash is ignored: an upload isn't begun until
+// its line is synced.
+func Open(path string) (*Journal, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	j := &Journal{path: path, f: f, open: map[string]time.Time{}}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		var l line
+		if json.Unmarshal(sc.Bytes(), &l) != nil || l.ID == "" {
+			continue
+		}
+		switch l.Op {
+		case "begin":
+			j.open[l.ID] = l.Started
+		case "end":
+			delete(j.open, l.ID)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	// start afresh, which also drops a torn line
+	if err := j.compact(); err != nil {
+		j.f.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+// Begin records that the upload id is starting, and syncs it to disk before returning.
+func (j *Journal) Begin(id string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now().UTC()
+	if err := j.write(line{Op: "begin", Entry: Entry{ID: id, Started: now}}, true); err != nil {
+		return err
+	}
+	j.open[id] = now
+	return nil
+}
+
+// End records that the upload id is over, one way or the other. It isn't synced: if a crash
+// loses it, the upload is only checked again.
+func (j *Journal) End(id string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, ok := j.open[id]; !ok {
+		return nil
+	}
+	if err := j.write(line{Op: "end", Entry: Entry{ID: id}}, false); err != nil {
+		return err
+	}
+	delete(j.open, id)
+	if len(j.open) == 0 && j.size >= compactAt {
+		return j.compact()
+	}
+	return nil
+}
+
+// Open returns the uploads begun and not yet ended, oldest first.
+func (j *Journal) Open() []Entry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var out []Entry
+	for id, at := range j.open {
+		out = append(out, Entry{ID: id, Started: at})
+	}
+	sort.Slice(out, func(a, b int) bool { return out[a].Started.Before(out[b].Started) })
+	return out
+}
+
+// Close closes the file.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.f.Close()
+}
+
+// write appends l; the caller holds mu.
+func (j *Journal) write(l line, sync bool) error {
+	data, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	n, err := j.f.Write(append(data, '\n'))
+	j.size += int64(n)
+	if err != nil {
+		return err
+	}
+	if sync {
+		return j.f.Sync()
+	}
+	return nil
+}
+
+// compact rewrites the file with only the open uploads, write-then-rename so a crash keeps
+// one or the other; the caller holds mu, or has the journal to itself.
+func (j *Journal) compact() error {
+	tmp, err := os.OpenFile(j.path+".tmp", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+	w := bufio.NewWriter(tmp)
+	var size int64
+	for id, at := range j.open {
+		data, _ := json.Marshal(line{Op: "begin", Entry: Entry{ID: id, Started: at}})
+		n, _ := w.Write(append(data, '\n'))
+		size += int64(n)
+	}
+	err = w.Flush()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	// Windows won't rename over a file that's open; the file is opened again either way, as
+	// O_APPEND can't be turned on later
+	j.f.Close()
+	renamed := os.Rename(tmp.Name(), j.path)
+	f, err := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	j.f = f
+	if renamed != nil {
+		return renamed
+	}
+	j.size = size
+	return nil
+}
@@ -0,0 +1,47 @@
+package journal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "uploads.journal")
+	j, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		if err := j.Begin(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	j.End("b")
+	j.End("nope")
+	j.Close()
+
+	// a crash tore the last line
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	f.WriteString(`{"op":"end","id":"a"`)
+	f.Close()
+
+	j, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	open := j.Open()
+	if len(open) != 2 || open[0].ID != "a" || open[1].ID != "c" || open[0].Started.IsZero() {
+		t.Fatalf("open after a restart: %+v", open)
+	}
+	j.End("a")
+	j.End("c")
+	if err := j.Begin("d"); err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+	if j, _ = Open(path); len(j.Open()) != 1 || j.Open()[0].ID != "d" {
+		t.Fatalf("open after compacting: %+v", j.Open())
+	}
+	j.Close()
+}
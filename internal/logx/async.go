@@ -0,0 +1,118 @@
+package logx
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Overflow is what an asynchronous logger does with a message when its queue is full.
+type Overflow int
+
+const (
+	Block Overflow = iota // wait for room, slowing the caller down to the pace of the sinks
+	Drop                  // drop the message, and say how many were dropped once there is room
+)
+
+// asyncQueue hands messages to the goroutine that writes them.
+type asyncQueue struct {
+	mu       sync.RWMutex // held for reading while queueing, so Close doesn't close ch under a sender
+	ch       chan queued
+	overflow Overflow
+	dropped  atomic.Int64
+	done     chan struct{} // closed once the writer has written everything and returned
+}
+
+// queued is a message waiting to be written, or, with flushed set, a request to say when
+// everything queued before it has been.
+type queued struct {
+	r       Record
+	flushed chan struct{}
+}
+
+// StartAsync makes the logger, and every logger made from it with With, queue messages for a
+// goroutine to write instead of writing them before returning, so a slow disk or syslog
+// server doesn't hold up requests. The queue holds size messages; overflow says what happens
+// beyond that. Call Close before the program exits, or what is still queued is lost. Fatal
+// and Panic wait for the queue themselves.
+func (l *Logger) StartAsync(size int, overflow Overflow) {
+	q := &asyncQueue{ch: make(chan queued, size), overflow: overflow, done: make(chan struct{})}
+	if !l.async.CompareAndSwap(nil, q) {
+		return // already asynchronous
+	}
+	go l.drain(q)
+}
+
+// drain writes queued messages until the queue is closed.
+func (l *Logger) drain(q *asyncQueue) {
+	defer close(q.done)
+	for m := range q.ch {
+		if m.flushed != nil {
+			close(m.flushed)
+			continue
+		}
+		l.emit(m.r)
+		if n := q.dropped.Swap(0); n > 0 {
+			l.emit(Record{Time: time.Now(), Level: LevelWarn, Message: "logx: the queue was full, dropped " + strconv.FormatInt(n, 10) + " messages"})
+		}
+	}
+}
+
+// enqueue queues r, or drops it if the queue is full and the policy says so. Panics and fatal
+// messages are never dropped. The caller holds q.mu for reading.
+func (q *asyncQueue) enqueue(r Record) {
+	m := queued{r: r}
+	if q.overflow == Block || r.Level >= LevelPanic {
+		q.ch <- m
+		return
+	}
+	select {
+	case q.ch <- m:
+	default:
+		q.dropped.Add(1)
+	}
+}
+
+// Flush waits until the messages logged so far are written, then has sink writers that hold
+// messages in a buffer, like a RotatingFile with Rotation.Buffer, write them out.
+func (l *Logger) Flush() {
+	l.flushQueue()
+	l.flushSinks()
+}
+
+// flushQueue waits for the queue of an asynchronous logger to be written.
+func (l *Logger) flushQueue() {
+	q := l.async.Load()
+	if q == nil {
+		return
+	}
+	q.mu.RLock()
+	if l.async.Load() != q { // closed meanwhile, which flushes too
+		q.mu.RUnlock()
+		<-q.done
+		return
+	}
+	flushed := make(chan struct{})
+	q.ch <- queued{flushed: flushed}
+	q.mu.RUnlock()
+	<-flushed
+}
+
+// Close writes the summaries of repeats held back (see SetDedup) and what is still queued,
+// and makes the logger synchronous again, with dedup off. It is safe to call on a logger that never was
+// asynchronous.
+func (l *Logger) Close() error {
+	l.stopRepeats()
+	q := l.async.Load()
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	if l.async.CompareAndSwap(q, nil) {
+		close(q.ch)
+	}
+	q.mu.Unlock()
+	<-q.done
+	return nil
+}
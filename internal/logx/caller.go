@@ -0,0 +1,51 @@
+package logx
+
+import (
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// SetCaller makes the logger, and every logger made from it with With, add where each message
+// was logged from as two fields: caller, the file and line, and func, the function:
+//
+//	... [ERROR] store: disk full caller=storage/disk.go:88 func=storage.(*Disk).Put
+//
+// Finding them costs a stack walk per message, so it is off unless turned on.
+func (l *Logger) SetCaller(on bool) { l.caller.Store(on) }
+
+// CallerSkip returns a logger that reports the caller n frames further up the stack, for
+// logging helpers that would otherwise show up as the caller of everything they log.
+func (l *Logger) CallerSkip(n int) *Logger {
+	c := *l
+	c.skip += n
+	return &c
+}
+
+// withCaller returns fields with the caller and func fields of pc added. fields itself is
+// left alone.
+func withCaller(fields []Field, pc uintptr) []Field {
+	if pc == 0 {
+		return fields
+	}
+	frame, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+	if frame.Function == "" {
+		return fields
+	}
+	return append(fields[:len(fields):len(fields)],
+		Field{"caller", lastElems(frame.File, "/", 2) + ":" + strconv.Itoa(frame.Line)},
+		Field{"func", lastElems(frame.Function, "/", 1)})
+}
+
+// lastElems returns the last n elements of the path s, separated by sep.
+func lastElems(s, sep string, n int) string {
+	i := len(s)
+	for ; n > 0; n-- {
+		j := strings.LastIndex(s[:i], sep)
+		if j < 0 {
+			return s
+		}
+		i = j
+	}
+	return s[i+len(sep):]
+}
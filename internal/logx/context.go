@@ -0,0 +1,17 @@
+package logx
+
+import "context"
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying l, for code further down a request to find with
+// FromContext.
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the logger stored in ctx by NewContext, or nil if there is none.
+func FromContext(ctx context.Context) *Logger {
+	l, _ := ctx.Value(contextKey{}).(*Logger)
+	return l
+}
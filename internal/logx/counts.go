@@ -0,0 +1,55 @@
+package logx
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Count is how many messages a logger has logged at a level, from one component: the value
+// of the messages' "component" field, or "" for those without one.
+type Count struct {
+	Level     Level
+	Component string
+	N         int64
+}
+
+type countKey struct {
+	lv        Level
+	component string
+}
+
+// count notes a message logged, whether or not it is written in the end.
+func (l *Logger) count(lv Level, fields []Field) {
+	k := countKey{lv: lv}
+	for _, f := range fields {
+		if f.Key == "component" {
+			k.component = fmt.Sprint(f.Value)
+		}
+	}
+	l.countMu.Lock()
+	defer l.countMu.Unlock()
+	if l.counts == nil {
+		l.counts = map[countKey]int64{}
+	}
+	l.counts[k]++
+}
+
+// Counts returns how many messages the logger, and every logger made from it with With, has
+// logged since it was made, by level and component, so alerts can fire on a rising error
+// rate without reading the log. Messages held back as repeats count; those below the level
+// don't.
+func (l *Logger) Counts() []Count {
+	l.countMu.Lock()
+	out := make([]Count, 0, len(l.counts))
+	for k, n := range l.counts {
+		out = append(out, Count{k.lv, k.component, n})
+	}
+	l.countMu.Unlock()
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Level != out[j].Level {
+			return out[i].Level < out[j].Level
+		}
+		return out[i].Component < out[j].Component
+	})
+	return out
+}
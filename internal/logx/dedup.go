@@ -0,0 +1,142 @@
+package logx
+
+import (
+	"fmt"
+	"time"
+)
+
+// repeats remembers the messages written within the last window, to hold back the same
+// message written again and again, like a storage backend's error while it is down.
+type repeats struct {
+	window time.Duration
+	seen   map[repeatKey]*repeat
+	swept  time.Time
+	stop   chan struct{} // closed when these are replaced, to stop sweepEvery
+}
+
+type repeatKey struct {
+	lv  Level
+	msg string
+}
+
+type repeat struct {
+	since time.Time // when the message was last written
+	held  int       // times it came again since, not written
+}
+
+// SetDedup makes the logger, and every logger made from it with With, write a message that
+// comes again within window only once, then once the window is over, how often it came:
+//
+//	... [ERROR] store: connection refused
+//	... [ERROR] message repeated 4211 times in last 10s: store: connection refused
+//
+// Messages count as the same when their level and text are; fields don't count. 0 writes
+// every message. The summaries are written once a window is over even if nothing else is
+// logged, and those still pending by Close, Fatal or Panic first, which also turn it off.
+func (l *Logger) SetDedup(window time.Duration) {
+	l.dedupMu.Lock()
+	flush := l.dropRepeats()
+	if window > 0 {
+		l.repeats = &repeats{window: window, seen: map[repeatKey]*repeat{}, stop: make(chan struct{})}
+		go l.sweepEvery(l.repeats)
+	}
+	l.dedupMu.Unlock()
+	for _, r := range flush {
+		l.send(r)
+	}
+}
+
+// sweepEvery writes the summaries of d's repeats whose window is over, every window, until
+// d is replaced.
+func (l *Logger) sweepEvery(d *repeats) {
+	tick := time.NewTicker(d.window)
+	defer tick.Stop()
+	for {
+		select {
+		case now := <-tick.C:
+			l.dedupMu.Lock()
+			var summaries []Record
+			if l.repeats == d {
+				summaries = d.sweep(now, false)
+			}
+			l.dedupMu.Unlock()
+			for _, r := range summaries {
+				l.send(r)
+			}
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+// stopRepeats writes the summaries of every repeat held back so far, whether its window is
+// over or not, and stops holding any back, for when the log is about to end.
+func (l *Logger) stopRepeats() {
+	l.dedupMu.Lock()
+	summaries := l.dropRepeats()
+	l.dedupMu.Unlock()
+	for _, r := range summaries {
+		l.send(r)
+	}
+}
+
+// dropRepeats forgets l's repeats, stopping their sweepEvery, and returns the summaries of
+// those held back; the caller holds dedupMu.
+func (l *Logger) dropRepeats() []Record {
+	d := l.repeats
+	if d == nil {
+		return nil
+	}
+	l.repeats = nil
+	close(d.stop)
+	return d.sweep(time.Now(), true)
+}
+
+// dedup reports whether r is a repeat to hold back, and returns the summaries of repeats
+// whose window is over, to write first.
+func (l *Logger) dedup(r Record) (held bool, summaries []Record) {
+	l.dedupMu.Lock()
+	defer l.dedupMu.Unlock()
+	d := l.repeats
+	if d == nil || r.Level >= LevelPanic {
+		return false, nil
+	}
+	if r.Time.Sub(d.swept) >= d.window {
+		summaries = d.sweep(r.Time, false)
+	}
+	k := repeatKey{r.Level, r.Message}
+	if rep, ok := d.seen[k]; ok && r.Time.Sub(rep.since) < d.window {
+		rep.held++
+		return true, summaries
+	}
+	if rep, ok := d.seen[k]; ok && rep.held > 0 {
+		summaries = append(summaries, d.summary(k, rep))
+	}
+	d.seen[k] = &repeat{since: r.Time}
+	return false, summaries
+}
+
+// sweep forgets the messages whose window is over, or all of them, and returns summaries of
+// those that were held back.
+func (d *repeats) sweep(now time.Time, all bool) []Record {
+	d.swept = now
+	var out []Record
+	for k, rep := range d.seen {
+		if !all && now.Sub(rep.since) < d.window {
+			continue
+		}
+		if rep.held > 0 {
+			out = append(out, d.summary(k, rep))
+		}
+		delete(d.seen, k)
+	}
+	return out
+}
+
+func (d *repeats) summary(k repeatKey, rep *repeat) Record {
+	return Record{
+		Time:    time.Now(),
+		Level:   k.lv,
+		Message: fmt.Sprintf("message repeated %d times in last %s: %s", rep.held, d.window, k.msg),
+	}
+}
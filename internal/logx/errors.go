@@ -0,0 +1,122 @@
+package logx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// ErrorE logs err at LevelError, with msg as the message and kv as key, value pairs of
+// fields, rather than flattening err into the message, so collectors can group on it:
+//
+//	log.ErrorE(err, "store blob", "file_id", id)
+//	// ... [ERROR] store blob file_id=0j3b32Vfusu9 error="write blobs/0j: no space left on device" error_type=*fs.PathError,syscall.Errno
+//
+// error is err's message and error_type the types of the errors it wraps, outermost first.
+// With SetErrorStacks on, unexpected errors get a stack field too: those that aren't Expected,
+// a cancelled or timed out context, a missing file, EOF or a closed connection.
+func (l *Logger) ErrorE(err error, msg string, kv ...any) {
+	if !l.Enabled(LevelError) || err == nil {
+		return
+	}
+	fields := append(l.fields[:len(l.fields):len(l.fields)], pairs(kv)...)
+	fields = append(fields, Field{"error", err.Error()}, Field{"error_type", errorTypes(err)})
+	if l.stacks.Load() && !expected(err) {
+		fields = append(fields, Field{"stack", stack(3 + l.skip)}) // runtime.Callers, stack, then ErrorE
+	}
+	if l.caller.Load() {
+		var pc [1]uintptr
+		runtime.Callers(2+l.skip, pc[:]) // runtime.Callers, then ErrorE
+		fields = withCaller(fields, pc[0])
+	}
+	l.write(LevelError, msg, fields)
+}
+
+// SetErrorStacks makes ErrorE add a stack trace to unexpected errors, for the logger and
+// every logger made from it with With.
+func (l *Logger) SetErrorStacks(on bool) { l.stacks.Store(on) }
+
+// pairs turns key, value pairs into fields. A value left without a key is kept as !BADKEY,
+// as log/slog does.
+func pairs(kv []any) []Field {
+	fields := make([]Field, 0, (len(kv)+1)/2)
+	for i := 0; i < len(kv); i += 2 {
+		if i+1 == len(kv) {
+			fields = append(fields, Field{"!BADKEY", kv[i]})
+			break
+		}
+		fields = append(fields, Field{fmt.Sprint(kv[i]), kv[i+1]})
+	}
+	return fields
+}
+
+// errorTypes lists the types of err and the errors it wraps, outermost first, each once.
+func errorTypes(err error) string {
+	var types []string
+	seen := map[string]bool{}
+	queue := []error{err}
+	for len(queue) > 0 {
+		e := queue[0]
+		queue = queue[1:]
+		if _, ok := e.(expectedError); !ok {
+			if t := fmt.Sprintf("%T", e); !seen[t] {
+				seen[t] = true
+				types = append(types, t)
+			}
+		}
+		switch u := e.(type) {
+		case interface{ Unwrap() error }:
+			if next := u.Unwrap(); next != nil {
+				queue = append(queue, next)
+			}
+		case interface{ Unwrap() []error }:
+			queue = append(queue, u.Unwrap()...)
+		}
+	}
+	return strings.Join(types, ",")
+}
+
+type expectedError struct{ error }
+
+func (e expectedError) Unwrap() error { return e.error }
+
+// Expected marks err as one that happens in the normal course of things, like a client
+// hanging up, so ErrorE doesn't take a stack trace for it.
+func Expected(err error) error {
+	if err == nil {
+		return nil
+	}
+	return expectedError{err}
+}
+
+func expected(err error) bool {
+	var e expectedError
+	return errors.As(err, &e) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, fs.ErrNotExist) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed)
+}
+
+// stack returns the calls leading to the caller skip frames up, innermost first, one per line.
+func stack(skip int) string {
+	pcs := make([]uintptr, 32)
+	pcs = pcs[:runtime.Callers(skip, pcs)]
+	var b strings.Builder
+	frames := runtime.CallersFrames(pcs)
+	for {
+		f, more := frames.Next()
+		if !strings.HasPrefix(f.Function, "runtime.") {
+			if b.Len() > 0 {
+				b.WriteByte('\n')
+			}
+			b.WriteString(lastElems(f.Function, "/", 1) + " " + lastElems(f.File, "/", 2) + ":" + strconv.Itoa(f.Line))
+		}
+		if !more {
+			return b.String()
+		}
+	}
+}
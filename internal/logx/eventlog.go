@@ -0,0 +1,83 @@
+package logx
+
+import (
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// eventLogMax is the most UTF-16 code units ReportEvent takes in one string.
+const eventLogMax = 31839
+
+// EventLog is a sink that writes to the Windows Event Log, under Applications and Services
+// as an application's events: errors as Error events, warnings as Warning and the rest as
+// Information, so they show in Event Viewer and Get-WinEvent next to other services', and
+// alerts set up for the server's other software pick them up too. Windows only.
+//
+// The source has to be registered, as InstallEventSource does, for Event Viewer to show the
+// messages without a complaint about a missing description.
+type EventLog struct {
+	h uintptr
+}
+
+// OpenEventLog writes to the Application log as source.
+func OpenEventLog(source string) (*EventLog, error) {
+	h, err := openEventLog(source)
+	if err != nil {
+		return nil, err
+	}
+	return &EventLog{h: h}, nil
+}
+
+// WriteEntry writes r as one event, with the fields on the end of its text as a text line has
+// them.
+func (e *EventLog) WriteEntry(r Record) error {
+	return reportEvent(e.h, eventType(r.Level), eventMessage(r))
+}
+
+// Write writes p as an information event.
+func (e *EventLog) Write(p []byte) (int, error) {
+	err := e.WriteEntry(Record{Time: time.Now(), Level: LevelInfo, Message: strings.TrimRight(string(p), "\n")})
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close lets go of the event source.
+func (e *EventLog) Close() error { return closeEventLog(e.h) }
+
+// from winnt.h
+const (
+	eventLogErrorType       = 0x0001
+	eventLogWarningType     = 0x0002
+	eventLogInformationType = 0x0004
+)
+
+// eventType is the event type of lv; the Event Log has nothing below Information.
+func eventType(lv Level) uint16 {
+	switch {
+	case lv >= LevelError:
+		return eventLogErrorType
+	case lv >= LevelWarn:
+		return eventLogWarningType
+	}
+	return eventLogInformationType
+}
+
+// eventMessage is r's text as an event has it, cut short to what ReportEvent takes.
+func eventMessage(r Record) string {
+	msg := r.Message + textFields(r.Fields)
+	if len(msg) <= eventLogMax { // no shorter in UTF-16
+		return msg
+	}
+	units := utf16.Encode([]rune(msg))
+	if len(units) <= eventLogMax {
+		return msg
+	}
+	cut := units[:eventLogMax-1]                                  // room for the ellipsis
+	if last := cut[len(cut)-1]; 0xd800 <= last && last < 0xdc00 { // half a surrogate pair
+		cut = cut[:len(cut)-1]
+	}
+	return string(utf16.Decode(cut)) + "…"
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+
+package logx
+
+import "errors"
+
+var errNoEventLog = errors.New("event log: only on Windows")
+
+func openEventLog(string) (uintptr, error) { return 0, errNoEventLog }
+
+func reportEvent(uintptr, uint16, string) error { return errNoEventLog }
+
+func closeEventLog(uintptr) error { return nil }
+
+// InstallEventSource registers source with the Windows Event Log; elsewhere there is none.
+func InstallEventSource(string) error { return errNoEventLog }
+
+// RemoveEventSource undoes InstallEventSource.
+func RemoveEventSource(string) error { return errNoEventLog }
@@ -0,0 +1,100 @@
+//go:build windows
+
+package logx
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	advapi32                  = syscall.NewLazyDLL("advapi32.dll")
+	procRegisterEventSource   = advapi32.NewProc("RegisterEventSourceW")
+	procDeregisterEventSource = advapi32.NewProc("DeregisterEventSource")
+	procReportEvent           = advapi32.NewProc("ReportEventW")
+	procRegCreateKeyEx        = advapi32.NewProc("RegCreateKeyExW")
+	procRegSetValueEx         = advapi32.NewProc("RegSetValueExW")
+	procRegDeleteKey          = advapi32.NewProc("RegDeleteKeyW")
+	procRegCloseKey           = advapi32.NewProc("RegCloseKey")
+)
+
+// from winreg.h and winnt.h
+const (
+	hkeyLocalMachine = 0x80000002
+	keyWrite         = 0x20006
+	regExpandSz      = 2
+	regDword         = 4
+	errFileNotFound  = 2
+)
+
+// eventSourceKey is where the Application log's sources are registered.
+const eventSourceKey = `SYSTEM\CurrentControlSet\Services\EventLog\Application\`
+
+// eventMessageFile has a message, "%1", for each event ID from 1 to 1000; with it as their
+// message file, events show the text they were reported with, and nothing needs compiling
+// into a resource of our own.
+const eventMessageFile = `%SystemRoot%\System32\EventCreate.exe`
+
+// eventID is the ID every event is reported with.
+const eventID = 1
+
+func openEventLog(source string) (uintptr, error) {
+	h, _, err := procRegisterEventSource.Call(0, uintptr(unsafe.Pointer(utf16Ptr(source))))
+	if h == 0 {
+		return 0, fmt.Errorf("event log: open source %s: %w", source, err)
+	}
+	return h, nil
+}
+
+func reportEvent(h uintptr, typ uint16, msg string) error {
+	strs := []*uint16{utf16Ptr(msg)}
+	ok, _, err := procReportEvent.Call(h, uintptr(typ), 0, eventID, 0, 1, 0, uintptr(unsafe.Pointer(&strs[0])), 0)
+	if ok == 0 {
+		return fmt.Errorf("event log: %w", err)
+	}
+	return nil
+}
+
+func closeEventLog(h uintptr) error {
+	if ok, _, err := procDeregisterEventSource.Call(h); ok == 0 {
+		return fmt.Errorf("event log: %w", err)
+	}
+	return nil
+}
+
+// InstallEventSource registers source with the Application log, for Event Viewer to show its
+// events' text. It needs an elevated prompt; "filegoblin service install" calls it.
+func InstallEventSource(source string) error {
+	var key uintptr
+	if rc, _, _ := procRegCreateKeyEx.Call(hkeyLocalMachine, uintptr(unsafe.Pointer(utf16Ptr(eventSourceKey+source))),
+		0, 0, 0, keyWrite, 0, uintptr(unsafe.Pointer(&key)), 0); rc != 0 {
+		return fmt.Errorf("event log: register source %s: %w", source, syscall.Errno(rc))
+	}
+	defer procRegCloseKey.Call(key)
+	file, _ := syscall.UTF16FromString(eventMessageFile)
+	if rc, _, _ := procRegSetValueEx.Call(key, uintptr(unsafe.Pointer(utf16Ptr("EventMessageFile"))), 0, regExpandSz,
+		uintptr(unsafe.Pointer(&file[0])), uintptr(2*len(file))); rc != 0 {
+		return fmt.Errorf("event log: register source %s: %w", source, syscall.Errno(rc))
+	}
+	types := uint32(eventLogErrorType | eventLogWarningType | eventLogInformationType)
+	if rc, _, _ := procRegSetValueEx.Call(key, uintptr(unsafe.Pointer(utf16Ptr("TypesSupported"))), 0, regDword,
+		uintptr(unsafe.Pointer(&types)), 4); rc != 0 {
+		return fmt.Errorf("event log: register source %s: %w", source, syscall.Errno(rc))
+	}
+	return nil
+}
+
+// RemoveEventSource undoes InstallEventSource. A source that isn't registered is no error.
+func RemoveEventSource(source string) error {
+	rc, _, _ := procRegDeleteKey.Call(hkeyLocalMachine, uintptr(unsafe.Pointer(utf16Ptr(eventSourceKey+source))))
+	if rc != 0 && rc != errFileNotFound {
+		return fmt.Errorf("event log: remove source %s: %w", source, syscall.Errno(rc))
+	}
+	return nil
+}
+
+func utf16Ptr(s string) *uint16 {
+	p, _ := syscall.UTF16PtrFromString(s)
+	return p
+}
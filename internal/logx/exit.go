@@ -0,0 +1,80 @@
+package logx
+
+import (
+	"fmt"
+	"time"
+)
+
+// exitTimeout is how long Fatal and Panic wait for the AtExit hooks, all of them together,
+// so one stuck on a dead disk or network can't keep a broken process alive.
+const exitTimeout = 10 * time.Second
+
+type exitHook struct {
+	id   int
+	name string
+	f    func() error
+}
+
+// AtExit registers f to run when Fatal or Panic is about to end the process, to clean up what
+// a crash would leave half done, like closing the audit trail or removing partial uploads:
+//
+//	remove := log.AtExit("audit trail", trail.Close)
+//	defer remove()
+//
+// Hooks run newest first, as deferred calls do, each once; an error or panic from one is
+// logged and the rest run anyway. Calling the returned function unregisters f, for when what
+// it cleans up is closed the normal way.
+func (l *Logger) AtExit(name string, f func() error) (remove func()) {
+	l.exitMu.Lock()
+	defer l.exitMu.Unlock()
+	l.exitID++
+	id := l.exitID
+	l.exitHooks = append(l.exitHooks, exitHook{id, name, f})
+	return func() {
+		l.exitMu.Lock()
+		defer l.exitMu.Unlock()
+		for i, h := range l.exitHooks {
+			if h.id == id {
+				l.exitHooks = append(l.exitHooks[:i:i], l.exitHooks[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// runExitHooks runs and forgets the AtExit hooks, giving up on those left after exitTimeout.
+func (l *Logger) runExitHooks() {
+	l.exitMu.Lock()
+	hooks := l.exitHooks
+	l.exitHooks = nil
+	l.exitMu.Unlock()
+	if len(hooks) == 0 {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := len(hooks) - 1; i >= 0; i-- {
+			if err := runExitHook(hooks[i]); err != nil {
+				l.write(LevelError, fmt.Sprintf("exit hook %s: %v", hooks[i].name, err), l.fields)
+			}
+		}
+	}()
+	timer := time.NewTimer(exitTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		l.write(LevelError, fmt.Sprintf("exit hooks still running after %s, exiting anyway", exitTimeout), l.fields)
+	}
+}
+
+// runExitHook runs h, turning a panic into an error.
+func runExitHook(h exitHook) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return h.f()
+}
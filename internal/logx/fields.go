@@ -0,0 +1,103 @@
+package logx
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Field is a key/value pair written with every message of a logger, like the ID of the
+// file a request is uploading.
+type Field struct {
+	Key   string
+	Value any
+}
+
+// With returns a logger that writes key=value with every message, besides the fields this
+// one already has; a key it already has gets the new value. The two share their output, level
+// and remembered errors, so With is cheap enough to call per request:
+//
+//	log := s.log.With("file_id", id)
+//	log.Info("uploaded %d bytes", n) // ... [INFO] uploaded 512 bytes file_id=0j3b32Vfusu9
+func (l *Logger) With(key string, value any) *Logger {
+	fields := make([]Field, 0, len(l.fields)+1)
+	for _, f := range l.fields {
+		if f.Key != key {
+			fields = append(fields, f)
+		}
+	}
+	return &Logger{output: l.output, fields: append(fields, Field{key, value}), skip: l.skip, name: l.name}
+}
+
+// textFields renders fields the way text lines end: " key=value", quoting values that have
+// spaces, quotes or control characters in them.
+func textFields(fields []Field) string {
+	var b strings.Builder
+	for _, f := range fields {
+		b.WriteByte(' ')
+		b.WriteString(f.Key)
+		b.WriteByte('=')
+		b.WriteString(textValue(f.Value))
+	}
+	return b.String()
+}
+
+// textValue renders a field's value for a text line, quoted if it needs to be.
+func textValue(v any) string {
+	s := fmt.Sprint(value(v))
+	if s == "" || strings.IndexFunc(s, func(r rune) bool { return unicode.IsSpace(r) || r == '"' || r == '=' || !unicode.IsPrint(r) }) >= 0 {
+		s = strconv.Quote(s)
+	}
+	return s
+}
+
+// value turns what can't be written as is into something that can: errors into their message.
+func value(v any) any {
+	if err, ok := v.(error); ok && err != nil {
+		return err.Error()
+	}
+	return v
+}
+
+// reserved are the keys a JSON line has of its own; fields named like them get a leading
+// underscore rather than writing the key twice.
+var reserved = map[string]bool{"ts": true, "level": true, "msg": true}
+
+// jsonLine renders one line of a JSON sink: ts, level and msg, then the fields in order.
+// JSON escaping already keeps a message with line breaks on one line.
+func jsonLine(ts any, lv Level, msg string, fields []Field) []byte {
+	var b bytes.Buffer
+	b.WriteString(`{"ts":`)
+	writeJSONValue(&b, ts)
+	b.WriteString(`,"level":`)
+	writeJSONValue(&b, strings.ToLower(lv.String()))
+	b.WriteString(`,"msg":`)
+	writeJSONValue(&b, msg)
+	for _, f := range fields {
+		key := f.Key
+		if reserved[key] {
+			key = "_" + key
+		}
+		b.WriteByte(',')
+		writeJSONValue(&b, key)
+		b.WriteByte(':')
+		writeJSONValue(&b, value(f.Value))
+	}
+	b.WriteString("}\n")
+	return b.Bytes()
+}
+
+// writeJSONValue writes v as JSON, or as the string fmt makes of it when it has no JSON form.
+func writeJSONValue(b *bytes.Buffer, v any) {
+	enc := json.NewEncoder(b)
+	enc.SetEscapeHTML(false)
+	start := b.Len()
+	if err := enc.Encode(v); err != nil {
+		b.Truncate(start)
+		_ = enc.Encode(fmt.Sprint(v))
+	}
+	b.Truncate(b.Len() - 1) // Encode ends with a newline
+}
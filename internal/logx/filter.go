@@ -0,0 +1,82 @@
+package logx
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Filter picks out messages for SetFilters to keep or drop: those whose text Message matches
+// and whose fields match Fields, by key, each of which a message must have. A nil Message
+// matches any text. Only messages up to UpTo are picked out, LevelInfo at the zero value, so
+// filtering out a noisy kind of request can't hide its errors too.
+type Filter struct {
+	Message *regexp.Regexp
+	Fields  map[string]*regexp.Regexp
+	UpTo    Level
+}
+
+type filters struct {
+	include, exclude []Filter
+}
+
+// SetFilters has the logger drop the messages any of exclude picks out, such as the access
+// log lines of health checks, and those at the levels of include filters that none of them
+// picks out:
+//
+//	log.SetFilters(nil, []logx.Filter{{
+//		Message: regexp.MustCompile(`^request$`),
+//		Fields:  map[string]*regexp.Regexp{"path": regexp.MustCompile(`^/healthz$`)},
+//	}})
+//
+// Dropped messages are as good as below the level: they don't count in Counts either. It is
+// safe to call while other goroutines are logging; no filters lets every message through.
+func (l *Logger) SetFilters(include, exclude []Filter) {
+	if len(include) == 0 && len(exclude) == 0 {
+		l.filters.Store(nil)
+		return
+	}
+	l.filters.Store(&filters{include: include, exclude: exclude})
+}
+
+// filtered reports whether the filters drop a message.
+func (l *Logger) filtered(lv Level, msg string, fields []Field) bool {
+	fs := l.filters.Load()
+	if fs == nil {
+		return false
+	}
+	for _, f := range fs.exclude {
+		if f.match(lv, msg, fields) {
+			return true
+		}
+	}
+	if len(fs.include) == 0 {
+		return false
+	}
+	covered := false
+	for _, f := range fs.include {
+		if f.match(lv, msg, fields) {
+			return false
+		}
+		covered = covered || lv <= f.UpTo
+	}
+	return covered // a message above every include filter's level goes through
+}
+
+func (f Filter) match(lv Level, msg string, fields []Field) bool {
+	if lv > f.UpTo || f.Message != nil && !f.Message.MatchString(msg) {
+		return false
+	}
+	for key, re := range f.Fields {
+		found := false
+		for _, fl := range fields {
+			if fl.Key == key {
+				found = re.MatchString(fmt.Sprint(value(fl.Value)))
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,44 @@
+package logx
+
+// Hook is told of every message a logger writes at or above the level it was added with,
+// redacted as the sinks get it, to raise an alert or report to an error tracker:
+//
+//	log.AddHook(logx.LevelError, logx.HookFunc(func(lv logx.Level, msg string, fields []logx.Field) {
+//		pager.Trigger(msg)
+//	}))
+//
+// Fire runs on the goroutine that logged the message, or the one writing for an asynchronous
+// logger, so one that talks to the network should hand the message off rather than wait.
+// It must not log through the same logger, which would call it again.
+type Hook interface {
+	Fire(lv Level, msg string, fields []Field)
+}
+
+// HookFunc lets an ordinary function be a Hook.
+type HookFunc func(lv Level, msg string, fields []Field)
+
+// Fire calls f.
+func (f HookFunc) Fire(lv Level, msg string, fields []Field) { f(lv, msg, fields) }
+
+type hook struct {
+	min Level
+	h   Hook
+}
+
+// AddHook has h fired for each message at min and above that the logger, or one made from it
+// with With, writes from now on.
+func (l *Logger) AddHook(min Level, h Hook) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.hooks = append(l.hooks, hook{min, h})
+}
+
+// fire calls the hooks that take r's level. l.mu is not held, so a hook can take its time
+// without holding up other goroutines' messages.
+func fire(hooks []hook, r Record) {
+	for _, h := range hooks {
+		if r.Level >= h.min {
+			h.h.Fire(r.Level, r.Message, r.Fields)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package logx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// journalSocket is where journald takes messages in its native protocol.
+var journalSocket = "/run/systemd/journal/socket"
+
+// Journal is a sink that writes to the systemd journal in its native protocol, so the level
+// becomes the entry's priority and every field a journal field of its own, to filter on
+// with journalctl:
+//
+//	journalctl -u filegoblin REQUEST_ID=Yp3hQ0k2cY8r
+type Journal struct {
+	tag  string
+	conn net.Conn
+}
+
+// OpenJournal connects to the journal, logging as from tag, the daemon's name.
+func OpenJournal(tag string) (*Journal, error) {
+	conn, err := net.Dial("unixgram", journalSocket)
+	if err != nil {
+		return nil, fmt.Errorf("journal: %w", err)
+	}
+	return &Journal{tag: tag, conn: conn}, nil
+}
+
+// journalReserved are the journal fields a message sets itself; log fields with the same
+// name get a FIELD_ prefix instead of overwriting them.
+var journalReserved = map[string]bool{"MESSAGE": true, "PRIORITY": true, "SYSLOG_IDENTIFIER": true}
+
+// WriteEntry writes r as one journal entry. MESSAGE carries the fields too, as a text line
+// would, for journalctl's usual output.
+func (j *Journal) WriteEntry(r Record) error {
+	var b bytes.Buffer
+	journalField(&b, "MESSAGE", r.Message+textFields(r.Fields))
+	journalField(&b, "PRIORITY", strconv.Itoa(severity(r.Level)))
+	journalField(&b, "SYSLOG_IDENTIFIER", j.tag)
+	for _, f := range r.Fields {
+		key := journalKey(f.Key)
+		if journalReserved[key] {
+			key = "FIELD_" + key
+		}
+		journalField(&b, key, fmt.Sprint(value(f.Value)))
+	}
+	_, err := j.conn.Write(b.Bytes())
+	return err
+}
+
+// Write writes p as an info message.
+func (j *Journal) Write(p []byte) (int, error) {
+	err := j.WriteEntry(Record{Time: time.Now(), Level: LevelInfo, Message: strings.TrimRight(string(p), "\n")})
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close closes the connection.
+func (j *Journal) Close() error { return j.conn.Close() }
+
+// journalKey makes a valid journal field name of key: upper case letters, digits and
+// underscores, starting with a letter.
+func journalKey(key string) string {
+	k := []byte(strings.ToUpper(key))
+	for i, c := range k {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			k[i] = '_'
+		}
+	}
+	name := strings.TrimLeft(string(k), "_0123456789")
+	if name == "" {
+		return "FIELD"
+	}
+	return name
+}
+
+// journalField appends KEY=value, or for a value with line breaks, the key, its length as a
+// little-endian 64-bit number, and the value as it is.
+func journalField(b *bytes.Buffer, key, value string) {
+	if !strings.Contains(value, "\n") {
+		fmt.Fprintf(b, "%s=%s\n", key, value)
+		return
+	}
+	b.WriteString(key)
+	b.WriteByte('\n')
+	_ = binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
@@ -0,0 +1,131 @@
+// Package logtest captures what a logx.Logger logs, so tests can check for a message and its
+// fields as a record instead of picking text out of a buffer:
+//
+//	log, rec := logtest.New()
+//	s.log = log
+//	... // do something that logs
+//	rec.AssertContains(t, logx.LevelInfo, "uploaded", "file_id", id)
+package logtest
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hey-granth/filegoblin/internal/logx"
+)
+
+// Recorder keeps every message its logger writes. It is safe for concurrent use.
+type Recorder struct {
+	mu      sync.Mutex
+	records []logx.Record
+}
+
+// New returns a logger that writes to a new Recorder, and the Recorder. The logger starts at
+// LevelInfo like any other; SetLevel it to record debug messages too.
+func New() (*logx.Logger, *Recorder) {
+	r := &Recorder{}
+	return logx.NewMulti(logx.Sink{Writer: r, Level: logx.LevelDebug}), r
+}
+
+// WriteEntry keeps rec.
+func (r *Recorder) WriteEntry(rec logx.Record) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records = append(r.records, rec)
+	return nil
+}
+
+// Write keeps p, as written straight to the logger's Writer, as an info message.
+func (r *Recorder) Write(p []byte) (int, error) {
+	return len(p), r.WriteEntry(logx.Record{Level: logx.LevelInfo, Message: strings.TrimRight(string(p), "\n")})
+}
+
+// Records returns the messages written so far, oldest first, redacted as any sink gets them.
+func (r *Recorder) Records() []logx.Record {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]logx.Record(nil), r.records...)
+}
+
+// At returns the messages written at lv, oldest first.
+func (r *Recorder) At(lv logx.Level) []logx.Record {
+	var out []logx.Record
+	for _, rec := range r.Records() {
+		if rec.Level == lv {
+			out = append(out, rec)
+		}
+	}
+	return out
+}
+
+// Reset forgets the messages written so far.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records = nil
+}
+
+// Find returns the first message at lv that has msg in it and the fields kv, given as key,
+// value pairs. Values match if they print the same, so 200 matches a status field of 200
+// whatever its integer type.
+func (r *Recorder) Find(lv logx.Level, msg string, kv ...any) (logx.Record, bool) {
+	for _, rec := range r.At(lv) {
+		if strings.Contains(rec.Message, msg) && hasFields(rec, kv) {
+			return rec, true
+		}
+	}
+	return logx.Record{}, false
+}
+
+// AssertContains fails t unless Find finds a message, and returns it.
+func (r *Recorder) AssertContains(t testing.TB, lv logx.Level, msg string, kv ...any) logx.Record {
+	t.Helper()
+	rec, ok := r.Find(lv, msg, kv...)
+	if !ok {
+		t.Fatalf("no %s message with %q and fields %v in:\n%s", lv, msg, kv, r)
+	}
+	return rec
+}
+
+// AssertNotContains fails t if Find finds a message.
+func (r *Recorder) AssertNotContains(t testing.TB, lv logx.Level, msg string, kv ...any) {
+	t.Helper()
+	if rec, ok := r.Find(lv, msg, kv...); ok {
+		t.Fatalf("unexpected %s message %q %v", lv, rec.Message, rec.Fields)
+	}
+}
+
+// Value returns the value of rec's field key.
+func Value(rec logx.Record, key string) (any, bool) {
+	for _, f := range rec.Fields {
+		if f.Key == key {
+			return f.Value, true
+		}
+	}
+	return nil, false
+}
+
+func hasFields(rec logx.Record, kv []any) bool {
+	for i := 0; i+1 < len(kv); i += 2 {
+		v, ok := Value(rec, fmt.Sprint(kv[i]))
+		if !ok || fmt.Sprint(v) != fmt.Sprint(kv[i+1]) {
+			return false
+		}
+	}
+	return true
+}
+
+// String lists the messages written so far, one per line, for failure messages.
+func (r *Recorder) String() string {
+	var b strings.Builder
+	for _, rec := range r.Records() {
+		fmt.Fprintf(&b, "  [%s] %s", rec.Level, rec.Message)
+		for _, f := range rec.Fields {
+			fmt.Fprintf(&b, " %s=%v", f.Key, f.Value)
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
@@ -0,0 +1,29 @@
+package logtest
+
+import (
+	"testing"
+
+	"github.com/hey-granth/filegoblin/internal/logx"
+)
+
+func TestRecorder(t *testing.T) {
+	log, rec := New()
+	log.With("file_id", "f1").With("bytes", int64(512)).Info("uploaded %q", "a.txt")
+	log.With("token", "s3cret").Warn("slow")
+	log.Debug("below the level")
+
+	got := rec.AssertContains(t, logx.LevelInfo, `uploaded "a.txt"`, "file_id", "f1", "bytes", 512)
+	if v, _ := Value(got, "bytes"); v != int64(512) {
+		t.Fatalf("bytes = %#v", v)
+	}
+	rec.AssertContains(t, logx.LevelWarn, "slow", "token", "[REDACTED]")
+	rec.AssertNotContains(t, logx.LevelInfo, "slow")
+	rec.AssertNotContains(t, logx.LevelInfo, "uploaded", "file_id", "f2")
+	if n := len(rec.Records()); n != 2 {
+		t.Fatalf("%d records:\n%s", n, rec)
+	}
+	rec.Reset()
+	if n := len(rec.At(logx.LevelInfo)); n != 0 {
+		t.Fatalf("%d info records after Reset", n)
+	}
+}
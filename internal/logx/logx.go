@@ -3,62 +3,387 @@ package logx
 import (
 	"fmt"
 	"io"
-	"log"
 	"os"
+	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Logger is a tiny wrapper so tests can inspect output if needed.
 type Logger struct {
-	std *log.Logger // This holds the *log.Logger used to format and write messages. It's a pointer so methods and internal state are shared, not copied.
-	mu  sync.Mutex  // This Mutex is locked around write operations (see Info/Error) so multiple goroutines don't interleave log output.
+	*output         // shared with the loggers With makes from this one
+	fields  []Field // written with every message; see With
+	skip    int     // frames to skip when finding the caller; see CallerSkip
+	name    string  // see Named
+}
+
+// output is where a logger writes, and everything about it that the loggers made by With
+// share: the sinks, the level and the remembered errors.
+type output struct {
+	start time.Time // when the logger was made, which pretty lines count from
+
+	mu sync.Mutex // This Mutex is locked around write operations (see Info/Error) so multiple goroutines don't interleave log output.
 	// Mutex (mutual exclusion) is a synchronization primitive that ensures only one goroutine at a time can execute a "critical section" of code that accesses shared state
-	out io.Writer // This stores the io.Writer (for example os.Stdout or a file) the logger writes to; it’s exposed by the Writer() method so callers can inspect or reuse it.
+	sinks []Sink    // where messages go, each in its own format and from its own level up
+	out   io.Writer // every sink's writer at once, exposed by the Writer() method so callers can reuse it
+
+	level   atomic.Int32                     // the lowest Level written; LevelInfo unless changed by SetLevel
+	levels  atomic.Pointer[map[string]Level] // the levels of named loggers; see SetLevels
+	filters atomic.Pointer[filters]          // messages to drop whatever their level; see SetFilters
+	syncAt  atomic.Int32                     // the lowest Level synced to disk once written; see SetSync
+	redact  *Redactor                        // masks secrets before anything is written; see SetRedactor
+	async   atomic.Pointer[asyncQueue]       // where messages go to be written later; nil writes them right away
+
+	caller atomic.Bool // add the caller of each message as fields; see SetCaller
+	stacks atomic.Bool // add stack traces to unexpected errors; see SetErrorStacks
+
+	timeLayout string         // how text and JSON lines write the time; see SetTimeFormat
+	timeLoc    *time.Location // and in which zone
+
+	dedupMu sync.Mutex
+	repeats *repeats // messages written lately, to hold back repeats of; see SetDedup
+
+	countMu sync.Mutex
+	counts  map[countKey]int64 // messages logged; see Counts
+
+	keep   int     // how many recent errors to remember for RecentErrors; 0 remembers none
+	recent []Entry // the remembered errors, oldest first
+
+	ring      []Record // the last messages written, for Recent; see KeepRecent
+	ringStart int      // where the oldest of them is once ring is full
+
+	hooks []hook // see AddHook
+
+	exitMu    sync.Mutex
+	exitHooks []exitHook // see AtExit
+	exitID    int        // the ID of the last of them registered
+}
+
+// Sink is one of the destinations of a logger made by NewMulti.
+type Sink struct {
+	Writer io.Writer
+	Level  Level // messages below it are left out of this sink; the logger's own level applies first
+	JSON   bool  // one JSON object per line instead of text, as NewJSON writes
+	Pretty bool  // colored and aligned for a person at a terminal, unless JSON is set too
+
+	// Only, when set, narrows Level down further: the sink takes just the levels it returns
+	// true for, such as those Below a level.
+	Only func(Level) bool
+}
+
+// Below returns a Sink.Only for the levels under lv, to split one log between two writers the
+// way container platforms tell normal output from errors:
+//
+//	log := logx.NewMulti(
+//		logx.Sink{Writer: os.Stdout, Level: logx.LevelDebug, Only: logx.Below(logx.LevelWarn)},
+//		logx.Sink{Writer: os.Stderr, Level: logx.LevelWarn},
+//	)
+func Below(lv Level) func(Level) bool {
+	return func(l Level) bool { return l < lv }
+}
+
+// Record is a message as an EntryWriter gets it: redacted, but not yet escaped or formatted.
+type Record struct {
+	Time    time.Time
+	Level   Level
+	Message string
+	Fields  []Field
+}
+
+// EntryWriter is a sink writer that formats messages itself, like syslog and the journal,
+// which have places of their own for the level and the fields. A Sink's JSON is ignored for
+// one; Write is used only for what goes straight to the logger's Writer.
+type EntryWriter interface {
+	io.Writer
+	WriteEntry(Record) error
+}
+
+// Entry is one remembered log message.
+type Entry struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
 }
 
 // this is a constructor for the Logger type. It creates and returns a new *Logger configured to write to the given io.Writer, defaulting to standard output when nil.
+// On a terminal it writes the pretty format, for a person to read; anywhere else plain text.
 func New(w io.Writer) *Logger {
 	if w == nil { // this sets up a default log writer, like if the value of w is passed to be null, the logs will be diplayed into the terminal
 		w = os.Stdout
 	}
-	std := log.New(w, "", 0) // use of standard library logger
-	// we are passing empty string as a prefix coz we are modifying it later on with [INFO] and [ERROR] tags in the Info and Error methods respectively later on.
-	// the standard log library provides a lot of flags which can be displayed in the logs, such as date, time, etc with the logs.
-	// This builds a *log.Logger that writes to w with no prefix and no flags — formatting (timestamp, level) is handled by your wrapper, not the standard logger.
+	// This returns a heap-allocated *Logger. Using a pointer means shared internal state (like the mutex) behaves correctly when the logger is used across goroutines.
+	return NewMulti(Sink{Writer: w, Level: LevelDebug, Pretty: IsTerminal(w)})
+}
 
-	// This returns a heap-allocated *Logger containing the internal *log.Logger and the io.Writer used. Using a pointer means shared internal state (like the mutex) behaves correctly when the logger is used across goroutines.
-	return &Logger{ // returns the pointer to the new logger object.
-		std: std,
-		out: w,
+// NewJSON returns a logger that writes each message as a JSON object on a line of its own,
+// for log collectors like Loki or Elasticsearch to take apart without parsing text:
+//
+//	{"ts":"2024-05-01T12:00:00.123456789Z","level":"info","msg":"listening on [::]:8080 (tls=false)"}
+func NewJSON(w io.Writer) *Logger {
+	return NewMulti(Sink{Writer: w, Level: LevelDebug, JSON: true})
+}
+
+// NewMulti returns a logger that writes every message to each of sinks that takes its level,
+// for instance everything to a file but only errors to standard error:
+//
+//	log := logx.NewMulti(
+//		logx.Sink{Writer: file, Level: logx.LevelDebug, JSON: true},
+//		logx.Sink{Writer: os.Stderr, Level: logx.LevelError},
+//	)
+//
+// SetLevel still decides what is logged at all; a sink's level only narrows it down. What is
+// written to the logger's Writer goes to every sink but those whose Only leaves info out.
+func NewMulti(sinks ...Sink) *Logger {
+	ws := make([]io.Writer, 0, len(sinks))
+	for _, s := range sinks {
+		if s.Only == nil || s.Only(LevelInfo) {
+			ws = append(ws, s.Writer)
+		}
+	}
+	out := io.MultiWriter(ws...)
+	if len(ws) == 1 {
+		out = ws[0]
 	}
+	l := &Logger{output: &output{start: time.Now(), sinks: sinks, out: out, redact: defaultRedactor}}
+	l.syncAt.Store(int32(NoSync))
+	return l
 }
 
+// Level is how important a message is. A logger drops messages below its level.
+type Level int32
+
+const (
+	LevelDebug Level = iota - 1 // detail for tracking down a problem, off by default
+	LevelInfo                   // the normal course of events
+	LevelWarn                   // something looks wrong, but the request or job carries on
+	LevelError                  // something failed
+	LevelPanic                  // a bug: the goroutine panics, and the process with it unless something recovers
+	LevelFatal                  // the process can't go on and exits
+)
+
+var levelNames = map[Level]string{LevelDebug: "DEBUG", LevelInfo: "INFO", LevelWarn: "WARN", LevelError: "ERROR", LevelPanic: "PANIC", LevelFatal: "FATAL"}
+
+func (lv Level) String() string {
+	if name, ok := levelNames[lv]; ok {
+		return name
+	}
+	return fmt.Sprintf("LEVEL(%d)", int32(lv))
+}
+
+// ParseLevel reads a level name as written in config files, in any case: "debug", "info",
+// "warn" (or "warning"), "error", "panic" or "fatal".
+func ParseLevel(s string) (Level, error) {
+	name := strings.ToUpper(strings.TrimSpace(s))
+	if name == "WARNING" {
+		name = "WARN"
+	}
+	for lv, n := range levelNames {
+		if n == name {
+			return lv, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q: want debug, info, warn, error, panic or fatal", s)
+}
+
+// SetLevel makes the logger drop messages below lv from now on. It is safe to call while
+// other goroutines are logging.
+func (l *Logger) SetLevel(lv Level) { l.level.Store(int32(lv)) }
+
+// Level returns the lowest level the logger writes.
+func (l *Logger) Level() Level { return Level(l.level.Load()) }
+
+// Enabled reports whether a message at lv would be written, so callers can skip building
+// expensive debug output nobody will see. A named logger goes by its name's level, if
+// SetLevels gave it one.
+func (l *Logger) Enabled(lv Level) bool { return lv >= l.effectiveLevel() }
+
 // like we do self in python functions and methods, we do (l *Logger) in golang.
 // we use pointer so we can later lock the actual mutex and ensure thread safety, instead of a copy.
 // The ... makes this variadic (like Python's *args). interface{} is Go's "any type" - equivalent to Python's Any or just not type-hinting. So this accepts zero or more arguments of any type.
-func (l *Logger) Info(format string, v ...interface{}) {
-	l.mu.Lock()                      // this locks the mutex to ensure that only one goroutine can execute the following code block at a time, preventing interleaved log output.
-	defer l.mu.Unlock()              // this schedules the unlock to happen when the function returns, ensuring the mutex is always released.
-	msg := fmt.Sprintf(format, v...) // this formats the log message using the provided format string and arguments. v... unpacks the variadic arguments. for example, if format is "Hello %s" and v is ["World"], msg becomes "Hello World".
-	// escape newlines and carriage returns to prevent log injection / header spoofing
-	msg = strings.ReplaceAll(msg, "\n", "\\n")                           // this escapes newlines in the message to avoid log injection. for example, if msg is "Hello\nWorld", it becomes "Hello\\nWorld".
-	msg = strings.ReplaceAll(msg, "\r", "\\r")                           // this escapes carriage returns in the message to avoid log injection. for example, if msg is "Hello\rWorld", it becomes "Hello\\rWorld".
-	l.std.Printf("%s [INFO] %s\n", time.Now().Format(time.RFC3339), msg) // this prints the formatted log message to the logger's output, prefixed with the current time and the [INFO] tag.
-}
-
-// same as Info method but for error level logs.
-func (l *Logger) Error(format string, v ...interface{}) {
+func (l *Logger) Info(format string, v ...interface{}) { l.log(LevelInfo, format, v) }
+
+// Debug logs detail that is only written once the level is lowered to LevelDebug.
+func (l *Logger) Debug(format string, v ...interface{}) { l.log(LevelDebug, format, v) }
+
+// Warn logs something that looks wrong but didn't make anything fail.
+func (l *Logger) Warn(format string, v ...interface{}) { l.log(LevelWarn, format, v) }
+
+// same as Info method but for error level logs. Errors are also remembered for
+// RecentErrors once KeepErrors has been called.
+func (l *Logger) Error(format string, v ...interface{}) { l.log(LevelError, format, v) }
+
+// Fatal logs the message whatever the level, runs the AtExit hooks, then exits the process
+// with status 1.
+func (l *Logger) Fatal(format string, v ...interface{}) {
+	l.log(LevelFatal, format, v)
+	l.stopRepeats()
+	l.runExitHooks()
+	l.Flush()
+	exit(1)
+}
+
+// Panic logs the message whatever the level, runs the AtExit hooks, then panics with it, so
+// deferred calls still run on the way out. Since the hooks run before anything can recover,
+// it is for code after which the process can't go on, not for request handlers.
+func (l *Logger) Panic(format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	l.log(LevelPanic, "%s", []interface{}{msg})
+	l.stopRepeats()
+	l.runExitHooks()
+	l.Flush()
+	panic(msg)
+}
+
+// exit is os.Exit, swapped out by tests.
+var exit = os.Exit
+
+// log writes one message at lv, unless the logger's level is above it.
+func (l *Logger) log(lv Level, format string, v []interface{}) {
+	if !l.Enabled(lv) {
+		return
+	}
+	fields := l.fields
+	if l.caller.Load() {
+		var pc [1]uintptr
+		runtime.Callers(3+l.skip, pc[:]) // runtime.Callers, log, then Info or the like
+		fields = withCaller(fields, pc[0])
+	}
+	l.write(lv, fmt.Sprintf(format, v...), fields) // this formats the log message using the provided format string and arguments. v... unpacks the variadic arguments. for example, if format is "Hello %s" and v is ["World"], msg becomes "Hello World".
+}
+
+// write writes a message that is already formatted, with fields, unless it is filtered out
+// or a repeat to hold back.
+func (l *Logger) write(lv Level, msg string, fields []Field) {
+	if l.filtered(lv, msg, fields) {
+		return
+	}
+	l.count(lv, fields)
+	r := Record{Time: time.Now(), Level: lv, Message: msg, Fields: fields}
+	held, summaries := l.dedup(r)
+	for _, s := range summaries {
+		l.send(s)
+	}
+	if !held {
+		l.send(r)
+	}
+}
+
+// send writes r, or queues it to be written if the logger is asynchronous.
+func (l *Logger) send(r Record) {
+	if q := l.async.Load(); q != nil {
+		q.mu.RLock()
+		if l.async.Load() == q {
+			q.enqueue(r)
+			q.mu.RUnlock()
+			return
+		}
+		q.mu.RUnlock() // closed meanwhile
+	}
+	l.emit(r)
+}
+
+// emit writes r to the sinks, then fires the hooks.
+func (l *Logger) emit(r Record) {
+	redacted, hooks := l.writeSinks(r)
+	fire(hooks, redacted)
+}
+
+// writeSinks writes r to the sinks and returns it redacted, with the hooks to fire for it.
+func (l *Logger) writeSinks(r Record) (Record, []hook) {
+	lv, msg, fields, now := r.Level, r.Message, r.Fields, r.Time
+	l.mu.Lock()         // this locks the mutex to ensure that only one goroutine can execute the following code block at a time, preventing interleaved log output.
+	defer l.mu.Unlock() // this schedules the unlock to happen when the function returns, ensuring the mutex is always released.
+	if l.redact != nil {
+		msg, fields = l.redact.String(msg), l.redact.apply(fields)
+	}
+	raw := msg
+	msg = escape(msg)
+	var text, js, pretty []byte // each format is rendered once, for the first sink that wants it
+	for _, s := range l.sinks {
+		if lv < s.Level || s.Only != nil && !s.Only(lv) {
+			continue
+		}
+		if ew, ok := s.Writer.(EntryWriter); ok {
+			_ = ew.WriteEntry(Record{Time: now, Level: lv, Message: raw, Fields: fields})
+			continue
+		}
+		if s.JSON {
+			if js == nil {
+				js = jsonLine(l.timestamp(now, time.RFC3339Nano, time.UTC), lv, raw, fields)
+			}
+			_, _ = s.Writer.Write(js)
+			continue
+		}
+		if s.Pretty {
+			if pretty == nil {
+				pretty = prettyLine(l.start, now, lv, msg, fields)
+			}
+			_, _ = s.Writer.Write(pretty)
+			continue
+		}
+		if text == nil {
+			// the current time and the level tag, like [INFO], then the message and the fields.
+			text = fmt.Appendf(nil, "%v [%s] %s%s\n", l.timestamp(now, time.RFC3339, time.Local), lv, msg, textFields(fields))
+		}
+		_, _ = s.Writer.Write(text)
+	}
+	if lv >= Level(l.syncAt.Load()) {
+		l.syncSinks(lv)
+	}
+	l.remember(Record{Time: now, Level: lv, Message: msg, Fields: fields})
+	if lv >= LevelError && l.keep > 0 {
+		// drop the oldest entry once we're full, so memory use stays fixed
+		if len(l.recent) == l.keep {
+			l.recent = append(l.recent[:0], l.recent[1:]...)
+		}
+		l.recent = append(l.recent, Entry{Time: now.UTC(), Message: withError(msg, fields)})
+	}
+	return Record{Time: now, Level: lv, Message: raw, Fields: fields}, l.hooks
+}
+
+// withError returns msg with the error field ErrorE adds, if there is one, for a list of errors
+// that shows no fields.
+func withError(msg string, fields []Field) string {
+	for _, f := range fields {
+		if f.Key == "error" {
+			return msg + ": " + escape(fmt.Sprint(f.Value))
+		}
+	}
+	return msg
+}
+
+// escape escapes newlines and carriage returns to prevent log injection / header spoofing.
+func escape(msg string) string {
+	msg = strings.ReplaceAll(msg, "\n", "\\n") // this escapes newlines in the message to avoid log injection. for example, if msg is "Hello\nWorld", it becomes "Hello\\nWorld".
+	msg = strings.ReplaceAll(msg, "\r", "\\r") // this escapes carriage returns in the message to avoid log injection. for example, if msg is "Hello\rWorld", it becomes "Hello\\rWorld".
+	return msg
+}
+
+// KeepErrors makes the logger remember its last n error messages, which the admin dashboard
+// shows. n = 0 turns it off again.
+func (l *Logger) KeepErrors(n int) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
-	msg := fmt.Sprintf(format, v...)
-	msg = strings.ReplaceAll(msg, "\n", "\\n")
-	msg = strings.ReplaceAll(msg, "\r", "\\r")
-	l.std.Printf("%s [ERROR] %s\n", time.Now().Format(time.RFC3339), msg)
+	l.keep = n
+	if len(l.recent) > n {
+		l.recent = append([]Entry(nil), l.recent[len(l.recent)-n:]...)
+	}
+}
+
+// RecentErrors returns a copy of the remembered errors, newest first. The copy means callers
+// can hold on to it while the logger keeps writing.
+func (l *Logger) RecentErrors() []Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make([]Entry, len(l.recent))
+	for i, e := range l.recent {
+		out[len(out)-1-i] = e
+	}
+	return out
 }
 
-// Writer returns the io.Writer the logger writes to. This lets callers inspect or reuse the underlying writer if needed.
+// Writer returns the io.Writer the logger writes to, all of them at once for NewMulti. This lets callers inspect or reuse the underlying writer if needed.
 // io.Writer is an interface which is written in a syntax to define the return type of the function.
 func (l *Logger) Writer() io.Writer { return l.out } // this exposes the raw writer used by the logger.
 
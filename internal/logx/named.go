@@ -0,0 +1,66 @@
+package logx
+
+import "strings"
+
+// Named returns a logger for one part of the program, with name as its "component" field.
+// Names nest with dots: Named("s3") on a logger named "storage" is named "storage.s3". Each
+// name can have a level of its own, set with SetLevels.
+func (l *Logger) Named(name string) *Logger {
+	if l.name != "" {
+		name = l.name + "." + name
+	}
+	c := l.With("component", name)
+	c.name = name
+	return c
+}
+
+// Name returns the name given with Named, or "" for a logger without one.
+func (l *Logger) Name() string { return l.name }
+
+// SetLevels sets the levels of named loggers, replacing any set before, so one part of the
+// program can log in more or less detail than the rest:
+//
+//	log.SetLevels(map[string]logx.Level{"upload": logx.LevelDebug, "storage": logx.LevelWarn})
+//
+// A name's level applies to the loggers named below it too, "storage.s3" and the like,
+// unless they have one of their own. Loggers whose names aren't in levels use SetLevel's.
+func (l *Logger) SetLevels(levels map[string]Level) {
+	if len(levels) == 0 {
+		l.levels.Store(nil)
+		return
+	}
+	m := make(map[string]Level, len(levels))
+	for name, lv := range levels {
+		m[name] = lv
+	}
+	l.levels.Store(&m)
+}
+
+// Levels returns the levels set with SetLevels.
+func (l *Logger) Levels() map[string]Level {
+	out := map[string]Level{}
+	if m := l.levels.Load(); m != nil {
+		for name, lv := range *m {
+			out[name] = lv
+		}
+	}
+	return out
+}
+
+// effectiveLevel is the level of the logger's name, or of the nearest name above it that
+// has one, or else the logger's level.
+func (l *Logger) effectiveLevel() Level {
+	if m := l.levels.Load(); m != nil && l.name != "" {
+		for name := l.name; ; {
+			if lv, ok := (*m)[name]; ok {
+				return lv
+			}
+			i := strings.LastIndexByte(name, '.')
+			if i < 0 {
+				break
+			}
+			name = name[:i]
+		}
+	}
+	return l.Level()
+}
@@ -0,0 +1,275 @@
+package logx
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// otlpBatch is how many messages go in one export at most.
+const otlpBatch = 512
+
+// OTLP is a sink that exports messages to an OpenTelemetry collector as OTLP log records over
+// HTTP, in JSON, as the OpenTelemetry SDK's exporter would. The fields trace_id and span_id,
+// when a message has them, become the record's trace and span, so the collector's backend can
+// show the log lines of a trace; the other fields become attributes.
+//
+// Messages are batched and exported from a goroutine of its own, once a second or as soon as
+// a batch is full. While the collector can't be reached they are held in memory, like a
+// Shipper holds them, and the export retried after a growing delay.
+type OTLP struct {
+	url      string
+	headers  map[string]string
+	client   *http.Client
+	resource []byte // the resource and scope every batch is exported as from
+
+	mu      sync.Mutex
+	pending [][]byte // encoded log records not exported yet, oldest first
+	dropped int
+	closed  bool
+
+	wake chan struct{}
+	done chan struct{}
+}
+
+// NewOTLP exports messages to the collector's OTLP/HTTP endpoint at url, like
+// "http://collector:4318/v1/logs", with headers added to each request, such as an API key.
+// service is the service.name the records are from.
+func NewOTLP(url, service string, headers map[string]string) *OTLP {
+	host, _ := os.Hostname()
+	var res bytes.Buffer
+	res.WriteString(`{"resource":{"attributes":[`)
+	writeAttr(&res, "service.name", service)
+	res.WriteByte(',')
+	writeAttr(&res, "host.name", host)
+	res.WriteString(`]},"scopeLogs":[{"scope":{"name":"filegoblin/logx"},"logRecords":[`)
+	o := &OTLP{url: url, headers: headers, client: &http.Client{Timeout: 10 * time.Second}, resource: res.Bytes(),
+		wake: make(chan struct{}, 1), done: make(chan struct{})}
+	go o.run()
+	return o
+}
+
+// WriteEntry queues r to be exported.
+func (o *OTLP) WriteEntry(r Record) error {
+	o.queue(otlpRecord(r))
+	return nil
+}
+
+// Write queues p as an info message.
+func (o *OTLP) Write(p []byte) (int, error) {
+	o.queue(otlpRecord(Record{Time: time.Now(), Level: LevelInfo, Message: strings.TrimRight(string(p), "\n")}))
+	return len(p), nil
+}
+
+func (o *OTLP) queue(rec []byte) {
+	o.mu.Lock()
+	if len(o.pending) == spillSize {
+		o.pending = o.pending[1:]
+		o.dropped++
+	}
+	o.pending = append(o.pending, rec)
+	full := len(o.pending) >= otlpBatch
+	o.mu.Unlock()
+	if full {
+		select {
+		case o.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// run exports what is queued every second until Close.
+func (o *OTLP) run() {
+	defer close(o.done)
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	backoff := time.Second
+	var retryAt time.Time
+	for {
+		select {
+		case <-tick.C:
+		case <-o.wake:
+		}
+		o.mu.Lock()
+		closed := o.closed
+		o.mu.Unlock()
+		if !closed && time.Now().Before(retryAt) {
+			continue
+		}
+		for {
+			o.mu.Lock()
+			n := min(len(o.pending), otlpBatch)
+			batch, dropped := o.pending[:n:n], o.dropped
+			o.pending, o.dropped = o.pending[n:], 0
+			o.mu.Unlock()
+			if dropped > 0 {
+				batch = append([][]byte{otlpRecord(Record{Time: time.Now(), Level: LevelWarn,
+					Message: fmt.Sprintf("dropped %d log messages while %s was unreachable", dropped, o.url)})}, batch...)
+			}
+			if len(batch) == 0 {
+				break
+			}
+			if err := o.export(batch); err != nil {
+				o.mu.Lock()
+				o.pending = append(batch, o.pending...)
+				if over := len(o.pending) - spillSize; over > 0 {
+					o.pending = o.pending[over:]
+					o.dropped += over
+				}
+				o.mu.Unlock()
+				retryAt = time.Now().Add(backoff)
+				backoff = min(2*backoff, 30*time.Second)
+				break
+			}
+			backoff, retryAt = time.Second, time.Time{}
+		}
+		if closed {
+			return
+		}
+	}
+}
+
+// export sends one batch. A batch the collector rejects as malformed is dropped.
+func (o *OTLP) export(batch [][]byte) error {
+	var body bytes.Buffer
+	body.WriteString(`{"resourceLogs":[`)
+	body.Write(o.resource)
+	body.Write(bytes.Join(batch, []byte{','}))
+	body.WriteString(`]}]}]}`)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.url, &body)
+	if err != nil {
+		return nil // a bad URL never gets better; the config check should have caught it
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range o.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("otlp: %s", resp.Status)
+	}
+	return nil // 4xx: the batch itself is at fault
+}
+
+// otlpSeverity is the OpenTelemetry severity number of lv.
+func otlpSeverity(lv Level) int {
+	switch {
+	case lv >= LevelFatal:
+		return 21
+	case lv >= LevelPanic:
+		return 18
+	case lv >= LevelError:
+		return 17
+	case lv >= LevelWarn:
+		return 13
+	case lv >= LevelInfo:
+		return 9
+	}
+	return 5
+}
+
+// otlpRecord encodes r as an OTLP LogRecord in JSON.
+func otlpRecord(r Record) []byte {
+	var b bytes.Buffer
+	b.WriteString(`{"timeUnixNano":"` + strconv.FormatInt(r.Time.UnixNano(), 10) + `"`)
+	b.WriteString(`,"severityNumber":` + strconv.Itoa(otlpSeverity(r.Level)))
+	b.WriteString(`,"severityText":"` + r.Level.String() + `","body":{"stringValue":`)
+	writeJSONValue(&b, r.Message)
+	b.WriteString(`},"attributes":[`)
+	first := true
+	var trace, span string
+	for _, f := range r.Fields {
+		switch v := fmt.Sprint(value(f.Value)); {
+		case f.Key == "trace_id" && isHex(v, 32):
+			trace = v
+			continue
+		case f.Key == "span_id" && isHex(v, 16):
+			span = v
+			continue
+		}
+		if !first {
+			b.WriteByte(',')
+		}
+		first = false
+		writeAttr(&b, f.Key, value(f.Value))
+	}
+	b.WriteByte(']')
+	if trace != "" {
+		b.WriteString(`,"traceId":"` + trace + `"`)
+		if span != "" {
+			b.WriteString(`,"spanId":"` + span + `"`)
+		}
+	}
+	b.WriteByte('}')
+	return b.Bytes()
+}
+
+// writeAttr writes an OTLP KeyValue, with v as the kind of AnyValue that fits it.
+func writeAttr(b *bytes.Buffer, key string, v any) {
+	b.WriteString(`{"key":`)
+	writeJSONValue(b, key)
+	b.WriteString(`,"value":{`)
+	switch v := v.(type) {
+	case bool:
+		b.WriteString(`"boolValue":` + strconv.FormatBool(v))
+	case int:
+		b.WriteString(`"intValue":"` + strconv.Itoa(v) + `"`)
+	case int64:
+		b.WriteString(`"intValue":"` + strconv.FormatInt(v, 10) + `"`)
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			b.WriteString(`"stringValue":"` + strconv.FormatFloat(v, 'g', -1, 64) + `"`)
+		} else {
+			b.WriteString(`"doubleValue":` + strconv.FormatFloat(v, 'g', -1, 64))
+		}
+	default:
+		b.WriteString(`"stringValue":`)
+		writeJSONValue(b, fmt.Sprint(v))
+	}
+	b.WriteString("}}")
+}
+
+func isHex(s string, n int) bool {
+	if len(s) != n || strings.Trim(s, "0") == "" {
+		return false
+	}
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// Close exports what is still queued, if the collector can be reached, and stops.
+func (o *OTLP) Close() error {
+	o.mu.Lock()
+	if o.closed {
+		o.mu.Unlock()
+		return nil
+	}
+	o.closed = true
+	o.mu.Unlock()
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	<-o.done
+	return nil
+}
@@ -0,0 +1,73 @@
+package logx
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// color is off when NO_COLOR is set, as https://no-color.org asks.
+var color = os.Getenv("NO_COLOR") == ""
+
+var levelColors = map[Level]string{
+	LevelDebug: "\x1b[90m", // grey
+	LevelInfo:  "\x1b[36m", // cyan
+	LevelWarn:  "\x1b[33m", // yellow
+	LevelError: "\x1b[31m", // red
+	LevelPanic: "\x1b[1;31m",
+	LevelFatal: "\x1b[1;31m",
+}
+
+const (
+	dim   = "\x1b[2m"
+	reset = "\x1b[0m"
+)
+
+// messageWidth is how far messages are padded, so the fields after them line up.
+const messageWidth = 44
+
+// prettyLine renders one line of a pretty sink: the time since the logger was made, the
+// colored level, the message padded out, and the fields with their keys dimmed:
+//
+//	12.345s INFO  uploaded 512 bytes                           file_id=0j3b32Vfusu9
+func prettyLine(start, now time.Time, lv Level, msg string, fields []Field) []byte {
+	var b strings.Builder
+	paint := func(code, s string) {
+		if color {
+			b.WriteString(code + s + reset)
+		} else {
+			b.WriteString(s)
+		}
+	}
+	paint(dim, fmt.Sprintf("%9.3fs", now.Sub(start).Seconds()))
+	b.WriteByte(' ')
+	paint(levelColors[lv], fmt.Sprintf("%-5s", lv))
+	b.WriteByte(' ')
+	b.WriteString(msg)
+	if len(fields) > 0 {
+		if n := utf8.RuneCountInString(msg); n < messageWidth {
+			b.WriteString(strings.Repeat(" ", messageWidth-n))
+		}
+	}
+	for _, f := range fields {
+		b.WriteByte(' ')
+		paint(dim, f.Key+"=")
+		b.WriteString(textValue(f.Value))
+	}
+	b.WriteByte('\n')
+	return []byte(b.String())
+}
+
+// IsTerminal reports whether w is a terminal that shows colors, so a person is reading what
+// is written to it rather than a file or another program. New writes the pretty format to one.
+func IsTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0 && enableColors(f)
+}
@@ -0,0 +1,74 @@
+package logx
+
+import (
+	"strings"
+	"time"
+)
+
+// Line is one message kept by KeepRecent, redacted and escaped as the text format writes it.
+type Line struct {
+	Time    time.Time      `json:"time"`
+	Level   string         `json:"level"`
+	Message string         `json:"message"`
+	Fields  map[string]any `json:"fields,omitempty"`
+}
+
+// KeepRecent makes the logger keep its last n messages at any level it writes, for Recent,
+// so what a server has been doing can be looked at without access to its log file. Unlike
+// KeepErrors it keeps whole records, fields and all. n = 0 keeps none.
+func (l *Logger) KeepRecent(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	old := l.ringOrder()
+	if len(old) > n {
+		old = old[len(old)-n:]
+	}
+	l.ring = append(make([]Record, 0, n), old...)
+	l.ringStart = 0
+}
+
+// remember adds r to the ring, overwriting the oldest message once it is full. l.mu is held.
+func (l *Logger) remember(r Record) {
+	switch {
+	case cap(l.ring) == 0:
+	case len(l.ring) < cap(l.ring):
+		l.ring = append(l.ring, r)
+	default:
+		l.ring[l.ringStart] = r
+		l.ringStart = (l.ringStart + 1) % len(l.ring)
+	}
+}
+
+// ringOrder returns a copy of the kept messages, oldest first. l.mu is held.
+func (l *Logger) ringOrder() []Record {
+	out := make([]Record, 0, len(l.ring))
+	out = append(out, l.ring[l.ringStart:]...)
+	return append(out, l.ring[:l.ringStart]...)
+}
+
+// Recent returns the last n kept messages at min and above, oldest first, like the tail of
+// the log; n <= 0 returns all of them.
+func (l *Logger) Recent(min Level, n int) []Line {
+	l.mu.Lock()
+	kept := l.ringOrder()
+	l.mu.Unlock()
+	out := []Line{}
+	for i := len(kept) - 1; i >= 0 && (n <= 0 || len(out) < n); i-- {
+		r := kept[i]
+		if r.Level < min {
+			continue
+		}
+		line := Line{Time: r.Time.UTC(), Level: strings.ToLower(r.Level.String()), Message: r.Message}
+		for _, f := range r.Fields {
+			if line.Fields == nil {
+				line.Fields = map[string]any{}
+			}
+			line.Fields[f.Key] = value(f.Value)
+		}
+		out = append(out, line)
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out
+}
@@ -0,0 +1,102 @@
+package logx
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// redacted is what a secret is replaced with.
+const redacted = "[REDACTED]"
+
+// Redactor masks secrets in messages and fields before they are written: the whole value of
+// a field with a secret's name, and whatever its patterns match anywhere else.
+type Redactor struct {
+	fields   map[string]bool // lower case
+	patterns []*regexp.Regexp
+}
+
+// secretFields are the field names whose values are always masked.
+var secretFields = []string{"password", "passwd", "secret", "token", "access_token", "api_key", "apikey", "authorization", "cookie", "sig", "signature"}
+
+// secretPatterns find secrets in free text: bearer and basic credentials, link signatures
+// and tokens in query strings, and passwords in URLs. Only the first group is masked, so
+// what is left still says what was there.
+var secretPatterns = []string{
+	`(?i)\b(?:bearer|basic)\s+([A-Za-z0-9._~+/=-]+)`,
+	`(?i)[?&](?:sig|signature|token|access_token|password|secret|api_key|apikey)=([^&\s"']+)`,
+	`://[^/\s:@]+:([^/\s@]+)@`,
+}
+
+// NewRedactor returns a Redactor that masks the values of the named fields and the matches of
+// patterns, besides the secrets every Redactor knows. A pattern with a group masks only what
+// the first group matched, the way "token=(\S+)" keeps "token=" in the line.
+func NewRedactor(fields, patterns []string) (*Redactor, error) {
+	r := &Redactor{fields: map[string]bool{}}
+	for _, f := range append(secretFields, fields...) {
+		r.fields[strings.ToLower(f)] = true
+	}
+	for _, p := range append(secretPatterns, patterns...) {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("redact pattern %q: %w", p, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+// defaultRedactor is what a logger starts with.
+var defaultRedactor, _ = NewRedactor(nil, nil)
+
+// String returns s with every match of the patterns masked.
+func (r *Redactor) String(s string) string {
+	for _, re := range r.patterns {
+		s = re.ReplaceAllStringFunc(s, func(m string) string {
+			sub := re.FindStringSubmatchIndex(m)
+			if len(sub) < 4 || sub[2] < 0 {
+				return redacted
+			}
+			return m[:sub[2]] + redacted + m[sub[3]:]
+		})
+	}
+	return s
+}
+
+// apply masks the values of secret fields, and secrets in the rest of the string fields.
+// fields itself is left alone: it belongs to the logger.
+func (r *Redactor) apply(fields []Field) []Field {
+	if len(fields) == 0 {
+		return fields
+	}
+	out := make([]Field, len(fields))
+	for i, f := range fields {
+		out[i] = f
+		if r.fields[strings.ToLower(f.Key)] {
+			out[i].Value = redacted
+		} else if s, ok := value(f.Value).(string); ok {
+			out[i].Value = r.String(s)
+		}
+	}
+	return out
+}
+
+// SetRedactor replaces the Redactor the logger, and every logger made from it with With,
+// masks secrets with. nil turns redaction off.
+func (l *Logger) SetRedactor(r *Redactor) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.redact = r
+}
+
+// Redact masks secrets in s as the logger would in a message, for lines written past it,
+// straight to Writer.
+func (l *Logger) Redact(s string) string {
+	l.mu.Lock()
+	r := l.redact
+	l.mu.Unlock()
+	if r == nil {
+		return s
+	}
+	return r.String(s)
+}
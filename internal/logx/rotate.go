@@ -0,0 +1,307 @@
+package logx
+
+import (
+	"bufio"
+	"cmp"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rotation says when a RotatingFile starts a new file and which old ones it keeps.
+type Rotation struct {
+	MaxSize    int64         // bytes a file may grow to before the next one is started; 0 never rotates
+	MaxAge     time.Duration // rotated files older than this are deleted; 0 keeps them regardless of age
+	MaxBackups int           // rotated files kept, newest first; 0 keeps them all
+	Compress   bool          // gzip rotated files
+
+	// Buffer, when above 0, holds up to that many bytes of messages in memory and writes
+	// them to the file together, every FlushEvery (a second if 0) or once it is full, for
+	// logs busy enough that a write per message costs. What is buffered is lost in a crash,
+	// short of a Logger's SetSync or Flush writing it out first.
+	Buffer     int
+	FlushEvery time.Duration
+}
+
+// backupTime is the layout of the timestamp in a rotated file's name, which sorts in time order
+// and has no colons, which Windows doesn't allow in file names.
+const backupTime = "2006-01-02T15-04-05.000"
+
+// RotatingFile is a log file that is moved aside once it would grow past MaxSize, as
+// "filegoblin-2024-05-01T12-00-00.000.log" next to "filegoblin.log", and a fresh one
+// started. Rotated files beyond MaxBackups or older than MaxAge are deleted, and compressed
+// first if asked, in the background so logging doesn't wait for them.
+type RotatingFile struct {
+	path string
+	rot  Rotation
+	now  func() time.Time
+
+	mu   sync.Mutex
+	f    *os.File
+	buf  *bufio.Writer // in front of f if rot.Buffer is set
+	size int64         // including what is buffered
+
+	stop chan struct{} // ends the goroutine flushing buf
+
+	mill sync.WaitGroup // compressing and deleting rotated files
+}
+
+// OpenFile opens the log file at path for appending, creating it and its directory if
+// needed, and rotates it according to rot.
+func OpenFile(path string, rot Rotation) (*RotatingFile, error) {
+	r := &RotatingFile{path: path, rot: rot, now: time.Now}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	if rot.Buffer > 0 {
+		r.buf = bufio.NewWriterSize(r.f, rot.Buffer)
+		r.stop = make(chan struct{})
+		go r.flushEvery(cmp.Or(rot.FlushEvery, time.Second))
+	}
+	return r, nil
+}
+
+// flushEvery writes out the buffer every d until Close.
+func (r *RotatingFile) flushEvery(d time.Duration) {
+	tick := time.NewTicker(d)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			_ = r.Flush()
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, fi.Size()
+	if r.buf != nil {
+		r.buf.Reset(f)
+	}
+	return nil
+}
+
+// Write appends p to the file, rotating first if p would take it past MaxSize. A message
+// longer than MaxSize gets a file of its own rather than being cut.
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return 0, os.ErrClosed
+	}
+	if r.rot.MaxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.rot.MaxSize {
+		if err := r.rotate(); err != nil {
+			return 0, fmt.Errorf("rotate %s: %w", r.path, err)
+		}
+	}
+	var n int
+	var err error
+	if r.buf != nil {
+		n, err = r.buf.Write(p)
+	} else {
+		n, err = r.f.Write(p)
+	}
+	r.size += int64(n)
+	return n, err
+}
+
+// Flush writes out what is buffered.
+func (r *RotatingFile) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil || r.buf == nil {
+		return nil
+	}
+	return r.buf.Flush()
+}
+
+// Sync writes out what is buffered and has the file synced to disk.
+func (r *RotatingFile) Sync() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return os.ErrClosed
+	}
+	if r.buf != nil {
+		if err := r.buf.Flush(); err != nil {
+			return err
+		}
+	}
+	return r.f.Sync()
+}
+
+// Rotate starts a new file now, whatever the size of the current one.
+func (r *RotatingFile) Rotate() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return os.ErrClosed
+	}
+	return r.rotate()
+}
+
+func (r *RotatingFile) rotate() error {
+	if r.buf != nil {
+		if err := r.buf.Flush(); err != nil {
+			return err
+		}
+	}
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	r.f = nil
+	ext := filepath.Ext(r.path)
+	backup := strings.TrimSuffix(r.path, ext) + "-" + r.now().UTC().Format(backupTime) + ext
+	if err := os.Rename(r.path, backup); err != nil {
+		if reopen := r.open(); reopen != nil {
+			return reopen
+		}
+		return err
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+	r.mill.Add(1)
+	go func() {
+		defer r.mill.Done()
+		r.cleanUp()
+	}()
+	return nil
+}
+
+// Close writes out what is buffered and closes the file, once the rotated files are taken
+// care of.
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mill.Wait()
+	if r.f == nil {
+		return nil
+	}
+	var err error
+	if r.buf != nil {
+		close(r.stop)
+		err = r.buf.Flush()
+	}
+	if cerr := r.f.Close(); err == nil {
+		err = cerr
+	}
+	r.f = nil
+	return err
+}
+
+// cleanUpMu keeps two clean-ups of the same directory from racing each other.
+var cleanUpMu sync.Mutex
+
+// cleanUp compresses rotated files if asked, then deletes those beyond MaxBackups and
+// older than MaxAge. Failures are reported on standard error: logging them would be
+// logging about the log.
+func (r *RotatingFile) cleanUp() {
+	cleanUpMu.Lock()
+	defer cleanUpMu.Unlock()
+	backups, err := r.backups()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "logx: %v\n", err)
+		return
+	}
+	cutoff := time.Time{}
+	if r.rot.MaxAge > 0 {
+		cutoff = r.now().Add(-r.rot.MaxAge)
+	}
+	for i, b := range backups { // newest first
+		if (r.rot.MaxBackups > 0 && i >= r.rot.MaxBackups) || b.at.Before(cutoff) {
+			if err := os.Remove(b.path); err != nil {
+				fmt.Fprintf(os.Stderr, "logx: %v\n", err)
+			}
+			continue
+		}
+		if r.rot.Compress && !strings.HasSuffix(b.path, ".gz") {
+			if err := compress(b.path); err != nil {
+				fmt.Fprintf(os.Stderr, "logx: compress %s: %v\n", b.path, err)
+			}
+		}
+	}
+}
+
+type backup struct {
+	path string
+	at   time.Time
+}
+
+// backups lists the rotated files of the log, newest first.
+func (r *RotatingFile) backups() ([]backup, error) {
+	dir := filepath.Dir(r.path)
+	ext := filepath.Ext(r.path)
+	prefix := strings.TrimSuffix(filepath.Base(r.path), ext) + "-"
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []backup
+	for _, e := range entries {
+		name := e.Name()
+		stamp, ok := strings.CutPrefix(name, prefix)
+		if !ok || e.IsDir() {
+			continue
+		}
+		stamp = strings.TrimSuffix(stamp, ".gz")
+		stamp, ok = strings.CutSuffix(stamp, ext)
+		if !ok {
+			continue
+		}
+		at, err := time.Parse(backupTime, stamp)
+		if err != nil {
+			continue // some other file that happens to start the same way
+		}
+		out = append(out, backup{filepath.Join(dir, name), at})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].at.After(out[j].at) })
+	return out, nil
+}
+
+// compress replaces path with path.gz.
+func compress(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	src.Close()
+	return os.Remove(path)
+}
@@ -0,0 +1,130 @@
+package logx
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRotatingFile checks that the log moves aside once full, and that only the newest
+// rotated files are kept, compressed.
+func TestRotatingFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "filegoblin.log")
+	r, err := OpenFile(path, Rotation{MaxSize: 20, MaxBackups: 2, Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { clock = clock.Add(time.Second); return clock }
+	for _, line := range []string{"first line\n", "second line\n", "third line\n", "fourth line\n"} {
+		if _, err := io.WriteString(r, line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, _ := os.ReadFile(path); string(data) != "fourth line\n" {
+		t.Fatalf("current file holds %q", data)
+	}
+	backups, err := r.backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("expected 2 backups; got %+v", backups)
+	}
+	if want := filepath.Join(dir, "filegoblin-2024-05-01T12-00-03.000.log.gz"); backups[0].path != want {
+		t.Fatalf("newest backup is %s, want %s", backups[0].path, want)
+	}
+	f, err := os.Open(backups[0].path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := io.ReadAll(zr); string(data) != "third line\n" {
+		t.Fatalf("newest backup holds %q", data)
+	}
+}
+
+// TestRotatingFileMaxAge checks that rotated files past MaxAge are deleted.
+func TestRotatingFileMaxAge(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	old := filepath.Join(dir, "app-2020-01-01T00-00-00.000.log")
+	unrelated := filepath.Join(dir, "app-notes.log")
+	for _, p := range []string{old, unrelated} {
+		if err := os.WriteFile(p, []byte("x\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := OpenFile(path, Rotation{MaxAge: 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(r, "line\n")
+	if err := r.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+
+	entries, _ := os.ReadDir(dir)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	got := strings.Join(names, " ")
+	if strings.Contains(got, "2020") || !strings.Contains(got, "app-notes.log") || len(names) != 3 {
+		t.Fatalf("unexpected files after clean-up: %s", got)
+	}
+}
+
+// TestRotatingFileBuffer checks that a buffered log reaches the file on an error when synced
+// on errors, on Flush, and on rotation.
+func TestRotatingFileBuffer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "filegoblin.log")
+	r, err := OpenFile(path, Rotation{MaxSize: 200, Buffer: 4096, FlushEvery: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	logger := NewMulti(Sink{Writer: r, Level: LevelDebug})
+	logger.SetSync(LevelError)
+	lines := func() int {
+		data, _ := os.ReadFile(path)
+		return strings.Count(string(data), "\n")
+	}
+
+	logger.Info("uploaded f1")
+	if n := lines(); n != 0 {
+		t.Fatalf("%d lines written before a flush", n)
+	}
+	logger.Error("put f2: no space left on device")
+	if n := lines(); n != 2 {
+		t.Fatalf("%d lines written after an error, want 2", n)
+	}
+	logger.Info("uploaded f3")
+	logger.Flush()
+	if n := lines(); n != 3 {
+		t.Fatalf("%d lines written after Flush, want 3", n)
+	}
+	logger.Info("uploaded f4")
+	logger.Info(strings.Repeat("x", 100)) // past MaxSize: f4 goes with the rotated file
+	backups, err := r.backups()
+	if err != nil || len(backups) != 1 {
+		t.Fatalf("backups %v, %v", backups, err)
+	}
+	if data, _ := os.ReadFile(backups[0].path); !strings.Contains(string(data), "uploaded f4") {
+		t.Fatalf("rotated file holds %q", data)
+	}
+}
@@ -0,0 +1,263 @@
+package logx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// spillSize is how many messages a Shipper holds while its collector is unreachable. Beyond
+// it the oldest are dropped, and counted in a warning once the collector is back.
+const spillSize = 10000
+
+// Shipper is a sink that ships each message to a log collector over the network: Graylog in
+// GELF, or Logstash as JSON lines. Messages are sent from a goroutine of its own, so logging
+// never waits on the network. While the collector can't be reached they are held in memory
+// and the connection retried, waiting longer after each failure, up to 30 seconds.
+type Shipper struct {
+	network, addr string
+	host          string
+	encode        func(*Shipper, Record) []byte
+	chunked       bool // GELF over UDP, split into chunks that fit in a datagram
+
+	mu      sync.Mutex
+	pending [][]byte // encoded messages not sent yet, oldest first
+	dropped int      // messages dropped since the last were sent
+	closed  bool
+
+	wake chan struct{}
+	done chan struct{}
+}
+
+// DialGELF ships messages to Graylog at addr, as GELF 1.1 over network, "udp" or "tcp". The
+// level goes as the syslog severity and the fields as additional fields.
+func DialGELF(network, addr string) (*Shipper, error) {
+	if network != "udp" && network != "tcp" {
+		return nil, fmt.Errorf("gelf: network %q must be udp or tcp", network)
+	}
+	return ship(network, addr, (*Shipper).gelf, network == "udp"), nil
+}
+
+// DialLogstash ships messages to Logstash at addr over TCP, one JSON object per line, for
+// its tcp input with the json_lines codec.
+func DialLogstash(addr string) (*Shipper, error) {
+	return ship("tcp", addr, (*Shipper).logstash, false), nil
+}
+
+func ship(network, addr string, encode func(*Shipper, Record) []byte, chunked bool) *Shipper {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "localhost"
+	}
+	s := &Shipper{network: network, addr: addr, host: host, encode: encode, chunked: chunked,
+		wake: make(chan struct{}, 1), done: make(chan struct{})}
+	go s.run()
+	return s
+}
+
+// WriteEntry queues r to be shipped.
+func (s *Shipper) WriteEntry(r Record) error {
+	s.queue(s.encode(s, r))
+	return nil
+}
+
+// Write queues p as an info message.
+func (s *Shipper) Write(p []byte) (int, error) {
+	s.queue(s.encode(s, Record{Time: time.Now(), Level: LevelInfo, Message: strings.TrimRight(string(p), "\n")}))
+	return len(p), nil
+}
+
+func (s *Shipper) queue(msg []byte) {
+	s.mu.Lock()
+	if len(s.pending) == spillSize {
+		s.pending = s.pending[1:]
+		s.dropped++
+	}
+	s.pending = append(s.pending, msg)
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run sends what is queued until Close, dialing again with a growing delay after a failure.
+func (s *Shipper) run() {
+	defer close(s.done)
+	var conn net.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	backoff := 100 * time.Millisecond
+	for {
+		s.mu.Lock()
+		batch, dropped, closed := s.pending, s.dropped, s.closed
+		s.pending, s.dropped = nil, 0
+		s.mu.Unlock()
+		if dropped > 0 {
+			batch = append([][]byte{s.encode(s, Record{Time: time.Now(), Level: LevelWarn,
+				Message: fmt.Sprintf("dropped %d log messages while %s was unreachable", dropped, s.addr)})}, batch...)
+		}
+		sent, err := 0, error(nil)
+		if len(batch) > 0 && conn == nil {
+			conn, err = net.DialTimeout(s.network, s.addr, 5*time.Second)
+		}
+		for err == nil && sent < len(batch) {
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err = s.send(conn, batch[sent]); err == nil {
+				sent++
+			}
+		}
+		if err == nil {
+			backoff = 100 * time.Millisecond
+		} else {
+			if conn != nil {
+				conn.Close()
+				conn = nil
+			}
+			s.requeue(batch[sent:])
+		}
+		if closed {
+			return
+		}
+		if err == nil {
+			<-s.wake
+			continue
+		}
+		timer := time.NewTimer(backoff)
+		for waiting := true; waiting; {
+			select {
+			case <-timer.C:
+				waiting = false
+			case <-s.wake: // only Close cuts the wait short; new messages can wait for the retry
+				s.mu.Lock()
+				waiting = !s.closed
+				s.mu.Unlock()
+			}
+		}
+		timer.Stop()
+		backoff = min(2*backoff, 30*time.Second)
+	}
+}
+
+// requeue puts messages that couldn't be sent back in front of those queued meanwhile.
+func (s *Shipper) requeue(msgs [][]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = append(msgs[:len(msgs):len(msgs)], s.pending...)
+	if over := len(s.pending) - spillSize; over > 0 {
+		s.pending = s.pending[over:]
+		s.dropped += over
+	}
+}
+
+// gelfChunk is how much of a message goes in one UDP chunk: what Graylog suggests to get
+// across networks with the usual MTU. A message needing more than 128 chunks is dropped.
+const gelfChunk = 1420
+
+func (s *Shipper) send(conn net.Conn, msg []byte) error {
+	if !s.chunked || len(msg) <= gelfChunk {
+		_, err := conn.Write(msg)
+		return err
+	}
+	n := (len(msg) + gelfChunk - 1) / gelfChunk
+	if n > 128 {
+		return nil
+	}
+	chunk := make([]byte, 12+gelfChunk)
+	chunk[0], chunk[1] = 0x1e, 0x0f
+	binary.BigEndian.PutUint64(chunk[2:10], rand.Uint64()) // the message ID, the same in each chunk
+	chunk[11] = byte(n)
+	for i := range n {
+		chunk[10] = byte(i)
+		part := msg[i*gelfChunk : min((i+1)*gelfChunk, len(msg))]
+		if _, err := conn.Write(append(chunk[:12], part...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gelfKey matches what GELF doesn't allow in the name of an additional field.
+var gelfKey = regexp.MustCompile(`[^\w.\-]`)
+
+// gelf encodes r as a GELF message, ended by a null byte over TCP.
+func (s *Shipper) gelf(r Record) []byte {
+	var b bytes.Buffer
+	b.WriteString(`{"version":"1.1","host":`)
+	writeJSONValue(&b, s.host)
+	b.WriteString(`,"short_message":`)
+	writeJSONValue(&b, r.Message)
+	b.WriteString(`,"timestamp":` + strconv.FormatFloat(float64(r.Time.UnixMilli())/1000, 'f', 3, 64))
+	b.WriteString(`,"level":` + strconv.Itoa(severity(r.Level)))
+	for _, f := range r.Fields {
+		key := "_" + gelfKey.ReplaceAllString(f.Key, "_")
+		if key == "_id" { // reserved by Graylog
+			key = "_id_"
+		}
+		b.WriteByte(',')
+		writeJSONValue(&b, key)
+		b.WriteByte(':')
+		writeJSONValue(&b, value(f.Value))
+	}
+	b.WriteByte('}')
+	if s.network == "tcp" {
+		b.WriteByte(0)
+	}
+	return b.Bytes()
+}
+
+// logstashReserved are the keys of a Logstash event that fields don't get to overwrite.
+var logstashReserved = map[string]bool{"@timestamp": true, "@version": true, "message": true, "level": true, "host": true}
+
+// logstash encodes r as a Logstash event on a line of its own.
+func (s *Shipper) logstash(r Record) []byte {
+	var b bytes.Buffer
+	b.WriteString(`{"@timestamp":`)
+	writeJSONValue(&b, r.Time.UTC().Format(time.RFC3339Nano))
+	b.WriteString(`,"@version":"1","message":`)
+	writeJSONValue(&b, r.Message)
+	b.WriteString(`,"level":`)
+	writeJSONValue(&b, strings.ToLower(r.Level.String()))
+	b.WriteString(`,"host":`)
+	writeJSONValue(&b, s.host)
+	for _, f := range r.Fields {
+		key := f.Key
+		if logstashReserved[key] {
+			key = "_" + key
+		}
+		b.WriteByte(',')
+		writeJSONValue(&b, key)
+		b.WriteByte(':')
+		writeJSONValue(&b, value(f.Value))
+	}
+	b.WriteString("}\n")
+	return b.Bytes()
+}
+
+// Close sends what is still queued, if the collector can be reached, and stops shipping.
+func (s *Shipper) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	<-s.done
+	return nil
+}
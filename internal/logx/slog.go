@@ -0,0 +1,101 @@
+package logx
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+)
+
+// SlogHandler is a slog.Handler that writes through a Logger, so libraries that log with
+// *slog.Logger end up in the same place, at the same level and in the same format as the
+// rest of filegoblin. Attributes become fields; those in a group are named "group.key".
+type SlogHandler struct {
+	log   *Logger
+	group string // prefix for attribute keys, "" or ending in "."
+}
+
+// NewSlogHandler returns a handler writing to l.
+func NewSlogHandler(l *Logger) *SlogHandler { return &SlogHandler{log: l} }
+
+// Slog returns a *slog.Logger writing to l.
+func (l *Logger) Slog() *slog.Logger { return slog.New(NewSlogHandler(l)) }
+
+// fromSlog maps slog's levels onto ours; levels in between go to the one below.
+func fromSlog(lv slog.Level) Level {
+	switch {
+	case lv < slog.LevelInfo:
+		return LevelDebug
+	case lv < slog.LevelWarn:
+		return LevelInfo
+	case lv < slog.LevelError:
+		return LevelWarn
+	}
+	return LevelError
+}
+
+func (h *SlogHandler) Enabled(_ context.Context, lv slog.Level) bool {
+	return h.log.Enabled(fromSlog(lv))
+}
+
+// Handle writes r with the handler's fields, then those of a logger stored in ctx by
+// NewContext, such as a request's ID, then r's own attributes.
+func (h *SlogHandler) Handle(ctx context.Context, r slog.Record) error {
+	fields := h.log.fields
+	if cl := FromContext(ctx); cl != nil {
+		for _, f := range cl.fields {
+			if !slices.ContainsFunc(fields, func(g Field) bool { return g.Key == f.Key }) {
+				fields = append(fields[:len(fields):len(fields)], f) // never into h.log.fields
+			}
+		}
+	}
+	if h.log.caller.Load() {
+		fields = withCaller(fields, r.PC)
+	}
+	if r.NumAttrs() > 0 {
+		fields = append([]Field(nil), fields...)
+		r.Attrs(func(a slog.Attr) bool {
+			fields = appendAttr(fields, h.group, a)
+			return true
+		})
+	}
+	h.log.write(fromSlog(r.Level), r.Message, fields)
+	return nil
+}
+
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var fields []Field
+	for _, a := range attrs {
+		fields = appendAttr(fields, h.group, a)
+	}
+	l := h.log
+	for _, f := range fields {
+		l = l.With(f.Key, f.Value)
+	}
+	return &SlogHandler{log: l, group: h.group}
+}
+
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &SlogHandler{log: h.log, group: h.group + name + "."}
+}
+
+// appendAttr adds a as fields, flattening groups, and leaving out empty attributes the way
+// slog's own handlers do.
+func appendAttr(fields []Field, prefix string, a slog.Attr) []Field {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range v.Group() {
+			fields = appendAttr(fields, prefix, ga)
+		}
+		return fields
+	}
+	if a.Key == "" {
+		return fields
+	}
+	return append(fields, Field{prefix + a.Key, v.Any()})
+}
@@ -0,0 +1,47 @@
+package logx
+
+import "math"
+
+// NoSync turns SetSync off.
+const NoSync Level = math.MaxInt32
+
+// syncer is a sink writer that can put what it was given on disk, like *os.File and
+// *RotatingFile.
+type syncer interface {
+	Sync() error
+}
+
+// flusher is a sink writer that holds messages in a buffer until Flush.
+type flusher interface {
+	Flush() error
+}
+
+// SetSync makes the logger sync the writers of the sinks a message at min or above went to,
+// those that can, as soon as it is written: a buffered RotatingFile writes out its buffer
+// and the file is synced to disk, so an error is there to read even if the machine goes down
+// right after. A synchronous logger has done so before the call that logged it returns. Syncing costs a disk flush each time, so min is best kept
+// to errors. SetSync(NoSync), the default, syncs none.
+func (l *Logger) SetSync(min Level) { l.syncAt.Store(int32(min)) }
+
+// syncSinks syncs the writers of the sinks a message at lv went to. l.mu is held.
+func (l *Logger) syncSinks(lv Level) {
+	for _, s := range l.sinks {
+		if lv < s.Level || s.Only != nil && !s.Only(lv) {
+			continue
+		}
+		if sw, ok := s.Writer.(syncer); ok {
+			_ = sw.Sync() // a terminal can't be synced, and nothing is lost by that
+		}
+	}
+}
+
+// flushSinks has the sink writers that buffer messages write them out.
+func (l *Logger) flushSinks() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, s := range l.sinks {
+		if fw, ok := s.Writer.(flusher); ok {
+			_ = fw.Flush()
+		}
+	}
+}
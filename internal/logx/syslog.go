@@ -0,0 +1,113 @@
+package logx
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// facilityDaemon is the syslog facility for system daemons.
+const facilityDaemon = 3
+
+// severity is the syslog severity of lv, which the journal uses too.
+func severity(lv Level) int {
+	switch {
+	case lv >= LevelPanic:
+		return 2 // critical
+	case lv >= LevelError:
+		return 3
+	case lv >= LevelWarn:
+		return 4
+	case lv >= LevelInfo:
+		return 6
+	}
+	return 7 // debug
+}
+
+// Syslog is a sink that sends each message to a syslog server as an RFC 5424 message, with
+// the level as its severity. It reconnects once if a send fails, so a restarted server
+// doesn't lose the log for good.
+type Syslog struct {
+	network, addr string
+	tag, host     string
+	pid           int
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// DialSyslog connects to the syslog server at addr over network: "udp", "tcp", or "unixgram"
+// or "unix" for a local socket like /dev/log. Messages are sent as from tag, the daemon's
+// name. Over a stream they are framed by octet counting, as RFC 6587 has it.
+func DialSyslog(network, addr, tag string) (*Syslog, error) {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "-"
+	}
+	s := &Syslog{network: network, addr: addr, tag: tag, host: host, pid: os.Getpid()}
+	if err := s.dial(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Syslog) dial() error {
+	conn, err := net.DialTimeout(s.network, s.addr, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("syslog: %w", err)
+	}
+	s.conn = conn
+	return nil
+}
+
+// WriteEntry sends r, with its fields written the way a text line ends.
+func (s *Syslog) WriteEntry(r Record) error {
+	return s.send(r.Time, r.Level, escape(r.Message)+textFields(r.Fields))
+}
+
+// Write sends p as it is, as an info message.
+func (s *Syslog) Write(p []byte) (int, error) {
+	if err := s.send(time.Now(), LevelInfo, escape(strings.TrimRight(string(p), "\n"))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *Syslog) send(t time.Time, lv Level, msg string) error {
+	line := fmt.Sprintf("<%d>1 %s %s %s %d - - %s", facilityDaemon*8+severity(lv),
+		t.UTC().Format("2006-01-02T15:04:05.000000Z07:00"), s.host, s.tag, s.pid, msg)
+	switch s.network {
+	case "tcp", "tcp4", "tcp6", "unix":
+		line = fmt.Sprintf("%d %s", len(line), line)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for retried := false; ; retried = true {
+		if s.conn == nil {
+			if err := s.dial(); err != nil {
+				return err
+			}
+		}
+		_, err := s.conn.Write([]byte(line))
+		if err == nil || retried {
+			return err
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+// Close closes the connection.
+func (s *Syslog) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
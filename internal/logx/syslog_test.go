@@ -0,0 +1,268 @@
+package logx
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslogUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	sl, err := DialSyslog("udp", pc.LocalAddr().String(), "filegoblin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sl.Close()
+	NewMulti(Sink{Writer: sl, Level: LevelDebug}).With("file_id", "f1").Warn("disk\nnearly full")
+
+	buf := make([]byte, 2048)
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	re := regexp.MustCompile(`^<28>1 \d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{6}Z \S+ filegoblin \d+ - - disk\\nnearly full file_id=f1$`)
+	if got := string(buf[:n]); !re.MatchString(got) {
+		t.Fatalf("syslog message %q", got)
+	}
+}
+
+func TestSyslogTCPFraming(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	got := make(chan []string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			got <- nil
+			return
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		r := bufio.NewReader(conn)
+		var frames []string
+		for range 2 {
+			length, err := r.ReadString(' ')
+			if err != nil {
+				break
+			}
+			n, _ := strconv.Atoi(strings.TrimSpace(length))
+			frame := make([]byte, n)
+			if _, err := io.ReadFull(r, frame); err != nil {
+				break
+			}
+			frames = append(frames, string(frame))
+		}
+		got <- frames
+	}()
+	sl, err := DialSyslog("tcp", ln.Addr().String(), "filegoblin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sl.Close()
+	logger := NewMulti(Sink{Writer: sl, Level: LevelDebug})
+	logger.Error("one")
+	logger.Info("two")
+	frames := <-got
+	if len(frames) != 2 || !strings.HasPrefix(frames[0], "<27>1 ") || !strings.HasSuffix(frames[0], " - - one") ||
+		!strings.HasPrefix(frames[1], "<30>1 ") || !strings.HasSuffix(frames[1], " - - two") {
+		t.Fatalf("octet-counted frames %q", frames)
+	}
+}
+
+func TestJournal(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no unix datagram sockets")
+	}
+	sock := filepath.Join(t.TempDir(), "journal.sock")
+	pc, err := net.ListenPacket("unixgram", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	old := journalSocket
+	journalSocket = sock
+	defer func() { journalSocket = old }()
+
+	j, err := OpenJournal("filegoblin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	NewMulti(Sink{Writer: j, Level: LevelDebug}).With("request_id", "r1").With("priority", "high").Error("two\nlines")
+
+	buf := make([]byte, 2048)
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := "two\nlines request_id=r1 priority=high"
+	size := make([]byte, 8)
+	binary.LittleEndian.PutUint64(size, uint64(len(msg)))
+	want := "MESSAGE\n" + string(size) + msg + "\nPRIORITY=3\nSYSLOG_IDENTIFIER=filegoblin\nREQUEST_ID=r1\nFIELD_PRIORITY=high\n"
+	if got := string(buf[:n]); got != want {
+		t.Fatalf("journal datagram\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestGELFChunks(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	g, err := DialGELF("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+	NewMulti(Sink{Writer: g, Level: LevelDebug}).With("id", 7).Error("%s", strings.Repeat("x", 3000))
+
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var msg []byte
+	buf := make([]byte, 2048)
+	for i := range 3 {
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n < 12 || buf[0] != 0x1e || buf[1] != 0x0f || buf[10] != byte(i) || buf[11] != 3 {
+			t.Fatalf("chunk %d header % x", i, buf[:12])
+		}
+		msg = append(msg, buf[12:n]...)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(msg, &got); err != nil {
+		t.Fatalf("%v in %s", err, msg)
+	}
+	if got["version"] != "1.1" || got["level"] != 3.0 || len(got["short_message"].(string)) != 3000 || got["_id_"] != 7.0 {
+		t.Fatalf("GELF message %v", got)
+	}
+}
+
+func TestLogstashReconnects(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close() // down to begin with: what is logged meanwhile waits
+	ls, err := DialLogstash(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ls.Close()
+	logger := NewMulti(Sink{Writer: ls, Level: LevelDebug})
+	logger.With("message", "field").Info("while down")
+	time.Sleep(200 * time.Millisecond)
+
+	if ln, err = net.Listen("tcp", addr); err != nil {
+		t.Skipf("port taken meanwhile: %v", err)
+	}
+	defer ln.Close()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	re := regexp.MustCompile(`^\{"@timestamp":"[^"]+","@version":"1","message":"while down","level":"info","host":"[^"]+","_message":"field"\}\n$`)
+	if !re.MatchString(line) {
+		t.Fatalf("logstash line %q", line)
+	}
+}
+
+func TestOTLP(t *testing.T) {
+	got := make(chan []byte, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/logs" || r.Header.Get("Api-Key") != "k" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("export to %s with headers %v", r.URL.Path, r.Header)
+		}
+		body, _ := io.ReadAll(r.Body)
+		got <- body
+	}))
+	defer srv.Close()
+	o := NewOTLP(srv.URL+"/v1/logs", "filegoblin", map[string]string{"Api-Key": "k"})
+	logger := NewMulti(Sink{Writer: o, Level: LevelDebug})
+	logger.With("trace_id", "4bf92f3577b34da6a3ce929d0e0e4736").With("span_id", "00f067aa0ba902b7").
+		With("bytes", 512).With("ok", true).Warn("slow upload")
+	o.Close() // exports what is queued
+
+	var req struct {
+		ResourceLogs []struct {
+			Resource struct {
+				Attributes []struct {
+					Key   string
+					Value map[string]any
+				}
+			}
+			ScopeLogs []struct {
+				LogRecords []struct {
+					TimeUnixNano   string
+					SeverityNumber int
+					SeverityText   string
+					Body           map[string]any
+					Attributes     []struct {
+						Key   string
+						Value map[string]any
+					}
+					TraceID string `json:"traceId"`
+					SpanID  string `json:"spanId"`
+				}
+			}
+		}
+	}
+	select {
+	case body := <-got:
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Fatalf("%v in %s", err, body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing exported")
+	}
+	rl := req.ResourceLogs[0]
+	if a := rl.Resource.Attributes[0]; a.Key != "service.name" || a.Value["stringValue"] != "filegoblin" {
+		t.Errorf("resource %+v", rl.Resource)
+	}
+	rec := rl.ScopeLogs[0].LogRecords[0]
+	if rec.SeverityNumber != 13 || rec.SeverityText != "WARN" || rec.Body["stringValue"] != "slow upload" ||
+		rec.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || rec.SpanID != "00f067aa0ba902b7" || rec.TimeUnixNano == "" {
+		t.Errorf("record %+v", rec)
+	}
+	if len(rec.Attributes) != 2 || rec.Attributes[0].Value["intValue"] != "512" || rec.Attributes[1].Value["boolValue"] != true {
+		t.Errorf("attributes %+v", rec.Attributes)
+	}
+}
+
+func TestEventMessage(t *testing.T) {
+	r := Record{Level: LevelWarn, Message: "slow disk", Fields: []Field{{"path", "D:\\data"}}}
+	if got := eventMessage(r); got != `slow disk path=D:\data` || eventType(r.Level) != eventLogWarningType {
+		t.Fatalf("got %q, type %d", got, eventType(r.Level))
+	}
+	r.Message, r.Fields = strings.Repeat("a", eventLogMax-2)+"😀b", nil // the emoji is two UTF-16 units
+	got := []rune(eventMessage(r))
+	if len(got) != eventLogMax-1 || got[len(got)-1] != '…' || got[len(got)-2] != 'a' {
+		t.Fatalf("cut to %d runes ending %q", len(got), string(got[len(got)-3:]))
+	}
+}
@@ -0,0 +1,31 @@
+//go:build windows
+
+package logx
+
+import (
+	"os"
+	"syscall"
+)
+
+var (
+	kernel32           = syscall.NewLazyDLL("kernel32.dll")
+	procSetConsoleMode = kernel32.NewProc("SetConsoleMode")
+)
+
+// enableVirtualTerminalProcessing has the console act on ANSI escape codes; from wincon.h.
+const enableVirtualTerminalProcessing = 0x0004
+
+// enableColors turns on ANSI escape codes in the console f writes to, and reports whether it
+// could: consoles older than Windows 10 would show the codes instead.
+func enableColors(f *os.File) bool {
+	var mode uint32
+	h := syscall.Handle(f.Fd())
+	if err := syscall.GetConsoleMode(h, &mode); err != nil {
+		return false
+	}
+	if mode&enableVirtualTerminalProcessing != 0 {
+		return true
+	}
+	r, _, _ := procSetConsoleMode.Call(uintptr(h), uintptr(mode|enableVirtualTerminalProcessing))
+	return r != 0
+}
@@ -0,0 +1,31 @@
+package logx
+
+import "time"
+
+// EpochMillis, as the layout for SetTimeFormat, writes times as milliseconds since 1970: a
+// number in JSON lines.
+const EpochMillis = "epoch_ms"
+
+// SetTimeFormat makes text and JSON lines write their time with layout, in time.Format's
+// terms, rather than RFC 3339, and in loc rather than local time for text and UTC for JSON.
+// An empty layout or a nil loc leaves that part to the format.
+func (l *Logger) SetTimeFormat(layout string, loc *time.Location) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.timeLayout, l.timeLoc = layout, loc
+}
+
+// timestamp renders t for a line that writes it with layout in loc unless SetTimeFormat said
+// otherwise. l.mu is held.
+func (l *Logger) timestamp(t time.Time, layout string, loc *time.Location) any {
+	if l.timeLayout != "" {
+		layout = l.timeLayout
+	}
+	if l.timeLoc != nil {
+		loc = l.timeLoc
+	}
+	if layout == EpochMillis {
+		return t.UnixMilli()
+	}
+	return t.In(loc).Format(layout)
+}
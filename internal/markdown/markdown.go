@@ -0,0 +1,356 @@
+// Package markdown renders Markdown to HTML for the preview page. It covers what READMEs and
+// notes use day to day (headings, paragraphs, emphasis, code, lists, quotes, tables, links)
+// and nothing that could carry markup of its own: raw HTML in the source is shown as text,
+// and links only keep safe URLs. Everything it outputs is built here, so there is no
+// sanitizer to get wrong.
+package markdown
+
+import (
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+
+	"github.com/hey-granth/filegoblin/internal/highlight"
+)
+
+// Resolver decides where a relative link points. It returns the URL to use, or "" to leave
+// the link text without a link.
+type Resolver func(target string) string
+
+// Render turns Markdown into HTML. resolve handles relative links; nil drops them.
+func Render(src string, resolve Resolver) template.HTML {
+	r := renderer{resolve: resolve}
+	lines := strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+	r.blocks(lines)
+	return template.HTML(r.out.String())
+}
+
+type renderer struct {
+	out     strings.Builder
+	resolve Resolver
+	depth   int // of nested quotes and lists
+}
+
+// maxDepth stops quotes and lists nesting further; deeper levels show as plain paragraphs.
+const maxDepth = 16
+
+var (
+	heading   = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	rule      = regexp.MustCompile(`^ {0,3}((\* *){3,}|(- *){3,}|(_ *){3,})$`)
+	listItem  = regexp.MustCompile(`^( {0,3})([-*+]|\d{1,9}[.)])( +|$)`)
+	fence     = regexp.MustCompile("^ {0,3}(```+|~~~+)\\s*([\\w+-]*)")
+	tableRule = regexp.MustCompile(`^\|? *:?-+:? *(\| *:?-+:? *)*\|? *$`)
+)
+
+func blank(s string) bool { return strings.TrimSpace(s) == "" }
+
+// blocks renders a run of lines as block elements.
+func (r *renderer) blocks(lines []string) {
+	r.depth++
+	defer func() { r.depth-- }()
+	nest := r.depth <= maxDepth
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case blank(line):
+			i++
+		case fence.MatchString(line):
+			i = r.code(lines, i)
+		case heading.MatchString(line):
+			m := heading.FindStringSubmatch(line)
+			n := string(rune('0' + len(m[1])))
+			r.out.WriteString("<h" + n + ">" + r.inline(m[2]) + "</h" + n + ">\n")
+			i++
+		case rule.MatchString(line):
+			r.out.WriteString("<hr>\n")
+			i++
+		case nest && strings.HasPrefix(strings.TrimLeft(line, " "), ">"):
+			var quote []string
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimLeft(lines[i], " "), ">"); i++ {
+				q := strings.TrimPrefix(strings.TrimLeft(lines[i], " "), ">")
+				quote = append(quote, strings.TrimPrefix(q, " "))
+			}
+			r.out.WriteString("<blockquote>\n")
+			r.blocks(quote)
+			r.out.WriteString("</blockquote>\n")
+		case nest && listItem.MatchString(line):
+			i = r.list(lines, i)
+		case i+1 < len(lines) && strings.Contains(line, "|") && tableRule.MatchString(lines[i+1]) && strings.Contains(lines[i+1], "-"):
+			i = r.table(lines, i)
+		default:
+			i = r.paragraph(lines, i)
+		}
+	}
+}
+
+// startsBlock reports whether a line interrupts a paragraph.
+func startsBlock(line string) bool {
+	return fence.MatchString(line) || heading.MatchString(line) || rule.MatchString(line) ||
+		strings.HasPrefix(strings.TrimLeft(line, " "), ">") || listItem.MatchString(line)
+}
+
+func (r *renderer) paragraph(lines []string, i int) int {
+	var text []string
+	for ; i < len(lines) && !blank(lines[i]) && (len(text) == 0 || r.depth > maxDepth || !startsBlock(lines[i])); i++ {
+		text = append(text, strings.TrimSpace(lines[i]))
+	}
+	r.out.WriteString("<p>" + r.inline(strings.Join(text, "\n")) + "</p>\n")
+	return i
+}
+
+func (r *renderer) code(lines []string, i int) int {
+	m := fence.FindStringSubmatch(lines[i])
+	marker, lang := m[1], m[2]
+	var body []string
+	for i++; i < len(lines); i++ {
+		if strings.HasPrefix(strings.TrimLeft(lines[i], " "), marker) && blank(strings.TrimLeft(strings.TrimLeft(lines[i], " "), marker[:1])) {
+			i++
+			break
+		}
+		body = append(body, lines[i])
+	}
+	r.out.WriteString("<pre><code>")
+	for n, l := range highlight.Lines(highlight.Language("x."+lang), strings.Join(body, "\n")) {
+		if n > 0 {
+			r.out.WriteString("\n")
+		}
+		r.out.WriteString(string(l))
+	}
+	r.out.WriteString("</code></pre>\n")
+	return i
+}
+
+// list renders a list and its items. An item runs on over lines indented past its marker,
+// which is also how lists nest.
+func (r *renderer) list(lines []string, i int) int {
+	first := listItem.FindStringSubmatch(lines[i])
+	tag := "ul"
+	if first[2][0] >= '0' && first[2][0] <= '9' {
+		tag = "ol"
+	}
+	r.out.WriteString("<" + tag + ">\n")
+	for i < len(lines) {
+		m := listItem.FindStringSubmatch(lines[i])
+		if m == nil || (tag == "ol") != (m[2][0] >= '0' && m[2][0] <= '9') {
+			break
+		}
+		indent := len(m[0])
+		item := []string{lines[i][len(m[0]):]}
+		loose := false
+		for i++; i < len(lines); i++ {
+			l := lines[i]
+			if blank(l) {
+				// a blank line continues the item only if more of it follows
+				if i+1 < len(lines) && leading(lines[i+1]) >= indent {
+					item = append(item, "")
+					loose = true
+					continue
+				}
+				break
+			}
+			if leading(l) >= indent {
+				item = append(item, l[indent:])
+				continue
+			}
+			if listItem.MatchString(l) || startsBlock(l) {
+				break
+			}
+			item = append(item, strings.TrimSpace(l)) // a lazy continuation of the text
+		}
+		r.out.WriteString("<li>")
+		if !loose && len(item) > 0 && !hasBlock(item) {
+			r.out.WriteString(r.inline(strings.Join(trimAll(item), "\n")))
+		} else if !loose {
+			// tight item with a nested list or code: its text stays unwrapped
+			n := 0
+			for n < len(item) && !startsBlock(item[n]) {
+				n++
+			}
+			r.out.WriteString(r.inline(strings.Join(trimAll(item[:n]), "\n")) + "\n")
+			r.blocks(item[n:])
+		} else {
+			r.out.WriteString("\n")
+			r.blocks(item)
+		}
+		r.out.WriteString("</li>\n")
+		for i < len(lines) && blank(lines[i]) && i+1 < len(lines) && listItem.MatchString(lines[i+1]) {
+			i++ // blank lines between items
+		}
+	}
+	r.out.WriteString("</" + tag + ">\n")
+	return i
+}
+
+func leading(s string) int { return len(s) - len(strings.TrimLeft(s, " ")) }
+
+func hasBlock(lines []string) bool {
+	for _, l := range lines[1:] {
+		if startsBlock(l) {
+			return true
+		}
+	}
+	return false
+}
+
+func trimAll(lines []string) []string {
+	out := make([]string, len(lines))
+	for i, l := range lines {
+		out[i] = strings.TrimSpace(l)
+	}
+	return out
+}
+
+func (r *renderer) table(lines []string, i int) int {
+	cells := func(line string) []string {
+		line = strings.TrimSpace(line)
+		line = strings.TrimSuffix(strings.TrimPrefix(line, "|"), "|")
+		parts := strings.Split(line, "|")
+		for j := range parts {
+			parts[j] = strings.TrimSpace(parts[j])
+		}
+		return parts
+	}
+	var align []string
+	for _, c := range cells(lines[i+1]) {
+		switch {
+		case strings.HasPrefix(c, ":") && strings.HasSuffix(c, ":"):
+			align = append(align, ` style="text-align:center"`)
+		case strings.HasSuffix(c, ":"):
+			align = append(align, ` style="text-align:right"`)
+		default:
+			align = append(align, "")
+		}
+	}
+	row := func(line, cell string) {
+		r.out.WriteString("<tr>")
+		for j, c := range cells(line) {
+			a := ""
+			if j < len(align) {
+				a = align[j]
+			}
+			r.out.WriteString("<" + cell + a + ">" + r.inline(c) + "</" + cell + ">")
+		}
+		r.out.WriteString("</tr>\n")
+	}
+	r.out.WriteString("<table>\n<thead>\n")
+	row(lines[i], "th")
+	r.out.WriteString("</thead>\n<tbody>\n")
+	for i += 2; i < len(lines) && !blank(lines[i]) && strings.Contains(lines[i], "|"); i++ {
+		row(lines[i], "td")
+	}
+	r.out.WriteString("</tbody>\n</table>\n")
+	return i
+}
+
+var (
+	link     = regexp.MustCompile(`^!?\[((?:[^\[\]\\]|\\.)*)\]\(\s*<?([^\s()<>]*)>?(?:\s+"[^"]*")?\s*\)`)
+	autolink = regexp.MustCompile(`^<((?:https?://|mailto:)[^\s<>]+)>`)
+	scheme   = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*:`)
+)
+
+// inline renders the text of a block: code spans, links, emphasis and escapes.
+func (r *renderer) inline(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		rest := s[i:]
+		switch c := s[i]; {
+		case c == '\\' && i+1 < len(s) && strings.IndexByte("\\`*_{}[]()#+-.!|<>", s[i+1]) >= 0:
+			b.WriteString(html.EscapeString(s[i+1 : i+2]))
+			i += 2
+			continue
+		case c == '`':
+			n := len(rest) - len(strings.TrimLeft(rest, "`"))
+			if end := strings.Index(rest[n:], rest[:n]); end >= 0 {
+				b.WriteString("<code>" + html.EscapeString(strings.TrimSpace(rest[n:n+end])) + "</code>")
+				i += 2*n + end
+				continue
+			}
+			b.WriteString(rest[:n]) // no closing run: the backticks are text
+			i += n
+			continue
+		case c == '[' || (c == '!' && strings.HasPrefix(rest, "![")):
+			if m := link.FindStringSubmatch(rest); m != nil {
+				// images become links too: the preview's CSP wouldn't load them anyway
+				b.WriteString(r.anchor(m[2], r.inline(m[1])))
+				i += len(m[0])
+				continue
+			}
+		case c == '<':
+			if m := autolink.FindStringSubmatch(rest); m != nil {
+				b.WriteString(r.anchor(m[1], html.EscapeString(m[1])))
+				i += len(m[0])
+				continue
+			}
+		case c == '*' || c == '_':
+			if out, n := r.emphasis(s, i); n > 0 {
+				b.WriteString(out)
+				i += n
+				continue
+			}
+		case c == '\n':
+			b.WriteString("\n")
+			i++
+			continue
+		}
+		b.WriteString(html.EscapeString(s[i : i+1]))
+		i++
+	}
+	return b.String()
+}
+
+// maxEmphasis is how far emphasis may reach. Looking further for a closing delimiter makes
+// rendering quadratic in the worst case, and real emphasis is never this long.
+const maxEmphasis = 1000
+
+// emphasis renders *em*, **strong** and their underscore forms starting at s[i], returning
+// the HTML and how many bytes it used, or 0 when the delimiters don't pair up.
+func (r *renderer) emphasis(s string, i int) (string, int) {
+	c := s[i]
+	if c == '_' && i > 0 && isWord(s[i-1]) {
+		return "", 0 // snake_case, not emphasis
+	}
+	for _, d := range []string{strings.Repeat(string(c), 2), string(c)} {
+		if !strings.HasPrefix(s[i:], d) || len(s) <= i+len(d) || s[i+len(d)] == ' ' {
+			continue
+		}
+		start := i + len(d)
+		for j := start + 1; j <= min(len(s)-len(d), start+maxEmphasis); j++ {
+			if s[j:j+len(d)] != d || s[j-1] == ' ' || (len(d) == 1 && j+1 < len(s) && s[j+1] == c) {
+				continue
+			}
+			if c == '_' && j+len(d) < len(s) && isWord(s[j+len(d)]) {
+				continue
+			}
+			tag := "em"
+			if len(d) == 2 {
+				tag = "strong"
+			}
+			return "<" + tag + ">" + r.inline(s[start:j]) + "</" + tag + ">", j + len(d) - i
+		}
+	}
+	return "", 0
+}
+
+func isWord(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// anchor links text to target if the target is safe: web and mail links as they are,
+// fragments within the page, and relative links as the resolver says. Anything else (a
+// javascript: URL, say) leaves just the text.
+func (r *renderer) anchor(target, text string) string {
+	var href string
+	switch lower := strings.ToLower(target); {
+	case strings.HasPrefix(lower, "http://"), strings.HasPrefix(lower, "https://"), strings.HasPrefix(lower, "mailto:"):
+		href = target
+	case strings.HasPrefix(target, "#"):
+		href = target
+	case target != "" && !scheme.MatchString(target) && !strings.HasPrefix(target, "//") && r.resolve != nil:
+		href = r.resolve(target)
+	}
+	if href == "" {
+		return text
+	}
+	return `<a href="` + html.EscapeString(href) + `" rel="nofollow noreferrer">` + text + "</a>"
+}
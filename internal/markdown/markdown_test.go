@@ -0,0 +1,61 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	src := strings.Join([]string{
+		"# Title <b>",
+		"",
+		"Some *em*, **strong**, `a<b` and snake_case_name.",
+		"[site](https://example.com) [bad](javascript:alert) [doc](other.md) <https://x.example>",
+		"",
+		"- one",
+		"- two",
+		"  - nested",
+		"",
+		"1. first",
+		"2. second",
+		"",
+		"> quoted",
+		"",
+		"```go",
+		"func f() {}",
+		"```",
+		"",
+		"| a | b |",
+		"|---|--:|",
+		"| 1 | 2 |",
+		"",
+		"<script>alert(1)</script>",
+	}, "\n")
+	got := string(Render(src, func(target string) string {
+		if target == "other.md" {
+			return "/f/abc"
+		}
+		return ""
+	}))
+	for _, want := range []string{
+		"<h1>Title &lt;b&gt;</h1>",
+		"<em>em</em>", "<strong>strong</strong>", "<code>a&lt;b</code>", "snake_case_name",
+		`<a href="https://example.com" rel="nofollow noreferrer">site</a>`,
+		" bad ",
+		`<a href="/f/abc" rel="nofollow noreferrer">doc</a>`,
+		`<a href="https://x.example" rel="nofollow noreferrer">https://x.example</a>`,
+		"<ul>\n<li>one</li>\n<li>two\n<ul>\n<li>nested</li>\n</ul>\n</li>\n</ul>",
+		"<ol>\n<li>first</li>\n<li>second</li>\n</ol>",
+		"<blockquote>\n<p>quoted</p>\n</blockquote>",
+		`<pre><code><span class="kw">func</span> f() {}</code></pre>`,
+		"<thead>\n<tr><th>a</th><th style=\"text-align:right\">b</th></tr>\n</thead>",
+		"<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "javascript:") {
+		t.Errorf("unsafe link kept:\n%s", got)
+	}
+}
@@ -0,0 +1,34 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func box(kind string, payload int) []byte {
+	b := make([]byte, 8+payload)
+	binary.BigEndian.PutUint32(b, uint32(len(b)))
+	copy(b[4:], kind)
+	return b
+}
+
+func TestFastStart(t *testing.T) {
+	cat := func(boxes ...[]byte) *bytes.Reader { return bytes.NewReader(bytes.Join(boxes, nil)) }
+	for _, tc := range []struct {
+		name string
+		r    *bytes.Reader
+		want bool
+		err  error
+	}{
+		{"faststart", cat(box("ftyp", 16), box("moov", 100), box("mdat", 1000)), true, nil},
+		{"index at the end", cat(box("ftyp", 16), box("free", 4), box("mdat", 1000), box("moov", 100)), false, nil},
+		{"not mp4", bytes.NewReader([]byte("RIFF....WAVEfmt ")), false, ErrNotMP4},
+		{"truncated", cat(box("ftyp", 16), box("free", 4)), false, ErrNotMP4},
+	} {
+		got, err := FastStart(tc.r)
+		if got != tc.want || err != tc.err {
+			t.Errorf("%s: got %v, %v; want %v, %v", tc.name, got, err, tc.want, tc.err)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package metadata
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// dirStore keeps each record in a JSON file of its own, named after its ID, with the file's
+// modification time as when it was written.
+type dirStore struct {
+	dir string
+}
+
+// OpenDir returns a store keeping records in dir, creating it if needed.
+func OpenDir(dir string) (Store, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("create metadata dir: %w", err)
+	}
+	return &dirStore{dir: dir}, nil
+}
+
+func (d *dirStore) path(id string) string { return filepath.Join(d.dir, id+".json") }
+
+// Load reads the record for id, with its file's modification time.
+func (d *dirStore) Load(id string) (*File, time.Time, error) {
+	// IDs come from URLs: keep them from naming a file outside the directory
+	if id == "" || strings.ContainsAny(id, `/\`) || strings.Contains(id, "..") {
+		return nil, time.Time{}, ErrNotFound
+	}
+	fi, err := os.Stat(d.path(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, time.Time{}, ErrNotFound
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	data, err := os.ReadFile(d.path(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, time.Time{}, ErrNotFound
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	var f File
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, time.Time{}, fmt.Errorf("metadata record %s.json: %w", id, err)
+	}
+	return &f, fi.ModTime(), nil
+}
+
+func (d *dirStore) Scan(fn func(f *File, mod time.Time)) error {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		f, mod, err := d.Load(strings.TrimSuffix(e.Name(), ".json"))
+		if err != nil {
+			return err
+		}
+		fn(f, mod)
+	}
+	return nil
+}
+
+func (d *dirStore) Modified() (map[string]time.Time, error) {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]time.Time, len(entries))
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue // deleted since the listing
+		}
+		out[strings.TrimSuffix(e.Name(), ".json")] = fi.ModTime()
+	}
+	return out, nil
+}
+
+func (d *dirStore) Save(f *File) (time.Time, error) {
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return time.Time{}, err
+	}
+	// write-then-rename so a crash can't leave a truncated record behind, and synced so a
+	// record written is one the upload journal can count on
+	tmp := d.path(f.ID) + ".tmp"
+	if err := writeSynced(tmp, data); err != nil {
+		return time.Time{}, err
+	}
+	if err := os.Rename(tmp, d.path(f.ID)); err != nil {
+		return time.Time{}, err
+	}
+	if dir, err := os.Open(d.dir); err == nil {
+		dir.Sync() // where it can be: Windows won't sync a directory
+		dir.Close()
+	}
+	var mod time.Time
+	if fi, err := os.Stat(d.path(f.ID)); err == nil {
+		mod = fi.ModTime()
+	}
+	return mod, nil
+}
+
+func writeSynced(name string, data []byte) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o640)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (d *dirStore) Remove(id string) error {
+	if err := os.Remove(d.path(id)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Vacuum removes temporary files left behind by writes that crashed before their rename.
+func (d *dirStore) Vacuum() (int, error) {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json.tmp") {
+			continue
+		}
+		if err := os.Remove(filepath.Join(d.dir, e.Name())); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+func (d *dirStore) Close() error { return nil }
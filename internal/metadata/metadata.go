@@ -0,0 +1,533 @@
+package metadata
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned when no record exists for an ID.
+var ErrNotFound = errors.New("metadata: file not found")
+
+// File is everything we know about an uploaded file apart from its bytes.
+// The blob itself lives in the storage backend under the same ID.
+type File struct {
+	ID               string     `json:"id"`
+	Name             string     `json:"name"`
+	Size             int64      `json:"size"`
+	ContentType      string     `json:"content_type"`
+	SHA256           string     `json:"sha256,omitempty"`    // hex digest of the content, computed while uploading
+	MD5              string     `json:"md5,omitempty"`       // hex; only when integrity.checksums asks for it or the uploader sent one
+	CRC32C           string     `json:"crc32c,omitempty"`    // hex; likewise
+	CID              string     `json:"cid,omitempty"`       // IPFS content identifier, when the ipfs backend stores it
+	Encrypted        bool       `json:"encrypted,omitempty"` // end-to-end encrypted by the client: Name and content are ciphertext
+	Owner            string     `json:"owner,omitempty"`     // API key (or user) that uploaded it; empty for anonymous uploads
+	CreatedAt        time.Time  `json:"created_at"`
+	Scan             *Scan      `json:"scan,omitempty"`              // virus scan outcome; nil when scanning is off
+	TakenDown        *Takedown  `json:"takedown,omitempty"`          // set when an admin took the file down after an abuse report
+	DLP              []string   `json:"dlp,omitempty"`               // DLP rules with the "tag" action that its content matched
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`        // the lifecycle sweep deletes it after this; nil leaves it to lifecycle.max_age
+	MaxDownloads     int        `json:"max_downloads,omitempty"`     // it expires once downloaded this many times; 0 for no limit
+	Downloads        int        `json:"downloads,omitempty"`         // downloads so far, only counted when MaxDownloads is set
+	Trashed          *time.Time `json:"trashed_at,omitempty"`        // deleted into the trash then; no longer served, and purged lifecycle.trash_for later
+	SlowStart        bool       `json:"slow_start,omitempty"`        // an MP4 with its index at the end: players fetch the end before starting
+	MetadataStripped bool       `json:"metadata_stripped,omitempty"` // EXIF and other image metadata were removed before storing
+	Path             string     `json:"path,omitempty"`              // where it was in the directory tree it was imported from, or the path it was uploaded to, with forward slashes
+	ModTime          *time.Time `json:"mod_time,omitempty"`          // when an imported file was last modified before it was imported
+	Description      string     `json:"description,omitempty"`       // what the uploader says it is, for people and searches
+	Tags             []string   `json:"tags,omitempty"`              // lowercase labels the owner gave it
+	// Blob is the storage key of a deduplicated file's content, its SHA-256, which other
+	// records may share. Files stored without deduplication are kept under their ID.
+	Blob string `json:"blob,omitempty"`
+	// PasswordHash is set for password-protected files: a PBKDF2 hash of the password that
+	// downloads must give. The API never shows it.
+	PasswordHash string `json:"password_hash,omitempty"`
+	// Version is the ID of the upload the content came from, once an upload to the file's
+	// Path has replaced it; until then it's the file's own ID, and left empty. Versions are
+	// the contents replaced, newest first.
+	Version  string    `json:"version,omitempty"`
+	Versions []Version `json:"versions,omitempty"`
+	// Meta is the uploader's own metadata, by lowercase key; it's sent back with downloads,
+	// in X-Goblin-Meta-* headers.
+	Meta map[string]string `json:"meta,omitempty"`
+}
+
+// BlobKey is the key f's content is stored under.
+func (f *File) BlobKey() string {
+	if f.Blob != "" {
+		return f.Blob
+	}
+	return f.ID
+}
+
+// BlobKeys returns the keys of every blob f needs: its content's, then its versions'.
+func (f *File) BlobKeys() []string {
+	keys := []string{f.BlobKey()}
+	for _, v := range f.Versions {
+		keys = append(keys, v.Blob)
+	}
+	return keys
+}
+
+// StoredSize is how many bytes f takes up in storage, its versions' included.
+func (f *File) StoredSize() int64 {
+	n := f.Size
+	for _, v := range f.Versions {
+		n += v.Size
+	}
+	return n
+}
+
+// Version is an earlier content of a file, and what was known about it.
+type Version struct {
+	ID               string     `json:"id"` // the upload that stored it
+	Name             string     `json:"name"`
+	Size             int64      `json:"size"`
+	ContentType      string     `json:"content_type"`
+	SHA256           string     `json:"sha256,omitempty"`
+	MD5              string     `json:"md5,omitempty"`
+	CRC32C           string     `json:"crc32c,omitempty"`
+	CID              string     `json:"cid,omitempty"`
+	Encrypted        bool       `json:"encrypted,omitempty"`
+	Scan             *Scan      `json:"scan,omitempty"`
+	DLP              []string   `json:"dlp,omitempty"`
+	SlowStart        bool       `json:"slow_start,omitempty"`
+	MetadataStripped bool       `json:"metadata_stripped,omitempty"`
+	ModTime          *time.Time `json:"mod_time,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`           // when it was uploaded
+	ReplacedAt       time.Time  `json:"replaced_at,omitzero"` // when a newer one took its place; zero for the current content
+	Blob             string     `json:"blob"`                 // the key it's stored under
+}
+
+// Current returns f's content as a version, replaced at now.
+func (f *File) Current(now time.Time) Version {
+	id := f.Version
+	if id == "" {
+		id = f.ID
+	}
+	return Version{ID: id, Name: f.Name, Size: f.Size, ContentType: f.ContentType, SHA256: f.SHA256, MD5: f.MD5,
+		CRC32C: f.CRC32C, CID: f.CID, Encrypted: f.Encrypted, Scan: f.Scan, DLP: f.DLP, SlowStart: f.SlowStart,
+		MetadataStripped: f.MetadataStripped, ModTime: f.ModTime, CreatedAt: f.CreatedAt, ReplacedAt: now, Blob: f.BlobKey()}
+}
+
+// SetContent makes v f's content. The version it replaces isn't kept; see Replace.
+func (f *File) SetContent(v Version) {
+	f.Version, f.Name, f.Size, f.ContentType, f.SHA256, f.MD5, f.CRC32C, f.CID = v.ID, v.Name, v.Size, v.ContentType, v.SHA256, v.MD5, v.CRC32C, v.CID
+	f.Encrypted, f.Scan, f.DLP, f.SlowStart, f.MetadataStripped = v.Encrypted, v.Scan, v.DLP, v.SlowStart, v.MetadataStripped
+	f.ModTime, f.CreatedAt, f.Blob = v.ModTime, v.CreatedAt, v.Blob
+	if f.Version == f.ID {
+		f.Version = ""
+	}
+	if f.Blob == f.ID {
+		f.Blob = ""
+	}
+}
+
+// Replace makes v f's content, keeping the one it replaces as the newest version.
+func (f *File) Replace(v Version, now time.Time) {
+	f.Versions = append([]Version{f.Current(now)}, f.Versions...)
+	f.SetContent(v)
+}
+
+// FindVersion returns the version of f with the given ID, its current content included.
+func (f *File) FindVersion(id string) (Version, bool) {
+	if cur := f.Current(time.Time{}); id == cur.ID {
+		return cur, true
+	}
+	for _, v := range f.Versions {
+		if v.ID == id {
+			return v, true
+		}
+	}
+	return Version{}, false
+}
+
+// Prune drops the versions of f past the newest keep, or replaced before cutoff unless it's
+// zero, and returns the blob keys of those dropped.
+func (f *File) Prune(keep int, cutoff time.Time) []string {
+	var kept []Version
+	var dropped []string
+	for i, v := range f.Versions {
+		if i >= keep || (!cutoff.IsZero() && v.ReplacedAt.Before(cutoff)) {
+			dropped = append(dropped, v.Blob)
+		} else {
+			kept = append(kept, v)
+		}
+	}
+	f.Versions = kept
+	return dropped
+}
+
+// Expired reports whether f is past its own expiry time or has been downloaded as many times
+// as it may be. It may still be waiting for the lifecycle sweep, but is no longer served.
+func (f *File) Expired(now time.Time) bool {
+	return f.ExpiresAt != nil && !now.Before(*f.ExpiresAt) || f.MaxDownloads > 0 && f.Downloads >= f.MaxDownloads
+}
+
+// Takedown records why a file stopped being served. The blob is kept for review.
+type Takedown struct {
+	At     time.Time `json:"at"`
+	Reason string    `json:"reason"`
+	Report string    `json:"report,omitempty"` // ID of the abuse report that led to it
+	Note   string    `json:"note,omitempty"`
+}
+
+// Scan verdicts.
+const (
+	ScanClean    = "clean"
+	ScanInfected = "infected" // only ever stored for quarantined files; rejected ones are gone
+	ScanFailed   = "failed"   // the scanner errored and fail_open let the file through
+)
+
+// Scan records what the virus scanner said about a file.
+type Scan struct {
+	Verdict   string    `json:"verdict"`
+	Signature string    `json:"signature,omitempty"` // detection name, or the error for ScanFailed
+	ScannedAt time.Time `json:"scanned_at"`
+}
+
+// Quarantined reports whether the file was kept after a detection and must not be served.
+func (f *File) Quarantined() bool { return f.Scan != nil && f.Scan.Verdict == ScanInfected }
+
+// Store is where an Index keeps its records: a directory of JSON files, as Open uses, or a
+// database. It needn't cache anything, the index has them all in memory; and it needn't
+// lock anything but its own writes, which the index serializes for one server. Several
+// servers may share a store, so each record carries the time it was last written, for the
+// index to tell what changed since it last looked.
+type Store interface {
+	// Load returns the record for id, with when it was written, or ErrNotFound.
+	Load(id string) (*File, time.Time, error)
+	// Scan calls fn for every record.
+	Scan(fn func(f *File, mod time.Time)) error
+	// Modified returns when each record was last written, by ID.
+	Modified() (map[string]time.Time, error)
+	// Save creates or replaces f's record, durably, and returns when it was written.
+	Save(f *File) (time.Time, error)
+	// Remove deletes the record for id; one that's already gone is no error.
+	Remove(id string) error
+	// Vacuum cleans up after writes that crashed halfway, and returns how many it found. It
+	// only runs while nothing is writing to the store.
+	Vacuum() (int, error)
+	Close() error
+}
+
+// Index keeps every File record in memory and mirrors each one to its store, so restarts
+// don't lose anything. Several servers may share the store: Get finds records the others
+// wrote, and Refresh catches up with their changes and deletions.
+type Index struct {
+	store Store
+	mu    sync.RWMutex
+	files map[string]*File
+	mod   map[string]time.Time // when each record was last written, as of our last read or write
+	refs  map[string]int       // how many records share each deduplicated blob
+	tried map[string]time.Time // when Get last looked in the store for IDs it didn't have
+}
+
+// Get looks in the store for an ID it doesn't have at most once every missTTL, and for at
+// most maxMisses such IDs in that time, so requests for made-up IDs can't keep the store
+// busy. A record another server wrote meanwhile waits for Refresh.
+const (
+	missTTL   = 5 * time.Second
+	maxMisses = 4096
+)
+
+// Open loads all records found in dir, one JSON file each, creating it if needed. A broken
+// record there only affects one file.
+func Open(dir string) (*Index, error) {
+	st, err := OpenDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	return New(st)
+}
+
+// New loads all records in st, whose schema, if it has one, must be at the latest version.
+func New(st Store) (*Index, error) {
+	if err := CheckSchema(st); err != nil {
+		st.Close()
+		return nil, err
+	}
+	ix := &Index{store: st, files: make(map[string]*File), mod: make(map[string]time.Time), refs: make(map[string]int),
+		tried: make(map[string]time.Time)}
+	err := st.Scan(func(f *File, mod time.Time) { ix.set(f.ID, f, mod) })
+	if err != nil {
+		st.Close()
+		return nil, err
+	}
+	return ix, nil
+}
+
+// Store returns the store the index keeps its records in.
+func (ix *Index) Store() Store { return ix.store }
+
+// Close closes the index's store.
+func (ix *Index) Close() error { return ix.store.Close() }
+
+// set makes f the record for id, counting the blob it refers to; the caller holds mu.
+func (ix *Index) set(id string, f *File, mod time.Time) {
+	ix.forget(id)
+	delete(ix.tried, id)
+	ix.files[id] = f
+	ix.mod[id] = mod
+	for _, key := range f.namedBlobs() {
+		ix.refs[key]++
+	}
+}
+
+// namedBlobs returns the keys of the blobs f refers to by name rather than by its ID, which
+// Refs counts.
+func (f *File) namedBlobs() []string {
+	keys := f.BlobKeys()
+	if f.Blob == "" {
+		keys = keys[1:]
+	}
+	return keys
+}
+
+// forget drops the record for id, if there is one; the caller holds mu.
+func (ix *Index) forget(id string) {
+	if old, ok := ix.files[id]; ok {
+		for _, key := range old.namedBlobs() {
+			if ix.refs[key]--; ix.refs[key] <= 0 {
+				delete(ix.refs, key)
+			}
+		}
+	}
+	delete(ix.files, id)
+	delete(ix.mod, id)
+}
+
+// Put creates or replaces a record.
+func (ix *Index) Put(f *File) error {
+	mod, err := ix.store.Save(f)
+	if err != nil {
+		return err
+	}
+	cp := *f
+	ix.mu.Lock()
+	ix.set(f.ID, &cp, mod)
+	ix.mu.Unlock()
+	return nil
+}
+
+// Get returns a copy of the record so callers can't mutate the index by accident. A record
+// that isn't in memory may be looked for in the store, in case another server sharing it has
+// just written it (see missTTL).
+func (ix *Index) Get(id string) (*File, error) {
+	ix.mu.RLock()
+	f, ok := ix.files[id]
+	ix.mu.RUnlock()
+	if ok {
+		cp := *f
+		return &cp, nil
+	}
+	if !ix.mayLoad(id, time.Now()) {
+		return nil, ErrNotFound
+	}
+	return ix.load(id)
+}
+
+// load reads the record for id from the store, and keeps it.
+func (ix *Index) load(id string) (*File, error) {
+	f, mod, err := ix.store.Load(id)
+	if err != nil {
+		return nil, err
+	}
+	ix.remember(id, f, mod)
+	cp := *f
+	return &cp, nil
+}
+
+// mayLoad reports whether Get may look for id in the store, and notes that it does until
+// the record turns up.
+func (ix *Index) mayLoad(id string, now time.Time) bool {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if at, ok := ix.tried[id]; ok && now.Sub(at) < missTTL {
+		return false
+	}
+	if len(ix.tried) >= maxMisses {
+		for id, at := range ix.tried {
+			if now.Sub(at) >= missTTL {
+				delete(ix.tried, id)
+			}
+		}
+		if len(ix.tried) >= maxMisses {
+			return false
+		}
+	}
+	ix.tried[id] = now
+	return true
+}
+
+// remember stores a record read from the store, unless the index already has a newer one.
+func (ix *Index) remember(id string, f *File, mod time.Time) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if known, ok := ix.mod[id]; !ok || mod.After(known) {
+		ix.set(id, f, mod)
+	}
+}
+
+func (ix *Index) Delete(id string) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if _, ok := ix.files[id]; !ok {
+		return ErrNotFound
+	}
+	if err := ix.store.Remove(id); err != nil {
+		return err
+	}
+	ix.forget(id)
+	return nil
+}
+
+// Reread replaces the record for id with what is in the store, or forgets it if it is gone
+// there, for a change to a record that another server sharing the store may have just made.
+func (ix *Index) Reread(id string) error {
+	f, mod, err := ix.store.Load(id)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if f == nil {
+		ix.forget(id)
+		return nil
+	}
+	ix.set(id, f, mod)
+	return nil
+}
+
+// Refresh catches up with the records other servers sharing the store have written or
+// deleted since we last looked. Only records that changed are read again, and the store is
+// read without holding the index's lock.
+func (ix *Index) Refresh() error {
+	stored, err := ix.store.Modified()
+	if err != nil {
+		return err
+	}
+	for id, at := range stored {
+		ix.mu.RLock()
+		known, ok := ix.mod[id]
+		ix.mu.RUnlock()
+		if ok && !at.After(known) {
+			continue
+		}
+		f, mod, err := ix.store.Load(id)
+		if errors.Is(err, ErrNotFound) {
+			continue // deleted since
+		}
+		if err != nil {
+			return err
+		}
+		ix.remember(id, f, mod)
+	}
+	gone := map[string]time.Time{}
+	ix.mu.RLock()
+	for id := range ix.files {
+		if _, ok := stored[id]; !ok {
+			gone[id] = ix.mod[id]
+		}
+	}
+	ix.mu.RUnlock()
+	for id := range gone {
+		// it may have been written by this server since
+		if _, _, err := ix.store.Load(id); !errors.Is(err, ErrNotFound) {
+			delete(gone, id)
+		}
+	}
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	for id, mod := range gone {
+		if known, ok := ix.mod[id]; ok && known.Equal(mod) { // and not since the Load
+			ix.forget(id)
+		}
+	}
+	return nil
+}
+
+// Refs returns how many records name the blob under key: a deduplicated one they share, or
+// that of a version, or of content an upload to a file's path replaced it with.
+func (ix *Index) Refs(key string) int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return ix.refs[key]
+}
+
+// HasBlob reports whether some record's content, or one of its versions, is stored under
+// key: a blob Refs counts, or a file's own, under its ID.
+func (ix *Index) HasBlob(key string) bool {
+	if ix.Refs(key) > 0 {
+		return true
+	}
+	ix.mu.RLock()
+	f, ok := ix.files[key]
+	ix.mu.RUnlock()
+	if !ok {
+		// the checks deleting blobs nobody has can't take Get's word for it
+		var err error
+		if f, err = ix.load(key); err != nil {
+			return false
+		}
+	}
+	return f.Blob == ""
+}
+
+// List returns copies of all records, newest first.
+func (ix *Index) List() []*File {
+	ix.mu.RLock()
+	out := make([]*File, 0, len(ix.files))
+	for _, f := range ix.files {
+		cp := *f
+		out = append(out, &cp)
+	}
+	ix.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out
+}
+
+// OwnerUsage is how much one owner stores.
+type OwnerUsage struct {
+	Owner string `json:"owner"` // empty for anonymous uploads
+	Files int    `json:"files"`
+	Bytes int64  `json:"bytes"`
+}
+
+// TopOwners adds up files per owner and returns the n owners storing the most bytes, biggest
+// first.
+func TopOwners(files []*File, n int) []OwnerUsage {
+	byOwner := map[string]*OwnerUsage{}
+	for _, f := range files {
+		u := byOwner[f.Owner]
+		if u == nil {
+			u = &OwnerUsage{Owner: f.Owner}
+			byOwner[f.Owner] = u
+		}
+		u.Files++
+		u.Bytes += f.StoredSize()
+	}
+	out := make([]OwnerUsage, 0, len(byOwner))
+	for _, u := range byOwner {
+		out = append(out, *u)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Bytes != out[j].Bytes {
+			return out[i].Bytes > out[j].Bytes
+		}
+		return out[i].Owner < out[j].Owner
+	})
+	if len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+// Vacuum cleans up after writes to the store that crashed halfway, like the temporary files
+// of a directory's. It must only run while nothing is writing to the index.
+func (ix *Index) Vacuum() (int, error) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	return ix.store.Vacuum()
+}
@@ -0,0 +1,304 @@
+package metadata
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/auth"
+)
+
+// stores returns a way to open each kind of store on one place in dir, its schema migrated,
+// so a test can open it twice, like two servers sharing it.
+func stores(t *testing.T) map[string]func() Store {
+	dir := t.TempDir()
+	migrated := func(st Store) Store {
+		if m, ok := st.(Migrator); ok {
+			if err := Migrate(m, Latest(m), nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return st
+	}
+	out := map[string]func() Store{
+		"dir": func() Store {
+			st, err := OpenDir(filepath.Join(dir, "meta"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return st
+		},
+	}
+	pg := newFakePostgres(t, "s3cret")
+	out["postgres"] = func() Store {
+		st, err := OpenPostgres(PostgresOptions{URL: pg.url("s3cret")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return migrated(st)
+	}
+	out["sqlite"] = func() Store {
+		st, err := OpenSQLite(SQLiteOptions{Path: filepath.Join(dir, "meta.db")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return migrated(st)
+	}
+	return out
+}
+
+// TestStores checks that records survive reopening the store, with names SQL or a line-based
+// format could trip over, and that a second index sees the first's writes and deletions.
+func TestStores(t *testing.T) {
+	for name, open := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			a, err := New(open())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer a.Close()
+			b, err := New(open())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer b.Close()
+
+			tricky := "it's\ta \"file\";\nDROP TABLE files; --.txt"
+			for _, f := range []*File{
+				{ID: "one", Name: tricky, Size: 3, CreatedAt: time.Now().UTC()},
+				{ID: "two", Name: "b.txt", Blob: "shared", CreatedAt: time.Now().UTC()},
+				{ID: "three", Name: "c.txt", Blob: "shared", CreatedAt: time.Now().UTC()},
+			} {
+				if err := a.Put(f); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := a.Delete("three"); err != nil {
+				t.Fatal(err)
+			}
+			if err := b.Refresh(); err != nil || len(b.List()) != 2 {
+				t.Fatalf("Refresh found %d records, %v", len(b.List()), err)
+			}
+
+			c, err := New(open())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			if got := len(c.List()); got != 2 {
+				t.Fatalf("reopened with %d records, want 2", got)
+			}
+			if f, err := c.Get("one"); err != nil || f.Name != tricky {
+				t.Fatalf("Get(one) = %+v, %v", f, err)
+			}
+			if c.Refs("shared") != 1 {
+				t.Fatalf("Refs(shared) = %d, want 1", c.Refs("shared"))
+			}
+
+			time.Sleep(10 * time.Millisecond) // so directory records get a later modification time
+			if err := a.Put(&File{ID: "one", Name: "renamed.txt", CreatedAt: time.Now().UTC()}); err != nil {
+				t.Fatal(err)
+			}
+			if err := a.Delete("two"); err != nil {
+				t.Fatal(err)
+			}
+			if err := b.Refresh(); err != nil {
+				t.Fatal(err)
+			}
+			if f, err := b.Get("one"); err != nil || f.Name != "renamed.txt" {
+				t.Fatalf("after Refresh, Get(one) = %+v, %v", f, err)
+			}
+			if _, err := b.Get("two"); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("after Refresh, Get(two) = %v, want ErrNotFound", err)
+			}
+			if b.HasBlob("shared") {
+				t.Fatal("deleted record's blob still counted")
+			}
+		})
+	}
+}
+
+// countingStore counts the records looked for one at a time.
+type countingStore struct {
+	Store
+	loads int
+}
+
+func (s *countingStore) Load(id string) (*File, time.Time, error) {
+	s.loads++
+	return s.Store.Load(id)
+}
+
+// TestGetMissing checks that Get finds a record another server has just written, but doesn't
+// look for the same missing ID again straight away, nor for more than maxMisses of them.
+func TestGetMissing(t *testing.T) {
+	open := stores(t)["dir"]
+	st := &countingStore{Store: open()}
+	ix, err := New(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := New(open())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Put(&File{ID: "new", Name: "new.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	if f, err := ix.Get("new"); err != nil || f.Name != "new.txt" {
+		t.Fatalf("Get(new) = %+v, %v", f, err)
+	}
+	for range 3 {
+		if _, err := ix.Get("missing"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Get(missing) = %v", err)
+		}
+	}
+	if st.loads != 2 {
+		t.Fatalf("%d loads, want 2", st.loads)
+	}
+	for i := range 2 * maxMisses {
+		ix.Get("made-up-" + strconv.Itoa(i))
+	}
+	if st.loads > maxMisses+1 {
+		t.Fatalf("%d loads for %d made-up IDs", st.loads, 2*maxMisses)
+	}
+}
+
+// TestAccounts checks that the databases keep users, quotas and keys for a registry, and
+// that another server's registry sees them.
+func TestAccounts(t *testing.T) {
+	for name, open := range stores(t) {
+		if name == "dir" {
+			continue // auth.json's job
+		}
+		t.Run(name, func(t *testing.T) {
+			st := open()
+			defer st.Close()
+			a, err := auth.New(st.(auth.Backend))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := a.AddUser("ann", 1<<20); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := a.AddUser("bob", 0); err != nil {
+				t.Fatal(err)
+			}
+			if err := a.SetQuota("bob", 5<<20); err != nil {
+				t.Fatal(err)
+			}
+			kept, err := a.CreateKey("ann", "laptop")
+			if err != nil {
+				t.Fatal(err)
+			}
+			revoked, err := a.CreateKey("bob", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := a.RevokeKey(revoked.ID); err != nil {
+				t.Fatal(err)
+			}
+
+			other := open()
+			defer other.Close()
+			b, err := auth.New(other.(auth.Backend))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u, ok := b.Authenticate(kept.Secret); !ok || u.Name != "ann" || u.Quota != 1<<20 {
+				t.Fatalf("Authenticate = %+v, %v", u, ok)
+			}
+			if _, ok := b.Authenticate(revoked.Secret); ok {
+				t.Fatal("revoked key still works")
+			}
+			want, _ := a.User("bob")
+			if u, err := b.User("bob"); err != nil || u.Quota != 5<<20 || !u.CreatedAt.Equal(want.CreatedAt) {
+				t.Fatalf("User(bob) = %+v, %v", u, err)
+			}
+			if keys, err := b.Keys("ann"); err != nil || len(keys) != 1 || keys[0].Label != "laptop" {
+				t.Fatalf("Keys(ann) = %+v, %v", keys, err)
+			}
+			if err := b.RemoveUser("ann"); err != nil {
+				t.Fatal(err)
+			}
+			if err := a.Reload(); err != nil || len(a.Users()) != 1 {
+				t.Fatalf("after another server removed a user: %v, %v", a.Users(), err)
+			}
+		})
+	}
+}
+
+// TestSearch checks that searches match the start of words in names, tags, descriptions and
+// content types, every word of the query, and follow records as they change; and, where
+// the store ranks them, that a match in the name comes first.
+func TestSearch(t *testing.T) {
+	for name, open := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			ix, err := New(open())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ix.Close()
+			for _, f := range []*File{
+				{ID: "report", Name: "Quarterly_Report-2024.pdf", ContentType: "application/pdf"},
+				{ID: "photo", Name: "IMG_0042.jpg", ContentType: "image/jpeg", Tags: []string{"holiday", "beach"}},
+				{ID: "notes", Name: "notes.txt", ContentType: "text/plain", Description: "Minutes of the quarterly meeting"},
+				{ID: "secret", Name: "quarterly-in-ciphertext", Encrypted: true},
+			} {
+				if err := ix.Put(f); err != nil {
+					t.Fatal(err)
+				}
+			}
+			ids := func(query string) []string {
+				t.Helper()
+				found, err := ix.Search(query)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var ids []string
+				for _, f := range found {
+					ids = append(ids, f.ID)
+				}
+				if name == "postgres" { // the fake doesn't rank
+					slices.Sort(ids)
+				}
+				return ids
+			}
+			for query, want := range map[string][]string{
+				"quart":           {"report", "notes"},
+				"2024 pdf":        {"report"},
+				"HOLIDAY":         {"photo"},
+				"image":           {"photo"},
+				"meeting notes":   {"notes"},
+				"quarterly beach": nil,
+				"  ,. ":           nil,
+			} {
+				if name == "postgres" {
+					slices.Sort(want)
+				}
+				if got := ids(query); !slices.Equal(got, want) {
+					t.Errorf("Search(%q) = %v, want %v", query, got, want)
+				}
+			}
+
+			if err := ix.Put(&File{ID: "photo", Name: "IMG_0042.jpg", ContentType: "image/jpeg", Tags: []string{"work"}}); err != nil {
+				t.Fatal(err)
+			}
+			if err := ix.Delete("notes"); err != nil {
+				t.Fatal(err)
+			}
+			if got := ids("holiday"); got != nil {
+				t.Errorf("after retagging, Search(holiday) = %v", got)
+			}
+			if got := ids("work"); !slices.Equal(got, []string{"photo"}) {
+				t.Errorf("after retagging, Search(work) = %v", got)
+			}
+			if got := ids("minutes"); got != nil {
+				t.Errorf("after deleting, Search(minutes) = %v", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrSchemaVersion is returned when a store's schema isn't the one this build uses, and
+// needs migrating first.
+var ErrSchemaVersion = errors.New("metadata: wrong schema version")
+
+// ErrSchemaChanged is returned by a Migrator's Apply when the schema is no longer at the
+// version the migration goes from: another server migrated it in the meantime.
+var ErrSchemaChanged = errors.New("metadata: schema changed while migrating")
+
+// A Migration takes a store's schema from the version before it to Version, and Down takes
+// it back. Each runs its statements in order, in one transaction.
+type Migration struct {
+	Version int      `json:"version"`
+	Name    string   `json:"name"`
+	Up      []string `json:"-"`
+	Down    []string `json:"-"`
+}
+
+// Migrator is implemented by stores with a schema that releases may change: the databases.
+// A schema's version is the number of migrations applied to it.
+type Migrator interface {
+	// Migrations lists the migrations this build knows, the first taking an empty database
+	// to version 1.
+	Migrations() []Migration
+	// Version returns the version the schema is at, 0 for an empty database.
+	Version() (int, error)
+	// Apply runs m up or down and records the schema's new version, all or nothing. It does
+	// nothing and returns ErrSchemaChanged if the schema isn't at the version m goes from.
+	Apply(m Migration, up bool) error
+}
+
+// Latest is the version the newest migration st knows takes its schema to.
+func Latest(st Migrator) int { return len(st.Migrations()) }
+
+// CheckSchema returns an error wrapping ErrSchemaVersion unless st's schema is at the latest
+// version. A store without a schema always passes.
+func CheckSchema(st Store) error {
+	m, ok := st.(Migrator)
+	if !ok {
+		return nil
+	}
+	v, err := m.Version()
+	if err != nil {
+		return err
+	}
+	switch latest := Latest(m); {
+	case v < latest:
+		return fmt.Errorf("%w: the schema is at version %d, not %d yet", ErrSchemaVersion, v, latest)
+	case v > latest:
+		return fmt.Errorf("%w: the schema is at version %d, newer than this build knows (%d)", ErrSchemaVersion, v, latest)
+	}
+	return nil
+}
+
+// Migrate takes st's schema to version to, one migration at a time, up or down, and calls
+// done after each. A store that's also a Coordinator is migrated under its schema lock, so
+// servers starting together take turns; otherwise Apply keeps them from migrating twice.
+func Migrate(st Migrator, to int, done func(m Migration, up bool)) error {
+	ms := st.Migrations()
+	if to < 0 || to > len(ms) {
+		return fmt.Errorf("metadata: no schema version %d, the latest is %d", to, len(ms))
+	}
+	if co, ok := st.(Coordinator); ok {
+		unlock, err := co.Lock("schema", time.Minute)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+	for {
+		v, err := st.Version()
+		if err != nil {
+			return err
+		}
+		if v > len(ms) {
+			return fmt.Errorf("%w: the schema is at version %d, newer than this build knows (%d)", ErrSchemaVersion, v, len(ms))
+		}
+		if v == to {
+			return nil
+		}
+		var m Migration
+		up := v < to
+		if up {
+			m = ms[v]
+		} else {
+			m = ms[v-1]
+		}
+		err = st.Apply(m, up)
+		if errors.Is(err, ErrSchemaChanged) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("metadata: migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		if done != nil {
+			done(m, up)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package metadata
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// TestMigrate checks that a store can't be used until its schema is migrated, that servers
+// migrating together each end up at the latest version, with the records there before, and
+// that migrating down undoes it.
+func TestMigrate(t *testing.T) {
+	pg := newFakePostgres(t, "s3cret")
+	path := filepath.Join(t.TempDir(), "meta.db")
+	opens := map[string]func() (Store, error){
+		"postgres": func() (Store, error) { return OpenPostgres(PostgresOptions{URL: pg.url("s3cret")}) },
+		"sqlite":   func() (Store, error) { return OpenSQLite(SQLiteOptions{Path: path}) },
+	}
+	for name, open := range opens {
+		t.Run(name, func(t *testing.T) {
+			open := func() Migrator {
+				st, err := open()
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { st.Close() })
+				return st.(Migrator)
+			}
+			st := open()
+			if _, err := New(open().(Store)); !errors.Is(err, ErrSchemaVersion) {
+				t.Fatalf("New before migrating: %v, want ErrSchemaVersion", err)
+			}
+
+			// a record from before the search index, which migrating has to index
+			if err := Migrate(st, 1, nil); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := st.(Store).Save(&File{ID: "old", Name: "from-before.txt"}); err != nil {
+				t.Fatal(err)
+			}
+
+			var wg sync.WaitGroup
+			errs := make([]error, 3)
+			for i := range errs {
+				wg.Go(func() { errs[i] = Migrate(open(), Latest(st), nil) })
+			}
+			wg.Wait()
+			for _, err := range errs {
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if v, err := st.Version(); v != Latest(st) || err != nil {
+				t.Fatalf("Version() = %d, %v, want %d", v, err, Latest(st))
+			}
+			ix, err := New(open().(Store))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found, err := ix.Search("before"); err != nil || len(found) != 1 {
+				t.Fatalf("Search found %d records from before migrating, %v", len(found), err)
+			}
+
+			var undone []int
+			err = Migrate(st, 0, func(m Migration, up bool) {
+				if up {
+					t.Errorf("migration %d applied going down", m.Version)
+				}
+				undone = append(undone, m.Version)
+			})
+			if err != nil || len(undone) != Latest(st) || undone[0] != Latest(st) {
+				t.Fatalf("migrating down undid %v, %v", undone, err)
+			}
+			if v, err := st.Version(); v != 0 || err != nil {
+				t.Fatalf("Version() = %d, %v after migrating down, want 0", v, err)
+			}
+			if err := Migrate(st, Latest(st)+1, nil); err == nil {
+				t.Fatal("migrated to a version that doesn't exist")
+			}
+			if err := Migrate(st, Latest(st), nil); err != nil {
+				t.Fatal(err)
+			}
+			if ix, err := New(open().(Store)); err != nil || len(ix.List()) != 0 {
+				t.Fatalf("migrated up again: %v records, %v", len(ix.List()), err)
+			}
+		})
+	}
+}
@@ -0,0 +1,610 @@
+package metadata
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/auth"
+)
+
+// ErrBusy is returned by a Coordinator's Lock when another server keeps the lock too long.
+var ErrBusy = errors.New("metadata: locked by another server")
+
+// Coordinator is implemented by stores that the servers sharing them can coordinate through,
+// instead of through lock files in a shared directory.
+type Coordinator interface {
+	// Lock takes the lock called name, waiting up to wait while another server holds it, and
+	// returns the function that releases it. A server that dies holding it releases it.
+	Lock(name string, wait time.Duration) (func(), error)
+	// Lead takes the leadership of the servers if nobody has it, and reports whether this
+	// server has it; called again, it checks that it still does.
+	Lead() (bool, error)
+	// Resign gives the leadership up, if this server has it.
+	Resign() error
+	// Spend records key as spent until exp, and reports whether it wasn't already: for
+	// one-time tokens, like solved challenges, that any of the servers may be shown.
+	Spend(key string, exp time.Time) (bool, error)
+}
+
+// PostgresOptions says which PostgreSQL database a store keeps its records in.
+type PostgresOptions struct {
+	URL      string // postgres://user@host:5432/database?sslmode=require
+	Password string // instead of one in the URL
+	MaxConns int    // connections open at once for queries, and as many again for locks; 10 when 0
+	Timeout  time.Duration
+}
+
+// pgStore keeps records in a PostgreSQL table, as JSON documents with the time each was
+// written, by the database's clock so that the servers sharing it agree. It coordinates
+// them with advisory locks, which the database releases when a server's connection drops.
+type pgStore struct {
+	cfg     pgConfig
+	queries *pgPool
+	locks   *pgPool // kept apart, so locks held can't take every connection the queries need
+
+	mu     sync.Mutex
+	leader *pgConn // the session holding the leader's lock
+}
+
+// The statements the store runs.
+const (
+	pgLoad     = `SELECT modified, record FROM filegoblin_files WHERE id = $1`
+	pgScan     = `SELECT modified, record FROM filegoblin_files`
+	pgModified = `SELECT id, modified FROM filegoblin_files`
+	pgSave     = `INSERT INTO filegoblin_files (id, modified, record)
+VALUES ($1, (extract(epoch FROM clock_timestamp()) * 1000000)::bigint * 1000, $2)
+ON CONFLICT (id) DO UPDATE SET modified = excluded.modified, record = excluded.record
+RETURNING modified`
+	pgRemove  = `DELETE FROM filegoblin_files WHERE id = $1`
+	pgTryLock = `SELECT pg_try_advisory_lock($1)`
+	pgUnlock  = `SELECT pg_advisory_unlock($1)`
+	pgPing    = `SELECT 1`
+
+	pgPruneSpent = `DELETE FROM filegoblin_spent WHERE expires < $1`
+	pgSpend      = `INSERT INTO filegoblin_spent (key, expires) VALUES ($1, $2) ON CONFLICT (key) DO NOTHING RETURNING key`
+
+	pgMigrationsTable = `CREATE TABLE IF NOT EXISTS filegoblin_migrations (
+	version integer PRIMARY KEY,
+	name    text NOT NULL,
+	applied timestamptz NOT NULL DEFAULT now()
+)`
+	pgVersion = `SELECT coalesce(max(version), 0) FROM filegoblin_migrations`
+	pgRecord  = `INSERT INTO filegoblin_migrations (version, name) VALUES ($1, $2)`
+	pgForget  = `DELETE FROM filegoblin_migrations WHERE version = $1`
+
+	pgSearch = `SELECT id FROM filegoblin_files, to_tsquery('simple', $1) query
+WHERE search @@ query ORDER BY ts_rank(search, query, 1) DESC, modified DESC`
+
+	pgLoadUsers  = `SELECT name, quota, created FROM filegoblin_users ORDER BY name`
+	pgLoadKeys   = `SELECT id, username, label, hash, created, revoked FROM filegoblin_api_keys ORDER BY created`
+	pgClearKeys  = `DELETE FROM filegoblin_api_keys`
+	pgClearUsers = `DELETE FROM filegoblin_users`
+	pgSaveUser   = `INSERT INTO filegoblin_users (name, quota, created) VALUES ($1, $2, $3)`
+	pgSaveKey    = `INSERT INTO filegoblin_api_keys (id, username, label, hash, created, revoked)
+VALUES ($1, $2, $3, $4, $5, nullif($6, '')::bigint)`
+)
+
+// pgMigrations are the PostgreSQL store's schema changes, oldest first. Databases made before
+// there were migrations have the files table but no version; the first adopts them.
+var pgMigrations = []Migration{
+	{Version: 1, Name: "files",
+		Up: []string{`CREATE TABLE IF NOT EXISTS filegoblin_files (
+	id       text PRIMARY KEY,
+	modified bigint NOT NULL,
+	record   text NOT NULL
+)`},
+		Down: []string{`DROP TABLE filegoblin_files`}},
+	// a full-text index of what searches match, on a column the database keeps up to date;
+	// text is split into words at anything but letters and digits, like searchTerms does
+	{Version: 2, Name: "search",
+		Up: []string{`ALTER TABLE filegoblin_files ADD COLUMN search tsvector GENERATED ALWAYS AS (
+	setweight(` + pgSearchText(`CASE WHEN record::jsonb @> '{"encrypted": true}' THEN NULL ELSE record::jsonb ->> 'name' END`) + `, 'A') ||
+	setweight(` + pgSearchText(`record::jsonb ->> 'tags'`) + `, 'B') ||
+	setweight(` + pgSearchText(`record::jsonb ->> 'description'`) + `, 'C') ||
+	setweight(` + pgSearchText(`record::jsonb ->> 'content_type'`) + `, 'D')
+) STORED`,
+			`CREATE INDEX filegoblin_files_search ON filegoblin_files USING gin (search)`},
+		Down: []string{`ALTER TABLE filegoblin_files DROP COLUMN search`}}, // the index goes with it
+	// the users, their quotas and their API keys, which were in data_dir/auth.json before
+	{Version: 3, Name: "accounts",
+		Up: []string{`CREATE TABLE filegoblin_users (
+	name    text PRIMARY KEY,
+	quota   bigint NOT NULL, -- bytes; 0 for no limit
+	created bigint NOT NULL  -- Unix nanoseconds
+)`,
+			`CREATE TABLE filegoblin_api_keys (
+	id       text PRIMARY KEY,
+	username text NOT NULL, -- kept, revoked, when the user is removed
+	label    text NOT NULL,
+	hash     text NOT NULL UNIQUE,
+	created  bigint NOT NULL,
+	revoked  bigint -- Unix nanoseconds; NULL while the key works
+)`},
+		Down: []string{`DROP TABLE filegoblin_api_keys`, `DROP TABLE filegoblin_users`}},
+	// one-time tokens spent with any of the servers, so another won't take them again
+	{Version: 4, Name: "spent",
+		Up: []string{`CREATE TABLE filegoblin_spent (
+	key     text PRIMARY KEY,
+	expires bigint NOT NULL -- Unix nanoseconds; the row can go after
+)`},
+		Down: []string{`DROP TABLE filegoblin_spent`}},
+}
+
+// pgSearchText is the words of the text expr for the search column.
+func pgSearchText(expr string) string {
+	return `to_tsvector('simple', regexp_replace(coalesce(` + expr + `, ''), '[^[:alnum:]]+', ' ', 'g'))`
+}
+
+// pgMigrateTimeout bounds each statement of a migration, which may have a large table to
+// rewrite.
+const pgMigrateTimeout = 10 * time.Minute
+
+// OpenPostgres connects to the database opt.URL names and returns a store keeping records
+// there. Its schema is left to Migrate.
+func OpenPostgres(opt PostgresOptions) (Store, error) {
+	cfg, err := parsePostgresURL(opt.URL, opt.Password)
+	if err != nil {
+		return nil, err
+	}
+	if opt.Timeout > 0 {
+		cfg.timeout = opt.Timeout
+	}
+	if opt.MaxConns <= 0 {
+		opt.MaxConns = 10
+	}
+	s := &pgStore{cfg: cfg, queries: newPgPool(cfg, opt.MaxConns), locks: newPgPool(cfg, opt.MaxConns)}
+	if _, err := s.query(pgPing); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// query runs sql on a pooled connection. A connection that was idle may have been closed by
+// the server, or something in between, in the meantime; the statements are all safe to
+// repeat, so one that fails that way is tried again on a new connection.
+func (s *pgStore) query(sql string, args ...string) ([][]string, error) {
+	for attempt := 0; ; attempt++ {
+		c, reused, err := s.queries.get(s.cfg.timeout)
+		if err != nil {
+			return nil, err
+		}
+		rows, err := c.query(s.cfg.timeout, sql, args...)
+		s.queries.put(c)
+		if err != nil && c.broken && reused && attempt == 0 {
+			continue
+		}
+		return rows, err
+	}
+}
+
+func parsePgRow(row []string) (*File, time.Time, error) {
+	if len(row) != 2 {
+		return nil, time.Time{}, fmt.Errorf("postgres: unexpected row of %d columns", len(row))
+	}
+	ns, err := strconv.ParseInt(row[0], 10, 64)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("postgres: bad modified time %q", row[0])
+	}
+	var f File
+	if err := json.Unmarshal([]byte(row[1]), &f); err != nil {
+		return nil, time.Time{}, fmt.Errorf("postgres: metadata record: %w", err)
+	}
+	return &f, time.Unix(0, ns), nil
+}
+
+func (s *pgStore) Load(id string) (*File, time.Time, error) {
+	rows, err := s.query(pgLoad, id)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if len(rows) == 0 {
+		return nil, time.Time{}, ErrNotFound
+	}
+	return parsePgRow(rows[0])
+}
+
+func (s *pgStore) Scan(fn func(f *File, mod time.Time)) error {
+	rows, err := s.query(pgScan)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		f, mod, err := parsePgRow(row)
+		if err != nil {
+			return err
+		}
+		fn(f, mod)
+	}
+	return nil
+}
+
+func (s *pgStore) Modified() (map[string]time.Time, error) {
+	rows, err := s.query(pgModified)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]time.Time, len(rows))
+	for _, row := range rows {
+		if len(row) != 2 {
+			return nil, fmt.Errorf("postgres: unexpected row of %d columns", len(row))
+		}
+		ns, err := strconv.ParseInt(row[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("postgres: bad modified time %q", row[1])
+		}
+		out[row[0]] = time.Unix(0, ns)
+	}
+	return out, nil
+}
+
+func (s *pgStore) Save(f *File) (time.Time, error) {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return time.Time{}, err
+	}
+	rows, err := s.query(pgSave, f.ID, string(data))
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(rows) != 1 || len(rows[0]) != 1 {
+		return time.Time{}, errors.New("postgres: no modified time for the saved record")
+	}
+	ns, err := strconv.ParseInt(rows[0][0], 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("postgres: bad modified time %q", rows[0][0])
+	}
+	return time.Unix(0, ns), nil
+}
+
+func (s *pgStore) Remove(id string) error {
+	_, err := s.query(pgRemove, id)
+	return err
+}
+
+// Vacuum has nothing to do: the database rolls back a write that didn't finish.
+func (s *pgStore) Vacuum() (int, error) { return 0, nil }
+
+func (s *pgStore) Close() error {
+	s.Resign()
+	s.queries.close()
+	s.locks.close()
+	return nil
+}
+
+func (s *pgStore) Migrations() []Migration { return pgMigrations }
+
+// Search asks the full-text index for records with words starting with each term, ranked
+// by how often they come up, by the weight of the field they're in.
+func (s *pgStore) Search(terms []string) ([]string, error) {
+	var query []string
+	for _, t := range terms {
+		query = append(query, "'"+t+"':*") // terms are letters and digits only
+	}
+	rows, err := s.query(pgSearch, strings.Join(query, " & "))
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(rows))
+	for i, row := range rows {
+		ids[i] = row[0]
+	}
+	return ids, nil
+}
+
+func (s *pgStore) Version() (int, error) {
+	rows, err := s.query(pgVersion)
+	var pe *PgError
+	if errors.As(err, &pe) && pe.Code == "42P01" { // undefined_table: never migrated
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(rows) != 1 || len(rows[0]) != 1 {
+		return 0, errors.New("postgres: no schema version")
+	}
+	return strconv.Atoi(rows[0][0])
+}
+
+// Apply runs the migration in a transaction on a connection of its own. Migrate holds the
+// schema lock meanwhile, so the version can't change between the check and the commit.
+func (s *pgStore) Apply(m Migration, up bool) (err error) {
+	c, _, err := s.queries.get(s.cfg.timeout)
+	if err != nil {
+		return err
+	}
+	defer s.queries.put(c)
+	if _, err := c.query(pgMigrateTimeout, pgMigrationsTable); err != nil {
+		return err
+	}
+	if _, err := c.query(s.cfg.timeout, "BEGIN"); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if _, rerr := c.query(s.cfg.timeout, "ROLLBACK"); rerr != nil {
+				c.broken = true // the transaction ends with the session
+			}
+		}
+	}()
+	from, stmts, record, args := m.Version-1, m.Up, pgRecord, []string{strconv.Itoa(m.Version), m.Name}
+	if !up {
+		from, stmts, record, args = m.Version, m.Down, pgForget, args[:1]
+	}
+	rows, err := c.query(s.cfg.timeout, pgVersion)
+	if err != nil {
+		return err
+	}
+	if len(rows) != 1 || len(rows[0]) != 1 || rows[0][0] != strconv.Itoa(from) {
+		return ErrSchemaChanged
+	}
+	for _, stmt := range stmts {
+		if _, err := c.query(pgMigrateTimeout, stmt); err != nil {
+			return err
+		}
+	}
+	if _, err := c.query(s.cfg.timeout, record, args...); err != nil {
+		return err
+	}
+	_, err = c.query(s.cfg.timeout, "COMMIT")
+	return err
+}
+
+func (s *pgStore) LoadAccounts() ([]*auth.User, []*auth.Key, error) {
+	rows, err := s.query(pgLoadUsers)
+	if err != nil {
+		return nil, nil, err
+	}
+	var users []*auth.User
+	for _, row := range rows {
+		if len(row) != 3 {
+			return nil, nil, fmt.Errorf("postgres: unexpected row of %d columns", len(row))
+		}
+		u := &auth.User{Name: row[0]}
+		quota, err1 := strconv.ParseInt(row[1], 10, 64)
+		created, err2 := strconv.ParseInt(row[2], 10, 64)
+		if err := errors.Join(err1, err2); err != nil {
+			return nil, nil, fmt.Errorf("postgres: user %s: %w", u.Name, err)
+		}
+		u.Quota, u.CreatedAt = quota, time.Unix(0, created).UTC()
+		users = append(users, u)
+	}
+	if rows, err = s.query(pgLoadKeys); err != nil {
+		return nil, nil, err
+	}
+	var keys []*auth.Key
+	for _, row := range rows {
+		if len(row) != 6 {
+			return nil, nil, fmt.Errorf("postgres: unexpected row of %d columns", len(row))
+		}
+		k := &auth.Key{ID: row[0], User: row[1], Label: row[2], Hash: row[3]}
+		created, err := strconv.ParseInt(row[4], 10, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("postgres: key %s: %w", k.ID, err)
+		}
+		k.CreatedAt = time.Unix(0, created).UTC()
+		if row[5] != "" {
+			revoked, err := strconv.ParseInt(row[5], 10, 64)
+			if err != nil {
+				return nil, nil, fmt.Errorf("postgres: key %s: %w", k.ID, err)
+			}
+			at := time.Unix(0, revoked).UTC()
+			k.RevokedAt = &at
+		}
+		keys = append(keys, k)
+	}
+	return users, keys, nil
+}
+
+// SaveAccounts replaces the users and keys in one transaction.
+func (s *pgStore) SaveAccounts(users []*auth.User, keys []*auth.Key) error {
+	return s.inTx(func(c *pgConn) error {
+		for _, sql := range []string{pgClearKeys, pgClearUsers} {
+			if _, err := c.query(s.cfg.timeout, sql); err != nil {
+				return err
+			}
+		}
+		for _, u := range users {
+			_, err := c.query(s.cfg.timeout, pgSaveUser, u.Name, strconv.FormatInt(u.Quota, 10), strconv.FormatInt(u.CreatedAt.UnixNano(), 10))
+			if err != nil {
+				return err
+			}
+		}
+		for _, k := range keys {
+			revoked := ""
+			if k.RevokedAt != nil {
+				revoked = strconv.FormatInt(k.RevokedAt.UnixNano(), 10)
+			}
+			_, err := c.query(s.cfg.timeout, pgSaveKey, k.ID, k.User, k.Label, k.Hash, strconv.FormatInt(k.CreatedAt.UnixNano(), 10), revoked)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// inTx runs fn in a transaction on a connection of its own, and commits it unless fn fails.
+func (s *pgStore) inTx(fn func(c *pgConn) error) (err error) {
+	c, _, err := s.queries.get(s.cfg.timeout)
+	if err != nil {
+		return err
+	}
+	defer s.queries.put(c)
+	if _, err := c.query(s.cfg.timeout, "BEGIN"); err != nil {
+		return err
+	}
+	if err = fn(c); err == nil {
+		_, err = c.query(s.cfg.timeout, "COMMIT")
+	}
+	if err != nil {
+		if _, rerr := c.query(s.cfg.timeout, "ROLLBACK"); rerr != nil {
+			c.broken = true // the transaction ends with the session
+		}
+	}
+	return err
+}
+
+// lockKey is the advisory lock for name: locks are numbers to PostgreSQL.
+func lockKey(name string) string {
+	h := fnv.New64a()
+	h.Write([]byte("filegoblin:" + name))
+	return strconv.FormatInt(int64(h.Sum64()), 10)
+}
+
+// Lock holds the lock on a connection of its own until it's released: an advisory lock
+// belongs to the session that took it, and each session may take it again.
+func (s *pgStore) Lock(name string, wait time.Duration) (func(), error) {
+	deadline := time.Now().Add(wait)
+	c, _, err := s.locks.get(wait)
+	if errors.Is(err, errPoolBusy) {
+		return nil, ErrBusy
+	}
+	if err != nil {
+		return nil, err
+	}
+	key := lockKey(name)
+	for {
+		rows, err := c.query(s.cfg.timeout, pgTryLock, key)
+		if err != nil {
+			s.locks.put(c)
+			return nil, err
+		}
+		if len(rows) == 1 && len(rows[0]) == 1 && rows[0][0] == "t" {
+			break
+		}
+		if time.Now().After(deadline) {
+			s.locks.put(c)
+			return nil, ErrBusy
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return func() {
+		if _, err := c.query(s.cfg.timeout, pgUnlock, key); err != nil {
+			c.broken = true // closing the session releases the lock just the same
+		}
+		s.locks.put(c)
+	}, nil
+}
+
+// Lead holds the leader's lock on a connection outside the pools, for as long as this server
+// leads; if that connection is lost, so is the lock, and with it the leadership.
+func (s *pgStore) Lead() (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.leader != nil {
+		if _, err := s.leader.query(s.cfg.timeout, pgPing); err == nil {
+			return true, nil
+		}
+		s.leader.nc.Close()
+		s.leader = nil
+	}
+	c, err := pgConnect(context.Background(), s.cfg)
+	if err != nil {
+		return false, err
+	}
+	rows, err := c.query(s.cfg.timeout, pgTryLock, lockKey("leader"))
+	if err != nil || len(rows) != 1 || len(rows[0]) != 1 || rows[0][0] != "t" {
+		c.close()
+		return false, err
+	}
+	s.leader = c
+	return true, nil
+}
+
+// Spend inserts key unless it's there already, clearing out the keys that expired first. A
+// try repeated after a lost connection may find its own first one and report key spent;
+// that only costs the client another puzzle.
+func (s *pgStore) Spend(key string, exp time.Time) (bool, error) {
+	if _, err := s.query(pgPruneSpent, strconv.FormatInt(time.Now().UnixNano(), 10)); err != nil {
+		return false, err
+	}
+	rows, err := s.query(pgSpend, key, strconv.FormatInt(exp.UnixNano(), 10))
+	if err != nil {
+		return false, err
+	}
+	return len(rows) == 1, nil
+}
+
+func (s *pgStore) Resign() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.leader == nil {
+		return nil
+	}
+	_, err := s.leader.query(s.cfg.timeout, pgUnlock, lockKey("leader"))
+	s.leader.close()
+	s.leader = nil
+	return err
+}
+
+// errPoolBusy is returned by pgPool.get when no connection is free in time.
+var errPoolBusy = errors.New("postgres: all connections are in use")
+
+// pgPool keeps up to max connections open, and those not in use for the next query.
+type pgPool struct {
+	cfg    pgConfig
+	slots  chan struct{} // one for each connection open and in use
+	idle   chan *pgConn
+	closed atomic.Bool
+}
+
+func newPgPool(cfg pgConfig, max int) *pgPool {
+	return &pgPool{cfg: cfg, slots: make(chan struct{}, max), idle: make(chan *pgConn, max)}
+}
+
+// get returns an idle connection, reused, or a new one, waiting up to wait for one to be free.
+func (p *pgPool) get(wait time.Duration) (c *pgConn, reused bool, err error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-time.After(wait):
+		return nil, false, errPoolBusy
+	}
+	select {
+	case c := <-p.idle:
+		return c, true, nil
+	default:
+	}
+	if c, err = pgConnect(context.Background(), p.cfg); err != nil {
+		<-p.slots
+		return nil, false, err
+	}
+	return c, false, nil
+}
+
+func (p *pgPool) put(c *pgConn) {
+	if c.broken {
+		c.nc.Close()
+	} else if p.closed.Load() {
+		c.close()
+	} else {
+		select {
+		case p.idle <- c:
+		default:
+			c.close()
+		}
+	}
+	<-p.slots
+}
+
+// close closes the idle connections; those in use are closed as they're put back.
+func (p *pgPool) close() {
+	p.closed.Store(true)
+	for {
+		select {
+		case c := <-p.idle:
+			c.close()
+		default:
+			return
+		}
+	}
+}
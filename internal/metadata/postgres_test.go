@@ -0,0 +1,544 @@
+package metadata
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakePostgres speaks enough of PostgreSQL's protocol, and understands the store's
+// statements, for its tests: SCRAM-SHA-256 logins, a table of records, the users and keys,
+// the migrations applied, and advisory locks that go with the session holding them.
+// Transactions are taken on trust.
+type fakePostgres struct {
+	ln       net.Listener
+	password string
+
+	mu       sync.Mutex
+	files    bool                 // whether the table of records is there
+	versions map[int]string       // the migrations applied, by version; nil without their table
+	rows     map[string][2]string // id: modified, record
+	locks    map[string]net.Conn  // key: the session holding it
+	conns    []net.Conn
+	last     int64
+	users    [][]string // the accounts tables' rows, as saved
+	keys     [][]string
+	method   uint32           // the authentication it asks for: 3 for cleartext, 5 for MD5, SCRAM when 0
+	unsigned bool             // whether it skips the SCRAM signature, as a server that doesn't know the password would
+	spent    map[string]int64 // key: expires, in Unix nanoseconds
+}
+
+func newFakePostgres(t *testing.T, password string) *fakePostgres {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("no loopback TCP: %v", err)
+	}
+	f := &fakePostgres{ln: ln, password: password, rows: map[string][2]string{}, locks: map[string]net.Conn{}}
+	t.Cleanup(func() { ln.Close(); f.drop() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			f.mu.Lock()
+			f.conns = append(f.conns, conn)
+			f.mu.Unlock()
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakePostgres) url(password string) string {
+	return "postgres://goblin:" + password + "@" + f.ln.Addr().String() + "/files"
+}
+
+// drop hangs up on every connection, like a database restarting.
+func (f *fakePostgres) drop() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, c := range f.conns {
+		c.Close()
+	}
+	f.conns = nil
+}
+
+func fakeSend(w io.Writer, typ byte, body []byte) {
+	msg := binary.BigEndian.AppendUint32([]byte{typ}, uint32(len(body)+4))
+	w.Write(append(msg, body...))
+}
+
+func fakeAuth(w io.Writer, code uint32, data string) {
+	fakeSend(w, 'R', append(binary.BigEndian.AppendUint32(nil, code), data...))
+}
+
+func fakeError(w io.Writer, code, msg string) {
+	fakeSend(w, 'E', []byte("SERROR\x00C"+code+"\x00M"+msg+"\x00\x00"))
+}
+
+func (f *fakePostgres) serve(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		f.mu.Lock()
+		for key, holder := range f.locks {
+			if holder == conn {
+				delete(f.locks, key)
+			}
+		}
+		f.mu.Unlock()
+	}()
+	r := bufio.NewReader(conn)
+	readStartup := func() []byte {
+		var n [4]byte
+		if _, err := io.ReadFull(r, n[:]); err != nil {
+			return nil
+		}
+		b := make([]byte, binary.BigEndian.Uint32(n[:])-4)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil
+		}
+		return b
+	}
+	read := func() (byte, []byte) {
+		var hdr [5]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return 0, nil
+		}
+		b := make([]byte, binary.BigEndian.Uint32(hdr[1:])-4)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return 0, nil
+		}
+		return hdr[0], b
+	}
+
+	startup := readStartup()
+	if binary.BigEndian.Uint32(startup) == 80877103 {
+		conn.Write([]byte{'N'})
+		startup = readStartup()
+	}
+	if startup == nil || !f.login(conn, read) {
+		return
+	}
+	fakeAuth(conn, 0, "")
+	fakeSend(conn, 'Z', []byte{'I'})
+
+	var sql string
+	var args []string
+	for {
+		typ, msg := read()
+		switch typ {
+		case 'P':
+			sql = string(msg[1 : bytes.IndexByte(msg[1:], 0)+1])
+			fakeSend(conn, '1', nil)
+		case 'B':
+			b := msg[4:] // the unnamed portal and statement, no format codes
+			n := int(binary.BigEndian.Uint16(b))
+			b, args = b[2:], nil
+			for range n {
+				size := binary.BigEndian.Uint32(b)
+				args, b = append(args, string(b[4:4+size])), b[4+size:]
+			}
+			fakeSend(conn, '2', nil)
+		case 'E':
+			rows, err := f.run(conn, sql, args)
+			if err != nil {
+				code := "42000"
+				if pe, ok := err.(*PgError); ok {
+					code, err = pe.Code, errors.New(pe.Message)
+				}
+				fakeError(conn, code, err.Error())
+				continue
+			}
+			for _, row := range rows {
+				body := binary.BigEndian.AppendUint16(nil, uint16(len(row)))
+				for _, col := range row {
+					body = append(binary.BigEndian.AppendUint32(body, uint32(len(col))), col...)
+				}
+				fakeSend(conn, 'D', body)
+			}
+			fakeSend(conn, 'C', []byte("OK\x00"))
+		case 'S':
+			fakeSend(conn, 'Z', []byte{'I'})
+		default: // Terminate, or the connection is gone
+			return
+		}
+	}
+}
+
+// login asks for the password the way f.method says, and reports whether the client knew it.
+func (f *fakePostgres) login(conn net.Conn, read func() (byte, []byte)) bool {
+	f.mu.Lock()
+	method := f.method
+	f.mu.Unlock()
+	var want string
+	switch method {
+	case 0:
+		return f.scram(conn, read)
+	case 3:
+		fakeAuth(conn, 3, "")
+		want = f.password
+	case 5:
+		fakeAuth(conn, 5, "salt")
+		inner := md5.Sum([]byte(f.password + "goblin"))
+		outer := md5.Sum([]byte(hex.EncodeToString(inner[:]) + "salt"))
+		want = "md5" + hex.EncodeToString(outer[:])
+	}
+	typ, msg := read()
+	if typ != 'p' || string(msg) != want+"\x00" {
+		fakeError(conn, "28P01", `password authentication failed for user "goblin"`)
+		return false
+	}
+	return true
+}
+
+// scram runs the server's side of a SCRAM-SHA-256 login, and reports whether the client
+// knew the password.
+func (f *fakePostgres) scram(conn net.Conn, read func() (byte, []byte)) bool {
+	fakeAuth(conn, 10, "SCRAM-SHA-256\x00\x00")
+	_, msg := read()
+	mech, rest, _ := bytes.Cut(msg, []byte{0})
+	if string(mech) != "SCRAM-SHA-256" || len(rest) < 4 {
+		return false
+	}
+	clientFirstBare := strings.TrimPrefix(string(rest[4:]), "n,,")
+	nonce := strings.TrimPrefix(clientFirstBare, "n=,r=") + "server"
+	salt := []byte("pepper")
+	serverFirst := "r=" + nonce + ",s=" + base64.StdEncoding.EncodeToString(salt) + ",i=4096"
+	fakeAuth(conn, 11, serverFirst)
+	_, msg = read()
+	withoutProof, proof, _ := strings.Cut(string(msg), ",p=")
+	salted, _ := pbkdf2.Key(sha256.New, f.password, salt, 4096, sha256.Size)
+	authMessage := clientFirstBare + "," + serverFirst + "," + withoutProof
+	stored := sha256.Sum256(hmacSHA256(salted, "Client Key"))
+	clientKey, _ := base64.StdEncoding.DecodeString(proof)
+	for i, b := range hmacSHA256(stored[:], authMessage) {
+		if i < len(clientKey) {
+			clientKey[i] ^= b
+		}
+	}
+	if got := sha256.Sum256(clientKey); !hmac.Equal(got[:], stored[:]) || withoutProof != "c=biws,r="+nonce {
+		fakeError(conn, "28P01", `password authentication failed for user "goblin"`)
+		return false
+	}
+	f.mu.Lock()
+	unsigned := f.unsigned
+	f.mu.Unlock()
+	if unsigned {
+		return true
+	}
+	fakeAuth(conn, 12, "v="+base64.StdEncoding.EncodeToString(hmacSHA256(hmacSHA256(salted, "Server Key"), authMessage)))
+	return true
+}
+
+// run carries out one of the store's statements.
+func (f *fakePostgres) run(conn net.Conn, sql string, args []string) ([][]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	undefined := func(table string) error {
+		return &PgError{Code: "42P01", Message: `relation "` + table + `" does not exist`}
+	}
+	for _, m := range pgMigrations[1:] { // the search column isn't kept: searches go through every record
+		if slices.Contains(m.Up, sql) || slices.Contains(m.Down, sql) {
+			return nil, nil
+		}
+	}
+	switch sql {
+	case "BEGIN", "COMMIT", "ROLLBACK":
+		return nil, nil
+	case pgMigrations[0].Up[0]:
+		f.files = true
+		return nil, nil
+	case pgMigrations[0].Down[0]:
+		f.files, f.rows = false, map[string][2]string{}
+		return nil, nil
+	case pgMigrationsTable:
+		if f.versions == nil {
+			f.versions = map[int]string{}
+		}
+		return nil, nil
+	case pgVersion, pgRecord, pgForget:
+		if f.versions == nil {
+			return nil, undefined("filegoblin_migrations")
+		}
+		switch sql {
+		case pgRecord:
+			v, _ := strconv.Atoi(args[0])
+			f.versions[v] = args[1]
+		case pgForget:
+			v, _ := strconv.Atoi(args[0])
+			delete(f.versions, v)
+		default:
+			latest := 0
+			for v := range f.versions {
+				latest = max(latest, v)
+			}
+			return [][]string{{strconv.Itoa(latest)}}, nil
+		}
+		return nil, nil
+	case pgLoad, pgScan, pgModified, pgSave, pgRemove, pgSearch:
+		if !f.files {
+			return nil, undefined("filegoblin_files")
+		}
+	}
+	switch sql {
+	case pgLoad:
+		if row, ok := f.rows[args[0]]; ok {
+			return [][]string{row[:]}, nil
+		}
+		return nil, nil
+	case pgScan, pgModified:
+		var out [][]string
+		for id, row := range f.rows {
+			if sql == pgScan {
+				out = append(out, row[:])
+			} else {
+				out = append(out, []string{id, row[0]})
+			}
+		}
+		return out, nil
+	case pgSave:
+		f.last = max(f.last+1, time.Now().UnixNano())
+		mod := strconv.FormatInt(f.last, 10)
+		f.rows[args[0]] = [2]string{mod, args[1]}
+		return [][]string{{mod}}, nil
+	case pgRemove:
+		delete(f.rows, args[0])
+		return nil, nil
+	case pgSearch:
+		var terms []string
+		for _, t := range strings.Split(args[0], " & ") {
+			terms = append(terms, strings.TrimSuffix(strings.TrimPrefix(t, "'"), "':*"))
+		}
+		var out [][]string
+		for id, row := range f.rows {
+			var rec File
+			if json.Unmarshal([]byte(row[1]), &rec) == nil && searchScore(&rec, terms) > 0 {
+				out = append(out, []string{id})
+			}
+		}
+		return out, nil
+	case pgLoadUsers, pgLoadKeys:
+		out := f.users
+		if sql == pgLoadKeys {
+			out = f.keys
+		}
+		return slices.Clone(out), nil
+	case pgClearUsers:
+		f.users = nil
+		return nil, nil
+	case pgClearKeys:
+		f.keys = nil
+		return nil, nil
+	case pgSaveUser:
+		f.users = append(f.users, args)
+		return nil, nil
+	case pgSaveKey:
+		f.keys = append(f.keys, args)
+		return nil, nil
+	case pgTryLock:
+		if holder, ok := f.locks[args[0]]; ok && holder != conn {
+			return [][]string{{"f"}}, nil
+		}
+		f.locks[args[0]] = conn
+		return [][]string{{"t"}}, nil
+	case pgUnlock:
+		ok := f.locks[args[0]] == conn
+		if ok {
+			delete(f.locks, args[0])
+		}
+		return [][]string{{strconv.FormatBool(ok)[:1]}}, nil
+	case pgPing:
+		return [][]string{{"1"}}, nil
+	case pgPruneSpent:
+		now, _ := strconv.ParseInt(args[0], 10, 64)
+		for k, exp := range f.spent {
+			if exp < now {
+				delete(f.spent, k)
+			}
+		}
+		return nil, nil
+	case pgSpend:
+		if _, ok := f.spent[args[0]]; ok {
+			return nil, nil
+		}
+		if f.spent == nil {
+			f.spent = map[string]int64{}
+		}
+		f.spent[args[0]], _ = strconv.ParseInt(args[1], 10, 64)
+		return [][]string{{args[0]}}, nil
+	}
+	return nil, errors.New("unexpected statement: " + sql)
+}
+
+// TestPostgresLogin checks that a wrong password is refused, and that a store whose idle
+// connections were dropped gets new ones.
+func TestPostgresLogin(t *testing.T) {
+	pg := newFakePostgres(t, "s3cret")
+	if _, err := OpenPostgres(PostgresOptions{URL: pg.url("wrong")}); err == nil || !strings.Contains(err.Error(), "password authentication failed") {
+		t.Fatalf("wrong password: %v", err)
+	}
+	st, err := OpenPostgres(PostgresOptions{URL: pg.url("wrong"), Password: "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	if err := Migrate(st.(Migrator), len(pgMigrations), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Save(&File{ID: "a", Name: "a.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	pg.drop()
+	if f, _, err := st.Load("a"); err != nil || f.Name != "a.txt" {
+		t.Fatalf("after the connections dropped: %+v, %v", f, err)
+	}
+}
+
+// TestPostgresPlainPasswords checks that without TLS, the password is sent in the clear or
+// as an MD5 hash only when require_auth allows it.
+func TestPostgresPlainPasswords(t *testing.T) {
+	pg := newFakePostgres(t, "s3cret")
+	asks := func(method uint32) {
+		pg.mu.Lock()
+		pg.method = method
+		pg.mu.Unlock()
+	}
+	for _, method := range []uint32{3, 5} {
+		asks(method)
+		name := pgAuthCodes[method]
+		if _, err := OpenPostgres(PostgresOptions{URL: pg.url("s3cret")}); err == nil || !strings.Contains(err.Error(), "without TLS") {
+			t.Fatalf("%s without TLS: %v", name, err)
+		}
+		if _, err := OpenPostgres(PostgresOptions{URL: pg.url("s3cret") + "?require_auth=scram-sha-256"}); err == nil || !strings.Contains(err.Error(), "require_auth") {
+			t.Fatalf("%s with require_auth=scram-sha-256: %v", name, err)
+		}
+		st, err := OpenPostgres(PostgresOptions{URL: pg.url("s3cret") + "?require_auth=scram-sha-256," + name})
+		if err != nil {
+			t.Fatalf("%s allowed: %v", name, err)
+		}
+		st.Close()
+		if _, err := OpenPostgres(PostgresOptions{URL: pg.url("wrong") + "?require_auth=" + name}); err == nil || !strings.Contains(err.Error(), "password authentication failed") {
+			t.Fatalf("%s, wrong password: %v", name, err)
+		}
+	}
+	asks(0)
+	if _, err := OpenPostgres(PostgresOptions{URL: pg.url("s3cret") + "?require_auth=md5"}); err == nil {
+		t.Fatal("SCRAM login with require_auth=md5")
+	}
+	if _, err := OpenPostgres(PostgresOptions{URL: pg.url("s3cret") + "?require_auth=kerberos"}); err == nil {
+		t.Fatal("unknown require_auth method accepted")
+	}
+}
+
+// TestPostgresUnsignedSCRAM checks that a SCRAM login the server ends without its signature
+// is refused: a server in the middle could take the proof and let the client in regardless.
+func TestPostgresUnsignedSCRAM(t *testing.T) {
+	pg := newFakePostgres(t, "s3cret")
+	pg.mu.Lock()
+	pg.unsigned = true
+	pg.mu.Unlock()
+	if _, err := OpenPostgres(PostgresOptions{URL: pg.url("s3cret")}); err == nil || !strings.Contains(err.Error(), "without proving") {
+		t.Fatalf("AuthenticationOk straight after SASLContinue: %v", err)
+	}
+}
+
+// TestPostgresCoordination checks that advisory locks keep two servers apart, and that the
+// leadership passes on when its holder resigns or its connection is lost.
+func TestPostgresCoordination(t *testing.T) {
+	pg := newFakePostgres(t, "s3cret")
+	open := func() Coordinator {
+		st, err := OpenPostgres(PostgresOptions{URL: pg.url("s3cret")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { st.Close() })
+		return st.(Coordinator)
+	}
+	a, b := open(), open()
+
+	unlock, err := a.Lock("file-x", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Lock("file-x", 100*time.Millisecond); !errors.Is(err, ErrBusy) {
+		t.Fatalf("second lock: %v, want ErrBusy", err)
+	}
+	if _, err := a.Lock("file-x", 100*time.Millisecond); !errors.Is(err, ErrBusy) {
+		t.Fatalf("same server, second lock: %v, want ErrBusy", err)
+	}
+	unlock()
+	unlock, err = b.Lock("file-x", time.Second)
+	if err != nil {
+		t.Fatalf("lock after release: %v", err)
+	}
+	unlock()
+
+	if ok, err := a.Lead(); !ok || err != nil {
+		t.Fatalf("a.Lead() = %v, %v", ok, err)
+	}
+	if ok, _ := b.Lead(); ok {
+		t.Fatal("two leaders")
+	}
+	if err := a.Resign(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := b.Lead(); !ok {
+		t.Fatal("b didn't take over after a resigned")
+	}
+	pg.drop() // b's session ends, and its lock with it
+	deadline := time.Now().Add(time.Second)
+	for ok := false; !ok; {
+		if ok, _ = a.Lead(); !ok && time.Now().After(deadline) {
+			t.Fatal("a didn't take over after b lost its connection")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if ok, _ := b.Lead(); ok {
+		t.Fatal("b still leads after losing its connection")
+	}
+}
+
+// TestPostgresSpend checks that a key spent with one server is spent for the others, until it
+// expires.
+func TestPostgresSpend(t *testing.T) {
+	pg := newFakePostgres(t, "s3cret")
+	open := func() Coordinator {
+		st, err := OpenPostgres(PostgresOptions{URL: pg.url("s3cret")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { st.Close() })
+		return st.(Coordinator)
+	}
+	a, b := open(), open()
+	now := time.Now()
+	if ok, err := a.Spend("puzzle", now.Add(time.Minute)); !ok || err != nil {
+		t.Fatalf("first spend = %v, %v", ok, err)
+	}
+	if ok, err := b.Spend("puzzle", now.Add(time.Minute)); ok || err != nil {
+		t.Fatalf("spent again with another server = %v, %v", ok, err)
+	}
+	if ok, _ := a.Spend("old", now.Add(-time.Second)); !ok {
+		t.Fatal("first spend of an expired key")
+	}
+	if ok, _ := b.Spend("old", now.Add(time.Minute)); !ok {
+		t.Fatal("a key that expired is still spent")
+	}
+}
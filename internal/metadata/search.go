@@ -0,0 +1,118 @@
+package metadata
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Searcher is implemented by stores that keep a full-text index of their records, on the
+// fields Index.Search looks at.
+type Searcher interface {
+	// Search returns the IDs of the records with a word starting with each of terms, best
+	// match first.
+	Search(terms []string) ([]string, error)
+}
+
+// maxSearchTerms bounds the words of a query that count.
+const maxSearchTerms = 16
+
+// searchTerms splits a query into lowercase words of letters and digits, the way names like
+// "tax_return-2024.pdf" are split into words to search, dropping repeats.
+func searchTerms(query string) []string {
+	var terms []string
+	for _, w := range searchWords(query) {
+		if len(terms) < maxSearchTerms && !slices.Contains(terms, w) {
+			terms = append(terms, w)
+		}
+	}
+	return terms
+}
+
+func searchWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+}
+
+// searchFields are the text of f that searches match, with how much a match in each counts:
+// its name most, then its tags, description and content type. An end-to-end encrypted
+// file's name is ciphertext, so it isn't searched.
+func searchFields(f *File) []searchField {
+	name := f.Name
+	if f.Encrypted {
+		name = ""
+	}
+	return []searchField{{name, 4}, {strings.Join(f.Tags, " "), 3}, {f.Description, 2}, {f.ContentType, 1}}
+}
+
+type searchField struct {
+	text   string
+	weight float64
+}
+
+// searchScore ranks f for terms, where the store doesn't: each term counts the weight of
+// every field with a word it starts, twice over for the whole word. It's 0 unless every term
+// matches somewhere.
+func searchScore(f *File, terms []string) float64 {
+	fields := searchFields(f)
+	words := make([][]string, len(fields))
+	for i, fl := range fields {
+		words[i] = searchWords(fl.text)
+	}
+	score := 0.0
+	for _, t := range terms {
+		matched := false
+		for i, fl := range fields {
+			for _, w := range words[i] {
+				if strings.HasPrefix(w, t) {
+					matched = true
+					score += fl.weight
+					if w == t {
+						score += fl.weight
+					}
+					break
+				}
+			}
+		}
+		if !matched {
+			return 0
+		}
+	}
+	return score
+}
+
+// Search returns copies of the records with a word in their name, tags, description or
+// content type starting with each word of query, best match first. Stores with a full-text
+// index rank them; otherwise they're ranked here, newest first among equals.
+func (ix *Index) Search(query string) ([]*File, error) {
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	var out []*File
+	if s, ok := ix.store.(Searcher); ok {
+		ids, err := s.Search(terms)
+		if err != nil {
+			return nil, err
+		}
+		ix.mu.RLock()
+		for _, id := range ids {
+			// one another server wrote since the last Refresh waits for the next
+			if f, ok := ix.files[id]; ok {
+				cp := *f
+				out = append(out, &cp)
+			}
+		}
+		ix.mu.RUnlock()
+		return out, nil
+	}
+	scores := map[*File]float64{}
+	for _, f := range ix.List() {
+		if score := searchScore(f, terms); score > 0 {
+			scores[f] = score
+			out = append(out, f)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return scores[out[i]] > scores[out[j]] })
+	return out, nil
+}
@@ -0,0 +1,376 @@
+package metadata
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/auth"
+	_ "modernc.org/sqlite" // the "sqlite" database/sql driver
+)
+
+// SQLiteOptions says where a SQLite store keeps its database.
+type SQLiteOptions struct {
+	Path string // the database file, created if missing
+
+	// BusyTimeout is how long a statement waits for another process's write to finish
+	// before it fails; 10s when 0.
+	BusyTimeout time.Duration
+}
+
+// sqliteStore keeps records in a SQLite database, as JSON documents in a table with the time
+// each was written. The database is opened in-process with modernc.org/sqlite, SQLite
+// translated to Go, so the server still builds without cgo.
+type sqliteStore struct {
+	db *sql.DB
+}
+
+// sqliteMigrations are the SQLite store's schema changes, oldest first. Databases made before
+// there were migrations have the files table but no version; the first adopts them.
+var sqliteMigrations = []Migration{
+	{Version: 1, Name: "files",
+		Up: []string{`CREATE TABLE IF NOT EXISTS files (
+	id       TEXT PRIMARY KEY,
+	modified INTEGER NOT NULL, -- Unix nanoseconds
+	record   TEXT NOT NULL     -- the File, as JSON
+)`},
+		Down: []string{`DROP TABLE files`}},
+	// a full-text index of what searches match, kept up to date by triggers; the index is
+	// keyed by the records' rowids, which only an INTEGER PRIMARY KEY keeps from changing
+	{Version: 2, Name: "search",
+		Up: []string{`CREATE TABLE files_new (
+	seq      INTEGER PRIMARY KEY,
+	id       TEXT NOT NULL UNIQUE,
+	modified INTEGER NOT NULL, -- Unix nanoseconds
+	record   TEXT NOT NULL     -- the File, as JSON
+)`,
+			`INSERT INTO files_new (id, modified, record) SELECT id, modified, record FROM files`,
+			`DROP TABLE files`,
+			`ALTER TABLE files_new RENAME TO files`,
+			`CREATE VIRTUAL TABLE files_search USING fts5(name, tags, description, content_type, tokenize = 'unicode61 remove_diacritics 2')`,
+			`CREATE TRIGGER files_search_insert AFTER INSERT ON files BEGIN
+	INSERT INTO files_search (rowid, name, tags, description, content_type) VALUES (` + sqliteSearchColumns("new") + `);
+END`,
+			`CREATE TRIGGER files_search_update AFTER UPDATE ON files BEGIN
+	DELETE FROM files_search WHERE rowid = old.seq;
+	INSERT INTO files_search (rowid, name, tags, description, content_type) VALUES (` + sqliteSearchColumns("new") + `);
+END`,
+			`CREATE TRIGGER files_search_delete AFTER DELETE ON files BEGIN
+	DELETE FROM files_search WHERE rowid = old.seq;
+END`,
+			`INSERT INTO files_search (rowid, name, tags, description, content_type) SELECT ` + sqliteSearchColumns("files") + ` FROM files`},
+		Down: []string{`DROP TABLE files_search`,
+			`CREATE TABLE files_old (
+	id       TEXT PRIMARY KEY,
+	modified INTEGER NOT NULL,
+	record   TEXT NOT NULL
+)`,
+			`INSERT INTO files_old (id, modified, record) SELECT id, modified, record FROM files`,
+			`DROP TABLE files`, // and its triggers
+			`ALTER TABLE files_old RENAME TO files`}},
+	// the users, their quotas and their API keys, which were in data_dir/auth.json before
+	{Version: 3, Name: "accounts",
+		Up: []string{`CREATE TABLE users (
+	name    TEXT PRIMARY KEY,
+	quota   INTEGER NOT NULL, -- bytes; 0 for no limit
+	created INTEGER NOT NULL  -- Unix nanoseconds
+)`,
+			`CREATE TABLE api_keys (
+	id       TEXT PRIMARY KEY,
+	username TEXT NOT NULL, -- kept, revoked, when the user is removed
+	label    TEXT NOT NULL,
+	hash     TEXT NOT NULL UNIQUE,
+	created  INTEGER NOT NULL,
+	revoked  INTEGER -- Unix nanoseconds; NULL while the key works
+)`},
+		Down: []string{`DROP TABLE api_keys`, `DROP TABLE users`}},
+}
+
+// sqliteSearchColumns are the values the search index has for row: its rowid, then the
+// fields searchFields has.
+func sqliteSearchColumns(row string) string {
+	return strings.NewReplacer("row.", row+".").Replace(`row.seq,
+		CASE WHEN json_extract(row.record, '$.encrypted') THEN NULL ELSE json_extract(row.record, '$.name') END,
+		(SELECT group_concat(value, ' ') FROM json_each(row.record, '$.tags')),
+		json_extract(row.record, '$.description'),
+		json_extract(row.record, '$.content_type')`)
+}
+
+// OpenSQLite returns a store keeping records in the SQLite database opt.Path, creating it,
+// and the directory it's in, if needed. Its schema is left to Migrate.
+func OpenSQLite(opt SQLiteOptions) (Store, error) {
+	if opt.BusyTimeout == 0 {
+		opt.BusyTimeout = 10 * time.Second
+	}
+	if err := os.MkdirAll(filepath.Dir(opt.Path), 0o750); err != nil {
+		return nil, fmt.Errorf("create metadata dir: %w", err)
+	}
+	db, err := sql.Open("sqlite", fmt.Sprintf("%s?_pragma=busy_timeout(%d)", opt.Path, opt.BusyTimeout.Milliseconds()))
+	if err != nil {
+		return nil, fmt.Errorf("sqlite %s: %w", opt.Path, err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("sqlite %s: %w", opt.Path, err)
+	}
+	return &sqliteStore{db: db}, nil
+}
+
+// scanRecord reads a row of modified and record.
+func scanRecord(row interface{ Scan(...any) error }) (*File, time.Time, error) {
+	var ns int64
+	var record string
+	if err := row.Scan(&ns, &record); err != nil {
+		return nil, time.Time{}, err
+	}
+	var f File
+	if err := json.Unmarshal([]byte(record), &f); err != nil {
+		return nil, time.Time{}, fmt.Errorf("sqlite: metadata record: %w", err)
+	}
+	return &f, time.Unix(0, ns), nil
+}
+
+func (s *sqliteStore) Load(id string) (*File, time.Time, error) {
+	f, mod, err := scanRecord(s.db.QueryRow(`SELECT modified, record FROM files WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, time.Time{}, ErrNotFound
+	}
+	return f, mod, err
+}
+
+func (s *sqliteStore) Scan(fn func(f *File, mod time.Time)) error {
+	rows, err := s.db.Query(`SELECT modified, record FROM files`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		f, mod, err := scanRecord(rows)
+		if err != nil {
+			return err
+		}
+		fn(f, mod)
+	}
+	return rows.Err()
+}
+
+func (s *sqliteStore) Modified() (map[string]time.Time, error) {
+	rows, err := s.db.Query(`SELECT id, modified FROM files`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string]time.Time{}
+	for rows.Next() {
+		var id string
+		var ns int64
+		if err := rows.Scan(&id, &ns); err != nil {
+			return nil, err
+		}
+		out[id] = time.Unix(0, ns)
+	}
+	return out, rows.Err()
+}
+
+// Save writes f in a transaction of its own, which SQLite syncs before it commits. A record
+// that's there already is updated in place, keeping its rowid.
+func (s *sqliteStore) Save(f *File) (time.Time, error) {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return time.Time{}, err
+	}
+	mod := time.Now()
+	_, err = s.db.Exec(`INSERT INTO files (id, modified, record) VALUES (?, ?, ?)
+ON CONFLICT (id) DO UPDATE SET modified = excluded.modified, record = excluded.record`, f.ID, mod.UnixNano(), string(data))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return mod, nil
+}
+
+func (s *sqliteStore) Remove(id string) error {
+	_, err := s.db.Exec(`DELETE FROM files WHERE id = ?`, id)
+	return err
+}
+
+// Vacuum has nothing to do: SQLite rolls back a write that crashed when the database is next
+// opened.
+func (s *sqliteStore) Vacuum() (int, error) { return 0, nil }
+
+func (s *sqliteStore) Close() error { return s.db.Close() }
+
+func (s *sqliteStore) Migrations() []Migration { return sqliteMigrations }
+
+// Search asks the full-text index for records with words starting with each term, ranked
+// by BM25 with searchFields' weights.
+func (s *sqliteStore) Search(terms []string) ([]string, error) {
+	var match []string
+	for _, t := range terms {
+		match = append(match, `"`+t+`"*`) // terms are letters and digits only
+	}
+	rows, err := s.db.Query(`SELECT files.id FROM files_search JOIN files ON files.seq = files_search.rowid
+WHERE files_search MATCH ?
+ORDER BY bm25(files_search, 4.0, 3.0, 2.0, 1.0), files.seq DESC`, strings.Join(match, " "))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// sqliteMigrationsTable records the migrations applied.
+const sqliteMigrationsTable = `CREATE TABLE IF NOT EXISTS migrations (
+	version INTEGER PRIMARY KEY,
+	name    TEXT NOT NULL,
+	applied INTEGER NOT NULL -- Unix nanoseconds
+)`
+
+func (s *sqliteStore) Version() (int, error) {
+	var n int
+	if err := s.db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'migrations'`).Scan(&n); err != nil || n == 0 {
+		return 0, err
+	}
+	var v int
+	err := s.db.QueryRow(`SELECT coalesce(max(version), 0) FROM migrations`).Scan(&v)
+	return v, err
+}
+
+// Apply runs the migration in a transaction that takes the database's write lock before it
+// checks the version, so a server migrating at the same time waits, then finds the schema
+// where it was going.
+func (s *sqliteStore) Apply(m Migration, up bool) (err error) {
+	ctx := context.Background()
+	c, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if _, err := c.ExecContext(ctx, `BEGIN IMMEDIATE`); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			c.ExecContext(ctx, `ROLLBACK`)
+		}
+	}()
+	if _, err := c.ExecContext(ctx, sqliteMigrationsTable); err != nil {
+		return err
+	}
+	var v int
+	if err := c.QueryRowContext(ctx, `SELECT coalesce(max(version), 0) FROM migrations`).Scan(&v); err != nil {
+		return err
+	}
+	from, stmts := m.Version-1, m.Up
+	if !up {
+		from, stmts = m.Version, m.Down
+	}
+	if v != from {
+		return ErrSchemaChanged
+	}
+	for _, stmt := range stmts {
+		if _, err := c.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	if up {
+		_, err = c.ExecContext(ctx, `INSERT INTO migrations (version, name, applied) VALUES (?, ?, ?)`, m.Version, m.Name, time.Now().UnixNano())
+	} else {
+		_, err = c.ExecContext(ctx, `DELETE FROM migrations WHERE version = ?`, m.Version)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = c.ExecContext(ctx, `COMMIT`)
+	return err
+}
+
+func (s *sqliteStore) LoadAccounts() ([]*auth.User, []*auth.Key, error) {
+	rows, err := s.db.Query(`SELECT name, quota, created FROM users ORDER BY name`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	var users []*auth.User
+	for rows.Next() {
+		var u auth.User
+		var created int64
+		if err := rows.Scan(&u.Name, &u.Quota, &created); err != nil {
+			return nil, nil, err
+		}
+		u.CreatedAt = time.Unix(0, created).UTC()
+		users = append(users, &u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	rows, err = s.db.Query(`SELECT id, username, label, hash, created, revoked FROM api_keys ORDER BY created`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	var keys []*auth.Key
+	for rows.Next() {
+		var k auth.Key
+		var created int64
+		var revoked sql.NullInt64
+		if err := rows.Scan(&k.ID, &k.User, &k.Label, &k.Hash, &created, &revoked); err != nil {
+			return nil, nil, err
+		}
+		k.CreatedAt = time.Unix(0, created).UTC()
+		if revoked.Valid {
+			at := time.Unix(0, revoked.Int64).UTC()
+			k.RevokedAt = &at
+		}
+		keys = append(keys, &k)
+	}
+	return users, keys, rows.Err()
+}
+
+// SaveAccounts replaces the users and keys in one transaction.
+func (s *sqliteStore) SaveAccounts(users []*auth.User, keys []*auth.Key) (err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	if _, err := tx.Exec(`DELETE FROM api_keys`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM users`); err != nil {
+		return err
+	}
+	for _, u := range users {
+		if _, err := tx.Exec(`INSERT INTO users (name, quota, created) VALUES (?, ?, ?)`, u.Name, u.Quota, u.CreatedAt.UnixNano()); err != nil {
+			return err
+		}
+	}
+	for _, k := range keys {
+		var revoked sql.NullInt64
+		if k.RevokedAt != nil {
+			revoked = sql.NullInt64{Int64: k.RevokedAt.UnixNano(), Valid: true}
+		}
+		if _, err := tx.Exec(`INSERT INTO api_keys (id, username, label, hash, created, revoked) VALUES (?, ?, ?, ?, ?, ?)`,
+			k.ID, k.User, k.Label, k.Hash, k.CreatedAt.UnixNano(), revoked); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,116 @@
+// Package metrics exposes counters and gauges in the Prometheus text format, for a scraper
+// to collect from /metrics. Values aren't kept here: each metric is a function that reads
+// them from wherever they are counted when a scrape comes in.
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Label is one dimension of a sample, like level="error".
+type Label struct {
+	Name, Value string
+}
+
+// Sample is one value of a metric, for one combination of labels.
+type Sample struct {
+	Labels []Label
+	Value  float64
+}
+
+// Registry is a set of metrics to expose. It is safe for concurrent use.
+type Registry struct {
+	mu       sync.Mutex
+	families []family
+}
+
+type family struct {
+	name, help, kind string
+	collect          func() []Sample
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry { return &Registry{} }
+
+// Counter adds a metric that only goes up, like messages logged since the start, read by
+// collect. Its name should end in _total.
+func (r *Registry) Counter(name, help string, collect func() []Sample) {
+	r.add(family{name, help, "counter", collect})
+}
+
+// Gauge adds a metric that goes up and down, like files stored, read by collect.
+func (r *Registry) Gauge(name, help string, collect func() []Sample) {
+	r.add(family{name, help, "gauge", collect})
+}
+
+func (r *Registry) add(f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.families = append(r.families, f)
+	sort.Slice(r.families, func(i, j int) bool { return r.families[i].name < r.families[j].name })
+}
+
+// WriteTo writes every metric in the text exposition format:
+//
+//	# HELP filegoblin_log_messages_total Log messages written, by level and component.
+//	# TYPE filegoblin_log_messages_total counter
+//	filegoblin_log_messages_total{level="error",component="storage"} 3
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := append([]family(nil), r.families...)
+	r.mu.Unlock()
+	cw := &countWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, f := range families {
+		bw.WriteString("# HELP " + f.name + " " + escape(f.help, false) + "\n")
+		bw.WriteString("# TYPE " + f.name + " " + f.kind + "\n")
+		for _, s := range f.collect() {
+			bw.WriteString(f.name)
+			if len(s.Labels) > 0 {
+				bw.WriteByte('{')
+				for i, l := range s.Labels {
+					if i > 0 {
+						bw.WriteByte(',')
+					}
+					bw.WriteString(l.Name + `="` + escape(l.Value, true) + `"`)
+				}
+				bw.WriteByte('}')
+			}
+			bw.WriteString(" " + strconv.FormatFloat(s.Value, 'g', -1, 64) + "\n")
+		}
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// ServeHTTP answers a scrape.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = r.WriteTo(w)
+}
+
+// escape escapes backslashes and line breaks, and in label values double quotes too.
+func escape(s string, quotes bool) string {
+	r := strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	if quotes {
+		r = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	}
+	return r.Replace(s)
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,33 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	r := NewRegistry()
+	r.Gauge("files", "Files stored.", func() []Sample { return []Sample{{Value: 12}} })
+	r.Counter("messages_total", "Messages,\nby level.", func() []Sample {
+		return []Sample{
+			{Labels: []Label{{"level", "error"}, {"component", `s3 "eu"`}}, Value: 3},
+			{Labels: []Label{{"level", "info"}}, Value: 1.5e9},
+		}
+	})
+	var b strings.Builder
+	n, err := r.WriteTo(&b)
+	if err != nil || n != int64(b.Len()) {
+		t.Fatalf("WriteTo = %d, %v for %d bytes", n, err, b.Len())
+	}
+	want := `# HELP files Files stored.
+# TYPE files gauge
+files 12
+# HELP messages_total Messages,\nby level.
+# TYPE messages_total counter
+messages_total{level="error",component="s3 \"eu\""} 3
+messages_total{level="info"} 1.5e+09
+`
+	if b.String() != want {
+		t.Fatalf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
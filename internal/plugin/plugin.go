@@ -0,0 +1,174 @@
+// Package plugin runs operators' own code at fixed points of a file's life, so custom rules
+// don't need a fork. A plugin is a command, given a Request as JSON on stdin, or an HTTP
+// endpoint POSTed the same JSON. It answers with a Response as JSON (on stdout, or as the
+// response body); no answer at all lets the action go ahead. docs/plugins.md describes the
+// contract for plugin authors.
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/config"
+	"github.com/hey-granth/filegoblin/internal/webhook"
+)
+
+// Version is the version of the JSON contract, sent in every Request. It goes up only when a
+// change could break existing plugins; new fields don't count.
+const Version = 1
+
+// Where plugins run.
+const (
+	PreUpload   = "pre_upload"   // stored and scanned but not yet published; can refuse it
+	PostUpload  = "post_upload"  // published; the answer is ignored
+	PreDownload = "pre_download" // before a download or preview is served; can refuse it
+)
+
+const (
+	defaultTimeout = 10 * time.Second
+	maxAnswer      = 1 << 20 // bytes of output read from a plugin
+)
+
+// Request is what a plugin is given.
+type Request struct {
+	Version int     `json:"version"`
+	Point   string  `json:"point"`
+	File    any     `json:"file"`           // the file's record, as the API shows it
+	Path    string  `json:"path,omitempty"` // for commands at upload points: the content, to read only
+	Client  *Client `json:"client,omitempty"`
+}
+
+// Client describes who made the request, when there is one (post_upload runs after the
+// upload has been answered).
+type Client struct {
+	User      string `json:"user,omitempty"` // the API key's user; empty for anonymous requests
+	Remote    string `json:"remote"`         // the client's IP address
+	UserAgent string `json:"user_agent,omitempty"`
+}
+
+// Response is a plugin's answer.
+type Response struct {
+	Deny    bool   `json:"deny"`
+	Message string `json:"message,omitempty"` // told to the client when denying
+}
+
+// Name identifies p in logs: its command or URL.
+func Name(p config.Plugin) string {
+	if len(p.Command) > 0 {
+		return p.Command[0]
+	}
+	return p.URL
+}
+
+// Run runs p with req and returns its answer. A plugin that fails (times out, exits non-zero,
+// answers with an HTTP error or with something that isn't a Response) returns an error.
+func Run(ctx context.Context, p config.Plugin, req Request) (Response, error) {
+	req.Version = Version
+	timeout := p.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	body, err := json.Marshal(req)
+	if err != nil {
+		return Response{}, err
+	}
+	var out []byte
+	if len(p.Command) > 0 {
+		out, err = runCommand(ctx, p, body)
+	} else {
+		out, err = post(ctx, p, body)
+	}
+	if err != nil {
+		return Response{}, fmt.Errorf("plugin %s: %w", Name(p), err)
+	}
+	var res Response
+	if len(bytes.TrimSpace(out)) == 0 {
+		return res, nil
+	}
+	if err := json.Unmarshal(out, &res); err != nil {
+		return Response{}, fmt.Errorf("plugin %s: answer is not JSON: %w", Name(p), err)
+	}
+	return res, nil
+}
+
+// runCommand runs a command plugin in a bare environment: PATH, a temporary directory and
+// the plugin's own env entries, and nothing from the server's environment, which can hold
+// secrets like the config key. It runs in the temporary directory.
+func runCommand(ctx context.Context, p config.Plugin, body []byte) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, p.Command[0], p.Command[1:]...)
+	cmd.Env = []string{"PATH=" + os.Getenv("PATH"), "TMPDIR=" + os.TempDir()}
+	if root := os.Getenv("SYSTEMROOT"); root != "" {
+		cmd.Env = append(cmd.Env, "SYSTEMROOT="+root) // Windows programs need it to start
+	}
+	cmd.Env = append(cmd.Env, p.Env...)
+	cmd.Dir = os.TempDir()
+	cmd.WaitDelay = time.Second // don't hang on children still holding stdout
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Stdout = &limitedWriter{&stdout, maxAnswer}
+	cmd.Stderr = &limitedWriter{&stderr, 4 << 10}
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, errors.New("timed out")
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+func post(ctx context.Context, p config.Plugin, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "filegoblin-plugin")
+	if p.Secret != "" {
+		req.Header.Set(webhook.SignatureHeader, webhook.Sign(p.Secret, time.Now(), body))
+	}
+	c := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	out, err := io.ReadAll(io.LimitReader(resp.Body, maxAnswer))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, errors.New(resp.Status)
+	}
+	return out, nil
+}
+
+// limitedWriter keeps the first n bytes written to it and quietly drops the rest, so a
+// chatty plugin can't fill the server's memory.
+type limitedWriter struct {
+	w io.Writer
+	n int
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if keep := min(len(p), l.n); keep > 0 {
+		l.n -= keep
+		if _, err := l.w.Write(p[:keep]); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
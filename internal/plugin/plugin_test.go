@@ -0,0 +1,82 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/config"
+	"github.com/hey-granth/filegoblin/internal/webhook"
+)
+
+func TestCommand(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh")
+	}
+	t.Setenv("FILEGOBLIN_CONFIG_KEY", "hunter2")
+	p := config.Plugin{
+		Command: []string{"sh", "-c", `[ -z "$FILEGOBLIN_CONFIG_KEY" ] || { echo leaked >&2; exit 2; }
+if grep -q evil; then echo "{\"deny\": true, \"message\": \"$REASON\"}"; fi`},
+		Env: []string{"REASON=no evil here"},
+	}
+	res, err := Run(context.Background(), p, Request{Point: PreUpload, File: map[string]string{"name": "fine.txt"}})
+	if err != nil || res.Deny {
+		t.Fatalf("fine file: %+v, %v", res, err)
+	}
+	res, err = Run(context.Background(), p, Request{Point: PreUpload, File: map[string]string{"name": "evil.exe"}})
+	if err != nil || !res.Deny || res.Message != "no evil here" {
+		t.Fatalf("evil file: %+v, %v", res, err)
+	}
+
+	p = config.Plugin{Command: []string{"sh", "-c", "echo broken >&2; exit 3"}}
+	if _, err := Run(context.Background(), p, Request{}); err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Fatalf("failing plugin: %v", err)
+	}
+	p = config.Plugin{Command: []string{"sh", "-c", "echo not json"}}
+	if _, err := Run(context.Background(), p, Request{}); err == nil {
+		t.Fatal("garbage answer accepted")
+	}
+	p = config.Plugin{Command: []string{"sleep", "5"}, Timeout: 50 * time.Millisecond}
+	if _, err := Run(context.Background(), p, Request{}); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("slow plugin: %v", err)
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := webhook.Verify("k", r.Header.Get(webhook.SignatureHeader), body, time.Now(), time.Minute); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		var req Request
+		_ = json.Unmarshal(body, &req)
+		if req.Version != Version || req.Client == nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if req.Client.User != "alice" {
+			_ = json.NewEncoder(w).Encode(Response{Deny: true, Message: "only alice"})
+		}
+	}))
+	defer srv.Close()
+	p := config.Plugin{URL: srv.URL, Secret: "k"}
+	res, err := Run(context.Background(), p, Request{Point: PreDownload, Client: &Client{User: "alice"}})
+	if err != nil || res.Deny {
+		t.Fatalf("alice: %+v, %v", res, err)
+	}
+	res, err = Run(context.Background(), p, Request{Point: PreDownload, Client: &Client{User: "bob"}})
+	if err != nil || !res.Deny || res.Message != "only alice" {
+		t.Fatalf("bob: %+v, %v", res, err)
+	}
+	p.Secret = "wrong"
+	if _, err := Run(context.Background(), p, Request{Point: PreDownload, Client: &Client{}}); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("endpoint refusing the request: %v", err)
+	}
+}
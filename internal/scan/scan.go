@@ -0,0 +1,167 @@
+// Package scan runs uploads through a virus scanner: a clamd daemon spoken to over its
+// INSTREAM protocol, or any command that reads the file on stdin.
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"strings"
+
+	"github.com/hey-granth/filegoblin/internal/config"
+)
+
+// Verdict is a scanner's answer about one file.
+type Verdict struct {
+	Infected  bool
+	Signature string // what was found, when Infected
+}
+
+// Scanner inspects a stream of bytes.
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (Verdict, error)
+}
+
+// New returns the scanner described by cfg, or nil when scanning is off.
+func New(cfg config.Scan) (Scanner, error) {
+	switch {
+	case cfg.Clamd != "":
+		return ParseClamd(cfg.Clamd)
+	case len(cfg.Command) > 0:
+		return &Command{Args: cfg.Command}, nil
+	}
+	return nil, nil
+}
+
+// Clamd talks to a clamd daemon.
+type Clamd struct {
+	Network string // "unix" or "tcp"
+	Addr    string
+}
+
+// ParseClamd parses "unix:/path/to/clamd.ctl" or "tcp:host:port".
+func ParseClamd(s string) (*Clamd, error) {
+	network, addr, ok := strings.Cut(s, ":")
+	if !ok || addr == "" || (network != "unix" && network != "tcp") {
+		return nil, fmt.Errorf("clamd address %q must look like unix:/path or tcp:host:port", s)
+	}
+	return &Clamd{Network: network, Addr: addr}, nil
+}
+
+// chunkSize is how much of the file goes into each INSTREAM chunk.
+const chunkSize = 64 << 10
+
+// Scan streams r to clamd with INSTREAM: length-prefixed chunks, then a zero-length one.
+func (c *Clamd) Scan(ctx context.Context, r io.Reader) (Verdict, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, c.Network, c.Addr)
+	if err != nil {
+		return Verdict{}, fmt.Errorf("clamd: %w", err)
+	}
+	defer conn.Close()
+	if dl, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(dl)
+	}
+	// closing the connection is the only way to interrupt a blocked read or write
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if _, err := io.WriteString(conn, "zINSTREAM\x00"); err != nil {
+		return Verdict{}, fmt.Errorf("clamd: %w", err)
+	}
+	buf := make([]byte, 4+chunkSize)
+	for {
+		n, rerr := r.Read(buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf, uint32(n))
+			if _, err := conn.Write(buf[:4+n]); err != nil {
+				// clamd hangs up once the stream exceeds StreamMaxLength; its reply says so
+				break
+			}
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return Verdict{}, rerr
+		}
+	}
+	_, _ = conn.Write([]byte{0, 0, 0, 0})
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		return Verdict{}, fmt.Errorf("clamd: reading reply: %w", err)
+	}
+	return parseClamdReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+// parseClamdReply understands "stream: OK", "stream: <signature> FOUND" and
+// "<message> ERROR".
+func parseClamdReply(reply string) (Verdict, error) {
+	_, status, _ := strings.Cut(reply, ": ")
+	switch {
+	case status == "OK":
+		return Verdict{}, nil
+	case strings.HasSuffix(status, " FOUND"):
+		return Verdict{Infected: true, Signature: strings.TrimSuffix(status, " FOUND")}, nil
+	}
+	return Verdict{}, fmt.Errorf("clamd: %s", reply)
+}
+
+// Command runs an external scanner with the file on stdin. Following clamscan's convention,
+// exit status 0 means clean and 1 means infected; anything else is an error. The last line
+// "... FOUND" line of output (or else the last line) names the detection.
+type Command struct {
+	Args []string
+}
+
+func (c *Command) Scan(ctx context.Context, r io.Reader) (Verdict, error) {
+	cmd := exec.CommandContext(ctx, c.Args[0], c.Args[1:]...)
+	cmd.Stdin = r
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cmd.Run()
+	var exit *exec.ExitError
+	switch {
+	case err == nil:
+		return Verdict{}, nil
+	case errors.As(err, &exit) && exit.ExitCode() == 1:
+		return Verdict{Infected: true, Signature: signature(out.String())}, nil
+	}
+	if msg := strings.TrimSpace(out.String()); msg != "" {
+		return Verdict{}, fmt.Errorf("%s: %w: %s", c.Args[0], err, detectionLine(msg))
+	}
+	return Verdict{}, fmt.Errorf("%s: %w", c.Args[0], err)
+}
+
+// signature pulls "Eicar-Signature" out of lines like "stdin: Eicar-Signature FOUND".
+func signature(output string) string {
+	line := detectionLine(output)
+	if i := strings.LastIndex(line, ": "); i >= 0 {
+		line = line[i+2:]
+	}
+	line = strings.TrimSuffix(line, " FOUND")
+	if line == "" {
+		return "unknown"
+	}
+	return line
+}
+
+// detectionLine finds the interesting line in scanner output. clamscan follows its detection
+// with a summary block, so a "FOUND" line wins over the last one.
+func detectionLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	for _, l := range lines {
+		if strings.HasSuffix(strings.TrimSpace(l), " FOUND") {
+			return strings.TrimSpace(l)
+		}
+	}
+	return strings.TrimSpace(lines[len(lines)-1])
+}
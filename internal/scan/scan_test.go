@@ -0,0 +1,101 @@
+package scan
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// fakeClamd accepts INSTREAM sessions and flags any stream containing "EICAR".
+func fakeClamd(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				cmd := make([]byte, len("zINSTREAM\x00"))
+				if _, err := io.ReadFull(conn, cmd); err != nil || string(cmd) != "zINSTREAM\x00" {
+					return
+				}
+				var data bytes.Buffer
+				for {
+					var n uint32
+					if err := binary.Read(conn, binary.BigEndian, &n); err != nil {
+						return
+					}
+					if n == 0 {
+						break
+					}
+					if _, err := io.CopyN(&data, conn, int64(n)); err != nil {
+						return
+					}
+				}
+				reply := "stream: OK\x00"
+				if bytes.Contains(data.Bytes(), []byte("EICAR")) {
+					reply = "stream: Eicar-Signature FOUND\x00"
+				}
+				_, _ = io.WriteString(conn, reply)
+			}(conn)
+		}
+	}()
+	return "tcp:" + ln.Addr().String()
+}
+
+func TestClamd(t *testing.T) {
+	c, err := ParseClamd(fakeClamd(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := c.Scan(context.Background(), strings.NewReader(strings.Repeat("clean ", 50000)))
+	if err != nil || v.Infected {
+		t.Fatalf("clean file: %+v, %v", v, err)
+	}
+	v, err = c.Scan(context.Background(), strings.NewReader(eicar))
+	if err != nil || !v.Infected || v.Signature != "Eicar-Signature" {
+		t.Fatalf("eicar: %+v, %v", v, err)
+	}
+}
+
+func TestParseClamdReply(t *testing.T) {
+	if _, err := parseClamdReply("INSTREAM size limit exceeded. ERROR"); err == nil {
+		t.Fatal("ERROR reply was not an error")
+	}
+	if _, err := ParseClamd("localhost:3310"); err == nil {
+		t.Fatal("address without network accepted")
+	}
+}
+
+func TestCommand(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh")
+	}
+	c := &Command{Args: []string{"sh", "-c", `if grep -q EICAR; then echo "stdin: Test.Sig FOUND"; echo; echo "--- SCAN SUMMARY ---"; exit 1; fi`}}
+	v, err := c.Scan(context.Background(), strings.NewReader("hello"))
+	if err != nil || v.Infected {
+		t.Fatalf("clean file: %+v, %v", v, err)
+	}
+	v, err = c.Scan(context.Background(), strings.NewReader(eicar))
+	if err != nil || !v.Infected || v.Signature != "Test.Sig" {
+		t.Fatalf("eicar: %+v, %v", v, err)
+	}
+	c = &Command{Args: []string{"sh", "-c", "echo broken >&2; exit 2"}}
+	if _, err := c.Scan(context.Background(), strings.NewReader("x")); err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Fatalf("scanner failure: got %v", err)
+	}
+}
@@ -0,0 +1,350 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/config"
+	"github.com/hey-granth/filegoblin/internal/storage"
+)
+
+// KMS is a Provider whose values are sealed by a key management service, which can seal new
+// ones too. key names the service's key, and the result is a whole reference, scheme and all.
+type KMS interface {
+	Provider
+	Encrypt(ctx context.Context, key, plain string) (string, error)
+}
+
+// UseKMS adds the providers for the awskms:, gcpkms: and, with a Vault, transit: schemes
+// configured in cfg.KMS, whose credentials may be references themselves. Resolve calls it;
+// it's only needed on its own to seal values.
+func (r *Resolver) UseKMS(ctx context.Context, cfg *config.Config) error {
+	aws := cfg.KMS.AWS
+	for name, v := range map[string]*string{"kms.aws.access_key": &aws.AccessKey, "kms.aws.secret_key": &aws.SecretKey} {
+		s, err := r.lookup(ctx, *v)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		*v = s.Value
+	}
+	r.Providers["awskms"] = newAWSKMS(aws)
+	r.Providers["gcpkms"] = newGCPKMS(cfg.KMS.GCP)
+	if r.Vault != nil {
+		r.Providers["transit"] = transit{r.Vault}
+	}
+	return nil
+}
+
+var kmsClient = &http.Client{Timeout: 30 * time.Second}
+
+// awsKMS opens "awskms:" values with AWS KMS's Decrypt, whose ciphertext names the key.
+type awsKMS struct {
+	opt      config.AWSKMS
+	token    string // for temporary credentials
+	endpoint string
+}
+
+func newAWSKMS(opt config.AWSKMS) *awsKMS {
+	env := func(v *string, names ...string) {
+		for _, name := range names {
+			if *v == "" {
+				*v = os.Getenv(name)
+			}
+		}
+	}
+	k := &awsKMS{opt: opt}
+	env(&k.opt.Region, "AWS_REGION", "AWS_DEFAULT_REGION")
+	if k.opt.AccessKey == "" {
+		env(&k.opt.AccessKey, "AWS_ACCESS_KEY_ID")
+		env(&k.opt.SecretKey, "AWS_SECRET_ACCESS_KEY")
+		env(&k.token, "AWS_SESSION_TOKEN")
+	}
+	k.endpoint = strings.TrimRight(opt.Endpoint, "/")
+	if k.endpoint == "" {
+		k.endpoint = "https://kms." + k.opt.Region + ".amazonaws.com"
+	}
+	return k
+}
+
+func (k *awsKMS) call(ctx context.Context, action string, in, out interface{}) error {
+	if k.opt.Region == "" || k.opt.AccessKey == "" {
+		return errors.New("aws kms: no region or credentials: set kms.aws or $AWS_REGION and $AWS_ACCESS_KEY_ID")
+	}
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+action)
+	if k.token != "" {
+		req.Header.Set("X-Amz-Security-Token", k.token)
+	}
+	sum := sha256.Sum256(body)
+	storage.SignV4(req, hex.EncodeToString(sum[:]), k.opt.Region, "kms", k.opt.AccessKey, k.opt.SecretKey, time.Now())
+	resp, err := kmsClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("aws kms: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		_ = json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&e)
+		return fmt.Errorf("aws kms: %s: %s %s", action, resp.Status, strings.TrimSpace(e.Type+" "+e.Message))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (k *awsKMS) Lookup(ctx context.Context, ref string) (Secret, error) {
+	var out struct{ Plaintext []byte }
+	if err := k.call(ctx, "Decrypt", map[string]string{"CiphertextBlob": ref}, &out); err != nil {
+		return Secret{}, err
+	}
+	return Secret{Value: string(out.Plaintext)}, nil
+}
+
+// Encrypt seals plain with key, a key ID, ARN or "alias/<name>".
+func (k *awsKMS) Encrypt(ctx context.Context, key, plain string) (string, error) {
+	var out struct{ CiphertextBlob string }
+	if err := k.call(ctx, "Encrypt", map[string]interface{}{"KeyId": key, "Plaintext": []byte(plain)}, &out); err != nil {
+		return "", err
+	}
+	return "awskms:" + out.CiphertextBlob, nil
+}
+
+// gcpKMS opens "gcpkms:" values with Cloud KMS, as a service account: the one whose key is
+// in the credentials file, or the VM's, from the metadata server.
+type gcpKMS struct {
+	opt      config.GCPKMS
+	endpoint string
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func newGCPKMS(opt config.GCPKMS) *gcpKMS {
+	if opt.CredentialsFile == "" {
+		opt.CredentialsFile = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	}
+	k := &gcpKMS{opt: opt, endpoint: strings.TrimRight(opt.Endpoint, "/")}
+	if k.endpoint == "" {
+		k.endpoint = "https://cloudkms.googleapis.com"
+	}
+	return k
+}
+
+// accessToken returns an OAuth token for Cloud KMS, fetching a new one when it's about to run
+// out.
+func (k *gcpKMS) accessToken(ctx context.Context) (string, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.token != "" && time.Until(k.expires) > time.Minute {
+		return k.token, nil
+	}
+	var req *http.Request
+	if k.opt.CredentialsFile != "" {
+		var creds struct {
+			ClientEmail string `json:"client_email"`
+			PrivateKey  string `json:"private_key"`
+			TokenURI    string `json:"token_uri"`
+		}
+		data, err := os.ReadFile(k.opt.CredentialsFile)
+		if err != nil {
+			return "", fmt.Errorf("gcp kms: %w", err)
+		}
+		if err := json.Unmarshal(data, &creds); err != nil || creds.ClientEmail == "" {
+			return "", fmt.Errorf("gcp kms: %s is not a service account key", k.opt.CredentialsFile)
+		}
+		if creds.TokenURI == "" {
+			creds.TokenURI = "https://oauth2.googleapis.com/token"
+		}
+		assertion, err := gcpAssertion(creds.ClientEmail, creds.PrivateKey, creds.TokenURI)
+		if err != nil {
+			return "", err
+		}
+		form := url.Values{"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"}, "assertion": {assertion}}
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, creds.TokenURI, strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		host := os.Getenv("GCE_METADATA_HOST")
+		if host == "" {
+			host = "metadata.google.internal"
+		}
+		var err error
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet,
+			"http://"+host+"/computeMetadata/v1/instance/service-accounts/default/token", nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Metadata-Flavor", "Google")
+	}
+	var out struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := gcpDo(req, &out); err != nil {
+		return "", fmt.Errorf("gcp kms: token: %w", err)
+	}
+	k.token, k.expires = out.AccessToken, time.Now().Add(time.Duration(out.ExpiresIn)*time.Second)
+	return k.token, nil
+}
+
+// gcpAssertion is the signed JWT a service account trades for an access token.
+func gcpAssertion(email, privateKey, aud string) (string, error) {
+	block, _ := pem.Decode([]byte(privateKey))
+	if block == nil {
+		return "", errors.New("gcp kms: the service account's private_key is not PEM")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if err != nil || !ok {
+		return "", errors.New("gcp kms: the service account's private_key is not an RSA key")
+	}
+	now := time.Now()
+	enc := func(v interface{}) string {
+		data, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	unsigned := enc(map[string]string{"alg": "RS256", "typ": "JWT"}) + "." + enc(map[string]interface{}{
+		"iss": email, "aud": aud, "scope": "https://www.googleapis.com/auth/cloudkms",
+		"iat": now.Unix(), "exp": now.Add(time.Hour).Unix(),
+	})
+	sum := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(nil, rsaKey, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func gcpDo(req *http.Request, out interface{}) error {
+	resp, err := kmsClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error json.RawMessage `json:"error"`
+		}
+		_ = json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&e)
+		var detail struct{ Message string }
+		if json.Unmarshal(e.Error, &detail) != nil {
+			json.Unmarshal(e.Error, &detail.Message) // OAuth errors are plain strings
+		}
+		return fmt.Errorf("%s %s", resp.Status, detail.Message)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (k *gcpKMS) call(ctx context.Context, key, method string, in, out interface{}) error {
+	token, err := k.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.endpoint+"/v1/"+key+":"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	if err := gcpDo(req, out); err != nil {
+		return fmt.Errorf("gcp kms: %s: %w", method, err)
+	}
+	return nil
+}
+
+// Lookup opens "<key name>:<ciphertext>", the key named in full, like
+// "projects/p/locations/global/keyRings/r/cryptoKeys/k".
+func (k *gcpKMS) Lookup(ctx context.Context, ref string) (Secret, error) {
+	key, ciphertext, ok := strings.Cut(ref, ":")
+	if !ok || !strings.HasPrefix(key, "projects/") {
+		return Secret{}, fmt.Errorf("gcpkms reference must look like gcpkms:projects/.../cryptoKeys/<key>:<ciphertext>")
+	}
+	var out struct{ Plaintext []byte }
+	if err := k.call(ctx, key, "decrypt", map[string]string{"ciphertext": ciphertext}, &out); err != nil {
+		return Secret{}, err
+	}
+	return Secret{Value: string(out.Plaintext)}, nil
+}
+
+func (k *gcpKMS) Encrypt(ctx context.Context, key, plain string) (string, error) {
+	var out struct{ Ciphertext string }
+	if err := k.call(ctx, key, "encrypt", map[string]interface{}{"plaintext": []byte(plain)}, &out); err != nil {
+		return "", err
+	}
+	return "gcpkms:" + key + ":" + out.Ciphertext, nil
+}
+
+// transit opens "transit:" values with a key of Vault's transit secrets engine.
+type transit struct{ v *Vault }
+
+// path is the API path of the operation on key, "<mount>/<name>" or a name in "transit".
+func (transit) path(key, op string) string {
+	mount, name := "transit", key
+	if i := strings.LastIndex(key, "/"); i >= 0 {
+		mount, name = key[:i], key[i+1:]
+	}
+	return mount + "/" + op + "/" + name
+}
+
+func (t transit) Lookup(ctx context.Context, ref string) (Secret, error) {
+	key, ciphertext, ok := strings.Cut(ref, ":")
+	if !ok || key == "" {
+		return Secret{}, errors.New("transit reference must look like transit:<key>:vault:v1:...")
+	}
+	res, err := t.v.call(ctx, http.MethodPost, t.path(key, "decrypt"), map[string]string{"ciphertext": ciphertext})
+	if err != nil {
+		return Secret{}, err
+	}
+	b64, _ := res.Data["plaintext"].(string)
+	plain, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return Secret{}, fmt.Errorf("vault transit: bad plaintext in the response")
+	}
+	return Secret{Value: string(plain)}, nil
+}
+
+func (t transit) Encrypt(ctx context.Context, key, plain string) (string, error) {
+	res, err := t.v.call(ctx, http.MethodPost, t.path(key, "encrypt"),
+		map[string]string{"plaintext": base64.StdEncoding.EncodeToString([]byte(plain))})
+	if err != nil {
+		return "", err
+	}
+	ciphertext, _ := res.Data["ciphertext"].(string)
+	if ciphertext == "" {
+		return "", errors.New("vault transit: no ciphertext in the response")
+	}
+	return "transit:" + key + ":" + ciphertext, nil
+}
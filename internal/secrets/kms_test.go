@@ -0,0 +1,151 @@
+package secrets
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/hey-granth/filegoblin/internal/config"
+)
+
+// fakeKMS stands in for AWS KMS, Cloud KMS with its OAuth token endpoint, and Vault's transit
+// engine at once. Its "ciphertext" is the plaintext, base64-encoded behind the key's name.
+func fakeKMS(t *testing.T) *httptest.Server {
+	seal := func(key string, plain []byte) string { return key + "." + base64.StdEncoding.EncodeToString(plain) }
+	open := func(key, ciphertext string) ([]byte, bool) {
+		b64, ok := strings.CutPrefix(ciphertext, key+".")
+		plain, err := base64.StdEncoding.DecodeString(b64)
+		return plain, ok && err == nil
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var in map[string]string
+		var out interface{}
+		switch {
+		case r.URL.Path == "/token":
+			if r.FormValue("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" || strings.Count(r.FormValue("assertion"), ".") != 2 {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			out = map[string]interface{}{"access_token": "gcp-token", "expires_in": 3600}
+		case r.Header.Get("X-Amz-Target") != "":
+			if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") ||
+				!strings.Contains(r.Header.Get("Authorization"), "/eu-west-1/kms/aws4_request") {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			_ = json.NewDecoder(r.Body).Decode(&in)
+			switch r.Header.Get("X-Amz-Target") {
+			case "TrentService.Encrypt":
+				plain, _ := base64.StdEncoding.DecodeString(in["Plaintext"])
+				out = map[string]string{"CiphertextBlob": base64.StdEncoding.EncodeToString([]byte(seal(in["KeyId"], plain)))}
+			case "TrentService.Decrypt":
+				blob, _ := base64.StdEncoding.DecodeString(in["CiphertextBlob"])
+				key, _, _ := strings.Cut(string(blob), ".")
+				plain, ok := open(key, string(blob))
+				if !ok {
+					w.WriteHeader(http.StatusBadRequest)
+					_, _ = w.Write([]byte(`{"__type":"InvalidCiphertextException"}`))
+					return
+				}
+				out = map[string][]byte{"Plaintext": plain}
+			}
+		case strings.HasPrefix(r.URL.Path, "/v1/projects/"):
+			if r.Header.Get("Authorization") != "Bearer gcp-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_ = json.NewDecoder(r.Body).Decode(&in)
+			key, method, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v1/"), ":")
+			if method == "encrypt" {
+				plain, _ := base64.StdEncoding.DecodeString(in["plaintext"])
+				out = map[string]string{"ciphertext": seal(key, plain)}
+			} else if plain, ok := open(key, in["ciphertext"]); ok {
+				out = map[string][]byte{"plaintext": plain}
+			}
+		case strings.HasPrefix(r.URL.Path, "/v1/transit/"):
+			_ = json.NewDecoder(r.Body).Decode(&in)
+			op, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v1/transit/"), "/")
+			if op == "encrypt" {
+				plain, _ := base64.StdEncoding.DecodeString(in["plaintext"])
+				out = map[string]interface{}{"data": map[string]string{"ciphertext": "vault:v1:" + seal(key, plain)}}
+			} else if plain, ok := open(key, strings.TrimPrefix(in["ciphertext"], "vault:v1:")); ok {
+				out = map[string]interface{}{"data": map[string]string{"plaintext": base64.StdEncoding.EncodeToString(plain)}}
+			}
+		}
+		if out == nil {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(out)
+	}))
+}
+
+// TestKMS seals values with each service and resolves config references to them.
+func TestKMS(t *testing.T) {
+	srv := fakeKMS(t)
+	defer srv.Close()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(rsaKey)
+	creds, _ := json.Marshal(map[string]string{"type": "service_account", "client_email": "fg@p.iam.gserviceaccount.com",
+		"private_key": string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), "token_uri": srv.URL + "/token"})
+	credsFile := filepath.Join(t.TempDir(), "sa.json")
+	if err := os.WriteFile(credsFile, creds, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("FG_TEST_AWS_SECRET", "aws-secret")
+
+	cfg := config.Default()
+	cfg.Vault = config.Vault{Address: srv.URL, Token: "tok"}
+	cfg.KMS.AWS = config.AWSKMS{Region: "eu-west-1", Endpoint: srv.URL, AccessKey: "AKID", SecretKey: "env:FG_TEST_AWS_SECRET"}
+	cfg.KMS.GCP = config.GCPKMS{CredentialsFile: credsFile, Endpoint: srv.URL}
+	r, err := NewResolver(cfg.Vault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := r.UseKMS(ctx, cfg); err != nil {
+		t.Fatal(err)
+	}
+	gcpKey := "projects/p/locations/global/keyRings/fg/cryptoKeys/storage"
+	var refs []string
+	for _, sk := range [][2]string{{"awskms", "alias/fg"}, {"gcpkms", gcpKey}, {"transit", "fg"}} {
+		scheme, key := sk[0], sk[1]
+		ref, err := r.Providers[scheme].(KMS).Encrypt(ctx, key, scheme+"-secret-value")
+		if err != nil || !strings.HasPrefix(ref, scheme+":") {
+			t.Fatalf("%s: Encrypt = %q, %v", scheme, ref, err)
+		}
+		refs = append(refs, ref)
+	}
+
+	cfg.Auth.Keys = slices.Clone(refs)
+	r, _ = NewResolver(cfg.Vault)
+	if _, err := r.Resolve(ctx, cfg); err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range cfg.Auth.Keys {
+		scheme, _, _ := strings.Cut(refs[i], ":")
+		if v != scheme+"-secret-value" {
+			t.Fatalf("%s resolved to %q", refs[i], v)
+		}
+	}
+
+	cfg.Auth.Keys = []string{"awskms:" + base64.StdEncoding.EncodeToString([]byte("tampered"))}
+	if _, err := r.Resolve(ctx, cfg); err == nil || !strings.Contains(err.Error(), "InvalidCiphertextException") {
+		t.Fatalf("bad ciphertext: got %v", err)
+	}
+}
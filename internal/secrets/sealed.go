@@ -0,0 +1,74 @@
+package secrets
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// EnvConfigKey names the environment variable the config key is read from when config_key
+// isn't set.
+const EnvConfigKey = "FILEGOBLIN_CONFIG_KEY"
+
+// sealVersion leads every sealed value, so the format can change without guessing.
+const sealVersion = 1
+
+// ErrBadSealed means an enc: value is malformed, was sealed with another key, or was edited.
+var ErrBadSealed = errors.New("cannot decrypt enc: value (wrong config key or corrupted value)")
+
+// Sealer encrypts config values with AES-256-GCM, so that a config file holding "enc:..."
+// values can be committed to git: without the key, which lives in the environment or in
+// Vault, they reveal nothing.
+type Sealer struct{ aead cipher.AEAD }
+
+// NewConfigKey returns a fresh random key, encoded the way NewSealer expects.
+func NewConfigKey() string {
+	k := make([]byte, 32)
+	_, _ = rand.Read(k)
+	return base64.StdEncoding.EncodeToString(k)
+}
+
+// NewSealer takes a base64-encoded 32-byte key.
+func NewSealer(key string) (*Sealer, error) {
+	k, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil || len(k) != 32 {
+		return nil, errors.New("config key must be 32 bytes, base64-encoded (see `filegoblin config keygen`)")
+	}
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Sealer{aead: aead}, nil
+}
+
+// Seal encrypts plain into an "enc:" value.
+func (s *Sealer) Seal(plain string) string {
+	out := make([]byte, 1+s.aead.NonceSize(), 1+s.aead.NonceSize()+len(plain)+s.aead.Overhead())
+	out[0] = sealVersion
+	_, _ = rand.Read(out[1:])
+	out = s.aead.Seal(out, out[1:], []byte(plain), out[:1])
+	return "enc:" + base64.RawURLEncoding.EncodeToString(out)
+}
+
+// Lookup opens a sealed value, given without its "enc:" prefix. It makes Sealer the
+// Provider for the enc: scheme.
+func (s *Sealer) Lookup(_ context.Context, ref string) (Secret, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(ref)
+	n := 1 + s.aead.NonceSize()
+	if err != nil || len(raw) < n || raw[0] != sealVersion {
+		return Secret{}, ErrBadSealed
+	}
+	plain, err := s.aead.Open(nil, raw[1:n], raw[n:], raw[:1])
+	if err != nil {
+		return Secret{}, ErrBadSealed
+	}
+	return Secret{Value: string(plain)}, nil
+}
@@ -0,0 +1,161 @@
+// Package secrets resolves secret references in the configuration, so keys and passwords can
+// live in Vault, the environment or files managed by something else (Kubernetes secrets,
+// systemd credentials) instead of in the config file itself.
+//
+// A secret field holding "vault:<path>#<field>", "env:<VARIABLE>" or "file:<path>" is replaced
+// by the value it points to, "enc:<sealed>" is decrypted with the config key (see Sealer),
+// and "awskms:", "gcpkms:" and "transit:" values by a key management service (see KMS);
+// anything else is taken literally.
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/config"
+)
+
+// Secret is a resolved value, with its Vault lease if it has one.
+type Secret struct {
+	Value string
+	Lease Lease
+}
+
+// Lease is a Vault lease that has to be renewed for the secret to stay valid. Static secrets
+// (and everything that doesn't come from Vault) have the zero Lease.
+type Lease struct {
+	ID        string
+	Duration  time.Duration
+	Renewable bool
+}
+
+// Provider looks up references of one scheme; ref is the part after "scheme:".
+type Provider interface {
+	Lookup(ctx context.Context, ref string) (Secret, error)
+}
+
+// Resolver replaces references with values, using one Provider per scheme.
+type Resolver struct {
+	Providers map[string]Provider
+	Vault     *Vault // also in Providers; kept for renewing leases
+}
+
+// NewResolver returns a resolver for env:, file: and, when an address is configured (or
+// $VAULT_ADDR is set), vault: references.
+func NewResolver(vc config.Vault) (*Resolver, error) {
+	r := &Resolver{Providers: map[string]Provider{"env": envProvider{}, "file": fileProvider{}}}
+	// the Vault token can't come from Vault, but it may come from the environment or a file
+	tok, err := r.lookup(context.Background(), vc.Token)
+	if err != nil {
+		return nil, fmt.Errorf("vault.token: %w", err)
+	}
+	vc.Token = tok.Value
+	v, err := NewVault(vc)
+	if err != nil {
+		return nil, err
+	}
+	if v != nil {
+		r.Vault, r.Providers["vault"] = v, v
+	}
+	return r, nil
+}
+
+// Resolve replaces every reference in cfg's secret fields and returns the leases the values
+// came with. A reference that can't be resolved is an error naming the setting.
+func (r *Resolver) Resolve(ctx context.Context, cfg *config.Config) ([]Lease, error) {
+	// the KMS credentials come first, then the config key, which may be sealed by a KMS and
+	// unlocks the enc: values
+	if err := r.UseKMS(ctx, cfg); err != nil {
+		return nil, err
+	}
+	sealer, err := r.Sealer(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if sealer != nil {
+		r.Providers["enc"] = sealer
+	}
+
+	var leases []Lease
+	seen := map[string]bool{}
+	err = cfg.Secrets(func(name string, v *string) error {
+		s, err := r.lookup(ctx, *v)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		*v = s.Value
+		if s.Lease.ID != "" && !seen[s.Lease.ID] {
+			seen[s.Lease.ID] = true
+			leases = append(leases, s.Lease)
+		}
+		return nil
+	})
+	return leases, err
+}
+
+// Sealer returns the Sealer for cfg's config key, or nil when there's no key: config_key,
+// which may itself be a reference, or else $FILEGOBLIN_CONFIG_KEY.
+func (r *Resolver) Sealer(ctx context.Context, cfg *config.Config) (*Sealer, error) {
+	keyRef := cfg.ConfigKey
+	if _, ok := os.LookupEnv(EnvConfigKey); ok && keyRef == "" {
+		keyRef = "env:" + EnvConfigKey
+	}
+	if keyRef == "" {
+		return nil, nil
+	}
+	key, err := r.lookup(ctx, keyRef)
+	if err != nil {
+		return nil, fmt.Errorf("config_key: %w", err)
+	}
+	sealer, err := NewSealer(key.Value)
+	if err != nil {
+		return nil, fmt.Errorf("config_key: %w", err)
+	}
+	cfg.ConfigKey = key.Value
+	return sealer, nil
+}
+
+// lookup resolves one "scheme:ref" value. Values without a known scheme are literals and
+// come back unchanged.
+func (r *Resolver) lookup(ctx context.Context, v string) (Secret, error) {
+	scheme, ref, ok := strings.Cut(v, ":")
+	p, known := r.Providers[scheme]
+	switch {
+	case ok && known:
+		return p.Lookup(ctx, ref)
+	case ok && (scheme == "vault" || scheme == "transit"):
+		return Secret{}, fmt.Errorf("%s reference but no vault.address configured", scheme)
+	case ok && scheme == "enc":
+		return Secret{}, fmt.Errorf("encrypted value but no config key: set config_key or $%s", EnvConfigKey)
+	}
+	return Secret{Value: v}, nil
+}
+
+type envProvider struct{}
+
+func (envProvider) Lookup(_ context.Context, name string) (Secret, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return Secret{}, fmt.Errorf("environment variable %s is not set", name)
+	}
+	return Secret{Value: v}, nil
+}
+
+type fileProvider struct{}
+
+// Lookup reads the whole file, minus a trailing newline, which editors and `echo` add.
+func (fileProvider) Lookup(_ context.Context, path string) (Secret, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Secret{}, err
+	}
+	v := strings.TrimRight(string(data), "\r\n")
+	if v == "" {
+		return Secret{}, errors.New(path + " is empty")
+	}
+	return Secret{Value: v}, nil
+}
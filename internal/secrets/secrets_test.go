@@ -0,0 +1,132 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/config"
+)
+
+// fakeVault serves a KV v2 secret, a dynamic secret with a renewable lease, and lease
+// renewal, counting reads so the test can check they're cached.
+func fakeVault(reads *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "tok" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		var out interface{}
+		switch r.URL.Path {
+		case "/v1/secret/data/filegoblin":
+			*reads++
+			out = map[string]interface{}{"data": map[string]interface{}{
+				"data":     map[string]string{"admin_key": "admin-key-from-vault"},
+				"metadata": map[string]int{"version": 3},
+			}}
+		case "/v1/database/creds/app":
+			*reads++
+			out = map[string]interface{}{"lease_id": "database/creds/app/xyz", "lease_duration": 3600, "renewable": true,
+				"data": map[string]string{"username": "v-app-1", "password": "p4ss"}}
+		case "/v1/sys/leases/renew":
+			var in struct {
+				LeaseID   string `json:"lease_id"`
+				Increment int    `json:"increment"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&in)
+			out = map[string]interface{}{"lease_id": in.LeaseID, "lease_duration": 600, "renewable": true}
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(out)
+	}))
+}
+
+// TestResolve fills a config's secret fields from Vault, the environment and a file, and
+// checks that plain values are left alone.
+func TestResolve(t *testing.T) {
+	reads := 0
+	vault := fakeVault(&reads)
+	defer vault.Close()
+	keyFile := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyFile, []byte("key-from-a-file-0000\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("FG_TEST_CAPTCHA", "captcha-secret")
+
+	cfg := config.Default()
+	cfg.Vault = config.Vault{Address: vault.URL, Token: "tok"}
+	cfg.Auth.Keys = []string{"literal-key-0000000", "file:" + keyFile, "vault:database/creds/app#username", "vault:database/creds/app#password"}
+	cfg.Auth.AdminKeys = []string{"vault:secret/data/filegoblin#admin_key"}
+	cfg.Challenge.Secret = "env:FG_TEST_CAPTCHA"
+
+	r, err := NewResolver(cfg.Vault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leases, err := r.Resolve(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"literal-key-0000000", "key-from-a-file-0000", "v-app-1", "p4ss"}
+	if strings.Join(cfg.Auth.Keys, ",") != strings.Join(want, ",") {
+		t.Fatalf("auth.keys = %q", cfg.Auth.Keys)
+	}
+	if cfg.Auth.AdminKeys[0] != "admin-key-from-vault" || cfg.Challenge.Secret != "captcha-secret" {
+		t.Fatalf("admin key %q, challenge secret %q", cfg.Auth.AdminKeys[0], cfg.Challenge.Secret)
+	}
+	if reads != 2 {
+		t.Fatalf("%d reads from vault, want 2 (one per path)", reads)
+	}
+	if len(leases) != 1 || leases[0].Duration != time.Hour || !leases[0].Renewable {
+		t.Fatalf("leases: %+v", leases)
+	}
+	if ttl, err := r.Vault.Renew(context.Background(), leases[0]); err != nil || ttl != 10*time.Minute {
+		t.Fatalf("renew: %v, %v", ttl, err)
+	}
+
+	cfg.Auth.AdminKeys = []string{"vault:secret/data/missing#x"}
+	if _, err := r.Resolve(context.Background(), cfg); err == nil || !strings.Contains(err.Error(), "auth.admin_keys[0]") {
+		t.Fatalf("missing secret: got %v", err)
+	}
+}
+
+// TestSealed decrypts enc: values with the config key from the environment, and refuses them
+// with any other key.
+func TestSealed(t *testing.T) {
+	key := NewConfigKey()
+	s, err := NewSealer(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Default()
+	cfg.Auth.Keys = []string{s.Seal("sealed-api-key-00000")}
+	if cfg.Auth.Keys[0] == s.Seal("sealed-api-key-00000") {
+		t.Fatal("sealing is deterministic")
+	}
+
+	t.Setenv(EnvConfigKey, key)
+	r, _ := NewResolver(cfg.Vault)
+	if _, err := r.Resolve(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Auth.Keys[0] != "sealed-api-key-00000" {
+		t.Fatalf("auth.keys[0] = %q", cfg.Auth.Keys[0])
+	}
+
+	cfg = config.Default()
+	cfg.Auth.Keys = []string{s.Seal("sealed-api-key-00000")}
+	t.Setenv(EnvConfigKey, NewConfigKey())
+	r, _ = NewResolver(cfg.Vault)
+	if _, err := r.Resolve(context.Background(), cfg); err == nil || !strings.Contains(err.Error(), ErrBadSealed.Error()) {
+		t.Fatalf("wrong key: got %v", err)
+	}
+}
@@ -0,0 +1,242 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/config"
+)
+
+// Vault reads secrets over Vault's HTTP API. Reads are cached for the life of the Vault, so
+// references to several fields of one dynamic secret (a username and its password, say) get
+// the values from the same lease.
+type Vault struct {
+	Address   string
+	Token     string
+	Namespace string
+	Client    *http.Client
+
+	cache map[string]*vaultResponse
+}
+
+// NewVault builds a client from the config, falling back to $VAULT_ADDR and $VAULT_TOKEN. It
+// returns nil when no address is known, since then no vault: references can work.
+func NewVault(vc config.Vault) (*Vault, error) {
+	addr := vc.Address
+	if addr == "" {
+		addr = os.Getenv("VAULT_ADDR")
+	}
+	if addr == "" {
+		return nil, nil
+	}
+	token := vc.Token
+	if vc.TokenFile != "" {
+		data, err := os.ReadFile(vc.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("vault token: %w", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	if token == "" {
+		return nil, errors.New("vault: no token: set vault.token, vault.token_file or $VAULT_TOKEN")
+	}
+	return &Vault{
+		Address:   strings.TrimRight(addr, "/"),
+		Token:     token,
+		Namespace: vc.Namespace,
+		Client:    &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+type vaultResponse struct {
+	LeaseID       string                 `json:"lease_id"`
+	LeaseDuration int                    `json:"lease_duration"`
+	Renewable     bool                   `json:"renewable"`
+	Data          map[string]interface{} `json:"data"`
+	Auth          *struct {
+		LeaseDuration int  `json:"lease_duration"`
+		Renewable     bool `json:"renewable"`
+	} `json:"auth"`
+}
+
+func (v *Vault) call(ctx context.Context, method, path string, in interface{}) (*vaultResponse, error) {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, v.Address+"/v1/"+strings.TrimLeft(path, "/"), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+	if v.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.Namespace)
+	}
+	resp, err := v.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("vault: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var e struct {
+			Errors []string `json:"errors"`
+		}
+		_ = json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&e)
+		return nil, fmt.Errorf("vault: %s %s: %s %s", method, path, resp.Status, strings.Join(e.Errors, "; "))
+	}
+	var out vaultResponse
+	if resp.StatusCode == http.StatusNoContent {
+		return &out, nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("vault: %s %s: %w", method, path, err)
+	}
+	return &out, nil
+}
+
+// Lookup resolves "<path>#<field>". KV version 2 responses, which nest the values one level
+// deeper, are unwrapped, so "secret/data/filegoblin#admin_key" works as expected.
+func (v *Vault) Lookup(ctx context.Context, ref string) (Secret, error) {
+	path, field, ok := strings.Cut(ref, "#")
+	if !ok || path == "" || field == "" {
+		return Secret{}, fmt.Errorf("vault reference %q must look like <path>#<field>", ref)
+	}
+	res, ok := v.cache[path]
+	if !ok {
+		var err error
+		if res, err = v.call(ctx, http.MethodGet, path, nil); err != nil {
+			return Secret{}, err
+		}
+		if v.cache == nil {
+			v.cache = map[string]*vaultResponse{}
+		}
+		v.cache[path] = res
+	}
+	data := res.Data
+	if inner, ok := data["data"].(map[string]interface{}); ok {
+		if _, kv2 := data["metadata"]; kv2 {
+			data = inner
+		}
+	}
+	val, ok := data[field].(string)
+	if !ok {
+		return Secret{}, fmt.Errorf("vault: %s has no string field %q", path, field)
+	}
+	s := Secret{Value: val}
+	if res.LeaseID != "" {
+		s.Lease = Lease{ID: res.LeaseID, Duration: time.Duration(res.LeaseDuration) * time.Second, Renewable: res.Renewable}
+	}
+	return s, nil
+}
+
+// Renew extends a lease by its original duration and returns how long it was actually
+// granted, which comes out shorter once the lease nears its maximum TTL.
+func (v *Vault) Renew(ctx context.Context, l Lease) (time.Duration, error) {
+	res, err := v.call(ctx, http.MethodPut, "sys/leases/renew", map[string]interface{}{
+		"lease_id":  l.ID,
+		"increment": int(l.Duration.Seconds()),
+	})
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(res.LeaseDuration) * time.Second, nil
+}
+
+// renewToken extends the client's own token. ok is false for tokens that can't be renewed,
+// root tokens and the like, which need no looking after.
+func (v *Vault) renewToken(ctx context.Context) (ttl time.Duration, ok bool, err error) {
+	self, err := v.call(ctx, http.MethodGet, "auth/token/lookup-self", nil)
+	if err != nil {
+		return 0, false, err
+	}
+	if renewable, _ := self.Data["renewable"].(bool); !renewable {
+		return 0, false, nil
+	}
+	res, err := v.call(ctx, http.MethodPost, "auth/token/renew-self", map[string]interface{}{})
+	if err != nil || res.Auth == nil {
+		return 0, true, err
+	}
+	return time.Duration(res.Auth.LeaseDuration) * time.Second, true, nil
+}
+
+// KeepAlive renews the token and leases at half their TTL until ctx is done. When a lease
+// can't be kept any longer (it hit its maximum TTL, can't be renewed at all, or Vault said
+// no) stale is called, so the caller can resolve its secrets afresh; KeepAlive itself carries
+// on, retrying failures every minute, until the caller cancels it.
+func (v *Vault) KeepAlive(ctx context.Context, leases []Lease, stale func(error)) {
+	due := map[string]time.Time{}
+	now := time.Now()
+	for _, l := range leases {
+		due[l.ID] = now.Add(half(l.Duration))
+		if !l.Renewable {
+			due[l.ID] = now.Add(l.Duration * 2 / 3) // all we can do is fetch a new one in time
+		}
+	}
+	tokenDue := now
+	for {
+		next := now.Add(time.Hour)
+		if !tokenDue.IsZero() && !now.Before(tokenDue) {
+			ttl, ok, err := v.renewToken(ctx)
+			switch {
+			case err != nil:
+				stale(fmt.Errorf("vault: renew token: %w", err))
+				tokenDue = now.Add(time.Minute)
+			case !ok || ttl <= 0:
+				tokenDue = time.Time{} // nothing to renew
+			default:
+				tokenDue = now.Add(half(ttl))
+			}
+		}
+		if !tokenDue.IsZero() && tokenDue.Before(next) {
+			next = tokenDue
+		}
+		for _, l := range leases {
+			if now.Before(due[l.ID]) {
+				if due[l.ID].Before(next) {
+					next = due[l.ID]
+				}
+				continue
+			}
+			if !l.Renewable {
+				stale(fmt.Errorf("vault: lease %s is about to expire and can't be renewed", l.ID))
+				due[l.ID] = now.Add(time.Minute)
+			} else if ttl, err := v.Renew(ctx, l); err != nil {
+				stale(fmt.Errorf("vault: renew %s: %w", l.ID, err))
+				due[l.ID] = now.Add(time.Minute)
+			} else {
+				if ttl < l.Duration {
+					stale(fmt.Errorf("vault: lease %s reaches its maximum TTL in %s", l.ID, ttl))
+				}
+				due[l.ID] = now.Add(half(ttl))
+			}
+			if due[l.ID].Before(next) {
+				next = due[l.ID]
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+		now = time.Now()
+	}
+}
+
+// half is when to renew something valid for d: halfway, but not in a tight loop once a lease
+// is nearly gone.
+func half(d time.Duration) time.Duration { return max(d/2, 10*time.Second) }
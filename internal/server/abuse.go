@@ -0,0 +1,143 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/hey-granth/filegoblin/internal/abuse"
+)
+
+// maxReportDetails caps the free-text part of a report; it's read by a person, not archived.
+const maxReportDetails = 4000
+
+// handleReport lets anyone holding a link report the file behind it. It needs no API key, so
+// it's careful about what it accepts: a small body, a known reason, and a capped queue per file.
+func (s *Server) handleReport(w http.ResponseWriter, r *http.Request) {
+	if !s.passChallenge(w, r) {
+		return
+	}
+	f, err := s.liveFile(r.PathValue("id"))
+	if err != nil || !s.linkAllowed(r, f) {
+		writeError(w, r, http.StatusNotFound, "file not found")
+		return
+	}
+	var req struct {
+		Reason  string `json:"reason"`
+		Details string `json:"details"`
+		Contact string `json:"contact"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 16<<10)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if utf8.RuneCountInString(req.Details) > maxReportDetails || len(req.Contact) > 200 {
+		writeError(w, r, http.StatusBadRequest, "report details or contact too long")
+		return
+	}
+	rep, err := s.reports.Add(f.ID, req.Reason, req.Details, req.Contact)
+	if err != nil {
+		s.writeReportError(w, r, err)
+		return
+	}
+	s.logFor(r.Context()).Info("abuse report %s against %s (%s)", rep.ID, f.ID, rep.Reason)
+	// the reporter gets the ID to refer to, not the record: contact details stay with admins
+	writeJSON(w, http.StatusCreated, map[string]string{"id": rep.ID, "status": rep.Status})
+}
+
+// handleNotices lists the takedown notices for the caller's files.
+func (s *Server) handleNotices(w http.ResponseWriter, r *http.Request) {
+	owner, ok := s.authorize(r)
+	if !ok {
+		writeError(w, r, http.StatusUnauthorized, "missing or invalid API key")
+		return
+	}
+	if owner == "" {
+		writeJSON(w, http.StatusOK, []abuse.Notice{}) // open servers have no owners to notify
+		return
+	}
+	writeJSON(w, http.StatusOK, s.reports.Notices(owner))
+}
+
+func (s *Server) handleListReports(w http.ResponseWriter, r *http.Request) {
+	status := abuse.StatusOpen
+	if r.URL.Query().Get("all") == "1" {
+		status = ""
+	}
+	writeJSON(w, http.StatusOK, s.reports.List(status))
+}
+
+// handleTakedown disables the reported file's links and tells the uploader. The record and
+// blob stay where they are, so the file can still be reviewed or handed over; downloads
+// answer 410 from now on and the owner can no longer delete it.
+func (s *Server) handleTakedown(w http.ResponseWriter, r *http.Request) {
+	note, ok := reviewNote(w, r)
+	if !ok {
+		return
+	}
+	if rep, err := s.reports.Get(r.PathValue("id")); err == nil {
+		unlock, err := s.lockFile(rep.FileID)
+		if err != nil {
+			s.writeLockError(w, r, err)
+			return
+		}
+		defer unlock()
+	}
+	rep, err := s.reports.Takedown(s.index, r.PathValue("id"), note)
+	if rep.ID == "" {
+		s.writeReportError(w, r, err)
+		return
+	}
+	if err != nil {
+		s.logFor(r.Context()).Error("notify uploader of takedown %s: %v", rep.FileID, err)
+	}
+	s.audit(r, "takedown", rep.FileID, "took down %s after report %s", rep.FileID, rep.ID)
+	writeJSON(w, http.StatusOK, rep)
+}
+
+func (s *Server) handleDismissReport(w http.ResponseWriter, r *http.Request) {
+	note, ok := reviewNote(w, r)
+	if !ok {
+		return
+	}
+	rep, err := s.reports.Resolve(r.PathValue("id"), abuse.StatusDismissed, note)
+	if err != nil {
+		s.writeReportError(w, r, err)
+		return
+	}
+	s.audit(r, "report_dismissed", "", "dismissed report %s", rep.ID)
+	writeJSON(w, http.StatusOK, rep)
+}
+
+// reviewNote reads the optional {"note": ...} body of a takedown or dismissal.
+func reviewNote(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req struct {
+		Note string `json:"note"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid JSON body")
+			return "", false
+		}
+	}
+	return req.Note, true
+}
+
+// writeReportError maps abuse store errors onto HTTP status codes.
+func (s *Server) writeReportError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, abuse.ErrUnknownReport):
+		writeError(w, r, http.StatusNotFound, err.Error())
+	case errors.Is(err, abuse.ErrInvalidReason):
+		writeError(w, r, http.StatusBadRequest, err.Error())
+	case errors.Is(err, abuse.ErrResolved), errors.Is(err, abuse.ErrFileGone):
+		writeError(w, r, http.StatusConflict, err.Error())
+	case errors.Is(err, abuse.ErrTooManyOpen):
+		writeError(w, r, http.StatusTooManyRequests, err.Error())
+	default:
+		s.logFor(r.Context()).Error("abuse: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "internal error")
+	}
+}
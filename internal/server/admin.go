@@ -0,0 +1,174 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/auth"
+)
+
+// userResponse is a user plus how much they're currently storing.
+type userResponse struct {
+	auth.User
+	Used int64 `json:"used"`
+}
+
+// usage adds up the size of everything owner has stored.
+func (s *Server) usage(owner string) int64 {
+	var n int64
+	for _, f := range s.index.List() {
+		if f.Owner == owner {
+			n += f.StoredSize()
+		}
+	}
+	return n
+}
+
+// uploadLimit works out how many bytes owner may send in one upload: the global size limit,
+// tightened to what's left of their quota. quotaBound says which of the two applies, so the
+// error message can tell the user what went wrong. 0 means unlimited.
+func (s *Server) uploadLimit(owner string) (limit int64, quotaBound bool, err error) {
+	limit = int64(s.config().Limits.MaxUploadSize)
+	if owner == "" {
+		return limit, false, nil
+	}
+	u, err := s.users.User(owner)
+	if err != nil || u.Quota <= 0 {
+		return limit, false, nil // static keys and unlimited users
+	}
+	left := u.Quota - s.usage(owner)
+	if left <= 0 {
+		return 0, false, errors.New("storage quota exhausted")
+	}
+	if limit <= 0 || left < limit {
+		return left, true, nil
+	}
+	return limit, false, nil
+}
+
+func (s *Server) handleListUsers(w http.ResponseWriter, r *http.Request) {
+	out := []userResponse{}
+	for _, u := range s.users.Users() {
+		out = append(out, userResponse{User: u, Used: s.usage(u.Name)})
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) handleAddUser(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name  string `json:"name"`
+		Quota int64  `json:"quota"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	u, err := s.users.AddUser(req.Name, req.Quota)
+	if err != nil {
+		s.writeAuthError(w, r, err)
+		return
+	}
+	s.audit(r, "user_added", "", "added user %s", u.Name)
+	writeJSON(w, http.StatusCreated, userResponse{User: u})
+}
+
+func (s *Server) handleRemoveUser(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if err := s.users.RemoveUser(name); err != nil {
+		s.writeAuthError(w, r, err)
+		return
+	}
+	s.audit(r, "user_removed", "", "removed user %s", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleSetQuota(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Quota int64 `json:"quota"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	name := r.PathValue("name")
+	if err := s.users.SetQuota(name, req.Quota); err != nil {
+		s.writeAuthError(w, r, err)
+		return
+	}
+	s.audit(r, "quota_set", "", "quota for %s set to %d bytes", name, req.Quota)
+	u, _ := s.users.User(name)
+	writeJSON(w, http.StatusOK, userResponse{User: u, Used: s.usage(name)})
+}
+
+func (s *Server) handleListKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := s.users.Keys(r.PathValue("name"))
+	if err != nil {
+		s.writeAuthError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, keys)
+}
+
+func (s *Server) handleCreateKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Label string `json:"label"`
+	}
+	// the body is optional here, a key without a label is fine
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+	}
+	k, err := s.users.CreateKey(r.PathValue("name"), req.Label)
+	if err != nil {
+		s.writeAuthError(w, r, err)
+		return
+	}
+	s.audit(r, "key_created", "", "created key %s for %s", k.ID, k.User)
+	writeJSON(w, http.StatusCreated, k)
+}
+
+func (s *Server) handleRevokeKey(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if err := s.users.RevokeKey(id); err != nil {
+		s.writeAuthError(w, r, err)
+		return
+	}
+	s.audit(r, "key_revoked", "", "revoked key %s", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeAuthError maps registry errors onto HTTP status codes.
+func (s *Server) writeAuthError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, auth.ErrUnknownUser), errors.Is(err, auth.ErrUnknownKey):
+		writeError(w, r, http.StatusNotFound, err.Error())
+	case errors.Is(err, auth.ErrUserExists):
+		writeError(w, r, http.StatusConflict, err.Error())
+	case errors.Is(err, auth.ErrInvalidName):
+		writeError(w, r, http.StatusBadRequest, err.Error())
+	default:
+		s.logFor(r.Context()).Error("admin: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "internal error")
+	}
+}
+
+func (s *Server) handleListSigningKeys(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.links.Keys())
+}
+
+// handleRotateSigningKey starts signing links with a fresh key right away, e.g. after a
+// suspected leak. Links signed with the old key keep working for links.grace.
+func (s *Server) handleRotateSigningKey(w http.ResponseWriter, r *http.Request) {
+	k, err := s.links.Rotate(time.Now())
+	if err != nil {
+		s.logFor(r.Context()).Error("rotate signing key: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "could not rotate signing key")
+		return
+	}
+	s.audit(r, "signing_key_rotated", "", "rotated link signing key, now %s", k.ID)
+	writeJSON(w, http.StatusCreated, k)
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// bearerToken pulls the key out of an "Authorization: Bearer <key>" header.
+func bearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if len(h) > 7 && strings.EqualFold(h[:7], "bearer ") {
+		return strings.TrimSpace(h[7:])
+	}
+	return ""
+}
+
+// Authenticator identifies who sent r, for programs that embed the server and have users of
+// their own: it returns the owner name to record, or false to turn the request away. It
+// stands in for the API keys and user registry; the admin API still wants an admin key.
+type Authenticator func(r *http.Request) (owner string, ok bool)
+
+// SetAuthenticator replaces API key checks with a. Call it before serving.
+func (s *Server) SetAuthenticator(a Authenticator) { s.authn = a }
+
+// authorize checks the request's API key and returns the owner name to record for it: the
+// user name for registry keys, a fingerprint for static keys from the config. When there are
+// no keys of either kind the server runs open and every request is allowed with an empty owner.
+func (s *Server) authorize(r *http.Request) (string, bool) {
+	if s.authn != nil {
+		return s.authn(r)
+	}
+	token := bearerToken(r)
+	if u, ok := s.users.Authenticate(token); ok {
+		return u.Name, true
+	}
+	keys := s.config().Auth.Keys
+	if k, ok := matchKey(token, keys); ok {
+		return keyOwner(k), true
+	}
+	if len(keys) == 0 && !s.users.HasUsers() {
+		return "", true
+	}
+	return "", false
+}
+
+// authorizeUpload is authorize, except anonymous uploads may be allowed by config.
+func (s *Server) authorizeUpload(r *http.Request) (string, bool) {
+	owner, ok := s.authorize(r)
+	if !ok && s.config().Auth.AnonymousUploads && bearerToken(r) == "" {
+		return "", true
+	}
+	return owner, ok
+}
+
+// authorizeAdmin checks for one of the admin keys. Unlike authorize there is no open mode:
+// without admin keys configured the admin API is simply off.
+func (s *Server) authorizeAdmin(r *http.Request) bool {
+	_, ok := matchKey(bearerToken(r), s.config().Auth.AdminKeys)
+	return ok
+}
+
+// admin wraps a handler so it only runs for requests carrying an admin key.
+func (s *Server) admin(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.authorizeAdmin(r) {
+			writeError(w, r, http.StatusForbidden, "admin key required")
+			return
+		}
+		h(w, r)
+	}
+}
+
+// matchKey compares token against every key in constant time and returns the one that matched.
+func matchKey(token string, keys []string) (string, bool) {
+	if token == "" {
+		return "", false
+	}
+	for _, k := range keys {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(k)) == 1 {
+			return k, true
+		}
+	}
+	return "", false
+}
+
+// keyOwner derives a stable, non-secret name for a key, so records never contain the key itself.
+func keyOwner(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "key:" + hex.EncodeToString(sum[:4])
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/backup"
+	"github.com/hey-granth/filegoblin/internal/config"
+)
+
+// runBackups writes a backup generation every backup.every until ctx is done. Like the
+// lifecycle sweep it reads the settings fresh each time, so a reload can turn backups on or
+// off, and in a cluster only the leader runs them.
+func (s *Server) runBackups(ctx context.Context) {
+	for {
+		every := s.config().Backup.Every
+		if every <= 0 {
+			every = time.Minute // off; look again later
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(every):
+		}
+		bc := s.config().Backup
+		if bc.Every <= 0 || !s.isLeader() {
+			continue
+		}
+		info, err := backup.Run(ctx, s.store, s.index, s.config().DataDir, bc.Target, bc.Keep, time.Now())
+		switch {
+		case errors.Is(err, backup.ErrBusy):
+			s.log.Info("backup: skipped, another is still running")
+		case err != nil:
+			s.log.Error("backup: %v", err)
+		default:
+			s.log.Info("backup: wrote %s to %s: %d records changed, %d deleted, %d new blobs (%s)",
+				info.Name, bc.Target, info.Records, info.Deleted, info.Blobs, config.ByteSize(info.Bytes))
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/hey-granth/filegoblin/internal/config"
+	"github.com/hey-granth/filegoblin/internal/i18n"
+	"github.com/hey-granth/filegoblin/internal/webui"
+)
+
+// newUIs builds the instance's web UI and one per branded tenant, keyed by lower-case domain,
+// all served under root.
+func newUIs(cfg *config.Config, root string) (*webui.UI, map[string]*webui.UI) {
+	base := cfg.Branding.Brand
+	tenants := map[string]*webui.UI{}
+	for _, t := range cfg.Branding.Tenants {
+		u := webui.New(inherit(t.Brand, base), t.AssetsDir, cfg.UI.AssetsDir)
+		u.SetRoot(root)
+		tenants[strings.ToLower(t.Domain)] = u
+	}
+	web := webui.New(base, cfg.UI.AssetsDir)
+	web.SetRoot(root)
+	return web, tenants
+}
+
+// inherit fills b's empty settings from base.
+func inherit(b, base config.Brand) config.Brand {
+	for _, f := range []struct {
+		v    *string
+		base string
+	}{
+		{&b.Name, base.Name}, {&b.Logo, base.Logo}, {&b.Accent, base.Accent},
+		{&b.Background, base.Background}, {&b.Text, base.Text}, {&b.Footer, base.Footer},
+		{&b.Locale, base.Locale},
+	} {
+		if *f.v == "" {
+			*f.v = f.base
+		}
+	}
+	return b
+}
+
+// requestHost is r's host name without the port, in lower case.
+func requestHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	return strings.ToLower(host)
+}
+
+// ui returns the web UI for the domain r was sent to: a tenant's, or the instance's own.
+func (s *Server) ui(r *http.Request) *webui.UI {
+	if u, ok := s.tenants[requestHost(r)]; ok {
+		return u
+	}
+	return s.web
+}
+
+// withLocale works out the language of every request up front, from its Accept-Language and
+// the default of the domain it was sent to, for the error messages it may get.
+func (s *Server) withLocale(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(i18n.NewContext(r.Context(), s.ui(r).Locale(r))))
+	})
+}
+
+func (s *Server) serveAsset(w http.ResponseWriter, r *http.Request) { s.ui(r).ServeAsset(w, r) }
+
+func (s *Server) serveAdmin(w http.ResponseWriter, r *http.Request) { s.ui(r).ServeAdmin(w, r) }
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/metadata"
+	"github.com/hey-granth/filegoblin/internal/plugin"
+)
+
+// Previews (thumbnails, posters, clips) are cached under data_dir/cache/<kind>/, named after
+// the file they were made from: "<id>-<variant>".
+
+// cached returns the path of a cached preview, calling fill to make it first when it doesn't
+// exist yet. fill writes to tmp, which is renamed into place on success, so a half-made
+// preview is never served. Previews are made one at a time, which keeps a burst of requests
+// from decoding many large files at once.
+func (s *Server) cached(kind, name string, fill func(tmp string) error) (string, error) {
+	path := filepath.Join(s.config().DataDir, "cache", kind, name)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	if _, err := os.Stat(path); err == nil {
+		return path, nil // made while we waited
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", err
+	}
+	tmp := path + ".tmp"
+	if err := fill(tmp); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return path, os.Rename(tmp, path)
+}
+
+// dropCache removes every cached preview of a deleted file.
+func (s *Server) dropCache(id string) {
+	paths, _ := filepath.Glob(filepath.Join(s.config().DataDir, "cache", "*", id+"-*"))
+	for _, p := range paths {
+		if err := os.Remove(p); err != nil {
+			s.log.Error("delete cached %s: %v", p, err)
+		}
+	}
+}
+
+// previewAllowed checks a request for a preview of f the way a download is checked, so a
+// preview never shows more than the file itself would. When it says no it has answered the
+// request.
+func (s *Server) previewAllowed(w http.ResponseWriter, r *http.Request, f *metadata.File) bool {
+	if !s.linkAllowed(r, f) {
+		textError(w, r, "link expired or invalid", http.StatusForbidden)
+		return false
+	}
+	if f.TakenDown != nil {
+		textError(w, r, "file taken down following an abuse report", http.StatusGone)
+		return false
+	}
+	if f.Expired(time.Now()) {
+		textError(w, r, "file expired", http.StatusGone)
+		return false
+	}
+	if status, msg := s.passwordAllowed(w, r, f); status != 0 {
+		textError(w, r, msg, status)
+		return false
+	}
+	if f.Quarantined() {
+		textError(w, r, "file quarantined by virus scan", http.StatusForbidden)
+		return false
+	}
+	if status, msg := s.runPlugins(r.Context(), plugin.PreDownload, r, f); status != 0 {
+		textError(w, r, msg, status)
+		return false
+	}
+	return true
+}
+
+// serveCached sends a cached preview of f, with Range support for clips.
+func (s *Server) serveCached(w http.ResponseWriter, r *http.Request, f *metadata.File, path, contentType string) {
+	pf, err := os.Open(path)
+	if err != nil {
+		s.logFor(r.Context()).Error("preview of %s: %v", f.ID, err)
+		textError(w, r, "preview unavailable", http.StatusInternalServerError)
+		return
+	}
+	defer pf.Close()
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Security-Policy", userContentCSP)
+	// private: the file may sit behind a password or a signed link, so shared caches keep out
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	http.ServeContent(w, r, "", f.CreatedAt, pf)
+}
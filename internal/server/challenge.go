@@ -0,0 +1,146 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/challenge"
+)
+
+// challengeHeader carries the CAPTCHA token or solved puzzle on a challenged request.
+const challengeHeader = "X-Challenge-Response"
+
+// captchaClient asks the CAPTCHA provider about tokens. A provider that takes longer than
+// this is treated as down.
+var captchaClient = &http.Client{Timeout: 10 * time.Second}
+
+// challengeResponse is GET /api/challenge: what a client has to solve before an anonymous
+// request. Provider is empty when no challenge is needed.
+type challengeResponse struct {
+	Provider   string     `json:"provider"`
+	SiteKey    string     `json:"site_key,omitempty"`
+	Puzzle     string     `json:"puzzle,omitempty"`
+	Difficulty int        `json:"difficulty,omitempty"`
+	Expires    *time.Time `json:"expires_at,omitempty"`
+}
+
+func (s *Server) handleChallenge(w http.ResponseWriter, r *http.Request) {
+	cc := s.config().Challenge
+	res := challengeResponse{Provider: cc.Provider}
+	switch cc.Provider {
+	case "hcaptcha", "turnstile":
+		res.SiteKey = cc.SiteKey
+	case "pow":
+		now := time.Now()
+		exp := now.Add(cc.TTL).UTC().Truncate(time.Second)
+		res.Puzzle, res.Difficulty, res.Expires = s.pow.Issue(cc.Difficulty, cc.TTL, now), cc.Difficulty, &exp
+	}
+	w.Header().Set("Cache-Control", "no-store") // puzzles are single-use
+	writeJSON(w, http.StatusOK, res)
+}
+
+// passChallenge checks the challenge response on a request that carries no API key, and
+// answers it itself when that fails. Requests with a valid key never need one.
+func (s *Server) passChallenge(w http.ResponseWriter, r *http.Request) bool {
+	cc := s.config().Challenge
+	if cc.Provider == "" || s.hasKey(r) {
+		return true
+	}
+	response := r.Header.Get(challengeHeader)
+	var err error
+	if cc.Provider == "pow" {
+		err = s.pow.Verify(response, cc.Difficulty, time.Now())
+	} else {
+		c := &challenge.Captcha{URL: challenge.VerifyURLs[cc.Provider], Secret: cc.Secret, SiteKey: cc.SiteKey, Client: captchaClient}
+		err = c.Verify(r.Context(), response, remoteIP(r))
+	}
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, challenge.ErrMissing):
+		writeError(w, r, http.StatusForbidden, "this request needs a solved challenge (see GET /api/challenge) or an API key")
+	case errors.Is(err, challenge.ErrRejected), errors.Is(err, challenge.ErrExpired), errors.Is(err, challenge.ErrReplayed):
+		writeError(w, r, http.StatusForbidden, err.Error())
+	case errors.Is(err, context.Canceled):
+		// the client gave up while we were asking the provider; nobody is left to answer
+	default:
+		s.logFor(r.Context()).Error("challenge: %v", err)
+		writeError(w, r, http.StatusServiceUnavailable, "could not verify the challenge, try again later")
+	}
+	return false
+}
+
+// hasKey reports whether r carries a valid user or static API key, or comes from a known
+// user of the embedding program.
+func (s *Server) hasKey(r *http.Request) bool {
+	if s.authn != nil {
+		owner, ok := s.authn(r)
+		return ok && owner != ""
+	}
+	token := bearerToken(r)
+	if token == "" {
+		return false
+	}
+	if _, ok := s.users.Authenticate(token); ok {
+		return true
+	}
+	_, ok := matchKey(token, s.config().Auth.Keys)
+	return ok
+}
+
+// remoteIP is the client address as seen by the CAPTCHA provider's risk checks.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+// captchaOrigins are what the web UI's CSP must allow for each provider's widget.
+var captchaOrigins = map[string]string{
+	"hcaptcha":  "https://hcaptcha.com https://*.hcaptcha.com",
+	"turnstile": "https://challenges.cloudflare.com",
+}
+
+// handleIndex serves the web UI, opening its CSP up to the CAPTCHA widget when one is in use.
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if origins, ok := captchaOrigins[s.config().Challenge.Provider]; ok {
+		if csp := w.Header().Get("Content-Security-Policy"); csp != "" {
+			w.Header().Set("Content-Security-Policy", allowSources(csp, origins, "script-src", "frame-src", "style-src", "connect-src"))
+		}
+	}
+	s.ui(r).ServeIndex(w, r)
+}
+
+// allowSources adds sources to the given CSP directives. A directive the policy doesn't have
+// falls back to default-src, so it's added as a copy of that plus sources; without a
+// default-src it's unrestricted already and left alone.
+func allowSources(policy, sources string, directives ...string) string {
+	var parts []string
+	def := ""
+	for _, p := range strings.Split(policy, ";") {
+		if p = strings.TrimSpace(p); p != "" {
+			parts = append(parts, p)
+			if name, value, _ := strings.Cut(p, " "); name == "default-src" {
+				def = value
+			}
+		}
+	}
+	for _, d := range directives {
+		found := false
+		for i, p := range parts {
+			if name, _, _ := strings.Cut(p, " "); name == d {
+				parts[i], found = p+" "+sources, true
+			}
+		}
+		if !found && def != "" {
+			parts = append(parts, d+" "+def+" "+sources)
+		}
+	}
+	return strings.Join(parts, "; ")
+}
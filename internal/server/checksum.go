@@ -0,0 +1,129 @@
+package server
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/hey-granth/filegoblin/internal/metadata"
+)
+
+// checksum is a digest uploads are hashed with on their way to storage. SHA-256 always is;
+// the others when integrity.checksums names them, or the client sent one to check against.
+type checksum struct {
+	name   string // as integrity.checksums and RFC 9530's Repr-Digest name it
+	label  string // for people
+	hex    string // the header carrying it in hex, as downloads do
+	base64 string // the header S3 clients send it in, base64
+	new    func() hash.Hash
+	field  func(*metadata.File) *string // where the record keeps it, in hex
+}
+
+var checksums = []checksum{
+	{"sha-256", "SHA-256", checksumHeader, "X-Amz-Checksum-Sha256", sha256.New, func(f *metadata.File) *string { return &f.SHA256 }},
+	{"md5", "MD5", "X-Checksum-MD5", "Content-MD5", md5.New, func(f *metadata.File) *string { return &f.MD5 }},
+	{"crc32c", "CRC32C", "X-Checksum-CRC32C", "X-Amz-Checksum-Crc32c",
+		func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) }, func(f *metadata.File) *string { return &f.CRC32C }},
+}
+
+// expectedSum is a checksum the client sent with an upload.
+type expectedSum struct {
+	checksum
+	header string
+	sum    []byte
+}
+
+// uploadHasher hashes an upload with the checksums it needs.
+type uploadHasher struct {
+	sums   []checksum
+	hashes []hash.Hash
+	expect []expectedSum
+}
+
+// newUploadHasher reads the checksums sent with r, from X-Checksum-* headers, the S3 ones
+// and Repr-Digest or Content-Digest, and picks what to hash the upload with. A checksum
+// that can't be read is an error: an upload it was meant to protect shouldn't go unchecked.
+func newUploadHasher(r *http.Request, extra []string) (*uploadHasher, error) {
+	u := &uploadHasher{}
+	for _, c := range checksums {
+		for _, h := range []string{c.hex, c.base64} {
+			v := strings.TrimSpace(r.Header.Get(h))
+			if v == "" {
+				continue
+			}
+			decode := base64.StdEncoding.DecodeString
+			if h == c.hex {
+				decode = hex.DecodeString
+			}
+			sum, err := decode(v)
+			if err != nil || len(sum) != c.new().Size() {
+				return nil, fmt.Errorf("%s is not a %s checksum", h, c.label)
+			}
+			u.expect = append(u.expect, expectedSum{c, h, sum})
+		}
+	}
+	for _, h := range []string{"Repr-Digest", "Content-Digest"} {
+		// RFC 9530: sha-256=:base64:, md5=:base64:
+		for _, item := range strings.Split(r.Header.Get(h), ",") {
+			alg, v, ok := strings.Cut(strings.TrimSpace(item), "=")
+			i := slices.IndexFunc(checksums, func(c checksum) bool { return strings.EqualFold(c.name, alg) })
+			if !ok || i < 0 {
+				continue // one we don't know; the others still count
+			}
+			c := checksums[i]
+			sum, err := base64.StdEncoding.DecodeString(strings.Trim(v, ":"))
+			if err != nil || len(sum) != c.new().Size() {
+				return nil, fmt.Errorf("%s has no readable %s checksum", h, c.label)
+			}
+			u.expect = append(u.expect, expectedSum{c, h, sum})
+		}
+	}
+	for _, c := range checksums {
+		if c.name == "sha-256" || slices.Contains(extra, c.name) ||
+			slices.ContainsFunc(u.expect, func(e expectedSum) bool { return e.name == c.name }) {
+			u.sums, u.hashes = append(u.sums, c), append(u.hashes, c.new())
+		}
+	}
+	return u, nil
+}
+
+// writer is what the upload is copied to on its way to storage.
+func (u *uploadHasher) writer() io.Writer {
+	ws := make([]io.Writer, len(u.hashes))
+	for i, h := range u.hashes {
+		ws[i] = h
+	}
+	return io.MultiWriter(ws...)
+}
+
+// record puts the checksums in f, and returns the first one the client sent that the
+// content doesn't match.
+func (u *uploadHasher) record(f *metadata.File) *checksumMismatch {
+	got := map[string][]byte{}
+	for i, c := range u.sums {
+		got[c.name] = u.hashes[i].Sum(nil)
+		*c.field(f) = hex.EncodeToString(got[c.name])
+	}
+	for _, e := range u.expect {
+		if sum := got[e.name]; string(sum) != string(e.sum) {
+			actual := base64.StdEncoding.EncodeToString(sum)
+			if e.header == e.checksum.hex {
+				actual = hex.EncodeToString(sum)
+			}
+			return &checksumMismatch{e.header, e.label, actual}
+		}
+	}
+	return nil
+}
+
+// checksumMismatch is a checksum sent in header that the content doesn't match, and the
+// content's actual one, encoded the way the header is.
+type checksumMismatch struct{ header, label, actual string }
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/cluster"
+	"github.com/hey-granth/filegoblin/internal/metadata"
+)
+
+// How long a change waits for another server to finish with the same state, and how old a
+// lock has to be before it counts as abandoned by a server that died holding it.
+const (
+	lockWait  = 10 * time.Second
+	lockStale = 2 * time.Minute
+)
+
+// leaser takes and keeps the leadership of the servers sharing the data directory.
+type leaser interface {
+	Acquire(now time.Time) (bool, error)
+	Release() error
+}
+
+// storeLease leads through a metadata store that coordinates the servers sharing it.
+type storeLease struct{ co metadata.Coordinator }
+
+func (l storeLease) Acquire(time.Time) (bool, error) { return l.co.Lead() }
+func (l storeLease) Release() error                  { return l.co.Resign() }
+
+// runCluster keeps this server's place among the servers sharing the data directory until ctx
+// is done: every lease_ttl/3 it takes or renews the leader's lease and picks up the changes
+// the other servers made. The lease is given up on the way out, so another server can take
+// over the background jobs straight away. It's a file in the data directory unless the
+// metadata store can hold it.
+func (s *Server) runCluster(ctx context.Context) {
+	cc := s.config().Cluster
+	var lease leaser = cluster.New(filepath.Join(s.config().DataDir, "cluster"), cc.NodeID, cc.LeaseTTL)
+	if co, ok := s.index.Store().(metadata.Coordinator); ok {
+		lease = storeLease{co}
+	}
+	for {
+		s.renewLease(lease, time.Now())
+		s.refreshShared()
+		select {
+		case <-ctx.Done():
+			if s.leader.Load() {
+				if err := lease.Release(); err != nil {
+					s.log.Error("cluster: release lease: %v", err)
+				}
+			}
+			return
+		case <-time.After(cc.LeaseTTL / 3):
+		}
+	}
+}
+
+// renewLease takes or renews the lease and logs when this server becomes or stops being the
+// leader. A lease that can't be renewed counts as lost: two leaders would be worse than none
+// for a while.
+func (s *Server) renewLease(lease leaser, now time.Time) {
+	ok, err := lease.Acquire(now)
+	if err != nil {
+		s.log.Error("cluster: renew lease: %v", err)
+	}
+	switch was := s.leader.Swap(ok); {
+	case ok && !was:
+		s.log.Info("cluster: %s is now the leader", s.config().Cluster.NodeID)
+	case !ok && was:
+		s.log.Info("cluster: %s is no longer the leader", s.config().Cluster.NodeID)
+	}
+}
+
+// refreshShared re-reads the state other servers may have changed: file records, users and
+// keys, link signing keys and abuse reports.
+func (s *Server) refreshShared() {
+	if err := s.index.Refresh(); err != nil {
+		s.log.Error("cluster: refresh metadata: %v", err)
+	}
+	if err := s.users.Reload(); err != nil {
+		s.log.Error("cluster: reload users: %v", err)
+	}
+	if err := s.links.Reload(); err != nil {
+		s.log.Error("cluster: reload signing keys: %v", err)
+	}
+	if err := s.reports.Reload(); err != nil {
+		s.log.Error("cluster: reload reports: %v", err)
+	}
+}
+
+// isLeader reports whether this server runs the background jobs: always when it runs alone.
+func (s *Server) isLeader() bool {
+	return !s.config().Cluster.Enabled() || s.leader.Load()
+}
+
+// lock takes the cluster-wide lock called name before a change another server might be making
+// at the same time, and returns the function that releases it: the metadata store's, if it
+// has locks, or a lock file's. A server on its own has nobody to wait for and gets a no-op.
+func (s *Server) lock(name string) (func(), error) {
+	if !s.config().Cluster.Enabled() {
+		return func() {}, nil
+	}
+	if co, ok := s.index.Store().(metadata.Coordinator); ok {
+		return co.Lock(name, lockWait)
+	}
+	return cluster.Lock(filepath.Join(s.config().DataDir, "cluster", "locks"), name, lockStale, lockWait)
+}
+
+// lockFile takes the lock for file id's record and re-reads it, so the change about to be
+// made starts from what the other servers last wrote.
+func (s *Server) lockFile(id string) (func(), error) {
+	if strings.ContainsAny(id, `/\.`) {
+		return func() {}, nil // not an ID we hand out: there is no record to guard
+	}
+	unlock, err := s.lock("file-" + id)
+	if err != nil || !s.config().Cluster.Enabled() {
+		return unlock, err
+	}
+	if err := s.index.Reread(id); err != nil {
+		unlock()
+		return nil, err
+	}
+	return unlock, nil
+}
+
+// exclusive wraps a handler that changes state shared with other servers: it runs holding the
+// lock called name, after reload has re-read that state.
+func (s *Server) exclusive(name string, reload func() error, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		unlock, err := s.lock(name)
+		if err != nil {
+			s.writeLockError(w, r, err)
+			return
+		}
+		defer unlock()
+		if s.config().Cluster.Enabled() {
+			if err := reload(); err != nil {
+				s.logFor(r.Context()).Error("cluster: reload %s: %v", name, err)
+				writeError(w, r, http.StatusInternalServerError, "internal error")
+				return
+			}
+		}
+		h(w, r)
+	}
+}
+
+func (s *Server) writeLockError(w http.ResponseWriter, r *http.Request, err error) {
+	s.logFor(r.Context()).Error("cluster: %v", err)
+	w.Header().Set("Retry-After", "5")
+	writeError(w, r, http.StatusServiceUnavailable, "busy, try again")
+}
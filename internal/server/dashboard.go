@@ -0,0 +1,162 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/abuse"
+	"github.com/hey-granth/filegoblin/internal/logx"
+	"github.com/hey-granth/filegoblin/internal/metadata"
+)
+
+// recentErrors is how many error log lines the dashboard keeps.
+const recentErrors = 50
+
+// statsResponse is the admin dashboard's overview of the server.
+type statsResponse struct {
+	Files        int                   `json:"files"`
+	Bytes        int64                 `json:"bytes"`
+	Users        int                   `json:"users"`
+	OpenReports  int                   `json:"open_reports"`
+	TopConsumers []metadata.OwnerUsage `json:"top_consumers"`
+	Transfers    []transferInfo        `json:"transfers"`
+	RecentErrors []logx.Entry          `json:"recent_errors"`
+	Lifecycle    lifecycleStats        `json:"lifecycle"`
+}
+
+// lifecycleStats sums up what the lifecycle sweep has done since the server started.
+type lifecycleStats struct {
+	LastSweep    *time.Time `json:"last_sweep,omitempty"`
+	LastExpired  int        `json:"last_expired"`  // files the last sweep deleted
+	TotalExpired int        `json:"total_expired"` // files deleted by all sweeps so far
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	files := s.index.List()
+	res := statsResponse{
+		Files:        len(files),
+		Users:        len(s.users.Users()),
+		OpenReports:  len(s.reports.List(abuse.StatusOpen)),
+		TopConsumers: metadata.TopOwners(files, 10),
+		Transfers:    s.transfers.list(),
+		RecentErrors: s.log.RecentErrors(),
+	}
+	for _, f := range files {
+		res.Bytes += f.StoredSize()
+	}
+	s.sweepMu.Lock()
+	res.Lifecycle = s.sweeps
+	s.sweepMu.Unlock()
+	writeJSON(w, http.StatusOK, res)
+}
+
+// recordSweep adds a finished sweep to the lifecycle stats.
+func (s *Server) recordSweep(now time.Time, expired int) {
+	s.sweepMu.Lock()
+	defer s.sweepMu.Unlock()
+	at := now.UTC()
+	s.sweeps.LastSweep = &at
+	s.sweeps.LastExpired = expired
+	s.sweeps.TotalExpired += expired
+}
+
+// transfer is an upload or download in progress.
+type transfer struct {
+	info  transferInfo
+	bytes atomic.Int64
+}
+
+// transferInfo is a snapshot of a transfer, as the dashboard shows it.
+type transferInfo struct {
+	ID        int64     `json:"id"`
+	Kind      string    `json:"kind"` // "upload" or "download"
+	File      string    `json:"file"` // the file ID of a download, the name of an upload
+	Owner     string    `json:"owner,omitempty"`
+	Remote    string    `json:"remote"`
+	StartedAt time.Time `json:"started_at"`
+	Size      int64     `json:"size"` // -1 when not known up front
+	Bytes     int64     `json:"bytes"`
+}
+
+// transferTracker keeps the transfers in progress.
+type transferTracker struct {
+	mu     sync.Mutex
+	next   int64
+	active map[int64]*transfer
+}
+
+func newTransferTracker() *transferTracker {
+	return &transferTracker{active: map[int64]*transfer{}}
+}
+
+func (tt *transferTracker) start(kind, file, owner, remote string, size int64) *transfer {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	tt.next++
+	t := &transfer{info: transferInfo{ID: tt.next, Kind: kind, File: file, Owner: owner, Remote: remote, StartedAt: time.Now().UTC(), Size: size}}
+	tt.active[t.info.ID] = t
+	return t
+}
+
+func (tt *transferTracker) done(t *transfer) {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	delete(tt.active, t.info.ID)
+}
+
+// downloading reports whether file id is being downloaded.
+func (tt *transferTracker) downloading(id string) bool {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	for _, t := range tt.active {
+		if t.info.Kind == "download" && t.info.File == id {
+			return true
+		}
+	}
+	return false
+}
+
+// list returns the transfers in progress, oldest first.
+func (tt *transferTracker) list() []transferInfo {
+	tt.mu.Lock()
+	out := make([]transferInfo, 0, len(tt.active))
+	for _, t := range tt.active {
+		info := t.info
+		info.Bytes = t.bytes.Load()
+		out = append(out, info)
+	}
+	tt.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// countingBody counts the bytes of a request body as they're read.
+type countingBody struct {
+	io.ReadCloser
+	n *atomic.Int64
+}
+
+func (b countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n.Add(int64(n))
+	return n, err
+}
+
+// countingWriter counts the bytes of a response as they're written.
+type countingWriter struct {
+	http.ResponseWriter
+	n *atomic.Int64
+}
+
+func (w countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.n.Add(int64(n))
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the real writer.
+func (w countingWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
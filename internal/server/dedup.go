@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/metadata"
+	"github.com/hey-granth/filegoblin/internal/plugin"
+	"github.com/hey-granth/filegoblin/internal/storage"
+)
+
+// checksumHeader carries an upload's SHA-256, in hex, as downloads do. An upload that has one
+// is refused if its content doesn't match, and with storage.dedup on it may be answered
+// before its body is sent: see uploadDuplicate.
+const checksumHeader = "X-Checksum-SHA256"
+
+// publish links f to its blob and records it in the index, or, when into isn't nil, makes it
+// into's new content and records into, keeping the content it replaces as a version. With
+// storage.dedup on, the blob just stored under f's ID is moved to its SHA-256, or dropped if
+// that content is stored already; encrypted uploads are kept apart, since their ciphertext
+// never repeats anyway.
+func (s *Server) publish(ctx context.Context, f, into *metadata.File) error {
+	s.blobMu.Lock()
+	defer s.blobMu.Unlock()
+	if s.config().Storage.Dedup && !f.Encrypted && f.SHA256 != "" {
+		stored := s.index.Refs(f.SHA256) > 0
+		if err := storage.Dedup(ctx, s.store, f.ID, f.SHA256, stored); err != nil && !stored {
+			s.discard(f.ID)
+			return err
+		} else if err != nil {
+			s.log.Error("delete blob %s: %v", f.ID, err)
+		}
+		f.Blob = f.SHA256
+	}
+	rec, pruned := f, []string(nil)
+	if into != nil {
+		rec = into
+		pruned = s.addVersion(into, f.Current(time.Now().UTC()))
+	}
+	if err := s.index.Put(rec); err != nil {
+		if s.index.Refs(f.BlobKey()) == 0 {
+			s.discard(f.BlobKey()) // don't leave an orphaned blob behind
+		}
+		return err
+	}
+	if err := s.dropBlobs(ctx, pruned); err != nil {
+		s.log.Error("delete pruned versions of %s: %v", rec.ID, err)
+	}
+	return nil
+}
+
+// dropBlob deletes the blobs of f, whose record is gone, unless other records still share them.
+func (s *Server) dropBlob(ctx context.Context, f *metadata.File) error {
+	s.blobMu.Lock()
+	defer s.blobMu.Unlock()
+	return s.dropBlobs(ctx, f.BlobKeys())
+}
+
+// dropBlobs deletes the blobs under keys that no record needs any more; the caller holds
+// blobMu.
+func (s *Server) dropBlobs(ctx context.Context, keys []string) error {
+	var errs []error
+	for _, key := range keys {
+		if s.index.HasBlob(key) {
+			continue
+		}
+		if err := s.store.Delete(ctx, key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// uploadDuplicate answers a raw upload whose X-Checksum-SHA256 matches a file its owner
+// already stored without reading the body, if it was left out or the client sent "Expect:
+// 100-continue" and is waiting to send it. It reports whether it answered. Only the owner's
+// own files count; matching anyone's would let a client find out whether someone else has
+// stored a given file.
+func (s *Server) uploadDuplicate(w http.ResponseWriter, r *http.Request, owner, name string) bool {
+	sum := strings.ToLower(r.Header.Get(checksumHeader))
+	if !s.config().Storage.Dedup || owner == "" || name == "" || r.URL.Query().Get("e2e") == "1" || r.URL.Query().Get("path") != "" {
+		return false
+	}
+	if r.ContentLength != 0 && !strings.EqualFold(r.Header.Get("Expect"), "100-continue") {
+		return false // it's on its way: store it, and check it against the checksum
+	}
+	if b, err := hex.DecodeString(sum); err != nil || len(b) != 32 || s.index.Refs(sum) == 0 {
+		return false
+	}
+	var same *metadata.File
+	for _, f := range s.index.List() {
+		if f.Owner == owner && f.Blob == sum && f.TakenDown == nil && f.Trashed == nil && !f.Quarantined() {
+			same = f
+			break
+		}
+	}
+	if same == nil {
+		return false
+	}
+	f := &metadata.File{
+		ID:               newID(),
+		Name:             name,
+		Size:             same.Size,
+		ContentType:      same.ContentType,
+		SHA256:           same.SHA256,
+		Blob:             same.Blob,
+		Owner:            owner,
+		CreatedAt:        time.Now().UTC(),
+		Scan:             same.Scan,
+		DLP:              same.DLP,
+		SlowStart:        same.SlowStart,
+		MetadataStripped: same.MetadataStripped,
+	}
+	if !s.uploadOptions(w, r, f) {
+		return true
+	}
+	if status, msg := s.runPlugins(r.Context(), plugin.PreUpload, r, f); status != 0 {
+		writeError(w, r, status, msg)
+		return true
+	}
+	s.blobMu.Lock()
+	shared := s.index.Refs(sum) > 0 // the owner may have deleted it since
+	var err error
+	if shared {
+		err = s.index.Put(f)
+	}
+	s.blobMu.Unlock()
+	if !shared {
+		return false
+	}
+	log := s.logFor(r.Context()).Named("upload").With("file_id", f.ID).With("owner", ownerLabel(owner))
+	if err != nil {
+		log.ErrorE(err, "index")
+		writeError(w, r, http.StatusInternalServerError, "could not store upload")
+		return true
+	}
+	log.Info("uploaded %q, %d bytes, a duplicate of %s", f.Name, f.Size, same.ID)
+	s.audit(r, "file_uploaded", f.ID, "%s uploaded %q, %d bytes", ownerLabel(owner), f.Name, f.Size)
+	s.postProcess(f)
+	s.notifyFile("upload", f)
+	writeJSON(w, http.StatusCreated, s.fileResponse(r, f))
+	return true
+}
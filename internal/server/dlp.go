@@ -0,0 +1,50 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/hey-granth/filegoblin/internal/dlp"
+	"github.com/hey-granth/filegoblin/internal/metadata"
+)
+
+// checkContent runs the DLP rules over a stored text upload. Matches are audited; tag rules
+// mark the file. When a block rule matches it discards the blob, answers the request and
+// returns false.
+func (s *Server) checkContent(w http.ResponseWriter, r *http.Request, f *metadata.File) bool {
+	scanner, err := dlp.New(s.config().DLP)
+	if err != nil || scanner == nil || !textual(baseType(f.ContentType)) {
+		return true // config validation rejects bad rules, so err can't happen here
+	}
+	rc, err := s.store.Get(r.Context(), f.BlobKey())
+	if err != nil {
+		s.logFor(r.Context()).Error("dlp %s: %v", f.ID, err)
+		s.discard(f.ID)
+		writeError(w, r, http.StatusInternalServerError, "could not store upload")
+		return false
+	}
+	matches, err := scanner.Scan(rc)
+	rc.Close()
+	if err != nil {
+		s.logFor(r.Context()).Error("dlp %s: %v", f.ID, err)
+		s.discard(f.ID)
+		writeError(w, r, http.StatusInternalServerError, "could not store upload")
+		return false
+	}
+	var blocked []string
+	for _, m := range matches {
+		s.audit(r, "dlp_match", f.ID, "%s (%q from %s) matched %s %d times, action %s", f.ID, f.Name, ownerLabel(f.Owner), m.Rule, m.Count, m.Action)
+		switch m.Action {
+		case dlp.ActionTag:
+			f.DLP = append(f.DLP, m.Rule)
+		case dlp.ActionBlock:
+			blocked = append(blocked, m.Rule)
+		}
+	}
+	if len(blocked) > 0 {
+		s.discard(f.ID)
+		writeErrorf(w, r, http.StatusUnprocessableEntity, "upload refused: it contains %s", strings.Join(blocked, ", "))
+		return false
+	}
+	return true
+}
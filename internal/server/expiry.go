@@ -0,0 +1,187 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/metadata"
+)
+
+// parseExpiry reads how long a file should be kept: a Go duration like "90m" or "24h", a
+// number of days like "7d", or "never". It returns 0 for never.
+func parseExpiry(s string) (time.Duration, error) {
+	if s == "never" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, nerr := strconv.Atoi(days)
+		d, err = time.Duration(n)*24*time.Hour, nerr
+	}
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("expiry %q must be a positive duration like 24h or 7d, or never", s)
+	}
+	return d, nil
+}
+
+// parseExpiresAt reads an expiry given as a time, in RFC 3339, which must be after now.
+func parseExpiresAt(s string, now time.Time) (time.Time, error) {
+	at, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expires_at %q must be a time like 2026-01-02T15:04:05Z", s)
+	}
+	if !at.After(now) {
+		return time.Time{}, errors.New("expires_at must be in the future")
+	}
+	return at.UTC().Truncate(time.Second), nil
+}
+
+// parseMaxDownloads reads how many times a file may be downloaded before it expires; 0
+// means no limit.
+func parseMaxDownloads(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("max_downloads %q must be a whole number, or 0 for no limit", s)
+	}
+	return n, nil
+}
+
+// uploadExpiry applies what an upload asks for of its retention to f: expires (or
+// expires_in), counted from the upload, or expires_at, and max_downloads.
+func uploadExpiry(r *http.Request, f *metadata.File) error {
+	q := r.URL.Query()
+	in := q.Get("expires")
+	if in == "" {
+		in = q.Get("expires_in")
+	}
+	switch at := q.Get("expires_at"); {
+	case in != "" && at != "":
+		return errors.New("give expires_in or expires_at, not both")
+	case in != "":
+		d, err := parseExpiry(in)
+		if err != nil {
+			return err
+		}
+		if d > 0 {
+			at := f.CreatedAt.Add(d).Truncate(time.Second)
+			f.ExpiresAt = &at
+		}
+	case at != "":
+		t, err := parseExpiresAt(at, f.CreatedAt)
+		if err != nil {
+			return err
+		}
+		f.ExpiresAt = &t
+	}
+	if v := q.Get("max_downloads"); v != "" {
+		n, err := parseMaxDownloads(v)
+		if err != nil {
+			return err
+		}
+		f.MaxDownloads = n
+	}
+	return nil
+}
+
+// handleUpdateFile changes a file's settings: when it expires, how many downloads it may
+// have, its description and tags, and its own metadata. Like delete, it needs an API key
+// and is limited to the caller's own files.
+func (s *Server) handleUpdateFile(w http.ResponseWriter, r *http.Request) {
+	owner, ok := s.authorize(r)
+	if !ok {
+		writeError(w, r, http.StatusUnauthorized, "missing or invalid API key")
+		return
+	}
+	unlock, err := s.lockFile(r.PathValue("id"))
+	if err != nil {
+		s.writeLockError(w, r, err)
+		return
+	}
+	defer unlock()
+	f, err := s.liveFile(r.PathValue("id"))
+	if err != nil || (owner != "" && f.Owner != owner) {
+		writeError(w, r, http.StatusNotFound, "file not found")
+		return
+	}
+	var req struct {
+		Expires      *string            `json:"expires"`    // see parseExpiry; counted from now
+		ExpiresIn    *string            `json:"expires_in"` // the same
+		ExpiresAt    *string            `json:"expires_at"`
+		MaxDownloads *int               `json:"max_downloads"` // 0 lifts the limit; downloads so far still count
+		Description  *string            `json:"description"`
+		Tags         *[]string          `json:"tags"` // replacing those it has
+		Meta         *map[string]string `json:"meta"` // likewise
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if req.Expires == nil {
+		req.Expires = req.ExpiresIn
+	}
+	if req.Expires != nil && req.ExpiresAt != nil {
+		writeError(w, r, http.StatusBadRequest, "give expires_in or expires_at, not both")
+		return
+	}
+	if req.Expires != nil {
+		d, err := parseExpiry(*req.Expires)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		f.ExpiresAt = nil
+		if d > 0 {
+			at := time.Now().Add(d).UTC().Truncate(time.Second)
+			f.ExpiresAt = &at
+		}
+	}
+	if req.ExpiresAt != nil {
+		at, err := parseExpiresAt(*req.ExpiresAt, time.Now())
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		f.ExpiresAt = &at
+	}
+	if req.MaxDownloads != nil {
+		if *req.MaxDownloads < 0 {
+			writeError(w, r, http.StatusBadRequest, "max_downloads must be a whole number, or 0 for no limit")
+			return
+		}
+		f.MaxDownloads = *req.MaxDownloads
+	}
+	if req.Description != nil {
+		if err := checkDescription(*req.Description); err != nil {
+			writeError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		f.Description = *req.Description
+	}
+	if req.Tags != nil {
+		tags, err := cleanTags(*req.Tags)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		f.Tags = tags
+	}
+	if req.Meta != nil {
+		meta, err := cleanMeta(*req.Meta)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		f.Meta = meta
+	}
+	if err := s.index.Put(f); err != nil {
+		s.logFor(r.Context()).Error("index %s: %v", f.ID, err)
+		writeError(w, r, http.StatusInternalServerError, "could not update file")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.fileResponse(r, f))
+}
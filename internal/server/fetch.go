@@ -0,0 +1,86 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"net/netip"
+	"path"
+
+	"github.com/hey-granth/filegoblin/internal/fetch"
+)
+
+// handleFetch uploads a file by URL: the server downloads it and stores it as if it had been
+// uploaded. See package fetch for what keeps this from being an open proxy into our network.
+func (s *Server) handleFetch(w http.ResponseWriter, r *http.Request) {
+	cfg := s.config().Fetch
+	if !cfg.Enabled {
+		writeError(w, r, http.StatusForbidden, "fetching URLs is disabled on this server")
+		return
+	}
+	owner, ok := s.authorizeUpload(r)
+	if !ok {
+		writeError(w, r, http.StatusUnauthorized, "missing or invalid API key")
+		return
+	}
+	if !s.passChallenge(w, r) {
+		return
+	}
+	var in struct {
+		URL  string `json:"url"`
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&in); err != nil || in.URL == "" {
+		writeError(w, r, http.StatusBadRequest, `expected {"url": "...", "name": "..."}`)
+		return
+	}
+	limit, quotaBound, err := s.uploadLimit(owner)
+	if err != nil {
+		writeError(w, r, http.StatusInsufficientStorage, err.Error())
+		return
+	}
+	if most := int64(cfg.MaxSize); limit <= 0 || most < limit {
+		limit, quotaBound = most, false // uploads may be unlimited, fetches never are
+	}
+
+	opts := fetch.Options{Timeout: cfg.Timeout, MaxRedirects: cfg.MaxRedirects}
+	for _, n := range cfg.AllowNetworks {
+		if p, err := netip.ParsePrefix(n); err == nil { // validated with the config
+			opts.Allow = append(opts.Allow, p)
+		}
+	}
+	c := fetch.NewClient(opts)
+	defer c.CloseIdleConnections()
+	resp, err := fetch.Get(r.Context(), c, opts, in.URL)
+	if err != nil {
+		if errors.Is(err, fetch.ErrBlocked) {
+			s.logFor(r.Context()).Info("fetch refused for %s: %v", ownerLabel(owner), err)
+			writeError(w, r, http.StatusForbidden, "that address may not be fetched")
+			return
+		}
+		writeError(w, r, http.StatusBadGateway, err.Error())
+		return
+	}
+	defer resp.Body.Close()
+	if resp.ContentLength > limit {
+		if quotaBound {
+			writeError(w, r, http.StatusInsufficientStorage, "file exceeds your storage quota")
+			return
+		}
+		writeError(w, r, http.StatusRequestEntityTooLarge, "file exceeds the size limit")
+		return
+	}
+	body := http.MaxBytesReader(w, resp.Body, limit)
+
+	name := in.Name
+	if name == "" {
+		if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+			name = params["filename"]
+		}
+	}
+	if name == "" {
+		name = path.Base(resp.Request.URL.Path) // the final URL, after redirects
+	}
+	s.ingest(w, r, owner, cleanName(name), body, quotaBound, false)
+}
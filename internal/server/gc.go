@@ -0,0 +1,45 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/config"
+	"github.com/hey-granth/filegoblin/internal/fsck"
+)
+
+// runGC collects orphaned blobs and leftover uploads every lifecycle.gc_interval until ctx is
+// done. Expired files are the lifecycle sweep's to remove, so that webhooks hear of them;
+// this only picks up what the sweep, a crash or an interrupted upload leaves. The settings
+// are read fresh each time, and in a cluster only the leader collects.
+func (s *Server) runGC(ctx context.Context) {
+	for {
+		every := s.config().Lifecycle.GCInterval
+		if every <= 0 {
+			every = time.Minute // off; look again later
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(every):
+		}
+		lc := s.config().Lifecycle
+		if lc.GCInterval <= 0 || !s.isLeader() {
+			continue
+		}
+		minAge := lc.GCMinAge
+		if minAge <= 0 {
+			minAge = time.Hour
+		}
+		// blobMu keeps an upload from sharing a blob as it's deleted
+		res, err := fsck.Collect(ctx, s.store, s.index, fsck.GCOptions{MinAge: minAge, Lock: &s.blobMu,
+			Progress: func(kind, id string) { s.log.Debug("gc: removed %s %s", kind, id) }})
+		if err != nil && ctx.Err() == nil {
+			s.log.Error("gc: %v", err)
+		}
+		if res.Orphans > 0 || res.TempFiles > 0 {
+			s.log.Info("gc: removed %d orphaned blobs (%s) and %d temporary files",
+				res.Orphans, config.ByteSize(res.OrphanBytes), res.TempFiles)
+		}
+	}
+}
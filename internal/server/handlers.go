@@ -0,0 +1,737 @@
+package server
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/e2e"
+	"github.com/hey-granth/filegoblin/internal/i18n"
+	"github.com/hey-granth/filegoblin/internal/logx"
+	"github.com/hey-granth/filegoblin/internal/metadata"
+	"github.com/hey-granth/filegoblin/internal/plugin"
+	"github.com/hey-granth/filegoblin/internal/signing"
+	"github.com/hey-granth/filegoblin/internal/storage"
+	"github.com/hey-granth/filegoblin/internal/thumb"
+)
+
+// fileResponse is what the API returns for a single file: the stored record plus its share link.
+type fileResponse struct {
+	*metadata.File
+	URL         string     `json:"url"`
+	LinkExpires *time.Time `json:"link_expires_at,omitempty"` // set when links are signed
+	Protected   bool       `json:"password_protected,omitempty"`
+	Thumbnail   string     `json:"thumbnail_url,omitempty"` // for images, when thumbnails are on
+	Poster      string     `json:"poster_url,omitempty"`    // for videos, when media previews are on
+	Clip        string     `json:"clip_url,omitempty"`      // likewise
+	Preview     string     `json:"preview_url,omitempty"`   // for text, CSV and PDF files
+	Share       string     `json:"share_url,omitempty"`     // landing page for people, when the web UI is on
+	// PasswordHash shadows the record's field, which encoding/json then leaves out: the
+	// hash stays on the server.
+	PasswordHash string `json:"password_hash,omitempty"`
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleUpload accepts either a raw request body (name in ?name= or X-Filename) or a
+// multipart form with a "file" field, and streams it straight into the storage backend.
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	owner, ok := s.authorizeUpload(r)
+	if !ok {
+		writeError(w, r, http.StatusUnauthorized, "missing or invalid API key")
+		return
+	}
+	if !s.passChallenge(w, r) {
+		return
+	}
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		name = r.Header.Get("X-Filename")
+	}
+	t := s.transfers.start("upload", name, owner, remoteIP(r), r.ContentLength)
+	defer s.transfers.done(t)
+	r.Body = countingBody{r.Body, &t.bytes}
+	limit, quotaBound, err := s.uploadLimit(owner)
+	if err != nil {
+		s.notifyQuota(owner)
+		writeError(w, r, http.StatusInsufficientStorage, err.Error())
+		return
+	}
+	if limit > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+	}
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") && s.uploadDuplicate(w, r, owner, name) {
+		return
+	}
+	name, body, err := uploadSource(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.ingest(w, r, owner, name, body, quotaBound, r.URL.Query().Get("e2e") == "1")
+}
+
+// ingest stores an upload's body, records it in the index and writes the API response. It's
+// shared by every way a file can come in.
+func (s *Server) ingest(w http.ResponseWriter, r *http.Request, owner, name string, body io.Reader, quotaBound, encrypted bool) {
+	id := newID()
+	log := s.logFor(r.Context()).Named("upload").With("file_id", id).With("owner", ownerLabel(owner))
+	br := bufio.NewReader(body)
+	head, _ := br.Peek(512) // a short or empty body is fine here, Put will see the same bytes
+	f := &metadata.File{
+		ID:          id,
+		Name:        name,
+		ContentType: DetectContentType(name, head),
+		Owner:       owner,
+		CreatedAt:   time.Now().UTC(),
+	}
+	if !s.uploadOptions(w, r, f) {
+		return
+	}
+	if encrypted {
+		// the content is ciphertext and the name is sealed: nothing about either can be
+		// inspected, so it's stored as an opaque blob and never sniffed, scanned or previewed
+		if !e2e.IsEncrypted(head) || !strings.HasPrefix(name, e2e.NamePrefix) {
+			writeError(w, r, http.StatusBadRequest, "e2e upload is not in the filegoblin encrypted format")
+			return
+		}
+		f.Encrypted = true
+		f.ContentType = "application/octet-stream"
+	} else if !s.checkMagic(w, r, owner, name, head) {
+		return
+	}
+	var src io.Reader = br
+	if !f.Encrypted && s.wantStrip(r, f) {
+		src, f.MetadataStripped = &stripReader{r: br}, true
+	}
+	sums, err := newUploadHasher(r, s.config().Integrity.Checksums)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !s.beginUpload(w, r, id) {
+		return
+	}
+	defer s.endUpload(id)
+	// hash on the way through, so the checksums cost no extra read of the blob
+	f.Size, err = s.store.Put(storage.WithContentType(r.Context(), f.ContentType), id, io.TeeReader(src, sums.writer()))
+	if err != nil {
+		if errors.Is(err, errStripTooLarge) {
+			writeError(w, r, http.StatusRequestEntityTooLarge, err.Error())
+			return
+		}
+		if errors.Is(err, thumb.ErrMalformed) || errors.Is(err, thumb.ErrUnsupported) {
+			writeError(w, r, http.StatusUnprocessableEntity, "image could not be read to strip its metadata")
+			return
+		}
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			if quotaBound {
+				s.notifyQuota(owner)
+				writeError(w, r, http.StatusInsufficientStorage, "upload exceeds your storage quota")
+				return
+			}
+			writeError(w, r, http.StatusRequestEntityTooLarge, "upload exceeds the size limit")
+			return
+		}
+		log.ErrorE(err, "store")
+		writeError(w, r, http.StatusInternalServerError, "could not store upload")
+		return
+	}
+	if m := sums.record(f); m != nil {
+		s.discard(id)
+		writeErrorf(w, r, http.StatusBadRequest, "content doesn't match %s: its %s is %s", m.header, m.label, m.actual)
+		return
+	}
+	if ca, ok := s.store.(storage.ContentAddressed); ok {
+		if f.CID, err = ca.CID(r.Context(), id); err != nil {
+			log.Warn("no IPFS CID: %v", err) // it still downloads, just not from the network
+		}
+	}
+	if !f.Encrypted && !s.scanUpload(w, r, f) {
+		return
+	}
+	if !f.Encrypted && !s.checkContent(w, r, f) {
+		return
+	}
+	if status, msg := s.runPlugins(r.Context(), plugin.PreUpload, r, f); status != 0 {
+		s.discard(id)
+		writeError(w, r, status, msg)
+		return
+	}
+	s.checkFastStart(r.Context(), f)
+	into, unlock, err := s.replaces(f)
+	if err != nil {
+		s.discard(id)
+		s.writeLockError(w, r, err)
+		return
+	}
+	defer unlock()
+	if into != nil {
+		// what was asked for with the upload applies to the file it becomes
+		if f.ExpiresAt != nil {
+			into.ExpiresAt = f.ExpiresAt
+		}
+		if f.MaxDownloads > 0 {
+			into.MaxDownloads, into.Downloads = f.MaxDownloads, 0
+		}
+		if f.PasswordHash != "" {
+			into.PasswordHash = f.PasswordHash
+		}
+		if f.Description != "" {
+			into.Description = f.Description
+		}
+		if f.Tags != nil {
+			into.Tags = f.Tags
+		}
+		if f.Meta != nil {
+			into.Meta = f.Meta
+		}
+	}
+	if err := s.publish(r.Context(), f, into); err != nil {
+		log.ErrorE(err, "index")
+		writeError(w, r, http.StatusInternalServerError, "could not store upload")
+		return
+	}
+	if into != nil {
+		log.Info("uploaded %q, %d bytes, as a new version of %s", f.Name, f.Size, into.ID)
+		s.audit(r, "file_uploaded", into.ID, "%s uploaded %q, %d bytes, as version %s of %s", ownerLabel(owner), f.Name, f.Size, f.ID, into.ID)
+		s.dropCache(into.ID)
+		f = into
+	} else {
+		log.Info("uploaded %q, %d bytes", f.Name, f.Size)
+		s.audit(r, "file_uploaded", f.ID, "%s uploaded %q, %d bytes", ownerLabel(owner), f.Name, f.Size)
+	}
+	s.postProcess(f)
+	s.notifyFile("upload", f)
+	writeJSON(w, http.StatusCreated, s.fileResponse(r, f))
+}
+
+// uploadOptions applies what an upload asks for besides its content, an expiry or download
+// limit, a path and a password, to f. It reports false, having answered, if they're no good.
+func (s *Server) uploadOptions(w http.ResponseWriter, r *http.Request, f *metadata.File) bool {
+	if err := uploadExpiry(r, f); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return false
+	}
+	if err := uploadLabels(r, f); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return false
+	}
+	meta, err := uploadMeta(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return false
+	}
+	f.Meta = meta
+	p, ok := uploadPath(r)
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, "path is not a valid file path")
+		return false
+	}
+	if _, keyed := s.authorize(r); p != "" && !keyed {
+		// anonymous uploads all share an owner, so they'd replace each other's files
+		writeError(w, r, http.StatusBadRequest, "uploads with a path need an API key")
+		return false
+	}
+	f.Path = p
+	if password := r.Header.Get(passwordHeader); password != "" {
+		if len(password) > maxPasswordLen {
+			writeError(w, r, http.StatusBadRequest, "password is too long")
+			return false
+		}
+		var err error
+		if f.PasswordHash, err = hashPassword(password); err != nil {
+			s.logFor(r.Context()).ErrorE(err, "hash password", "file_id", f.ID)
+			writeError(w, r, http.StatusInternalServerError, "could not store upload")
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	owner, ok := s.authorize(r)
+	if !ok {
+		writeError(w, r, http.StatusUnauthorized, "missing or invalid API key")
+		return
+	}
+	tagged, err := parseTagFilter(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter := metaFilter(r)
+	out := []fileResponse{}
+	for _, f := range s.index.List() {
+		if (owner != "" && f.Owner != owner) || f.Trashed != nil || !matchMeta(f, filter) || !tagged.match(f) {
+			continue
+		}
+		out = append(out, s.fileResponse(r, f))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) handleStat(w http.ResponseWriter, r *http.Request) {
+	f, err := s.liveFile(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, "file not found")
+		return
+	}
+	if f.Expired(time.Now()) {
+		writeError(w, r, http.StatusGone, "file expired")
+		return
+	}
+	if !s.linkAllowed(r, f) {
+		writeError(w, r, http.StatusForbidden, "link expired or invalid")
+		return
+	}
+	if status, msg := s.passwordAllowed(w, r, f); status != 0 {
+		writeError(w, r, status, msg)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.fileResponse(r, f))
+}
+
+func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
+	owner, ok := s.authorize(r)
+	if !ok {
+		writeError(w, r, http.StatusUnauthorized, "missing or invalid API key")
+		return
+	}
+	id := r.PathValue("id")
+	unlock, err := s.lockFile(id)
+	if err != nil {
+		s.writeLockError(w, r, err)
+		return
+	}
+	defer unlock()
+	f, err := s.liveFile(id)
+	if err != nil || (owner != "" && f.Owner != owner) {
+		// same answer for "missing" and "not yours", so IDs can't be probed
+		writeError(w, r, http.StatusNotFound, "file not found")
+		return
+	}
+	if f.TakenDown != nil {
+		writeError(w, r, http.StatusConflict, "file was taken down and is kept for review")
+		return
+	}
+	// with a trash, deleting only moves the file there, unless ?purge=1 asks for it gone now
+	if s.config().Lifecycle.TrashFor > 0 && r.URL.Query().Get("purge") != "1" {
+		now := time.Now().UTC()
+		f.Trashed = &now
+		if err := s.index.Put(f); err != nil {
+			s.logFor(r.Context()).ErrorE(err, "trash", "file_id", id)
+			writeError(w, r, http.StatusInternalServerError, "could not delete file")
+			return
+		}
+		s.audit(r, "file_trashed", id, "%s moved %s to the trash", ownerLabel(owner), id)
+		s.notifyFile("delete", f)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err := s.removeFile(r.Context(), f); err != nil {
+		s.logFor(r.Context()).ErrorE(err, "delete", "file_id", id)
+		writeError(w, r, http.StatusInternalServerError, "could not delete file")
+		return
+	}
+	s.audit(r, "file_deleted", id, "%s deleted %s", ownerLabel(owner), id)
+	s.notifyFile("delete", f)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
+	f, err := s.liveFile(r.PathValue("id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !s.linkAllowed(r, f) {
+		textError(w, r, "link expired or invalid", http.StatusForbidden)
+		return
+	}
+	if !s.withVersion(w, r, f) {
+		return
+	}
+	if f.TakenDown != nil {
+		textError(w, r, "file taken down following an abuse report", http.StatusGone)
+		return
+	}
+	if f.Expired(time.Now()) {
+		textError(w, r, "file expired", http.StatusGone)
+		return
+	}
+	if status, msg := s.passwordAllowed(w, r, f); status != 0 {
+		textError(w, r, msg, status)
+		return
+	}
+	if f.Quarantined() {
+		textError(w, r, "file quarantined by virus scan", http.StatusForbidden)
+		return
+	}
+	if status, msg := s.runPlugins(r.Context(), plugin.PreDownload, r, f); status != 0 {
+		textError(w, r, msg, status)
+		return
+	}
+	// players fetch a video in many ranges, seeking back and forth; rehashing the whole file
+	// for each would make seeking crawl, so only requests from the start are checked
+	if rangeFromStart(r) && !s.verifyBeforeServe(r.Context(), f) {
+		textError(w, r, "file failed its integrity check", http.StatusInternalServerError)
+		return
+	}
+	if s.redirectDownload(w, r, f) {
+		return
+	}
+	rc, encoding, err := s.openDownload(r, f)
+	if err != nil {
+		s.logFor(r.Context()).ErrorE(err, "open blob", "file_id", f.ID)
+		textError(w, r, "file unavailable", http.StatusInternalServerError)
+		return
+	}
+	defer rc.Close()
+	if r.Method == http.MethodGet && rangeFromStart(r) {
+		// only once per download, not for every range a player asks for as it seeks
+		if !s.countDownload(w, r, f) {
+			return
+		}
+		s.noteDownload(r, f)
+	}
+	t := s.transfers.start("download", f.ID, f.Owner, remoteIP(r), f.Size)
+	defer s.transfers.done(t)
+	w = countingWriter{w, &t.bytes}
+
+	// ?inline=1 lets images, media and PDFs open in the browser; everything else, HTML and
+	// SVG in particular, is downloaded unless types.inline_allow names it
+	disposition := "attachment"
+	if r.URL.Query().Get("inline") == "1" && s.inlineAllowed(f.ContentType) {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Type", f.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": f.Name}))
+	w.Header().Set("Content-Security-Policy", userContentCSP)
+	setDigestHeaders(w, r, f)
+	setMetaHeaders(w, f)
+	if f.CID != "" && !f.Encrypted {
+		// what gateways send, so IPFS-aware browsers can fetch it from the network instead
+		w.Header().Set("X-Ipfs-Path", "/ipfs/"+f.CID)
+	}
+	if _, ok := s.store.(storage.EncodedGetter); ok {
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+	if encoding != "" {
+		w.Header().Set("Content-Encoding", encoding)
+		w.Header().Del("Digest") // of the content as sent, which it no longer is
+	}
+	if rs, ok := rc.(io.ReadSeeker); ok {
+		modified := f.CreatedAt
+		if f.ModTime != nil { // imported: the time the file had before
+			modified = *f.ModTime
+		}
+		http.ServeContent(w, r, f.Name, modified, rs) // handles Range and If-Modified-Since for us
+		return
+	}
+	if _, err := io.Copy(w, rc); err != nil {
+		s.logFor(r.Context()).ErrorE(logx.Expected(err), "send", "file_id", f.ID)
+	}
+}
+
+// openDownload opens f's blob to send it, as it's stored if it's stored compressed and the
+// client takes it that way, which saves decompressing it; encoding is its Content-Encoding
+// then. Range requests get the content, since their ranges are of that.
+func (s *Server) openDownload(r *http.Request, f *metadata.File) (rc io.ReadCloser, encoding string, err error) {
+	eg, ok := s.store.(storage.EncodedGetter)
+	if ok && r.Header.Get("Range") == "" && acceptsEncoding(r.Header.Get("Accept-Encoding"), "gzip") {
+		return eg.GetEncoded(r.Context(), f.BlobKey())
+	}
+	rc, err = s.store.Get(r.Context(), f.BlobKey())
+	return rc, "", err
+}
+
+// acceptsEncoding reads an Accept-Encoding header like "gzip, br;q=0.8" and reports whether
+// it takes encoding.
+func acceptsEncoding(accept, encoding string) bool {
+	for _, item := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(item), ";")
+		if !strings.EqualFold(name, encoding) && name != "*" {
+			continue
+		}
+		q, ok := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q=")
+		if ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// redirectDownload sends a GET for f to a URL the storage backend signed, if
+// storage.direct_downloads is on, and reports whether it did. Files opened inline stay with
+// the server for its Content-Security-Policy, and encrypted ones for the decryption page,
+// which fetches them from its own origin.
+func (s *Server) redirectDownload(w http.ResponseWriter, r *http.Request, f *metadata.File) bool {
+	ttl := s.config().Storage.DirectDownloads
+	signer, ok := s.store.(storage.URLSigner)
+	if ttl <= 0 || !ok || r.Method != http.MethodGet || f.Encrypted || r.URL.Query().Get("inline") == "1" {
+		return false
+	}
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": f.Name})
+	u, err := signer.SignedURL(f.BlobKey(), ttl, disposition, f.ContentType)
+	if err != nil {
+		s.logFor(r.Context()).ErrorE(err, "sign download URL", "file_id", f.ID)
+		return false
+	}
+	if rangeFromStart(r) {
+		if !s.countDownload(w, r, f) {
+			return true
+		}
+		s.noteDownload(r, f)
+	}
+	w.Header().Set("Cache-Control", "no-store") // the URL expires
+	http.Redirect(w, r, u, http.StatusFound)
+	return true
+}
+
+// countDownload counts a download of f if it has a download limit, and reports false,
+// having answered, if that's used up: by downloads started since f was read, say.
+func (s *Server) countDownload(w http.ResponseWriter, r *http.Request, f *metadata.File) bool {
+	if f.MaxDownloads == 0 {
+		return true
+	}
+	unlock, err := s.lockFile(f.ID)
+	if err != nil {
+		s.logFor(r.Context()).Error("cluster: %v", err)
+		w.Header().Set("Retry-After", "5")
+		textError(w, r, "busy, try again", http.StatusServiceUnavailable)
+		return false
+	}
+	defer unlock()
+	s.countMu.Lock()
+	defer s.countMu.Unlock()
+	cur, err := s.index.Get(f.ID)
+	if err != nil || cur.Expired(time.Now()) {
+		textError(w, r, "file expired", http.StatusGone)
+		return false
+	}
+	cur.Downloads++
+	if err := s.index.Put(cur); err != nil {
+		s.logFor(r.Context()).ErrorE(err, "index", "file_id", f.ID)
+		textError(w, r, "file unavailable", http.StatusInternalServerError)
+		return false
+	}
+	f.Downloads = cur.Downloads
+	return true
+}
+
+// noteDownload tells webhooks and, if asked to, the audit trail that f was downloaded.
+func (s *Server) noteDownload(r *http.Request, f *metadata.File) {
+	s.notifyFile("download", f)
+	if s.config().Audit.Downloads {
+		s.audit(r, "file_downloaded", f.ID, "%q downloaded", f.Name)
+	}
+}
+
+func (s *Server) fileResponse(r *http.Request, f *metadata.File) fileResponse {
+	res := fileResponse{File: f, URL: s.baseURL(r) + "/f/" + f.ID, Protected: f.PasswordHash != ""}
+	if f.Encrypted {
+		// the decryption page; clients append "#k=<key>" themselves
+		res.URL = s.baseURL(r) + "/e/" + f.ID
+	}
+	var sig string
+	if lc := s.config().Links; lc.RequireSignature {
+		exp := time.Now().Add(lc.TTL).UTC().Truncate(time.Second)
+		sig = "?" + s.links.Sign(f.ID, exp).Encode()
+		res.URL += sig
+		res.LinkExpires = &exp
+	}
+	if s.config().Thumbnails.Enabled && thumbable(f) {
+		res.Thumbnail = s.baseURL(r) + "/f/" + f.ID + "/thumb" + sig
+	}
+	if s.config().UI.Enabled && !f.Encrypted {
+		res.Share = s.baseURL(r) + "/s/" + f.ID + sig
+	}
+	if s.config().Preview.Enabled && previewKind(f) != "" {
+		res.Preview = s.baseURL(r) + "/f/" + f.ID + "/preview" + sig
+	}
+	if mc := s.config().Media; mc.FFmpeg != "" && isVideo(f) {
+		res.Poster = s.baseURL(r) + "/f/" + f.ID + "/poster" + sig
+		if mc.ClipLength > 0 {
+			res.Clip = s.baseURL(r) + "/f/" + f.ID + "/clip" + sig
+		}
+	}
+	return res
+}
+
+// linkAllowed reports whether r may see file f. Without links.require_signature every link
+// works; with it, the link must carry a valid signature, or the request must come from the
+// file's owner.
+func (s *Server) linkAllowed(r *http.Request, f *metadata.File) bool {
+	lc := s.config().Links
+	if !lc.RequireSignature {
+		return true
+	}
+	err := s.links.Verify(f.ID, r.URL.Query(), time.Now(), lc.Grace)
+	if err == nil {
+		return true
+	}
+	// on an open server everyone is "" and owns nothing, so this can't bypass signatures there
+	if owner, ok := s.authorize(r); ok && owner != "" && owner == f.Owner {
+		return true
+	}
+	if !errors.Is(err, signing.ErrUnsigned) {
+		s.logFor(r.Context()).Info("refused link to %s: %v", f.ID, err)
+	}
+	return false
+}
+
+// handleDecryptPage serves the page that downloads an encrypted file and decrypts it in the
+// browser with the key from the URL fragment, which never reaches the server.
+func (s *Server) handleDecryptPage(w http.ResponseWriter, r *http.Request) {
+	f, err := s.liveFile(r.PathValue("id"))
+	if err != nil || !f.Encrypted {
+		http.NotFound(w, r)
+		return
+	}
+	if !s.linkAllowed(r, f) {
+		textError(w, r, "link expired or invalid", http.StatusForbidden)
+		return
+	}
+	if f.TakenDown != nil {
+		textError(w, r, "file taken down following an abuse report", http.StatusGone)
+		return
+	}
+	if f.Expired(time.Now()) {
+		textError(w, r, "file expired", http.StatusGone)
+		return
+	}
+	if status, msg := s.passwordAllowed(w, r, f); status != 0 {
+		textError(w, r, msg, status)
+		return
+	}
+	s.ui(r).ServeDecrypt(w, r)
+}
+
+// baseURL prefers the configured public URL, since behind a proxy the Host header may lie.
+// Requests to a branded tenant's domain keep that domain, which the tenant list vouches for.
+// A public URL includes the mount prefix if there is one; a URL built from Host gets it added.
+func (s *Server) baseURL(r *http.Request) string {
+	pub := s.config().PublicURL
+	_, tenant := s.tenants[requestHost(r)]
+	if pub != "" && !tenant {
+		return strings.TrimRight(pub, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil || strings.HasPrefix(pub, "https:") {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + s.prefix
+}
+
+// uploadSource finds the file name and body of an upload request.
+func uploadSource(r *http.Request) (string, io.Reader, error) {
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if ct == "multipart/form-data" {
+		mr, err := r.MultipartReader()
+		if err != nil {
+			return "", nil, err
+		}
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return "", nil, errors.New(`multipart upload has no "file" field`)
+			}
+			if err != nil {
+				return "", nil, err
+			}
+			if part.FormName() == "file" {
+				return cleanName(part.FileName()), part, nil
+			}
+		}
+	}
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		name = r.Header.Get("X-Filename")
+	}
+	return cleanName(name), r.Body, nil
+}
+
+// cleanName strips any directory part and control characters from a client-supplied name.
+func cleanName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "" || name == "." || name == "/" || name == ".." {
+		return "file"
+	}
+	return name
+}
+
+// DetectContentType picks a stored file's content type. It trusts the extension first and falls
+// back to sniffing the first bytes.
+func DetectContentType(name string, head []byte) string {
+	if ct := mime.TypeByExtension(filepath.Ext(name)); ct != "" {
+		return ct
+	}
+	if ct, ok := mediaTypes[strings.ToLower(filepath.Ext(name))]; ok {
+		return ct
+	}
+	return http.DetectContentType(head)
+}
+
+// newID returns a short random ID that is safe to use in URLs and as a storage key.
+func newID() string {
+	b := make([]byte, 9) // 72 bits, plenty to make links unguessable
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError answers with an API error, translated into the language of the request when
+// the message is one the catalogs know.
+func writeError(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	loc := i18n.FromContext(r.Context())
+	localized(w, loc)
+	writeJSON(w, status, map[string]string{"error": loc.T(msg)})
+}
+
+// writeErrorf is writeError with a message formatted like fmt.Sprintf, translating the format.
+func writeErrorf(w http.ResponseWriter, r *http.Request, status int, format string, args ...any) {
+	loc := i18n.FromContext(r.Context())
+	localized(w, loc)
+	writeJSON(w, status, map[string]string{"error": loc.Tf(format, args...)})
+}
+
+// textError is http.Error, translated like writeError, for the responses of links people
+// open in a browser.
+func textError(w http.ResponseWriter, r *http.Request, msg string, status int) {
+	loc := i18n.FromContext(r.Context())
+	localized(w, loc)
+	http.Error(w, loc.T(msg), status)
+}
+
+func localized(w http.ResponseWriter, loc *i18n.Locale) {
+	w.Header().Set("Content-Language", loc.Tag)
+	w.Header().Add("Vary", "Accept-Language")
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// userContentCSP is sent with every uploaded file. Should a browser render one anyway (an old
+// browser ignoring the attachment disposition, say), the sandbox gives it a unique origin with
+// no scripts, so it can't read cookies or call the API as the viewer.
+const userContentCSP = "sandbox; default-src 'none'; img-src 'self' data:; media-src 'self'; style-src 'unsafe-inline'"
+
+// withHeaders adds the configured security headers to every response. Route overrides from
+// the config are applied next, longest prefix last so the most specific one wins, and
+// handlers may still set their own on top (downloads always do).
+func (s *Server) withHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hc := s.config().Headers
+		h := w.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		set := func(name, value string) {
+			if value == "" {
+				h.Del(name)
+				return
+			}
+			h.Set(name, value)
+		}
+		set("Content-Security-Policy", hc.ContentSecurityPolicy)
+		set("X-Frame-Options", hc.FrameOptions)
+		set("Referrer-Policy", hc.ReferrerPolicy)
+		if hc.HSTSMaxAge > 0 && (r.TLS != nil || strings.HasPrefix(s.config().PublicURL, "https://")) {
+			h.Set("Strict-Transport-Security", "max-age="+strconv.Itoa(int(hc.HSTSMaxAge.Seconds())))
+		}
+
+		var prefixes []string
+		for p := range hc.Routes {
+			if strings.HasPrefix(r.URL.Path, p) {
+				prefixes = append(prefixes, p)
+			}
+		}
+		sort.Slice(prefixes, func(i, j int) bool { return len(prefixes[i]) < len(prefixes[j]) })
+		for _, p := range prefixes {
+			for name, value := range hc.Routes[p] {
+				set(name, value)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// inlineAllowed is inlineSafe plus the types the operator explicitly allowed. Those are still
+// served under userContentCSP, so even an allowed SVG or HTML file runs no script.
+func (s *Server) inlineAllowed(contentType string) bool {
+	if inlineSafe(contentType) {
+		return true
+	}
+	ct, _, _ := strings.Cut(contentType, ";")
+	ct = strings.TrimSpace(strings.ToLower(ct))
+	for _, t := range s.config().Types.InlineAllow {
+		if strings.EqualFold(t, ct) {
+			return true
+		}
+	}
+	return false
+}
+
+// inlineSafe reports whether a content type may be shown inline in the browser on request.
+// Anything that can carry script (HTML, SVG, XML and friends) is always an attachment.
+func inlineSafe(contentType string) bool {
+	ct, _, _ := strings.Cut(contentType, ";")
+	ct = strings.TrimSpace(strings.ToLower(ct))
+	switch {
+	case ct == "image/svg+xml":
+		return false
+	case strings.HasPrefix(ct, "image/"), strings.HasPrefix(ct, "video/"), strings.HasPrefix(ct, "audio/"):
+		return true
+	case ct == "application/pdf", ct == "text/plain":
+		return true
+	}
+	return false
+}
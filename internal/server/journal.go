@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/hey-granth/filegoblin/internal/journal"
+	"github.com/hey-granth/filegoblin/internal/metadata"
+	"github.com/hey-granth/filegoblin/internal/storage"
+)
+
+// SetJournal makes uploads go through j: each is recorded there before any of its blob is
+// written, and ended once its record is committed or it was given up. Call RecoverUploads
+// before serving, to deal with what a crash left open.
+func (s *Server) SetJournal(j *journal.Journal) { s.journal = j }
+
+// beginUpload records the upload id in the journal, or writes the error response and
+// returns false.
+func (s *Server) beginUpload(w http.ResponseWriter, r *http.Request, id string) bool {
+	if s.journal == nil {
+		return true
+	}
+	if err := s.journal.Begin(id); err != nil {
+		s.logFor(r.Context()).ErrorE(err, "journal upload", "file_id", id)
+		writeError(w, r, http.StatusInternalServerError, "could not store upload")
+		return false
+	}
+	return true
+}
+
+func (s *Server) endUpload(id string) {
+	if s.journal == nil {
+		return
+	}
+	if err := s.journal.End(id); err != nil {
+		s.log.Error("journal: end upload %s: %v", id, err)
+	}
+}
+
+// RecoveryResult counts what RecoverUploads found.
+type RecoveryResult struct {
+	Committed int // had their record written; only the journal's note of it was lost
+	Discarded int // never got a record: their blob, whole or not, was deleted
+	Lost      int // had a record but not all of their blob, and were deleted
+	Open      int // couldn't be checked, and are left for the next start
+}
+
+// RecoverUploads goes through the uploads the journal has open, the ones a crash or kill
+// cut short, and makes sure none leaves a record without its whole blob or a blob without
+// a record. Records are only written once the blob is synced, so a lost one means storage
+// didn't keep what it said it had. A blob deduplicated just before the crash may be left
+// under its SHA-256; gc collects it as an orphan.
+func (s *Server) RecoverUploads(ctx context.Context) (RecoveryResult, error) {
+	var res RecoveryResult
+	if s.journal == nil {
+		return res, nil
+	}
+	var errs []error
+	for _, e := range s.journal.Open() {
+		if ctx.Err() != nil {
+			return res, ctx.Err()
+		}
+		err := s.recoverUpload(ctx, e.ID, &res)
+		if err != nil {
+			res.Open++
+			errs = append(errs, err)
+			continue
+		}
+		if err := s.journal.End(e.ID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return res, errors.Join(errs...)
+}
+
+func (s *Server) recoverUpload(ctx context.Context, id string, res *RecoveryResult) error {
+	f, err := s.index.Get(id)
+	if errors.Is(err, metadata.ErrNotFound) {
+		if err := s.store.Delete(ctx, id); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
+		res.Discarded++
+		return nil
+	} else if err != nil {
+		return err
+	}
+	info, err := s.store.Stat(ctx, f.BlobKey())
+	if err == nil && info.Size == f.Size {
+		res.Committed++
+		return nil
+	} else if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+	s.log.Error("upload %s (%q) has a record but lost its blob in a crash; deleting it", id, f.Name)
+	if err := s.index.Delete(id); err != nil && !errors.Is(err, metadata.ErrNotFound) {
+		return err
+	}
+	if err := s.dropBlob(ctx, f); err != nil {
+		return err
+	}
+	res.Lost++
+	return nil
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/metadata"
+)
+
+// runLifecycle applies the lifecycle rules every sweep interval until ctx is done. The rules are
+// read fresh on every pass, so a reload takes effect at the next sweep. In a cluster only the
+// leader sweeps.
+func (s *Server) runLifecycle(ctx context.Context) {
+	for {
+		interval := s.config().Lifecycle.SweepInterval
+		if interval <= 0 {
+			interval = 10 * time.Minute
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+		if !s.isLeader() {
+			continue
+		}
+		s.sweep(ctx, time.Now())
+		s.maintainLinkKeys(time.Now())
+	}
+}
+
+// maintainLinkKeys rotates the link signing key when it's due and drops retired keys whose
+// grace period has passed.
+func (s *Server) maintainLinkKeys(now time.Time) {
+	unlock, err := s.lock("signing")
+	if err != nil {
+		s.log.Error("lifecycle: lock signing keys: %v", err)
+		return
+	}
+	defer unlock()
+	if s.config().Cluster.Enabled() {
+		if err := s.links.Reload(); err != nil {
+			s.log.Error("lifecycle: reload signing keys: %v", err)
+			return
+		}
+	}
+	lc := s.config().Links
+	rotated, dropped, err := s.links.Maintain(now, lc.RotateEvery, lc.Grace)
+	if err != nil {
+		s.log.Error("lifecycle: rotate signing keys: %v", err)
+		return
+	}
+	if rotated {
+		s.log.Info("lifecycle: rotated link signing key")
+	}
+	if dropped > 0 {
+		s.log.Info("lifecycle: dropped %d retired signing keys", dropped)
+	}
+}
+
+// sweep deletes every file older than the configured max age, past its own expiry time or out
+// of downloads, those in the trash longer than lifecycle.trash_for and versions older than
+// versions.max_age, and returns how many files expired.
+func (s *Server) sweep(ctx context.Context, now time.Time) int {
+	lc := s.config().Lifecycle
+	n, purged := 0, 0
+	due := func(f *metadata.File) bool {
+		old := lc.MaxAge > 0 && now.Sub(f.CreatedAt) >= lc.MaxAge
+		// taken-down files are kept until an admin has finished with them, trashed ones until
+		// the trash is emptied, and those being downloaded (their last, often) until that ends
+		return (old || f.Expired(now)) && f.TakenDown == nil && f.Trashed == nil && !s.transfers.downloading(f.ID)
+	}
+	emptied := func(f *metadata.File) bool {
+		return f.Trashed != nil && now.Sub(*f.Trashed) >= lc.TrashFor
+	}
+	for _, f := range s.index.List() {
+		switch {
+		case due(f) && s.expire(ctx, f.ID, due, "expire"):
+			n++
+		case emptied(f) && s.expire(ctx, f.ID, emptied, "purge"):
+			purged++
+		}
+	}
+	if n > 0 {
+		s.log.Info("lifecycle: expired %d files", n)
+	}
+	if purged > 0 {
+		s.log.Info("lifecycle: purged %d files from the trash", purged)
+	}
+	if pruned := s.pruneVersions(ctx, now); pruned > 0 {
+		s.log.Info("lifecycle: pruned %d old versions", pruned)
+	}
+	s.recordSweep(now, n)
+	return n
+}
+
+// expire deletes file id if it is still due once its lock is held: another server may have
+// just pushed its expiry back, taken it down, restored it or deleted it. event is the webhook
+// event to send for it.
+func (s *Server) expire(ctx context.Context, id string, due func(*metadata.File) bool, event string) bool {
+	unlock, err := s.lockFile(id)
+	if err != nil {
+		s.log.Error("lifecycle: lock %s: %v", id, err)
+		return false
+	}
+	defer unlock()
+	f, err := s.index.Get(id)
+	if err != nil || !due(f) {
+		return false
+	}
+	if err := s.index.Delete(id); err != nil {
+		s.log.Error("lifecycle: delete %s: %v", id, err)
+		return false
+	}
+	if err := s.dropBlob(ctx, f); err != nil {
+		s.log.Error("lifecycle: delete blob %s: %v", id, err)
+	}
+	s.dropCache(id)
+	s.notifyFile(event, f)
+	return true
+}
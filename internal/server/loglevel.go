@@ -0,0 +1,80 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/hey-granth/filegoblin/internal/logx"
+)
+
+type logLevelResponse struct {
+	Level      string `json:"level"`
+	Configured string `json:"configured"` // what log.level says, and what a restart goes back to
+}
+
+func (s *Server) logLevelResponse() logLevelResponse {
+	return logLevelResponse{Level: strings.ToLower(s.log.Level().String()), Configured: s.config().Log.Level}
+}
+
+func (s *Server) handleGetLogLevel(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.logLevelResponse())
+}
+
+// handleSetLogLevel changes the log level until the next restart, or until a reload that
+// changes log.level, so debug lines can be had from a busy server without dropping its
+// uploads. The change is made on this node only, even in a cluster.
+func (s *Server) handleSetLogLevel(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Level string `json:"level"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	lv, err := logx.ParseLevel(req.Level)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.log.SetLevel(lv)
+	s.audit(r, "log_level", "", "log level set to %s", strings.ToLower(lv.String()))
+	writeJSON(w, http.StatusOK, s.logLevelResponse())
+}
+
+// ToggleDebug switches the log to debug, or back to the configured level if it is at debug
+// already, and returns the level it is now at.
+func (s *Server) ToggleDebug() logx.Level {
+	if s.log.Level() == logx.LevelDebug {
+		s.applyLogLevel(s.config().Log.Level)
+	} else {
+		s.log.SetLevel(logx.LevelDebug)
+	}
+	return s.log.Level()
+}
+
+// handleLogs serves the latest messages kept in memory (log.recent), oldest first, for a look
+// at what the server has been doing without access to its log file. ?level= leaves out those
+// below a level, and ?limit= returns only the last so many.
+func (s *Server) handleLogs(w http.ResponseWriter, r *http.Request) {
+	min := logx.LevelDebug
+	if v := r.URL.Query().Get("level"); v != "" {
+		lv, err := logx.ParseLevel(v)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		min = lv
+	}
+	limit := 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, r, http.StatusBadRequest, "limit must be a number of messages")
+			return
+		}
+		limit = n
+	}
+	writeJSON(w, http.StatusOK, s.log.Recent(min, limit))
+}
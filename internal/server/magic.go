@@ -0,0 +1,102 @@
+package server
+
+import (
+	"mime"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// sniffable are the types http.DetectContentType recognises by signature. A file claiming
+// one of them must really start with it; for anything else the first bytes prove little.
+var sniffable = map[string]bool{
+	"image/png": true, "image/jpeg": true, "image/gif": true, "image/webp": true, "image/bmp": true,
+	"image/x-icon": true, "application/pdf": true, "application/zip": true, "application/x-gzip": true,
+	"application/x-rar-compressed": true, "application/wasm": true, "video/mp4": true, "video/webm": true,
+	"video/avi": true, "audio/mpeg": true, "audio/wave": true, "application/ogg": true, "audio/aiff": true,
+	"font/woff": true, "font/woff2": true, "font/ttf": true, "font/otf": true,
+}
+
+// typeAliases maps the names the extension table uses onto the ones the sniffer returns.
+var typeAliases = map[string]string{
+	"image/vnd.microsoft.icon": "image/x-icon",
+	"application/gzip":         "application/x-gzip",
+	"application/vnd.rar":      "application/x-rar-compressed",
+	"audio/wav":                "audio/wave",
+	"audio/x-wav":              "audio/wave",
+	"audio/ogg":                "application/ogg",
+	"video/ogg":                "application/ogg",
+	"video/x-msvideo":          "video/avi",
+	"audio/x-aiff":             "audio/aiff",
+	"audio/mp4":                "video/mp4",
+	"application/font-woff":    "font/woff",
+}
+
+// zipContainers are formats that are zip files inside, so they sniff as application/zip.
+var zipContainers = []string{
+	"application/vnd.openxmlformats-", "application/vnd.oasis.opendocument.", "application/epub+zip",
+	"application/java-archive", "application/vnd.android.package-archive", "application/x-zip-compressed",
+}
+
+func baseType(ct string) string {
+	mt, _, _ := strings.Cut(ct, ";")
+	mt = strings.ToLower(strings.TrimSpace(mt))
+	if alias, ok := typeAliases[mt]; ok {
+		return alias
+	}
+	return mt
+}
+
+// textual reports whether a type is some kind of text, which the sniffer can only call
+// text/plain, text/html or text/xml.
+func textual(ct string) bool {
+	switch {
+	case strings.HasPrefix(ct, "text/"), strings.HasSuffix(ct, "+xml"), strings.HasSuffix(ct, "+json"):
+		return true
+	}
+	switch ct {
+	case "application/json", "application/xml", "application/javascript", "application/x-sh", "application/x-yaml", "application/yaml", "application/toml":
+		return true
+	}
+	return false
+}
+
+// typeMatches reports whether content that sniffs as sniffed may be stored as declared, the
+// type its extension claims.
+func typeMatches(declared, sniffed string) bool {
+	declared, sniffed = baseType(declared), baseType(sniffed)
+	switch {
+	case declared == "" || declared == sniffed:
+		return true
+	case sniffed == "application/zip":
+		for _, z := range zipContainers {
+			if strings.HasPrefix(declared, z) {
+				return true
+			}
+		}
+	case textual(declared) && textual(sniffed):
+		return true
+	}
+	// a declared type with a known signature has to carry it; others can't be checked
+	return !sniffable[declared]
+}
+
+// checkMagic turns away an upload whose first bytes contradict its file name, when types.
+// verify_magic asks for it. An HTML page named photo.png is the classic: fine as long as it's
+// downloaded, but a trap in anything that trusts the extension.
+func (s *Server) checkMagic(w http.ResponseWriter, r *http.Request, owner, name string, head []byte) bool {
+	tc := s.config().Types
+	if !tc.VerifyMagic || (len(tc.VerifyOwners) > 0 && !slices.Contains(tc.VerifyOwners, owner)) {
+		return true
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	declared := mime.TypeByExtension(ext)
+	sniffed := http.DetectContentType(head)
+	if typeMatches(declared, sniffed) {
+		return true
+	}
+	s.logFor(r.Context()).Info("rejected upload %q from %s: content is %s, not %s", name, ownerLabel(owner), baseType(sniffed), baseType(declared))
+	writeErrorf(w, r, http.StatusUnsupportedMediaType, "content does not match the %s extension (looks like %s)", ext, baseType(sniffed))
+	return false
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hey-granth/filegoblin/internal/config"
+	"github.com/hey-granth/filegoblin/internal/media"
+	"github.com/hey-granth/filegoblin/internal/metadata"
+)
+
+// mediaTypes covers video and audio extensions that minimal systems, without a
+// /etc/mime.types, don't know. Browsers only play what arrives with the right type.
+var mediaTypes = map[string]string{
+	".mp4": "video/mp4", ".m4v": "video/mp4", ".webm": "video/webm", ".mov": "video/quicktime",
+	".mkv": "video/x-matroska", ".ogv": "video/ogg", ".mp3": "audio/mpeg", ".m4a": "audio/mp4",
+	".aac": "audio/aac", ".ogg": "audio/ogg", ".oga": "audio/ogg", ".opus": "audio/ogg",
+	".flac": "audio/flac", ".wav": "audio/wav",
+}
+
+// isVideo reports whether previews can be made of f: a plain (not end-to-end encrypted) video.
+func isVideo(f *metadata.File) bool {
+	return strings.HasPrefix(strings.ToLower(f.ContentType), "video/") && !f.Encrypted
+}
+
+// checkFastStart marks MP4 and QuickTime uploads whose index comes after the media data, which
+// browsers can still play but only after fetching the end of the file first.
+func (s *Server) checkFastStart(ctx context.Context, f *metadata.File) {
+	if ct := baseType(f.ContentType); f.Encrypted || (ct != "video/mp4" && ct != "video/quicktime") {
+		return
+	}
+	rc, err := s.store.Get(ctx, f.BlobKey())
+	if err != nil {
+		return
+	}
+	defer rc.Close()
+	rs, ok := rc.(io.ReadSeeker)
+	if !ok {
+		return
+	}
+	if fast, err := media.FastStart(rs); err == nil && !fast {
+		f.SlowStart = true
+		s.logFor(ctx).Info("%s (%q) has its MP4 index at the end; remuxing with ffmpeg -movflags +faststart makes it start sooner", f.ID, f.Name)
+	}
+}
+
+// rangeFromStart reports whether a download request starts at the beginning of the file: no
+// Range header, or one whose first range starts at byte 0.
+func rangeFromStart(r *http.Request) bool {
+	rng := r.Header.Get("Range")
+	return rng == "" || strings.HasPrefix(strings.TrimSpace(rng), "bytes=0-")
+}
+
+func (s *Server) handlePoster(w http.ResponseWriter, r *http.Request) {
+	s.servePreview(w, r, "poster.jpg", "image/jpeg")
+}
+
+func (s *Server) handleClip(w http.ResponseWriter, r *http.Request) {
+	if s.config().Media.ClipLength == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	s.servePreview(w, r, "clip.mp4", "video/mp4")
+}
+
+// servePreview serves a poster or clip of a video file, making it first if it isn't cached.
+func (s *Server) servePreview(w http.ResponseWriter, r *http.Request, variant, contentType string) {
+	mc := s.config().Media
+	f, err := s.liveFile(r.PathValue("id"))
+	if err != nil || mc.FFmpeg == "" || !isVideo(f) {
+		http.NotFound(w, r)
+		return
+	}
+	if !s.previewAllowed(w, r, f) {
+		return
+	}
+	path, err := s.mediaPreview(mc, f, variant)
+	if err != nil {
+		s.logFor(r.Context()).Error("%s of %s: %v", variant, f.ID, err)
+		textError(w, r, "preview unavailable", http.StatusInternalServerError)
+		return
+	}
+	s.serveCached(w, r, f, path, contentType)
+}
+
+// mediaPreview returns the path of a poster ("poster.jpg") or clip ("clip.mp4") of f, making
+// it with ffmpeg first when it isn't cached yet. It deliberately doesn't use the request's
+// context: a client going away halfway shouldn't waste the work.
+func (s *Server) mediaPreview(mc config.Media, f *metadata.File, variant string) (string, error) {
+	return s.cached("media", f.ID+"-"+variant, func(tmp string) error {
+		ctx, cancel := context.WithTimeout(context.Background(), mc.Timeout)
+		defer cancel()
+		src, done, err := s.localCopy(ctx, f.BlobKey())
+		if err != nil {
+			return err
+		}
+		defer done()
+		if variant == "clip.mp4" {
+			return media.Clip(ctx, mc.FFmpeg, src, mc.ClipLength, mc.Width, tmp)
+		}
+		out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			return err
+		}
+		if err := media.Poster(ctx, mc.FFmpeg, src, mc.PosterAt, mc.Width, out); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
+}
+
+// localCopy returns a path ffmpeg can read blob id from, which has to be a real file: MP4s
+// with their index at the end can't be read from a pipe. Blobs on disk are used in place;
+// anything else is copied to a temporary file, which done removes.
+func (s *Server) localCopy(ctx context.Context, id string) (path string, done func(), err error) {
+	rc, err := s.store.Get(ctx, id)
+	if err != nil {
+		return "", nil, err
+	}
+	if f, ok := rc.(*os.File); ok {
+		return f.Name(), func() { f.Close() }, nil
+	}
+	defer rc.Close()
+	dir := filepath.Join(s.config().DataDir, "cache")
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return "", nil, err
+	}
+	tmp, err := os.CreateTemp(dir, "media-*")
+	if err != nil {
+		return "", nil, err
+	}
+	if _, err := io.Copy(tmp, rc); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", nil, err
+	}
+	tmp.Close()
+	return tmp.Name(), func() { os.Remove(tmp.Name()) }, nil
+}
+
+// makeMediaPreviews makes the poster and clip of a new video upload.
+func (s *Server) makeMediaPreviews(f *metadata.File) {
+	mc := s.config().Media
+	if mc.FFmpeg == "" || !isVideo(f) || f.Quarantined() {
+		return
+	}
+	variants := []string{"poster.jpg"}
+	if mc.ClipLength > 0 {
+		variants = append(variants, "clip.mp4")
+	}
+	for _, v := range variants {
+		if _, err := s.mediaPreview(mc, f, v); err != nil {
+			s.log.Error("%s of %s: %v", v, f.ID, err)
+		}
+	}
+}
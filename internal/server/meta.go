@@ -0,0 +1,97 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hey-granth/filegoblin/internal/metadata"
+)
+
+// metaHeader prefixes the headers that give a file entries of its own metadata on upload,
+// one each, like X-Goblin-Meta-Project: apollo, and that carry them on its downloads.
+const metaHeader = "X-Goblin-Meta-"
+
+// Limits on a file's own metadata, which has to fit in response headers.
+const (
+	maxMetaEntries  = 32
+	maxMetaKeyLen   = 64
+	maxMetaValueLen = 1 << 10
+)
+
+// cleanMeta lowercases the keys of meta, as headers don't keep their case, and checks them:
+// letters, digits, "-" and "_" only, and values without control characters.
+func cleanMeta(meta map[string]string) (map[string]string, error) {
+	if len(meta) == 0 {
+		return nil, nil
+	}
+	if len(meta) > maxMetaEntries {
+		return nil, fmt.Errorf("a file can have at most %d metadata entries", maxMetaEntries)
+	}
+	out := make(map[string]string, len(meta))
+	for k, v := range meta {
+		k = strings.ToLower(k)
+		if k == "" || len(k) > maxMetaKeyLen || strings.IndexFunc(k, func(r rune) bool {
+			return !('a' <= r && r <= 'z' || '0' <= r && r <= '9' || r == '-' || r == '_')
+		}) >= 0 {
+			return nil, fmt.Errorf("metadata key %q must be up to %d letters, digits, - and _", k, maxMetaKeyLen)
+		}
+		if len(v) > maxMetaValueLen || strings.ContainsFunc(v, func(r rune) bool { return r < 0x20 || r == 0x7f }) {
+			return nil, fmt.Errorf("metadata value for %q must be up to %d bytes, without control characters", k, maxMetaValueLen)
+		}
+		out[k] = v
+	}
+	return out, nil
+}
+
+// uploadMeta reads the metadata an upload gives its file: X-Goblin-Meta-* headers, and
+// ?meta=, a JSON object of strings, whose entries win.
+func uploadMeta(r *http.Request) (map[string]string, error) {
+	meta := map[string]string{}
+	for name, values := range r.Header {
+		if key, ok := strings.CutPrefix(name, metaHeader); ok {
+			meta[strings.ToLower(key)] = values[0]
+		}
+	}
+	if v := r.URL.Query().Get("meta"); v != "" {
+		var m map[string]string
+		if err := json.Unmarshal([]byte(v), &m); err != nil {
+			return nil, errors.New(`meta must be a JSON object of strings, like {"project": "apollo"}`)
+		}
+		for k, v := range m {
+			meta[strings.ToLower(k)] = v
+		}
+	}
+	return cleanMeta(meta)
+}
+
+// setMetaHeaders sends f's own metadata with its download.
+func setMetaHeaders(w http.ResponseWriter, f *metadata.File) {
+	for k, v := range f.Meta {
+		w.Header().Set(metaHeader+k, v)
+	}
+}
+
+// metaFilter reads the metadata list and search filter on: ?meta.project=apollo for files
+// whose project is apollo, or =* for those with one at all. Every one has to match.
+func metaFilter(r *http.Request) map[string]string {
+	filter := map[string]string{}
+	for name, values := range r.URL.Query() {
+		if key, ok := strings.CutPrefix(name, "meta."); ok {
+			filter[strings.ToLower(key)] = values[0]
+		}
+	}
+	return filter
+}
+
+func matchMeta(f *metadata.File, filter map[string]string) bool {
+	for k, want := range filter {
+		v, ok := f.Meta[k]
+		if !ok || want != "*" && v != want {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/hey-granth/filegoblin/internal/metrics"
+)
+
+// newMetrics registers what /metrics exposes.
+func (s *Server) newMetrics() *metrics.Registry {
+	reg := metrics.NewRegistry()
+	reg.Counter("filegoblin_log_messages_total", "Log messages logged, by level and component.", func() []metrics.Sample {
+		var out []metrics.Sample
+		for _, c := range s.log.Counts() {
+			out = append(out, metrics.Sample{
+				Labels: []metrics.Label{{Name: "level", Value: strings.ToLower(c.Level.String())}, {Name: "component", Value: c.Component}},
+				Value:  float64(c.N),
+			})
+		}
+		return out
+	})
+	return reg
+}
+
+// handleMetrics answers a Prometheus scrape, if metrics.enabled is set and the scraper has the
+// token, when there is one.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	mc := s.config().Metrics
+	if !mc.Enabled {
+		http.NotFound(w, r)
+		return
+	}
+	if mc.Token != "" {
+		if _, ok := matchKey(bearerToken(r), []string{mc.Token}); !ok {
+			writeError(w, r, http.StatusUnauthorized, "metrics token required")
+			return
+		}
+	}
+	s.metrics.ServeHTTP(w, r)
+}
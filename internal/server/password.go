@@ -0,0 +1,186 @@
+package server
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/config"
+	"github.com/hey-granth/filegoblin/internal/metadata"
+)
+
+// passwordHeader carries a file's password: on upload it sets one, on stat and download it
+// unlocks the file. Browsers, which can't add headers to a link, use HTTP basic auth instead.
+const passwordHeader = "X-File-Password"
+
+// maxPasswordLen keeps a single request from making the server hash megabytes.
+const maxPasswordLen = 1024
+
+const passwordIterations = 100_000
+
+// hashPassword returns "pbkdf2-sha256$<iterations>$<salt>$<key>" for password.
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, passwordIterations, 32)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawStdEncoding
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", passwordIterations, enc.EncodeToString(salt), enc.EncodeToString(key)), nil
+}
+
+// matchPassword reports whether password hashes to hash. A malformed hash matches nothing.
+func matchPassword(hash, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iter, err := strconv.Atoi(parts[1])
+	if err != nil || iter < 1 {
+		return false
+	}
+	enc := base64.RawStdEncoding
+	salt, err1 := enc.DecodeString(parts[2])
+	want, err2 := enc.DecodeString(parts[3])
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	got, err := pbkdf2.Key(sha256.New, password, salt, iter, len(want))
+	return err == nil && subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// passwordAllowed reports whether r may open the password-protected file f. When it may not,
+// it returns the status and message to answer with, having already set WWW-Authenticate or
+// Retry-After. Owners never need the password for their own files.
+func (s *Server) passwordAllowed(w http.ResponseWriter, r *http.Request, f *metadata.File) (int, string) {
+	if f.PasswordHash == "" {
+		return 0, ""
+	}
+	if owner, ok := s.authorize(r); ok && owner != "" && owner == f.Owner {
+		return 0, ""
+	}
+	password := r.Header.Get(passwordHeader)
+	if password == "" {
+		_, password, _ = r.BasicAuth()
+	}
+	if password == "" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="filegoblin", charset="UTF-8"`)
+		return http.StatusUnauthorized, "file is password protected"
+	}
+	ip := remoteIP(r)
+	keys := []string{"file:" + f.ID, "ip:" + ip}
+	pc := s.config().Passwords
+	wait, tripped := s.guesses.try(time.Now(), pc, keys...)
+	if wait > 0 && !tripped {
+		w.Header().Set("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+		return http.StatusTooManyRequests, "too many wrong passwords, try again later"
+	}
+	if len(password) <= maxPasswordLen && matchPassword(f.PasswordHash, password) {
+		s.guesses.succeed(pc, keys...)
+		return 0, ""
+	}
+	s.audit(r, "password_failure", f.ID, "file %s from %s", f.ID, ip)
+	if tripped {
+		s.audit(r, "password_lockout", f.ID, "file %s from %s locked out for %s", f.ID, ip, wait)
+	}
+	w.Header().Set("WWW-Authenticate", `Basic realm="filegoblin", charset="UTF-8"`)
+	return http.StatusUnauthorized, "wrong password"
+}
+
+// lockout counts password guesses per key (a file, a client IP) and locks keys out with an
+// exponential backoff once they run out of free attempts.
+type lockout struct {
+	mu        sync.Mutex
+	entries   map[string]*guesses
+	lastPrune time.Time
+}
+
+type guesses struct {
+	failures int
+	until    time.Time // nothing is accepted before this
+	last     time.Time
+}
+
+func newLockout() *lockout {
+	return &lockout{entries: map[string]*guesses{}}
+}
+
+// try registers a guess against every key. If any key is locked out it refuses the guess and
+// returns how long to wait. Otherwise the guess counts as a failure until succeed takes it back,
+// so a burst of parallel guesses can't slip past the limit. A guess that uses up the last free
+// attempt goes ahead with tripped set: if it's wrong, the returned lockout is now in force.
+func (l *lockout) try(now time.Time, pc config.Passwords, keys ...string) (wait time.Duration, tripped bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prune(now, pc.MaxLockout)
+	for _, k := range keys {
+		if g := l.entries[k]; g != nil && now.Before(g.until) {
+			wait = max(wait, g.until.Sub(now))
+		}
+	}
+	if wait > 0 {
+		return wait, false
+	}
+	for _, k := range keys {
+		g := l.entries[k]
+		if g == nil {
+			g = &guesses{}
+			l.entries[k] = g
+		}
+		g.failures++
+		g.last = now
+		if over := g.failures - pc.FreeAttempts; over >= 0 {
+			d := pc.MaxLockout
+			if over < 32 {
+				d = min(pc.Lockout<<over, pc.MaxLockout)
+			}
+			g.until = now.Add(d)
+			wait, tripped = max(wait, d), true
+		}
+	}
+	return wait, tripped
+}
+
+// succeed takes back the failure try counted for a guess that turned out right. Earlier
+// failures stay: knowing one file's password mustn't reset the count for guessing others.
+func (l *lockout) succeed(pc config.Passwords, keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, k := range keys {
+		g := l.entries[k]
+		if g == nil {
+			continue
+		}
+		g.failures--
+		if g.failures <= 0 {
+			delete(l.entries, k)
+		} else if g.failures < pc.FreeAttempts {
+			g.until = time.Time{}
+		}
+	}
+}
+
+// prune drops keys that have been quiet for longer than the longest lockout, so counts decay
+// and the map doesn't grow without bound. It runs at most once a minute.
+func (l *lockout) prune(now time.Time, idle time.Duration) {
+	if now.Sub(l.lastPrune) < time.Minute {
+		return
+	}
+	l.lastPrune = now
+	for k, g := range l.entries {
+		if now.After(g.until) && now.Sub(g.last) > idle {
+			delete(l.entries, k)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"slices"
+
+	"github.com/hey-granth/filegoblin/internal/config"
+	"github.com/hey-granth/filegoblin/internal/metadata"
+	"github.com/hey-granth/filegoblin/internal/plugin"
+)
+
+// runPlugins runs the plugins configured at point, in order, until one denies. It returns
+// the status and message to refuse the request with, or 0 to go ahead. r is nil after the
+// request is over (post_upload).
+func (s *Server) runPlugins(ctx context.Context, point string, r *http.Request, f *metadata.File) (int, string) {
+	plugins := s.config().Plugins
+	if !slices.ContainsFunc(plugins, func(p config.Plugin) bool { return p.Point == point }) {
+		return 0, ""
+	}
+	req := plugin.Request{Point: point, File: hookFile{File: f}}
+	if r != nil {
+		user, _ := s.authorize(r)
+		if point != plugin.PreDownload {
+			user = f.Owner
+		}
+		req.Client = &plugin.Client{User: user, Remote: remoteIP(r), UserAgent: r.UserAgent()}
+	}
+	var path string // where command plugins can read an upload's content
+	for _, p := range plugins {
+		if p.Point != point {
+			continue
+		}
+		preq := req
+		if len(p.Command) > 0 && point != plugin.PreDownload {
+			if path == "" {
+				var done func()
+				var err error
+				if path, done, err = s.localCopy(ctx, f.BlobKey()); err != nil {
+					s.logFor(ctx).Error("plugin %s: %s: %v", plugin.Name(p), f.ID, err)
+					return http.StatusInternalServerError, "could not store upload"
+				}
+				defer done()
+			}
+			preq.Path = path
+		}
+		res, err := plugin.Run(ctx, p, preq)
+		switch {
+		case err != nil && p.FailOpen:
+			s.logFor(ctx).Error("%v (going ahead, fail_open is set)", err)
+		case err != nil:
+			s.logFor(ctx).Error("%v", err)
+			return http.StatusServiceUnavailable, "a server plugin failed, try again later"
+		case res.Deny:
+			msg := res.Message
+			if msg == "" {
+				msg = "refused by server policy"
+			}
+			s.logFor(ctx).Info("plugin %s denied %s of %s: %s", plugin.Name(p), point, f.ID, msg)
+			return http.StatusForbidden, msg
+		}
+	}
+	return 0, ""
+}
+
+// postUploadPlugins runs the post_upload plugins. They can't undo the upload; failures are
+// only logged.
+func (s *Server) postUploadPlugins(f *metadata.File) {
+	s.runPlugins(context.Background(), plugin.PostUpload, nil, f)
+}
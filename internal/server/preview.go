@@ -0,0 +1,148 @@
+package server
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/hey-granth/filegoblin/internal/highlight"
+	"github.com/hey-granth/filegoblin/internal/markdown"
+	"github.com/hey-granth/filegoblin/internal/metadata"
+	"github.com/hey-granth/filegoblin/internal/webui"
+)
+
+// pdfCSP is sent with PDF previews. It leaves out userContentCSP's sandbox, which keeps the
+// browsers' built-in PDF viewers from loading; scripts inside a PDF run in the viewer, not
+// with the server's origin.
+const pdfCSP = "default-src 'none'; frame-ancestors 'none'"
+
+// previewKind says how /f/{id}/preview shows f: "pdf", "csv", "markdown", "text", or "" for
+// no preview.
+func previewKind(f *metadata.File) string {
+	if f.Encrypted {
+		return ""
+	}
+	ext := strings.ToLower(filepath.Ext(f.Name))
+	switch ct := baseType(f.ContentType); {
+	case ct == "application/pdf":
+		return "pdf"
+	case ct == "text/csv":
+		return "csv"
+	case ct == "text/markdown" || (textual(ct) && (ext == ".md" || ext == ".markdown")):
+		return "markdown"
+	case textual(ct):
+		return "text"
+	}
+	return ""
+}
+
+// handlePreview shows a file in the browser: text and code as a highlighted listing with line
+// numbers, CSV as a table, Markdown rendered (or as source, with ?source=1), PDFs in the
+// browser's viewer. Text pages are rendered on the server and sent under userContentCSP, so
+// they run no script at all.
+func (s *Server) handlePreview(w http.ResponseWriter, r *http.Request) {
+	pc := s.config().Preview
+	f, err := s.liveFile(r.PathValue("id"))
+	if err != nil || !pc.Enabled {
+		http.NotFound(w, r)
+		return
+	}
+	kind := previewKind(f)
+	if kind == "" {
+		textError(w, r, "no preview for this type of file", http.StatusUnsupportedMediaType)
+		return
+	}
+	if !s.previewAllowed(w, r, f) {
+		return
+	}
+	if (kind != "pdf" || rangeFromStart(r)) && !s.verifyBeforeServe(r.Context(), f) {
+		textError(w, r, "file failed its integrity check", http.StatusInternalServerError)
+		return
+	}
+	rc, err := s.store.Get(r.Context(), f.BlobKey())
+	if err != nil {
+		s.logFor(r.Context()).Error("open blob %s: %v", f.ID, err)
+		textError(w, r, "file unavailable", http.StatusInternalServerError)
+		return
+	}
+	defer rc.Close()
+
+	if kind == "pdf" {
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Security-Policy", pdfCSP)
+		if rs, ok := rc.(io.ReadSeeker); ok {
+			http.ServeContent(w, r, f.Name, f.CreatedAt, rs)
+			return
+		}
+		if _, err := io.Copy(w, rc); err != nil {
+			s.logFor(r.Context()).Error("send %s: %v", f.ID, err)
+		}
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(rc, int64(pc.MaxBytes)+1))
+	if err != nil {
+		s.logFor(r.Context()).Error("preview %s: %v", f.ID, err)
+		textError(w, r, "file unavailable", http.StatusInternalServerError)
+		return
+	}
+	p := webui.Preview{Name: f.Name, Download: s.prefix + "/f/" + f.ID}
+	q := r.URL.Query()
+	source := q.Get("source") != ""
+	q.Del("source")
+	if len(q) > 0 {
+		p.Download += "?" + q.Encode() // keeps a link signature working
+	}
+	if int64(len(data)) > int64(pc.MaxBytes) {
+		// end at a line break, so the last line shown is a whole one
+		data = data[:pc.MaxBytes]
+		if i := bytes.LastIndexByte(data, '\n'); i > 0 {
+			data = data[:i+1]
+		}
+		p.Truncated = true
+	}
+	text := strings.ToValidUTF8(string(data), "�")
+	if kind == "markdown" && !source {
+		// There are no folders for a relative link to point into, so those stay plain text
+		// rather than guessing at other files.
+		p.HTML = markdown.Render(text, nil)
+		q.Set("source", "1")
+		p.Source = s.prefix + "/f/" + f.ID + "/preview?" + q.Encode()
+	}
+	if kind == "csv" {
+		p.Rows, err = csvRows(text, pc.MaxRows+1)
+		if len(p.Rows) > pc.MaxRows {
+			p.Rows, p.Truncated = p.Rows[:pc.MaxRows], true
+		}
+	}
+	if p.HTML == "" && (kind != "csv" || err != nil || len(p.Rows) == 0) {
+		// not a table after all: show it as text
+		p.Rows = nil
+		p.Lines = highlight.Lines(highlight.Language(f.Name), text)
+	}
+	w.Header().Set("Content-Security-Policy", userContentCSP)
+	s.ui(r).ServePreview(w, r, p)
+}
+
+// csvRows parses up to limit records of CSV. Rows may differ in length.
+func csvRows(text string, limit int) ([][]string, error) {
+	cr := csv.NewReader(strings.NewReader(text))
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	var rows [][]string
+	for len(rows) < limit {
+		rec, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, rec)
+	}
+	return rows, nil
+}
@@ -0,0 +1,16 @@
+package server
+
+import "github.com/hey-granth/filegoblin/internal/metadata"
+
+// postProcess runs the work that follows a successful upload in the background, so the
+// uploader gets an answer as soon as the file is stored. Each step handles its own errors:
+// a failure here never takes back an upload.
+func (s *Server) postProcess(f *metadata.File) {
+	s.background.Add(1)
+	go func() {
+		defer s.background.Done()
+		s.makeThumbnails(f)
+		s.makeMediaPreviews(f)
+		s.postUploadPlugins(f)
+	}()
+}
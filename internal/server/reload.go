@@ -0,0 +1,182 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+
+	"github.com/hey-granth/filegoblin/internal/config"
+	"github.com/hey-granth/filegoblin/internal/logx"
+)
+
+// SetReloader tells the server where fresh configuration comes from for Reload and the
+// /admin/reload endpoint.
+func (s *Server) SetReloader(r Reloader) { s.reloader = r }
+
+// Reload fetches a new configuration from the reloader and applies it, then re-reads the
+// user registry, the link signing keys and the abuse report queue.
+func (s *Server) Reload() error {
+	if s.reloader == nil {
+		return errors.New("reload is not configured")
+	}
+	next, err := s.reloader()
+	if err != nil {
+		return err
+	}
+	if err := s.Apply(next); err != nil {
+		return err
+	}
+	// pick up users, keys, signing keys and reports edited offline while we were running
+	if err := s.users.Reload(); err != nil {
+		return err
+	}
+	if err := s.links.Reload(); err != nil {
+		return err
+	}
+	return s.reports.Reload()
+}
+
+// Apply swaps in the safe-to-change parts of next: limits, auth keys, lifecycle rules and
+// TLS certificates. Settings that only take effect at startup (listen address, data
+// directory, cluster membership, whether TLS is on at all) keep their current values, and we say so in the log
+// rather than silently ignoring the edit. Nothing changes if the new TLS files can't be loaded.
+func (s *Server) Apply(next *config.Config) error {
+	cur := s.config()
+	merged := *next
+
+	if merged.Listen != cur.Listen {
+		s.log.Warn("reload: listen address change to %q needs a restart, keeping %q", merged.Listen, cur.Listen)
+		merged.Listen = cur.Listen
+	}
+	if merged.DataDir != cur.DataDir {
+		s.log.Warn("reload: data_dir change to %q needs a restart, keeping %q", merged.DataDir, cur.DataDir)
+		merged.DataDir = cur.DataDir
+	}
+	if !reflect.DeepEqual(merged.Storage, cur.Storage) {
+		s.log.Warn("reload: storage settings only change on restart, keeping the current ones")
+		merged.Storage = cur.Storage
+	}
+	if merged.UI != cur.UI {
+		s.log.Warn("reload: ui settings only change on restart, keeping the current ones")
+		merged.UI = cur.UI
+	}
+	if !reflect.DeepEqual(merged.Branding, cur.Branding) {
+		s.log.Warn("reload: branding only changes on restart, keeping the current settings")
+		merged.Branding = cur.Branding
+	}
+	if merged.Challenge.Provider == "pow" && merged.Challenge.Secret != cur.Challenge.Secret {
+		s.log.Warn("reload: the proof-of-work secret only changes on restart, keeping the current one")
+		merged.Challenge.Secret = cur.Challenge.Secret
+	}
+	if merged.Bus.NATS != cur.Bus.NATS {
+		s.log.Warn("reload: bus.nats only changes on restart, keeping the current server")
+		merged.Bus.NATS = cur.Bus.NATS
+	}
+	if merged.Cluster != cur.Cluster {
+		s.log.Warn("reload: cluster settings only change on restart, keeping the current ones")
+		merged.Cluster = cur.Cluster
+	}
+	logSettings := cur.Log
+	logSettings.Level, logSettings.Levels, logSettings.Access = merged.Log.Level, merged.Log.Levels, merged.Log.Access
+	logSettings.RedactFields, logSettings.Redact = merged.Log.RedactFields, merged.Log.Redact
+	logSettings.Include, logSettings.Exclude = merged.Log.Include, merged.Log.Exclude
+	logSettings.Dedup, logSettings.Caller = merged.Log.Dedup, merged.Log.Caller
+	logSettings.Sync = merged.Log.Sync
+	logSettings.Recent, logSettings.ErrorStacks = merged.Log.Recent, merged.Log.ErrorStacks
+	logSettings.TimeFormat, logSettings.TimeZone = merged.Log.TimeFormat, merged.Log.TimeZone
+	if !reflect.DeepEqual(merged.Log, logSettings) {
+		s.log.Warn("reload: log settings other than level, levels, access, redaction, filters, dedup, caller, error stacks, recent, time format and sync only change on restart, keeping the current ones")
+		merged.Log = logSettings
+	}
+	if merged.TLS.Enabled() != cur.TLS.Enabled() {
+		s.log.Warn("reload: turning TLS on or off needs a restart, keeping the current tls settings")
+		merged.TLS = cur.TLS
+	}
+	if merged.TLS.Enabled() {
+		if err := s.loadCert(merged.TLS); err != nil {
+			return err
+		}
+	}
+	s.cfg.Store(&merged)
+	if merged.Log.Level != cur.Log.Level { // else keep a level set through /admin/loglevel
+		s.applyLogLevel(merged.Log.Level)
+	}
+	s.applyLevels(merged.Log)
+	s.applyRedaction(merged.Log)
+	s.applyFilters(merged.Log)
+	s.applySync(merged.Log.Sync)
+	s.applyTimeFormat(merged.Log)
+	if merged.Log.Dedup != cur.Log.Dedup {
+		s.log.SetDedup(merged.Log.Dedup)
+	}
+	s.log.SetCaller(merged.Log.Caller)
+	s.log.SetErrorStacks(merged.Log.ErrorStacks)
+	if merged.Log.Recent != cur.Log.Recent {
+		s.log.KeepRecent(merged.Log.Recent)
+	}
+	s.log.Info("configuration reloaded")
+	return nil
+}
+
+// applyLogLevel sets the log level from the configuration, which Validate has checked.
+func (s *Server) applyLogLevel(name string) {
+	if lv, err := logx.ParseLevel(name); err == nil {
+		s.log.SetLevel(lv)
+	}
+}
+
+// applyLevels sets the levels of named loggers from the configuration, which Validate has
+// checked.
+func (s *Server) applyLevels(lc config.Log) {
+	levels := make(map[string]logx.Level, len(lc.Levels))
+	for name, level := range lc.Levels {
+		if lv, err := logx.ParseLevel(level); err == nil {
+			levels[name] = lv
+		}
+	}
+	s.log.SetLevels(levels)
+}
+
+// applyRedaction sets what the log masks from the configuration, which Validate has checked.
+func (s *Server) applyRedaction(lc config.Log) {
+	if r, err := logx.NewRedactor(lc.RedactFields, lc.Redact); err == nil {
+		s.log.SetRedactor(r)
+	}
+}
+
+// applyFilters sets what the log drops from the configuration, which Validate has checked.
+func (s *Server) applyFilters(lc config.Log) {
+	if include, exclude, err := lc.Filters(); err == nil {
+		s.log.SetFilters(include, exclude)
+	}
+}
+
+// applySync sets from which level the log is synced to disk, from the configuration, which
+// Validate has checked.
+func (s *Server) applySync(name string) {
+	lv := logx.NoSync
+	if name != "" {
+		lv, _ = logx.ParseLevel(name)
+	}
+	s.log.SetSync(lv)
+}
+
+// applyTimeFormat sets how log lines write the time, which Validate has checked.
+func (s *Server) applyTimeFormat(lc config.Log) {
+	if layout, loc, err := lc.TimeLayout(); err == nil {
+		s.log.SetTimeFormat(layout, loc)
+	}
+}
+
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(r) {
+		writeError(w, r, http.StatusForbidden, "admin key required")
+		return
+	}
+	if err := s.Reload(); err != nil {
+		s.logFor(r.Context()).Error("reload: %v", err)
+		writeError(w, r, http.StatusBadRequest, "reload failed: "+err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "reloaded"})
+}
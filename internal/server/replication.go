@@ -0,0 +1,40 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/storage"
+)
+
+// runRepair catches replicas up on the blobs they missed while they were down, every
+// storage.replication.repair_interval until ctx is done. Each server notes the copies it
+// failed to make itself, so in a cluster every one of them repairs, not just the leader.
+func (s *Server) runRepair(ctx context.Context) {
+	replicated, ok := storage.Find[*storage.Replicated](s.store)
+	if !ok {
+		return
+	}
+	every := s.config().Storage.Replication.RepairInterval
+	if every <= 0 {
+		every = 5 * time.Minute
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(every):
+		}
+		res, err := replicated.Repair(ctx, func(key string, err error) {
+			if err != nil {
+				s.log.Warn("replication: repair %s: %v", key, err)
+			}
+		})
+		if err != nil && ctx.Err() == nil {
+			s.log.Error("replication: %v", err)
+		}
+		if res.Repaired > 0 || res.Pending > 0 {
+			s.log.Info("replication: repaired %d blobs, %d still missing a copy", res.Repaired, res.Pending)
+		}
+	}
+}
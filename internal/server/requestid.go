@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/hey-granth/filegoblin/internal/logx"
+)
+
+// withRequestID gives every request an ID, returned in the X-Request-Id header and written
+// with every log line about the request. An ID set by a proxy in front is kept, so its logs
+// and ours can be matched up, as long as it is short and plain enough to log as it is.
+func (s *Server) withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-Id")
+		if !validRequestID(id) {
+			id = newID()
+			r.Header.Set("X-Request-Id", id)
+		}
+		w.Header().Set("X-Request-Id", id)
+		log := s.log.With("request_id", id)
+		if trace, span, ok := traceParent(r.Header.Get("Traceparent")); ok {
+			log = log.With("trace_id", trace).With("span_id", span)
+		}
+		ctx := logx.NewContext(r.Context(), log)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// traceParent returns the trace and parent span IDs of a W3C traceparent header, which a
+// traced client or proxy sends, so the request's log lines show up with its trace:
+// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01".
+func traceParent(h string) (trace, span string, ok bool) {
+	parts := strings.Split(h, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[3]) != 2 {
+		return "", "", false
+	}
+	trace, span = parts[1], parts[2]
+	if len(trace) != 32 || len(span) != 16 || !isLowerHex(h) ||
+		strings.Trim(trace, "0") == "" || strings.Trim(span, "0") == "" {
+		return "", "", false
+	}
+	return trace, span, true
+}
+
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// logFor returns the log for work done on behalf of the request ctx belongs to, which adds
+// the request's ID to each line, or the server's own log outside a request.
+func (s *Server) logFor(ctx context.Context) *logx.Logger {
+	if l := logx.FromContext(ctx); l != nil {
+		return l
+	}
+	return s.log
+}
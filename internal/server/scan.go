@@ -0,0 +1,80 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/metadata"
+	"github.com/hey-granth/filegoblin/internal/scan"
+)
+
+// scanUpload runs the configured virus scanner over a blob that has been stored but not yet
+// published (it has no index record yet, so nobody can download it). It records the verdict
+// on f and returns true when the upload may go ahead; otherwise it has already cleaned up and
+// written the response.
+func (s *Server) scanUpload(w http.ResponseWriter, r *http.Request, f *metadata.File) bool {
+	cfg := s.config().Scan
+	scanner, err := scan.New(cfg)
+	if err != nil || scanner == nil {
+		return true // config validation rejects bad scanner settings, so err can't happen here
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), cfg.Timeout)
+	defer cancel()
+	v, err := s.scanBlob(ctx, scanner, f.BlobKey())
+	f.Scan = &metadata.Scan{ScannedAt: time.Now().UTC()}
+	switch {
+	case err != nil && cfg.FailOpen:
+		s.logFor(r.Context()).Error("scan %s: %v (publishing anyway, fail_open is set)", f.ID, err)
+		f.Scan.Verdict, f.Scan.Signature = metadata.ScanFailed, err.Error()
+		return true
+	case err != nil:
+		s.logFor(r.Context()).Error("scan %s: %v", f.ID, err)
+		s.discard(f.ID)
+		writeError(w, r, http.StatusServiceUnavailable, "virus scanner unavailable, try again later")
+		return false
+	case !v.Infected:
+		f.Scan.Verdict = metadata.ScanClean
+		return true
+	}
+
+	f.Scan.Verdict, f.Scan.Signature = metadata.ScanInfected, v.Signature
+	if cfg.Action == "quarantine" {
+		if err := s.index.Put(f); err != nil {
+			s.logFor(r.Context()).Error("index %s: %v", f.ID, err)
+			s.discard(f.ID)
+		}
+		s.logFor(r.Context()).Error("quarantined %s (%q from %s): %s", f.ID, f.Name, ownerLabel(f.Owner), v.Signature)
+		writeErrorf(w, r, http.StatusUnprocessableEntity, "upload quarantined: %s detected", v.Signature)
+		return false
+	}
+	s.discard(f.ID)
+	s.logFor(r.Context()).Error("rejected %s (%q from %s): %s", f.ID, f.Name, ownerLabel(f.Owner), v.Signature)
+	writeErrorf(w, r, http.StatusUnprocessableEntity, "upload rejected: %s detected", v.Signature)
+	return false
+}
+
+func (s *Server) scanBlob(ctx context.Context, scanner scan.Scanner, id string) (scan.Verdict, error) {
+	rc, err := s.store.Get(ctx, id)
+	if err != nil {
+		return scan.Verdict{}, err
+	}
+	defer rc.Close()
+	return scanner.Scan(ctx, rc)
+}
+
+// discard deletes a blob that will never be published.
+func (s *Server) discard(id string) {
+	// the request may already be cancelled, and the blob must go regardless
+	if err := s.store.Delete(context.Background(), id); err != nil {
+		s.log.Error("delete blob %s: %v", id, err)
+	}
+}
+
+func ownerLabel(owner string) string {
+	if owner == "" {
+		return "anonymous"
+	}
+	return owner
+}
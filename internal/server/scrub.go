@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/config"
+	"github.com/hey-granth/filegoblin/internal/fsck"
+)
+
+// corruptEvent is the data of a "corrupt" event: a scrub found a file's blob damaged or gone.
+type corruptEvent struct {
+	File     hookFile `json:"file"`
+	Detail   string   `json:"detail"`
+	Repaired bool     `json:"repaired"`
+}
+
+// runScrub reads back every blob and checks it against its checksum every
+// integrity.scrub_interval until ctx is done. The settings are read fresh each time, and in a
+// cluster only the leader scrubs, as the blobs are shared.
+func (s *Server) runScrub(ctx context.Context) {
+	for {
+		every := s.config().Integrity.ScrubInterval
+		if every <= 0 {
+			every = time.Minute // off; look again later
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(every):
+		}
+		ic := s.config().Integrity
+		if ic.ScrubInterval <= 0 || !s.isLeader() {
+			continue
+		}
+		res, err := fsck.Scrub(ctx, s.store, s.index, fsck.ScrubOptions{Rate: int64(ic.ScrubRate), Progress: s.scrubbed})
+		if err != nil && ctx.Err() == nil {
+			s.log.Error("scrub: %v", err)
+		}
+		s.log.Info("scrub: checked %d blobs (%s): %d corrupt, %d repaired, %d missing",
+			res.Checked, config.ByteSize(res.Bytes), res.Corrupt, res.Repaired, res.Missing)
+	}
+}
+
+// scrubbed logs a problem a scrub found, and sends it as a "corrupt" event.
+func (s *Server) scrubbed(p fsck.Problem, repaired bool) {
+	detail := p.Detail
+	if p.Kind == fsck.Missing {
+		detail = "blob is missing"
+	}
+	if repaired {
+		s.log.Warn("scrub: file %s was corrupt, repaired from a replica: %s", p.ID, detail)
+	} else {
+		s.log.Error("scrub: file %s is %s: %s", p.ID, p.Kind, detail)
+	}
+	f, err := s.index.Get(p.ID)
+	if err != nil {
+		return
+	}
+	s.notify("corrupt", corruptEvent{File: hookFile{File: f}, Detail: detail, Repaired: repaired})
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hey-granth/filegoblin/internal/metadata"
+)
+
+// Limits on what files are labelled with.
+const (
+	maxDescriptionLen = 4 << 10
+	maxTags           = 32
+	maxTagLen         = 64
+)
+
+// cleanTags trims and lowercases tags and drops empty and repeated ones.
+func cleanTags(tags []string) ([]string, error) {
+	var out []string
+	for _, t := range tags {
+		t = strings.ToLower(strings.TrimSpace(t))
+		switch {
+		case t == "" || slices.Contains(out, t):
+			continue
+		case utf8.RuneCountInString(t) > maxTagLen || strings.ContainsAny(t, ",\r\n"):
+			return nil, fmt.Errorf("tag %q must be at most %d characters, without commas or line breaks", t, maxTagLen)
+		}
+		out = append(out, t)
+	}
+	if len(out) > maxTags {
+		return nil, fmt.Errorf("a file can have at most %d tags", maxTags)
+	}
+	return out, nil
+}
+
+func checkDescription(s string) error {
+	if len(s) > maxDescriptionLen {
+		return fmt.Errorf("description must be at most %d bytes", maxDescriptionLen)
+	}
+	return nil
+}
+
+// uploadLabels applies an upload's description and tags, the latter comma-separated, to f.
+func uploadLabels(r *http.Request, f *metadata.File) error {
+	q := r.URL.Query()
+	if err := checkDescription(q.Get("description")); err != nil {
+		return err
+	}
+	tags, err := cleanTags(strings.Split(q.Get("tags"), ","))
+	if err != nil {
+		return err
+	}
+	f.Description, f.Tags = q.Get("description"), tags
+	return nil
+}
+
+// searchResponse is a page of search results: files, best match first, from offset on in
+// the total that matched.
+type searchResponse struct {
+	Total  int            `json:"total"`
+	Offset int            `json:"offset"`
+	Files  []fileResponse `json:"files"`
+}
+
+// handleSearch finds the files whose name, tags, description or content type have a word
+// starting with each word of ?q=, a page of ?limit= (20 unless set, at most 100) from
+// ?offset= at a time. Like list, it only looks at the caller's own files when there are keys,
+// and takes metadata and tag filters (see metaFilter and tagFilter).
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	owner, ok := s.authorize(r)
+	if !ok {
+		writeError(w, r, http.StatusUnauthorized, "missing or invalid API key")
+		return
+	}
+	q := r.URL.Query()
+	if strings.TrimSpace(q.Get("q")) == "" {
+		writeError(w, r, http.StatusBadRequest, "q must have something to search for")
+		return
+	}
+	limit, offset := 20, 0
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 100 {
+			writeError(w, r, http.StatusBadRequest, "limit must be a number from 1 to 100")
+			return
+		}
+		limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, r, http.StatusBadRequest, "offset must be a whole number")
+			return
+		}
+		offset = n
+	}
+	found, err := s.index.Search(q.Get("q"))
+	if err != nil {
+		s.logFor(r.Context()).ErrorE(err, "search")
+		writeError(w, r, http.StatusInternalServerError, "could not search")
+		return
+	}
+	tagged, err := parseTagFilter(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter := metaFilter(r)
+	res := searchResponse{Offset: offset, Files: []fileResponse{}}
+	for _, f := range found {
+		if (owner != "" && f.Owner != owner) || f.Trashed != nil || !matchMeta(f, filter) || !tagged.match(f) {
+			continue
+		}
+		if res.Total >= offset && len(res.Files) < limit {
+			res.Files = append(res.Files, s.fileResponse(r, f))
+		}
+		res.Total++
+	}
+	writeJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,315 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/abuse"
+	"github.com/hey-granth/filegoblin/internal/audit"
+	"github.com/hey-granth/filegoblin/internal/auth"
+	"github.com/hey-granth/filegoblin/internal/bus"
+	"github.com/hey-granth/filegoblin/internal/challenge"
+	"github.com/hey-granth/filegoblin/internal/config"
+	"github.com/hey-granth/filegoblin/internal/journal"
+	"github.com/hey-granth/filegoblin/internal/logx"
+	"github.com/hey-granth/filegoblin/internal/metadata"
+	"github.com/hey-granth/filegoblin/internal/metrics"
+	"github.com/hey-granth/filegoblin/internal/signing"
+	"github.com/hey-granth/filegoblin/internal/storage"
+	"github.com/hey-granth/filegoblin/internal/webhook"
+	"github.com/hey-granth/filegoblin/internal/webui"
+)
+
+// Reloader produces a fresh configuration, usually by re-reading the config file.
+type Reloader func() (*config.Config, error)
+
+// Server is the long-running filegoblin daemon: an HTTP API for uploading, listing,
+// downloading and deleting files on top of a storage backend and the metadata index.
+type Server struct {
+	// cfg is swapped wholesale on reload. Handlers grab the current snapshot once per request,
+	// so a request that is already running keeps the settings it started with.
+	cfg        atomic.Pointer[config.Config]
+	cert       atomic.Pointer[tls.Certificate]
+	reloader   Reloader
+	authn      Authenticator // replaces API keys when set
+	prefix     string        // path the handler is mounted at, "" for the root
+	users      *auth.Registry
+	links      *signing.Keyring
+	reports    *abuse.Store
+	trail      *audit.Log // nil when no audit log is kept
+	hooks      *webhook.Dispatcher
+	bus        *bus.Publisher // nil when events aren't published to a message bus
+	pow        *challenge.PoW
+	guesses    *lockout
+	transfers  *transferTracker
+	leader     atomic.Bool // holds the cluster lease; see isLeader
+	sweepMu    sync.Mutex
+	sweeps     lifecycleStats
+	cacheMu    sync.Mutex     // one preview is made at a time
+	background sync.WaitGroup // post-processing still running
+	store      storage.Backend
+	blobMu     sync.Mutex // between sharing a deduplicated blob and deleting it
+	countMu    sync.Mutex // held while a download is counted against its file's limit
+	index      *metadata.Index
+	journal    *journal.Journal // nil when uploads aren't journaled
+	log        *logx.Logger
+	accessOut  io.Writer // combined access log lines; the log's writer when nil
+	accessMu   sync.Mutex
+	web        *webui.UI
+	tenants    map[string]*webui.UI // branded UIs by domain
+	mux        *http.ServeMux
+	metrics    *metrics.Registry
+}
+
+// New wires up the routes. Nothing is listening until Serve is called.
+func New(cfg *config.Config, store storage.Backend, index *metadata.Index, users *auth.Registry, links *signing.Keyring, reports *abuse.Store, trail *audit.Log, log *logx.Logger) *Server {
+	s := &Server{store: store, index: index, users: users, links: links, reports: reports, trail: trail, pow: challenge.NewPoW(cfg.Challenge.Secret), guesses: newLockout(), transfers: newTransferTracker(), log: log, mux: http.NewServeMux()}
+	if co, ok := index.Store().(metadata.Coordinator); ok && cfg.Cluster.Enabled() {
+		s.pow.Share(co) // a solution is spent once across the cluster, not once per server
+	}
+	s.hooks = webhook.New(s.webhookGaveUp)
+	if cfg.Bus.NATS != "" {
+		var err error
+		if s.bus, err = bus.New(cfg.Bus.NATS, log.Error); err != nil {
+			log.Error("%v", err) // Validate lets no such URL through
+		}
+	}
+	s.web, s.tenants = newUIs(cfg, "")
+	s.cfg.Store(cfg)
+	s.applyLogLevel(cfg.Log.Level)
+	s.applyLevels(cfg.Log)
+	s.applyRedaction(cfg.Log)
+	s.applyFilters(cfg.Log)
+	s.applySync(cfg.Log.Sync)
+	s.applyTimeFormat(cfg.Log)
+	s.log.SetDedup(cfg.Log.Dedup)
+	s.log.SetCaller(cfg.Log.Caller)
+	s.log.SetErrorStacks(cfg.Log.ErrorStacks)
+	s.log.KeepRecent(cfg.Log.Recent)
+	log.KeepErrors(recentErrors) // for the admin dashboard
+	s.metrics = s.newMetrics()
+	s.routes()
+	return s
+}
+
+func (s *Server) routes() {
+	s.mux.HandleFunc("GET /healthz", s.handleHealth)
+	s.mux.HandleFunc("GET /metrics", s.handleMetrics)
+	s.mux.HandleFunc("POST /api/files", s.handleUpload)
+	s.mux.HandleFunc("GET /api/files", s.handleList)
+	s.mux.HandleFunc("GET /api/files/{id}", s.handleStat)
+	s.mux.HandleFunc("PATCH /api/files/{id}", s.handleUpdateFile)
+	s.mux.HandleFunc("DELETE /api/files/{id}", s.handleDelete)
+	s.mux.HandleFunc("POST /api/files/{id}/verify", s.handleVerify)
+	s.mux.HandleFunc("GET /api/files/{id}/versions", s.handleVersions)
+	s.mux.HandleFunc("POST /api/files/{id}/versions/{version}/restore", s.handleRestoreVersion)
+	s.mux.HandleFunc("POST /api/files/{id}/tags", s.handleAddTags)
+	s.mux.HandleFunc("DELETE /api/files/{id}/tags/{tag}", s.handleRemoveTag)
+	s.mux.HandleFunc("GET /api/tags", s.handleListTags)
+	s.mux.HandleFunc("GET /api/search", s.handleSearch)
+	s.mux.HandleFunc("GET /api/trash", s.handleListTrash)
+	s.mux.HandleFunc("POST /api/trash/{id}/restore", s.handleRestore)
+	s.mux.HandleFunc("DELETE /api/trash/{id}", s.handlePurge)
+	s.mux.HandleFunc("POST /api/files/{id}/report", s.exclusive("reports", s.reports.Reload, s.handleReport))
+	s.mux.HandleFunc("POST /api/fetch", s.handleFetch)
+	s.mux.HandleFunc("GET /api/notices", s.handleNotices)
+	s.mux.HandleFunc("GET /api/challenge", s.handleChallenge)
+	s.mux.HandleFunc("GET /f/{id}", s.handleDownload)
+	// encrypted links need the decryption page and its assets even with the UI turned off
+	s.mux.HandleFunc("GET /f/{id}/thumb", s.handleThumb)
+	s.mux.HandleFunc("GET /f/{id}/poster", s.handlePoster)
+	s.mux.HandleFunc("GET /f/{id}/clip", s.handleClip)
+	s.mux.HandleFunc("GET /f/{id}/preview", s.handlePreview)
+	s.mux.HandleFunc("GET /e/{id}", s.handleDecryptPage)
+	s.mux.HandleFunc("GET /assets/{name...}", s.serveAsset)
+	if s.config().UI.Enabled {
+		s.mux.HandleFunc("GET /{$}", s.handleIndex)
+	}
+	if s.config().UI.Enabled {
+		s.mux.HandleFunc("GET /admin/{$}", s.serveAdmin)
+		s.mux.HandleFunc("GET /s/{id}", s.handleShare)
+	}
+	s.mux.HandleFunc("POST /admin/reload", s.handleReload)
+	s.mux.HandleFunc("GET /admin/stats", s.admin(s.handleStats))
+	s.mux.HandleFunc("GET /admin/loglevel", s.admin(s.handleGetLogLevel))
+	s.mux.HandleFunc("PUT /admin/loglevel", s.admin(s.handleSetLogLevel))
+	s.mux.HandleFunc("GET /admin/logs", s.admin(s.handleLogs))
+	s.mux.HandleFunc("GET /admin/users", s.admin(s.handleListUsers))
+	s.mux.HandleFunc("POST /admin/users", s.admin(s.exclusive("users", s.users.Reload, s.handleAddUser)))
+	s.mux.HandleFunc("DELETE /admin/users/{name}", s.admin(s.exclusive("users", s.users.Reload, s.handleRemoveUser)))
+	s.mux.HandleFunc("PUT /admin/users/{name}/quota", s.admin(s.exclusive("users", s.users.Reload, s.handleSetQuota)))
+	s.mux.HandleFunc("GET /admin/users/{name}/keys", s.admin(s.handleListKeys))
+	s.mux.HandleFunc("POST /admin/users/{name}/keys", s.admin(s.exclusive("users", s.users.Reload, s.handleCreateKey)))
+	s.mux.HandleFunc("DELETE /admin/keys/{id}", s.admin(s.exclusive("users", s.users.Reload, s.handleRevokeKey)))
+	s.mux.HandleFunc("GET /admin/signing-keys", s.admin(s.handleListSigningKeys))
+	s.mux.HandleFunc("POST /admin/signing-keys/rotate", s.admin(s.exclusive("signing", s.links.Reload, s.handleRotateSigningKey)))
+	s.mux.HandleFunc("GET /admin/reports", s.admin(s.handleListReports))
+	s.mux.HandleFunc("POST /admin/reports/{id}/takedown", s.admin(s.exclusive("reports", s.reports.Reload, s.handleTakedown)))
+	s.mux.HandleFunc("POST /admin/reports/{id}/dismiss", s.admin(s.exclusive("reports", s.reports.Reload, s.handleDismissReport)))
+	s.mux.HandleFunc("GET /admin/webhooks/deliveries", s.admin(s.handleWebhookDeliveries))
+	s.mux.HandleFunc("POST /admin/webhooks/test", s.admin(s.handleWebhookTest))
+}
+
+// Handler returns the router wrapped in the security headers and language middleware, in
+// the prefix set by SetPrefix, in the access log, and in request IDs.
+func (s *Server) Handler() http.Handler {
+	h := s.withHeaders(s.withLocale(s.mux))
+	if s.prefix == "" {
+		return s.withRequestID(s.withAccessLog(h))
+	}
+	return s.withRequestID(s.withAccessLog(http.StripPrefix(s.prefix, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "" {
+			r.URL.Path = "/" // the prefix itself is the index page
+		}
+		h.ServeHTTP(w, r)
+	}))))
+}
+
+// SetPrefix mounts the server at prefix, like "/files": Handler only answers paths under it,
+// and the links and pages it serves point back under it. Call it before serving.
+func (s *Server) SetPrefix(prefix string) {
+	s.prefix = strings.TrimRight(prefix, "/")
+	s.web, s.tenants = newUIs(s.config(), s.prefix)
+}
+
+// config returns the settings currently in effect.
+func (s *Server) config() *config.Config { return s.cfg.Load() }
+
+// Serve accepts connections on ln until ctx is cancelled, then gives in-flight requests
+// a grace period to finish before returning. It speaks HTTPS when TLS is configured.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	srv := &http.Server{
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second, // uploads can be slow, but headers shouldn't be
+		// TLS handshake failures, panics in handlers and the like, which net/http would
+		// otherwise print to standard error in a format of its own
+		ErrorLog: slog.NewLogLogger(logx.NewSlogHandler(s.log), slog.LevelWarn),
+	}
+	useTLS := s.config().TLS.Enabled()
+	if useTLS {
+		if err := s.loadCert(s.config().TLS); err != nil {
+			return err
+		}
+		// GetCertificate instead of a fixed certificate, so a reload can swap it under live connections
+		srv.TLSConfig = &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+				return s.cert.Load(), nil
+			},
+		}
+	}
+
+	s.startBackground(ctx)
+	errc := make(chan error, 1)
+	go func() {
+		if useTLS {
+			errc <- srv.ServeTLS(ln, "", "")
+		} else {
+			errc <- srv.Serve(ln)
+		}
+	}()
+	s.log.Info("listening on %s (tls=%v)", ln.Addr(), useTLS)
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	s.log.Info("shutting down, waiting for in-flight requests")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	s.drain()
+	return nil
+}
+
+// Run does what Serve does besides serving HTTP, for a program that serves Handler itself:
+// sweeping expired files, taking backups and taking part in a cluster. It returns once ctx
+// is cancelled and pending webhook deliveries and post-processing are done.
+func (s *Server) Run(ctx context.Context) {
+	s.startBackground(ctx)
+	<-ctx.Done()
+	s.drain()
+}
+
+func (s *Server) startBackground(ctx context.Context) {
+	if s.config().Cluster.Enabled() {
+		go s.runCluster(ctx)
+	}
+	go s.runLifecycle(ctx)
+	go s.runBackups(ctx)
+	go s.runTiering(ctx)
+	go s.runRepair(ctx)
+	go s.runGC(ctx)
+	go s.runScrub(ctx)
+}
+
+// drain waits for background work started by requests and flushes outgoing events.
+func (s *Server) drain() {
+	s.background.Wait()
+	s.hooks.Close()
+	if s.bus != nil {
+		s.bus.Close()
+	}
+}
+
+func (s *Server) loadCert(t config.TLS) error {
+	cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+	if err != nil {
+		return err
+	}
+	s.cert.Store(&cert)
+	return nil
+}
+
+// audit logs a security-relevant event and appends it to the audit log, with who sent r and
+// from where, and the file it concerns, if any. Failing to record it doesn't fail the action,
+// which has already happened by the time it is audited.
+func (s *Server) audit(r *http.Request, event, file, format string, args ...interface{}) {
+	rec := audit.Record{Event: event, Detail: fmt.Sprintf(format, args...), File: file}
+	log := s.log
+	if r != nil {
+		rec.Actor, rec.Key = s.requester(r)
+		rec.Remote = remoteIP(r)
+		log = s.logFor(r.Context())
+	}
+	log.Info("audit: %s: %s", event, rec.Detail)
+	if s.trail == nil {
+		return
+	}
+	if _, err := s.trail.Add(rec); err != nil {
+		log.Error("audit log: %v", err)
+	}
+}
+
+// requester says who sent r for the audit log: the owner authorize finds, or "admin" for an
+// admin key, and the ID of the key, or a fingerprint for keys from the config.
+func (s *Server) requester(r *http.Request) (actor, key string) {
+	token := bearerToken(r)
+	cfg := s.config()
+	if k, ok := matchKey(token, cfg.Auth.AdminKeys); ok {
+		return "admin", keyOwner(k)
+	}
+	actor, _ = s.authorize(r)
+	if id, ok := s.users.KeyID(token); ok {
+		key = id
+	} else if k, ok := matchKey(token, cfg.Auth.Keys); ok {
+		key = keyOwner(k)
+	}
+	return actor, key
+}
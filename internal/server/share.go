@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/hey-granth/filegoblin/internal/config"
+	"github.com/hey-granth/filegoblin/internal/webui"
+)
+
+// handleShare serves a share link's landing page: what the file is, a download button and a
+// preview when there is one, in the domain's branding. End-to-end encrypted files go to their
+// decryption page instead, which is the only place their name can be read.
+func (s *Server) handleShare(w http.ResponseWriter, r *http.Request) {
+	f, err := s.liveFile(r.PathValue("id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if f.Encrypted {
+		u := s.prefix + "/e/" + f.ID
+		if r.URL.RawQuery != "" {
+			u += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, u, http.StatusSeeOther)
+		return
+	}
+	if !s.previewAllowed(w, r, f) {
+		return
+	}
+	// links on the page carry this request's signature, if any, rather than fresh ones:
+	// reloading the page mustn't extend a link past its expiry
+	link := func(path string, extra ...string) string {
+		q := r.URL.RawQuery
+		for _, e := range extra {
+			if q != "" {
+				q += "&"
+			}
+			q += e
+		}
+		if q == "" {
+			return path
+		}
+		return path + "?" + q
+	}
+	page := webui.Share{
+		Name:      f.Name,
+		Size:      config.ByteSize(f.Size).String(),
+		Type:      baseType(f.ContentType),
+		CreatedAt: f.CreatedAt,
+		ExpiresAt: f.ExpiresAt,
+		Protected: f.PasswordHash != "",
+		Download:  link(s.prefix + "/f/" + f.ID),
+	}
+	if s.config().Preview.Enabled && previewKind(f) != "" {
+		page.Preview = link(s.prefix + "/f/" + f.ID + "/preview")
+	}
+	if s.config().Thumbnails.Enabled && thumbable(f) {
+		page.Image = link(s.prefix+"/f/"+f.ID+"/thumb", "w=512")
+	} else if s.config().Media.FFmpeg != "" && isVideo(f) {
+		page.Image = link(s.prefix + "/f/" + f.ID + "/poster")
+	}
+	s.ui(r).ServeShare(w, r, page)
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/hey-granth/filegoblin/internal/metadata"
+	"github.com/hey-granth/filegoblin/internal/thumb"
+)
+
+// maxStripSize is the largest image metadata is stripped from; the whole image is read into
+// memory to do it.
+const maxStripSize = 64 << 20
+
+var errStripTooLarge = errors.New("image too large to strip its metadata")
+
+// wantStrip reports whether metadata is to be stripped from upload f, by the server's
+// images.strip_metadata policy and the upload's ?strip=1.
+func (s *Server) wantStrip(r *http.Request, f *metadata.File) bool {
+	switch baseType(f.ContentType) {
+	case "image/jpeg", "image/png", "image/webp":
+	default:
+		return false
+	}
+	switch s.config().Images.StripMetadata {
+	case "always":
+		return true
+	case "on_request":
+		return r.URL.Query().Get("strip") == "1"
+	}
+	return false
+}
+
+// stripReader strips the metadata from the image read from r. It reads all of r on the
+// first Read, so a failure (an upload over the size limit, say) comes out of the store's Put
+// like any other read error.
+type stripReader struct {
+	r   io.Reader
+	out io.Reader
+}
+
+func (sr *stripReader) Read(p []byte) (int, error) {
+	if sr.out == nil {
+		data, err := io.ReadAll(io.LimitReader(sr.r, maxStripSize+1))
+		if err != nil {
+			return 0, err
+		}
+		if len(data) > maxStripSize {
+			return 0, errStripTooLarge
+		}
+		if data, err = thumb.Strip(data); err != nil {
+			return 0, err
+		}
+		sr.out = bytes.NewReader(data)
+	}
+	return sr.out.Read(p)
+}
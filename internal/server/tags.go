@@ -0,0 +1,135 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/hey-granth/filegoblin/internal/metadata"
+)
+
+// tagCount is one of the tags on GET /api/tags, with how many files have it.
+type tagCount struct {
+	Tag   string `json:"tag"`
+	Files int    `json:"files"`
+}
+
+// tagFilter is what list and search filter on by tag: ?tags=a,b for files with all of them,
+// or with any of them given ?match=any.
+type tagFilter struct {
+	tags []string
+	any  bool
+}
+
+func parseTagFilter(r *http.Request) (tagFilter, error) {
+	q := r.URL.Query()
+	tags, err := cleanTags(strings.Split(q.Get("tags"), ","))
+	if err != nil {
+		return tagFilter{}, err
+	}
+	switch q.Get("match") {
+	case "", "all":
+		return tagFilter{tags: tags}, nil
+	case "any":
+		return tagFilter{tags: tags, any: true}, nil
+	}
+	return tagFilter{}, errors.New("match must be all or any")
+}
+
+func (tf tagFilter) match(f *metadata.File) bool {
+	if len(tf.tags) == 0 {
+		return true
+	}
+	// a tag that has to be there isn't, or one of those that may be is
+	for _, t := range tf.tags {
+		if slices.Contains(f.Tags, t) == tf.any {
+			return tf.any
+		}
+	}
+	return !tf.any
+}
+
+// handleListTags lists the tags on the caller's files, or everyone's without keys, the most
+// used first.
+func (s *Server) handleListTags(w http.ResponseWriter, r *http.Request) {
+	owner, ok := s.authorize(r)
+	if !ok {
+		writeError(w, r, http.StatusUnauthorized, "missing or invalid API key")
+		return
+	}
+	counts := map[string]int{}
+	for _, f := range s.index.List() {
+		if (owner != "" && f.Owner != owner) || f.Trashed != nil {
+			continue
+		}
+		for _, t := range f.Tags {
+			counts[t]++
+		}
+	}
+	out := make([]tagCount, 0, len(counts))
+	for t, n := range counts {
+		out = append(out, tagCount{Tag: t, Files: n})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Files != out[j].Files {
+			return out[i].Files > out[j].Files
+		}
+		return out[i].Tag < out[j].Tag
+	})
+	writeJSON(w, http.StatusOK, out)
+}
+
+// handleAddTags adds the tags in the body, {"tags": ["a", "b"]}, to those a file has.
+func (s *Server) handleAddTags(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Tags []string `json:"tags"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	s.retag(w, r, func(tags []string) []string { return append(tags, req.Tags...) })
+}
+
+// handleRemoveTag takes a tag off a file. Removing one it doesn't have isn't an error.
+func (s *Server) handleRemoveTag(w http.ResponseWriter, r *http.Request) {
+	tag := strings.ToLower(strings.TrimSpace(r.PathValue("tag")))
+	s.retag(w, r, func(tags []string) []string {
+		return slices.DeleteFunc(tags, func(t string) bool { return t == tag })
+	})
+}
+
+// retag sets the tags of the caller's file to what change makes of them, and sends the file.
+func (s *Server) retag(w http.ResponseWriter, r *http.Request, change func([]string) []string) {
+	owner, ok := s.authorize(r)
+	if !ok {
+		writeError(w, r, http.StatusUnauthorized, "missing or invalid API key")
+		return
+	}
+	unlock, err := s.lockFile(r.PathValue("id"))
+	if err != nil {
+		s.writeLockError(w, r, err)
+		return
+	}
+	defer unlock()
+	f, err := s.liveFile(r.PathValue("id"))
+	if err != nil || (owner != "" && f.Owner != owner) {
+		writeError(w, r, http.StatusNotFound, "file not found")
+		return
+	}
+	tags, err := cleanTags(change(slices.Clone(f.Tags)))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	f.Tags = tags
+	if err := s.index.Put(f); err != nil {
+		s.logFor(r.Context()).ErrorE(err, "index", "file_id", f.ID)
+		writeError(w, r, http.StatusInternalServerError, "could not update file")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.fileResponse(r, f))
+}
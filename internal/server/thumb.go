@@ -0,0 +1,128 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/hey-granth/filegoblin/internal/config"
+	"github.com/hey-granth/filegoblin/internal/metadata"
+	"github.com/hey-granth/filegoblin/internal/thumb"
+)
+
+// maxThumbSource is the largest file a thumbnail is made from; the whole file is read into
+// memory to decode it.
+const maxThumbSource = 64 << 20
+
+// thumbable reports whether thumbnails can be made of f: a plain (not end-to-end encrypted)
+// image in a format the decoder reads.
+func thumbable(f *metadata.File) bool {
+	switch baseType(f.ContentType) {
+	case "image/jpeg", "image/png", "image/gif":
+		return !f.Encrypted
+	}
+	return false
+}
+
+// handleThumb serves a thumbnail of an image file.
+func (s *Server) handleThumb(w http.ResponseWriter, r *http.Request) {
+	tc := s.config().Thumbnails
+	f, err := s.liveFile(r.PathValue("id"))
+	if err != nil || !tc.Enabled || !thumbable(f) {
+		http.NotFound(w, r)
+		return
+	}
+	if !s.previewAllowed(w, r, f) {
+		return
+	}
+	width := tc.Sizes[len(tc.Sizes)-1]
+	if v := r.URL.Query().Get("w"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "w must be a positive width in pixels", http.StatusBadRequest)
+			return
+		}
+		width = thumbSize(tc.Sizes, n)
+	}
+	format := tc.Format
+	if (format == "webp" || format == "avif") && !strings.Contains(r.Header.Get("Accept"), thumb.ContentType(format)) {
+		format = "jpeg"
+	}
+	path, err := s.thumbnail(r.Context(), tc, f, width, format)
+	if errors.Is(err, thumb.ErrTooLarge) {
+		http.Error(w, "image too large to thumbnail", http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		s.logFor(r.Context()).Error("thumbnail %s: %v", f.ID, err)
+		http.Error(w, "thumbnail unavailable", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Vary", "Accept")
+	s.serveCached(w, r, f, path, thumb.ContentType(format))
+}
+
+// thumbSize rounds a requested width up to the next configured size, or down to the largest.
+func thumbSize(sizes []int, want int) int {
+	sorted := slices.Sorted(slices.Values(sizes))
+	for _, n := range sorted {
+		if n >= want {
+			return n
+		}
+	}
+	return sorted[len(sorted)-1]
+}
+
+// thumbnail returns the path of f's thumbnail at width in format, making it first when it
+// isn't cached yet.
+func (s *Server) thumbnail(ctx context.Context, tc config.Thumbnails, f *metadata.File, width int, format string) (string, error) {
+	ext := format
+	if ext == "jpeg" {
+		ext = "jpg"
+	}
+	name := fmt.Sprintf("%s-%d.%s", f.ID, width, ext)
+	return s.cached("thumbs", name, func(tmp string) error {
+		if f.Size > maxThumbSource {
+			return thumb.ErrTooLarge
+		}
+		rc, err := s.store.Get(ctx, f.BlobKey())
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(io.LimitReader(rc, maxThumbSource))
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		opt := thumb.Options{Width: width, Format: format, Quality: tc.Quality, Encoder: tc.Encoder, MaxPixels: tc.MaxPixels}
+		if err := thumb.Make(ctx, &buf, data, opt); err != nil {
+			return err
+		}
+		return os.WriteFile(tmp, buf.Bytes(), 0o600)
+	})
+}
+
+// makeThumbnails makes the configured sizes of a new upload, so the first look at it is
+// served from the cache.
+func (s *Server) makeThumbnails(f *metadata.File) {
+	tc := s.config().Thumbnails
+	if !tc.Enabled || !thumbable(f) || f.Quarantined() {
+		return
+	}
+	for _, width := range tc.Sizes {
+		if _, err := s.thumbnail(context.Background(), tc, f, width, tc.Format); err != nil {
+			if !errors.Is(err, thumb.ErrTooLarge) {
+				s.log.Error("thumbnail %s: %v", f.ID, err)
+			}
+			return
+		}
+	}
+}
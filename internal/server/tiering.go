@@ -0,0 +1,44 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/config"
+	"github.com/hey-granth/filegoblin/internal/storage"
+)
+
+// runTiering moves blobs that have gone cold to the cold tier every storage.cold.interval
+// until ctx is done. Storage settings only change with a restart, so unlike the lifecycle
+// sweep it reads them once. In a cluster only the leader moves blobs.
+func (s *Server) runTiering(ctx context.Context) {
+	tiered, ok := storage.Find[*storage.Tiered](s.store)
+	if !ok {
+		return
+	}
+	every := s.config().Storage.Cold.Interval
+	if every <= 0 {
+		every = time.Hour
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(every):
+		}
+		if !s.isLeader() {
+			continue
+		}
+		res, err := tiered.Migrate(ctx, time.Now(), func(key string, err error) {
+			if err != nil {
+				s.log.Error("tiering: move %s to the cold tier: %v", key, err)
+			}
+		})
+		if err != nil && ctx.Err() == nil {
+			s.log.Error("tiering: %v", err)
+		}
+		if res.Moved > 0 || res.Failed > 0 {
+			s.log.Info("tiering: moved %d blobs (%s) to the cold tier, %d failed", res.Moved, config.ByteSize(res.Bytes), res.Failed)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/metadata"
+)
+
+// liveFile returns the record of file id, as long as it isn't in the trash: there, only the
+// trash endpoints see it.
+func (s *Server) liveFile(id string) (*metadata.File, error) {
+	f, err := s.index.Get(id)
+	if err == nil && f.Trashed != nil {
+		return nil, metadata.ErrNotFound
+	}
+	return f, err
+}
+
+// trashedFile returns the record of file id if it's in owner's trash, or writes the error
+// response. The caller holds the file's lock.
+func (s *Server) trashedFile(w http.ResponseWriter, r *http.Request, owner, id string) (*metadata.File, bool) {
+	f, err := s.index.Get(id)
+	if err != nil || f.Trashed == nil || (owner != "" && f.Owner != owner) {
+		writeError(w, r, http.StatusNotFound, "file not in the trash")
+		return nil, false
+	}
+	return f, true
+}
+
+// removeFile deletes f for good: its record, then its blob unless other records share it,
+// and its cached previews. With the record gone the file is unreachable, so a blob that
+// can't be deleted is only left for gc.
+func (s *Server) removeFile(ctx context.Context, f *metadata.File) error {
+	if err := s.index.Delete(f.ID); err != nil && !errors.Is(err, metadata.ErrNotFound) {
+		return err
+	}
+	if err := s.dropBlob(ctx, f); err != nil {
+		s.logFor(ctx).ErrorE(err, "delete blob", "file_id", f.ID)
+	}
+	s.dropCache(f.ID)
+	return nil
+}
+
+// handleListTrash lists the caller's files in the trash, most recently deleted first.
+func (s *Server) handleListTrash(w http.ResponseWriter, r *http.Request) {
+	owner, ok := s.authorize(r)
+	if !ok {
+		writeError(w, r, http.StatusUnauthorized, "missing or invalid API key")
+		return
+	}
+	trashFor := s.config().Lifecycle.TrashFor
+	out := []trashedResponse{}
+	for _, f := range s.index.List() {
+		if f.Trashed == nil || (owner != "" && f.Owner != owner) {
+			continue
+		}
+		out = append(out, trashedResponse{fileResponse: s.fileResponse(r, f), PurgeAt: f.Trashed.Add(trashFor)})
+	}
+	slices.SortFunc(out, func(a, b trashedResponse) int { return b.Trashed.Compare(*a.Trashed) })
+	writeJSON(w, http.StatusOK, out)
+}
+
+// trashedResponse is a file in the trash, and when the lifecycle sweep will purge it.
+type trashedResponse struct {
+	fileResponse
+	PurgeAt time.Time `json:"purge_at"`
+}
+
+// handleRestore takes a file back out of the trash, as it was when it was deleted.
+func (s *Server) handleRestore(w http.ResponseWriter, r *http.Request) {
+	owner, ok := s.authorize(r)
+	if !ok {
+		writeError(w, r, http.StatusUnauthorized, "missing or invalid API key")
+		return
+	}
+	id := r.PathValue("id")
+	unlock, err := s.lockFile(id)
+	if err != nil {
+		s.writeLockError(w, r, err)
+		return
+	}
+	defer unlock()
+	f, ok := s.trashedFile(w, r, owner, id)
+	if !ok {
+		return
+	}
+	f.Trashed = nil
+	if err := s.index.Put(f); err != nil {
+		s.logFor(r.Context()).ErrorE(err, "restore", "file_id", id)
+		writeError(w, r, http.StatusInternalServerError, "could not restore file")
+		return
+	}
+	s.audit(r, "file_restored", id, "%s restored %s from the trash", ownerLabel(owner), id)
+	s.notifyFile("restore", f)
+	writeJSON(w, http.StatusOK, s.fileResponse(r, f))
+}
+
+// handlePurge deletes a file in the trash for good, without waiting for the sweep to.
+func (s *Server) handlePurge(w http.ResponseWriter, r *http.Request) {
+	owner, ok := s.authorize(r)
+	if !ok {
+		writeError(w, r, http.StatusUnauthorized, "missing or invalid API key")
+		return
+	}
+	id := r.PathValue("id")
+	unlock, err := s.lockFile(id)
+	if err != nil {
+		s.writeLockError(w, r, err)
+		return
+	}
+	defer unlock()
+	f, ok := s.trashedFile(w, r, owner, id)
+	if !ok {
+		return
+	}
+	if err := s.removeFile(r.Context(), f); err != nil {
+		s.logFor(r.Context()).ErrorE(err, "purge", "file_id", id)
+		writeError(w, r, http.StatusInternalServerError, "could not purge file")
+		return
+	}
+	s.audit(r, "file_purged", id, "%s purged %s from the trash", ownerLabel(owner), id)
+	s.notifyFile("purge", f)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/hey-granth/filegoblin/internal/metadata"
+)
+
+// verifyResponse reports a fresh read of a blob against the checksum recorded at upload.
+type verifyResponse struct {
+	ID       string `json:"id"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+	Bytes    int64  `json:"bytes"`
+	OK       bool   `json:"ok"`
+}
+
+// handleVerify rereads a file from storage and compares it with its stored SHA-256. It reads
+// the whole blob, so like delete it needs an API key and is limited to the caller's own files.
+func (s *Server) handleVerify(w http.ResponseWriter, r *http.Request) {
+	owner, ok := s.authorize(r)
+	if !ok {
+		writeError(w, r, http.StatusUnauthorized, "missing or invalid API key")
+		return
+	}
+	f, err := s.liveFile(r.PathValue("id"))
+	if err != nil || (owner != "" && f.Owner != owner) {
+		writeError(w, r, http.StatusNotFound, "file not found")
+		return
+	}
+	if f.SHA256 == "" {
+		writeError(w, r, http.StatusConflict, "no checksum was recorded for this file")
+		return
+	}
+	actual, n, err := s.hashBlob(r.Context(), f.BlobKey())
+	if err != nil {
+		s.logFor(r.Context()).Error("verify %s: %v", f.ID, err)
+		writeError(w, r, http.StatusInternalServerError, "could not read file")
+		return
+	}
+	res := verifyResponse{
+		ID:       f.ID,
+		Expected: f.SHA256,
+		Actual:   actual,
+		Bytes:    n,
+	}
+	res.OK = res.Actual == res.Expected && n == f.Size
+	if !res.OK {
+		s.logFor(r.Context()).Error("verify %s: checksum mismatch (stored %s, read %s, %d of %d bytes)", f.ID, res.Expected, res.Actual, n, f.Size)
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// hashBlob reads a blob in full and returns its SHA-256 and length.
+func (s *Server) hashBlob(ctx context.Context, id string) (string, int64, error) {
+	rc, err := s.store.Get(ctx, id)
+	if err != nil {
+		return "", 0, err
+	}
+	defer rc.Close()
+	sum := sha256.New()
+	n, err := io.Copy(sum, rc)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(sum.Sum(nil)), n, nil
+}
+
+// verifyBeforeServe checks a file against its checksum when integrity.verify_on_download
+// covers it, and reports whether it may be served. Files without a recorded checksum pass,
+// since there's nothing to compare with.
+func (s *Server) verifyBeforeServe(ctx context.Context, f *metadata.File) bool {
+	ic := s.config().Integrity
+	if !ic.VerifyOnDownload || f.SHA256 == "" {
+		return true
+	}
+	if len(ic.VerifyOwners) > 0 && !slices.Contains(ic.VerifyOwners, f.Owner) {
+		return true
+	}
+	actual, n, err := s.hashBlob(ctx, f.BlobKey())
+	if err != nil {
+		s.logFor(ctx).Error("verify %s before download: %v", f.ID, err)
+		return false
+	}
+	if actual != f.SHA256 || n != f.Size {
+		s.logFor(ctx).Error("verify %s before download: checksum mismatch (stored %s, read %s), refusing to serve", f.ID, f.SHA256, actual)
+		return false
+	}
+	return true
+}
+
+// setDigestHeaders advertises a download's checksums: always as X-Checksum-SHA256 (and
+// -MD5 and -CRC32C when recorded, and S3's X-Amz-Checksum-Crc32c), and as the standard
+// Digest (RFC 3230) and Repr-Digest (RFC 9530) headers unless the client's Want-Digest or
+// Want-Repr-Digest says it doesn't accept SHA-256. Repr-Digest also carries MD5 and CRC32C
+// when Want-Repr-Digest asks for them. They all describe the whole file, so they're the
+// same for range requests.
+func setDigestHeaders(w http.ResponseWriter, r *http.Request, f *metadata.File) {
+	h := w.Header()
+	var repr []string
+	for _, c := range checksums {
+		raw, err := hex.DecodeString(*c.field(f))
+		if err != nil || len(raw) == 0 {
+			continue
+		}
+		b64 := base64.StdEncoding.EncodeToString(raw)
+		h.Set(c.hex, *c.field(f))
+		if c.name == "crc32c" {
+			h.Set(c.base64, b64)
+		}
+		want := r.Header.Get("Want-Repr-Digest")
+		if c.name == "sha-256" {
+			if wantsSHA256(r.Header.Get("Want-Digest")) {
+				h.Set("Digest", "SHA-256="+b64)
+			}
+			if wantsSHA256(want) {
+				repr = append(repr, "sha-256=:"+b64+":")
+			}
+		} else if strings.TrimSpace(want) != "" && wantsDigest(want, c.name) {
+			repr = append(repr, c.name+"=:"+b64+":")
+		}
+	}
+	if len(repr) > 0 {
+		h.Set("Repr-Digest", strings.Join(repr, ", "))
+	}
+}
+
+// wantsSHA256 reads a Want-Digest style preference list like "sha-256;q=1, md5;q=0.3" (or
+// "sha-256=10" in RFC 9530's syntax). No header means no preference, which we take as yes.
+func wantsSHA256(want string) bool {
+	return strings.TrimSpace(want) == "" || wantsDigest(want, "sha-256")
+}
+
+// wantsDigest reports whether the preference list want accepts the algorithm alg.
+func wantsDigest(want, alg string) bool {
+	for _, item := range strings.Split(want, ",") {
+		name, weight, _ := strings.Cut(strings.TrimSpace(item), ";")
+		if a, w, ok := strings.Cut(name, "="); ok { // RFC 9530: "sha-256=5"
+			name, weight = a, "q="+w
+		}
+		if !strings.EqualFold(strings.TrimSpace(name), alg) {
+			continue
+		}
+		q := strings.TrimPrefix(strings.TrimSpace(weight), "q=")
+		if q == "" {
+			return true
+		}
+		v, err := strconv.ParseFloat(q, 64)
+		return err == nil && v > 0
+	}
+	return false
+}
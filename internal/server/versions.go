@@ -0,0 +1,218 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/metadata"
+)
+
+// maxPathLen caps the paths uploads may give files.
+const maxPathLen = 1024
+
+// uploadPath reads the path an upload asks to be stored at, cleaned like the ones ingest
+// records: relative, with forward slashes.
+func uploadPath(r *http.Request) (string, bool) {
+	v := r.URL.Query().Get("path")
+	if v == "" {
+		return "", true
+	}
+	p := path.Clean("/" + strings.ReplaceAll(v, `\`, "/"))[1:]
+	return p, p != "" && len(p) <= maxPathLen
+}
+
+// replaces finds the file an upload of f replaces, one of its owner's with the same path, and
+// takes its lock. It returns nil, and no lock to hold, if there's none.
+func (s *Server) replaces(f *metadata.File) (*metadata.File, func(), error) {
+	if f.Path == "" {
+		return nil, func() {}, nil
+	}
+	at := func(c *metadata.File) bool {
+		// a taken-down file keeps its content for review
+		return c.Owner == f.Owner && c.Path == f.Path && c.Trashed == nil && c.TakenDown == nil
+	}
+	files := s.index.List()
+	i := slices.IndexFunc(files, at)
+	if i < 0 {
+		return nil, func() {}, nil
+	}
+	id := files[i].ID
+	unlock, err := s.lockFile(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	// it may have been deleted or changed while we waited
+	cur, err := s.index.Get(id)
+	if err != nil || !at(cur) {
+		return nil, unlock, nil
+	}
+	return cur, unlock, nil
+}
+
+// addVersion makes v f's content, keeping the content it replaces as a version as long as
+// the versions settings allow, and returns the blob keys of the versions it pruned.
+func (s *Server) addVersion(f *metadata.File, v metadata.Version) []string {
+	f.Replace(v, v.ReplacedAt)
+	return f.Prune(s.config().Versions.Keep, s.versionCutoff(v.ReplacedAt))
+}
+
+// versionCutoff is when versions must have been replaced after to be kept, as of now.
+func (s *Server) versionCutoff(now time.Time) time.Time {
+	if maxAge := s.config().Versions.MaxAge; maxAge > 0 {
+		return now.Add(-maxAge)
+	}
+	return time.Time{}
+}
+
+// ownFile returns the live file id if the request's key owns it, or writes the error response.
+func (s *Server) ownFile(w http.ResponseWriter, r *http.Request, id string) (*metadata.File, bool) {
+	owner, ok := s.authorize(r)
+	if !ok {
+		writeError(w, r, http.StatusUnauthorized, "missing or invalid API key")
+		return nil, false
+	}
+	f, err := s.liveFile(id)
+	if err != nil || (owner != "" && f.Owner != owner) {
+		writeError(w, r, http.StatusNotFound, "file not found")
+		return nil, false
+	}
+	return f, true
+}
+
+// versionResponse is a version of a file as the API shows it.
+type versionResponse struct {
+	metadata.Version
+	Current bool   `json:"current,omitempty"`
+	URL     string `json:"url"` // downloads it; only the owner may
+}
+
+// handleVersions lists a file's versions, its current content first.
+func (s *Server) handleVersions(w http.ResponseWriter, r *http.Request) {
+	f, ok := s.ownFile(w, r, r.PathValue("id"))
+	if !ok {
+		return
+	}
+	cur := f.Current(time.Time{})
+	cur.ReplacedAt = time.Time{}
+	out := []versionResponse{{Version: cur, Current: true}}
+	for _, v := range f.Versions {
+		out = append(out, versionResponse{Version: v})
+	}
+	for i := range out {
+		out[i].URL = s.baseURL(r) + "/f/" + f.ID + "?version=" + out[i].ID
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// handleRestoreVersion makes an earlier version a file's content again. The content it
+// replaces becomes a version in turn, so nothing is lost.
+func (s *Server) handleRestoreVersion(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	unlock, err := s.lockFile(id)
+	if err != nil {
+		s.writeLockError(w, r, err)
+		return
+	}
+	defer unlock()
+	f, ok := s.ownFile(w, r, id)
+	if !ok {
+		return
+	}
+	if f.TakenDown != nil {
+		writeError(w, r, http.StatusConflict, "file was taken down and is kept for review")
+		return
+	}
+	v, ok := f.FindVersion(r.PathValue("version"))
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "version not found")
+		return
+	}
+	if v.ID != f.Current(time.Time{}).ID {
+		if err := s.restoreVersion(r.Context(), f, v); err != nil {
+			s.logFor(r.Context()).ErrorE(err, "restore version", "file_id", id, "version", v.ID)
+			writeError(w, r, http.StatusInternalServerError, "could not restore version")
+			return
+		}
+		s.dropCache(id)
+		owner, _ := s.authorize(r)
+		s.audit(r, "file_version_restored", id, "%s restored version %s of %s", ownerLabel(owner), v.ID, id)
+	}
+	writeJSON(w, http.StatusOK, s.fileResponse(r, f))
+}
+
+func (s *Server) restoreVersion(ctx context.Context, f *metadata.File, v metadata.Version) error {
+	s.blobMu.Lock()
+	defer s.blobMu.Unlock()
+	f.Versions = slices.DeleteFunc(slices.Clone(f.Versions), func(old metadata.Version) bool { return old.ID == v.ID })
+	v.ReplacedAt = time.Now().UTC()
+	pruned := s.addVersion(f, v)
+	if err := s.index.Put(f); err != nil {
+		return err
+	}
+	return s.dropBlobs(ctx, pruned)
+}
+
+// withVersion gives f the content of its version named in the request's ?version=, if
+// there's one, and reports false, having answered, if there's no such version or the
+// request may not see it. Only the owner may: the content was replaced, perhaps for a reason.
+func (s *Server) withVersion(w http.ResponseWriter, r *http.Request, f *metadata.File) bool {
+	id := r.URL.Query().Get("version")
+	if id == "" {
+		return true
+	}
+	owner, ok := s.authorize(r)
+	v, found := f.FindVersion(id)
+	if !ok || (owner != "" && owner != f.Owner) || !found {
+		http.NotFound(w, r)
+		return false
+	}
+	f.SetContent(v)
+	return true
+}
+
+// pruneVersions drops the versions older than versions.max_age, and returns how many went.
+func (s *Server) pruneVersions(ctx context.Context, now time.Time) int {
+	cutoff := s.versionCutoff(now)
+	if cutoff.IsZero() {
+		return 0
+	}
+	old := func(v metadata.Version) bool { return v.ReplacedAt.Before(cutoff) }
+	n := 0
+	for _, f := range s.index.List() {
+		if slices.ContainsFunc(f.Versions, old) {
+			n += s.pruneFile(ctx, f.ID, cutoff)
+		}
+	}
+	return n
+}
+
+func (s *Server) pruneFile(ctx context.Context, id string, cutoff time.Time) int {
+	unlock, err := s.lockFile(id)
+	if err != nil {
+		s.log.Error("lifecycle: lock %s: %v", id, err)
+		return 0
+	}
+	defer unlock()
+	s.blobMu.Lock()
+	defer s.blobMu.Unlock()
+	f, err := s.index.Get(id)
+	if err != nil {
+		return 0
+	}
+	pruned := f.Prune(s.config().Versions.Keep, cutoff)
+	if len(pruned) == 0 {
+		return 0
+	}
+	if err := s.index.Put(f); err != nil {
+		s.log.Error("lifecycle: prune versions of %s: %v", id, err)
+		return 0
+	}
+	if err := s.dropBlobs(ctx, pruned); err != nil {
+		s.log.Error("lifecycle: delete pruned versions of %s: %v", id, err)
+	}
+	return len(pruned)
+}
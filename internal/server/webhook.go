@@ -0,0 +1,105 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+
+	"github.com/hey-granth/filegoblin/internal/metadata"
+	"github.com/hey-granth/filegoblin/internal/webhook"
+)
+
+// hookFile is what webhooks are told about a file: its record, less the password hash,
+// which the shadowing field keeps out of the JSON as in fileResponse.
+type hookFile struct {
+	*metadata.File
+	PasswordHash string `json:"password_hash,omitempty"`
+}
+
+// quotaEvent is the data of a "quota" event: an upload was refused for being over quota.
+type quotaEvent struct {
+	Owner string `json:"owner"`
+	Quota int64  `json:"quota"`
+	Used  int64  `json:"used"`
+}
+
+// notify sends an event to every webhook subscribed to typ, and publishes it to the message
+// bus. Delivery happens in the background; notify never holds up the request that caused
+// the event.
+func (s *Server) notify(typ string, data any) {
+	wc, bc := s.config().Webhooks, s.config().Bus
+	toBus := s.bus != nil && (len(bc.Events) == 0 || slices.Contains(bc.Events, typ))
+	if len(wc.Hooks) == 0 && !toBus {
+		return
+	}
+	ev := webhook.NewEvent(typ, data)
+	if toBus {
+		if body, err := json.Marshal(ev); err != nil {
+			s.log.Error("bus: encode %s event: %v", typ, err)
+		} else if !s.bus.Publish(bc.Subject+"."+typ, body) {
+			s.log.Error("bus: too many events waiting, dropped %s event %s", typ, ev.ID)
+		}
+	}
+	policy := webhook.Policy{Attempts: wc.MaxAttempts, Backoff: wc.Backoff, Timeout: wc.Timeout}
+	for _, h := range wc.Hooks {
+		if len(h.Events) > 0 && !slices.Contains(h.Events, typ) {
+			continue
+		}
+		if !s.hooks.Send(webhook.Target{URL: h.URL, Secret: h.Secret}, policy, ev) {
+			s.log.Error("webhook %s: too many deliveries pending, dropped %s event %s", h.URL, typ, ev.ID)
+		}
+	}
+}
+
+// notifyFile sends a file event. The record is copied, since it is encoded in the
+// background while the request may go on to change it.
+func (s *Server) notifyFile(typ string, f *metadata.File) {
+	cp := *f
+	s.notify(typ, map[string]hookFile{"file": {File: &cp}})
+}
+
+// notifyQuota reports an upload refused because owner is out of quota.
+func (s *Server) notifyQuota(owner string) {
+	u, err := s.users.User(owner)
+	if err != nil {
+		return
+	}
+	s.notify("quota", quotaEvent{Owner: owner, Quota: u.Quota, Used: s.usage(owner)})
+}
+
+// webhookGaveUp logs an event that couldn't be delivered.
+func (s *Server) webhookGaveUp(d webhook.Delivery) {
+	s.log.Error("webhook %s: gave up on %s event %s after %d attempts: %s", d.URL, d.Type, d.Event, d.Attempt, d.Error)
+}
+
+func (s *Server) handleWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.hooks.Recent())
+}
+
+// handleWebhookTest sends a "test" event to every configured webhook, or only the one whose
+// URL is given, whatever events they subscribe to. Each gets a single attempt, and the
+// answer reports how they went.
+func (s *Server) handleWebhookTest(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		URL string `json:"url"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+	}
+	wc := s.config().Webhooks
+	ev := webhook.NewEvent("test", map[string]string{"message": "test event from filegoblin"})
+	out := []webhook.Delivery{}
+	for _, h := range wc.Hooks {
+		if req.URL == "" || h.URL == req.URL {
+			out = append(out, s.hooks.Deliver(webhook.Target{URL: h.URL, Secret: h.Secret}, wc.Timeout, ev))
+		}
+	}
+	if len(out) == 0 {
+		writeError(w, r, http.StatusNotFound, "no such webhook configured")
+		return
+	}
+	writeJSON(w, http.StatusOK, out)
+}
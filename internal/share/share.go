@@ -0,0 +1,171 @@
+package share
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/hey-granth/filegoblin/internal/logx"
+)
+
+// cookieName remembers the token after the first visit so links inside the listing don't need it.
+const cookieName = "filegoblin_share"
+
+// Share serves one directory read-only to anyone holding the token. It backs `serve --once`,
+// where the whole point is "hand someone on the LAN a link and forget about it".
+type Share struct {
+	root  *os.Root // os.Root refuses to follow anything (.. or symlinks) out of the shared dir
+	token string
+	log   *logx.Logger
+}
+
+// New opens dir for sharing, protected by token.
+func New(dir, token string, log *logx.Logger) (*Share, error) {
+	if token == "" {
+		return nil, errors.New("share: empty token")
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Share{root: root, token: token, log: log}, nil
+}
+
+// NewToken returns a random token suitable for a share link.
+func NewToken() string {
+	b := make([]byte, 18)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Close releases the directory handle.
+func (s *Share) Close() error { return s.root.Close() }
+
+func (s *Share) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.checkToken(w, r) {
+		http.Error(w, "invalid or missing share token", http.StatusForbidden)
+		return
+	}
+
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		name = "."
+	}
+	if hidden(name) {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := s.root.Open(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if fi.IsDir() {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+			return
+		}
+		s.serveListing(w, r, f)
+		return
+	}
+	s.log.Info("share: sending %s to %s", name, r.RemoteAddr)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fi.Name()}))
+	http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+}
+
+// checkToken accepts the token from ?t= (and then sets the cookie) or from the cookie.
+func (s *Share) checkToken(w http.ResponseWriter, r *http.Request) bool {
+	if t := r.URL.Query().Get("t"); t != "" {
+		if !s.tokenOK(t) {
+			return false
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     cookieName,
+			Value:    t,
+			Path:     "/",
+			HttpOnly: true,
+			SameSite: http.SameSiteStrictMode,
+		})
+		return true
+	}
+	c, err := r.Cookie(cookieName)
+	return err == nil && s.tokenOK(c.Value)
+}
+
+func (s *Share) tokenOK(t string) bool {
+	return subtle.ConstantTimeCompare([]byte(t), []byte(s.token)) == 1
+}
+
+// hidden reports whether any element of name is a dotfile, which are left out of listings
+// and can't be fetched directly either.
+func hidden(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if part != "." && strings.HasPrefix(part, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+type listingEntry struct {
+	Name  string
+	Href  string
+	IsDir bool
+	Size  int64
+}
+
+var listingTmpl = template.Must(template.New("listing").Parse(`<!doctype html>
+<html><head><meta charset="utf-8"><title>filegoblin share</title>
+<style>body{font-family:sans-serif;max-width:50em;margin:2em auto}td{padding:.2em 1em}</style></head>
+<body><h1>{{.Path}}</h1><table>
+{{if ne .Path "/"}}<tr><td><a href="../">../</a></td><td></td></tr>{{end}}
+{{range .Entries}}<tr><td><a href="{{.Href}}{{if .IsDir}}/{{end}}">{{.Name}}{{if .IsDir}}/{{end}}</a></td><td>{{if not .IsDir}}{{.Size}} bytes{{end}}</td></tr>
+{{end}}</table></body></html>
+`))
+
+func (s *Share) serveListing(w http.ResponseWriter, r *http.Request, dir fs.ReadDirFile) {
+	entries, err := dir.ReadDir(-1)
+	if err != nil {
+		http.Error(w, "cannot read directory", http.StatusInternalServerError)
+		return
+	}
+	list := make([]listingEntry, 0, len(entries))
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") {
+			continue // dotfiles are usually not meant to be shared
+		}
+		le := listingEntry{Name: e.Name(), Href: url.PathEscape(e.Name()), IsDir: e.IsDir()}
+		if fi, err := e.Info(); err == nil {
+			le.Size = fi.Size()
+		}
+		list = append(list, le)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].IsDir != list[j].IsDir {
+			return list[i].IsDir
+		}
+		return list[i].Name < list[j].Name
+	})
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_ = listingTmpl.Execute(w, map[string]interface{}{"Path": path.Clean("/" + r.URL.Path), "Entries": list})
+}
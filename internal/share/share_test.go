@@ -0,0 +1,61 @@
+package share
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hey-granth/filegoblin/internal/logx"
+)
+
+// TestShareRequiresToken makes sure nothing is served without the token and that the
+// cookie set on the first visit is enough afterwards.
+func TestShareRequiresToken(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hi"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".secret"), []byte("no"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sh, err := New(dir, "tok", logx.New(io.Discard))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sh.Close()
+
+	rec := httptest.NewRecorder()
+	sh.ServeHTTP(rec, httptest.NewRequest("GET", "/a.txt", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("no token: got %d, want 403", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	sh.ServeHTTP(rec, httptest.NewRequest("GET", "/?t=tok", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("listing: got %d", rec.Code)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("expected the share cookie, got %v", cookies)
+	}
+
+	req := httptest.NewRequest("GET", "/a.txt", nil)
+	req.AddCookie(cookies[0])
+	rec = httptest.NewRecorder()
+	sh.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Body.String() != "hi" {
+		t.Fatalf("download with cookie: got %d %q", rec.Code, rec.Body)
+	}
+
+	req = httptest.NewRequest("GET", "/.secret", nil)
+	req.AddCookie(cookies[0])
+	rec = httptest.NewRecorder()
+	sh.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("dotfile: got %d, want 404", rec.Code)
+	}
+}
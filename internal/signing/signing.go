@@ -0,0 +1,231 @@
+// Package signing issues and checks signed, expiring download links. Links name the key that
+// signed them, so the keyring can rotate: new links are signed with the newest key while
+// retired keys keep verifying for a grace period, and outstanding links die off gradually
+// instead of all at once.
+package signing
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnsigned     = errors.New("signing: link is not signed")
+	ErrExpired      = errors.New("signing: link has expired")
+	ErrBadSignature = errors.New("signing: invalid signature")
+	ErrUnknownKey   = errors.New("signing: link was signed with an unknown or retired key")
+)
+
+// Key is one HMAC secret in the keyring.
+type Key struct {
+	ID        string     `json:"id"`
+	Secret    []byte     `json:"secret,omitempty"` // cleared in everything the keyring hands out
+	CreatedAt time.Time  `json:"created_at"`
+	RetiredAt *time.Time `json:"retired_at,omitempty"` // when a newer key took over signing
+}
+
+// Keyring holds the signing keys, newest last, and persists them to a JSON file.
+type Keyring struct {
+	path string
+	mu   sync.RWMutex
+	keys []*Key
+}
+
+type keyringFile struct {
+	Keys []*Key `json:"keys"`
+}
+
+// Open loads the keyring at path, creating it with a first key if it doesn't exist yet.
+func Open(path string) (*Keyring, error) {
+	k := &Keyring{path: path}
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if len(k.keys) == 0 {
+		k.keys = []*Key{newKey(time.Now())}
+		if err := k.save(); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// Reload re-reads the file, picking up rotations done by another process.
+func (k *Keyring) Reload() error {
+	var kf keyringFile
+	data, err := os.ReadFile(k.path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return err
+	default:
+		if err := json.Unmarshal(data, &kf); err != nil {
+			return fmt.Errorf("parse %s: %w", k.path, err)
+		}
+	}
+	k.mu.Lock()
+	if len(kf.Keys) > 0 || k.keys == nil { // a vanished file mustn't wipe the keys we're using
+		k.keys = kf.Keys
+	}
+	k.mu.Unlock()
+	return nil
+}
+
+// save writes the keyring atomically. Callers hold k.mu.
+func (k *Keyring) save() error {
+	data, err := json.MarshalIndent(keyringFile{Keys: k.keys}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := k.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil { // raw secrets, keep it private
+		return err
+	}
+	return os.Rename(tmp, k.path)
+}
+
+func newKey(now time.Time) *Key {
+	id := make([]byte, 4)
+	secret := make([]byte, 32)
+	_, _ = rand.Read(id)
+	_, _ = rand.Read(secret)
+	return &Key{ID: hex.EncodeToString(id), Secret: secret, CreatedAt: now.UTC()}
+}
+
+// Keys lists the keys, oldest first, without their secrets.
+func (k *Keyring) Keys() []Key {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	out := make([]Key, 0, len(k.keys))
+	for _, key := range k.keys {
+		cp := *key
+		cp.Secret = nil
+		out = append(out, cp)
+	}
+	return out
+}
+
+// Rotate retires the current key and starts signing with a fresh one.
+func (k *Keyring) Rotate(now time.Time) (Key, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.rotate(now)
+}
+
+func (k *Keyring) rotate(now time.Time) (Key, error) {
+	prev := k.keys
+	next := newKey(now)
+	retired := now.UTC()
+	keys := make([]*Key, 0, len(prev)+1)
+	for _, key := range prev {
+		cp := *key
+		if cp.RetiredAt == nil {
+			cp.RetiredAt = &retired
+		}
+		keys = append(keys, &cp)
+	}
+	k.keys = append(keys, next)
+	if err := k.save(); err != nil {
+		k.keys = prev
+		return Key{}, err
+	}
+	out := *next
+	out.Secret = nil
+	return out, nil
+}
+
+// Maintain does the scheduled upkeep: it rotates when the current key is older than every
+// (0 disables scheduled rotation) and forgets retired keys whose grace period is over. It
+// reports whether anything changed.
+func (k *Keyring) Maintain(now time.Time, every, grace time.Duration) (rotated bool, dropped int, err error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if cur := k.keys[len(k.keys)-1]; every > 0 && now.Sub(cur.CreatedAt) >= every {
+		if _, err := k.rotate(now); err != nil {
+			return false, 0, err
+		}
+		rotated = true
+	}
+	keep := k.keys[:0:0]
+	for _, key := range k.keys {
+		if key.RetiredAt != nil && now.Sub(*key.RetiredAt) >= grace {
+			dropped++
+			continue
+		}
+		keep = append(keep, key)
+	}
+	if dropped > 0 {
+		prev := k.keys
+		k.keys = keep
+		if err := k.save(); err != nil {
+			k.keys = prev
+			return rotated, 0, err
+		}
+	}
+	return rotated, dropped, nil
+}
+
+// mac computes the signature over everything a link's validity depends on.
+func mac(secret []byte, kid, id string, exp int64) string {
+	h := hmac.New(sha256.New, secret)
+	fmt.Fprintf(h, "filegoblin-link\n%s\n%s\n%d", kid, id, exp)
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// Sign returns the query parameters (exp, kid, sig) that make a link to file id valid until exp.
+func (k *Keyring) Sign(id string, exp time.Time) url.Values {
+	k.mu.RLock()
+	key := k.keys[len(k.keys)-1]
+	k.mu.RUnlock()
+	e := exp.Unix()
+	return url.Values{
+		"exp": {strconv.FormatInt(e, 10)},
+		"kid": {key.ID},
+		"sig": {mac(key.Secret, key.ID, id, e)},
+	}
+}
+
+// Verify checks a link's query parameters for file id. Keys retired more than grace ago no
+// longer verify, even if Maintain hasn't dropped them yet.
+func (k *Keyring) Verify(id string, q url.Values, now time.Time, grace time.Duration) error {
+	kid, sig, expStr := q.Get("kid"), q.Get("sig"), q.Get("exp")
+	if kid == "" || sig == "" || expStr == "" {
+		return ErrUnsigned
+	}
+	exp, err := strconv.ParseInt(expStr, 10, 64)
+	if err != nil {
+		return ErrBadSignature
+	}
+	k.mu.RLock()
+	var key *Key
+	for _, c := range k.keys {
+		if c.ID == kid {
+			key = c
+		}
+	}
+	k.mu.RUnlock()
+	if key == nil || (key.RetiredAt != nil && now.Sub(*key.RetiredAt) >= grace) {
+		return ErrUnknownKey
+	}
+	if !hmac.Equal([]byte(sig), []byte(mac(key.Secret, kid, id, exp))) {
+		return ErrBadSignature
+	}
+	if now.Unix() >= exp {
+		return ErrExpired
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package signing
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestRotationGrace walks a link through rotation: still valid within the grace period,
+// refused after it, and dropped from the keyring by Maintain.
+func TestRotationGrace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "signing.json")
+	k, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	grace := time.Hour
+	q := k.Sign("abc", now.Add(24*time.Hour))
+
+	if err := k.Verify("abc", q, now, grace); err != nil {
+		t.Fatalf("fresh link: %v", err)
+	}
+	if err := k.Verify("abd", q, now, grace); err != ErrBadSignature {
+		t.Fatalf("link for another file: got %v", err)
+	}
+	if err := k.Verify("abc", q, now.Add(25*time.Hour), 48*time.Hour); err != ErrExpired {
+		t.Fatalf("expired link: got %v", err)
+	}
+
+	rotated, _, err := k.Maintain(now.Add(2*time.Hour), time.Hour, grace)
+	if err != nil || !rotated {
+		t.Fatalf("scheduled rotation: %v, %v", rotated, err)
+	}
+	if q2 := k.Sign("abc", now.Add(24*time.Hour)); q2.Get("kid") == q.Get("kid") {
+		t.Fatal("still signing with the retired key")
+	}
+	if err := k.Verify("abc", q, now.Add(2*time.Hour+30*time.Minute), grace); err != nil {
+		t.Fatalf("old link within grace: %v", err)
+	}
+	if err := k.Verify("abc", q, now.Add(3*time.Hour+time.Minute), grace); err != ErrUnknownKey {
+		t.Fatalf("old link after grace: got %v", err)
+	}
+
+	// another process (the server) sees the rotation after a reload
+	other, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(other.Keys()) != 2 {
+		t.Fatalf("reloaded keyring has %d keys, want 2", len(other.Keys()))
+	}
+	if _, dropped, _ := k.Maintain(now.Add(3*time.Hour+time.Minute), 0, grace); dropped != 1 {
+		t.Fatalf("dropped %d retired keys, want 1", dropped)
+	}
+	for _, key := range k.Keys() {
+		if key.Secret != nil {
+			t.Fatal("Keys leaked a secret")
+		}
+	}
+}
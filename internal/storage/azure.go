@@ -0,0 +1,345 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// azureVersion is the version of the Blob service REST API requests are made in.
+const azureVersion = "2021-08-06"
+
+// AzureOptions says where an Azure backend keeps its blobs and how it gets at them.
+type AzureOptions struct {
+	Account   string
+	Key       string // the storage account's access key, base64 as the portal shows it
+	Container string
+	Prefix    string // put in front of every blob name, like "blobs/", to share a container
+
+	// Endpoint is the Blob service's URL; empty is https://<account>.blob.core.windows.net.
+	// Azurite, the emulator, is at "http://127.0.0.1:10000/devstoreaccount1".
+	Endpoint string
+
+	// Tier is the access tier new blobs are put in: "Hot", "Cool", "Cold" or "Archive"; empty
+	// leaves it to the account's default. Archived blobs can't be read until rehydrated.
+	Tier string
+
+	// BlockSize is how much of a blob is sent per request. Blobs larger than it are sent as
+	// blocks and committed together, so memory use stays at one block. 0 is 8 MiB.
+	BlockSize int64
+}
+
+// Azure keeps blobs as block blobs in an Azure Storage container, so an Azure-hosted server
+// needs no data disk for them. Requests are signed with the account's shared key, which
+// also signs the SAS URLs of SignedURL.
+type Azure struct {
+	opt    AzureOptions
+	key    []byte
+	base   *url.URL
+	client *http.Client
+	now    func() time.Time
+}
+
+// NewAzure returns a backend storing in the container opt names. It doesn't check that the
+// container is there; the first request finds out.
+func NewAzure(opt AzureOptions) (*Azure, error) {
+	if opt.Account == "" || opt.Container == "" {
+		return nil, errors.New("azure: account and container are required")
+	}
+	key, err := base64.StdEncoding.DecodeString(opt.Key)
+	if err != nil || len(key) == 0 {
+		return nil, errors.New("azure: the account key must be base64, as the portal shows it")
+	}
+	if opt.Endpoint == "" {
+		opt.Endpoint = "https://" + opt.Account + ".blob.core.windows.net"
+	}
+	base, err := url.Parse(strings.TrimRight(opt.Endpoint, "/"))
+	if err != nil || base.Host == "" || (base.Scheme != "http" && base.Scheme != "https") {
+		return nil, fmt.Errorf("azure: endpoint %q is not an http or https URL", opt.Endpoint)
+	}
+	if opt.BlockSize == 0 {
+		opt.BlockSize = 8 << 20
+	}
+	return &Azure{opt: opt, key: key, base: base, client: &http.Client{}, now: time.Now}, nil
+}
+
+// blobURL is the URL of the blob for key, or of the container for "".
+func (a *Azure) blobURL(key string, query url.Values) *url.URL {
+	u := *a.base
+	u.Path += "/" + a.opt.Container
+	if key != "" {
+		u.Path += "/" + a.opt.Prefix + key
+	}
+	u.RawQuery = query.Encode()
+	return &u
+}
+
+func (a *Azure) checkKey(key string) error {
+	if key == "" || strings.ContainsAny(key, `/\`) || key == "." || key == ".." {
+		return fmt.Errorf("storage: invalid key %q", key)
+	}
+	return nil
+}
+
+// do sends a request with body signed, and returns the response if its status is one of ok.
+func (a *Azure) do(ctx context.Context, method string, u *url.URL, header http.Header, body []byte, ok ...int) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	a.sign(req)
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("azure: %s %s: %w", method, u.Path, err)
+	}
+	for _, code := range ok {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	var e struct {
+		Code    string
+		Message string
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if xml.Unmarshal(data, &e) == nil && e.Code != "" {
+		return nil, fmt.Errorf("azure: %s %s: %s: %s", method, u.Path, e.Code, strings.SplitN(e.Message, "\n", 2)[0])
+	}
+	return nil, fmt.Errorf("azure: %s %s: %s", method, u.Path, resp.Status)
+}
+
+// sign adds the date, version and a Shared Key authorization to req.
+func (a *Azure) sign(req *http.Request) {
+	req.Header.Set("X-Ms-Date", a.now().UTC().Format(http.TimeFormat))
+	req.Header.Set("X-Ms-Version", azureVersion)
+	length := ""
+	if req.ContentLength > 0 {
+		length = strconv.FormatInt(req.ContentLength, 10)
+	}
+	h := req.Header.Get
+	lines := []string{req.Method, h("Content-Encoding"), h("Content-Language"), length, h("Content-MD5"),
+		h("Content-Type"), "", h("If-Modified-Since"), h("If-Match"), h("If-None-Match"), h("If-Unmodified-Since"), h("Range")}
+
+	var msHeaders []string
+	for k, v := range req.Header {
+		if name := strings.ToLower(k); strings.HasPrefix(name, "x-ms-") {
+			msHeaders = append(msHeaders, name+":"+strings.TrimSpace(strings.Join(v, ",")))
+		}
+	}
+	sort.Strings(msHeaders)
+	resource := "/" + a.opt.Account + req.URL.EscapedPath()
+	q := req.URL.Query()
+	names := make([]string, 0, len(q))
+	for k := range q {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		vs := append([]string(nil), q[k]...)
+		sort.Strings(vs)
+		resource += "\n" + strings.ToLower(k) + ":" + strings.Join(vs, ",")
+	}
+	toSign := strings.Join(lines, "\n") + "\n" + strings.Join(msHeaders, "\n") + "\n" + resource
+	req.Header.Set("Authorization", "SharedKey "+a.opt.Account+":"+a.hmac(toSign))
+}
+
+func (a *Azure) hmac(s string) string {
+	m := hmac.New(sha256.New, a.key)
+	m.Write([]byte(s))
+	return base64.StdEncoding.EncodeToString(m.Sum(nil))
+}
+
+// newBlobHeader has the headers of a blob being created.
+func (a *Azure) newBlobHeader() http.Header {
+	h := http.Header{}
+	if a.opt.Tier != "" {
+		h.Set("X-Ms-Access-Tier", a.opt.Tier)
+	}
+	return h
+}
+
+// Put sends a blob of up to BlockSize in one request, and a larger one as blocks committed
+// together at the end, which is when it becomes visible. Blocks never committed are removed
+// by the service after a week.
+func (a *Azure) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	if err := a.checkKey(key); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, a.opt.BlockSize)
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		h := a.newBlobHeader()
+		h.Set("X-Ms-Blob-Type", "BlockBlob")
+		resp, err := a.do(ctx, http.MethodPut, a.blobURL(key, nil), h, buf[:n], http.StatusCreated)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return int64(n), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var ids []string
+	var total int64
+	for n > 0 {
+		if len(ids) == 50000 {
+			return total, fmt.Errorf("azure: %s needs more than 50000 blocks; raise the block size", key)
+		}
+		// IDs must all be the same length before encoding
+		id := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("block-%06d", len(ids))))
+		resp, err := a.do(ctx, http.MethodPut, a.blobURL(key, url.Values{"comp": {"block"}, "blockid": {id}}), nil, buf[:n], http.StatusCreated)
+		if err != nil {
+			return total, err
+		}
+		resp.Body.Close()
+		ids, total = append(ids, id), total+int64(n)
+		if n, err = io.ReadFull(r, buf); err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return total, err
+		}
+	}
+	body, _ := xml.Marshal(struct {
+		XMLName xml.Name `xml:"BlockList"`
+		Latest  []string `xml:"Latest"`
+	}{Latest: ids})
+	resp, err := a.do(ctx, http.MethodPut, a.blobURL(key, url.Values{"comp": {"blocklist"}}), a.newBlobHeader(), body, http.StatusCreated)
+	if err != nil {
+		return total, err
+	}
+	resp.Body.Close()
+	return total, nil
+}
+
+// Get returns an io.ReadSeekCloser that fetches the blob from where it is read, so a range
+// request only downloads the range.
+func (a *Azure) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	info, err := a.Stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return &rangeReader{size: info.Size, open: func(off int64) (io.ReadCloser, error) {
+		h := http.Header{"Range": {"bytes=" + strconv.FormatInt(off, 10) + "-"}}
+		resp, err := a.do(ctx, http.MethodGet, a.blobURL(key, nil), h, nil, http.StatusPartialContent, http.StatusOK)
+		if err != nil {
+			return nil, err
+		}
+		return resp.Body, nil
+	}}, nil
+}
+
+func (a *Azure) Stat(ctx context.Context, key string) (Info, error) {
+	if err := a.checkKey(key); err != nil {
+		return Info{}, err
+	}
+	resp, err := a.do(ctx, http.MethodHead, a.blobURL(key, nil), nil, nil, http.StatusOK)
+	if err != nil {
+		return Info{}, err
+	}
+	resp.Body.Close()
+	mod, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return Info{Key: key, Size: resp.ContentLength, ModTime: mod}, nil
+}
+
+func (a *Azure) Delete(ctx context.Context, key string) error {
+	if err := a.checkKey(key); err != nil {
+		return err
+	}
+	resp, err := a.do(ctx, http.MethodDelete, a.blobURL(key, nil), nil, nil, http.StatusAccepted)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (a *Azure) List(ctx context.Context, fn func(Info) error) error {
+	q := url.Values{"restype": {"container"}, "comp": {"list"}}
+	if a.opt.Prefix != "" {
+		q.Set("prefix", a.opt.Prefix)
+	}
+	for {
+		resp, err := a.do(ctx, http.MethodGet, a.blobURL("", q), nil, nil, http.StatusOK)
+		if err != nil {
+			return err
+		}
+		var page struct {
+			Blobs []struct {
+				Name       string
+				Properties struct {
+					Size         int64  `xml:"Content-Length"`
+					LastModified string `xml:"Last-Modified"`
+				}
+			} `xml:"Blobs>Blob"`
+			NextMarker string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("azure: list: %w", err)
+		}
+		for _, b := range page.Blobs {
+			key := strings.TrimPrefix(b.Name, a.opt.Prefix)
+			if a.checkKey(key) != nil {
+				continue // something else keeps blobs under the prefix too
+			}
+			mod, _ := http.ParseTime(b.Properties.LastModified)
+			if err := fn(Info{Key: key, Size: b.Properties.Size, ModTime: mod}); err != nil {
+				return err
+			}
+		}
+		if page.NextMarker == "" {
+			return nil
+		}
+		q.Set("marker", page.NextMarker)
+	}
+}
+
+// SignedURL returns a SAS URL anyone can download the blob from until ttl is up, with the
+// Content-Disposition and Content-Type the service answers with set to disposition and
+// contentType.
+func (a *Azure) SignedURL(key string, ttl time.Duration, disposition, contentType string) (string, error) {
+	if err := a.checkKey(key); err != nil {
+		return "", err
+	}
+	u := a.blobURL(key, nil)
+	now := a.now().UTC()
+	start := now.Add(-5 * time.Minute).Format(time.RFC3339) // clocks differ
+	expiry := now.Add(ttl).Format(time.RFC3339)
+	resource := "/blob/" + a.opt.Account + "/" + a.opt.Container + "/" + a.opt.Prefix + key
+	protocol := "https,http"
+	if u.Scheme == "https" {
+		protocol = "https"
+	}
+	// permissions, start, expiry, resource, identifier, IP, protocol, version, resource
+	// type, snapshot time, encryption scope, then the response headers cache-control,
+	// disposition, encoding, language and type
+	toSign := strings.Join([]string{"r", start, expiry, resource, "", "", protocol, azureVersion, "b", "", "",
+		"", disposition, "", "", contentType}, "\n")
+	q := url.Values{
+		"sp": {"r"}, "st": {start}, "se": {expiry}, "spr": {protocol}, "sv": {azureVersion}, "sr": {"b"},
+		"rscd": {disposition}, "rsct": {contentType}, "sig": {a.hmac(toSign)},
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
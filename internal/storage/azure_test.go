@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var azureKey = base64.StdEncoding.EncodeToString([]byte("account key"))
+
+// fakeAzure is enough of the Blob service's API, Azurite-style, for the backend's tests.
+type fakeAzure struct {
+	mu     sync.Mutex
+	blobs  map[string][]byte
+	blocks map[string][]byte
+	tiers  []string // the tier asked for with each new blob
+}
+
+func (f *fakeAzure) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "SharedKey acct:") || r.Header.Get("X-Ms-Version") == "" {
+		http.Error(w, "unsigned", http.StatusForbidden)
+		return
+	}
+	name, _ := strings.CutPrefix(r.URL.Path, "/acct/box/")
+	q := r.URL.Query()
+	body, _ := io.ReadAll(r.Body)
+	switch {
+	case r.Method == http.MethodPut && q.Get("comp") == "block":
+		f.blocks[q.Get("blockid")] = body
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && q.Get("comp") == "blocklist":
+		var list struct {
+			Latest []string
+		}
+		xml.Unmarshal(body, &list)
+		var all []byte
+		for _, id := range list.Latest {
+			all = append(all, f.blocks[id]...)
+		}
+		f.blobs[name] = all
+		f.tiers = append(f.tiers, r.Header.Get("X-Ms-Access-Tier"))
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut:
+		f.blobs[name] = body
+		f.tiers = append(f.tiers, r.Header.Get("X-Ms-Access-Tier"))
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodGet && q.Get("comp") == "list":
+		var names []string
+		for n := range f.blobs {
+			if strings.HasPrefix(n, q.Get("prefix")) {
+				names = append(names, n)
+			}
+		}
+		sort.Strings(names)
+		start, _ := strconv.Atoi(q.Get("marker"))
+		end := min(start+2, len(names)) // small pages, to page through
+		fmt.Fprint(w, "<EnumerationResults><Blobs>")
+		for _, n := range names[start:end] {
+			fmt.Fprintf(w, "<Blob><Name>%s</Name><Properties><Last-Modified>Wed, 01 May 2024 12:00:00 GMT</Last-Modified><Content-Length>%d</Content-Length></Properties></Blob>", n, len(f.blobs[n]))
+		}
+		fmt.Fprint(w, "</Blobs><NextMarker>")
+		if end < len(names) {
+			fmt.Fprint(w, end)
+		}
+		fmt.Fprint(w, "</NextMarker></EnumerationResults>")
+	default:
+		data, ok := f.blobs[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "<Error><Code>BlobNotFound</Code><Message>The specified blob does not exist.</Message></Error>")
+			return
+		}
+		if r.Method == http.MethodDelete {
+			delete(f.blobs, name)
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		w.Header().Set("Last-Modified", "Wed, 01 May 2024 12:00:00 GMT")
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}
+}
+
+func TestAzure(t *testing.T) {
+	fake := &fakeAzure{blobs: map[string][]byte{}, blocks: map[string][]byte{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	a, err := NewAzure(AzureOptions{Account: "acct", Key: azureKey, Container: "box", Prefix: "blobs/",
+		Endpoint: srv.URL + "/acct", Tier: "Cool", BlockSize: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	big := bytes.Repeat([]byte("0123456789"), 250) // three blocks
+	if n, err := a.Put(ctx, "big", bytes.NewReader(big)); err != nil || n != int64(len(big)) {
+		t.Fatalf("Put big = %d, %v", n, err)
+	}
+	if n, err := a.Put(ctx, "small", strings.NewReader("hello")); err != nil || n != 5 {
+		t.Fatalf("Put small = %d, %v", n, err)
+	}
+	a.Put(ctx, "third", strings.NewReader("3"))
+	if !bytes.Equal(fake.blobs["blobs/big"], big) || len(fake.blocks) != 3 {
+		t.Fatalf("stored %d bytes from %d blocks", len(fake.blobs["blobs/big"]), len(fake.blocks))
+	}
+	if strings.Join(fake.tiers, ",") != "Cool,Cool,Cool" {
+		t.Fatalf("tiers asked for: %q", fake.tiers)
+	}
+
+	rc, err := a.Get(ctx, "big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := rc.(io.ReadSeeker)
+	rs.Seek(int64(len(big))-5, io.SeekStart)
+	if tail, _ := io.ReadAll(rs); string(tail) != "56789" {
+		t.Fatalf("read %q from the end", tail)
+	}
+	rc.Close()
+	if info, err := a.Stat(ctx, "small"); err != nil || info.Size != 5 || info.ModTime.Year() != 2024 {
+		t.Fatalf("Stat = %+v, %v", info, err)
+	}
+
+	var keys []string
+	a.List(ctx, func(i Info) error { keys = append(keys, i.Key); return nil })
+	if strings.Join(keys, ",") != "big,small,third" {
+		t.Fatalf("listed %q", keys)
+	}
+	if err := a.Delete(ctx, "small"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Get(ctx, "small"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get after Delete: %v", err)
+	}
+	if err := a.Delete(ctx, "small"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("second Delete: %v", err)
+	}
+}
+
+func TestAzureSignedURL(t *testing.T) {
+	a, err := NewAzure(AzureOptions{Account: "acct", Key: azureKey, Container: "box"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.now = func() time.Time { return time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC) }
+	raw, err := a.SignedURL("abc", time.Hour, `attachment; filename="a b.txt"`, "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(raw)
+	if u.Host != "acct.blob.core.windows.net" || u.Path != "/box/abc" {
+		t.Fatalf("signed URL %s", raw)
+	}
+	q := u.Query()
+	if q.Get("sp") != "r" || q.Get("sr") != "b" || q.Get("spr") != "https" || q.Get("se") != "2024-05-01T13:00:00Z" ||
+		q.Get("rscd") != `attachment; filename="a b.txt"` || q.Get("rsct") != "text/plain" {
+		t.Fatalf("SAS parameters %v", q)
+	}
+	// what the service signs to check it, field by field
+	toSign := "r\n" + q.Get("st") + "\n" + q.Get("se") + "\n/blob/acct/box/abc\n\n\nhttps\n" + q.Get("sv") +
+		"\nb\n\n\n\n" + q.Get("rscd") + "\n\n\n" + q.Get("rsct")
+	m := hmac.New(sha256.New, []byte("account key"))
+	m.Write([]byte(toSign))
+	if want := base64.StdEncoding.EncodeToString(m.Sum(nil)); q.Get("sig") != want {
+		t.Fatalf("sig = %s, want %s", q.Get("sig"), want)
+	}
+}
@@ -0,0 +1,481 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ChunkOptions says which blobs a Chunked backend splits, and into what.
+type ChunkOptions struct {
+	Over int64 // blobs at least this large are chunked; smaller ones are stored whole. 0 is 8 MiB
+	Avg  int   // the average chunk size, a power of two of at least 64 KiB; 0 is 1 MiB
+}
+
+// Chunked stores large blobs in another backend as content-defined chunks, keyed by their
+// SHA-256 and shared by every blob that has them, plus a manifest listing a blob's chunks.
+// Uploading a slightly changed copy of a large file only stores the chunks around the change.
+//
+// A blob's manifest is kept under its key with ".manifest" added, and each chunk under its
+// SHA-256 with ".chunk", so neither is mistaken for a blob stored whole. How many manifests
+// use each chunk is counted in memory, read from the manifests when first needed; deleting a
+// blob deletes the chunks no other manifest uses. Those counts are this process's, so servers
+// must not share a chunked store. Chunks a crash left unused go with gc.
+type Chunked struct {
+	inner Backend
+	opt   ChunkOptions
+
+	mu     sync.Mutex
+	refs   map[string]int // by chunk key; nil until loaded
+	loaded bool
+}
+
+const (
+	manifestSuffix = ".manifest"
+	chunkSuffix    = ".chunk"
+	manifestHeader = "filegoblin chunks 1"
+)
+
+// NewChunked returns a backend chunking blobs into inner.
+func NewChunked(inner Backend, opt ChunkOptions) (*Chunked, error) {
+	if opt.Over == 0 {
+		opt.Over = 8 << 20
+	}
+	if opt.Avg == 0 {
+		opt.Avg = 1 << 20
+	}
+	if opt.Avg < 64<<10 || opt.Avg&(opt.Avg-1) != 0 {
+		return nil, fmt.Errorf("storage: chunk size %d is not a power of two of at least 64KiB", opt.Avg)
+	}
+	return &Chunked{inner: inner, opt: opt}, nil
+}
+
+type chunkRef struct {
+	key  string
+	size int64
+}
+
+// Put stores r whole if it ends before Over bytes, and as chunks otherwise. Up to Over bytes
+// are held in memory until that's known.
+func (c *Chunked) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	if strings.HasSuffix(key, manifestSuffix) || strings.HasSuffix(key, chunkSuffix) {
+		return 0, fmt.Errorf("storage: invalid key %q", key)
+	}
+	if err := c.load(ctx); err != nil {
+		return 0, err
+	}
+	ck := newChunker(ctxReader{ctx, r}, c.opt.Avg)
+	var held [][]byte // the chunks so far, until there are Over bytes of them
+	var size int64
+	for size < c.opt.Over {
+		chunk, err := ck.next()
+		if err == io.EOF {
+			n, err := c.inner.Put(ctx, key, bytes.NewReader(bytes.Join(held, nil)))
+			if err != nil {
+				return n, err
+			}
+			return n, c.dropManifest(ctx, key)
+		}
+		if err != nil {
+			return size, err
+		}
+		held = append(held, bytes.Clone(chunk))
+		size += int64(len(chunk))
+	}
+
+	var chunks []chunkRef
+	defer func() {
+		if chunks != nil {
+			c.release(ctx, chunks) // failed: give back what was taken
+		}
+	}()
+	size = 0
+	next := func() ([]byte, error) {
+		if len(held) > 0 {
+			chunk := held[0]
+			held = held[1:]
+			return chunk, nil
+		}
+		return ck.next()
+	}
+	for {
+		chunk, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return size, err
+		}
+		ref, err := c.putChunk(ctx, chunk)
+		if err != nil {
+			return size, err
+		}
+		chunks = append(chunks, ref)
+		size += ref.size
+	}
+	var m strings.Builder
+	m.WriteString(manifestHeader + "\n")
+	for _, ch := range chunks {
+		fmt.Fprintf(&m, "%s %d\n", strings.TrimSuffix(ch.key, chunkSuffix), ch.size)
+	}
+	// a blob stored whole under the key would hide the manifest
+	if err := c.inner.Delete(ctx, key); err != nil && !errors.Is(err, ErrNotFound) {
+		return size, err
+	}
+	old, err := c.manifest(ctx, key+manifestSuffix)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return size, err
+	}
+	if _, err := c.inner.Put(ctx, key+manifestSuffix, strings.NewReader(m.String())); err != nil {
+		return size, err
+	}
+	chunks = nil
+	return size, c.release(ctx, old)
+}
+
+// dropManifest deletes the manifest of a chunked blob that key no longer is, with the chunks
+// only it used.
+func (c *Chunked) dropManifest(ctx context.Context, key string) error {
+	if err := c.load(ctx); err != nil {
+		return err
+	}
+	chunks, err := c.manifest(ctx, key+manifestSuffix)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := c.inner.Delete(ctx, key+manifestSuffix); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	return c.release(ctx, chunks)
+}
+
+// putChunk stores a chunk unless a manifest uses it already, and counts the use. One a crash
+// left unused is written again, so it's as new as the blob using it for gc's min age.
+func (c *Chunked) putChunk(ctx context.Context, chunk []byte) (chunkRef, error) {
+	sum := sha256.Sum256(chunk)
+	ref := chunkRef{key: hex.EncodeToString(sum[:]) + chunkSuffix, size: int64(len(chunk))}
+	c.mu.Lock()
+	c.refs[ref.key]++
+	stored := c.refs[ref.key] > 1
+	c.mu.Unlock()
+	if !stored {
+		if _, err := c.inner.Put(ctx, ref.key, bytes.NewReader(chunk)); err != nil {
+			c.release(ctx, []chunkRef{ref})
+			return ref, err
+		}
+	}
+	return ref, nil
+}
+
+// release drops a use of each chunk, deleting those no longer used.
+func (c *Chunked) release(ctx context.Context, chunks []chunkRef) error {
+	var unused []string
+	c.mu.Lock()
+	for _, ch := range chunks {
+		if c.refs[ch.key]--; c.refs[ch.key] <= 0 {
+			delete(c.refs, ch.key)
+			unused = append(unused, ch.key)
+		}
+	}
+	c.mu.Unlock()
+	// a Put taking one of these between the unlock and the delete writes it again
+	for _, key := range unused {
+		if err := c.inner.Delete(ctx, key); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// load counts the chunks' uses, once.
+func (c *Chunked) load(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.loaded {
+		return nil
+	}
+	refs := map[string]int{}
+	var manifests []string
+	err := c.inner.List(ctx, func(info Info) error {
+		if strings.HasSuffix(info.Key, manifestSuffix) {
+			manifests = append(manifests, info.Key)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range manifests {
+		chunks, err := c.manifest(ctx, key)
+		if errors.Is(err, ErrNotFound) {
+			continue // deleted since the listing
+		}
+		if err != nil {
+			return err
+		}
+		for _, ch := range chunks {
+			refs[ch.key]++
+		}
+	}
+	c.refs, c.loaded = refs, true
+	return nil
+}
+
+// manifest reads the chunk list stored under key.
+func (c *Chunked) manifest(ctx context.Context, key string) ([]chunkRef, error) {
+	rc, err := c.inner.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	sc := bufio.NewScanner(rc)
+	if !sc.Scan() || sc.Text() != manifestHeader {
+		return nil, fmt.Errorf("storage: %s is not a chunk manifest", key)
+	}
+	var chunks []chunkRef
+	for sc.Scan() {
+		sum, size, ok := strings.Cut(sc.Text(), " ")
+		n, err := strconv.ParseInt(size, 10, 64)
+		if !ok || err != nil || len(sum) != 64 {
+			return nil, fmt.Errorf("storage: bad line in chunk manifest %s: %q", key, sc.Text())
+		}
+		chunks = append(chunks, chunkRef{key: sum + chunkSuffix, size: n})
+	}
+	return chunks, sc.Err()
+}
+
+// open finds the blob under key: stored whole, or as the chunks a manifest lists.
+func (c *Chunked) open(ctx context.Context, key string) (whole io.ReadCloser, chunks []chunkRef, err error) {
+	rc, err := c.inner.Get(ctx, key)
+	if !errors.Is(err, ErrNotFound) {
+		return rc, nil, err
+	}
+	chunks, err = c.manifest(ctx, key+manifestSuffix)
+	return nil, chunks, err
+}
+
+// Get returns an io.ReadSeekCloser for a chunked blob, which opens the chunks it reads from
+// one at a time.
+func (c *Chunked) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	whole, chunks, err := c.open(ctx, key)
+	if err != nil || whole != nil {
+		return whole, err
+	}
+	var size int64
+	for _, ch := range chunks {
+		size += ch.size
+	}
+	return &chunkReader{ctx: ctx, inner: c.inner, chunks: chunks, size: size}, nil
+}
+
+func (c *Chunked) Stat(ctx context.Context, key string) (Info, error) {
+	info, err := c.inner.Stat(ctx, key)
+	if !errors.Is(err, ErrNotFound) {
+		return info, err
+	}
+	info, err = c.inner.Stat(ctx, key+manifestSuffix)
+	if err != nil {
+		return info, err
+	}
+	chunks, err := c.manifest(ctx, key+manifestSuffix)
+	if err != nil {
+		return Info{}, err
+	}
+	info.Key, info.Size = key, 0
+	for _, ch := range chunks {
+		info.Size += ch.size
+	}
+	return info, nil
+}
+
+func (c *Chunked) Delete(ctx context.Context, key string) error {
+	err := c.inner.Delete(ctx, key)
+	if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if err := c.load(ctx); err != nil {
+		return err
+	}
+	chunks, err := c.manifest(ctx, key+manifestSuffix)
+	if err != nil {
+		return err
+	}
+	if err := c.inner.Delete(ctx, key+manifestSuffix); err != nil {
+		return err
+	}
+	return c.release(ctx, chunks)
+}
+
+// List lists the blobs, stored whole or chunked, and not the chunks themselves.
+func (c *Chunked) List(ctx context.Context, fn func(Info) error) error {
+	return c.inner.List(ctx, func(info Info) error {
+		if strings.HasSuffix(info.Key, chunkSuffix) {
+			return nil
+		}
+		if key, ok := strings.CutSuffix(info.Key, manifestSuffix); ok {
+			full, err := c.Stat(ctx, key)
+			if errors.Is(err, ErrNotFound) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			info = full
+		}
+		return fn(info)
+	})
+}
+
+// Move moves a chunked blob's manifest; its chunks stay where they are.
+func (c *Chunked) Move(ctx context.Context, from, to string) error {
+	err := Move(ctx, c.inner, from, to)
+	if err == nil {
+		return c.dropManifest(ctx, to)
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if _, err := c.inner.Stat(ctx, from+manifestSuffix); err != nil {
+		return err
+	}
+	if err := c.inner.Delete(ctx, to); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if err := c.dropManifest(ctx, to); err != nil {
+		return err
+	}
+	return Move(ctx, c.inner, from+manifestSuffix, to+manifestSuffix)
+}
+
+// CleanTemp deletes the chunks no manifest uses that are older than cutoff, which a crash
+// between storing chunks and their manifest leaves behind, and cleans the inner backend's
+// temporary files.
+func (c *Chunked) CleanTemp(cutoff time.Time) (int, error) {
+	ctx := context.Background()
+	if err := c.load(ctx); err != nil {
+		return 0, err
+	}
+	var unused []string
+	err := c.inner.List(ctx, func(info Info) error {
+		if strings.HasSuffix(info.Key, chunkSuffix) && !info.ModTime.After(cutoff) {
+			c.mu.Lock()
+			used := c.refs[info.Key] > 0
+			c.mu.Unlock()
+			if !used {
+				unused = append(unused, info.Key)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, key := range unused {
+		c.mu.Lock()
+		used := c.refs[key] > 0 // taken by a Put since
+		c.mu.Unlock()
+		if used {
+			continue
+		}
+		if err := c.inner.Delete(ctx, key); err != nil && !errors.Is(err, ErrNotFound) {
+			return n, err
+		}
+		n++
+	}
+	if tc, ok := c.inner.(interface{ CleanTemp(time.Time) (int, error) }); ok {
+		m, err := tc.CleanTemp(cutoff)
+		return n + m, err
+	}
+	return n, nil
+}
+
+// Unwrap returns the backend the chunks and manifests are stored in.
+func (c *Chunked) Unwrap() Backend { return c.inner }
+
+// chunkReader reads a chunked blob, with the chunk at off open.
+type chunkReader struct {
+	ctx    context.Context
+	inner  Backend
+	chunks []chunkRef
+	size   int64
+	off    int64
+	cur    io.ReadCloser // the chunk at off, ending at end
+	end    int64
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if r.off >= r.size {
+		return 0, io.EOF
+	}
+	if r.cur == nil {
+		i, start := 0, int64(0)
+		for ; start+r.chunks[i].size <= r.off; i++ {
+			start += r.chunks[i].size
+		}
+		rc, err := r.inner.Get(r.ctx, r.chunks[i].key)
+		if err != nil {
+			return 0, fmt.Errorf("storage: chunk %s: %w", r.chunks[i].key, err)
+		}
+		if skip := r.off - start; skip > 0 {
+			if s, ok := rc.(io.Seeker); ok {
+				_, err = s.Seek(skip, io.SeekStart)
+			} else {
+				_, err = io.CopyN(io.Discard, rc, skip)
+			}
+			if err != nil {
+				rc.Close()
+				return 0, err
+			}
+		}
+		r.cur, r.end = rc, start+r.chunks[i].size
+	}
+	n, err := r.cur.Read(p[:min(int64(len(p)), r.end-r.off)])
+	r.off += int64(n)
+	if r.off == r.end {
+		r.cur.Close()
+		r.cur = nil
+		return n, nil
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF // a chunk shorter than its manifest says
+	}
+	return n, err
+}
+
+func (r *chunkReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return 0, errors.New("storage: seek before the start")
+	}
+	if offset != r.off && r.cur != nil {
+		r.cur.Close()
+		r.cur = nil
+	}
+	r.off = offset
+	return offset, nil
+}
+
+func (r *chunkReader) Close() error {
+	if r.cur == nil {
+		return nil
+	}
+	return r.cur.Close()
+}
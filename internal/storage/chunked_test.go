@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChunked(t *testing.T) {
+	mem := NewMemory()
+	c, err := NewChunked(mem, ChunkOptions{Over: 1 << 20, Avg: 64 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	chunks := func() (n int) {
+		mem.List(ctx, func(i Info) error {
+			if strings.HasSuffix(i.Key, chunkSuffix) {
+				n++
+			}
+			return nil
+		})
+		return n
+	}
+
+	big := make([]byte, 4<<20)
+	rand.New(rand.NewSource(1)).Read(big)
+	if n, err := c.Put(ctx, "v1", bytes.NewReader(big)); err != nil || n != int64(len(big)) {
+		t.Fatalf("Put v1 = %d, %v", n, err)
+	}
+	first := chunks()
+	if first < 16 || first > 256 {
+		t.Fatalf("4MiB made %d chunks of 64KiB on average", first)
+	}
+
+	// a few bytes inserted in the middle only change the chunks around them
+	v2 := append(bytes.Clone(big[:2<<20]), "a small edit"...)
+	v2 = append(v2, big[2<<20:]...)
+	if _, err := c.Put(ctx, "v2", bytes.NewReader(v2)); err != nil {
+		t.Fatal(err)
+	}
+	if added := chunks() - first; added < 1 || added > 3 {
+		t.Fatalf("the edit stored %d new chunks", added)
+	}
+	if _, err := c.Put(ctx, "small", strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if rc, err := mem.Get(ctx, "small"); err != nil {
+		t.Fatalf("small blob isn't stored whole: %v", err)
+	} else {
+		rc.Close()
+	}
+
+	rc, err := c.Get(ctx, "v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(rc); !bytes.Equal(got, v2) {
+		t.Fatalf("read back %d bytes, not v2", len(got))
+	}
+	rs := rc.(io.ReadSeeker)
+	rs.Seek(-5, io.SeekEnd)
+	if tail, _ := io.ReadAll(rs); !bytes.Equal(tail, v2[len(v2)-5:]) {
+		t.Fatalf("read %q from the end", tail)
+	}
+	rs.Seek(2<<20, io.SeekStart)
+	mid := make([]byte, 12)
+	if _, err := io.ReadFull(rs, mid); err != nil || string(mid) != "a small edit" {
+		t.Fatalf("read %q from the middle: %v", mid, err)
+	}
+	rc.Close()
+	if info, err := c.Stat(ctx, "v2"); err != nil || info.Size != int64(len(v2)) || info.Key != "v2" {
+		t.Fatalf("Stat = %+v, %v", info, err)
+	}
+	var keys []string
+	c.List(ctx, func(i Info) error { keys = append(keys, i.Key); return nil })
+	if strings.Join(keys, ",") != "small,v1,v2" {
+		t.Fatalf("listed %q", keys)
+	}
+
+	// a new backend reads the uses back from the manifests
+	c, _ = NewChunked(mem, ChunkOptions{Over: 1 << 20, Avg: 64 << 10})
+	if err := c.Move(ctx, "v1", "moved"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete(ctx, "moved"); err != nil {
+		t.Fatal(err)
+	}
+	if n := chunks(); n < first-3 || n > first {
+		t.Fatalf("%d chunks left, not v2's", n)
+	}
+	if rc, err := c.Get(ctx, "v2"); err != nil {
+		t.Fatal(err)
+	} else if got, _ := io.ReadAll(rc); !bytes.Equal(got, v2) {
+		t.Fatal("v2 changed when v1 was deleted")
+	}
+	if err := c.Delete(ctx, "v2"); err != nil {
+		t.Fatal(err)
+	}
+	if n := chunks(); n != 0 {
+		t.Fatalf("%d chunks left after deleting everything", n)
+	}
+	if _, err := c.Get(ctx, "v2"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get after Delete: %v", err)
+	}
+
+	// a chunk no manifest uses is gc'd once old enough
+	mem.Put(ctx, strings.Repeat("0", 64)+chunkSuffix, strings.NewReader("lost"))
+	if n, err := c.CleanTemp(time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Fatalf("CleanTemp of a new chunk = %d, %v", n, err)
+	}
+	if n, err := c.CleanTemp(time.Now().Add(time.Second)); err != nil || n != 1 {
+		t.Fatalf("CleanTemp = %d, %v", n, err)
+	}
+}
@@ -0,0 +1,361 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Compressed gzips blobs of types that compress well, text, JSON, XML and the like, before
+// they reach another backend, and leaves the rest, images, video, archives, as they are:
+// compressing what's compressed already costs time and saves nothing. Reads decompress, so
+// callers never see the difference, and EncodedGetter hands out the gzip stream itself to
+// serve to clients that accept it.
+//
+// A compressed blob is "FGZ1", a gzip stream, and the content's length (uint64 BE), which
+// Stat reads so it doesn't have to decompress. Blobs stored before compression was turned
+// on, or stored as they were, have no such header and are read as they are.
+type Compressed struct {
+	inner Backend
+}
+
+const (
+	compressedMagic = "FGZ1"
+	compressMin     = 1 << 10 // smaller blobs hardly shrink, and aren't worth a header
+)
+
+// EncodedGetter is implemented by backends that may keep a blob compressed, to send it to
+// clients that take it that way without decompressing it first.
+type EncodedGetter interface {
+	// GetEncoded returns the blob under key as it's stored and its Content-Encoding, like
+	// "gzip", or what Get would, and "", if it isn't stored compressed.
+	GetEncoded(ctx context.Context, key string) (io.ReadCloser, string, error)
+}
+
+// NewCompressed returns a backend compressing blobs into inner.
+func NewCompressed(inner Backend) *Compressed {
+	return &Compressed{inner: inner}
+}
+
+type contentTypeKey struct{}
+
+// WithContentType tells a Compressed backend, whether called directly or by one wrapping
+// it, the type of what ctx's Put stores, so it needn't guess from the first bytes.
+func WithContentType(ctx context.Context, contentType string) context.Context {
+	return context.WithValue(ctx, contentTypeKey{}, contentType)
+}
+
+// compressible reports whether content of the type generally shrinks when gzipped.
+func compressible(contentType string) bool {
+	t, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(t, "text/"), strings.HasSuffix(t, "+json"), strings.HasSuffix(t, "+xml"):
+		return true
+	}
+	switch t {
+	case "application/json", "application/x-ndjson", "application/xml", "application/javascript",
+		"application/x-javascript", "application/ecmascript", "application/yaml", "application/x-yaml",
+		"application/toml", "application/sql", "application/x-sh", "application/rtf", "application/postscript",
+		"application/wasm", "application/x-tar", "image/bmp", "image/x-icon", "image/vnd.microsoft.icon",
+		"image/tiff", "font/ttf", "font/otf", "application/vnd.ms-fontobject":
+		return true
+	}
+	return false
+}
+
+// Put compresses r if its type, from WithContentType or else sniffed, compresses and it's
+// at least 1KiB, and returns the number of bytes read from r.
+func (c *Compressed) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	br := bufio.NewReaderSize(r, compressMin)
+	head, err := br.Peek(compressMin)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	ct, ok := ctx.Value(contentTypeKey{}).(string)
+	if !ok {
+		ct = http.DetectContentType(head)
+	}
+	if len(head) < compressMin || !compressible(ct) {
+		return c.inner.Put(ctx, key, br)
+	}
+	gz := &gzipReader{r: br}
+	gz.out.WriteString(compressedMagic)
+	gz.zw = gzip.NewWriter(&gz.out)
+	if _, err := c.inner.Put(ctx, key, gz); err != nil {
+		return gz.n, err
+	}
+	return gz.n, nil
+}
+
+// gzipReader compresses what it reads from r, between the header and the trailer.
+type gzipReader struct {
+	r    io.Reader
+	zw   *gzip.Writer
+	out  bytes.Buffer // compressed, not yet read
+	buf  []byte
+	n    int64
+	done bool
+}
+
+func (g *gzipReader) Read(p []byte) (int, error) {
+	if g.buf == nil {
+		g.buf = make([]byte, 32<<10)
+	}
+	for g.out.Len() == 0 && !g.done {
+		n, err := g.r.Read(g.buf)
+		g.n += int64(n)
+		if _, werr := g.zw.Write(g.buf[:n]); werr != nil {
+			return 0, werr
+		}
+		if err == io.EOF {
+			if err := g.zw.Close(); err != nil {
+				return 0, err
+			}
+			binary.Write(&g.out, binary.BigEndian, uint64(g.n))
+			g.done = true
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	if g.out.Len() == 0 {
+		return 0, io.EOF
+	}
+	return g.out.Read(p)
+}
+
+// open opens the blob under key and reports whether it's compressed, and if so, the
+// content's length and the stored blob's; the reader is at the start of the gzip stream then,
+// and at the start of the blob otherwise.
+func (c *Compressed) open(ctx context.Context, key string) (rc io.ReadCloser, compressed bool, size, stored int64, err error) {
+	rc, err = c.inner.Get(ctx, key)
+	if err != nil {
+		return nil, false, 0, 0, err
+	}
+	rs, seekable := rc.(io.ReadSeeker)
+	head := make([]byte, len(compressedMagic))
+	n, err := io.ReadFull(rc, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		rc.Close()
+		return nil, false, 0, 0, err
+	}
+	if string(head) != compressedMagic {
+		if seekable {
+			_, err = rs.Seek(0, io.SeekStart)
+		} else {
+			rc, err = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(head[:n]), rc), rc}, nil
+		}
+		return rc, false, 0, 0, err
+	}
+	if !seekable {
+		return rc, true, -1, -1, nil
+	}
+	trailer := make([]byte, 8)
+	if stored, err = rs.Seek(-8, io.SeekEnd); err == nil {
+		if _, err = io.ReadFull(rs, trailer); err == nil {
+			_, err = rs.Seek(int64(len(compressedMagic)), io.SeekStart)
+		}
+	}
+	if err != nil {
+		rc.Close()
+		return nil, false, 0, 0, err
+	}
+	return rc, true, int64(binary.BigEndian.Uint64(trailer)), stored + 8, nil
+}
+
+// Get returns an io.ReadSeekCloser for a compressed blob when inner does, but seeking
+// backwards decompresses again from the start, and forwards decompresses what's skipped.
+func (c *Compressed) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	rc, compressed, size, _, err := c.open(ctx, key)
+	if err != nil || !compressed {
+		return rc, err
+	}
+	if size < 0 {
+		zr, err := gzip.NewReader(rc)
+		if err != nil {
+			rc.Close()
+			return nil, err
+		}
+		zr.Multistream(false) // the trailer follows
+		return struct {
+			io.Reader
+			io.Closer
+		}{zr, rc}, nil
+	}
+	return &gunzipReader{src: rc.(io.ReadSeeker), closer: rc, size: size}, nil
+}
+
+// gunzipReader decompresses a compressed blob from src, from off on.
+type gunzipReader struct {
+	src    io.ReadSeeker
+	closer io.Closer
+	size   int64
+	off    int64
+	zr     *gzip.Reader
+	pos    int64 // how much zr has decompressed
+}
+
+func (g *gunzipReader) Read(p []byte) (int, error) {
+	if g.off >= g.size {
+		return 0, io.EOF
+	}
+	if g.zr == nil || g.pos > g.off {
+		if _, err := g.src.Seek(int64(len(compressedMagic)), io.SeekStart); err != nil {
+			return 0, err
+		}
+		zr, err := gzip.NewReader(bufio.NewReader(g.src))
+		if err != nil {
+			return 0, err
+		}
+		zr.Multistream(false)
+		g.zr, g.pos = zr, 0
+	}
+	if g.pos < g.off {
+		n, err := io.CopyN(io.Discard, g.zr, g.off-g.pos)
+		g.pos += n
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := g.zr.Read(p[:min(int64(len(p)), g.size-g.off)])
+	g.pos += int64(n)
+	g.off += int64(n)
+	if err == io.EOF {
+		err = nil
+		if g.off < g.size {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+	return n, err
+}
+
+func (g *gunzipReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += g.off
+	case io.SeekEnd:
+		offset += g.size
+	}
+	if offset < 0 {
+		return 0, errors.New("storage: seek before the start")
+	}
+	g.off = offset
+	return offset, nil
+}
+
+func (g *gunzipReader) Close() error { return g.closer.Close() }
+
+// GetEncoded returns a compressed blob's gzip stream, an io.ReadSeekCloser when inner
+// returns one.
+func (c *Compressed) GetEncoded(ctx context.Context, key string) (io.ReadCloser, string, error) {
+	rc, compressed, _, stored, err := c.open(ctx, key)
+	if err != nil {
+		return nil, "", err
+	}
+	if !compressed {
+		return rc, "", nil
+	}
+	if stored < 0 {
+		// the gzip stream knows where it ends; the trailer is left unread
+		return struct {
+			io.Reader
+			io.Closer
+		}{&span{r: rc, end: -1}, rc}, "gzip", nil
+	}
+	start := int64(len(compressedMagic))
+	return &span{r: rc, start: start, off: start, end: stored - 8}, "gzip", nil
+}
+
+// span reads r from start to end, or to its end if end is -1, but for the trailer then.
+type span struct {
+	r               io.ReadCloser
+	start, off, end int64
+}
+
+func (s *span) Read(p []byte) (int, error) {
+	if s.end >= 0 {
+		if s.off >= s.end {
+			return 0, io.EOF
+		}
+		p = p[:min(int64(len(p)), s.end-s.off)]
+	}
+	n, err := s.r.Read(p)
+	s.off += int64(n)
+	return n, err
+}
+
+func (s *span) Seek(offset int64, whence int) (int64, error) {
+	rs, ok := s.r.(io.Seeker)
+	if !ok {
+		return 0, errors.New("storage: blob can't seek")
+	}
+	switch whence {
+	case io.SeekStart:
+		offset += s.start
+	case io.SeekCurrent:
+		offset += s.off
+	case io.SeekEnd:
+		offset += s.end
+	}
+	if offset < s.start {
+		return 0, errors.New("storage: seek before the start")
+	}
+	off, err := rs.Seek(offset, io.SeekStart)
+	s.off = off
+	return off - s.start, err
+}
+
+func (s *span) Close() error { return s.r.Close() }
+
+// Stat reports a compressed blob's content length, which takes reading its trailer.
+func (c *Compressed) Stat(ctx context.Context, key string) (Info, error) {
+	info, err := c.inner.Stat(ctx, key)
+	if err != nil {
+		return info, err
+	}
+	rc, compressed, size, _, err := c.open(ctx, key)
+	if err != nil {
+		return Info{}, err
+	}
+	rc.Close()
+	if compressed && size >= 0 {
+		info.Size = size
+	}
+	return info, nil
+}
+
+func (c *Compressed) Delete(ctx context.Context, key string) error {
+	return c.inner.Delete(ctx, key)
+}
+
+// List lists blobs with the sizes they take in inner, compressed or not.
+func (c *Compressed) List(ctx context.Context, fn func(Info) error) error {
+	return c.inner.List(ctx, fn)
+}
+
+func (c *Compressed) Move(ctx context.Context, from, to string) error {
+	return Move(ctx, c.inner, from, to)
+}
+
+// Unwrap returns the backend the blobs are compressed into.
+func (c *Compressed) Unwrap() Backend { return c.inner }
+
+func (c *Compressed) CleanTemp(cutoff time.Time) (int, error) {
+	if tc, ok := c.inner.(interface{ CleanTemp(time.Time) (int, error) }); ok {
+		return tc.CleanTemp(cutoff)
+	}
+	return 0, nil
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestCompressed(t *testing.T) {
+	mem := NewMemory()
+	c := NewCompressed(mem)
+	ctx := context.Background()
+	text := []byte(strings.Repeat("a line of text that repeats, and so compresses well\n", 2000))
+	noise := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(noise)
+
+	if n, err := c.Put(ctx, "text", bytes.NewReader(text)); err != nil || n != int64(len(text)) {
+		t.Fatalf("Put text = %d, %v", n, err)
+	}
+	c.Put(WithContentType(ctx, "image/png"), "png", bytes.NewReader(text)) // taken at its word
+	c.Put(ctx, "noise", bytes.NewReader(noise))
+	c.Put(ctx, "short", strings.NewReader("too short to bother"))
+	for key, compressed := range map[string]bool{"text": true, "png": false, "noise": false, "short": false} {
+		stored := must(io.ReadAll(must(mem.Get(ctx, key))))
+		if bytes.HasPrefix(stored, []byte(compressedMagic)) != compressed {
+			t.Fatalf("%s stored compressed: %v", key, !compressed)
+		}
+	}
+	if info, err := c.Stat(ctx, "text"); err != nil || info.Size != int64(len(text)) {
+		t.Fatalf("Stat = %+v, %v", info, err)
+	}
+
+	rc, err := c.Get(ctx, "text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := must(io.ReadAll(rc)); !bytes.Equal(got, text) {
+		t.Fatalf("read back %d bytes of %d", len(got), len(text))
+	}
+	rs := rc.(io.ReadSeeker)
+	for _, off := range []int64{50000, 1000, int64(len(text)) - 7} { // forwards, back, to the end
+		rs.Seek(off, io.SeekStart)
+		got := make([]byte, 7)
+		if _, err := io.ReadFull(rs, got); err != nil || !bytes.Equal(got, text[off:off+7]) {
+			t.Fatalf("read %q at %d: %v", got, off, err)
+		}
+	}
+	rc.Close()
+	if rc, _ := c.Get(ctx, "noise"); !bytes.Equal(must(io.ReadAll(rc)), noise) {
+		t.Fatal("noise changed")
+	}
+
+	rc, enc, err := c.GetEncoded(ctx, "text")
+	if err != nil || enc != "gzip" {
+		t.Fatalf("GetEncoded = %q, %v", enc, err)
+	}
+	zr, err := gzip.NewReader(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := must(io.ReadAll(zr)); !bytes.Equal(got, text) {
+		t.Fatalf("gunzipped %d bytes of %d", len(got), len(text))
+	}
+	if rc, enc, _ := c.GetEncoded(ctx, "short"); enc != "" || string(must(io.ReadAll(rc))) != "too short to bother" {
+		t.Fatalf("GetEncoded of a blob stored as it is: %q", enc)
+	}
+}
@@ -0,0 +1,189 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Disk keeps blobs as plain files under a directory, fanned out by the first two characters
+// of the key so a single directory never ends up with millions of entries.
+type Disk struct {
+	dir string
+}
+
+// NewDisk creates the blob directory if needed and returns a backend rooted there.
+func NewDisk(dir string) (*Disk, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "tmp"), 0o750); err != nil {
+		return nil, fmt.Errorf("create blob dir: %w", err)
+	}
+	return &Disk{dir: dir}, nil
+}
+
+func (d *Disk) path(key string) (string, error) {
+	// keys come from our own ID generator, but never trust them to be path-safe
+	if key == "" || strings.ContainsAny(key, `/\`+keyReserved) || key == "." || key == ".." || key == "tmp" {
+		return "", fmt.Errorf("storage: invalid key %q", key)
+	}
+	shard := key
+	if len(shard) > 2 {
+		shard = shard[:2]
+	}
+	return filepath.Join(d.dir, shard, key), nil
+}
+
+// Put writes to a temp file first and renames it into place, so readers never see half a
+// blob, and syncs both before returning.
+func (d *Disk) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	dst, err := d.path(key)
+	if err != nil {
+		return 0, err
+	}
+	tmp, err := os.CreateTemp(filepath.Join(d.dir, "tmp"), "put-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+
+	n, err := io.Copy(tmp, ctxReader{ctx, r})
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return n, err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o750); err != nil {
+		return n, err
+	}
+	if err := rename(tmp.Name(), dst); err != nil {
+		return n, err
+	}
+	// the rename isn't on disk until the directory is: without this a crash could lose a
+	// blob whose record has been written since
+	return n, syncDir(filepath.Dir(dst))
+}
+
+// Move renames the blob's file.
+func (d *Disk) Move(_ context.Context, from, to string) error {
+	src, err := d.path(from)
+	if err != nil {
+		return err
+	}
+	dst, err := d.path(to)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o750); err != nil {
+		return err
+	}
+	err = rename(src, dst)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Get returns the *os.File itself, which is seekable.
+func (d *Disk) Get(_ context.Context, key string) (io.ReadCloser, error) {
+	p, err := d.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := openBlob(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (d *Disk) Stat(_ context.Context, key string) (Info, error) {
+	p, err := d.path(key)
+	if err != nil {
+		return Info{}, err
+	}
+	fi, err := os.Stat(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Info{}, ErrNotFound
+	}
+	if err != nil {
+		return Info{}, err
+	}
+	return Info{Key: key, Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
+
+func (d *Disk) Delete(_ context.Context, key string) error {
+	p, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	err = remove(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (d *Disk) List(ctx context.Context, fn func(Info) error) error {
+	return filepath.WalkDir(d.dir, func(p string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if e.IsDir() {
+			if e.Name() == "tmp" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		fi, err := e.Info()
+		if err != nil {
+			return err
+		}
+		return fn(Info{Key: e.Name(), Size: fi.Size(), ModTime: fi.ModTime()})
+	})
+}
+
+// ctxReader stops a long copy as soon as the request is cancelled.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// CleanTemp removes partial uploads left in tmp/ by a crash, skipping anything modified
+// after cutoff since it may belong to an upload still in progress.
+func (d *Disk) CleanTemp(cutoff time.Time) (int, error) {
+	entries, err := os.ReadDir(filepath.Join(d.dir, "tmp"))
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, e := range entries {
+		fi, err := e.Info()
+		if err != nil || fi.ModTime().After(cutoff) {
+			continue
+		}
+		if err := remove(filepath.Join(d.dir, "tmp", e.Name())); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
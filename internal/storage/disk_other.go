@@ -0,0 +1,27 @@
+//go:build !windows
+
+package storage
+
+import "os"
+
+// keyReserved are the characters a key can't contain besides path separators.
+const keyReserved = ""
+
+func openBlob(p string) (*os.File, error) { return os.Open(p) }
+
+func rename(from, to string) error { return os.Rename(from, to) }
+
+func remove(p string) error { return os.Remove(p) }
+
+// syncDir syncs the directory p, so that entries just created or renamed in it survive a crash.
+func syncDir(p string) error {
+	d, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
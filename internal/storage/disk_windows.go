@@ -0,0 +1,56 @@
+//go:build windows
+
+package storage
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"syscall"
+	"time"
+)
+
+// keyReserved are the characters a key can't contain besides path separators; "a:b" would
+// name the alternate data stream b of the file a.
+const keyReserved = ":"
+
+// openBlob opens p for reading the way Unix does: with FILE_SHARE_DELETE, so that a blob
+// can be deleted or replaced while it is being downloaded. os.Open doesn't share delete,
+// which makes Delete fail for as long as any reader has the file open.
+func openBlob(p string) (*os.File, error) {
+	name, err := syscall.UTF16PtrFromString(p)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: p, Err: err}
+	}
+	h, err := syscall.CreateFile(name, syscall.GENERIC_READ,
+		syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE,
+		nil, syscall.OPEN_EXISTING, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: p, Err: err}
+	}
+	return os.NewFile(uintptr(h), p), nil
+}
+
+// rename and remove retry for a while when something else has the file open without
+// sharing it; virus scanners and indexers briefly do that to every file written.
+func rename(from, to string) error { return retry(func() error { return os.Rename(from, to) }) }
+
+func remove(p string) error { return retry(func() error { return os.Remove(p) }) }
+
+// syncDir does nothing: NTFS journals renames itself, and Windows won't sync a directory
+// opened for reading.
+func syncDir(string) error { return nil }
+
+const errSharingViolation syscall.Errno = 32 // ERROR_SHARING_VIOLATION
+
+func retry(op func() error) error {
+	wait := 10 * time.Millisecond
+	for {
+		err := op()
+		if wait > time.Second || !(errors.Is(err, syscall.ERROR_ACCESS_DENIED) || errors.Is(err, errSharingViolation)) {
+			return err
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
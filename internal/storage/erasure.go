@@ -0,0 +1,654 @@
+package storage
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"slices"
+	"sort"
+	"time"
+)
+
+// ErasureOptions configure an Erasure backend.
+type ErasureOptions struct {
+	// Dirs are where the shards go, one each and ideally each on a disk of its own. The last
+	// Parity of them hold parity, the rest the data.
+	Dirs []string
+	// Parity is how many of the dirs can be lost without losing a blob.
+	Parity int
+	// ShardSize is how much of a blob goes in each data shard at a time, 64KiB unless set.
+	ShardSize int
+}
+
+// Erasure stripes blobs across several directories with a Reed-Solomon code: each stripe of a
+// blob is cut into k data shards and m parity shards, and any k of them recover it, so up to m
+// disks can fail or return garbage without losing anything. Writes succeed as long as k
+// shards can be written; Rebuild writes the rest once the disks are back or replaced.
+//
+// Every shard is a file in its directory under the blob's key: a header, the shard's blocks
+// each followed by its CRC-32, so damage is noticed and read around, and the blob's length.
+// The header carries the time of the write, so a shard a write missed isn't mistaken for
+// part of the blob that replaced it.
+type Erasure struct {
+	disks []*Disk
+	code  *rsCode
+	block int
+}
+
+const (
+	erasureMagic   = "FGE1"
+	erasureHeader  = 20 // magic, k, m, index, reserved, block size (uint32), write time (uint64)
+	erasureTrailer = 8  // blob length (uint64)
+)
+
+// RebuildResult is what a Rebuild pass did.
+type RebuildResult struct {
+	Checked int `json:"checked"` // blobs read
+	Rebuilt int `json:"rebuilt"` // blobs missing shards or with damaged ones that were written again
+	Lost    int `json:"lost"`    // blobs with too few shards left to recover
+}
+
+// NewErasure returns a backend striping blobs across opt.Dirs.
+func NewErasure(opt ErasureOptions) (*Erasure, error) {
+	k := len(opt.Dirs) - opt.Parity
+	if opt.Parity < 1 || k < 1 {
+		return nil, fmt.Errorf("storage: %d dirs can't hold %d parity shards and data", len(opt.Dirs), opt.Parity)
+	}
+	code, err := newRSCode(k, opt.Parity)
+	if err != nil {
+		return nil, err
+	}
+	if opt.ShardSize <= 0 {
+		opt.ShardSize = 64 << 10
+	}
+	e := &Erasure{code: code, block: opt.ShardSize}
+	for _, dir := range opt.Dirs {
+		d, err := NewDisk(dir)
+		if err != nil {
+			return nil, err
+		}
+		e.disks = append(e.disks, d)
+	}
+	return e, nil
+}
+
+func (e *Erasure) header(index int, written int64) []byte {
+	h := make([]byte, erasureHeader)
+	copy(h, erasureMagic)
+	h[4], h[5], h[6] = byte(e.code.k), byte(e.code.m), byte(index)
+	binary.BigEndian.PutUint32(h[8:], uint32(e.block))
+	binary.BigEndian.PutUint64(h[12:], uint64(written))
+	return h
+}
+
+// shardWriters starts a Put of key on the disks of each index, and returns the pipes that feed
+// them and a function that waits for the Puts and returns their errors, by index.
+func (e *Erasure) shardWriters(ctx context.Context, key string, indexes []int) ([]*io.PipeWriter, func() map[int]error) {
+	type result struct {
+		i   int
+		err error
+	}
+	results := make(chan result, len(indexes))
+	pipes := make([]*io.PipeWriter, len(indexes))
+	for n, i := range indexes {
+		pr, pw := io.Pipe()
+		pipes[n] = pw
+		go func() {
+			_, err := e.disks[i].Put(ctx, key, pr)
+			pr.CloseWithError(errReplicaDone)
+			results <- result{i, err}
+		}()
+	}
+	return pipes, func() map[int]error {
+		errs := map[int]error{}
+		for range indexes {
+			if res := <-results; res.err != nil {
+				errs[res.i] = res.err
+			}
+		}
+		return errs
+	}
+}
+
+// writeShards writes a block to each live pipe, dropping those that fail.
+func writeShards(pipes []*io.PipeWriter, blocks [][]byte) (live int) {
+	for n, pw := range pipes {
+		if pw == nil {
+			continue
+		}
+		if _, err := pw.Write(blocks[n]); err != nil {
+			pipes[n] = nil
+			continue
+		}
+		live++
+	}
+	return live
+}
+
+// Put encodes r a stripe at a time and streams the shards to all the disks at once. It fails
+// when fewer than k of them took their shard.
+func (e *Erasure) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	k, all := e.code.k, e.code.k+e.code.m
+	indexes := make([]int, all)
+	for i := range indexes {
+		indexes[i] = i
+	}
+	pipes, wait := e.shardWriters(ctx, key, indexes)
+	written := time.Now().UnixNano()
+	blocks := make([][]byte, all)
+	for i := range blocks {
+		blocks[i] = e.header(i, written)
+	}
+	live := writeShards(pipes, blocks)
+
+	data := make([]byte, k*e.block)
+	shards := e.stripeBuffers(data)
+	for i := range blocks {
+		blocks[i] = make([]byte, e.block+4)
+	}
+	var n int64
+	var failed error
+	for live >= k {
+		m, err := io.ReadFull(r, data)
+		n += int64(m)
+		if err == io.EOF {
+			break
+		} else if err != nil && err != io.ErrUnexpectedEOF {
+			failed = err
+			break
+		}
+		clear(data[m:])
+		e.code.encode(shards)
+		for i, s := range shards {
+			copy(blocks[i], s)
+			binary.BigEndian.PutUint32(blocks[i][e.block:], crc32.ChecksumIEEE(s))
+		}
+		live = writeShards(pipes, blocks)
+		if err == io.ErrUnexpectedEOF {
+			break
+		}
+	}
+	if failed == nil {
+		trailer := binary.BigEndian.AppendUint64(nil, uint64(n))
+		for i := range blocks {
+			blocks[i] = trailer
+		}
+		live = writeShards(pipes, blocks)
+	}
+	if failed == nil && live < k {
+		failed = fmt.Errorf("storage: %d of %d shards could be written, %d needed", live, all, k)
+	}
+	for _, pw := range pipes {
+		if pw != nil {
+			pw.CloseWithError(failed)
+		}
+	}
+	errs := wait()
+	if failed != nil {
+		return n, failed
+	}
+	if len(errs) > e.code.m {
+		var shardErrs []error
+		for i, err := range errs {
+			shardErrs = append(shardErrs, fmt.Errorf("shard %d: %w", i, err))
+		}
+		return n, fmt.Errorf("storage: too few shards written: %w", errors.Join(shardErrs...))
+	}
+	return n, nil
+}
+
+// stripeBuffers returns a buffer for each shard of a stripe, the data shards' backed by data.
+func (e *Erasure) stripeBuffers(data []byte) [][]byte {
+	shards := make([][]byte, e.code.k+e.code.m)
+	for i := range shards {
+		if i < e.code.k {
+			shards[i] = data[i*e.block : (i+1)*e.block]
+		} else {
+			shards[i] = make([]byte, e.block)
+		}
+	}
+	return shards
+}
+
+// ecShards are the open shard files of a blob, nil where a shard is missing or was left by
+// another write.
+type ecShards struct {
+	files   []io.ReadSeekCloser
+	size    int64
+	stripes int64
+	written int64
+}
+
+func (s *ecShards) Close() error {
+	for _, f := range s.files {
+		if f != nil {
+			f.Close()
+		}
+	}
+	return nil
+}
+
+type shardHead struct {
+	written, size, stripes int64
+}
+
+// openShards opens the shards of the latest write of key that left at least k of them.
+func (e *Erasure) openShards(ctx context.Context, key string) (*ecShards, error) {
+	files := make([]io.ReadSeekCloser, len(e.disks))
+	heads := make([]shardHead, len(e.disks))
+	found := false
+	var lastErr error
+	for i, d := range e.disks {
+		rc, err := d.Get(ctx, key)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		found = true
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		f, ok := rc.(io.ReadSeekCloser)
+		if !ok {
+			rc.Close()
+			continue
+		}
+		if heads[i], err = e.readHead(f, i); err != nil {
+			f.Close()
+			lastErr = err
+			continue
+		}
+		files[i] = f
+	}
+	if !found {
+		return nil, ErrNotFound
+	}
+	// the latest write that left enough shards wins
+	counts := map[int64]int{}
+	var best int64 = -1
+	for i, f := range files {
+		if f != nil {
+			if counts[heads[i].written]++; counts[heads[i].written] >= e.code.k && heads[i].written > best {
+				best = heads[i].written
+			}
+		}
+	}
+	sh := &ecShards{files: files, written: best}
+	for i, f := range files {
+		if f != nil && heads[i].written != best {
+			f.Close()
+			files[i] = nil
+		} else if f != nil {
+			sh.size, sh.stripes = heads[i].size, heads[i].stripes
+		}
+	}
+	if best < 0 {
+		sh.Close()
+		err := fmt.Errorf("storage: too few shards of %s left", key)
+		if lastErr != nil {
+			err = fmt.Errorf("%w: %w", err, lastErr)
+		}
+		return nil, err
+	}
+	return sh, nil
+}
+
+// readHead checks a shard file's header and trailer.
+func (e *Erasure) readHead(f io.ReadSeeker, index int) (shardHead, error) {
+	var h shardHead
+	end, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return h, err
+	}
+	stride := int64(e.block + 4)
+	body := end - erasureHeader - erasureTrailer
+	if body < 0 || body%stride != 0 {
+		return h, errors.New("storage: shard has the wrong length")
+	}
+	buf := make([]byte, erasureHeader)
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return h, err
+	}
+	if _, err := io.ReadFull(f, buf); err != nil {
+		return h, err
+	}
+	if string(buf[:4]) != erasureMagic || int(buf[4]) != e.code.k || int(buf[5]) != e.code.m ||
+		int(buf[6]) != index || binary.BigEndian.Uint32(buf[8:]) != uint32(e.block) {
+		return h, errors.New("storage: shard isn't one of this layout's")
+	}
+	h.written = int64(binary.BigEndian.Uint64(buf[12:]))
+	if _, err := f.Seek(-erasureTrailer, io.SeekEnd); err != nil {
+		return h, err
+	}
+	if _, err := io.ReadFull(f, buf[:erasureTrailer]); err != nil {
+		return h, err
+	}
+	h.size, h.stripes = int64(binary.BigEndian.Uint64(buf)), body/stride
+	width := int64(e.code.k * e.block)
+	if h.size > h.stripes*width || h.stripes > 0 && h.size <= (h.stripes-1)*width {
+		return h, errors.New("storage: shard's length doesn't match the blob's")
+	}
+	return h, nil
+}
+
+// readBlock reads shard i's block of stripe s into buf and checks it.
+func (e *Erasure) readBlock(sh *ecShards, i int, s int64, buf, crc []byte) bool {
+	f := sh.files[i]
+	if f == nil {
+		return false
+	}
+	if _, err := f.Seek(erasureHeader+s*int64(e.block+4), io.SeekStart); err != nil {
+		return false
+	}
+	if _, err := io.ReadFull(f, buf); err != nil {
+		return false
+	}
+	if _, err := io.ReadFull(f, crc); err != nil {
+		return false
+	}
+	return crc32.ChecksumIEEE(buf) == binary.BigEndian.Uint32(crc)
+}
+
+// readStripe reads stripe s into shards, recovering those that are missing or damaged and
+// marking them in bad, which may be nil. With every false, it only reads as many shards as
+// it takes to get the data, and the parity shards are left as they are.
+func (e *Erasure) readStripe(sh *ecShards, s int64, shards [][]byte, every bool, bad []bool) error {
+	k := e.code.k
+	ok := make([]bool, len(shards))
+	crc := make([]byte, 4)
+	good := 0
+	for i := range shards {
+		if !every && good == k && i >= k {
+			break
+		}
+		if ok[i] = e.readBlock(sh, i, s, shards[i], crc); ok[i] {
+			good++
+		} else if bad != nil {
+			bad[i] = true
+		}
+	}
+	if good < k {
+		return fmt.Errorf("storage: too few shards of stripe %d readable", s)
+	}
+	if !every && !slices.Contains(ok[:k], false) {
+		return nil // the data is all there, and the parity isn't wanted
+	}
+	return e.code.reconstruct(shards, ok)
+}
+
+// Get returns an io.ReadSeekCloser that reads the data shards, and recovers a stripe from the
+// parity only when one of them is missing or damaged.
+func (e *Erasure) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	sh, err := e.openShards(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, e.code.k*e.block)
+	return &erasureReader{e: e, sh: sh, data: data, shards: e.stripeBuffers(data), stripe: -1}, nil
+}
+
+type erasureReader struct {
+	e      *Erasure
+	sh     *ecShards
+	data   []byte
+	shards [][]byte
+	stripe int64 // which stripe data holds
+	off    int64
+}
+
+func (r *erasureReader) Read(p []byte) (int, error) {
+	if r.off >= r.sh.size {
+		return 0, io.EOF
+	}
+	width := int64(len(r.data))
+	s := r.off / width
+	if s != r.stripe {
+		r.stripe = -1
+		if err := r.e.readStripe(r.sh, s, r.shards, false, nil); err != nil {
+			return 0, err
+		}
+		r.stripe = s
+	}
+	start := r.off - s*width
+	n := copy(p, r.data[start:min(width, r.sh.size-s*width)])
+	r.off += int64(n)
+	return n, nil
+}
+
+func (r *erasureReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		offset += r.sh.size
+	}
+	if offset < 0 {
+		return 0, errors.New("storage: seek before the start")
+	}
+	r.off = offset
+	return offset, nil
+}
+
+func (r *erasureReader) Close() error { return r.sh.Close() }
+
+// Stat reads the shards' headers, and gives the time of the write as the blob's ModTime.
+func (e *Erasure) Stat(ctx context.Context, key string) (Info, error) {
+	sh, err := e.openShards(ctx, key)
+	if err != nil {
+		return Info{}, err
+	}
+	sh.Close()
+	return Info{Key: key, Size: sh.size, ModTime: time.Unix(0, sh.written)}, nil
+}
+
+// Delete deletes every shard it can, and fails only if it couldn't delete any.
+func (e *Erasure) Delete(ctx context.Context, key string) error {
+	found := false
+	var lastErr error
+	for _, d := range e.disks {
+		switch err := d.Delete(ctx, key); {
+		case err == nil:
+			found = true
+		case !errors.Is(err, ErrNotFound):
+			lastErr = err
+		}
+	}
+	if found {
+		return nil
+	}
+	if lastErr != nil {
+		return lastErr
+	}
+	return ErrNotFound
+}
+
+// keys returns the keys any disk has a shard of.
+func (e *Erasure) keys(ctx context.Context) ([]string, error) {
+	seen := map[string]bool{}
+	listed := false
+	var lastErr error
+	for _, d := range e.disks {
+		err := d.List(ctx, func(info Info) error {
+			seen[info.Key] = true
+			return nil
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			lastErr = err
+			continue
+		}
+		listed = true
+	}
+	if !listed {
+		return nil, lastErr
+	}
+	keys := make([]string, 0, len(seen))
+	for key := range seen {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// List lists every blob with a shard on any disk. One with too few shards left to read is
+// listed with no size or time, so fsck and gc see it.
+func (e *Erasure) List(ctx context.Context, fn func(Info) error) error {
+	keys, err := e.keys(ctx)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		info, err := e.Stat(ctx, key)
+		if errors.Is(err, ErrNotFound) {
+			continue // deleted since
+		} else if err != nil {
+			info = Info{Key: key}
+		}
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Move renames the shards on every disk.
+func (e *Erasure) Move(ctx context.Context, from, to string) error {
+	moved := false
+	var lastErr error
+	for _, d := range e.disks {
+		switch err := d.Move(ctx, from, to); {
+		case err == nil:
+			moved = true
+		case !errors.Is(err, ErrNotFound):
+			lastErr = err
+		}
+	}
+	if moved {
+		return nil
+	}
+	if lastErr != nil {
+		return lastErr
+	}
+	return ErrNotFound
+}
+
+// Rebuild reads every blob's shards, and writes those that are missing or damaged again from
+// the rest, as after a disk was replaced or was down during writes. progress, when not nil,
+// is told of every blob rebuilt or lost.
+func (e *Erasure) Rebuild(ctx context.Context, progress func(key string, err error)) (RebuildResult, error) {
+	var res RebuildResult
+	keys, err := e.keys(ctx)
+	if err != nil {
+		return res, err
+	}
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		rebuilt, err := e.rebuild(ctx, key)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		res.Checked++
+		if err != nil {
+			res.Lost++
+		} else if rebuilt {
+			res.Rebuilt++
+		} else {
+			continue
+		}
+		if progress != nil {
+			progress(key, err)
+		}
+	}
+	return res, nil
+}
+
+func (e *Erasure) rebuild(ctx context.Context, key string) (bool, error) {
+	sh, err := e.openShards(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	defer sh.Close()
+	all := e.code.k + e.code.m
+	bad := make([]bool, all)
+	for i, f := range sh.files {
+		bad[i] = f == nil
+	}
+	shards := e.stripeBuffers(make([]byte, e.code.k*e.block))
+	for s := int64(0); s < sh.stripes; s++ {
+		if err := e.readStripe(sh, s, shards, true, bad); err != nil {
+			return false, err
+		}
+	}
+	var indexes []int
+	for i, b := range bad {
+		if b {
+			indexes = append(indexes, i)
+			if sh.files[i] != nil {
+				sh.files[i].Close() // it's being replaced
+				sh.files[i] = nil
+			}
+		}
+	}
+	if len(indexes) == 0 {
+		return false, nil
+	}
+
+	pipes, wait := e.shardWriters(ctx, key, indexes)
+	blocks := make([][]byte, len(indexes))
+	for n, i := range indexes {
+		blocks[n] = e.header(i, sh.written)
+	}
+	writeShards(pipes, blocks)
+	for n := range blocks {
+		blocks[n] = make([]byte, e.block+4)
+	}
+	var failed error
+	for s := int64(0); s < sh.stripes && failed == nil; s++ {
+		if failed = e.readStripe(sh, s, shards, true, nil); failed != nil {
+			break
+		}
+		for n, i := range indexes {
+			copy(blocks[n], shards[i])
+			binary.BigEndian.PutUint32(blocks[n][e.block:], crc32.ChecksumIEEE(shards[i]))
+		}
+		writeShards(pipes, blocks)
+	}
+	trailer := binary.BigEndian.AppendUint64(nil, uint64(sh.size))
+	for n := range blocks {
+		blocks[n] = trailer
+	}
+	writeShards(pipes, blocks)
+	for _, pw := range pipes {
+		if pw != nil {
+			pw.CloseWithError(failed)
+		}
+	}
+	var errs []error
+	for i, err := range wait() {
+		errs = append(errs, fmt.Errorf("shard %d: %w", i, err))
+	}
+	if failed != nil {
+		return false, failed
+	}
+	return true, errors.Join(errs...)
+}
+
+// CleanTemp cleans up every disk's leftovers from interrupted writes.
+func (e *Erasure) CleanTemp(cutoff time.Time) (int, error) {
+	n := 0
+	for _, d := range e.disks {
+		m, err := d.CleanTemp(cutoff)
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
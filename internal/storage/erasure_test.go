@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReedSolomon(t *testing.T) {
+	code, err := newRSCode(4, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rng := rand.New(rand.NewSource(1))
+	shards := make([][]byte, 7)
+	for i := range shards {
+		shards[i] = make([]byte, 100)
+		if i < 4 {
+			rng.Read(shards[i])
+		}
+	}
+	code.encode(shards)
+	want := make([][]byte, 7)
+	for i := range shards {
+		want[i] = bytes.Clone(shards[i])
+	}
+	// every way of losing three shards
+	for lost := 0; lost < 1<<7; lost++ {
+		ok := make([]bool, 7)
+		n := 0
+		for i := range ok {
+			if ok[i] = lost&(1<<i) == 0; !ok[i] {
+				n++
+			}
+		}
+		if n > 3 {
+			continue
+		}
+		for i := range ok {
+			if !ok[i] {
+				rng.Read(shards[i])
+			}
+		}
+		if err := code.reconstruct(shards, ok); err != nil {
+			t.Fatal(err)
+		}
+		for i := range shards {
+			if !bytes.Equal(shards[i], want[i]) {
+				t.Fatalf("shard %d wrong after losing %07b", i, lost)
+			}
+		}
+	}
+}
+
+func TestErasure(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	var dirs []string
+	for _, name := range []string{"d0", "d1", "d2", "d3", "p0", "p1"} {
+		dirs = append(dirs, filepath.Join(root, name))
+	}
+	e, err := NewErasure(ErasureOptions{Dirs: dirs, Parity: 2, ShardSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	big := make([]byte, 10*4096+123)
+	rand.New(rand.NewSource(2)).Read(big)
+	for _, content := range [][]byte{{}, big[:4096], big} {
+		if n, err := e.Put(ctx, "blob", bytes.NewReader(content)); err != nil || n != int64(len(content)) {
+			t.Fatalf("Put %d bytes = %d, %v", len(content), n, err)
+		}
+		if info, err := e.Stat(ctx, "blob"); err != nil || info.Size != int64(len(content)) {
+			t.Fatalf("Stat = %+v, %v", info, err)
+		}
+		if got := must(io.ReadAll(must(e.Get(ctx, "blob")))); !bytes.Equal(got, content) {
+			t.Fatalf("read back %d bytes of %d", len(got), len(content))
+		}
+	}
+
+	// lose one disk and damage another
+	if err := os.RemoveAll(dirs[1]); err != nil {
+		t.Fatal(err)
+	}
+	shard := filepath.Join(dirs[2], "bl", "blob")
+	b := must(os.ReadFile(shard))
+	b[erasureHeader+5*(1024+4)+7] ^= 1
+	os.WriteFile(shard, b, 0o600)
+	rc := must(e.Get(ctx, "blob"))
+	if got := must(io.ReadAll(rc)); !bytes.Equal(got, big) {
+		t.Fatal("blob damaged with two shards lost")
+	}
+	rs := rc.(io.ReadSeeker)
+	rs.Seek(-200, io.SeekEnd)
+	if tail := must(io.ReadAll(rs)); !bytes.Equal(tail, big[len(big)-200:]) {
+		t.Fatal("read from the end")
+	}
+	rc.Close()
+
+	// a replaced disk is filled in again
+	e, _ = NewErasure(ErasureOptions{Dirs: dirs, Parity: 2, ShardSize: 1024})
+	res, err := e.Rebuild(ctx, nil)
+	if err != nil || res.Checked != 1 || res.Rebuilt != 1 || res.Lost != 0 {
+		t.Fatalf("Rebuild = %+v, %v", res, err)
+	}
+	if res, _ := e.Rebuild(ctx, nil); res.Rebuilt != 0 {
+		t.Fatalf("second Rebuild = %+v", res)
+	}
+	// the data disks alone hold the blob now
+	os.RemoveAll(dirs[4])
+	os.RemoveAll(dirs[5])
+	if got := must(io.ReadAll(must(e.Get(ctx, "blob")))); !bytes.Equal(got, big) {
+		t.Fatal("rebuilt shards are wrong")
+	}
+
+	// a shard a write missed doesn't count
+	os.MkdirAll(dirs[4], 0o750)
+	os.MkdirAll(dirs[5], 0o750)
+	e, _ = NewErasure(ErasureOptions{Dirs: dirs, Parity: 2, ShardSize: 1024})
+	e.Rebuild(ctx, nil)
+	stale := must(os.ReadFile(filepath.Join(dirs[0], "bl", "blob")))
+	e.Put(ctx, "blob", bytes.NewReader(big[:5000]))
+	os.WriteFile(filepath.Join(dirs[0], "bl", "blob"), stale, 0o600)
+	if got := must(io.ReadAll(must(e.Get(ctx, "blob")))); !bytes.Equal(got, big[:5000]) {
+		t.Fatal("read a stale shard")
+	}
+
+	if err := e.Delete(ctx, "blob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.List(ctx, func(info Info) error { t.Fatalf("listed %s", info.Key); return nil }); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"io"
+	"math/bits"
+)
+
+// gear is FastCDC's table of a random 64-bit value per byte. Chunk boundaries depend on it,
+// so it must never change: chunks stored before would stop matching. It comes from
+// SplitMix64 seeded with 0 rather than a literal table.
+var gear = func() (t [256]uint64) {
+	var x uint64
+	for i := range t {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+		z = (z ^ z>>27) * 0x94d049bb133111eb
+		t[i] = z ^ z>>31
+	}
+	return t
+}()
+
+// chunker splits a stream into content-defined chunks with FastCDC (Xia et al., USENIX ATC
+// 2016): a cut falls where a rolling hash of the last 64 bytes has enough zero bits, so an
+// edit only moves the boundaries around it, and the chunks before and after it stay the same.
+// Chunks are between a quarter and four times avg long; normalized chunking makes a cut
+// harder to find before avg and easier after, which keeps most close to avg.
+type chunker struct {
+	r                    io.Reader
+	min, avg, max        int
+	maskSmall, maskLarge uint64
+	buf                  []byte
+	start, end           int
+	eof                  bool
+}
+
+func newChunker(r io.Reader, avg int) *chunker {
+	b := bits.Len(uint(avg)) - 1 // avg is a power of two
+	mask := func(n int) uint64 { return (uint64(1)<<n - 1) << (64 - n) }
+	return &chunker{r: r, min: avg / 4, avg: avg, max: avg * 4,
+		maskSmall: mask(b + 2), maskLarge: mask(b - 2), buf: make([]byte, avg*8)}
+}
+
+// next returns the next chunk, valid until the following call, or io.EOF after the last.
+func (c *chunker) next() ([]byte, error) {
+	if c.end-c.start < c.max && !c.eof {
+		n := copy(c.buf, c.buf[c.start:c.end])
+		c.start, c.end = 0, n
+		for c.end < len(c.buf) && !c.eof {
+			m, err := c.r.Read(c.buf[c.end:])
+			c.end += m
+			if err == io.EOF {
+				c.eof = true
+			} else if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+	n := c.cut(c.buf[c.start:c.end])
+	chunk := c.buf[c.start : c.start+n]
+	c.start += n
+	return chunk, nil
+}
+
+// cut returns where the chunk at the start of data ends.
+func (c *chunker) cut(data []byte) int {
+	n := len(data)
+	if n <= c.min {
+		return n
+	}
+	n = min(n, c.max)
+	normal := min(n, c.avg)
+	var fp uint64
+	i := c.min
+	for ; i < normal; i++ {
+		fp = fp<<1 + gear[data[i]]
+		if fp&c.maskSmall == 0 {
+			return i
+		}
+	}
+	for ; i < n; i++ {
+		fp = fp<<1 + gear[data[i]]
+		if fp&c.maskLarge == 0 {
+			return i
+		}
+	}
+	return n
+}
@@ -0,0 +1,417 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IPFSOptions says which IPFS node an IPFS backend keeps its blobs on, and where else they
+// are pinned.
+type IPFSOptions struct {
+	// API is the node's RPC API, as Kubo serves it; empty is http://127.0.0.1:5001.
+	API string
+	// Dir is the directory of the node's MFS the blobs are kept in; empty is /filegoblin.
+	Dir string
+
+	// Gateway is an HTTP gateway blobs can be downloaded from by CID, like
+	// "https://ipfs.io" or the node's own; SignedURL needs it.
+	Gateway string
+
+	// PinService is the endpoint of a remote pinning service speaking the IPFS Pinning
+	// Service API, like "https://api.pinata.cloud/psa", that every blob is pinned with as
+	// well, using the bearer token PinToken. Empty pins blobs on the node alone.
+	PinService string
+	PinToken   string
+}
+
+// IPFS keeps blobs on an IPFS node, as files in its MFS, which keeps them from the node's
+// garbage collection the way a pin does, under names that are the keys. Each blob has a
+// CID, so the same content can be fetched from any node or gateway that has it.
+type IPFS struct {
+	opt    IPFSOptions
+	api    *url.URL
+	client *http.Client
+	now    func() time.Time
+}
+
+// NewIPFS returns a backend storing on the node opt names. It doesn't check that the node is
+// up; the first request finds out.
+func NewIPFS(opt IPFSOptions) (*IPFS, error) {
+	if opt.API == "" {
+		opt.API = "http://127.0.0.1:5001"
+	}
+	api, err := url.Parse(strings.TrimRight(opt.API, "/"))
+	if err != nil || api.Host == "" || (api.Scheme != "http" && api.Scheme != "https") {
+		return nil, fmt.Errorf("ipfs: api %q is not an http or https URL", opt.API)
+	}
+	if opt.Dir == "" {
+		opt.Dir = "/filegoblin"
+	}
+	if !path.IsAbs(opt.Dir) {
+		return nil, fmt.Errorf("ipfs: dir %q must be an absolute MFS path", opt.Dir)
+	}
+	opt.Dir = path.Clean(opt.Dir)
+	opt.Gateway = strings.TrimRight(opt.Gateway, "/")
+	opt.PinService = strings.TrimRight(opt.PinService, "/")
+	return &IPFS{opt: opt, api: api, client: &http.Client{}, now: time.Now}, nil
+}
+
+func (p *IPFS) checkKey(key string) error {
+	if key == "" || strings.ContainsAny(key, `/\`) || key == "." || key == ".." {
+		return fmt.Errorf("storage: invalid key %q", key)
+	}
+	return nil
+}
+
+func (p *IPFS) file(key string) string { return p.opt.Dir + "/" + key }
+
+// call makes an RPC call, all of which are POSTs, and returns the response if it succeeded.
+// A missing file is ErrNotFound.
+func (p *IPFS) call(ctx context.Context, cmd string, args url.Values, contentType string, body io.Reader) (*http.Response, error) {
+	u := *p.api
+	u.Path += "/api/v0/" + cmd
+	u.RawQuery = args.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("ipfs: %s: %w", cmd, err)
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	var e struct{ Message string }
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(data, &e) != nil || e.Message == "" {
+		return nil, fmt.Errorf("ipfs: %s: %s", cmd, resp.Status)
+	}
+	if strings.Contains(e.Message, "does not exist") || strings.Contains(e.Message, "not found") {
+		return nil, ErrNotFound
+	}
+	return nil, fmt.Errorf("ipfs: %s: %s", cmd, e.Message)
+}
+
+// callJSON makes an RPC call and decodes its answer into v.
+func (p *IPFS) callJSON(ctx context.Context, cmd string, args url.Values, v any) error {
+	resp, err := p.call(ctx, cmd, args, "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if v == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("ipfs: %s: %w", cmd, err)
+	}
+	return nil
+}
+
+// Put adds the blob to the node, streaming it, and only then links it into the MFS under
+// key, so it isn't visible before it's all there. The write time goes in the file's UnixFS
+// metadata, as MFS keeps no times of its own.
+func (p *IPFS) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	if err := p.checkKey(key); err != nil {
+		return 0, err
+	}
+	pr, pw := io.Pipe()
+	form := multipart.NewWriter(pw)
+	var n int64
+	copied := make(chan error, 1)
+	go func() {
+		part, err := form.CreateFormFile("file", key)
+		if err == nil {
+			n, err = io.Copy(part, r)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		pw.CloseWithError(err)
+		copied <- err
+	}()
+	args := url.Values{"pin": {"false"}, "cid-version": {"1"}, "raw-leaves": {"true"}, "quieter": {"true"},
+		"mtime": {strconv.FormatInt(p.now().Unix(), 10)}}
+	resp, err := p.call(ctx, "add", args, form.FormDataContentType(), pr)
+	// it answers with a line per file added, and an error in the trailer if it gave up
+	var added struct{ Hash string }
+	if err == nil {
+		lines := bufio.NewScanner(resp.Body)
+		for lines.Scan() {
+			json.Unmarshal(lines.Bytes(), &added)
+		}
+		if err = lines.Err(); err != nil {
+			err = fmt.Errorf("ipfs: add: %w", err)
+		} else if msg := resp.Trailer.Get("X-Stream-Error"); msg != "" {
+			err = fmt.Errorf("ipfs: add: %s", msg)
+		} else if added.Hash == "" {
+			err = errors.New("ipfs: add: the node answered with no CID")
+		}
+		resp.Body.Close()
+	}
+	pr.CloseWithError(errReplicaDone) // in case the node stopped reading early
+	// a failed read of r, like an upload over the size limit, is what went wrong
+	if cerr := <-copied; cerr != nil && cerr != errReplicaDone {
+		return 0, cerr
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if err := p.callJSON(ctx, "files/rm", url.Values{"arg": {p.file(key)}, "force": {"true"}}, nil); err != nil && !errors.Is(err, ErrNotFound) {
+		return 0, err
+	}
+	err = p.callJSON(ctx, "files/cp", url.Values{"arg": {"/ipfs/" + added.Hash, p.file(key)}, "parents": {"true"}}, nil)
+	if err != nil {
+		return 0, err
+	}
+	if p.opt.PinService != "" {
+		if err := p.pinRemote(ctx, added.Hash, key); err != nil {
+			p.callJSON(ctx, "files/rm", url.Values{"arg": {p.file(key)}, "force": {"true"}}, nil)
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// ipfsStat is what files/stat says about a file.
+type ipfsStat struct {
+	Hash  string
+	Size  int64
+	Type  string
+	Mtime int64 // seconds; 0 for files added without one
+}
+
+func (p *IPFS) stat(ctx context.Context, key string) (ipfsStat, error) {
+	var st ipfsStat
+	if err := p.checkKey(key); err != nil {
+		return st, err
+	}
+	if err := p.callJSON(ctx, "files/stat", url.Values{"arg": {p.file(key)}}, &st); err != nil {
+		return st, err
+	}
+	if st.Type != "file" {
+		return st, ErrNotFound
+	}
+	return st, nil
+}
+
+// Get returns an io.ReadSeekCloser that reads the blob from where it is read, so a range
+// request only fetches the range.
+func (p *IPFS) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	st, err := p.stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return &rangeReader{size: st.Size, open: func(off int64) (io.ReadCloser, error) {
+		resp, err := p.call(ctx, "files/read", url.Values{"arg": {p.file(key)}, "offset": {strconv.FormatInt(off, 10)}}, "", nil)
+		if err != nil {
+			return nil, err
+		}
+		return resp.Body, nil
+	}}, nil
+}
+
+func (p *IPFS) Stat(ctx context.Context, key string) (Info, error) {
+	st, err := p.stat(ctx, key)
+	if err != nil {
+		return Info{}, err
+	}
+	return st.info(key), nil
+}
+
+func (st ipfsStat) info(key string) Info {
+	info := Info{Key: key, Size: st.Size}
+	if st.Mtime > 0 {
+		info.ModTime = time.Unix(st.Mtime, 0)
+	}
+	return info
+}
+
+// CID returns the CID of the blob under key.
+func (p *IPFS) CID(ctx context.Context, key string) (string, error) {
+	st, err := p.stat(ctx, key)
+	return st.Hash, err
+}
+
+// Delete unlinks the blob from the MFS, and unpins it from the pinning service. The node
+// drops its blocks at its next garbage collection, unless something else still has them.
+func (p *IPFS) Delete(ctx context.Context, key string) error {
+	if _, err := p.stat(ctx, key); err != nil {
+		return err
+	}
+	if err := p.callJSON(ctx, "files/rm", url.Values{"arg": {p.file(key)}}, nil); err != nil {
+		return err
+	}
+	if p.opt.PinService != "" {
+		return p.unpinRemote(ctx, key)
+	}
+	return nil
+}
+
+// Move renames the blob within the MFS, which keeps its CID.
+func (p *IPFS) Move(ctx context.Context, from, to string) error {
+	st, err := p.stat(ctx, from)
+	if err != nil {
+		return err
+	}
+	if err := p.checkKey(to); err != nil {
+		return err
+	}
+	if err := p.callJSON(ctx, "files/rm", url.Values{"arg": {p.file(to)}, "force": {"true"}}, nil); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if err := p.callJSON(ctx, "files/mv", url.Values{"arg": {p.file(from), p.file(to)}}, nil); err != nil {
+		return err
+	}
+	if p.opt.PinService == "" {
+		return nil
+	}
+	// remote pins are found by name when they're removed, so the blob is pinned again
+	// under its new one
+	if err := p.pinRemote(ctx, st.Hash, to); err != nil {
+		return err
+	}
+	return p.unpinRemote(ctx, from)
+}
+
+// List stats every file in the directory, as listing it gives no times.
+func (p *IPFS) List(ctx context.Context, fn func(Info) error) error {
+	var ls struct {
+		Entries []struct {
+			Name string
+			Type int // 0 for files
+		}
+	}
+	err := p.callJSON(ctx, "files/ls", url.Values{"arg": {p.opt.Dir}, "long": {"true"}}, &ls)
+	if errors.Is(err, ErrNotFound) {
+		return nil // nothing was ever stored
+	} else if err != nil {
+		return err
+	}
+	for _, e := range ls.Entries {
+		if e.Type != 0 || p.checkKey(e.Name) != nil {
+			continue
+		}
+		st, err := p.stat(ctx, e.Name)
+		if errors.Is(err, ErrNotFound) {
+			continue // deleted since
+		} else if err != nil {
+			return err
+		}
+		if err := fn(st.info(e.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SignedURL returns the blob's URL on the gateway. It needs no signing, and doesn't expire:
+// anyone with a CID can fetch its content from any gateway. The gateway sets
+// Content-Disposition from the filename in disposition, and Content-Type by itself.
+func (p *IPFS) SignedURL(key string, ttl time.Duration, disposition, contentType string) (string, error) {
+	if p.opt.Gateway == "" {
+		return "", errors.New("ipfs: no gateway to send downloads to")
+	}
+	cid, err := p.CID(context.Background(), key)
+	if err != nil {
+		return "", err
+	}
+	q := url.Values{"download": {"true"}}
+	if _, params, err := mime.ParseMediaType(disposition); err == nil && params["filename"] != "" {
+		q.Set("filename", params["filename"])
+	}
+	return p.opt.Gateway + "/ipfs/" + cid + "?" + q.Encode(), nil
+}
+
+// pinService makes a request to the remote pinning service, and returns the response if
+// its status is one of ok.
+func (p *IPFS) pinService(ctx context.Context, method, path string, body any, ok ...int) (*http.Response, error) {
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, p.opt.PinService+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.opt.PinToken)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("ipfs: pinning service: %w", err)
+	}
+	for _, code := range ok {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+	defer resp.Body.Close()
+	var e struct {
+		Error struct{ Reason, Details string }
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(msg, &e) == nil && e.Error.Reason != "" {
+		return nil, fmt.Errorf("ipfs: pinning service: %s %s: %s %s", method, path, e.Error.Reason, e.Error.Details)
+	}
+	return nil, fmt.Errorf("ipfs: pinning service: %s %s: %s", method, path, resp.Status)
+}
+
+// pinRemote asks the pinning service to pin cid under the name key. It pins in the
+// background, fetching the content from the network, the node among others.
+func (p *IPFS) pinRemote(ctx context.Context, cid, key string) error {
+	resp, err := p.pinService(ctx, http.MethodPost, "/pins", map[string]string{"cid": cid, "name": key},
+		http.StatusAccepted, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// unpinRemote removes the pinning service's pins named key.
+func (p *IPFS) unpinRemote(ctx context.Context, key string) error {
+	q := url.Values{"name": {key}, "match": {"exact"}, "status": {"queued,pinning,pinned,failed"}}
+	resp, err := p.pinService(ctx, http.MethodGet, "/pins?"+q.Encode(), nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	var pins struct {
+		Results []struct {
+			RequestID string `json:"requestid"`
+		}
+	}
+	err = json.NewDecoder(resp.Body).Decode(&pins)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("ipfs: pinning service: %w", err)
+	}
+	for _, pin := range pins.Results {
+		resp, err := p.pinService(ctx, http.MethodDelete, "/pins/"+url.PathEscape(pin.RequestID), nil, http.StatusAccepted, http.StatusOK)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// fakeIPFS is enough of Kubo's RPC API, and of the Pinning Service API, for the backend's
+// tests.
+type fakeIPFS struct {
+	mu     sync.Mutex
+	added  map[string]ipfsStat // by CID
+	data   map[string][]byte   // by CID
+	mfs    map[string]string   // MFS path to CID
+	pins   map[string][2]string
+	nextID int
+}
+
+func (f *fakeIPFS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if pin, ok := strings.CutPrefix(r.URL.Path, "/psa/pins"); ok {
+		f.pinService(w, r, strings.TrimPrefix(pin, "/"))
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "RPC takes POST", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	args := q["arg"]
+	fail := func(msg string) {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]any{"Message": msg, "Code": 0, "Type": "error"})
+	}
+	switch strings.TrimPrefix(r.URL.Path, "/api/v0/") {
+	case "add":
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			fail(err.Error())
+			return
+		}
+		data, _ := io.ReadAll(file)
+		mtime, _ := strconv.ParseInt(q.Get("mtime"), 10, 64)
+		cid := fmt.Sprintf("bafk%x", sha256.Sum256(append(data, q.Get("mtime")...)))[:20]
+		f.added[cid] = ipfsStat{Hash: cid, Size: int64(len(data)), Type: "file", Mtime: mtime}
+		f.data[cid] = data
+		json.NewEncoder(w).Encode(map[string]string{"Name": "blob", "Hash": cid})
+	case "files/cp":
+		if _, ok := f.mfs[args[1]]; ok {
+			fail("directory already has entry by that name")
+			return
+		}
+		f.mfs[args[1]] = strings.TrimPrefix(args[0], "/ipfs/")
+	case "files/mv":
+		cid, ok := f.mfs[args[0]]
+		if !ok {
+			fail("file does not exist")
+			return
+		}
+		delete(f.mfs, args[0])
+		f.mfs[args[1]] = cid
+	case "files/rm":
+		if _, ok := f.mfs[args[0]]; !ok && q.Get("force") != "true" {
+			fail("file does not exist")
+			return
+		}
+		delete(f.mfs, args[0])
+	case "files/stat":
+		cid, ok := f.mfs[args[0]]
+		if !ok {
+			fail("file does not exist")
+			return
+		}
+		json.NewEncoder(w).Encode(f.added[cid])
+	case "files/read":
+		cid, ok := f.mfs[args[0]]
+		if !ok {
+			fail("file does not exist")
+			return
+		}
+		off, _ := strconv.Atoi(q.Get("offset"))
+		w.Write(f.data[cid][off:])
+	case "files/ls":
+		var entries []map[string]any
+		for p := range f.mfs {
+			if path.Dir(p) == args[0] {
+				entries = append(entries, map[string]any{"Name": path.Base(p), "Type": 0})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"Entries": entries})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeIPFS) pinService(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]any{"error": map[string]string{"reason": "UNAUTHORIZED"}})
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		var pin struct{ CID, Name string }
+		json.NewDecoder(r.Body).Decode(&pin)
+		f.nextID++
+		f.pins[strconv.Itoa(f.nextID)] = [2]string{pin.CID, pin.Name}
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodGet:
+		var results []map[string]string
+		for id, pin := range f.pins {
+			if pin[1] == r.URL.Query().Get("name") {
+				results = append(results, map[string]string{"requestid": id})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"count": len(results), "results": results})
+	case http.MethodDelete:
+		delete(f.pins, id)
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func TestIPFS(t *testing.T) {
+	fake := &fakeIPFS{added: map[string]ipfsStat{}, data: map[string][]byte{}, mfs: map[string]string{}, pins: map[string][2]string{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	p, err := NewIPFS(IPFSOptions{API: srv.URL, Gateway: "https://gw.example/", PinService: srv.URL + "/psa", PinToken: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.now = func() time.Time { return time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC) }
+	ctx := context.Background()
+
+	content := strings.Repeat("content addressed ", 1000)
+	if n, err := p.Put(ctx, "doc", strings.NewReader(content)); err != nil || n != int64(len(content)) {
+		t.Fatalf("Put = %d, %v", n, err)
+	}
+	p.Put(ctx, "other", strings.NewReader("x"))
+	cid, err := p.CID(ctx, "doc")
+	if err != nil || fake.mfs["/filegoblin/doc"] != cid || fake.pins["1"] != [2]string{cid, "doc"} {
+		t.Fatalf("CID = %q, %v; MFS %v, pins %v", cid, err, fake.mfs, fake.pins)
+	}
+	if info, err := p.Stat(ctx, "doc"); err != nil || info.Size != int64(len(content)) || !info.ModTime.Equal(p.now()) {
+		t.Fatalf("Stat = %+v, %v", info, err)
+	}
+	rc := must(p.Get(ctx, "doc"))
+	rs := rc.(io.ReadSeeker)
+	rs.Seek(-6, io.SeekEnd)
+	if tail, _ := io.ReadAll(rs); string(tail) != "essed " {
+		t.Fatalf("read %q from the end", tail)
+	}
+	rc.Close()
+
+	if err := p.Move(ctx, "doc", "renamed"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := p.CID(ctx, "renamed"); got != cid || len(fake.pins) != 2 {
+		t.Fatalf("moved blob has CID %q, pins %v", got, fake.pins)
+	}
+	var keys []string
+	p.List(ctx, func(i Info) error { keys = append(keys, i.Key); return nil })
+	if len(keys) != 2 || !strings.Contains(strings.Join(keys, ","), "renamed") {
+		t.Fatalf("listed %q", keys)
+	}
+	u, err := p.SignedURL("renamed", time.Hour, `attachment; filename="a b.txt"`, "text/plain")
+	if err != nil || u != "https://gw.example/ipfs/"+cid+"?download=true&filename=a+b.txt" {
+		t.Fatalf("SignedURL = %s, %v", u, err)
+	}
+
+	if err := p.Delete(ctx, "renamed"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Get(ctx, "renamed"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get after Delete: %v", err)
+	}
+	if err := p.Delete(ctx, "renamed"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("second Delete: %v", err)
+	}
+	if len(fake.pins) != 1 {
+		t.Fatalf("pins left: %v", fake.pins)
+	}
+
+	// a failed read of the upload is what Put reports
+	tooBig := errors.New("too big")
+	if _, err := p.Put(ctx, "broken", io.MultiReader(strings.NewReader("part"), iotest.ErrReader(tooBig))); !errors.Is(err, tooBig) {
+		t.Fatalf("Put of a failing reader: %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory keeps blobs in memory, for tests and demo servers that should start from nothing and
+// forget everything when they stop. Each blob takes its size in RAM, so limits.max_upload_size
+// is worth setting with it.
+type Memory struct {
+	mu    sync.RWMutex
+	blobs map[string]memBlob
+}
+
+type memBlob struct {
+	data []byte
+	mod  time.Time
+}
+
+// NewMemory returns an empty in-memory backend.
+func NewMemory() *Memory {
+	return &Memory{blobs: make(map[string]memBlob)}
+}
+
+// Put reads r to the end before storing, so a blob is never visible half written.
+func (m *Memory) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	if err := memKey(key); err != nil {
+		return 0, err
+	}
+	var buf bytes.Buffer
+	n, err := buf.ReadFrom(ctxReader{ctx, r})
+	if err != nil {
+		return n, err
+	}
+	m.mu.Lock()
+	m.blobs[key] = memBlob{data: buf.Bytes(), mod: time.Now()}
+	m.mu.Unlock()
+	return n, nil
+}
+
+// Get returns a reader over the stored bytes, which Put replaces rather than changes, so
+// readers never see a blob change under them.
+func (m *Memory) Get(_ context.Context, key string) (io.ReadCloser, error) {
+	m.mu.RLock()
+	b, ok := m.blobs[key]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return memReader{bytes.NewReader(b.data)}, nil
+}
+
+type memReader struct{ *bytes.Reader }
+
+func (memReader) Close() error { return nil }
+
+func (m *Memory) Stat(_ context.Context, key string) (Info, error) {
+	m.mu.RLock()
+	b, ok := m.blobs[key]
+	m.mu.RUnlock()
+	if !ok {
+		return Info{}, ErrNotFound
+	}
+	return Info{Key: key, Size: int64(len(b.data)), ModTime: b.mod}, nil
+}
+
+func (m *Memory) Delete(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.blobs[key]; !ok {
+		return ErrNotFound
+	}
+	delete(m.blobs, key)
+	return nil
+}
+
+func (m *Memory) Move(_ context.Context, from, to string) error {
+	if err := memKey(to); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.blobs[from]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(m.blobs, from)
+	m.blobs[to] = b
+	return nil
+}
+
+// List walks a snapshot in key order, so fn may Put and Delete.
+func (m *Memory) List(ctx context.Context, fn func(Info) error) error {
+	m.mu.RLock()
+	infos := make([]Info, 0, len(m.blobs))
+	for key, b := range m.blobs {
+		infos = append(infos, Info{Key: key, Size: int64(len(b.data)), ModTime: b.mod})
+	}
+	m.mu.RUnlock()
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+	for _, info := range infos {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// memKey holds keys to what the other backends take, so tests don't pass here and fail there.
+func memKey(key string) error {
+	if key == "" || strings.ContainsAny(key, `/\`) || key == "." || key == ".." {
+		return fmt.Errorf("storage: invalid key %q", key)
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package storage
+
+import "errors"
+
+// Arithmetic in GF(2^8) over the polynomial x^8+x^4+x^3+x^2+1, the field Reed-Solomon codes
+// for storage usually work in: adding is XOR, and multiplying goes through log tables.
+var (
+	gfExp [510]byte // doubled, so a sum of two logs needs no modulo
+	gfLog [256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i], gfExp[i+255] = byte(x), byte(x)
+		gfLog[x] = byte(i)
+		if x <<= 1; x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfPow(a byte, n int) byte {
+	switch {
+	case n == 0:
+		return 1
+	case a == 0:
+		return 0
+	}
+	return gfExp[int(gfLog[a])*n%255]
+}
+
+// gfMulAdd adds c times src to dst.
+func gfMulAdd(dst, src []byte, c byte) {
+	if c == 0 {
+		return
+	}
+	lc := int(gfLog[c])
+	for i, b := range src {
+		if b != 0 {
+			dst[i] ^= gfExp[lc+int(gfLog[b])]
+		}
+	}
+}
+
+type gfMatrix [][]byte
+
+func newGFMatrix(rows, cols int) gfMatrix {
+	m := make(gfMatrix, rows)
+	for i := range m {
+		m[i] = make([]byte, cols)
+	}
+	return m
+}
+
+func (m gfMatrix) mul(o gfMatrix) gfMatrix {
+	out := newGFMatrix(len(m), len(o[0]))
+	for i := range m {
+		for j := range o[0] {
+			var v byte
+			for k := range o {
+				v ^= gfMul(m[i][k], o[k][j])
+			}
+			out[i][j] = v
+		}
+	}
+	return out
+}
+
+var errSingular = errors.New("storage: singular matrix")
+
+// invert returns m's inverse by Gauss-Jordan elimination.
+func (m gfMatrix) invert() (gfMatrix, error) {
+	n := len(m)
+	work := newGFMatrix(n, 2*n)
+	for i := range m {
+		copy(work[i], m[i])
+		work[i][n+i] = 1
+	}
+	for col := 0; col < n; col++ {
+		pivot := col
+		for pivot < n && work[pivot][col] == 0 {
+			pivot++
+		}
+		if pivot == n {
+			return nil, errSingular
+		}
+		work[col], work[pivot] = work[pivot], work[col]
+		if c := work[col][col]; c != 1 {
+			inv := gfExp[255-int(gfLog[c])]
+			for j := range work[col] {
+				work[col][j] = gfMul(work[col][j], inv)
+			}
+		}
+		for row := 0; row < n; row++ {
+			if row != col && work[row][col] != 0 {
+				gfMulAdd(work[row], work[col], work[row][col])
+			}
+		}
+	}
+	out := make(gfMatrix, n)
+	for i := range work {
+		out[i] = work[i][n:]
+	}
+	return out, nil
+}
+
+// rsCode is a systematic Reed-Solomon code with k data shards and m parity shards: the data
+// shards are the data as it is, and any k of the k+m shards are enough to recover it.
+type rsCode struct {
+	k, m int
+	enc  gfMatrix // (k+m)×k; its top k rows are the identity
+}
+
+func newRSCode(k, m int) (*rsCode, error) {
+	if k < 1 || m < 0 || k+m > 256 {
+		return nil, errors.New("storage: a Reed-Solomon code takes 1 to 256 shards")
+	}
+	// any k rows of a Vandermonde matrix are independent, and stay so when it's multiplied
+	// by the inverse of its top k rows to make the code systematic
+	v := newGFMatrix(k+m, k)
+	for r := range v {
+		for c := range v[r] {
+			v[r][c] = gfPow(byte(r), c)
+		}
+	}
+	top, err := v[:k].invert()
+	if err != nil {
+		return nil, err
+	}
+	return &rsCode{k: k, m: m, enc: v.mul(top)}, nil
+}
+
+// encode computes the parity shards, shards[k:], from the data shards, all the same size.
+func (c *rsCode) encode(shards [][]byte) {
+	for i := c.k; i < c.k+c.m; i++ {
+		clear(shards[i])
+		for j := 0; j < c.k; j++ {
+			gfMulAdd(shards[i], shards[j], c.enc[i][j])
+		}
+	}
+}
+
+// reconstruct fills in the shards that aren't ok from at least k that are.
+func (c *rsCode) reconstruct(shards [][]byte, ok []bool) error {
+	var have []int
+	for i := range shards {
+		if ok[i] {
+			have = append(have, i)
+		}
+	}
+	if len(have) < c.k {
+		return errors.New("storage: too few shards left to recover the data")
+	}
+	have = have[:c.k]
+	sub := make(gfMatrix, c.k)
+	for i, idx := range have {
+		sub[i] = c.enc[idx]
+	}
+	dec, err := sub.invert()
+	if err != nil {
+		return err
+	}
+	for d := 0; d < c.k; d++ {
+		if ok[d] {
+			continue
+		}
+		clear(shards[d])
+		for j, idx := range have {
+			gfMulAdd(shards[d], shards[idx], dec[d][j])
+		}
+	}
+	for i := c.k; i < c.k+c.m; i++ {
+		if ok[i] {
+			continue
+		}
+		clear(shards[i])
+		for j := 0; j < c.k; j++ {
+			gfMulAdd(shards[i], shards[j], c.enc[i][j])
+		}
+	}
+	return nil
+}
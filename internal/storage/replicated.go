@@ -0,0 +1,578 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ReplicaOptions tune Replicated.
+type ReplicaOptions struct {
+	// MinCopies is how many backends must take a blob for Put to succeed, 1 unless set.
+	MinCopies int
+	// State is a file the copies backends missed are kept in across restarts, so Repair
+	// still knows of them. Without one, a restart forgets them.
+	State string
+}
+
+// Replicated writes every blob to all of its backends at once and reads from the first that
+// answers. A backend that fails is skipped by reads for a while, and the writes and deletes it
+// missed are noted for Repair to catch up on once it's back.
+type Replicated struct {
+	backends []Backend
+	opt      ReplicaOptions
+
+	mu     sync.Mutex
+	down   []time.Time      // when each backend may be tried again
+	missed map[string][]int // keys and the backends that missed a change to them
+	locks  map[string]*keyLock
+}
+
+// replicaRetry is how long a failed backend is left out of reads.
+const replicaRetry = 30 * time.Second
+
+// RepairResult is what a Repair pass did.
+type RepairResult struct {
+	Repaired int // keys every backend agrees on again
+	Pending  int // keys still missing from a backend
+}
+
+// NewReplicated returns a backend replicating blobs to backends, the first of which reads
+// try first.
+func NewReplicated(backends []Backend, opt ReplicaOptions) (*Replicated, error) {
+	if len(backends) == 0 {
+		return nil, errors.New("storage: no backends to replicate to")
+	}
+	if opt.MinCopies <= 0 {
+		opt.MinCopies = 1
+	}
+	if opt.MinCopies > len(backends) {
+		return nil, fmt.Errorf("storage: can't keep %d copies on %d backends", opt.MinCopies, len(backends))
+	}
+	r := &Replicated{backends: backends, opt: opt, down: make([]time.Time, len(backends)),
+		missed: map[string][]int{}, locks: map[string]*keyLock{}}
+	if opt.State != "" {
+		b, err := os.ReadFile(opt.State)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		if len(b) > 0 {
+			if err := json.Unmarshal(b, &r.missed); err != nil {
+				return nil, fmt.Errorf("storage: missed copies in %s: %w", opt.State, err)
+			}
+		}
+	}
+	return r, nil
+}
+
+// lock serializes changes to key, so Repair doesn't copy a blob that's being replaced.
+func (r *Replicated) lock(key string) (unlock func()) {
+	r.mu.Lock()
+	l := r.locks[key]
+	if l == nil {
+		l = &keyLock{}
+		r.locks[key] = l
+	}
+	l.n++
+	r.mu.Unlock()
+	l.Lock()
+	return func() {
+		l.Unlock()
+		r.mu.Lock()
+		if l.n--; l.n == 0 {
+			delete(r.locks, key)
+		}
+		r.mu.Unlock()
+	}
+}
+
+// failed marks backend i down and notes that key missed a change on it.
+func (r *Replicated) failed(i int, key string) {
+	r.mu.Lock()
+	r.down[i] = time.Now().Add(replicaRetry)
+	if key != "" && !containsInt(r.missed[key], i) {
+		r.missed[key] = append(r.missed[key], i)
+	}
+	r.mu.Unlock()
+}
+
+// pending reports whether backend i missed a change to key.
+func (r *Replicated) pending(key string, i int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return containsInt(r.missed[key], i)
+}
+
+func containsInt(s []int, v int) bool {
+	for _, x := range s {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// order returns the backends' indexes, those that are up first.
+func (r *Replicated) order() []int {
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var up, down []int
+	for i := range r.backends {
+		if r.down[i].After(now) {
+			down = append(down, i)
+		} else {
+			up = append(up, i)
+		}
+	}
+	return append(up, down...)
+}
+
+// Put streams r to every backend at once. A backend that fails drops out without holding up
+// the others, and is caught up by Repair; Put fails if fewer than MinCopies took the blob.
+func (r *Replicated) Put(ctx context.Context, key string, src io.Reader) (int64, error) {
+	defer r.lock(key)()
+	type result struct {
+		i   int
+		err error
+	}
+	results := make(chan result, len(r.backends))
+	pipes := make([]*io.PipeWriter, len(r.backends))
+	for i, b := range r.backends {
+		pr, pw := io.Pipe()
+		pipes[i] = pw
+		go func() {
+			_, err := b.Put(ctx, key, pr)
+			pr.CloseWithError(errReplicaDone) // unblock writes if Put gave up early
+			results <- result{i, err}
+		}()
+	}
+
+	var n int64
+	buf := make([]byte, 32<<10)
+	var readErr error
+	for live := len(pipes); live > 0; {
+		m, err := src.Read(buf)
+		n += int64(m)
+		for i, pw := range pipes {
+			if pw != nil && m > 0 {
+				if _, werr := pw.Write(buf[:m]); werr != nil {
+					pipes[i] = nil
+					live--
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			readErr = err
+			break
+		}
+	}
+	for _, pw := range pipes {
+		if pw != nil {
+			pw.CloseWithError(readErr) // nil closes cleanly
+		}
+	}
+
+	var errs []error
+	copies := 0
+	for range r.backends {
+		res := <-results
+		if res.err == nil && readErr == nil {
+			copies++
+			continue
+		}
+		if readErr == nil {
+			r.failed(res.i, key)
+			errs = append(errs, fmt.Errorf("replica %d: %w", res.i, res.err))
+		}
+	}
+	if readErr != nil {
+		return n, readErr
+	}
+	if copies < r.opt.MinCopies {
+		return n, fmt.Errorf("storage: stored %d of the %d copies required: %w", copies, r.opt.MinCopies, errors.Join(errs...))
+	}
+	if len(errs) > 0 {
+		r.save()
+	}
+	return n, nil
+}
+
+var errReplicaDone = errors.New("storage: replica stopped reading")
+
+type replicaReadKey struct{}
+
+// ReplicaRead pins the reads of a Replicated backend, whether called directly or by one
+// wrapping it, to one of its backends, Replica, and notes the keys read. A blob can then be
+// checked copy by copy, and Heal can replace the bad copies of everything a good read used,
+// like the chunks of a chunked blob.
+type ReplicaRead struct {
+	Replica int
+
+	mu      sync.Mutex
+	keys    []string
+	skipped bool
+}
+
+// WithReplicaRead returns a context whose reads rr pins.
+func WithReplicaRead(ctx context.Context, rr *ReplicaRead) context.Context {
+	return context.WithValue(ctx, replicaReadKey{}, rr)
+}
+
+// Keys returns the keys read so far.
+func (rr *ReplicaRead) Keys() []string {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	return append([]string(nil), rr.keys...)
+}
+
+// Skipped reports whether a read found the replica down, or behind on a change Repair has
+// yet to catch it up on, and so returned ErrNotFound without trying it.
+func (rr *ReplicaRead) Skipped() bool {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	return rr.skipped
+}
+
+// pinned returns the backends ctx may read key from, in order, noting the read if they're
+// pinned.
+func (r *Replicated) pinned(ctx context.Context, key string, get bool) []int {
+	rr, _ := ctx.Value(replicaReadKey{}).(*ReplicaRead)
+	if rr == nil {
+		return r.order()
+	}
+	i := rr.Replica
+	r.mu.Lock()
+	skip := i < 0 || i >= len(r.backends) || r.down[i].After(time.Now()) || containsInt(r.missed[key], i)
+	r.mu.Unlock()
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	if skip {
+		rr.skipped = true
+		return nil
+	}
+	if get {
+		rr.keys = append(rr.keys, key)
+	}
+	return []int{i}
+}
+
+// Replicas returns how many backends r replicates to, storage's own included.
+func (r *Replicated) Replicas() int { return len(r.backends) }
+
+// Heal replaces the copies of keys on the backends to with those on from, for a blob found
+// to read back wrong from them.
+func (r *Replicated) Heal(ctx context.Context, keys []string, from int, to []int) error {
+	var errs []error
+	for _, key := range keys {
+		unlock := r.lock(key)
+		for _, i := range to {
+			if i == from {
+				continue
+			}
+			if err := copyBlob(ctx, r.backends[from], r.backends[i], key); err != nil {
+				errs = append(errs, fmt.Errorf("replica %d: %s: %w", i, key, err))
+			}
+		}
+		unlock()
+	}
+	return errors.Join(errs...)
+}
+
+// Get reads from the first backend up that has the blob and hasn't missed a change to it.
+func (r *Replicated) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	err := ErrNotFound
+	for _, i := range r.pinned(ctx, key, true) {
+		if r.pending(key, i) {
+			continue // it may still hold what was there before
+		}
+		rc, gerr := r.backends[i].Get(ctx, key)
+		if gerr == nil {
+			return rc, nil
+		}
+		if !errors.Is(gerr, ErrNotFound) {
+			r.failed(i, "")
+		}
+		if errors.Is(err, ErrNotFound) {
+			err = gerr
+		}
+	}
+	return nil, err
+}
+
+func (r *Replicated) Stat(ctx context.Context, key string) (Info, error) {
+	err := ErrNotFound
+	for _, i := range r.pinned(ctx, key, false) {
+		if r.pending(key, i) {
+			continue // it may still hold what was there before
+		}
+		info, serr := r.backends[i].Stat(ctx, key)
+		if serr == nil {
+			return info, nil
+		}
+		if !errors.Is(serr, ErrNotFound) {
+			r.failed(i, "")
+		}
+		if errors.Is(err, ErrNotFound) {
+			err = serr
+		}
+	}
+	return Info{}, err
+}
+
+// Delete deletes key from every backend, noting those that fail for Repair. It returns
+// ErrNotFound only if none of them had it.
+func (r *Replicated) Delete(ctx context.Context, key string) error {
+	defer r.lock(key)()
+	found, missed := false, false
+	var last error
+	for i, b := range r.backends {
+		err := b.Delete(ctx, key)
+		switch {
+		case err == nil:
+			found = true
+		case errors.Is(err, ErrNotFound):
+		default:
+			r.failed(i, key)
+			missed, last = true, err
+		}
+	}
+	if missed {
+		r.save()
+		if !found {
+			return last
+		}
+	}
+	if !found {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// List lists the blobs on every backend up, each once.
+func (r *Replicated) List(ctx context.Context, fn func(Info) error) error {
+	seen := map[string]bool{}
+	listed := false
+	var err error
+	for _, i := range r.order() {
+		var fnErr error
+		lerr := r.backends[i].List(ctx, func(info Info) error {
+			if seen[info.Key] || r.pending(info.Key, i) {
+				return nil
+			}
+			seen[info.Key] = true
+			fnErr = fn(info)
+			return fnErr
+		})
+		if fnErr != nil {
+			return fnErr
+		}
+		if lerr != nil {
+			r.failed(i, "")
+			err = lerr
+			continue
+		}
+		listed = true
+	}
+	if !listed {
+		return err
+	}
+	return nil
+}
+
+// Move moves the blob on every backend, noting those that fail for Repair.
+func (r *Replicated) Move(ctx context.Context, from, to string) error {
+	if from == to {
+		return nil
+	}
+	first, second := from, to
+	if second < first {
+		first, second = second, first
+	}
+	defer r.lock(first)()
+	defer r.lock(second)()
+	moved, missed := 0, false
+	var last error
+	var lacking []int
+	for i, b := range r.backends {
+		err := Move(ctx, b, from, to)
+		switch {
+		case err == nil:
+			moved++
+		case errors.Is(err, ErrNotFound):
+			lacking = append(lacking, i)
+		default:
+			r.failed(i, from)
+			r.failed(i, to)
+			missed, last = true, err
+		}
+	}
+	if moved > 0 {
+		// a backend that missed the blob misses it under its new key too
+		for _, i := range lacking {
+			if r.pending(from, i) {
+				r.failed(i, to)
+				missed = true
+			}
+		}
+	}
+	if missed {
+		r.save()
+	}
+	if moved == 0 {
+		if last != nil {
+			return last
+		}
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Repair catches the backends up on the changes they missed: a blob the others have is
+// copied to them, and one the others don't is deleted from them. progress, when not nil,
+// is told of every key tried.
+func (r *Replicated) Repair(ctx context.Context, progress func(key string, err error)) (RepairResult, error) {
+	r.mu.Lock()
+	keys := make([]string, 0, len(r.missed))
+	for key := range r.missed {
+		keys = append(keys, key)
+	}
+	r.mu.Unlock()
+	sort.Strings(keys)
+
+	var res RepairResult
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			r.save()
+			return res, err
+		}
+		err := r.repair(ctx, key)
+		if err == nil {
+			res.Repaired++
+		}
+		if progress != nil {
+			progress(key, err)
+		}
+	}
+	r.mu.Lock()
+	res.Pending = len(r.missed)
+	r.mu.Unlock()
+	return res, r.save()
+}
+
+func (r *Replicated) repair(ctx context.Context, key string) error {
+	defer r.lock(key)()
+	r.mu.Lock()
+	missed := append([]int(nil), r.missed[key]...)
+	r.mu.Unlock()
+	if len(missed) == 0 {
+		return nil
+	}
+	// the backends that took the last change know what key should hold
+	src := -1
+	for i, b := range r.backends {
+		if containsInt(missed, i) {
+			continue
+		}
+		_, err := b.Stat(ctx, key)
+		if err == nil {
+			src = i
+			break
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		if src == -1 {
+			src = -2 // gone from a backend that's up to date
+		}
+	}
+	if src == -1 {
+		return errors.New("storage: no backend is up to date")
+	}
+
+	var errs []error
+	var left []int
+	for _, i := range missed {
+		var err error
+		if src >= 0 {
+			err = copyBlob(ctx, r.backends[src], r.backends[i], key)
+		} else if err = r.backends[i].Delete(ctx, key); errors.Is(err, ErrNotFound) {
+			err = nil
+		}
+		if err != nil {
+			r.failed(i, "")
+			left = append(left, i)
+			errs = append(errs, fmt.Errorf("replica %d: %w", i, err))
+		}
+	}
+	r.mu.Lock()
+	if len(left) == 0 {
+		delete(r.missed, key)
+	} else {
+		r.missed[key] = left
+	}
+	r.mu.Unlock()
+	return errors.Join(errs...)
+}
+
+// copyBlob copies the blob under key from one backend to another.
+func copyBlob(ctx context.Context, from, to Backend, key string) error {
+	rc, err := from.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	_, err = to.Put(ctx, key, rc)
+	return err
+}
+
+// save writes the missed copies to the State file, through a temporary one so a crash
+// can't leave half of it.
+func (r *Replicated) save() error {
+	if r.opt.State == "" {
+		return nil
+	}
+	r.mu.Lock()
+	b, err := json.Marshal(r.missed)
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(r.opt.State), ".replicas-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), r.opt.State)
+}
+
+// CleanTemp cleans up every backend's leftovers from interrupted writes.
+func (r *Replicated) CleanTemp(cutoff time.Time) (int, error) {
+	n := 0
+	for _, b := range r.backends {
+		if tc, ok := b.(interface{ CleanTemp(time.Time) (int, error) }); ok {
+			m, err := tc.CleanTemp(cutoff)
+			n += m
+			if err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// outage is a backend that fails everything while down.
+type outage struct {
+	*Memory
+	down bool
+}
+
+var errOutage = errors.New("backend is down")
+
+func (o *outage) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	if o.down {
+		return 0, errOutage
+	}
+	return o.Memory.Put(ctx, key, r)
+}
+
+func (o *outage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if o.down {
+		return nil, errOutage
+	}
+	return o.Memory.Get(ctx, key)
+}
+
+func (o *outage) Delete(ctx context.Context, key string) error {
+	if o.down {
+		return errOutage
+	}
+	return o.Memory.Delete(ctx, key)
+}
+
+func TestReplicated(t *testing.T) {
+	ctx := context.Background()
+	a, b := &outage{Memory: NewMemory()}, &outage{Memory: NewMemory()}
+	state := filepath.Join(t.TempDir(), "replicas.json")
+	r, err := NewReplicated([]Backend{a, b}, ReplicaOptions{MinCopies: 1, State: state})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := strings.Repeat("replicated ", 10000)
+	if n, err := r.Put(ctx, "both", strings.NewReader(content)); err != nil || n != int64(len(content)) {
+		t.Fatalf("Put = %d, %v", n, err)
+	}
+	for _, m := range []*outage{a, b} {
+		if got := string(must(io.ReadAll(must(m.Memory.Get(ctx, "both"))))); got != content {
+			t.Fatalf("a replica holds %d bytes of %d", len(got), len(content))
+		}
+	}
+
+	// b misses a write and a delete, and reads fail over to it while a is down
+	b.down = true
+	if _, err := r.Put(ctx, "missed", strings.NewReader("written while b was down")); err != nil {
+		t.Fatal(err)
+	}
+	r.Put(ctx, "deleted", strings.NewReader("soon gone"))
+	b.down = false
+	b.Memory.Put(ctx, "deleted", strings.NewReader("soon gone")) // b had it from before
+	b.down = true
+	if err := r.Delete(ctx, "deleted"); err != nil {
+		t.Fatal(err)
+	}
+	b.down, a.down = false, true
+	if rc, err := r.Get(ctx, "both"); err != nil || string(must(io.ReadAll(rc))) != content {
+		t.Fatalf("Get with the first backend down: %v", err)
+	}
+	if _, err := r.Get(ctx, "deleted"); err == nil {
+		t.Fatalf("read a blob deleted while its replica was down: %v", err)
+	}
+	if _, err := r.Put(ctx, "none", strings.NewReader("x")); err != nil {
+		t.Fatal(err) // one copy is enough
+	}
+	a.down = false
+
+	// a restart remembers what's missing, and Repair catches b up
+	r, _ = NewReplicated([]Backend{a, b}, ReplicaOptions{MinCopies: 2, State: state})
+	res, err := r.Repair(ctx, nil)
+	if err != nil || res.Repaired != 3 || res.Pending != 0 {
+		t.Fatalf("Repair = %+v, %v", res, err)
+	}
+	for key, want := range map[string]string{"missed": "written while b was down", "none": "x"} {
+		for _, m := range []*outage{a, b} {
+			if rc, err := m.Memory.Get(ctx, key); err != nil || string(must(io.ReadAll(rc))) != want {
+				t.Fatalf("%s after repair: %v", key, err)
+			}
+		}
+	}
+	if _, err := b.Memory.Get(ctx, "deleted"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("deleted blob left on b: %v", err)
+	}
+
+	b.down = true
+	if _, err := r.Put(ctx, "short", strings.NewReader("one copy")); err == nil {
+		t.Fatal("Put with fewer copies than required")
+	}
+}
@@ -0,0 +1,453 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Options says where an S3 backend keeps its blobs and how it gets at them.
+type S3Options struct {
+	// Endpoint is the service's base URL, like "https://s3.eu-central-1.amazonaws.com" or
+	// "http://minio:9000"; empty is AWS in Region.
+	Endpoint string
+	Region   string
+	Bucket   string
+	Prefix   string // put in front of every key, like "blobs/", to share a bucket
+
+	AccessKey, SecretKey string
+
+	// PathStyle addresses the bucket in the path, endpoint/bucket/key, as MinIO and most
+	// other S3-compatible services want, rather than as bucket.endpoint/key.
+	PathStyle bool
+
+	// SSE asks the service to encrypt what it stores: "AES256" with keys it manages, or
+	// "aws:kms" with the KMS key SSEKMSKeyID (or the account's default key if empty).
+	SSE         string
+	SSEKMSKeyID string
+
+	// PartSize is how much of a blob is sent per request. Blobs larger than it are sent as a
+	// multipart upload, one part at a time, so memory use stays at one part however big the
+	// blob. 0 is 16 MiB; S3 takes no less than 5 MiB.
+	PartSize int64
+}
+
+// s3MinPart is the smallest part S3 accepts but for the last.
+const s3MinPart = 5 << 20
+
+// S3 keeps blobs as objects in an S3 bucket, on AWS or a service that speaks its API, like
+// MinIO, Wasabi or Ceph, so a server keeps nothing but its metadata on local disk. Requests
+// are signed with AWS Signature Version 4.
+type S3 struct {
+	opt    S3Options
+	base   *url.URL
+	client *http.Client
+	now    func() time.Time
+}
+
+// NewS3 returns a backend storing in the bucket opt names. It doesn't check that the bucket
+// is there; the first request finds out.
+func NewS3(opt S3Options) (*S3, error) {
+	if opt.Bucket == "" || opt.Region == "" {
+		return nil, errors.New("s3: bucket and region are required")
+	}
+	if opt.Endpoint == "" {
+		opt.Endpoint = "https://s3." + opt.Region + ".amazonaws.com"
+	}
+	base, err := url.Parse(strings.TrimRight(opt.Endpoint, "/"))
+	if err != nil || base.Host == "" || (base.Scheme != "http" && base.Scheme != "https") {
+		return nil, fmt.Errorf("s3: endpoint %q is not an http or https URL", opt.Endpoint)
+	}
+	if opt.PartSize == 0 {
+		opt.PartSize = 16 << 20
+	}
+	if opt.PartSize < s3MinPart {
+		return nil, fmt.Errorf("s3: part size %d is below S3's minimum of 5 MiB", opt.PartSize)
+	}
+	return &S3{opt: opt, base: base, client: &http.Client{}, now: time.Now}, nil
+}
+
+// objectURL is the URL of the object for key, or of the bucket for "".
+func (s *S3) objectURL(key string, query url.Values) *url.URL {
+	u := *s.base
+	path := "/"
+	if key != "" {
+		path += s.opt.Prefix + key
+	}
+	if s.opt.PathStyle {
+		path = "/" + s.opt.Bucket + strings.TrimSuffix(path, "/")
+		if key == "" {
+			path += "/"
+		}
+	} else {
+		u.Host = s.opt.Bucket + "." + u.Host
+	}
+	u.Path = strings.TrimRight(u.Path, "/") + path
+	u.RawPath = uriEncode(u.Path, false) // what is signed is what is sent
+	u.RawQuery = query.Encode()
+	return &u
+}
+
+func (s *S3) checkKey(key string) error {
+	if key == "" || strings.ContainsAny(key, `/\`) || key == "." || key == ".." {
+		return fmt.Errorf("storage: invalid key %q", key)
+	}
+	return nil
+}
+
+// do sends a request with body, which is small enough to hold in memory, signed, and returns
+// the response if its status is one of ok.
+func (s *S3) do(ctx context.Context, method string, u *url.URL, header http.Header, body []byte, ok ...int) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	sum := sha256.Sum256(body)
+	s.sign(req, hex.EncodeToString(sum[:]))
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("s3: %s %s: %w", method, u.Path, err)
+	}
+	for _, code := range ok {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound && method != http.MethodPost {
+		return nil, ErrNotFound
+	}
+	var e struct {
+		Code    string
+		Message string
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if xml.Unmarshal(data, &e) == nil && e.Code != "" {
+		return nil, fmt.Errorf("s3: %s %s: %s: %s", method, u.Path, e.Code, e.Message)
+	}
+	return nil, fmt.Errorf("s3: %s %s: %s", method, u.Path, resp.Status)
+}
+
+// sign adds the headers of AWS Signature Version 4 to req, whose body hashes to payload.
+func (s *S3) sign(req *http.Request, payload string) {
+	SignV4(req, payload, s.opt.Region, "s3", s.opt.AccessKey, s.opt.SecretKey, s.now())
+}
+
+// SignV4 adds the headers of AWS Signature Version 4 for service in region to req, whose
+// body hashes to payload, the SHA-256 in hex. Headers already on req are signed too, a
+// session's X-Amz-Security-Token included. Other AWS APIs than S3, like KMS, use it as well.
+func SignV4(req *http.Request, payload, region, service, accessKey, secretKey string, now time.Time) {
+	now = now.UTC()
+	stamp, day := now.Format("20060102T150405Z"), now.Format("20060102")
+	req.Header.Set("X-Amz-Date", stamp)
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+
+	names := []string{"host"}
+	canonical := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		name := strings.ToLower(k)
+		names = append(names, name)
+		canonical[name] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	sort.Strings(names)
+	var headers strings.Builder
+	for _, name := range names {
+		headers.WriteString(name + ":" + canonical[name] + "\n")
+	}
+	signed := strings.Join(names, ";")
+	request := strings.Join([]string{req.Method, req.URL.EscapedPath(), canonicalQuery(req.URL.Query()),
+		headers.String(), signed, payload}, "\n")
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	hash := sha256.Sum256([]byte(request))
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), day)
+	for _, part := range []string{region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+
+		", SignedHeaders="+signed+", Signature="+hex.EncodeToString(hmacSHA256(key, toSign)))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
+
+// canonicalQuery is q sorted and encoded as Signature Version 4 wants it.
+func canonicalQuery(q url.Values) string {
+	var pairs []string
+	for k, vs := range q {
+		for _, v := range vs {
+			pairs = append(pairs, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode percent-encodes s the way Signature Version 4 does: everything but unreserved
+// characters, and slashes too unless in a path.
+func uriEncode(s string, slash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~", c) >= 0 || c == '/' && !slash {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// sseHeader has the server-side encryption headers for a new object.
+func (s *S3) sseHeader() http.Header {
+	h := http.Header{}
+	if s.opt.SSE != "" {
+		h.Set("X-Amz-Server-Side-Encryption", s.opt.SSE)
+		if s.opt.SSE == "aws:kms" && s.opt.SSEKMSKeyID != "" {
+			h.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", s.opt.SSEKMSKeyID)
+		}
+	}
+	return h
+}
+
+// Put sends a blob of up to PartSize in one request and a larger one as a multipart upload,
+// which S3 only makes visible once it is completed, and aborts if anything fails.
+func (s *S3) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	if err := s.checkKey(key); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, s.opt.PartSize)
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		resp, err := s.do(ctx, http.MethodPut, s.objectURL(key, nil), s.sseHeader(), buf[:n], http.StatusOK)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return int64(n), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := s.do(ctx, http.MethodPost, s.objectURL(key, url.Values{"uploads": {""}}), s.sseHeader(), nil, http.StatusOK)
+	if err != nil {
+		return 0, err
+	}
+	var created struct{ UploadId string }
+	err = xml.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	if err != nil || created.UploadId == "" {
+		return 0, fmt.Errorf("s3: start multipart upload of %s: %v", key, err)
+	}
+	total, err := s.putParts(ctx, key, created.UploadId, buf, n, r)
+	if err != nil {
+		// with a fresh context: the request's may be what was cancelled
+		actx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if resp, aerr := s.do(actx, http.MethodDelete, s.objectURL(key, url.Values{"uploadId": {created.UploadId}}), nil, nil, http.StatusNoContent); aerr == nil {
+			resp.Body.Close()
+		}
+		return total, err
+	}
+	return total, nil
+}
+
+type completedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+// putParts sends the parts of a multipart upload, the first n bytes of buf first, and
+// completes it.
+func (s *S3) putParts(ctx context.Context, key, uploadID string, buf []byte, n int, r io.Reader) (int64, error) {
+	var parts []completedPart
+	var total int64
+	for num := 1; n > 0; num++ {
+		if num > 10000 {
+			return total, fmt.Errorf("s3: %s needs more than 10000 parts; raise the part size", key)
+		}
+		q := url.Values{"partNumber": {strconv.Itoa(num)}, "uploadId": {uploadID}}
+		resp, err := s.do(ctx, http.MethodPut, s.objectURL(key, q), nil, buf[:n], http.StatusOK)
+		if err != nil {
+			return total, err
+		}
+		resp.Body.Close()
+		parts = append(parts, completedPart{num, resp.Header.Get("ETag")})
+		total += int64(n)
+		var err2 error
+		n, err2 = io.ReadFull(r, buf)
+		if err2 != nil && err2 != io.EOF && err2 != io.ErrUnexpectedEOF {
+			return total, err2
+		}
+	}
+	body, _ := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	resp, err := s.do(ctx, http.MethodPost, s.objectURL(key, url.Values{"uploadId": {uploadID}}), nil, body, http.StatusOK)
+	if err != nil {
+		return total, err
+	}
+	defer resp.Body.Close()
+	// S3 can answer 200 and report a failure in the body, having kept the connection alive
+	var done struct {
+		XMLName xml.Name
+		Code    string
+		Message string
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&done); err != nil {
+		return total, fmt.Errorf("s3: complete multipart upload of %s: %w", key, err)
+	}
+	if done.XMLName.Local == "Error" {
+		return total, fmt.Errorf("s3: complete multipart upload of %s: %s: %s", key, done.Code, done.Message)
+	}
+	return total, nil
+}
+
+// Get returns an io.ReadSeekCloser that fetches the object from where it is read, so a range
+// request only downloads the range.
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	info, err := s.Stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return &rangeReader{size: info.Size, open: func(off int64) (io.ReadCloser, error) {
+		h := http.Header{"Range": {"bytes=" + strconv.FormatInt(off, 10) + "-"}}
+		resp, err := s.do(ctx, http.MethodGet, s.objectURL(key, nil), h, nil, http.StatusPartialContent, http.StatusOK)
+		if err != nil {
+			return nil, err
+		}
+		return resp.Body, nil
+	}}, nil
+}
+
+func (s *S3) Stat(ctx context.Context, key string) (Info, error) {
+	if err := s.checkKey(key); err != nil {
+		return Info{}, err
+	}
+	resp, err := s.do(ctx, http.MethodHead, s.objectURL(key, nil), nil, nil, http.StatusOK)
+	if err != nil {
+		return Info{}, err
+	}
+	resp.Body.Close()
+	mod, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return Info{Key: key, Size: resp.ContentLength, ModTime: mod}, nil
+}
+
+// Delete removes the object. S3 doesn't say whether there was one, so it is looked up first.
+func (s *S3) Delete(ctx context.Context, key string) error {
+	if _, err := s.Stat(ctx, key); err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodDelete, s.objectURL(key, nil), nil, nil, http.StatusNoContent, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3) List(ctx context.Context, fn func(Info) error) error {
+	q := url.Values{"list-type": {"2"}, "prefix": {s.opt.Prefix}}
+	for {
+		resp, err := s.do(ctx, http.MethodGet, s.objectURL("", q), nil, nil, http.StatusOK)
+		if err != nil {
+			return err
+		}
+		var page struct {
+			Contents []struct {
+				Key          string
+				Size         int64
+				LastModified time.Time
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("s3: list: %w", err)
+		}
+		for _, c := range page.Contents {
+			key := strings.TrimPrefix(c.Key, s.opt.Prefix)
+			if s.checkKey(key) != nil {
+				continue // something else keeps objects under the prefix too
+			}
+			if err := fn(Info{Key: key, Size: c.Size, ModTime: c.LastModified}); err != nil {
+				return err
+			}
+		}
+		if !page.IsTruncated {
+			return nil
+		}
+		q.Set("continuation-token", page.NextContinuationToken)
+	}
+}
+
+// CleanTemp aborts multipart uploads started before cutoff that were never finished, as a
+// crash in the middle of a Put leaves them: S3 keeps, and bills for, their parts until then.
+func (s *S3) CleanTemp(cutoff time.Time) (int, error) {
+	ctx := context.Background()
+	q := url.Values{"uploads": {""}, "prefix": {s.opt.Prefix}}
+	n := 0
+	for {
+		resp, err := s.do(ctx, http.MethodGet, s.objectURL("", q), nil, nil, http.StatusOK)
+		if err != nil {
+			return n, err
+		}
+		var page struct {
+			Upload []struct {
+				Key       string
+				UploadId  string
+				Initiated time.Time
+			}
+			IsTruncated        bool
+			NextKeyMarker      string
+			NextUploadIdMarker string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return n, fmt.Errorf("s3: list uploads: %w", err)
+		}
+		for _, u := range page.Upload {
+			key := strings.TrimPrefix(u.Key, s.opt.Prefix)
+			if s.checkKey(key) != nil || u.Initiated.After(cutoff) {
+				continue
+			}
+			resp, err := s.do(ctx, http.MethodDelete, s.objectURL(key, url.Values{"uploadId": {u.UploadId}}), nil, nil,
+				http.StatusNoContent, http.StatusNotFound)
+			if err != nil {
+				return n, err
+			}
+			resp.Body.Close()
+			n++
+		}
+		if !page.IsTruncated {
+			return n, nil
+		}
+		q.Set("key-marker", page.NextKeyMarker)
+		q.Set("upload-id-marker", page.NextUploadIdMarker)
+	}
+}
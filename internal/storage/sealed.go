@@ -0,0 +1,473 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Sealed encrypts blobs before they reach another backend with AES-256-GCM, under a key of
+// their own that is stored with them, wrapped by the master key. Whoever gets at the
+// backend, a stolen disk or a leaked bucket, sees ciphertext; the master key never leaves
+// the server's config.
+//
+// A sealed blob is a header followed by the content in 64KiB chunks, each sealed on its own,
+// so blobs of any size are encrypted and decrypted in constant memory and a range is read
+// without decrypting what comes before it:
+//
+//	header:  "FGS2" | chunk size (uint32 BE) | master key ID (8 bytes)
+//	         | wrapping nonce (12 bytes) | AES-256-GCM(data key) (48 bytes)
+//	chunks:  AES-256-GCM(chunk), nonce = counter (uint64 BE) | 0 0 0 | last (1 byte)
+//
+// The data key is sealed with the first 16 bytes of the header as additional data, and the
+// chunks with the first 8, the part that never changes: they are bound to the blob by its
+// data key, not by how that key is wrapped. The "last" flag stops a blob being cut short at
+// a chunk boundary. Blobs stored before encryption was turned on are read as they are, and
+// so are "FGS1" blobs, whose chunks were sealed with the whole header.
+//
+// The master key can be rotated: new blobs are sealed with the current key, and blobs sealed
+// with an old one stay readable as long as it's kept, until Rekey wraps their data keys
+// again.
+type Sealed struct {
+	inner   Backend
+	current [8]byte                 // the ID of the key new blobs are sealed with
+	masters map[[8]byte]cipher.AEAD // by key ID
+}
+
+const (
+	sealedMagic  = "FGS2"
+	sealedV1     = "FGS1" // chunks sealed with the whole header, wrapped key and all
+	sealedHeader = 4 + 4 + 8 + 12 + 32 + 16
+	sealedChunk  = 64 << 10
+	sealedTag    = 16
+)
+
+// ErrSealed means a sealed blob was modified, cut short, or sealed under another master key.
+var ErrSealed = errors.New("storage: cannot decrypt blob (corrupted, or sealed with another key)")
+
+// NewSealed returns a backend encrypting blobs into inner under the 32-byte master key, and
+// decrypting blobs sealed with any of the old keys too.
+func NewSealed(inner Backend, key []byte, old ...[]byte) (*Sealed, error) {
+	s := &Sealed{inner: inner, masters: map[[8]byte]cipher.AEAD{}}
+	for i, k := range append([][]byte{key}, old...) {
+		if len(k) != 32 {
+			return nil, errors.New("storage: encryption keys must be 32 bytes")
+		}
+		aead, err := newGCM(k)
+		if err != nil {
+			return nil, err
+		}
+		// identifies the master key in headers without giving anything away about it
+		var id [8]byte
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte("filegoblin storage key id"))
+		copy(id[:], mac.Sum(nil))
+		if i == 0 {
+			s.current = id
+		}
+		s.masters[id] = aead
+	}
+	return s, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Put encrypts r as it is stored, and returns the number of plaintext bytes.
+func (s *Sealed) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return 0, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return 0, err
+	}
+	header, err := s.wrap(dataKey)
+	if err != nil {
+		return 0, err
+	}
+	enc := &sealReader{r: bufio.NewReaderSize(r, sealedChunk), aead: aead, ad: chunkAD(header), out: header}
+	if _, err := s.inner.Put(ctx, key, enc); err != nil {
+		return enc.plain, err
+	}
+	return enc.plain, nil
+}
+
+// wrap returns the header of a blob sealed with dataKey, which it seals with the current
+// master key.
+func (s *Sealed) wrap(dataKey []byte) ([]byte, error) {
+	header := make([]byte, 16+12, sealedHeader)
+	copy(header, sealedMagic)
+	binary.BigEndian.PutUint32(header[4:8], sealedChunk)
+	copy(header[8:16], s.current[:])
+	if _, err := rand.Read(header[16:28]); err != nil {
+		return nil, err
+	}
+	return s.masters[s.current].Seal(header, header[16:28], dataKey, header[:16]), nil
+}
+
+// unwrap returns the data key sealed in header, a whole one of either version.
+func (s *Sealed) unwrap(header []byte) ([]byte, error) {
+	master, ok := s.masters[[8]byte(header[8:16])]
+	if binary.BigEndian.Uint32(header[4:8]) != sealedChunk || !ok {
+		return nil, ErrSealed
+	}
+	dataKey, err := master.Open(nil, header[16:28], header[28:], header[:16])
+	if err != nil {
+		return nil, ErrSealed
+	}
+	return dataKey, nil
+}
+
+// chunkAD returns the additional data the chunks of the blob with header are sealed with.
+func chunkAD(header []byte) []byte {
+	if string(header[:4]) == sealedV1 {
+		return header
+	}
+	return header[:8]
+}
+
+// sealReader encrypts what it reads from r, header first.
+type sealReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	ad      []byte
+	counter uint64
+	out     []byte // sealed, not yet read
+	plain   int64
+	done    bool
+}
+
+func (e *sealReader) Read(p []byte) (int, error) {
+	for len(e.out) == 0 {
+		if e.done {
+			return 0, io.EOF
+		}
+		chunk := make([]byte, sealedChunk, sealedChunk+sealedTag)
+		n, err := io.ReadFull(e.r, chunk)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			e.done = true
+		} else if err != nil {
+			return 0, err
+		} else if _, err := e.r.Peek(1); err == io.EOF {
+			e.done = true // a full chunk with nothing after it is the last
+		} else if err != nil {
+			return 0, err
+		}
+		e.plain += int64(n)
+		e.out = e.aead.Seal(chunk[:0], sealedNonce(e.counter, e.done), chunk[:n], e.ad)
+		e.counter++
+	}
+	n := copy(p, e.out)
+	e.out = e.out[n:]
+	return n, nil
+}
+
+func sealedNonce(counter uint64, last bool) []byte {
+	n := make([]byte, 12)
+	binary.BigEndian.PutUint64(n, counter)
+	if last {
+		n[11] = 1
+	}
+	return n
+}
+
+// isSealed reports whether a blob starting with b, at least 4 bytes of it, is sealed.
+func isSealed(b []byte) bool {
+	return string(b[:4]) == sealedMagic || string(b[:4]) == sealedV1
+}
+
+// plainSize is how much content a sealed blob of size bytes holds.
+func plainSize(size int64) int64 {
+	n := size - sealedHeader
+	chunks := max((n+sealedChunk+sealedTag-1)/(sealedChunk+sealedTag), 1)
+	return n - chunks*sealedTag
+}
+
+// open reads the header at the start of rc, returning the data key's AEAD, or nil for a
+// blob that isn't sealed.
+func (s *Sealed) open(rc io.Reader, size int64) (cipher.AEAD, []byte, error) {
+	if size < sealedHeader+sealedTag {
+		return nil, nil, nil
+	}
+	header := make([]byte, sealedHeader)
+	if _, err := io.ReadFull(rc, header); err != nil {
+		return nil, nil, err
+	}
+	if !isSealed(header) {
+		return nil, header, nil
+	}
+	dataKey, err := s.unwrap(header)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := newGCM(dataKey)
+	return aead, header, err
+}
+
+// Get returns an io.ReadSeekCloser when inner does, decrypting chunks as they are read.
+func (s *Sealed) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	rc, err := s.inner.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	var size int64
+	seeker, seekable := rc.(io.Seeker)
+	if seekable {
+		size, err = seeker.Seek(0, io.SeekEnd)
+		if err == nil {
+			_, err = seeker.Seek(0, io.SeekStart)
+		}
+	} else {
+		var info Info
+		info, err = s.inner.Stat(ctx, key)
+		size = info.Size
+	}
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	aead, header, err := s.open(rc, size)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	if aead == nil {
+		if seekable {
+			_, err = seeker.Seek(0, io.SeekStart)
+			return rc, err
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(header), rc), rc}, nil
+	}
+	return &openReader{r: rc, aead: aead, ad: chunkAD(header), size: plainSize(size)}, nil
+}
+
+// openReader decrypts a sealed blob from r, which is positioned after the header.
+type openReader struct {
+	r     io.ReadCloser
+	aead  cipher.AEAD
+	ad    []byte
+	size  int64
+	off   int64
+	next  int64  // the chunk r is positioned at
+	chunk int64  // the chunk in plain
+	plain []byte // nil when no chunk is decrypted
+}
+
+func (d *openReader) Read(p []byte) (int, error) {
+	if d.off >= d.size {
+		return 0, io.EOF
+	}
+	i := d.off / sealedChunk
+	if d.plain == nil || d.chunk != i {
+		if d.next != i {
+			s, ok := d.r.(io.Seeker)
+			if !ok {
+				return 0, errors.New("storage: seek in a blob that can't seek")
+			}
+			if _, err := s.Seek(sealedHeader+i*(sealedChunk+sealedTag), io.SeekStart); err != nil {
+				return 0, err
+			}
+		}
+		last := i == (d.size-1)/sealedChunk
+		buf := make([]byte, sealedChunk+sealedTag)
+		n, err := io.ReadFull(d.r, buf)
+		if err != nil && !(last && err == io.ErrUnexpectedEOF) {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return 0, ErrSealed
+			}
+			return 0, err
+		}
+		d.next = i + 1
+		plain, err := d.aead.Open(buf[:0], sealedNonce(uint64(i), last), buf[:n], d.ad)
+		if err != nil {
+			return 0, ErrSealed
+		}
+		d.chunk, d.plain = i, plain
+	}
+	n := copy(p, d.plain[d.off-d.chunk*sealedChunk:])
+	d.off += int64(n)
+	return n, nil
+}
+
+func (d *openReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += d.off
+	case io.SeekEnd:
+		offset += d.size
+	}
+	if offset < 0 {
+		return 0, errors.New("storage: seek before the start")
+	}
+	d.off = offset
+	return offset, nil
+}
+
+func (d *openReader) Close() error { return d.r.Close() }
+
+// Stat reports a sealed blob's plaintext size, which takes reading its header.
+func (s *Sealed) Stat(ctx context.Context, key string) (Info, error) {
+	info, err := s.inner.Stat(ctx, key)
+	if err != nil || info.Size < sealedHeader+sealedTag {
+		return info, err
+	}
+	rc, err := s.inner.Get(ctx, key)
+	if err != nil {
+		return Info{}, err
+	}
+	defer rc.Close()
+	magic := make([]byte, len(sealedMagic))
+	if _, err := io.ReadFull(rc, magic); err != nil {
+		return Info{}, fmt.Errorf("storage: read %s: %w", key, err)
+	}
+	if isSealed(magic) {
+		info.Size = plainSize(info.Size)
+	}
+	return info, nil
+}
+
+func (s *Sealed) Delete(ctx context.Context, key string) error {
+	return s.inner.Delete(ctx, key)
+}
+
+// List lists blobs with the sizes they take in inner, sealed or not.
+func (s *Sealed) List(ctx context.Context, fn func(Info) error) error {
+	return s.inner.List(ctx, fn)
+}
+
+// Move moves the sealed blob as it is: its key doesn't go into the encryption.
+func (s *Sealed) Move(ctx context.Context, from, to string) error {
+	return Move(ctx, s.inner, from, to)
+}
+
+// Rekey seals the blob under key again with the current master key, if it's sealed with an
+// old one or not at all, and reports whether it had to; with dryRun it only reports. Only
+// the header of a sealed blob changes: its data key is wrapped again and its chunks are
+// copied across as they are. A blob that isn't sealed, or an "FGS1" blob, whose chunks are
+// bound to its old header, is read and sealed again whole. Either way it's stored again
+// under the same key, so readers see one or the other.
+func (s *Sealed) Rekey(ctx context.Context, key string, dryRun bool) (bool, error) {
+	rc, err := s.inner.Get(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	header := make([]byte, sealedHeader)
+	n, err := io.ReadFull(rc, header)
+	if err == nil && isSealed(header) && [8]byte(header[8:16]) == s.current {
+		rc.Close()
+		return false, nil
+	}
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		rc.Close()
+		return false, err
+	}
+	if dryRun {
+		rc.Close()
+		return true, nil
+	}
+	if n == sealedHeader && string(header[:4]) == sealedMagic {
+		defer rc.Close()
+		dataKey, err := s.unwrap(header)
+		if err != nil {
+			return false, err
+		}
+		if header, err = s.wrap(dataKey); err != nil {
+			return false, err
+		}
+		_, err = s.inner.Put(ctx, key, io.MultiReader(bytes.NewReader(header), rc))
+		return err == nil, err
+	}
+	rc.Close()
+	rc, err = s.Get(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	defer rc.Close()
+	_, err = s.Put(ctx, key, rc)
+	return err == nil, err
+}
+
+// RekeyResult counts the blobs RekeyAll went through.
+type RekeyResult struct {
+	Current int  `json:"current"` // sealed with the current key already
+	Rekeyed int  `json:"rekeyed"` // sealed again, or would be on a dry run
+	Pending int  `json:"pending"` // left for later by the limit
+	Failed  int  `json:"failed"`
+	DryRun  bool `json:"dry_run"`
+}
+
+// RekeyAll seals every blob in b that isn't sealed with the current master key again, up to
+// limit of them if it's above 0, telling progress about each. b is the Sealed backend or
+// one wrapping it, like Chunked; chunks and manifests are blobs of their own to it. Blobs
+// are rekeyed one at a time, each stored again whole, so the server can keep running.
+func RekeyAll(ctx context.Context, b Backend, dryRun bool, limit int, progress func(key string, err error)) (RekeyResult, error) {
+	res := RekeyResult{DryRun: dryRun}
+	s, ok := b.(*Sealed)
+	for !ok {
+		w, wraps := b.(interface{ Unwrap() Backend })
+		if !wraps {
+			return res, errors.New("storage: blobs aren't encrypted at rest")
+		}
+		b = w.Unwrap()
+		s, ok = b.(*Sealed)
+	}
+	var keys []string
+	if err := s.inner.List(ctx, func(info Info) error {
+		keys = append(keys, info.Key)
+		return nil
+	}); err != nil {
+		return res, err
+	}
+	for _, key := range keys {
+		full := limit > 0 && res.Rekeyed >= limit
+		rekeyed, err := s.Rekey(ctx, key, dryRun || full)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			continue // deleted since the listing
+		case err != nil:
+			res.Failed++
+		case !rekeyed:
+			res.Current++
+			continue
+		case full:
+			res.Pending++
+			continue
+		default:
+			res.Rekeyed++
+		}
+		if progress != nil {
+			progress(key, err)
+		}
+	}
+	return res, nil
+}
+
+// Unwrap returns the backend the blobs are sealed into.
+func (s *Sealed) Unwrap() Backend { return s.inner }
+
+func (s *Sealed) CleanTemp(cutoff time.Time) (int, error) {
+	if tc, ok := s.inner.(interface{ CleanTemp(time.Time) (int, error) }); ok {
+		return tc.CleanTemp(cutoff)
+	}
+	return 0, nil
+}
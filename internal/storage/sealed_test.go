@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestSealed(t *testing.T) {
+	mem := NewMemory()
+	key := bytes.Repeat([]byte{7}, 32)
+	s, err := NewSealed(mem, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	big := make([]byte, 3*sealedChunk+100)
+	rand.New(rand.NewSource(1)).Read(big)
+	for _, content := range [][]byte{big, big[:sealedChunk], {}} {
+		if n, err := s.Put(ctx, "blob", bytes.NewReader(content)); err != nil || n != int64(len(content)) {
+			t.Fatalf("Put %d bytes = %d, %v", len(content), n, err)
+		}
+		stored, _ := mem.Stat(ctx, "blob")
+		if info, err := s.Stat(ctx, "blob"); err != nil || info.Size != int64(len(content)) {
+			t.Fatalf("Stat of %d bytes, %d stored = %+v, %v", len(content), stored.Size, info, err)
+		}
+		rc, err := s.Get(ctx, "blob")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := io.ReadAll(rc); err != nil || !bytes.Equal(got, content) {
+			t.Fatalf("read back %d bytes of %d: %v", len(got), len(content), err)
+		}
+		rc.Close()
+	}
+	s.Put(ctx, "blob", bytes.NewReader(big))
+	if rc, _ := mem.Get(ctx, "blob"); bytes.Contains(must(io.ReadAll(rc)), big[:64]) {
+		t.Fatal("plaintext stored")
+	}
+
+	rc, _ := s.Get(ctx, "blob")
+	rs := rc.(io.ReadSeeker)
+	rs.Seek(2*sealedChunk-10, io.SeekStart)
+	mid := make([]byte, 20)
+	if _, err := io.ReadFull(rs, mid); err != nil || !bytes.Equal(mid, big[2*sealedChunk-10:2*sealedChunk+10]) {
+		t.Fatalf("read across a chunk boundary: %v", err)
+	}
+	rs.Seek(-5, io.SeekEnd)
+	if tail, _ := io.ReadAll(rs); !bytes.Equal(tail, big[len(big)-5:]) {
+		t.Fatalf("read %q from the end", tail)
+	}
+	rc.Close()
+
+	// cutting off the last chunk, or flipping a bit, is caught
+	stored := must(io.ReadAll(must(mem.Get(ctx, "blob"))))
+	mem.Put(ctx, "short", bytes.NewReader(stored[:len(stored)-100-sealedTag]))
+	flipped := bytes.Clone(stored)
+	flipped[len(flipped)/2] ^= 1
+	mem.Put(ctx, "flipped", bytes.NewReader(flipped))
+	for _, key := range []string{"short", "flipped"} {
+		rc, err := s.Get(ctx, key)
+		if err == nil {
+			_, err = io.ReadAll(rc)
+		}
+		if !errors.Is(err, ErrSealed) {
+			t.Fatalf("reading %s: %v", key, err)
+		}
+	}
+	other, _ := NewSealed(mem, bytes.Repeat([]byte{8}, 32))
+	if _, err := other.Get(ctx, "blob"); !errors.Is(err, ErrSealed) {
+		t.Fatalf("Get with another key: %v", err)
+	}
+
+	// blobs stored before encryption was on are read as they are
+	mem.Put(ctx, "plain", strings.NewReader("stored in the clear"))
+	if rc, err := s.Get(ctx, "plain"); err != nil || string(must(io.ReadAll(rc))) != "stored in the clear" {
+		t.Fatalf("Get of a plain blob: %v", err)
+	}
+	if info, err := s.Stat(ctx, "plain"); err != nil || info.Size != 19 {
+		t.Fatalf("Stat of a plain blob = %+v, %v", info, err)
+	}
+}
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+func TestSealedRotation(t *testing.T) {
+	mem := NewMemory()
+	oldKey, newKey := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+	old, _ := NewSealed(mem, oldKey)
+	ctx := context.Background()
+	for _, key := range []string{"a", "b", "c"} {
+		old.Put(ctx, key, strings.NewReader("content of "+key))
+	}
+	mem.Put(ctx, "plain", strings.NewReader("from before encryption"))
+	sealedBefore := map[string][]byte{"a": must(io.ReadAll(must(mem.Get(ctx, "a"))))}
+
+	s, err := NewSealed(mem, newKey, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Put(ctx, "d", strings.NewReader("content of d"))
+	if rc, err := s.Get(ctx, "a"); err != nil || string(must(io.ReadAll(rc))) != "content of a" {
+		t.Fatalf("Get of a blob under the old key: %v", err)
+	}
+	if res, err := RekeyAll(ctx, s, true, 0, nil); err != nil || res.Rekeyed != 4 || res.Current != 1 {
+		t.Fatalf("dry run = %+v, %v", res, err)
+	}
+	// through a wrapper, a batch at a time
+	c, _ := NewChunked(s, ChunkOptions{})
+	var done []string
+	res, err := RekeyAll(ctx, c, false, 3, func(key string, err error) { done = append(done, key) })
+	if err != nil || res.Rekeyed != 3 || res.Pending != 1 || strings.Join(done, ",") != "a,b,c" {
+		t.Fatalf("rekey = %+v, %v, did %q", res, err, done)
+	}
+	if res, _ := RekeyAll(ctx, s, false, 0, nil); res.Rekeyed != 1 || res.Current != 4 {
+		t.Fatalf("second rekey = %+v", res)
+	}
+	// only the header changed: the chunks were sealed with the part of it that stays
+	if a, b := must(io.ReadAll(must(mem.Get(ctx, "a")))), sealedBefore["a"]; bytes.Equal(a, b) || !bytes.Equal(a[sealedHeader:], b[sealedHeader:]) {
+		t.Fatal("rekey did more than wrap the data key again")
+	}
+
+	current, _ := NewSealed(mem, newKey)
+	for _, key := range []string{"a", "b", "c", "d", "plain"} {
+		rc, err := current.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("Get %s without the old key: %v", key, err)
+		}
+		if got := string(must(io.ReadAll(rc))); got != "content of "+key && key != "plain" {
+			t.Fatalf("%s reads %q", key, got)
+		}
+	}
+	if _, err := RekeyAll(ctx, mem, true, 0, nil); err == nil {
+		t.Fatal("RekeyAll on a store without encryption")
+	}
+}
+
+// TestSealedV1 reads and rekeys a blob in the "FGS1" format, whose chunks were sealed with the
+// whole header.
+func TestSealedV1(t *testing.T) {
+	mem := NewMemory()
+	oldKey, newKey := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+	old, _ := NewSealed(mem, oldKey)
+	ctx := context.Background()
+
+	dataKey := bytes.Repeat([]byte{3}, 32)
+	header := must(old.wrap(dataKey))
+	copy(header, sealedV1)
+	header = old.masters[old.current].Seal(header[:28], header[16:28], dataKey, header[:16])
+	aead := must(newGCM(dataKey))
+	blob := aead.Seal(bytes.Clone(header), sealedNonce(0, true), []byte("sealed long ago"), header)
+	mem.Put(ctx, "v1", bytes.NewReader(blob))
+
+	s, _ := NewSealed(mem, newKey, oldKey)
+	if info, err := s.Stat(ctx, "v1"); err != nil || info.Size != 15 {
+		t.Fatalf("Stat = %+v, %v", info, err)
+	}
+	if rc, err := s.Get(ctx, "v1"); err != nil || string(must(io.ReadAll(rc))) != "sealed long ago" {
+		t.Fatalf("Get: %v", err)
+	}
+	if rekeyed, err := s.Rekey(ctx, "v1", false); !rekeyed || err != nil {
+		t.Fatalf("Rekey = %v, %v", rekeyed, err)
+	}
+	if stored := must(io.ReadAll(must(mem.Get(ctx, "v1")))); string(stored[:4]) != sealedMagic {
+		t.Fatalf("rekeyed into %q", stored[:4])
+	}
+	current, _ := NewSealed(mem, newKey)
+	if rc, err := current.Get(ctx, "v1"); err != nil || string(must(io.ReadAll(rc))) != "sealed long ago" {
+		t.Fatalf("Get after rekeying: %v", err)
+	}
+}
@@ -0,0 +1,745 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SFTPOptions says which host an SFTP backend keeps its blobs on and how it logs in.
+type SFTPOptions struct {
+	Host         string // "nas.lan", or "user@nas.lan"
+	Port         int    // 0 is ssh's default, usually 22
+	IdentityFile string // the private key to log in with; empty leaves it to ssh's config
+	Dir          string // where on the host the blobs go, created if missing
+
+	// SSHCommand is the ssh client run for each connection, "ssh" when empty. It runs in
+	// batch mode, so the host has to be in known_hosts and the key must not need a passphrase.
+	SSHCommand string
+
+	Conns   int // idle connections kept open for the next request; 0 is 4
+	Retries int // how often a request that lost its connection is tried again on a new one
+}
+
+// SFTP keeps blobs on another host over SFTP, laid out like Disk keeps them, for small
+// deployments with a NAS or a box that can already be reached over SSH. Rather than carry an
+// SSH implementation it runs the system's ssh client with the sftp subsystem and speaks
+// SFTP version 3 over its stdin and stdout, so ssh_config, agents and known_hosts work as
+// they do from the shell.
+type SFTP struct {
+	opt  SFTPOptions
+	dial func() (io.ReadWriteCloser, error)
+
+	mu   sync.Mutex
+	idle []*sftpConn
+}
+
+// NewSFTP returns a backend storing under opt.Dir on opt.Host. It doesn't connect; the first
+// request does.
+func NewSFTP(opt SFTPOptions) (*SFTP, error) {
+	if opt.Host == "" || opt.Dir == "" {
+		return nil, errors.New("sftp: host and dir are required")
+	}
+	if opt.SSHCommand == "" {
+		opt.SSHCommand = "ssh"
+	}
+	if opt.Conns == 0 {
+		opt.Conns = 4
+	}
+	s := &SFTP{opt: opt}
+	s.dial = s.ssh
+	return s, nil
+}
+
+// ssh starts an ssh client with the sftp subsystem.
+func (s *SFTP) ssh() (io.ReadWriteCloser, error) {
+	args := []string{"-o", "BatchMode=yes", "-o", "ServerAliveInterval=30"}
+	if s.opt.Port != 0 {
+		args = append(args, "-p", strconv.Itoa(s.opt.Port))
+	}
+	if s.opt.IdentityFile != "" {
+		args = append(args, "-i", s.opt.IdentityFile)
+	}
+	cmd := exec.Command(s.opt.SSHCommand, append(args, "-s", s.opt.Host, "sftp")...)
+	p := &sshPipe{cmd: cmd}
+	cmd.Stderr = &p.stderr
+	var err error
+	if p.WriteCloser, err = cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	if p.Reader, err = cmd.StdoutPipe(); err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// sshPipe is a running ssh client, written to through its stdin and read from through its stdout.
+type sshPipe struct {
+	io.WriteCloser
+	io.Reader
+	cmd    *exec.Cmd
+	stderr tailBuffer
+}
+
+func (p *sshPipe) Close() error {
+	p.WriteCloser.Close()
+	p.cmd.Process.Kill()
+	p.cmd.Wait()
+	return nil
+}
+
+// Error has what ssh said on its way out, like "Permission denied (publickey)".
+func (p *sshPipe) Error() string {
+	return strings.TrimSpace(p.stderr.String())
+}
+
+// tailBuffer keeps the last 1KiB written to it.
+type tailBuffer struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > 1024 {
+		b.buf = b.buf[len(b.buf)-1024:]
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.buf)
+}
+
+// connError is a request failing because the connection did, which trying again on a new
+// connection may fix.
+type connError struct{ err error }
+
+func (e *connError) Error() string { return "sftp: " + e.err.Error() }
+func (e *connError) Unwrap() error { return e.err }
+
+// conn takes an idle connection, or opens one.
+func (s *SFTP) conn() (*sftpConn, error) {
+	s.mu.Lock()
+	if n := len(s.idle); n > 0 {
+		c := s.idle[n-1]
+		s.idle = s.idle[:n-1]
+		s.mu.Unlock()
+		return c, nil
+	}
+	s.mu.Unlock()
+	rw, err := s.dial()
+	if err != nil {
+		return nil, &connError{fmt.Errorf("connect to %s: %w", s.opt.Host, err)}
+	}
+	c, err := newSFTPConn(rw)
+	if err != nil {
+		rw.Close()
+		if said, ok := rw.(interface{ Error() string }); ok && said.Error() != "" {
+			err = fmt.Errorf("%w: %s", err, said.Error())
+		}
+		return nil, &connError{fmt.Errorf("connect to %s: %w", s.opt.Host, err)}
+	}
+	return c, nil
+}
+
+// release hands c back for reuse, unless it's broken or enough are idle already, or a
+// reader still holds it.
+func (s *SFTP) release(c *sftpConn) {
+	if c.held {
+		return
+	}
+	s.mu.Lock()
+	if !c.broken && len(s.idle) < s.opt.Conns {
+		s.idle = append(s.idle, c)
+		c = nil
+	}
+	s.mu.Unlock()
+	if c != nil {
+		c.rw.Close()
+	}
+}
+
+// Close closes the idle connections.
+func (s *SFTP) Close() error {
+	s.mu.Lock()
+	idle := s.idle
+	s.idle = nil
+	s.mu.Unlock()
+	for _, c := range idle {
+		c.rw.Close()
+	}
+	return nil
+}
+
+// with runs fn on a connection, and again on a new one, up to Retries times, if the
+// connection fails under it. An idle connection the host has since dropped fails that way.
+func (s *SFTP) with(ctx context.Context, fn func(c *sftpConn) error) error {
+	for try := 0; ; try++ {
+		c, err := s.conn()
+		if err == nil {
+			err = fn(c)
+			s.release(c)
+		}
+		var ce *connError
+		if !errors.As(err, &ce) || try >= s.opt.Retries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(time.Duration(try) * 500 * time.Millisecond): // the first retry right away
+		}
+	}
+}
+
+// path is where key's blob is on the host, fanned out like Disk does it.
+func (s *SFTP) path(key string) (string, error) {
+	if key == "" || strings.ContainsAny(key, `/\`) || key == "." || key == ".." || key == "tmp" {
+		return "", fmt.Errorf("storage: invalid key %q", key)
+	}
+	shard := key
+	if len(shard) > 2 {
+		shard = shard[:2]
+	}
+	return path.Join(s.opt.Dir, shard, key), nil
+}
+
+// Put writes to a temp file in Dir/tmp and renames it into place. Only getting the temp file
+// open is retried: after that, r has been read from.
+func (s *SFTP) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	dst, err := s.path(key)
+	if err != nil {
+		return 0, err
+	}
+	var rnd [8]byte
+	rand.Read(rnd[:])
+	tmp := path.Join(s.opt.Dir, "tmp", "put-"+hex.EncodeToString(rnd[:]))
+	var n int64
+	err = s.with(ctx, func(c *sftpConn) error {
+		h, err := c.open(tmp, fxfWrite|fxfCreat|fxfExcl)
+		if errors.Is(err, ErrNotFound) {
+			if err = c.mkdirAll(path.Dir(tmp)); err == nil {
+				h, err = c.open(tmp, fxfWrite|fxfCreat|fxfExcl)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if n, err = c.write(h, ctxReader{ctx, r}); err == nil {
+			err = c.close(h)
+		}
+		if err == nil {
+			if err = c.rename(tmp, dst); errors.Is(err, ErrNotFound) {
+				if err = c.mkdirAll(path.Dir(dst)); err == nil {
+					err = c.rename(tmp, dst)
+				}
+			}
+		}
+		if err != nil {
+			if !c.broken {
+				c.remove(tmp)
+			}
+			return fmt.Errorf("sftp: put %s: %v", key, err) // not a connError any more
+		}
+		return nil
+	})
+	return n, err
+}
+
+// Get returns an io.ReadSeekCloser reading the blob on a connection of its own until closed.
+func (s *SFTP) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	info, err := s.Stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	p, _ := s.path(key)
+	return &rangeReader{size: info.Size, open: func(off int64) (io.ReadCloser, error) {
+		var body *sftpBody
+		err := s.with(ctx, func(c *sftpConn) error {
+			h, err := c.open(p, fxfRead)
+			if err != nil {
+				return err
+			}
+			c.held = true
+			body = &sftpBody{s: s, c: c, h: h, off: off}
+			return nil
+		})
+		return body, err
+	}}, nil
+}
+
+func (s *SFTP) Stat(ctx context.Context, key string) (Info, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return Info{}, err
+	}
+	var info Info
+	err = s.with(ctx, func(c *sftpConn) error {
+		reply, m, err := c.request(fxpStat, p)
+		if err != nil {
+			return err
+		}
+		size, mod, _ := m.attrs()
+		if m.err() != nil || reply != fxpAttrs {
+			return c.fail(errors.New("bad reply to stat"))
+		}
+		info = Info{Key: key, Size: size, ModTime: mod}
+		return nil
+	})
+	return info, err
+}
+
+func (s *SFTP) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	return s.with(ctx, func(c *sftpConn) error { return c.remove(p) })
+}
+
+func (s *SFTP) Move(ctx context.Context, from, to string) error {
+	src, err := s.path(from)
+	if err != nil {
+		return err
+	}
+	dst, err := s.path(to)
+	if err != nil {
+		return err
+	}
+	return s.with(ctx, func(c *sftpConn) error {
+		err := c.rename(src, dst)
+		if errors.Is(err, ErrNotFound) {
+			// the blob, or the shard it goes in, isn't there
+			if _, _, serr := c.request(fxpStat, src); serr != nil {
+				return serr
+			}
+			if err = c.mkdirAll(path.Dir(dst)); err == nil {
+				err = c.rename(src, dst)
+			}
+		}
+		return err
+	})
+}
+
+// List lists a shard directory at a time, each with a retry of its own.
+func (s *SFTP) List(ctx context.Context, fn func(Info) error) error {
+	shards, err := s.readDir(ctx, s.opt.Dir)
+	if errors.Is(err, ErrNotFound) {
+		return nil // nothing stored yet
+	}
+	if err != nil {
+		return err
+	}
+	for _, shard := range shards {
+		if !shard.dir || shard.name == "tmp" {
+			continue
+		}
+		blobs, err := s.readDir(ctx, path.Join(s.opt.Dir, shard.name))
+		if err != nil {
+			return err
+		}
+		for _, b := range blobs {
+			if b.dir {
+				continue
+			}
+			if err := fn(Info{Key: b.name, Size: b.size, ModTime: b.mod}); err != nil {
+				return err
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type sftpEntry struct {
+	name string
+	dir  bool
+	size int64
+	mod  time.Time
+}
+
+func (s *SFTP) readDir(ctx context.Context, dir string) ([]sftpEntry, error) {
+	var entries []sftpEntry
+	err := s.with(ctx, func(c *sftpConn) error {
+		entries = entries[:0]
+		h, err := c.openDir(dir)
+		if err != nil {
+			return err
+		}
+		defer c.close(h)
+		for {
+			reply, m, err := c.request(fxpReaddir, h)
+			if err != nil {
+				return err
+			}
+			if reply == fxpStatus { // EOF
+				return nil
+			}
+			if reply != fxpName {
+				return c.fail(errors.New("bad reply to readdir"))
+			}
+			for n := m.u32(); n > 0 && m.err() == nil; n-- {
+				name := string(m.str())
+				m.str() // the ls -l line
+				size, mod, mode := m.attrs()
+				if name != "." && name != ".." {
+					entries = append(entries, sftpEntry{name, mode&0o170000 == 0o040000, size, mod})
+				}
+			}
+			if m.err() != nil {
+				return c.fail(errors.New("bad reply to readdir"))
+			}
+		}
+	})
+	return entries, err
+}
+
+// sftpBody reads a remote file from off on, holding its connection until closed.
+type sftpBody struct {
+	s   *SFTP
+	c   *sftpConn
+	h   string
+	off int64
+}
+
+func (b *sftpBody) Read(p []byte) (int, error) {
+	reply, m, err := b.c.request(fxpRead, b.h, uint64(b.off), uint32(min(len(p), sftpChunk)))
+	if err != nil {
+		return 0, err
+	}
+	if reply == fxpStatus {
+		return 0, io.EOF
+	}
+	data := m.str()
+	if err := m.err(); err != nil || reply != fxpData {
+		return 0, b.c.fail(errors.New("bad reply to read"))
+	}
+	n := copy(p, data)
+	b.off += int64(n)
+	return n, nil
+}
+
+func (b *sftpBody) Close() error {
+	err := b.c.close(b.h)
+	b.c.held = false
+	b.s.release(b.c)
+	return err
+}
+
+// SFTP version 3, from draft-ietf-secsh-filexfer-02, the version OpenSSH speaks.
+const (
+	fxpInit     = 1
+	fxpVersion  = 2
+	fxpOpen     = 3
+	fxpClose    = 4
+	fxpRead     = 5
+	fxpWrite    = 6
+	fxpOpendir  = 11
+	fxpReaddir  = 12
+	fxpRemove   = 13
+	fxpMkdir    = 14
+	fxpStat     = 17
+	fxpRename   = 18
+	fxpExtended = 200
+	fxpStatus   = 101
+	fxpHandle   = 102
+	fxpData     = 103
+	fxpName     = 104
+	fxpAttrs    = 105
+
+	fxOK         = 0
+	fxEOF        = 1
+	fxNoSuchFile = 2
+
+	fxfRead  = 0x01
+	fxfWrite = 0x02
+	fxfCreat = 0x08
+	fxfExcl  = 0x20
+
+	attrSize        = 0x01
+	attrUIDGID      = 0x02
+	attrPermissions = 0x04
+	attrACModTime   = 0x08
+	attrExtended    = 0x80000000
+)
+
+// sftpChunk is the most read or written per request; every server takes 32KiB.
+const sftpChunk = 32 << 10
+
+// posixRename is OpenSSH's extension for a rename that replaces the target, which plain
+// SFTP v3 rename refuses to.
+const posixRename = "posix-rename@openssh.com"
+
+// sftpConn is one SFTP session. Requests on it go one at a time.
+type sftpConn struct {
+	rw          io.ReadWriteCloser
+	id          uint32
+	posixRename bool
+	broken      bool
+	held        bool // by an sftpBody, until it's closed
+}
+
+func newSFTPConn(rw io.ReadWriteCloser) (*sftpConn, error) {
+	c := &sftpConn{rw: rw}
+	if err := c.send(fxpInit, uint32(3)); err != nil {
+		return nil, err
+	}
+	typ, m, err := c.recv()
+	if err != nil {
+		return nil, err
+	}
+	if typ != fxpVersion {
+		return nil, fmt.Errorf("unexpected reply %d to init", typ)
+	}
+	m.u32() // the version the server picked
+	for len(m.b) > 0 && m.err() == nil {
+		name, _ := string(m.str()), m.str()
+		c.posixRename = c.posixRename || name == posixRename
+	}
+	return c, nil
+}
+
+// fail marks c broken, so it isn't reused, and returns err as a connError.
+func (c *sftpConn) fail(err error) error {
+	c.broken = true
+	return &connError{err}
+}
+
+func (c *sftpConn) send(typ byte, args ...any) error {
+	b := []byte{0, 0, 0, 0, typ}
+	for _, a := range args {
+		switch a := a.(type) {
+		case uint32:
+			b = binary.BigEndian.AppendUint32(b, a)
+		case uint64:
+			b = binary.BigEndian.AppendUint64(b, a)
+		case string:
+			b = binary.BigEndian.AppendUint32(b, uint32(len(a)))
+			b = append(b, a...)
+		case []byte:
+			b = binary.BigEndian.AppendUint32(b, uint32(len(a)))
+			b = append(b, a...)
+		default:
+			panic(fmt.Sprintf("sftp: can't send a %T", a))
+		}
+	}
+	binary.BigEndian.PutUint32(b, uint32(len(b)-4))
+	if _, err := c.rw.Write(b); err != nil {
+		return c.fail(err)
+	}
+	return nil
+}
+
+func (c *sftpConn) recv() (byte, *sftpMsg, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(c.rw, hdr[:]); err != nil {
+		return 0, nil, c.fail(err)
+	}
+	n := binary.BigEndian.Uint32(hdr[:4])
+	if n < 1 || n > 1<<18 {
+		return 0, nil, c.fail(fmt.Errorf("reply of %d bytes", n))
+	}
+	b := make([]byte, n-1)
+	if _, err := io.ReadFull(c.rw, b); err != nil {
+		return 0, nil, c.fail(err)
+	}
+	return hdr[4], &sftpMsg{b: b}, nil
+}
+
+// request sends a request and returns its reply, less the ID. A status other than OK or EOF
+// is an error, no such file being ErrNotFound.
+func (c *sftpConn) request(typ byte, args ...any) (byte, *sftpMsg, error) {
+	if c.broken {
+		return 0, nil, &connError{errors.New("connection lost")}
+	}
+	c.id++
+	if err := c.send(typ, append([]any{c.id}, args...)...); err != nil {
+		return 0, nil, err
+	}
+	reply, m, err := c.recv()
+	if err != nil {
+		return 0, nil, err
+	}
+	if id := m.u32(); id != c.id {
+		return 0, nil, c.fail(fmt.Errorf("reply to request %d, not %d", id, c.id))
+	}
+	if reply == fxpStatus {
+		switch code, msg := m.u32(), m.str(); code {
+		case fxOK, fxEOF:
+		case fxNoSuchFile:
+			return 0, nil, ErrNotFound
+		default:
+			return 0, nil, fmt.Errorf("sftp: %s (status %d)", msg, code)
+		}
+	}
+	return reply, m, nil
+}
+
+func (c *sftpConn) open(p string, flags uint32) (string, error) {
+	reply, m, err := c.request(fxpOpen, p, flags, uint32(attrPermissions), uint32(0o640))
+	if err != nil {
+		return "", err
+	}
+	h := m.str()
+	if err := m.err(); err != nil || reply != fxpHandle {
+		return "", c.fail(errors.New("bad reply to open"))
+	}
+	return string(h), nil
+}
+
+func (c *sftpConn) openDir(p string) (string, error) {
+	reply, m, err := c.request(fxpOpendir, p)
+	if err != nil {
+		return "", err
+	}
+	h := m.str()
+	if err := m.err(); err != nil || reply != fxpHandle {
+		return "", c.fail(errors.New("bad reply to opendir"))
+	}
+	return string(h), nil
+}
+
+func (c *sftpConn) close(h string) error {
+	_, _, err := c.request(fxpClose, h)
+	return err
+}
+
+// write writes everything read from r to the file open as h.
+func (c *sftpConn) write(h string, r io.Reader) (int64, error) {
+	buf := make([]byte, sftpChunk)
+	var off int64
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if _, _, werr := c.request(fxpWrite, h, uint64(off), buf[:n]); werr != nil {
+				return off, werr
+			}
+			off += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return off, nil
+		}
+		if err != nil {
+			return off, err
+		}
+	}
+}
+
+func (c *sftpConn) rename(from, to string) error {
+	if c.posixRename {
+		_, _, err := c.request(fxpExtended, posixRename, from, to)
+		return err
+	}
+	// plain SFTP renames only onto nothing; blobs are written once, so this rarely matters
+	if err := c.remove(to); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	_, _, err := c.request(fxpRename, from, to)
+	return err
+}
+
+func (c *sftpConn) remove(p string) error {
+	_, _, err := c.request(fxpRemove, p)
+	return err
+}
+
+// mkdirAll makes dir and whatever it's in that's missing.
+func (c *sftpConn) mkdirAll(dir string) error {
+	_, _, err := c.request(fxpMkdir, dir, uint32(attrPermissions), uint32(0o750))
+	if errors.Is(err, ErrNotFound) && path.Dir(dir) != dir {
+		if err = c.mkdirAll(path.Dir(dir)); err == nil {
+			_, _, err = c.request(fxpMkdir, dir, uint32(attrPermissions), uint32(0o750))
+		}
+	}
+	if err != nil {
+		// someone else made it first, or it was there and the failure was something else
+		if _, _, serr := c.request(fxpStat, dir); serr == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// sftpMsg is what's left to read of a reply.
+type sftpMsg struct {
+	b     []byte
+	short bool
+}
+
+func (m *sftpMsg) err() error {
+	if m.short {
+		return errors.New("reply cut short")
+	}
+	return nil
+}
+
+func (m *sftpMsg) u32() uint32 {
+	if len(m.b) < 4 {
+		m.short, m.b = true, nil
+		return 0
+	}
+	v := binary.BigEndian.Uint32(m.b)
+	m.b = m.b[4:]
+	return v
+}
+
+func (m *sftpMsg) u64() uint64 {
+	return uint64(m.u32())<<32 | uint64(m.u32())
+}
+
+func (m *sftpMsg) str() []byte {
+	n := m.u32()
+	if uint32(len(m.b)) < n {
+		m.short, m.b = true, nil
+		return nil
+	}
+	s := m.b[:n]
+	m.b = m.b[n:]
+	return s
+}
+
+// attrs reads a file's attributes, returning those the backend has a use for.
+func (m *sftpMsg) attrs() (size int64, mod time.Time, mode uint32) {
+	flags := m.u32()
+	if flags&attrSize != 0 {
+		size = int64(m.u64())
+	}
+	if flags&attrUIDGID != 0 {
+		m.u32()
+		m.u32()
+	}
+	if flags&attrPermissions != 0 {
+		mode = m.u32()
+	}
+	if flags&attrACModTime != 0 {
+		m.u32()
+		mod = time.Unix(int64(m.u32()), 0)
+	}
+	if flags&attrExtended != 0 {
+		for n := m.u32(); n > 0 && !m.short; n-- {
+			m.str()
+			m.str()
+		}
+	}
+	return size, mod, mode
+}
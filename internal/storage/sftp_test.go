@@ -0,0 +1,229 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeSFTP serves enough of SFTP v3, on files under root, for the backend's tests.
+type fakeSFTP struct {
+	root string
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func (f *fakeSFTP) dial() (io.ReadWriteCloser, error) {
+	client, server := net.Pipe()
+	f.mu.Lock()
+	f.conns = append(f.conns, server)
+	f.mu.Unlock()
+	go f.serve(server)
+	return client, nil
+}
+
+// drop hangs up on every connection, like a host restarting.
+func (f *fakeSFTP) drop() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, c := range f.conns {
+		c.Close()
+	}
+	f.conns = nil
+}
+
+func (f *fakeSFTP) serve(conn net.Conn) {
+	defer conn.Close()
+	files := map[string]*os.File{}
+	dirs := map[string][]os.DirEntry{}
+	for {
+		var hdr [5]byte
+		if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+			return
+		}
+		b := make([]byte, binary.BigEndian.Uint32(hdr[:4])-1)
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return
+		}
+		m := &sftpMsg{b: b}
+		reply := func(typ byte, args ...any) {
+			c := &sftpConn{rw: conn}
+			c.send(typ, args...)
+		}
+		if hdr[4] == fxpInit {
+			reply(fxpVersion, uint32(3), posixRename, "1")
+			continue
+		}
+		id := m.u32()
+		status := func(err error) {
+			code := uint32(fxOK)
+			if errors.Is(err, os.ErrNotExist) {
+				code = fxNoSuchFile
+			} else if err != nil {
+				code = 4
+			}
+			reply(fxpStatus, id, code, "status", "")
+		}
+		local := func(p []byte) string { return filepath.Join(f.root, filepath.FromSlash(string(p))) }
+		attrs := func(fi os.FileInfo) []any {
+			mode := uint32(0o100644)
+			if fi.IsDir() {
+				mode = 0o040755
+			}
+			return []any{uint32(attrSize | attrPermissions | attrACModTime), uint64(fi.Size()), mode, uint32(0), uint32(fi.ModTime().Unix())}
+		}
+		switch hdr[4] {
+		case fxpOpen:
+			name, flags := local(m.str()), m.u32()
+			var fl *os.File
+			var err error
+			if flags&fxfWrite != 0 {
+				fl, err = os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640)
+			} else {
+				fl, err = os.Open(name)
+			}
+			if err != nil {
+				status(err)
+				continue
+			}
+			h := strconv.Itoa(len(files) + len(dirs))
+			files[h] = fl
+			reply(fxpHandle, id, h)
+		case fxpOpendir:
+			entries, err := os.ReadDir(local(m.str()))
+			if err != nil {
+				status(err)
+				continue
+			}
+			h := strconv.Itoa(len(files) + len(dirs))
+			dirs[h] = entries
+			reply(fxpHandle, id, h)
+		case fxpReaddir:
+			h := string(m.str())
+			entries := dirs[h]
+			if len(entries) == 0 {
+				reply(fxpStatus, id, uint32(fxEOF), "eof", "")
+				continue
+			}
+			n := min(2, len(entries)) // a few at a time, to read through
+			args := []any{id, uint32(n)}
+			for _, e := range entries[:n] {
+				fi, _ := e.Info()
+				args = append(append(args, e.Name(), "ls -l line"), attrs(fi)...)
+			}
+			dirs[h] = entries[n:]
+			reply(fxpName, args...)
+		case fxpClose:
+			h := string(m.str())
+			if fl := files[h]; fl != nil {
+				fl.Close()
+			}
+			delete(files, h)
+			delete(dirs, h)
+			status(nil)
+		case fxpRead:
+			fl, off, n := files[string(m.str())], m.u64(), m.u32()
+			buf := make([]byte, n)
+			n2, err := fl.ReadAt(buf, int64(off))
+			if n2 == 0 && err == io.EOF {
+				reply(fxpStatus, id, uint32(fxEOF), "eof", "")
+				continue
+			}
+			reply(fxpData, id, buf[:n2])
+		case fxpWrite:
+			fl, off, data := files[string(m.str())], m.u64(), m.str()
+			_, err := fl.WriteAt(data, int64(off))
+			status(err)
+		case fxpStat:
+			fi, err := os.Stat(local(m.str()))
+			if err != nil {
+				status(err)
+				continue
+			}
+			reply(fxpAttrs, append([]any{id}, attrs(fi)...)...)
+		case fxpRemove:
+			status(os.Remove(local(m.str())))
+		case fxpMkdir:
+			status(os.Mkdir(local(m.str()), 0o750))
+		case fxpExtended:
+			if string(m.str()) != posixRename {
+				status(errors.New("unsupported"))
+				continue
+			}
+			status(os.Rename(local(m.str()), local(m.str())))
+		default:
+			status(errors.New("unsupported"))
+		}
+	}
+}
+
+func TestSFTP(t *testing.T) {
+	fake := &fakeSFTP{root: t.TempDir()}
+	s, err := NewSFTP(SFTPOptions{Host: "nas", Dir: "/srv/blobs", Retries: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.dial = fake.dial
+	defer s.Close()
+	ctx := context.Background()
+
+	big := bytes.Repeat([]byte("0123456789"), 10000) // several writes and reads
+	if n, err := s.Put(ctx, "big", bytes.NewReader(big)); err != nil || n != int64(len(big)) {
+		t.Fatalf("Put big = %d, %v", n, err)
+	}
+	if n, err := s.Put(ctx, "small", strings.NewReader("hello")); err != nil || n != 5 {
+		t.Fatalf("Put small = %d, %v", n, err)
+	}
+	s.Put(ctx, "third", strings.NewReader("3"))
+	if got, _ := os.ReadFile(filepath.Join(fake.root, "srv/blobs/bi/big")); !bytes.Equal(got, big) {
+		t.Fatalf("stored %d bytes", len(got))
+	}
+	if tmp, _ := os.ReadDir(filepath.Join(fake.root, "srv/blobs/tmp")); len(tmp) != 0 {
+		t.Fatalf("%d temp files left", len(tmp))
+	}
+
+	rc, err := s.Get(ctx, "big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := rc.(io.ReadSeeker)
+	if all, _ := io.ReadAll(rs); !bytes.Equal(all, big) {
+		t.Fatalf("read %d bytes", len(all))
+	}
+	rs.Seek(-5, io.SeekEnd)
+	if tail, _ := io.ReadAll(rs); string(tail) != "56789" {
+		t.Fatalf("read %q from the end", tail)
+	}
+	rc.Close()
+
+	// idle connections the host dropped are retried on new ones
+	fake.drop()
+	if info, err := s.Stat(ctx, "small"); err != nil || info.Size != 5 {
+		t.Fatalf("Stat after a dropped connection = %+v, %v", info, err)
+	}
+
+	var keys []string
+	s.List(ctx, func(i Info) error { keys = append(keys, i.Key); return nil })
+	if strings.Join(keys, ",") != "big,small,third" {
+		t.Fatalf("listed %q", keys)
+	}
+	if err := s.Delete(ctx, "small"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(ctx, "small"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get after Delete: %v", err)
+	}
+	if err := s.Delete(ctx, "small"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("second Delete: %v", err)
+	}
+}
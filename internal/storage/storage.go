@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrNotFound is returned when a key has no blob behind it.
+var ErrNotFound = errors.New("storage: blob not found")
+
+// Info describes a stored blob without opening it.
+type Info struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Backend stores opaque blobs by key. It knows nothing about file names, owners or links;
+// that lives in the metadata index. Keeping the two apart means new backends only have to
+// move bytes around.
+type Backend interface {
+	// Put stores everything read from r under key and returns the number of bytes written.
+	// A blob must never be visible under key until it has been written completely.
+	Put(ctx context.Context, key string, r io.Reader) (int64, error)
+	// Get opens the blob for reading. Implementations return an io.ReadSeekCloser when they
+	// can, so the HTTP layer can serve range requests.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Stat(ctx context.Context, key string) (Info, error)
+	Delete(ctx context.Context, key string) error
+	// List calls fn for every stored blob. Returning an error from fn stops the walk.
+	List(ctx context.Context, fn func(Info) error) error
+}
+
+// URLSigner is implemented by backends that can hand out a time-limited URL a client can
+// download a blob from directly, so the bytes don't pass through the server.
+type URLSigner interface {
+	// SignedURL returns a URL for key valid for ttl, whose response carries the given
+	// Content-Disposition and Content-Type.
+	SignedURL(key string, ttl time.Duration, disposition, contentType string) (string, error)
+}
+
+// ContentAddressed is implemented by backends that store blobs under a content identifier
+// besides their key, like IPFS's CIDs, which the content can be fetched by elsewhere.
+type ContentAddressed interface {
+	CID(ctx context.Context, key string) (string, error)
+}
+
+// Mover is implemented by backends that can give a blob a new key without copying it.
+type Mover interface {
+	// Move puts the blob under from under to instead, replacing whatever was there.
+	Move(ctx context.Context, from, to string) error
+}
+
+// Move gives the blob under from the key to, copying it across and deleting the original
+// when b can't move it.
+func Move(ctx context.Context, b Backend, from, to string) error {
+	if m, ok := b.(Mover); ok {
+		return m.Move(ctx, from, to)
+	}
+	rc, err := b.Get(ctx, from)
+	if err != nil {
+		return err
+	}
+	_, err = b.Put(ctx, to, rc)
+	rc.Close()
+	if err != nil {
+		return err
+	}
+	return b.Delete(ctx, from)
+}
+
+// Dedup files the blob just stored under key by sum, its content's SHA-256, the way
+// storage.dedup keeps one copy of each content: it is moved there, or deleted when stored
+// says that content is there already. Either way the record it was stored for should then
+// name sum as its blob. When stored, an error only means the extra copy is left behind.
+func Dedup(ctx context.Context, b Backend, key, sum string, stored bool) error {
+	if !stored {
+		return Move(ctx, b, key, sum)
+	}
+	// the request may already be cancelled, and the copy must go regardless
+	if err := b.Delete(context.WithoutCancel(ctx), key); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+// Find returns the backend of type T among b and the backends it wraps, like the *Tiered
+// under encryption and compression, following their Unwrap methods.
+func Find[T Backend](b Backend) (T, bool) {
+	for {
+		if t, ok := b.(T); ok {
+			return t, true
+		}
+		w, ok := b.(interface{ Unwrap() Backend })
+		if !ok {
+			var zero T
+			return zero, false
+		}
+		b = w.Unwrap()
+	}
+}
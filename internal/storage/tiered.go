@@ -0,0 +1,358 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// TierPolicy decides which blobs Tiered moves to its cold tier. A blob moves once every rule
+// that's set holds for it; rules left zero don't count, and with none set nothing moves.
+type TierPolicy struct {
+	Age     time.Duration // stored at least this long ago
+	Idle    time.Duration // not read for at least this long; reading a cold blob moves it back
+	MinSize int64         // at least this large
+	// State is a file the read times Idle goes by are kept in across restarts. Without one,
+	// a restart forgets them, and blobs count as last read when they were stored.
+	State string
+}
+
+func (p TierPolicy) set() bool { return p.Age > 0 || p.Idle > 0 || p.MinSize > 0 }
+
+// Tiered keeps blobs in a fast hot backend and moves those the policy picks to a cheap cold
+// one when Migrate runs. Reads look in the hot tier first; with an Idle rule, a blob read
+// from the cold tier is moved back before it's returned, since it isn't idle any more.
+// Without one it's read where it is, so it doesn't bounce between the tiers.
+type Tiered struct {
+	hot, cold Backend
+	policy    TierPolicy
+
+	mu    sync.Mutex
+	reads map[string]time.Time
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	n int
+}
+
+// TierResult is what a Migrate pass did.
+type TierResult struct {
+	Moved  int
+	Bytes  int64
+	Failed int
+}
+
+// NewTiered returns a backend keeping blobs in hot until policy moves them to cold.
+func NewTiered(hot, cold Backend, policy TierPolicy) (*Tiered, error) {
+	t := &Tiered{hot: hot, cold: cold, policy: policy, reads: map[string]time.Time{}, locks: map[string]*keyLock{}}
+	if policy.State != "" {
+		b, err := os.ReadFile(policy.State)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		if len(b) > 0 {
+			if err := json.Unmarshal(b, &t.reads); err != nil {
+				return nil, fmt.Errorf("storage: read times in %s: %w", policy.State, err)
+			}
+		}
+	}
+	return t, nil
+}
+
+// lock serializes changes to key: a blob mustn't be moved between tiers while it's being
+// replaced or deleted.
+func (t *Tiered) lock(key string) (unlock func()) {
+	t.mu.Lock()
+	l := t.locks[key]
+	if l == nil {
+		l = &keyLock{}
+		t.locks[key] = l
+	}
+	l.n++
+	t.mu.Unlock()
+	l.Lock()
+	return func() {
+		l.Unlock()
+		t.mu.Lock()
+		if l.n--; l.n == 0 {
+			delete(t.locks, key)
+		}
+		t.mu.Unlock()
+	}
+}
+
+func (t *Tiered) touch(key string) {
+	if t.policy.Idle > 0 {
+		t.mu.Lock()
+		t.reads[key] = time.Now()
+		t.mu.Unlock()
+	}
+}
+
+// Put stores into the hot tier, and drops a copy under key in the cold one.
+func (t *Tiered) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	defer t.lock(key)()
+	n, err := t.hot.Put(ctx, key, r)
+	if err != nil {
+		return n, err
+	}
+	if err := t.cold.Delete(ctx, key); err != nil && !errors.Is(err, ErrNotFound) {
+		return n, err
+	}
+	return n, nil
+}
+
+type backgroundReadKey struct{}
+
+// WithBackgroundRead marks ctx's reads as checks of the blobs rather than downloads: a
+// Tiered backend, whether called directly or by one wrapping it, doesn't count them as reads
+// of a blob, or move it back from the cold tier for them.
+func WithBackgroundRead(ctx context.Context) context.Context {
+	return context.WithValue(ctx, backgroundReadKey{}, true)
+}
+
+func (t *Tiered) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	background := ctx.Value(backgroundReadKey{}) != nil
+	rc, err := t.hot.Get(ctx, key)
+	if !errors.Is(err, ErrNotFound) {
+		if err == nil && !background {
+			t.touch(key)
+		}
+		return rc, err
+	}
+	if t.policy.Idle <= 0 || background {
+		return t.cold.Get(ctx, key)
+	}
+	defer t.lock(key)()
+	// it may have been moved back while we waited
+	if rc, err := t.hot.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+		if err == nil {
+			t.touch(key)
+		}
+		return rc, err
+	}
+	if err := copyBlob(ctx, t.cold, t.hot, key); err != nil {
+		return nil, err
+	}
+	t.touch(key)
+	if err := t.cold.Delete(ctx, key); err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	return t.hot.Get(ctx, key)
+}
+
+func (t *Tiered) Stat(ctx context.Context, key string) (Info, error) {
+	info, err := t.hot.Stat(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		return t.cold.Stat(ctx, key)
+	}
+	return info, err
+}
+
+// Delete deletes key from both tiers, and returns ErrNotFound only if neither had it.
+func (t *Tiered) Delete(ctx context.Context, key string) error {
+	defer t.lock(key)()
+	t.mu.Lock()
+	delete(t.reads, key)
+	t.mu.Unlock()
+	hotErr := t.hot.Delete(ctx, key)
+	coldErr := t.cold.Delete(ctx, key)
+	switch {
+	case hotErr == nil && (coldErr == nil || errors.Is(coldErr, ErrNotFound)):
+		return nil
+	case coldErr == nil && errors.Is(hotErr, ErrNotFound):
+		return nil
+	case hotErr != nil && !errors.Is(hotErr, ErrNotFound):
+		return hotErr
+	}
+	return coldErr
+}
+
+// List lists the blobs in both tiers, each once.
+func (t *Tiered) List(ctx context.Context, fn func(Info) error) error {
+	hot := map[string]bool{}
+	if err := t.hot.List(ctx, func(info Info) error {
+		hot[info.Key] = true
+		return fn(info)
+	}); err != nil {
+		return err
+	}
+	return t.cold.List(ctx, func(info Info) error {
+		if hot[info.Key] {
+			return nil
+		}
+		return fn(info)
+	})
+}
+
+// Move moves the blob within the tier it's in.
+func (t *Tiered) Move(ctx context.Context, from, to string) error {
+	if from == to {
+		return nil
+	}
+	first, second := from, to
+	if second < first {
+		first, second = second, first
+	}
+	defer t.lock(first)()
+	defer t.lock(second)()
+	in, other := t.hot, t.cold
+	if _, err := t.hot.Stat(ctx, from); errors.Is(err, ErrNotFound) {
+		in, other = t.cold, t.hot
+	} else if err != nil {
+		return err
+	}
+	if err := Move(ctx, in, from, to); err != nil {
+		return err
+	}
+	if err := other.Delete(ctx, to); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	t.mu.Lock()
+	if read, ok := t.reads[from]; ok {
+		t.reads[to] = read
+		delete(t.reads, from)
+	}
+	t.mu.Unlock()
+	return nil
+}
+
+// Migrate moves the hot blobs the policy picks to the cold tier, reporting each one to
+// progress when it isn't nil, and saves the read times to the policy's State file.
+func (t *Tiered) Migrate(ctx context.Context, now time.Time, progress func(key string, err error)) (TierResult, error) {
+	var res TierResult
+	started := time.Now()
+	var due []Info
+	seen := map[string]bool{}
+	if err := t.hot.List(ctx, func(info Info) error {
+		seen[info.Key] = true
+		if t.due(info, now) {
+			due = append(due, info)
+		}
+		return nil
+	}); err != nil {
+		return res, err
+	}
+	for _, info := range due {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		moved, err := t.demote(ctx, info, now)
+		if err != nil {
+			res.Failed++
+		} else if moved {
+			res.Moved++
+			res.Bytes += info.Size
+		} else {
+			continue
+		}
+		if progress != nil {
+			progress(info.Key, err)
+		}
+	}
+
+	// what's no longer hot needs no read time, unless it was moved back during the pass
+	t.mu.Lock()
+	for key, read := range t.reads {
+		if !seen[key] && read.Before(started) {
+			delete(t.reads, key)
+		}
+	}
+	t.mu.Unlock()
+	return res, t.save()
+}
+
+func (t *Tiered) due(info Info, now time.Time) bool {
+	p := t.policy
+	if !p.set() || now.Sub(info.ModTime) < p.Age || info.Size < p.MinSize {
+		return false
+	}
+	if p.Idle > 0 {
+		t.mu.Lock()
+		read, ok := t.reads[info.Key]
+		t.mu.Unlock()
+		if !ok || read.Before(info.ModTime) {
+			read = info.ModTime
+		}
+		return now.Sub(read) >= p.Idle
+	}
+	return true
+}
+
+// demote moves one blob to the cold tier, unless it changed or was read since it was listed.
+func (t *Tiered) demote(ctx context.Context, listed Info, now time.Time) (bool, error) {
+	defer t.lock(listed.Key)()
+	info, err := t.hot.Stat(ctx, listed.Key)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if !info.ModTime.Equal(listed.ModTime) || !t.due(info, now) {
+		return false, nil
+	}
+	if err := copyBlob(ctx, t.hot, t.cold, info.Key); err != nil {
+		t.cold.Delete(ctx, info.Key)
+		return false, err
+	}
+	if err := t.hot.Delete(ctx, info.Key); err != nil {
+		return false, err
+	}
+	t.mu.Lock()
+	delete(t.reads, info.Key)
+	t.mu.Unlock()
+	return true, nil
+}
+
+// save writes the read times to the State file, through a temporary one so a crash can't
+// leave half of it.
+func (t *Tiered) save() error {
+	if t.policy.State == "" || t.policy.Idle <= 0 {
+		return nil
+	}
+	t.mu.Lock()
+	b, err := json.Marshal(t.reads)
+	t.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(t.policy.State), ".tiers-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), t.policy.State)
+}
+
+// Unwrap returns the hot tier.
+func (t *Tiered) Unwrap() Backend { return t.hot }
+
+// CleanTemp cleans up both tiers' leftovers from interrupted writes.
+func (t *Tiered) CleanTemp(cutoff time.Time) (int, error) {
+	n := 0
+	for _, b := range []Backend{t.hot, t.cold} {
+		if tc, ok := b.(interface{ CleanTemp(time.Time) (int, error) }); ok {
+			m, err := tc.CleanTemp(cutoff)
+			n += m
+			if err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTiered(t *testing.T) {
+	ctx := context.Background()
+	hot, cold := NewMemory(), NewMemory()
+	state := filepath.Join(t.TempDir(), "tiers.json")
+	tiers, err := NewTiered(hot, cold, TierPolicy{Idle: time.Hour, MinSize: 10, State: state})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"read", "unread", "small"} {
+		content := "content of " + key
+		if key == "small" {
+			content = "tiny"
+		}
+		tiers.Put(ctx, key, strings.NewReader(content))
+	}
+	later := time.Now().Add(90 * time.Minute)
+	// read times count, and are kept across restarts
+	tiers.reads["read"] = later.Add(-time.Minute)
+	tiers.save()
+	tiers, _ = NewTiered(hot, cold, TierPolicy{Idle: time.Hour, MinSize: 10, State: state})
+
+	var moved []string
+	res, err := tiers.Migrate(ctx, later, func(key string, err error) { moved = append(moved, key) })
+	if err != nil || res.Moved != 1 || res.Bytes != 17 || strings.Join(moved, ",") != "unread" {
+		t.Fatalf("Migrate = %+v, %v, moved %q", res, err, moved)
+	}
+	if _, err := hot.Stat(ctx, "unread"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("unread still hot: %v", err)
+	}
+	var listed []string
+	tiers.List(ctx, func(info Info) error {
+		listed = append(listed, info.Key)
+		return nil
+	})
+	if len(listed) != 3 {
+		t.Fatalf("List = %q", listed)
+	}
+	if info, err := tiers.Stat(ctx, "unread"); err != nil || info.Size != 17 {
+		t.Fatalf("Stat of a cold blob = %+v, %v", info, err)
+	}
+
+	// reading it moves it back
+	rc, err := tiers.Get(ctx, "unread")
+	if err != nil || string(must(io.ReadAll(rc))) != "content of unread" {
+		t.Fatalf("Get of a cold blob: %v", err)
+	}
+	if _, err := cold.Stat(ctx, "unread"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("unread still cold: %v", err)
+	}
+	if res, _ := tiers.Migrate(ctx, time.Now().Add(time.Minute), nil); res.Moved != 0 {
+		t.Fatalf("moved %d blobs just read", res.Moved)
+	}
+
+	// without an idle rule, cold blobs are read where they are
+	tiers, _ = NewTiered(hot, cold, TierPolicy{MinSize: 10})
+	if res, _ := tiers.Migrate(ctx, time.Now(), nil); res.Moved != 2 {
+		t.Fatalf("moved %d blobs by size", res.Moved)
+	}
+	if rc, err := tiers.Get(ctx, "read"); err != nil || string(must(io.ReadAll(rc))) != "content of read" {
+		t.Fatalf("Get: %v", err)
+	}
+	if _, err := hot.Stat(ctx, "read"); !errors.Is(err, ErrNotFound) {
+		t.Fatal("read moved back without an idle rule")
+	}
+
+	// storing a blob again makes it hot; deleting it deletes it from both tiers
+	tiers.Put(ctx, "read", strings.NewReader("new content"))
+	if _, err := cold.Stat(ctx, "read"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("stale copy left in the cold tier: %v", err)
+	}
+	if err := tiers.Move(ctx, "unread", "moved"); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"read", "moved", "small"} {
+		if err := tiers.Delete(ctx, key); err != nil {
+			t.Fatalf("Delete %s: %v", key, err)
+		}
+	}
+	if err := tiers.Delete(ctx, "read"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Delete twice: %v", err)
+	}
+	if err := tiers.List(ctx, func(info Info) error { return errors.New(info.Key + " left") }); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,115 @@
+// Package systemd implements the two bits of the systemd protocol filegoblin cares about:
+// inheriting listening sockets (socket activation) and sd_notify readiness/watchdog messages.
+// Both are plain environment variables plus a unix datagram socket, so no libsystemd is needed.
+package systemd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// listenFdsStart is the first inherited file descriptor, as defined by sd_listen_fds(3).
+const listenFdsStart = 3
+
+// Listeners returns the sockets passed in by systemd socket activation, keyed by the names
+// from FileDescriptorName= (or "LISTEN_FD_<n>" when unnamed). It returns nil when the process
+// was not socket-activated. The environment is cleared afterwards so child processes don't
+// think the sockets are theirs.
+func Listeners() (map[string]net.Listener, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil // not for us
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	out := make(map[string]net.Listener, n)
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("LISTEN_FD_%d", listenFdsStart+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(listenFdsStart+i), name)
+		ln, err := net.FileListener(f) // dups the fd, so the *os.File can be closed right away
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("inherited socket %s: %w", name, err)
+		}
+		out[name] = ln
+	}
+	return out, nil
+}
+
+// Notify sends a state string such as "READY=1" to the service manager. It returns false
+// without error when NOTIFY_SOCKET is unset, i.e. when not running under systemd.
+func Notify(state string) (bool, error) {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return false, nil
+	}
+	if addr[0] == '@' {
+		addr = "\x00" + addr[1:] // abstract namespace socket
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns how often systemd expects a WATCHDOG=1 ping, or 0 when the
+// watchdog is not enabled for this process.
+func WatchdogInterval() (time.Duration, error) {
+	usec := os.Getenv("WATCHDOG_USEC")
+	if usec == "" {
+		return 0, nil
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, errors.New("systemd: invalid WATCHDOG_USEC")
+	}
+	return time.Duration(n) * time.Microsecond, nil
+}
+
+// Watchdog pings the service manager at half the requested interval until ctx is done,
+// which is the safety margin systemd's documentation recommends. It returns immediately
+// when the watchdog is disabled.
+func Watchdog(ctx context.Context) error {
+	interval, err := WatchdogInterval()
+	if err != nil || interval == 0 {
+		return err
+	}
+	t := time.NewTicker(interval / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+			if _, err := Notify("WATCHDOG=1"); err != nil {
+				return err
+			}
+		}
+	}
+}
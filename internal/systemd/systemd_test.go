@@ -0,0 +1,52 @@
+package systemd
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestNotify checks that a state message reaches the socket named in NOTIFY_SOCKET.
+func TestNotify(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: sock, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("unixgram not available: %v", err)
+	}
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", sock)
+	sent, err := Notify("READY=1")
+	if err != nil || !sent {
+		t.Fatalf("Notify: sent=%v err=%v", sent, err)
+	}
+	buf := make([]byte, 64)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); got != "READY=1" {
+		t.Fatalf("got %q, want READY=1", got)
+	}
+}
+
+// TestNotifyWithoutSystemd makes sure running outside systemd is not an error.
+func TestNotifyWithoutSystemd(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	sent, err := Notify("READY=1")
+	if sent || err != nil {
+		t.Fatalf("got sent=%v err=%v, want false, nil", sent, err)
+	}
+}
+
+// TestListenersIgnoresOtherPID checks that sockets meant for another process are left alone.
+func TestListenersIgnoresOtherPID(t *testing.T) {
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+	lns, err := Listeners()
+	if err != nil || lns != nil {
+		t.Fatalf("got %v, %v; want nil, nil", lns, err)
+	}
+}
@@ -0,0 +1,197 @@
+package thumb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+var (
+	// ErrUnsupported is returned by Strip for formats other than JPEG, PNG and WebP.
+	ErrUnsupported = errors.New("thumb: can't strip metadata from this format")
+	// ErrMalformed is returned by Strip for images whose structure doesn't parse.
+	ErrMalformed = errors.New("thumb: malformed image")
+)
+
+// Strip removes the metadata from a JPEG, PNG or WebP image without re-encoding it: EXIF
+// (with the GPS position, camera and time taken), XMP, IPTC, comments and text chunks, and
+// anything appended after the image. The EXIF orientation is kept, in a block of its own, so
+// the image still shows upright; colour profiles are kept too.
+func Strip(data []byte) ([]byte, error) {
+	switch {
+	case len(data) >= 2 && data[0] == 0xFF && data[1] == 0xD8:
+		return stripJPEG(data)
+	case len(data) >= 8 && string(data[:8]) == "\x89PNG\r\n\x1a\n":
+		return stripPNG(data)
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return stripWebP(data)
+	}
+	return nil, ErrUnsupported
+}
+
+// orientationTIFF is an EXIF block holding nothing but orientation o.
+func orientationTIFF(o int) []byte {
+	return []byte{'M', 'M', 0, 42, 0, 0, 0, 8, // header, IFD0 at 8
+		0, 1, // one entry
+		0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, byte(o), 0, 0, // orientation, SHORT, count 1, value
+		0, 0, 0, 0} // no next IFD
+}
+
+func stripJPEG(data []byte) ([]byte, error) {
+	out := []byte{0xFF, 0xD8}
+	if o := Orientation(data); o != 1 {
+		seg := append([]byte("Exif\x00\x00"), orientationTIFF(o)...)
+		out = append(out, 0xFF, 0xE1, byte((len(seg)+2)>>8), byte(len(seg)+2))
+		out = append(out, seg...)
+	}
+	for i := 2; ; {
+		if i+4 > len(data) || data[i] != 0xFF {
+			return nil, ErrMalformed
+		}
+		marker := data[i+1]
+		if marker == 0xFF { // fill byte
+			i++
+			continue
+		}
+		n := int(binary.BigEndian.Uint16(data[i+2:]))
+		if n < 2 || i+2+n > len(data) {
+			return nil, ErrMalformed
+		}
+		if marker == 0xDA { // start of scan: the image data follows
+			return append(out, data[i:jpegEnd(data, i)]...), nil
+		}
+		if keepSegment(marker, data[i+4:i+2+n]) {
+			out = append(out, data[i:i+2+n]...)
+		}
+		i += 2 + n
+	}
+}
+
+// keepSegment reports whether a JPEG header segment is needed to show the image: the
+// metadata lives in comments and in application segments other than JFIF, ICC profiles and
+// Adobe's colour transform flag.
+func keepSegment(marker byte, seg []byte) bool {
+	switch {
+	case marker == 0xFE: // comment
+		return false
+	case marker == 0xE0, marker == 0xEE: // JFIF, Adobe
+		return true
+	case marker == 0xE2:
+		return bytes.HasPrefix(seg, []byte("ICC_PROFILE\x00"))
+	case marker >= 0xE1 && marker <= 0xEF:
+		return false
+	}
+	return true
+}
+
+// jpegEnd returns the offset just past the end-of-image marker, walking from the first scan
+// at i over the image data and the segments between progressive scans. Phones append whole
+// videos after it, which go too. A truncated image is kept as far as it goes.
+func jpegEnd(data []byte, i int) int {
+	for i+1 < len(data) {
+		if data[i] != 0xFF {
+			i++
+			continue
+		}
+		switch m := data[i+1]; {
+		case m == 0xD9:
+			return i + 2
+		case m == 0xFF:
+			i++
+		case m == 0x00 || (m >= 0xD0 && m <= 0xD7): // stuffed byte, restart marker
+			i += 2
+		default:
+			if i+4 > len(data) {
+				return len(data)
+			}
+			i += 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		}
+	}
+	return len(data)
+}
+
+func stripPNG(data []byte) ([]byte, error) {
+	out := append([]byte(nil), data[:8]...)
+	o := 1
+	for i := 8; ; {
+		if i+12 > len(data) {
+			return nil, ErrMalformed
+		}
+		n := int(binary.BigEndian.Uint32(data[i:]))
+		if n < 0 || n > len(data)-i-12 {
+			return nil, ErrMalformed
+		}
+		chunk := data[i : i+12+n]
+		switch string(data[i+4 : i+8]) {
+		case "eXIf":
+			o = tiffOrientation(chunk[8 : 8+n])
+		case "tEXt", "zTXt", "iTXt", "tIME":
+		case "IDAT":
+			if o != 1 { // eXIf has to come before the image data
+				out = append(out, pngChunk("eXIf", orientationTIFF(o))...)
+				o = 1
+			}
+			out = append(out, chunk...)
+		case "IEND":
+			return append(out, chunk...), nil
+		default:
+			out = append(out, chunk...)
+		}
+		i += 12 + n
+	}
+}
+
+func pngChunk(typ string, payload []byte) []byte {
+	c := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+	c = append(c, typ...)
+	c = append(c, payload...)
+	return binary.BigEndian.AppendUint32(c, crc32.ChecksumIEEE(c[4:]))
+}
+
+// WebP's VP8X header flags saying EXIF and XMP chunks are present.
+const (
+	webpEXIF = 0x08
+	webpXMP  = 0x04
+)
+
+func stripWebP(data []byte) ([]byte, error) {
+	var chunks [][]byte
+	vp8x := -1
+	o := 1
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return nil, ErrMalformed
+		}
+		n := int(binary.LittleEndian.Uint32(data[i+4:]))
+		if n < 0 || n > len(data)-i-8 {
+			return nil, ErrMalformed
+		}
+		size := min(8+n+n&1, len(data)-i) // chunks are padded to an even length
+		switch fourcc := string(data[i : i+4]); fourcc {
+		case "EXIF":
+			o = tiffOrientation(bytes.TrimPrefix(data[i+8:i+8+n], []byte("Exif\x00\x00")))
+		case "XMP ":
+		default:
+			if fourcc == "VP8X" && n >= 1 {
+				vp8x = len(chunks)
+			}
+			chunks = append(chunks, data[i:i+size])
+		}
+		i += size
+	}
+	if vp8x >= 0 {
+		c := append([]byte(nil), chunks[vp8x]...)
+		c[8] &^= webpEXIF | webpXMP
+		if o != 1 {
+			c[8] |= webpEXIF
+			exif := binary.LittleEndian.AppendUint32([]byte("EXIF"), uint32(len(orientationTIFF(o))))
+			chunks = append(chunks, append(exif, orientationTIFF(o)...))
+		}
+		chunks[vp8x] = c
+	}
+	body := bytes.Join(chunks, nil)
+	out := binary.LittleEndian.AppendUint32([]byte("RIFF"), uint32(4+len(body)))
+	out = append(out, "WEBP"...)
+	return append(out, body...), nil
+}
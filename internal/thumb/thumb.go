@@ -0,0 +1,160 @@
+// Package thumb makes thumbnails of uploaded images: decode, turn upright according to the
+// EXIF orientation, scale down and encode. JPEG and PNG are encoded natively; WebP and AVIF
+// go through an external encoder command, since the standard library can't write them.
+// Strip removes the metadata from uploads that are published as they are.
+package thumb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif" // decoder only: thumbnails show the first frame
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os/exec"
+)
+
+// ErrTooLarge is returned for images with more pixels than the caller allows, which keeps
+// small files that decode to huge bitmaps from eating the server's memory.
+var ErrTooLarge = errors.New("thumb: image too large to thumbnail")
+
+// Options says what thumbnail to make.
+type Options struct {
+	Width     int      // the thumbnail's width; images narrower than this keep their size
+	Format    string   // "jpeg", "png", "webp" or "avif"
+	Quality   int      // JPEG quality, 1-100
+	Encoder   []string // for webp and avif: reads a PNG on stdin, writes the image on stdout
+	MaxPixels int64    // refuse to decode images bigger than this; 0 means no limit
+}
+
+// ContentType returns the media type of a thumbnail format.
+func ContentType(format string) string {
+	return "image/" + format
+}
+
+// Make reads an image from data and writes its thumbnail to w.
+func Make(ctx context.Context, w io.Writer, data []byte, opt Options) error {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("thumb: %w", err)
+	}
+	if opt.MaxPixels > 0 && int64(cfg.Width)*int64(cfg.Height) > opt.MaxPixels {
+		return ErrTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("thumb: %w", err)
+	}
+	o := Orientation(data)
+	img = Orient(Scale(img, opt.Width, o >= 5), o)
+	return encode(ctx, w, img, opt)
+}
+
+// Scale shrinks img to width pixels wide in its upright frame, keeping the aspect ratio; swap
+// says the image is stored rotated by 90°, so its height becomes the upright width. It never
+// enlarges.
+func Scale(img image.Image, width int, swap bool) image.Image {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	uw, uh := sw, sh
+	if swap {
+		uw, uh = sh, sw
+	}
+	if width <= 0 || width >= uw {
+		return img
+	}
+	dw, dh := width, max(1, uh*width/uw)
+	if swap {
+		dw, dh = dh, dw
+	}
+
+	src := image.NewRGBA(image.Rect(0, 0, sw, sh))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	// box filter: every destination pixel is the average of the source pixels it covers,
+	// which is what shrinking needs to stay free of aliasing
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*sh/dh, max((y+1)*sh/dh, y*sh/dh+1)
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*sw/dw, max((x+1)*sw/dw, x*sw/dw+1)
+			var r, g, bl, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r, g, bl, a = r+uint32(p[0]), g+uint32(p[1]), bl+uint32(p[2]), a+uint32(p[3])
+					n++
+				}
+			}
+			i := y*dst.Stride + x*4
+			dst.Pix[i], dst.Pix[i+1], dst.Pix[i+2], dst.Pix[i+3] = uint8(r/n), uint8(g/n), uint8(bl/n), uint8(a/n)
+		}
+	}
+	return dst
+}
+
+// Orient turns an image stored with EXIF orientation o (1-8) upright.
+func Orient(img image.Image, o int) image.Image {
+	if o < 2 || o > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if o >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch o {
+			case 2: // flip horizontally
+				dx, dy = w-1-x, y
+			case 3: // rotate 180°
+				dx, dy = w-1-x, h-1-y
+			case 4: // flip vertically
+				dx, dy = x, h-1-y
+			case 5: // transpose
+				dx, dy = y, x
+			case 6: // rotate 90° clockwise
+				dx, dy = h-1-y, x
+			case 7: // transverse
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotate 90° counter-clockwise
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+func encode(ctx context.Context, w io.Writer, img image.Image, opt Options) error {
+	switch opt.Format {
+	case "", "jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: opt.Quality})
+	case "png":
+		return png.Encode(w, img)
+	case "webp", "avif":
+		if len(opt.Encoder) == 0 {
+			return fmt.Errorf("thumb: %s needs an encoder command", opt.Format)
+		}
+		var in bytes.Buffer
+		if err := png.Encode(&in, img); err != nil {
+			return err
+		}
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, opt.Encoder[0], opt.Encoder[1:]...)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = &in, w, &stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("thumb: %s encoder: %v: %s", opt.Format, err, bytes.TrimSpace(stderr.Bytes()))
+		}
+		return nil
+	}
+	return fmt.Errorf("thumb: unknown format %q", opt.Format)
+}
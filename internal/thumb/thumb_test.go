@@ -0,0 +1,158 @@
+package thumb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// withOrientation inserts an EXIF block carrying orientation o right after a JPEG's SOI.
+func withOrientation(t *testing.T, jpg []byte, o byte) []byte {
+	t.Helper()
+	tiff := []byte{'M', 'M', 0, 42, 0, 0, 0, 8, // header, IFD0 at 8
+		0, 1, // one entry
+		0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, o, 0, 0, // orientation, SHORT, count 1, value
+		0, 0, 0, 0} // no next IFD
+	seg := append([]byte("Exif\x00\x00"), tiff...)
+	n := len(seg) + 2
+	out := append([]byte{0xFF, 0xD8, 0xFF, 0xE1, byte(n >> 8), byte(n)}, seg...)
+	return append(out, jpg[2:]...)
+}
+
+func TestMake(t *testing.T) {
+	// 40x20, left half red, right half blue
+	src := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 40; x++ {
+			c := color.RGBA{255, 0, 0, 255}
+			if x >= 20 {
+				c = color.RGBA{0, 0, 255, 255}
+			}
+			src.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, src, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	if o := Orientation(buf.Bytes()); o != 1 {
+		t.Fatalf("plain JPEG orientation = %d", o)
+	}
+	rotated := withOrientation(t, buf.Bytes(), 6)
+	if o := Orientation(rotated); o != 6 {
+		t.Fatalf("orientation = %d, want 6", o)
+	}
+
+	var out bytes.Buffer
+	if err := Make(context.Background(), &out, rotated, Options{Width: 10, Format: "png"}); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// upright the image is 20x40 with red on top, so the thumbnail is 10x20
+	if b := img.Bounds(); b.Dx() != 10 || b.Dy() != 20 {
+		t.Fatalf("thumbnail is %v", b)
+	}
+	if r, _, bl, _ := img.At(5, 2).RGBA(); r < bl {
+		t.Errorf("top of the upright image should be red")
+	}
+	if r, _, bl, _ := img.At(5, 17).RGBA(); bl < r {
+		t.Errorf("bottom of the upright image should be blue")
+	}
+
+	if err := Make(context.Background(), &out, rotated, Options{Width: 10, MaxPixels: 100}); err != ErrTooLarge {
+		t.Fatalf("pixel limit: %v", err)
+	}
+}
+
+func TestStrip(t *testing.T) {
+	src := image.NewGray(image.Rect(0, 0, 8, 8))
+	secret := []byte("51.5007N 0.1246W")
+	withSecret := func(marker byte, prefix string) []byte {
+		seg := append([]byte(prefix), secret...)
+		return append([]byte{0xFF, marker, byte((len(seg) + 2) >> 8), byte(len(seg) + 2)}, seg...)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, src, nil); err != nil {
+		t.Fatal(err)
+	}
+	jpg := withOrientation(t, buf.Bytes(), 6)
+	jpg = append(jpg[:2], append(append(withSecret(0xFE, ""), withSecret(0xE1, "http://ns.adobe.com/xap/1.0/\x00")...), jpg[2:]...)...)
+	jpg = append(jpg, secret...) // trailing data after the image
+	out, err := Strip(jpg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(out, secret) {
+		t.Fatal("JPEG metadata survived")
+	}
+	if o := Orientation(out); o != 6 {
+		t.Fatalf("JPEG orientation = %d, want 6", o)
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(out)); err != nil {
+		t.Fatalf("stripped JPEG: %v", err)
+	}
+
+	buf.Reset()
+	if err := png.Encode(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+	p := buf.Bytes()
+	ihdrEnd := 8 + 12 + 13
+	p = append(p[:ihdrEnd:ihdrEnd], append(pngChunk("tEXt", append([]byte("Comment\x00"), secret...)), p[ihdrEnd:]...)...)
+	if out, err = Strip(p); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(out, secret) {
+		t.Fatal("PNG metadata survived")
+	}
+	if _, err := png.Decode(bytes.NewReader(out)); err != nil {
+		t.Fatalf("stripped PNG: %v", err)
+	}
+
+	chunk := func(fourcc string, payload []byte) []byte {
+		c := binary.LittleEndian.AppendUint32([]byte(fourcc), uint32(len(payload)))
+		c = append(c, payload...)
+		if len(payload)%2 == 1 {
+			c = append(c, 0)
+		}
+		return c
+	}
+	body := append([]byte("WEBP"), chunk("VP8X", []byte{webpEXIF | webpXMP, 0, 0, 0, 7, 0, 0, 7, 0, 0})...)
+	body = append(body, chunk("VP8L", []byte{0x2F, 7, 0xC0, 1, 0})...)
+	body = append(body, chunk("EXIF", append(cameraTIFF(8), secret...))...)
+	body = append(body, chunk("XMP ", secret)...)
+	webp := append(binary.LittleEndian.AppendUint32([]byte("RIFF"), uint32(len(body))), body...)
+	if out, err = Strip(webp); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(out, secret) {
+		t.Fatal("WebP metadata survived")
+	}
+	if n := binary.LittleEndian.Uint32(out[4:]); int(n) != len(out)-8 {
+		t.Fatalf("RIFF size %d for %d bytes", n, len(out)-8)
+	}
+	if out[20] != webpEXIF || !bytes.Contains(out, orientationTIFF(8)) {
+		t.Fatalf("WebP orientation lost: flags %#x", out[20])
+	}
+
+	if _, err := Strip([]byte("GIF89a")); err != ErrUnsupported {
+		t.Fatalf("GIF: %v", err)
+	}
+	if _, err := Strip(jpg[:40]); err != ErrMalformed {
+		t.Fatalf("truncated JPEG: %v", err)
+	}
+}
+
+// cameraTIFF is an EXIF block with orientation o, little-endian as many cameras write it.
+func cameraTIFF(o byte) []byte {
+	return []byte{'I', 'I', 42, 0, 8, 0, 0, 0, 1, 0, 0x12, 0x01, 3, 0, 1, 0, 0, 0, o, 0, 0, 0, 0, 0, 0, 0}
+}
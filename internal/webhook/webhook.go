@@ -0,0 +1,275 @@
+// Package webhook delivers events to HTTP endpoints. Each delivery is a JSON POST signed
+// with the endpoint's secret; failed ones are retried with exponential backoff, and every
+// attempt is kept in a short in-memory log.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Headers sent with every delivery.
+const (
+	SignatureHeader = "X-Filegoblin-Signature"
+	EventHeader     = "X-Filegoblin-Event"    // the event's type
+	DeliveryHeader  = "X-Filegoblin-Delivery" // the event's ID, the same for every attempt
+)
+
+var (
+	ErrUnsigned     = errors.New("webhook: delivery is not signed")
+	ErrBadSignature = errors.New("webhook: signature doesn't match")
+	ErrStale        = errors.New("webhook: signature is too old")
+)
+
+const (
+	keep       = 200  // deliveries kept for Recent
+	maxPending = 1000 // events waiting to be delivered or retried, over all endpoints
+	maxBackoff = time.Hour
+)
+
+// Version is the schema version of events, sent in each one so consumers can tell which
+// layout they are reading. Adding fields leaves it alone; renaming or removing one bumps it.
+const Version = 1
+
+// Event is what an endpoint receives, as the JSON body of the POST.
+type Event struct {
+	ID      string    `json:"id"` // receivers can use it to drop a retry they already handled
+	Version int       `json:"version"`
+	Type    string    `json:"type"`
+	Time    time.Time `json:"time"`
+	Data    any       `json:"data"`
+}
+
+// NewEvent stamps an event of type typ with a fresh ID and the current time.
+func NewEvent(typ string, data any) Event {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return Event{ID: "evt_" + hex.EncodeToString(b), Version: Version, Type: typ, Time: time.Now().UTC(), Data: data}
+}
+
+// Target is an endpoint. Deliveries to it are signed when Secret is set.
+type Target struct {
+	URL    string
+	Secret string
+}
+
+// Policy says how hard to try.
+type Policy struct {
+	Attempts int           // the first one included
+	Backoff  time.Duration // wait before the first retry; doubles for each one after
+	Timeout  time.Duration // per attempt
+}
+
+// Delivery records one attempt to deliver an event.
+type Delivery struct {
+	Event   string     `json:"event"` // the event's ID
+	Type    string     `json:"type"`
+	URL     string     `json:"url"`
+	Attempt int        `json:"attempt"` // 1 for the first
+	Time    time.Time  `json:"time"`
+	Millis  int64      `json:"ms"`               // how long the attempt took
+	Status  int        `json:"status,omitempty"` // the endpoint's HTTP status; 0 when it didn't answer
+	Error   string     `json:"error,omitempty"`
+	RetryAt *time.Time `json:"retry_at,omitempty"` // when the next attempt is due, if there is one
+}
+
+// OK reports whether the endpoint accepted the event.
+func (d Delivery) OK() bool { return d.Status >= 200 && d.Status < 300 }
+
+// retryable reports whether a failed attempt is worth repeating: the endpoint didn't answer,
+// failed itself, or asked to be tried later. Other answers won't change by trying again.
+func (d Delivery) retryable() bool {
+	return d.Status == 0 || d.Status == http.StatusRequestTimeout || d.Status == http.StatusTooManyRequests || d.Status >= 500
+}
+
+// Dispatcher sends events in the background. It is safe for concurrent use.
+type Dispatcher struct {
+	client  *http.Client
+	giveUp  func(Delivery) // called with the last attempt of an event that wasn't delivered
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	pending atomic.Int64
+	mu      sync.Mutex
+	recent  []Delivery // oldest first
+}
+
+// New returns a Dispatcher. giveUp, if not nil, is told about every event that couldn't be
+// delivered, with its last attempt.
+func New(giveUp func(Delivery)) *Dispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Dispatcher{
+		client: &http.Client{
+			// a redirect is the endpoint's answer; following it could post the event anywhere
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		giveUp: giveUp,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Send delivers ev to t in the background, retrying as p says. It returns false, dropping the
+// event, when too many deliveries are already waiting: an endpoint that has been down for a
+// while shouldn't pile up work without end.
+func (d *Dispatcher) Send(t Target, p Policy, ev Event) bool {
+	if d.pending.Add(1) > maxPending {
+		d.pending.Add(-1)
+		return false
+	}
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		defer d.pending.Add(-1)
+		wait := p.Backoff
+		for attempt := 1; ; attempt++ {
+			del := d.attempt(t, p.Timeout, ev, attempt)
+			if del.OK() || !del.retryable() || attempt >= p.Attempts {
+				d.record(del)
+				if !del.OK() && d.giveUp != nil {
+					d.giveUp(del)
+				}
+				return
+			}
+			at := del.Time.Add(wait)
+			del.RetryAt = &at
+			d.record(del)
+			select {
+			case <-time.After(wait):
+			case <-d.ctx.Done():
+				return
+			}
+			wait = min(2*wait, maxBackoff)
+		}
+	}()
+	return true
+}
+
+// Deliver makes a single attempt to deliver ev to t and waits for its outcome.
+func (d *Dispatcher) Deliver(t Target, timeout time.Duration, ev Event) Delivery {
+	del := d.attempt(t, timeout, ev, 1)
+	d.record(del)
+	return del
+}
+
+func (d *Dispatcher) attempt(t Target, timeout time.Duration, ev Event, n int) (del Delivery) {
+	del = Delivery{Event: ev.ID, Type: ev.Type, URL: t.URL, Attempt: n, Time: time.Now().UTC()}
+	defer func() { del.Millis = time.Since(del.Time).Milliseconds() }()
+	body, err := json.Marshal(ev)
+	if err != nil {
+		del.Error = err.Error()
+		return del
+	}
+	// not d.ctx: an attempt under way when the server stops is allowed to finish
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.URL, bytes.NewReader(body))
+	if err != nil {
+		del.Error = err.Error()
+		return del
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "filegoblin-webhook")
+	req.Header.Set(EventHeader, ev.Type)
+	req.Header.Set(DeliveryHeader, ev.ID)
+	if t.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(t.Secret, time.Now(), body))
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		del.Error = err.Error()
+		return del
+	}
+	// read a little of the answer so the connection can be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	del.Status = resp.StatusCode
+	if !del.OK() {
+		del.Error = resp.Status
+	}
+	return del
+}
+
+func (d *Dispatcher) record(del Delivery) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.recent) == keep {
+		d.recent = append(d.recent[:0], d.recent[1:]...)
+	}
+	d.recent = append(d.recent, del)
+}
+
+// Recent returns the latest delivery attempts, newest first.
+func (d *Dispatcher) Recent() []Delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := make([]Delivery, len(d.recent))
+	for i, del := range d.recent {
+		out[len(out)-1-i] = del
+	}
+	return out
+}
+
+// Close abandons the retries still waiting and waits for the attempts in flight to finish.
+func (d *Dispatcher) Close() {
+	d.cancel()
+	d.wg.Wait()
+}
+
+// Sign returns the signature header for body sent at t: "t=<unix seconds>,v1=<hex>", where
+// the hex is the HMAC-SHA256 of "<unix seconds>.<body>" keyed with secret. Signing the time
+// too lets receivers refuse an old delivery being replayed.
+func Sign(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + ts + ",v1=" + mac(secret, ts, body)
+}
+
+func mac(secret, ts string, body []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(h, "%s.", ts)
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Verify checks a signature header made by Sign, for receivers written in Go. Deliveries
+// signed more than tolerance before now are refused as stale.
+func Verify(secret, header string, body []byte, now time.Time, tolerance time.Duration) error {
+	var ts, sig string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			sig = v
+		}
+	}
+	if ts == "" || sig == "" {
+		return ErrUnsigned
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return ErrBadSignature
+	}
+	if !hmac.Equal([]byte(sig), []byte(mac(secret, ts, body))) {
+		return ErrBadSignature
+	}
+	if d := now.Sub(time.Unix(unix, 0)); d > tolerance || d < -tolerance {
+		return ErrStale
+	}
+	return nil
+}
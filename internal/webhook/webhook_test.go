@@ -0,0 +1,77 @@
+package webhook
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSend(t *testing.T) {
+	var calls atomic.Int32
+	delivered := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := Verify("s3cret", r.Header.Get(SignatureHeader), body, time.Now(), time.Minute); err != nil {
+			t.Errorf("delivery %d: %v", calls.Load(), err)
+		}
+		if r.URL.Path == "/gone" {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		close(delivered)
+	}))
+	defer srv.Close()
+
+	given := make(chan Delivery, 1)
+	d := New(func(del Delivery) { given <- del })
+	policy := Policy{Attempts: 5, Backoff: time.Millisecond, Timeout: time.Second}
+	ev := NewEvent("upload", map[string]string{"id": "abc"})
+	d.Send(Target{URL: srv.URL, Secret: "s3cret"}, policy, ev)
+	d.Send(Target{URL: srv.URL + "/gone", Secret: "s3cret"}, policy, NewEvent("delete", nil))
+	del := <-given
+	if del.Status != http.StatusGone || del.Attempt != 1 {
+		t.Fatalf("gave up on %+v, want the 410 without retrying", del)
+	}
+	<-delivered
+	d.Close()
+	if n := calls.Load(); n != 3 {
+		t.Fatalf("%d attempts, want 3: two failures then success", n)
+	}
+	var tries []Delivery
+	for _, del := range d.Recent() {
+		if del.Event == ev.ID {
+			tries = append(tries, del)
+		}
+	}
+	if len(tries) != 3 || !tries[0].OK() || tries[0].Attempt != 3 || tries[2].RetryAt == nil || tries[0].RetryAt != nil {
+		t.Fatalf("delivery log, newest first: %+v", tries)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"id":"evt_1"}`)
+	sig := Sign("k", now, body)
+	if err := Verify("k", sig, body, now.Add(30*time.Second), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify("other", sig, body, now, time.Minute); err != ErrBadSignature {
+		t.Fatalf("wrong secret: %v", err)
+	}
+	if err := Verify("k", sig, []byte(`{"id":"evt_2"}`), now, time.Minute); err != ErrBadSignature {
+		t.Fatalf("changed body: %v", err)
+	}
+	if err := Verify("k", sig, body, now.Add(time.Hour), time.Minute); err != ErrStale {
+		t.Fatalf("replayed an hour later: %v", err)
+	}
+	if err := Verify("k", "", body, now, time.Minute); err != ErrUnsigned {
+		t.Fatalf("no header: %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+// filegoblin admin dashboard: everything here comes from the /admin API.
+(function () {
+  "use strict";
+
+  const keyInput = document.getElementById("key");
+  const status = document.getElementById("status");
+  const dashboard = document.getElementById("dashboard");
+  const keysBox = document.getElementById("keys");
+  const newSecret = document.getElementById("new-secret");
+  let keysUser = "";
+  let timer = 0;
+
+  // the server may be mounted under a path, like /files; its own paths start with root
+  const root = document.documentElement.dataset.root || "";
+
+  keyInput.value = sessionStorage.getItem("filegoblin.adminkey") || "";
+
+  function el(tag, text) {
+    const e = document.createElement(tag);
+    if (text !== undefined) e.textContent = text;
+    return e;
+  }
+
+  function formatSize(n) {
+    const units = ["B", "KB", "MB", "GB", "TB"];
+    let i = 0;
+    while (n >= 1000 && i < units.length - 1) { n /= 1000; i++; }
+    return (i === 0 ? n : n.toFixed(1)) + " " + units[i];
+  }
+
+  // parseSize reads sizes the way the server's config does: KB/MB/GB/TB are powers of 1000,
+  // KiB/MiB/GiB/TiB powers of 1024.
+  function parseSize(s) {
+    const m = /^\s*([\d.]+)\s*([KMGT]i?B|B)?\s*$/i.exec(s);
+    if (!m) throw new Error("invalid size " + JSON.stringify(s));
+    const unit = (m[2] || "B").toUpperCase();
+    const exp = { B: 0, KB: 1, MB: 2, GB: 3, TB: 4, KIB: 1, MIB: 2, GIB: 3, TIB: 4 }[unit];
+    return Math.round(parseFloat(m[1]) * Math.pow(unit.includes("I") ? 1024 : 1000, exp));
+  }
+
+  function when(t) {
+    return t ? new Date(t).toLocaleString() : "";
+  }
+
+  async function api(method, path, body) {
+    const headers = { "Authorization": "Bearer " + keyInput.value };
+    if (body) headers["Content-Type"] = "application/json";
+    const resp = await fetch(root + path, { method: method, headers: headers, body: body ? JSON.stringify(body) : undefined });
+    if (resp.status === 204) return null;
+    const data = await resp.json().catch(function () { return {}; });
+    if (!resp.ok) throw new Error(data.error || resp.statusText);
+    return data;
+  }
+
+  function fill(tbody, rows) {
+    tbody.replaceChildren();
+    for (const cells of rows) {
+      const tr = el("tr");
+      for (const c of cells) {
+        const td = el("td");
+        td.append(c);
+        tr.append(td);
+      }
+      tbody.append(tr);
+    }
+  }
+
+  function button(label, fn) {
+    const b = el("button", label);
+    b.type = "button";
+    b.addEventListener("click", function () {
+      fn().catch(function (err) { status.textContent = err.message; });
+    });
+    return b;
+  }
+
+  async function loadStats() {
+    const st = await api("GET", "/admin/stats");
+    const overview = document.getElementById("overview");
+    overview.replaceChildren();
+    for (const [k, v] of [["Files", st.files], ["Stored", formatSize(st.bytes)], ["Users", st.users], ["Open abuse reports", st.open_reports]]) {
+      overview.append(el("dt", k), el("dd", String(v)));
+    }
+    fill(document.querySelector("#consumers tbody"), st.top_consumers.map(function (c) {
+      return [c.owner || "anonymous", String(c.files), formatSize(c.bytes)];
+    }));
+    fill(document.querySelector("#transfers tbody"), st.transfers.map(function (t) {
+      const p = el("progress");
+      p.max = t.size > 0 ? t.size : 1;
+      p.value = t.size > 0 ? t.bytes : 0;
+      const progress = el("span");
+      progress.append(p, " " + formatSize(t.bytes));
+      return [t.kind, t.file, t.owner || "", t.remote, progress, when(t.started_at)];
+    }));
+    const lc = st.lifecycle;
+    document.getElementById("lifecycle").textContent = lc.last_sweep
+      ? "Last sweep " + when(lc.last_sweep) + " removed " + lc.last_expired + " files; " + lc.total_expired + " since the server started."
+      : "No sweep has run since the server started.";
+    const errors = document.getElementById("errors");
+    errors.replaceChildren();
+    for (const e of st.recent_errors) errors.append(el("li", when(e.time) + "  " + e.message));
+    if (!st.recent_errors.length) errors.append(el("li", "none"));
+  }
+
+  async function loadUsers() {
+    const users = await api("GET", "/admin/users");
+    fill(document.querySelector("#users tbody"), users.map(function (u) {
+      const actions = el("span");
+      actions.append(
+        button("Keys", function () { return loadKeys(u.name); }),
+        " ",
+        button("Set quota", async function () {
+          const q = prompt("New quota for " + u.name + " (empty for unlimited)", "");
+          if (q === null) return;
+          await api("PUT", "/admin/users/" + encodeURIComponent(u.name) + "/quota", { quota: q.trim() ? parseSize(q) : 0 });
+          await loadUsers();
+        }),
+        " ",
+        button("Remove", async function () {
+          if (!confirm("Remove user " + u.name + " and revoke their keys?")) return;
+          await api("DELETE", "/admin/users/" + encodeURIComponent(u.name));
+          if (keysUser === u.name) keysBox.hidden = true;
+          await loadUsers();
+        }),
+      );
+      return [u.name, u.quota ? formatSize(u.quota) : "unlimited", formatSize(u.used), actions];
+    }));
+  }
+
+  async function loadKeys(user) {
+    keysUser = user;
+    newSecret.hidden = true;
+    const keys = await api("GET", "/admin/users/" + encodeURIComponent(user) + "/keys");
+    document.getElementById("keys-user").textContent = user;
+    fill(keysBox.querySelector("tbody"), keys.map(function (k) {
+      const revoke = k.revoked_at ? "" : button("Revoke", async function () {
+        if (!confirm("Revoke key " + k.id + "?")) return;
+        await api("DELETE", "/admin/keys/" + encodeURIComponent(k.id));
+        await loadKeys(user);
+      });
+      return [k.id, k.label || "", when(k.created_at), when(k.revoked_at), revoke];
+    }));
+    keysBox.hidden = false;
+  }
+
+  async function refresh() {
+    try {
+      await loadStats();
+      status.textContent = "";
+      dashboard.hidden = false;
+    } catch (err) {
+      status.textContent = "Could not load the dashboard: " + err.message;
+      dashboard.hidden = true;
+      clearInterval(timer);
+    }
+  }
+
+  document.getElementById("login").addEventListener("submit", async function (ev) {
+    ev.preventDefault();
+    // session storage: an admin key shouldn't outlive the tab
+    sessionStorage.setItem("filegoblin.adminkey", keyInput.value);
+    clearInterval(timer);
+    await refresh();
+    if (dashboard.hidden) return;
+    loadUsers().catch(function (err) { status.textContent = err.message; });
+    timer = setInterval(refresh, 5000);
+  });
+
+  document.getElementById("add-user").addEventListener("submit", async function (ev) {
+    ev.preventDefault();
+    try {
+      const q = document.getElementById("new-quota").value;
+      await api("POST", "/admin/users", { name: document.getElementById("new-user").value, quota: q.trim() ? parseSize(q) : 0 });
+      ev.target.reset();
+      await loadUsers();
+    } catch (err) {
+      status.textContent = err.message;
+    }
+  });
+
+  document.getElementById("add-key").addEventListener("submit", async function (ev) {
+    ev.preventDefault();
+    try {
+      const k = await api("POST", "/admin/users/" + encodeURIComponent(keysUser) + "/keys", { label: document.getElementById("key-label").value });
+      ev.target.reset();
+      await loadKeys(keysUser);
+      newSecret.textContent = "New key (shown only once): " + k.secret;
+      newSecret.hidden = false;
+    } catch (err) {
+      status.textContent = err.message;
+    }
+  });
+
+  if (keyInput.value) document.getElementById("login").requestSubmit();
+})();
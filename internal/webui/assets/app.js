@@ -0,0 +1,331 @@
+// filegoblin web UI: uploads and manages files through the same HTTP API the CLI uses.
+(function () {
+  "use strict";
+
+  const keyInput = document.getElementById("key");
+  const fileInput = document.getElementById("file");
+  const drop = document.getElementById("drop");
+  const expiresInput = document.getElementById("expires");
+  const passwordInput = document.getElementById("password");
+  const status = document.getElementById("status");
+  const uploads = document.getElementById("uploads");
+  const challengeBox = document.getElementById("challenge");
+  const filter = document.getElementById("filter");
+  const browseStatus = document.getElementById("browse-status");
+  const table = document.getElementById("files");
+
+  // the server may be mounted under a path, like /files; its own paths start with root
+  const root = document.documentElement.dataset.root || "";
+
+  // t translates a message with the catalog the page was rendered with, filling in {0}, {1}...
+  const catalog = document.getElementById("messages");
+  const messages = catalog ? JSON.parse(catalog.textContent) : {};
+  function t(msg) {
+    const args = Array.prototype.slice.call(arguments, 1);
+    return (messages[msg] || msg).replace(/\{(\d)\}/g, function (_, i) { return args[i]; });
+  }
+
+  // remember the key in this browser only, so it doesn't have to be pasted every time
+  keyInput.value = localStorage.getItem("filegoblin.key") || "";
+
+  // Servers may ask uploads without a key to pass a CAPTCHA or solve a proof-of-work puzzle.
+  // The CAPTCHA widget is shown up front; puzzles are single-use and fetched at upload time.
+  const widgetScripts = {
+    hcaptcha: "https://js.hcaptcha.com/1/api.js?render=explicit&onload=filegoblinCaptcha",
+    turnstile: "https://challenges.cloudflare.com/turnstile/v0/api.js?render=explicit&onload=filegoblinCaptcha",
+  };
+  let provider = "";
+  let captchaToken = "";
+  fetch(root + "/api/challenge").then((r) => r.json()).then(function (ch) {
+    provider = ch.provider || "";
+    if (!widgetScripts[provider]) return;
+    window.filegoblinCaptcha = function () {
+      window[provider === "hcaptcha" ? "hcaptcha" : "turnstile"].render(challengeBox, {
+        sitekey: ch.site_key,
+        callback: function (token) { captchaToken = token; },
+        "expired-callback": function () { captchaToken = ""; },
+      });
+    };
+    const s = document.createElement("script");
+    s.src = widgetScripts[provider];
+    s.async = true;
+    document.head.appendChild(s);
+  }).catch(function () {});
+
+  function resetCaptcha() {
+    captchaToken = "";
+    const w = window[provider === "hcaptcha" ? "hcaptcha" : "turnstile"];
+    if (w && w.reset) w.reset();
+  }
+
+  // solvePuzzle finds a nonce such that SHA-256("<puzzle>:<nonce>") starts with difficulty
+  // zero bits, the same search the CLI does.
+  async function solvePuzzle(puzzle, difficulty) {
+    const enc = new TextEncoder();
+    for (let n = 0; ; n++) {
+      const sum = new Uint8Array(await crypto.subtle.digest("SHA-256", enc.encode(puzzle + ":" + n)));
+      let zeros = 0;
+      for (const b of sum) {
+        if (b === 0) { zeros += 8; continue; }
+        zeros += Math.clz32(b) - 24;
+        break;
+      }
+      if (zeros >= difficulty) return puzzle + ":" + n;
+    }
+  }
+
+  async function challengeResponse() {
+    if (provider === "pow") {
+      status.textContent = t("Solving the anti-abuse puzzle…");
+      const ch = await (await fetch(root + "/api/challenge")).json();
+      return solvePuzzle(ch.puzzle, ch.difficulty);
+    }
+    if (provider && !captchaToken) throw new Error(t("please complete the CAPTCHA first"));
+    return captchaToken;
+  }
+
+  function authHeaders() {
+    return keyInput.value ? { "Authorization": "Bearer " + keyInput.value } : {};
+  }
+
+  function formatSize(n) {
+    const units = ["B", "KB", "MB", "GB", "TB"];
+    let i = 0;
+    while (n >= 1000 && i < units.length - 1) { n /= 1000; i++; }
+    return (i === 0 ? n : n.toFixed(1)) + " " + units[i];
+  }
+
+  function el(tag, text) {
+    const e = document.createElement(tag);
+    if (text !== undefined) e.textContent = text;
+    return e;
+  }
+
+  // upload sends one file with XMLHttpRequest, which unlike fetch reports upload progress.
+  async function upload(file) {
+    const li = el("li");
+    const name = el("span", file.name);
+    const bar = el("progress");
+    bar.max = file.size || 1;
+    bar.value = 0;
+    const note = el("span");
+    li.append(name, bar, note);
+    uploads.prepend(li);
+
+    const headers = authHeaders();
+    try {
+      if (!keyInput.value && provider) headers["X-Challenge-Response"] = await challengeResponse();
+    } catch (err) {
+      note.textContent = err.message;
+      note.className = "error";
+      return;
+    }
+    if (passwordInput.value) headers["X-File-Password"] = passwordInput.value;
+    let url = root + "/api/files?name=" + encodeURIComponent(file.name);
+    if (expiresInput.value) url += "&expires=" + encodeURIComponent(expiresInput.value);
+
+    await new Promise(function (resolve) {
+      const xhr = new XMLHttpRequest();
+      xhr.open("POST", url);
+      for (const h in headers) xhr.setRequestHeader(h, headers[h]);
+      xhr.upload.onprogress = function (ev) { if (ev.lengthComputable) bar.value = ev.loaded; };
+      xhr.onload = function () {
+        let body = {};
+        try { body = JSON.parse(xhr.responseText); } catch (e) { /* not JSON */ }
+        if (xhr.status >= 200 && xhr.status < 300) {
+          bar.value = bar.max;
+          const a = el("a", body.name);
+          a.href = body.url;
+          name.replaceWith(a);
+          note.textContent = formatSize(body.size);
+        } else {
+          note.textContent = body.error || xhr.statusText || t("upload failed");
+          note.className = "error";
+        }
+        resolve();
+      };
+      xhr.onerror = function () {
+        note.textContent = t("network error");
+        note.className = "error";
+        resolve();
+      };
+      xhr.send(file);
+    });
+    if (widgetScripts[provider]) resetCaptcha(); // tokens are single-use too
+  }
+
+  async function uploadAll(files) {
+    if (!files.length) return;
+    localStorage.setItem("filegoblin.key", keyInput.value);
+    for (let i = 0; i < files.length; i++) {
+      status.textContent = t("Uploading {0} of {1}…", i + 1, files.length);
+      await upload(files[i]);
+    }
+    status.textContent = "";
+    loadFiles();
+  }
+
+  // uploads start as soon as files are picked; there's nothing to submit
+  document.getElementById("upload").addEventListener("submit", function (ev) { ev.preventDefault(); });
+  fileInput.addEventListener("change", function () {
+    const files = Array.from(fileInput.files);
+    fileInput.value = "";
+    uploadAll(files);
+  });
+  drop.addEventListener("dragover", function (ev) {
+    ev.preventDefault();
+    drop.classList.add("over");
+  });
+  drop.addEventListener("dragleave", function () { drop.classList.remove("over"); });
+  drop.addEventListener("drop", function (ev) {
+    ev.preventDefault();
+    drop.classList.remove("over");
+    uploadAll(Array.from(ev.dataTransfer.files));
+  });
+
+  // The file browser lists what the API key may see: its own files, or everything on a
+  // server without keys.
+  let files = [];
+
+  async function loadFiles() {
+    try {
+      const resp = await fetch(root + "/api/files", { headers: authHeaders() });
+      if (resp.status === 401) {
+        files = [];
+        browseStatus.textContent = t("Enter your API key to see your files.");
+        render();
+        return;
+      }
+      const body = await resp.json();
+      if (!resp.ok) throw new Error(body.error || resp.statusText);
+      files = body.sort(function (a, b) { return b.created_at.localeCompare(a.created_at); });
+      browseStatus.textContent = files.length ? "" : t("No files yet.");
+      render();
+    } catch (err) {
+      browseStatus.textContent = t("Could not list files: {0}", err.message);
+    }
+  }
+
+  // previewable leaves out files an <img> can't load without prompting or that mustn't be
+  // shown: encrypted, password protected, taken down or quarantined ones.
+  function previewable(f) {
+    return (f.content_type.startsWith("image/") || f.poster_url) && !f.encrypted && !f.password_protected &&
+      !f.takedown && !(f.scan && f.scan.verdict === "infected");
+  }
+
+  function inlineURL(url) {
+    return url + (url.includes("?") ? "&" : "?") + "inline=1";
+  }
+
+  // previewURL prefers the server's thumbnail, which is far smaller than the image itself;
+  // videos show their poster frame.
+  function previewURL(f) {
+    if (f.poster_url) return f.poster_url;
+    if (!f.thumbnail_url) return inlineURL(f.url);
+    return f.thumbnail_url + (f.thumbnail_url.includes("?") ? "&" : "?") + "w=128";
+  }
+
+  async function api(method, id, body) {
+    const headers = authHeaders();
+    if (body) headers["Content-Type"] = "application/json";
+    const resp = await fetch(root + "/api/files/" + encodeURIComponent(id), {
+      method: method,
+      headers: headers,
+      body: body ? JSON.stringify(body) : undefined,
+    });
+    if (!resp.ok) {
+      const e = await resp.json().catch(function () { return {}; });
+      throw new Error(e.error || resp.statusText);
+    }
+  }
+
+  function expirySelect(f) {
+    const sel = el("select");
+    const current = el("option", f.expires_at ? new Date(f.expires_at).toLocaleString() : t("never"));
+    current.value = "";
+    sel.append(current);
+    for (const opt of expiresInput.options) {
+      const o = el("option", opt.textContent);
+      o.value = opt.value || "never";
+      sel.append(o);
+    }
+    sel.addEventListener("change", async function () {
+      if (!sel.value) return;
+      try {
+        await api("PATCH", f.id, { expires: sel.value });
+      } catch (err) {
+        browseStatus.textContent = t("Could not change expiry: {0}", err.message);
+      }
+      loadFiles();
+    });
+    return sel;
+  }
+
+  function row(f) {
+    const tr = el("tr");
+    const preview = el("td");
+    if (previewable(f)) {
+      const img = el("img");
+      img.loading = "lazy";
+      img.alt = "";
+      img.src = previewURL(f);
+      preview.append(img);
+    }
+    const name = el("td");
+    const a = el("a", f.encrypted ? t("(end-to-end encrypted)") : f.name);
+    a.href = f.url;
+    name.append(a);
+    if (f.password_protected) name.append(el("span", " " + t("(password)")));
+    if (f.takedown) name.append(el("span", " " + t("taken down")));
+    if (f.preview_url && !f.takedown) {
+      const view = el("a", t("view"));
+      view.href = f.preview_url;
+      view.target = "_blank";
+      view.rel = "noopener";
+      name.append(" (", view, ")");
+    }
+
+    const expires = el("td");
+    expires.append(expirySelect(f));
+
+    const actions = el("td");
+    const copy = el("button", t("Copy link"));
+    copy.type = "button";
+    copy.addEventListener("click", function () {
+      navigator.clipboard.writeText(f.share_url || f.url).then(function () { copy.textContent = t("Copied"); });
+    });
+    const del = el("button", t("Delete"));
+    del.type = "button";
+    del.addEventListener("click", async function () {
+      if (!confirm(t("Delete {0}?", f.encrypted ? t("this file") : f.name))) return;
+      try {
+        await api("DELETE", f.id);
+      } catch (err) {
+        browseStatus.textContent = t("Could not delete: {0}", err.message);
+      }
+      loadFiles();
+    });
+    actions.append(copy, " ", del);
+
+    tr.append(preview, name, el("td", formatSize(f.size)), el("td", new Date(f.created_at).toLocaleString()), expires, actions);
+    return tr;
+  }
+
+  function render() {
+    const q = filter.value.trim().toLowerCase();
+    const tbody = table.tBodies[0];
+    tbody.replaceChildren();
+    for (const f of files) {
+      if (q && !f.name.toLowerCase().includes(q) && !f.content_type.includes(q)) continue;
+      tbody.append(row(f));
+    }
+    table.hidden = files.length === 0;
+  }
+
+  filter.addEventListener("input", render);
+  keyInput.addEventListener("change", function () {
+    localStorage.setItem("filegoblin.key", keyInput.value);
+    loadFiles();
+  });
+  loadFiles();
+})();
@@ -0,0 +1,104 @@
+// filegoblin decryption page: a WebCrypto implementation of the format in internal/e2e.
+//
+//   header:  "FGE1" | chunk size (uint32 BE) | nonce prefix (7 bytes)
+//   chunks:  AES-256-GCM, nonce = prefix | counter (uint32 BE) | last (1 byte),
+//            additional data = header
+//
+// Content and name keys are derived from the master key with HKDF-SHA256.
+(function () {
+  "use strict";
+
+  const status = document.getElementById("status");
+  const save = document.getElementById("save");
+  const HEADER = 15, TAG = 16;
+
+  // the server may be mounted under a path, like /files; its own paths start with root
+  const root = document.documentElement.dataset.root || "";
+
+  // t translates a message with the catalog the page was rendered with, filling in {0}, {1}...
+  const catalog = document.getElementById("messages");
+  const messages = catalog ? JSON.parse(catalog.textContent) : {};
+  function t(msg) {
+    const args = Array.prototype.slice.call(arguments, 1);
+    return (messages[msg] || msg).replace(/\{(\d)\}/g, function (_, i) { return args[i]; });
+  }
+
+  function b64url(s) {
+    s = s.replace(/-/g, "+").replace(/_/g, "/");
+    while (s.length % 4) s += "=";
+    return Uint8Array.from(atob(s), (c) => c.charCodeAt(0));
+  }
+
+  async function deriveKey(master, purpose) {
+    const base = await crypto.subtle.importKey("raw", master, "HKDF", false, ["deriveKey"]);
+    return crypto.subtle.deriveKey(
+      { name: "HKDF", hash: "SHA-256", salt: new Uint8Array(0), info: new TextEncoder().encode("filegoblin e2e " + purpose) },
+      base, { name: "AES-GCM", length: 256 }, false, ["decrypt"]);
+  }
+
+  async function decryptName(master, enc) {
+    if (!enc.startsWith("fge1.")) return enc;
+    const raw = b64url(enc.slice(5));
+    const key = await deriveKey(master, "name");
+    const plain = await crypto.subtle.decrypt({ name: "AES-GCM", iv: raw.slice(0, 12) }, key, raw.slice(12));
+    return new TextDecoder().decode(plain);
+  }
+
+  async function decryptContent(master, data) {
+    if (data.length < HEADER || new TextDecoder().decode(data.slice(0, 4)) !== "FGE1") {
+      throw new Error(t("not an encrypted filegoblin file"));
+    }
+    const header = data.slice(0, HEADER);
+    const chunk = new DataView(header.buffer).getUint32(4) + TAG;
+    const key = await deriveKey(master, "content");
+    const parts = [];
+    let off = HEADER, counter = 0;
+    for (;;) {
+      const end = Math.min(off + chunk, data.length);
+      const last = end === data.length;
+      const iv = new Uint8Array(12);
+      iv.set(header.slice(8, 15));
+      new DataView(iv.buffer).setUint32(7, counter);
+      iv[11] = last ? 1 : 0;
+      parts.push(await crypto.subtle.decrypt({ name: "AES-GCM", iv: iv, additionalData: header }, key, data.slice(off, end)));
+      if (last) return parts;
+      off = end;
+      counter++;
+      status.textContent = t("Decrypting… {0}%", Math.round((100 * off) / data.length));
+    }
+  }
+
+  async function main() {
+    const id = location.pathname.split("/").pop();
+    const k = new URLSearchParams(location.hash.slice(1)).get("k");
+    if (!k) throw new Error(t("this link has no key; ask the sender for the full link"));
+    if (!window.crypto || !crypto.subtle) throw new Error(t("this browser can't decrypt files (WebCrypto needs HTTPS)"));
+    const master = b64url(k);
+
+    // a signed link's query string is what authorizes the two requests below
+    const meta = await fetch(root + "/api/files/" + encodeURIComponent(id) + location.search);
+    if (meta.status === 403) throw new Error(t("this link has expired"));
+    if (!meta.ok) throw new Error(t("file not found"));
+    const info = await meta.json();
+    const name = await decryptName(master, info.name);
+
+    status.textContent = t("Downloading {0}…", name);
+    // the whole file is held in memory while decrypting; fine for the sizes people share
+    // through a browser, and the CLI streams for anything bigger
+    const resp = await fetch(root + "/f/" + encodeURIComponent(id) + location.search);
+    if (!resp.ok) throw new Error(t("download failed: {0}", resp.statusText));
+    const parts = await decryptContent(master, new Uint8Array(await resp.arrayBuffer()));
+
+    save.href = URL.createObjectURL(new Blob(parts, { type: "application/octet-stream" }));
+    save.download = name;
+    save.textContent = t("Save {0}", name);
+    save.hidden = false;
+    status.textContent = t("Decrypted.");
+    save.click();
+  }
+
+  main().catch(function (err) {
+    // AES-GCM failures surface as an unhelpful OperationError
+    status.textContent = err.name === "OperationError" ? t("Decryption failed: wrong key or damaged file.") : t("Error: {0}", err.message);
+  });
+})();
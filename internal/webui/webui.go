@@ -0,0 +1,261 @@
+package webui
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hey-granth/filegoblin/internal/config"
+	"github.com/hey-granth/filegoblin/internal/i18n"
+)
+
+//go:embed assets
+var embedded embed.FS
+
+// UI serves the web interface: HTML pages rendered as templates, plus static files under
+// /assets/. Asset URLs carry a content hash (?v=...), so browsers may cache them forever and
+// still pick up a new version the moment the file changes.
+type UI struct {
+	files fs.FS
+	live  bool // files come from disk and may change while we run
+	brand config.Brand
+	root  string // path the server is mounted at, "" for the root
+
+	mu     sync.Mutex
+	hashes map[string]string
+	pages  map[string]*template.Template
+}
+
+// New returns the UI with the given branding, backed by the assets compiled into the binary
+// and by dirs, which let operators restyle or replace the UI without rebuilding. A file is
+// taken from the first dir that has it, falling back to the embedded one, so a customization
+// only needs the files it changes. Empty dirs are skipped.
+func New(brand config.Brand, dirs ...string) *UI {
+	sub, _ := fs.Sub(embedded, "assets") // can't fail: the directory is embedded above
+	if brand.Name == "" {
+		brand.Name = "filegoblin"
+	}
+	u := &UI{files: sub, brand: brand, hashes: map[string]string{}, pages: map[string]*template.Template{}}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if dirs[i] != "" {
+			u.files, u.live = overlay{os.DirFS(dirs[i]), u.files}, true
+		}
+	}
+	return u
+}
+
+// overlay serves files from top, falling back to base for anything top doesn't have.
+type overlay struct{ top, base fs.FS }
+
+func (o overlay) Open(name string) (fs.File, error) {
+	f, err := o.top.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.base.Open(name)
+	}
+	return f, err
+}
+
+// hash returns a short content hash of an asset, cached unless files are live on disk.
+func (u *UI) hash(name string) string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if h, ok := u.hashes[name]; ok && !u.live {
+		return h
+	}
+	data, err := u.read(name)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	h := hex.EncodeToString(sum[:6])
+	u.hashes[name] = h
+	return h
+}
+
+// SetRoot makes pages link to assets and the API under root, like "/files", for a server
+// that isn't mounted at the root of its domain.
+func (u *UI) SetRoot(root string) { u.root = root }
+
+// assetURL is the template function that turns "app.js" into "/assets/app.js?v=<hash>".
+func (u *UI) assetURL(name string) string {
+	return u.root + "/assets/" + name + "?v=" + u.hash(name)
+}
+
+func (u *UI) template(name string) (*template.Template, error) {
+	u.mu.Lock()
+	t := u.pages[name]
+	u.mu.Unlock()
+	if t != nil && !u.live {
+		return t, nil
+	}
+	// brand.html holds the header, footer and stylesheet links every page shares
+	t, err := template.New(name).Funcs(template.FuncMap{"asset": u.assetURL}).ParseFS(u.files, name, "brand.html")
+	if err != nil {
+		return nil, err
+	}
+	u.mu.Lock()
+	u.pages[name] = t
+	u.mu.Unlock()
+	return t, nil
+}
+
+// ServeIndex renders the main page.
+func (u *UI) ServeIndex(w http.ResponseWriter, r *http.Request) { u.servePage(w, r, "index.html", nil) }
+
+// ServeAdmin renders the admin dashboard. The page itself is public; everything on it comes
+// from the admin API, which wants an admin key.
+func (u *UI) ServeAdmin(w http.ResponseWriter, r *http.Request) { u.servePage(w, r, "admin.html", nil) }
+
+// ServeDecrypt renders the page that decrypts an end-to-end encrypted file in the browser.
+// It works out the file ID from its own URL and the key from the fragment.
+func (u *UI) ServeDecrypt(w http.ResponseWriter, r *http.Request) {
+	// the page holds a decryption key in its URL; keep it from leaking via Referer
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	u.servePage(w, r, "decrypt.html", nil)
+}
+
+// Preview is what the preview page shows: a text file as Lines, a CSV file as Rows with the
+// header first, or a Markdown file rendered as HTML.
+type Preview struct {
+	Name      string
+	Download  string          // the file's own link
+	Source    string          // for Markdown, the link to its highlighted source
+	Lines     []template.HTML // already escaped and highlighted
+	Rows      [][]string
+	HTML      template.HTML // rendered Markdown
+	Truncated bool          // the file was cut short at the preview size limit
+}
+
+// ServePreview renders the preview page for a text, CSV or Markdown file. The caller sets the
+// sandboxing headers; the page itself has no scripts.
+func (u *UI) ServePreview(w http.ResponseWriter, r *http.Request, p Preview) {
+	u.servePage(w, r, "preview.html", p)
+}
+
+// Share is what the share page shows about a file.
+type Share struct {
+	Name      string
+	Size      string // human-readable
+	Type      string
+	CreatedAt time.Time
+	ExpiresAt *time.Time
+	Protected bool   // downloads ask for a password
+	Download  string // the file's own link
+	Preview   string // the preview page, when there is one
+	Image     string // a thumbnail or video poster, when there is one
+}
+
+// ServeShare renders the landing page of a share link.
+func (u *UI) ServeShare(w http.ResponseWriter, r *http.Request, s Share) {
+	u.servePage(w, r, "share.html", s)
+}
+
+// Locale returns the language to answer r in: the best one its Accept-Language asks for,
+// or the brand's own.
+func (u *UI) Locale(r *http.Request) *i18n.Locale {
+	return i18n.Negotiate(r.Header.Get("Accept-Language"), u.brand.Locale)
+}
+
+// page is what every template gets: the branding, the language, plus the page's own data as
+// .Page. Templates translate with {{$.T "English text"}} and {{$.Tf "format" args...}}.
+type page struct {
+	Brand config.Brand
+	Logo  template.URL
+	Lang  string
+	Root  string // prefix of the server's own paths, for scripts
+	Page  any
+	loc   *i18n.Locale
+}
+
+func (p page) T(msg string) string                  { return p.loc.T(msg) }
+func (p page) Tf(format string, args ...any) string { return p.loc.Tf(format, args...) }
+
+// Messages is the catalog of the page's language, for its script to translate with.
+func (p page) Messages() map[string]string { return p.loc.Messages() }
+
+// servePage renders an HTML template in the language r asks for. Pages are never cached,
+// since they're what points at the current asset versions.
+func (u *UI) servePage(w http.ResponseWriter, r *http.Request, name string, data any) {
+	loc := u.Locale(r)
+	p := page{Brand: u.brand, Lang: loc.Tag, Root: u.root, Page: data, loc: loc}
+	switch {
+	case strings.HasPrefix(u.brand.Logo, "data:image/"):
+		p.Logo = template.URL(u.brand.Logo) // checked by config validation
+	case u.brand.Logo != "":
+		p.Logo = template.URL(u.assetURL(u.brand.Logo))
+	}
+	t, err := u.template(name)
+	if err != nil {
+		http.Error(w, "web UI unavailable: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, p); err != nil {
+		http.Error(w, "web UI unavailable: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Language", loc.Tag)
+	w.Header().Add("Vary", "Accept-Language")
+	w.Header().Set("Cache-Control", "no-cache")
+	_, _ = w.Write(buf.Bytes())
+}
+
+// ServeAsset serves one file from /assets/{name...}.
+func (u *UI) ServeAsset(w http.ResponseWriter, r *http.Request) {
+	name := path.Clean(r.PathValue("name"))
+	if path.Ext(name) == ".html" || strings.HasPrefix(name, "..") || strings.HasPrefix(name, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	data, err := u.read(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if ct := mime.TypeByExtension(path.Ext(name)); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	if v := r.URL.Query().Get("v"); v != "" && v == u.hash(name) {
+		// the URL changes whenever the content does, so this exact URL never goes stale
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(data))
+}
+
+// read returns an asset's content. brand.css is made from the branding unless the assets
+// directory has its own.
+func (u *UI) read(name string) ([]byte, error) {
+	data, err := fs.ReadFile(u.files, name)
+	if errors.Is(err, fs.ErrNotExist) && name == "brand.css" {
+		return u.brandCSS(), nil
+	}
+	return data, err
+}
+
+// brandCSS sets the custom properties style.css takes its colours from.
+func (u *UI) brandCSS() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(":root {\n")
+	for _, v := range []struct{ name, value string }{{"accent", u.brand.Accent}, {"background", u.brand.Background}, {"text", u.brand.Text}} {
+		if v.value != "" {
+			fmt.Fprintf(&buf, "  --%s: %s;\n", v.name, v.value) // colours are validated as plain CSS values
+		}
+	}
+	buf.WriteString("}\n")
+	return buf.Bytes()
+}
@@ -0,0 +1,62 @@
+package webui
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/hey-granth/filegoblin/internal/config"
+)
+
+func serve(u *UI) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", u.ServeIndex)
+	mux.HandleFunc("GET /assets/{name...}", u.ServeAsset)
+	return mux
+}
+
+// TestCacheBusting checks that the index links versioned assets and that only the current
+// version is cached for good.
+func TestCacheBusting(t *testing.T) {
+	h := serve(New(config.Brand{}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	m := regexp.MustCompile(`/assets/app\.js\?v=([0-9a-f]+)`).FindStringSubmatch(rec.Body.String())
+	if m == nil {
+		t.Fatalf("index does not link a versioned app.js:\n%s", rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", m[0], nil))
+	if cc := rec.Header().Get("Cache-Control"); !strings.Contains(cc, "immutable") {
+		t.Fatalf("current asset version not cached: %q", cc)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/assets/app.js?v=stale", nil))
+	if cc := rec.Header().Get("Cache-Control"); cc != "no-cache" {
+		t.Fatalf("stale asset version cached: %q", cc)
+	}
+}
+
+// TestAssetsFromDisk checks that an assets directory overrides the embedded files.
+func TestAssetsFromDisk(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte(`custom <script src="{{asset "x.js"}}"></script>`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "x.js"), []byte("1"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h := serve(New(config.Brand{}, dir))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(rec.Body.String(), "custom") || !strings.Contains(rec.Body.String(), "/assets/x.js?v=") {
+		t.Fatalf("custom index not served: %s", rec.Body)
+	}
+}
@@ -0,0 +1,7 @@
+// Package winsvc implements the bits of the Windows service control manager (SCM) protocol
+// filegoblin needs: running as a service, with the SCM's stop and parameter-change requests
+// mapped to cancelling a context and reloading, and installing or removing the service. It
+// calls advapi32.dll directly, the way package systemd speaks sd_notify without libsystemd.
+//
+// Everything but this comment is only built on Windows.
+package winsvc
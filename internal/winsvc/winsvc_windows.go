@@ -0,0 +1,290 @@
+//go:build windows
+
+package winsvc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	advapi32 = syscall.NewLazyDLL("advapi32.dll")
+
+	procStartServiceCtrlDispatcher = advapi32.NewProc("StartServiceCtrlDispatcherW")
+	procRegisterServiceCtrlHandler = advapi32.NewProc("RegisterServiceCtrlHandlerExW")
+	procSetServiceStatus           = advapi32.NewProc("SetServiceStatus")
+	procOpenSCManager              = advapi32.NewProc("OpenSCManagerW")
+	procCreateService              = advapi32.NewProc("CreateServiceW")
+	procOpenService                = advapi32.NewProc("OpenServiceW")
+	procChangeServiceConfig2       = advapi32.NewProc("ChangeServiceConfig2W")
+	procControlService             = advapi32.NewProc("ControlService")
+	procDeleteService              = advapi32.NewProc("DeleteService")
+	procCloseServiceHandle         = advapi32.NewProc("CloseServiceHandle")
+)
+
+// from winsvc.h and winnt.h
+const (
+	serviceWin32OwnProcess = 0x10
+	serviceAutoStart       = 2
+	serviceErrorNormal     = 1
+
+	stateStopped      = 1
+	stateStartPending = 2
+	stateStopPending  = 3
+	stateRunning      = 4
+
+	controlStop        = 1
+	controlInterrogate = 4
+	controlShutdown    = 5
+	controlParamChange = 6
+
+	acceptStop        = 1
+	acceptShutdown    = 4
+	acceptParamChange = 8
+
+	scManagerAllAccess = 0xF003F
+	serviceAllAccess   = 0xF01FF
+
+	configDescription    = 1
+	configFailureActions = 2
+	actionRestart        = 1
+
+	errServiceSpecific       = 1066 // ERROR_SERVICE_SPECIFIC_ERROR
+	errNotStartedBySCM       = 1063 // ERROR_FAILED_SERVICE_CONTROLLER_CONNECT
+	errServiceNotActive      = 1062 // ERROR_SERVICE_NOT_ACTIVE
+	errServiceDoesNotExist   = 1060 // ERROR_SERVICE_DOES_NOT_EXIST
+	errServiceMarkedToDelete = 1072 // ERROR_SERVICE_MARKED_FOR_DELETE
+)
+
+// ErrNotService is returned by Run when the process wasn't started by the SCM, e.g. from a
+// console.
+var ErrNotService = errors.New("winsvc: not started by the service control manager; use 'sc start' or Start-Service")
+
+type serviceStatus struct {
+	ServiceType             uint32
+	CurrentState            uint32
+	ControlsAccepted        uint32
+	Win32ExitCode           uint32
+	ServiceSpecificExitCode uint32
+	CheckPoint              uint32
+	WaitHint                uint32
+}
+
+type tableEntry struct {
+	name *uint16
+	proc uintptr
+}
+
+// the one service this process runs; the SCM's callbacks can't carry Go state
+var svc struct {
+	name   *uint16
+	run    func(context.Context) error
+	reload func()
+
+	mu     sync.Mutex
+	handle uintptr
+	status serviceStatus
+	cancel context.CancelFunc
+	err    error
+}
+
+var (
+	mainCallback    = syscall.NewCallback(serviceMain)
+	handlerCallback = syscall.NewCallback(serviceHandler)
+)
+
+// Run runs the service name: run is called once the SCM has started the service and its
+// context is cancelled when the SCM asks the service to stop, or Windows shuts down. reload
+// is called for a parameter change ("sc control <name> paramchange"). Run returns when run
+// does, with its error, or ErrNotService without calling it.
+func Run(name string, run func(ctx context.Context) error, reload func()) error {
+	svc.name, _ = syscall.UTF16PtrFromString(name)
+	svc.run, svc.reload = run, reload
+	table := []tableEntry{{svc.name, mainCallback}, {}}
+	// blocks until the service has stopped
+	ok, _, err := procStartServiceCtrlDispatcher.Call(uintptr(unsafe.Pointer(&table[0])))
+	if ok == 0 {
+		if errno, _ := err.(syscall.Errno); errno == errNotStartedBySCM {
+			return ErrNotService
+		}
+		return fmt.Errorf("winsvc: start dispatcher: %w", err)
+	}
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	return svc.err
+}
+
+// serviceMain is the ServiceMain the SCM calls on a thread of its own.
+func serviceMain(argc uint32, argv **uint16) uintptr {
+	h, _, err := procRegisterServiceCtrlHandler.Call(uintptr(unsafe.Pointer(svc.name)), handlerCallback, 0)
+	if h == 0 {
+		svc.mu.Lock()
+		svc.err = fmt.Errorf("winsvc: register control handler: %w", err)
+		svc.mu.Unlock()
+		return 0
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	svc.mu.Lock()
+	svc.handle, svc.cancel = h, cancel
+	svc.mu.Unlock()
+	setStatus(stateStartPending, 0, nil)
+	setStatus(stateRunning, acceptStop|acceptShutdown|acceptParamChange, nil)
+
+	runErr := svc.run(ctx)
+	cancel()
+	svc.mu.Lock()
+	svc.err = runErr
+	svc.mu.Unlock()
+	setStatus(stateStopped, 0, runErr)
+	return 0
+}
+
+// serviceHandler is the HandlerEx the SCM sends control requests to.
+func serviceHandler(control, eventType uint32, eventData, userData uintptr) uintptr {
+	switch control {
+	case controlStop, controlShutdown:
+		setStatus(stateStopPending, 0, nil)
+		svc.mu.Lock()
+		cancel := svc.cancel
+		svc.mu.Unlock()
+		if cancel != nil {
+			cancel()
+		}
+	case controlParamChange:
+		if svc.reload != nil {
+			go svc.reload()
+		}
+	case controlInterrogate:
+		svc.mu.Lock()
+		st := svc.status
+		svc.mu.Unlock()
+		setStatus(st.CurrentState, st.ControlsAccepted, nil)
+	}
+	return 0 // NO_ERROR
+}
+
+// setStatus reports the service's state to the SCM; a non-nil err on stopping tells it the
+// service failed, which triggers the restart configured by Install.
+func setStatus(state, accepts uint32, err error) {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	st := serviceStatus{ServiceType: serviceWin32OwnProcess, CurrentState: state, ControlsAccepted: accepts}
+	if state == stateStartPending || state == stateStopPending {
+		svc.status.CheckPoint++
+		st.CheckPoint = svc.status.CheckPoint
+		st.WaitHint = 30000 // ms; in-flight requests get up to 30s on shutdown
+	}
+	if err != nil {
+		st.Win32ExitCode, st.ServiceSpecificExitCode = errServiceSpecific, 1
+	}
+	svc.status = st
+	_, _, _ = procSetServiceStatus.Call(svc.handle, uintptr(unsafe.Pointer(&st)))
+}
+
+type serviceDescription struct {
+	description *uint16
+}
+
+type scAction struct {
+	actionType uint32
+	delay      uint32 // ms
+}
+
+type failureActions struct {
+	resetPeriod uint32 // s
+	rebootMsg   *uint16
+	command     *uint16
+	actions     uint32
+	action      *scAction
+}
+
+// Install registers the service name, started automatically at boot by running this
+// executable with args, and restarted when it fails. It runs as the service's own virtual
+// account, "NT SERVICE\<name>", which has no rights beyond those granted to it.
+func Install(name, displayName, description string, args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmdline := []string{syscall.EscapeArg(exe)}
+	for _, a := range args {
+		cmdline = append(cmdline, syscall.EscapeArg(a))
+	}
+	m, err := openManager()
+	if err != nil {
+		return err
+	}
+	defer closeHandle(m)
+	svcName, display, binary, account := utf16(name), utf16(displayName), utf16(strings.Join(cmdline, " ")), utf16(`NT SERVICE\`+name)
+	h, _, err := procCreateService.Call(m,
+		uintptr(unsafe.Pointer(svcName)), uintptr(unsafe.Pointer(display)),
+		serviceAllAccess, serviceWin32OwnProcess, serviceAutoStart, serviceErrorNormal,
+		uintptr(unsafe.Pointer(binary)), 0, 0, 0, uintptr(unsafe.Pointer(account)), 0)
+	if h == 0 {
+		return fmt.Errorf("winsvc: create service %s: %w", name, err)
+	}
+	defer closeHandle(h)
+
+	desc := serviceDescription{description: utf16(description)}
+	if ok, _, err := procChangeServiceConfig2.Call(h, configDescription, uintptr(unsafe.Pointer(&desc))); ok == 0 {
+		return fmt.Errorf("winsvc: set description: %w", err)
+	}
+	// restart after 5s, 30s, then every minute, forgetting failures after a day
+	actions := []scAction{{actionRestart, 5000}, {actionRestart, 30000}, {actionRestart, 60000}}
+	fa := failureActions{resetPeriod: 86400, actions: uint32(len(actions)), action: &actions[0]}
+	if ok, _, err := procChangeServiceConfig2.Call(h, configFailureActions, uintptr(unsafe.Pointer(&fa))); ok == 0 {
+		return fmt.Errorf("winsvc: set recovery actions: %w", err)
+	}
+	return nil
+}
+
+// Uninstall stops the service name if it is running and removes it. Windows finishes the
+// removal once nothing has the service open any more, like the Services console.
+func Uninstall(name string) error {
+	m, err := openManager()
+	if err != nil {
+		return err
+	}
+	defer closeHandle(m)
+	h, _, err := procOpenService.Call(m, uintptr(unsafe.Pointer(utf16(name))), serviceAllAccess)
+	if h == 0 {
+		if errno, _ := err.(syscall.Errno); errno == errServiceDoesNotExist {
+			return fmt.Errorf("winsvc: no service %s is installed", name)
+		}
+		return fmt.Errorf("winsvc: open service %s: %w", name, err)
+	}
+	defer closeHandle(h)
+	var st serviceStatus
+	if ok, _, err := procControlService.Call(h, controlStop, uintptr(unsafe.Pointer(&st))); ok == 0 {
+		if errno, _ := err.(syscall.Errno); errno != errServiceNotActive {
+			return fmt.Errorf("winsvc: stop service %s: %w", name, err)
+		}
+	}
+	if ok, _, err := procDeleteService.Call(h); ok == 0 {
+		if errno, _ := err.(syscall.Errno); errno != errServiceMarkedToDelete {
+			return fmt.Errorf("winsvc: delete service %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func openManager() (uintptr, error) {
+	m, _, err := procOpenSCManager.Call(0, 0, scManagerAllAccess)
+	if m == 0 {
+		return 0, fmt.Errorf("winsvc: open service control manager (this needs an elevated prompt): %w", err)
+	}
+	return m, nil
+}
+
+func closeHandle(h uintptr) { _, _, _ = procCloseServiceHandle.Call(h) }
+
+func utf16(s string) *uint16 {
+	p, _ := syscall.UTF16PtrFromString(s)
+	return p
+}
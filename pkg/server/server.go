@@ -0,0 +1,191 @@
+// Package server embeds filegoblin in another Go program: the same upload, download and
+// sharing API, web UI and background work as the filegoblin daemon, served by an
+// http.Handler the program mounts wherever it likes.
+//
+//	srv, err := server.New(
+//		server.WithConfig(cfg),
+//		server.WithStorage(myBucket),
+//		server.WithAuthenticator(mySessions),
+//		server.WithMountPoint("/files"),
+//	)
+//	if err != nil {
+//		return err
+//	}
+//	defer srv.Close()
+//	go srv.Run(ctx)
+//	mux.Handle("/files/", srv.Handler())
+//
+// Everything not set by an option comes from the configuration, as it would for the daemon.
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hey-granth/filegoblin/internal/abuse"
+	"github.com/hey-granth/filegoblin/internal/audit"
+	"github.com/hey-granth/filegoblin/internal/config"
+	"github.com/hey-granth/filegoblin/internal/logx"
+	core "github.com/hey-granth/filegoblin/internal/server"
+	"github.com/hey-granth/filegoblin/internal/signing"
+	"github.com/hey-granth/filegoblin/internal/storage"
+)
+
+// Config is the daemon's configuration, documented in the example config file.
+type Config = config.Config
+
+// DefaultConfig returns the settings the daemon starts from before reading a config file.
+func DefaultConfig() *Config { return config.Default() }
+
+// Storage keeps file contents as blobs by key; the file names, owners and links live in the
+// metadata index under the configured data_dir.
+type Storage = storage.Backend
+
+// BlobInfo describes a stored blob.
+type BlobInfo = storage.Info
+
+// ErrNotFound is what a Storage returns for a key with no blob behind it.
+var ErrNotFound = storage.ErrNotFound
+
+// Authenticator identifies who sent a request, in place of filegoblin's API keys: it returns
+// the owner name to record for the request, or false to turn it away. Returning true with an
+// empty owner lets the request through anonymously.
+type Authenticator = core.Authenticator
+
+// Logger is the daemon's logger.
+type Logger = logx.Logger
+
+// NewLogger returns a Logger writing text lines to w.
+func NewLogger(w io.Writer) *Logger { return logx.New(w) }
+
+// NewJSONLogger returns a Logger writing a JSON object per line to w.
+func NewJSONLogger(w io.Writer) *Logger { return logx.NewJSON(w) }
+
+// Level is how important a log message is.
+type Level = logx.Level
+
+const (
+	LevelDebug = logx.LevelDebug
+	LevelInfo  = logx.LevelInfo
+	LevelWarn  = logx.LevelWarn
+	LevelError = logx.LevelError
+)
+
+// Field is a key/value pair logged with a message, like the ID of the file a request is for.
+type Field = logx.Field
+
+// Hook is told of the messages a Logger writes from a level up, redacted, to hand them to an
+// error tracker or pager; see Logger.AddHook.
+type Hook = logx.Hook
+
+// HookFunc lets an ordinary function be a Hook.
+type HookFunc = logx.HookFunc
+
+// Option configures a Server.
+type Option func(*options)
+
+type options struct {
+	cfg    *Config
+	store  Storage
+	authn  Authenticator
+	log    *Logger
+	prefix string
+}
+
+// WithConfig sets the configuration; DefaultConfig is used otherwise. It is validated by New.
+func WithConfig(cfg *Config) Option { return func(o *options) { o.cfg = cfg } }
+
+// WithStorage stores file contents in s instead of under data_dir/blobs.
+func WithStorage(s Storage) Option { return func(o *options) { o.store = s } }
+
+// WithAuthenticator decides who uploads and owns files with a instead of API keys. The
+// admin API still takes the configured admin keys.
+func WithAuthenticator(a Authenticator) Option { return func(o *options) { o.authn = a } }
+
+// WithLogger logs to l instead of standard error.
+func WithLogger(l *Logger) Option { return func(o *options) { o.log = l } }
+
+// WithMountPoint serves everything under path, like "/files", rather than at the root:
+// Handler expects request paths to still carry it, and the links and pages it produces point
+// under it. A public_url in the configuration must then include the path too.
+func WithMountPoint(path string) Option {
+	return func(o *options) { o.prefix = "/" + strings.Trim(path, "/") }
+}
+
+// Server is an embedded filegoblin instance.
+type Server struct {
+	srv   *core.Server
+	trail *audit.Log
+}
+
+// New opens the index, user registry and other state under the configured data_dir,
+// creating it if needed, and returns a Server ready to serve.
+func New(opts ...Option) (*Server, error) {
+	o := options{cfg: DefaultConfig()}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err := o.cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	if o.log == nil {
+		o.log = logx.New(os.Stderr)
+	}
+	dir := o.cfg.DataDir
+	if o.store == nil {
+		store, err := o.cfg.Storage.Open(dir)
+		if err != nil {
+			return nil, err
+		}
+		o.store = store
+	}
+	index, err := o.cfg.Metadata.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	users, err := o.cfg.Metadata.OpenUsers(dir, index)
+	if err != nil {
+		return nil, err
+	}
+	links, err := signing.Open(filepath.Join(dir, "signing.json"))
+	if err != nil {
+		return nil, err
+	}
+	reports, err := abuse.Open(filepath.Join(dir, "reports.json"))
+	if err != nil {
+		return nil, err
+	}
+	trail, err := audit.Open(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		return nil, err
+	}
+	srv := core.New(o.cfg, o.store, index, users, links, reports, trail, o.log)
+	if o.authn != nil {
+		srv.SetAuthenticator(o.authn)
+	}
+	if o.prefix != "" {
+		srv.SetPrefix(o.prefix)
+	}
+	return &Server{srv: srv, trail: trail}, nil
+}
+
+// Handler serves the API and web UI. It answers only paths under the mount point, if set.
+func (s *Server) Handler() http.Handler { return s.srv.Handler() }
+
+// Run sweeps expired files, takes scheduled backups and takes part in a cluster, as
+// configured, until ctx is cancelled. A program serving Handler itself should run it
+// alongside; Serve does so by itself.
+func (s *Server) Run(ctx context.Context) { s.srv.Run(ctx) }
+
+// Serve serves Handler on ln, with TLS if configured, and does Run's work until ctx is
+// cancelled, then lets in-flight requests finish.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error { return s.srv.Serve(ctx, ln) }
+
+// Close closes the audit log. Call it once the server has stopped.
+func (s *Server) Close() error { return s.trail.Close() }
@@ -0,0 +1,145 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hey-granth/filegoblin/pkg/server"
+)
+
+// memStore keeps blobs in memory, to show a Storage can live outside this module.
+type memStore struct {
+	mu    sync.Mutex
+	blobs map[string][]byte
+}
+
+func (m *memStore) Put(_ context.Context, key string, r io.Reader) (int64, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.blobs[key] = data
+	return int64(len(data)), nil
+}
+
+func (m *memStore) Get(_ context.Context, key string) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.blobs[key]
+	if !ok {
+		return nil, server.ErrNotFound
+	}
+	return struct {
+		io.ReadSeeker
+		io.Closer
+	}{bytes.NewReader(data), io.NopCloser(nil)}, nil
+}
+
+func (m *memStore) Stat(_ context.Context, key string) (server.BlobInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.blobs[key]
+	if !ok {
+		return server.BlobInfo{}, server.ErrNotFound
+	}
+	return server.BlobInfo{Key: key, Size: int64(len(data)), ModTime: time.Now()}, nil
+}
+
+func (m *memStore) Delete(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.blobs[key]; !ok {
+		return server.ErrNotFound
+	}
+	delete(m.blobs, key)
+	return nil
+}
+
+func (m *memStore) List(_ context.Context, fn func(server.BlobInfo) error) error {
+	m.mu.Lock()
+	var infos []server.BlobInfo
+	for k, v := range m.blobs {
+		infos = append(infos, server.BlobInfo{Key: k, Size: int64(len(v))})
+	}
+	m.mu.Unlock()
+	for _, i := range infos {
+		if err := fn(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TestEmbedded mounts a server under /files of another mux, with its own storage and users.
+func TestEmbedded(t *testing.T) {
+	cfg := server.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+	store := &memStore{blobs: map[string][]byte{}}
+	srv, err := server.New(
+		server.WithConfig(cfg),
+		server.WithStorage(store),
+		server.WithLogger(server.NewLogger(io.Discard)),
+		server.WithMountPoint("/files/"),
+		server.WithAuthenticator(func(r *http.Request) (string, bool) {
+			u := r.Header.Get("X-User")
+			return u, u != ""
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	mux := http.NewServeMux()
+	mux.Handle("/files/", srv.Handler())
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "host app") })
+	do := func(method, path, user, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://app.example"+path, strings.NewReader(body))
+		if user != "" {
+			req.Header.Set("X-User", user)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do("POST", "/files/api/files?name=a.txt", "", "hello"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("upload without a user: %d %s", rec.Code, rec.Body)
+	}
+	rec := do("POST", "/files/api/files?name=a.txt", "alice", "hello")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("upload: %d %s", rec.Code, rec.Body)
+	}
+	var f struct {
+		ID    string `json:"id"`
+		Owner string `json:"owner"`
+		URL   string `json:"url"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &f); err != nil {
+		t.Fatal(err)
+	}
+	if f.Owner != "alice" || f.URL != "http://app.example/files/f/"+f.ID {
+		t.Fatalf("upload response %+v", f)
+	}
+	if string(store.blobs[f.ID]) != "hello" {
+		t.Fatalf("blob not in the given storage: %q", store.blobs)
+	}
+	if rec := do("GET", "/files/f/"+f.ID, "", ""); rec.Body.String() != "hello" {
+		t.Fatalf("download: %d %s", rec.Code, rec.Body)
+	}
+	if body := do("GET", "/files/", "", "").Body.String(); !strings.Contains(body, `href="/files/assets/style.css?v=`) || !strings.Contains(body, `data-root="/files"`) {
+		t.Fatalf("index page not under the mount point:\n%s", body)
+	}
+	if body := do("GET", "/api/files", "alice", "").Body.String(); body != "host app" {
+		t.Fatalf("the host's own routes answered by filegoblin: %s", body)
+	}
+}